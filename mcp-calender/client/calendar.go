@@ -0,0 +1,228 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Event is a calendar event.
+type Event struct {
+	ID      string `json:"id"`
+	Summary string `json:"summary"`
+	// Description is Markdown; the HTML of a formatted description is in
+	// DescriptionHTML.
+	Description     string       `json:"description"`
+	DescriptionHTML string       `json:"description_html,omitempty"`
+	Start           time.Time    `json:"start"`
+	End             time.Time    `json:"end"`
+	Location        string       `json:"location"`
+	Attachments     []Attachment `json:"attachments,omitempty"`
+	// Weather is the forecast for an outdoor event, set in agendas only.
+	Weather *EventWeather `json:"weather,omitempty"`
+	// Travel is the travel from the previous event, set on events created
+	// with AddTravelBuffer only.
+	Travel *EventTravel `json:"travel,omitempty"`
+}
+
+// Attachment is a Google Drive file attached to an event. Attaching one
+// takes its FileURL or FileID.
+type Attachment struct {
+	FileID   string `json:"file_id,omitempty"`
+	FileURL  string `json:"file_url,omitempty"`
+	Title    string `json:"title,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	IconLink string `json:"icon_link,omitempty"`
+}
+
+// EventTravel is the travel from the previous event at another place to a
+// new event.
+type EventTravel struct {
+	FromEventID  string  `json:"from_event_id,omitempty"`
+	FromLocation string  `json:"from_location,omitempty"`
+	DistanceKM   float64 `json:"distance_km,omitempty"`
+	Minutes      int     `json:"minutes,omitempty"`
+	// Source is "routing" or "estimate".
+	Source     string `json:"source,omitempty"`
+	GapMinutes int    `json:"gap_minutes"`
+	// Buffer is the travel block added before the event, if any.
+	Buffer *Event `json:"buffer,omitempty"`
+	// Warning says why no buffer was added, such as the previous event
+	// ending too late to get there in time.
+	Warning string `json:"warning,omitempty"`
+}
+
+// EventWeather is the forecast for the time window of an event.
+type EventWeather struct {
+	City                string  `json:"city"`
+	TemperatureMin      float64 `json:"temperature_min"`
+	TemperatureMax      float64 `json:"temperature_max"`
+	Description         string  `json:"description"`
+	PrecipitationChance float64 `json:"precipitation_chance"`
+	WindSpeed           float64 `json:"wind_speed"`
+	Units               string  `json:"units"`
+	// Advice is what to bring or wear, such as "Bring an umbrella".
+	Advice string `json:"advice,omitempty"`
+}
+
+// Agenda is the events of a day.
+type Agenda struct {
+	// Date is the day, YYYY-MM-DD.
+	Date   string  `json:"date"`
+	Events []Event `json:"events"`
+}
+
+// Calendar is a calendar of the user's calendar list.
+type Calendar struct {
+	ID      string `json:"id"`
+	Summary string `json:"summary"`
+	// Primary is set on the user's own calendar.
+	Primary bool `json:"primary,omitempty"`
+	// AccessRole is owner, writer, reader or freeBusyReader.
+	AccessRole string `json:"access_role,omitempty"`
+}
+
+// EventsQuery filters events by date, YYYY-MM-DD; empty bounds are open.
+type EventsQuery struct {
+	StartDate string
+	EndDate   string
+	// CalendarID is the calendar to read, the primary one by default.
+	CalendarID string
+}
+
+// CreateEventRequest is a new event; Start and End are RFC3339.
+type CreateEventRequest struct {
+	Summary     string `json:"summary"`
+	Description string `json:"description,omitempty"`
+	Start       string `json:"start"`
+	End         string `json:"end"`
+	Location    string `json:"location,omitempty"`
+	// Attachments are Drive files to attach, at most 25.
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// AddTravelBuffer adds a block before the event for the travel from the
+	// previous one, or warns when there is no time to travel.
+	AddTravelBuffer bool `json:"add_travel_buffer,omitempty"`
+}
+
+// FollowUpsRequest selects the meetings CreateFollowUps checks; all
+// fields are optional.
+type FollowUpsRequest struct {
+	// Since is the earliest end of the meetings, RFC3339; the service's
+	// FOLLOWUP_LOOKBACK ago by default.
+	Since string `json:"since,omitempty"`
+	// Keywords replace the service's FOLLOWUP_KEYWORDS.
+	Keywords   []string `json:"keywords,omitempty"`
+	CalendarID string   `json:"calendar_id,omitempty"`
+}
+
+// FollowUps is the outcome of CreateFollowUps.
+type FollowUps struct {
+	// Matched counts the ended meetings with a keyword.
+	Matched int    `json:"matched"`
+	Created []Task `json:"created"`
+	// Existing counts the meetings that already had their follow-up task.
+	Existing int `json:"existing"`
+}
+
+// CalendarClient is a client of the calendar service.
+type CalendarClient struct {
+	base
+}
+
+// NewCalendarClient returns a client of the calendar service at baseURL,
+// such as "http://calendar-service:8082".
+func NewCalendarClient(baseURL string, opts ...Option) *CalendarClient {
+	return &CalendarClient{newBase("calendar-service", baseURL, opts)}
+}
+
+// Events returns the user's events matching q.
+func (c *CalendarClient) Events(ctx context.Context, q EventsQuery) ([]Event, error) {
+	query := url.Values{}
+	if q.StartDate != "" {
+		query.Set("start_date", q.StartDate)
+	}
+	if q.EndDate != "" {
+		query.Set("end_date", q.EndDate)
+	}
+	if q.CalendarID != "" {
+		query.Set("calendar_id", q.CalendarID)
+	}
+	var resp struct {
+		Events []Event `json:"events"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/events", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Events, nil
+}
+
+// Calendars returns the calendars the user can read events of.
+func (c *CalendarClient) Calendars(ctx context.Context) ([]Calendar, error) {
+	var resp struct {
+		Calendars []Calendar `json:"calendars"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/calendars", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Calendars, nil
+}
+
+// CreateEvent adds an event to the user's calendar and returns it.
+func (c *CalendarClient) CreateEvent(ctx context.Context, req CreateEventRequest) (*Event, error) {
+	var event Event
+	if err := c.do(ctx, http.MethodPost, "/events", nil, req, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// Event returns one of the user's events.
+func (c *CalendarClient) Event(ctx context.Context, id string) (*Event, error) {
+	var event Event
+	if err := c.do(ctx, http.MethodGet, "/events/"+url.PathEscape(id), nil, nil, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// AttachFiles adds Drive files to one of the user's events, keeping those
+// it has, and returns the event.
+func (c *CalendarClient) AttachFiles(ctx context.Context, id string, attachments []Attachment) (*Event, error) {
+	body := map[string]interface{}{"attachments": attachments}
+	var event Event
+	if err := c.do(ctx, http.MethodPost, "/events/"+url.PathEscape(id)+"/attachments", nil, body, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// DeleteEvent removes an event from the user's calendar.
+func (c *CalendarClient) DeleteEvent(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/events/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// CreateFollowUps creates a task to send the notes of each of the user's
+// meetings with a follow-up keyword that has ended, unless it already has
+// one, so calling it again never duplicates them.
+func (c *CalendarClient) CreateFollowUps(ctx context.Context, req FollowUpsRequest) (*FollowUps, error) {
+	var followUps FollowUps
+	if err := c.do(ctx, http.MethodPost, "/followups", nil, req, &followUps); err != nil {
+		return nil, err
+	}
+	return &followUps, nil
+}
+
+// Agenda returns the events of date, YYYY-MM-DD or "" for today, with the
+// forecast for those held outdoors.
+func (c *CalendarClient) Agenda(ctx context.Context, date string) (*Agenda, error) {
+	query := url.Values{}
+	if date != "" {
+		query.Set("date", date)
+	}
+	var agenda Agenda
+	if err := c.do(ctx, http.MethodGet, "/agenda", query, nil, &agenda); err != nil {
+		return nil, err
+	}
+	return &agenda, nil
+}
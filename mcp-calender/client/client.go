@@ -0,0 +1,235 @@
+// Package client is a Go client for the task, calendar, weather,
+// notification and GitHub sync services.
+//
+// Every call takes a context, which bounds the request and carries the
+// identity it is made for: the user's JWT (WithToken) and, for the
+// calendar, their Google access or refresh token (WithGoogleToken,
+// WithGoogleRefreshToken). Idempotent
+// requests, and those marked so with WithIdempotent, are retried when the
+// service is unreachable or temporarily unavailable. Errors returned by a service are *APIError, which matches
+// ErrNotFound, ErrUnauthorized and the other sentinel errors with
+// errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Defaults of the options.
+const (
+	DefaultTimeout = 10 * time.Second
+	DefaultRetries = 2
+	DefaultBackoff = 200 * time.Millisecond
+)
+
+// Option configures a client.
+type Option func(*base)
+
+// WithHTTPClient makes a client send its requests with hc.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(b *base) { b.http = hc }
+}
+
+// WithRetries sets how many times an idempotent request is retried; 0
+// disables retries.
+func WithRetries(n int) Option {
+	return func(b *base) { b.retries = n }
+}
+
+// WithBackoff sets the wait before the first retry, which doubles with
+// each further retry.
+func WithBackoff(d time.Duration) Option {
+	return func(b *base) { b.backoff = d }
+}
+
+// WithJitter makes each wait before a retry up to fraction, between 0 and
+// 1, shorter or longer at random, so that clients failing together do not
+// retry together.
+func WithJitter(fraction float64) Option {
+	return func(b *base) { b.jitter = fraction }
+}
+
+// WithRetryHook has hook called before each retry with the number of the
+// retry, from 1, and the error of the attempt before it.
+func WithRetryHook(hook func(retry int, err error)) Option {
+	return func(b *base) { b.onRetry = hook }
+}
+
+type tokenKey struct{}
+
+type googleTokenKey struct{}
+
+type googleRefreshTokenKey struct{}
+
+type idempotentKey struct{}
+
+// WithToken returns ctx carrying the JWT that requests made with it are
+// authorized by. Without one the services act for their default user.
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+// WithGoogleToken returns ctx carrying the Google access token calendar
+// requests made with it use. Without one the calendar returns mock events.
+func WithGoogleToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, googleTokenKey{}, token)
+}
+
+// WithGoogleRefreshToken returns ctx carrying the Google refresh token the
+// calendar gets access tokens with when ctx carries none that is valid.
+func WithGoogleRefreshToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, googleRefreshTokenKey{}, token)
+}
+
+// WithIdempotent returns ctx marking the requests made with it as safe to
+// send twice, so that they are retried even when they are POST or PATCH
+// requests, such as those of an upsert.
+func WithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// base sends the requests of one service's client.
+type base struct {
+	service string
+	baseURL string
+	http    *http.Client
+	retries int
+	backoff time.Duration
+	jitter  float64
+	onRetry func(retry int, err error)
+}
+
+func newBase(service, baseURL string, opts []Option) base {
+	b := base{
+		service: service,
+		baseURL: baseURL,
+		http:    &http.Client{Timeout: DefaultTimeout},
+		retries: DefaultRetries,
+		backoff: DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(&b)
+	}
+	return b
+}
+
+// do sends a request with body encoded as JSON, if not nil, and decodes
+// the response into out, if not nil.
+func (b *base) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("%s: encoding request: %w", b.service, err)
+		}
+	}
+	target := b.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	// Retrying a POST could create a task or send a notification twice
+	retries := b.retries
+	if idempotent, _ := ctx.Value(idempotentKey{}).(bool); !idempotent && (method == http.MethodPost || method == http.MethodPatch) {
+		retries = 0
+	}
+	for attempt := 0; ; attempt++ {
+		err := b.send(ctx, method, target, payload, out)
+		if err == nil || attempt >= retries || !retryable(err) {
+			return err
+		}
+		wait := b.backoff << attempt
+		if b.jitter > 0 {
+			wait += time.Duration(float64(wait) * b.jitter * (2*rand.Float64() - 1))
+		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+		if b.onRetry != nil {
+			b.onRetry(attempt+1, err)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *base) send(ctx context.Context, method, target string, payload []byte, out interface{}) error {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reqBody)
+	if err != nil {
+		return fmt.Errorf("%s: %w", b.service, err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if token, _ := ctx.Value(tokenKey{}).(string); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if token, _ := ctx.Value(googleTokenKey{}).(string); token != "" && b.service == "calendar-service" {
+		req.Header.Set("X-Google-Access-Token", token)
+	}
+	if token, _ := ctx.Value(googleRefreshTokenKey{}).(string); token != "" && b.service == "calendar-service" {
+		req.Header.Set("X-Google-Refresh-Token", token)
+	}
+
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", b.service, err)
+	}
+	defer resp.Body.Close()
+
+	// Redirects are followed by b.http; a 3xx left over, such as the
+	// weather service's 300 for an ambiguous city, needs the caller's choice
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		apiErr := &APIError{
+			Service:    b.service,
+			StatusCode: resp.StatusCode,
+			Message:    string(bytes.TrimSpace(msg)),
+		}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(secs) * time.Second
+		}
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s: decoding response: %w", b.service, err)
+	}
+	return nil
+}
+
+// retryable reports whether a failed request may succeed if sent again.
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	var netErr net.Error
+	var urlErr *url.Error
+	return errors.As(err, &netErr) || errors.As(err, &urlErr)
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Errors an *APIError matches with errors.Is, by status code.
+var (
+	// ErrBadRequest is a 400 or 422: the request was invalid.
+	ErrBadRequest = errors.New("bad request")
+	// ErrUnauthorized is a 401 or 403: the token is missing, invalid or
+	// expired, or does not grant access.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotFound is a 404.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is a 409, such as registering a taken email.
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable is a 429 or 5xx: the service failed or is overloaded.
+	ErrUnavailable = errors.New("service unavailable")
+)
+
+// ErrCircuitOpen is what a transport refusing to call a failing service,
+// such as a circuit breaker, wraps its errors in. Requests it refuses are
+// not retried.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// APIError is an error response of a service.
+type APIError struct {
+	// Service is the name of the service, such as "task-service".
+	Service    string
+	StatusCode int
+	// Message is the body of the response.
+	Message string
+	// RetryAfter is the wait the service asked for, if any.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s returned %d %s: %s", e.Service, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is matches the sentinel error of the status code.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	}
+	return false
+}
+
+// Temporary reports whether the request may succeed if sent again: the
+// service is overloaded, restarting or behind an unreachable proxy.
+func (e *APIError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
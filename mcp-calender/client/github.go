@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// GitHubSyncConfig is what a user syncs from GitHub: the issues assigned
+// to GitHubLogin in each of Repos, as tasks.
+type GitHubSyncConfig struct {
+	UserID      string   `json:"user_id"`
+	GitHubLogin string   `json:"github_login"`
+	Repos       []string `json:"repos"`
+	Enabled     bool     `json:"enabled"`
+	// LastRunAt is when the last sync started.
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	// SyncedUntil is when the last sync that succeeded started.
+	SyncedUntil *time.Time `json:"synced_until,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// GitHubSyncConfigRequest sets what a user syncs. Enabled defaults to
+// true.
+type GitHubSyncConfigRequest struct {
+	GitHubLogin string   `json:"github_login"`
+	Repos       []string `json:"repos"`
+	Enabled     *bool    `json:"enabled,omitempty"`
+}
+
+// GitHubSyncResult is the outcome of a sync.
+type GitHubSyncResult struct {
+	Repos     []GitHubRepoResult `json:"repos"`
+	Created   int                `json:"created"`
+	Updated   int                `json:"updated"`
+	Unchanged int                `json:"unchanged"`
+	// Errors counts the repositories that failed to sync.
+	Errors   int       `json:"errors"`
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// GitHubRepoResult is the outcome of syncing one repository.
+type GitHubRepoResult struct {
+	Repo      string `json:"repo"`
+	Issues    int    `json:"issues"`
+	Created   int    `json:"created"`
+	Updated   int    `json:"updated"`
+	Unchanged int    `json:"unchanged"`
+	Error     string `json:"error,omitempty"`
+}
+
+// GitHubSyncClient is a client of the GitHub sync service.
+type GitHubSyncClient struct {
+	base
+}
+
+// NewGitHubSyncClient returns a client of the GitHub sync service at
+// baseURL, such as "http://github-sync-service:8087".
+func NewGitHubSyncClient(baseURL string, opts ...Option) *GitHubSyncClient {
+	return &GitHubSyncClient{newBase("github-sync-service", baseURL, opts)}
+}
+
+// Config returns what the user syncs; ErrNotFound if they sync nothing.
+func (c *GitHubSyncClient) Config(ctx context.Context) (*GitHubSyncConfig, error) {
+	var cfg GitHubSyncConfig
+	if err := c.do(ctx, http.MethodGet, "/config", nil, nil, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// UpdateConfig sets what the user syncs.
+func (c *GitHubSyncClient) UpdateConfig(ctx context.Context, req GitHubSyncConfigRequest) (*GitHubSyncConfig, error) {
+	var cfg GitHubSyncConfig
+	if err := c.do(ctx, http.MethodPut, "/config", nil, req, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Sync syncs the user's issues now, of all their repos or only of repos.
+func (c *GitHubSyncClient) Sync(ctx context.Context, repos ...string) (*GitHubSyncResult, error) {
+	body := map[string]interface{}{}
+	if len(repos) > 0 {
+		body["repos"] = repos
+	}
+	var result GitHubSyncResult
+	if err := c.do(ctx, http.MethodPost, "/sync", nil, body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
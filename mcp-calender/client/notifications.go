@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Notification is a message sent to a user over one or more channels.
+type Notification struct {
+	ID         int        `json:"id"`
+	UserID     string     `json:"user_id"`
+	Type       string     `json:"type"`
+	Subject    string     `json:"subject"`
+	Body       string     `json:"body"`
+	Priority   string     `json:"priority"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+// Delivery tracks sending a notification over one channel.
+type Delivery struct {
+	ID      int    `json:"id"`
+	Channel string `json:"channel"`
+	// Status is "pending", "sent", "failed" or "skipped".
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	Error       string     `json:"error,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// SendNotificationRequest is a notification to send to the user of the
+// request's token. Data fills the template of Type; "custom" notifications
+// use Subject and Message.
+type SendNotificationRequest struct {
+	Type     string                 `json:"type,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Subject  string                 `json:"subject,omitempty"`
+	Message  string                 `json:"message,omitempty"`
+	Priority string                 `json:"priority,omitempty"`
+	// Channels restricts delivery to these of the user's channels.
+	Channels []string `json:"channels,omitempty"`
+}
+
+// NotificationsClient is a client of the notification service.
+type NotificationsClient struct {
+	base
+}
+
+// NewNotificationsClient returns a client of the notification service at
+// baseURL, such as "http://notification-service:8084".
+func NewNotificationsClient(baseURL string, opts ...Option) *NotificationsClient {
+	return &NotificationsClient{newBase("notification-service", baseURL, opts)}
+}
+
+// Send queues a notification and returns it with its pending deliveries.
+func (c *NotificationsClient) Send(ctx context.Context, req SendNotificationRequest) (*Notification, error) {
+	var n Notification
+	if err := c.do(ctx, http.MethodPost, "/notifications", nil, req, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// Get returns a notification with the status of each delivery.
+func (c *NotificationsClient) Get(ctx context.Context, id int) (*Notification, error) {
+	var n Notification
+	if err := c.do(ctx, http.MethodGet, "/notifications/"+strconv.Itoa(id), nil, nil, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
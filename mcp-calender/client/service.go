@@ -0,0 +1,26 @@
+package client
+
+import (
+	"context"
+	"net/url"
+)
+
+// ServiceClient is a client of any service, calling its endpoints by path,
+// such as those the MCP server's configured tools call.
+type ServiceClient struct {
+	base
+}
+
+// NewServiceClient returns a client of the service named service at
+// baseURL. The calendar's Google tokens are only sent to a service named
+// "calendar-service".
+func NewServiceClient(service, baseURL string, opts ...Option) *ServiceClient {
+	return &ServiceClient{newBase(service, baseURL, opts)}
+}
+
+// Do sends a request for path with query and body, encoded as JSON if not
+// nil, and decodes the response into out, if not nil. It is retried as
+// the requests of the other clients are.
+func (c *ServiceClient) Do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	return c.do(ctx, method, path, query, body, out)
+}
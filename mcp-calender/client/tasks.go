@@ -0,0 +1,275 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Task is a task of the task service.
+type Task struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// Priority is "low", "medium" or "high".
+	Priority string `json:"priority"`
+	// Status starts as "pending".
+	Status string `json:"status"`
+	// DueDate is YYYY-MM-DD, or "" for none.
+	DueDate string   `json:"due_date,omitempty"`
+	Tags    []string `json:"tags"`
+	// ExternalSource and ExternalID identify the item of another system
+	// the task is synced with, such as "github" and "owner/repo#12".
+	ExternalSource string    `json:"external_source,omitempty"`
+	ExternalID     string    `json:"external_id,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// CreateTaskRequest is a new task. Priority defaults to "medium".
+type CreateTaskRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Priority    string `json:"priority,omitempty"`
+	// DueDate is YYYY-MM-DD.
+	DueDate string   `json:"due_date,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	// ExternalSource and ExternalID, given together, link the task to an
+	// external item.
+	ExternalSource string `json:"external_source,omitempty"`
+	ExternalID     string `json:"external_id,omitempty"`
+}
+
+// UpdateTaskRequest changes the fields of a task that are not nil. An
+// empty DueDate clears it. ExternalSource and ExternalID are set together;
+// empty ones unlink the task from its external item.
+type UpdateTaskRequest struct {
+	Title          *string   `json:"title,omitempty"`
+	Description    *string   `json:"description,omitempty"`
+	Priority       *string   `json:"priority,omitempty"`
+	Status         *string   `json:"status,omitempty"`
+	DueDate        *string   `json:"due_date,omitempty"`
+	Tags           *[]string `json:"tags,omitempty"`
+	ExternalSource *string   `json:"external_source,omitempty"`
+	ExternalID     *string   `json:"external_id,omitempty"`
+}
+
+// ExternalTaskRequest is the current state of an external item, which
+// replaces that of its task. Status is only changed when set.
+type ExternalTaskRequest struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Priority    string   `json:"priority,omitempty"`
+	Status      string   `json:"status,omitempty"`
+	DueDate     string   `json:"due_date,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// UpsertResult is the task of an external item and what saving it did:
+// "created", "updated" or "unchanged".
+type UpsertResult struct {
+	Task   Task   `json:"task"`
+	Result string `json:"result"`
+}
+
+// TasksClient is a client of the task service.
+type TasksClient struct {
+	base
+}
+
+// NewTasksClient returns a client of the task service at baseURL, such as
+// "http://task-service:8081".
+func NewTasksClient(baseURL string, opts ...Option) *TasksClient {
+	return &TasksClient{newBase("task-service", baseURL, opts)}
+}
+
+// List returns the user's tasks, newest first.
+func (c *TasksClient) List(ctx context.Context) ([]Task, error) {
+	var resp struct {
+		Tasks []Task `json:"tasks"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/tasks", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Tasks, nil
+}
+
+// ListOptions select a page of the user's tasks. Zero fields are left to
+// the task service: all tasks, newest first.
+type ListOptions struct {
+	// Statuses and Priorities match any of their values.
+	Statuses   []string
+	Priorities []string
+	Tag        string
+	// ExternalSource matches the tasks synced with that system, and
+	// UpdatedAfter those changed since then.
+	ExternalSource string
+	UpdatedAfter   time.Time
+	// Sort is created_at, updated_at, due_date, priority or title, and
+	// Order asc or desc.
+	Sort  string
+	Order string
+	// Limit is the most tasks of the page, up to 500.
+	Limit int
+	// Cursor is the NextCursor of the previous page. It keeps the order of
+	// the first page; the filters must be given again.
+	Cursor string
+}
+
+// TaskPage is a page of tasks. NextCursor is "" on the last page.
+type TaskPage struct {
+	Tasks      []Task `json:"tasks"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ListPage returns a page of the user's tasks. Paging by cursor neither
+// repeats nor skips tasks when others are added or deleted meanwhile.
+func (c *TasksClient) ListPage(ctx context.Context, opts ListOptions) (*TaskPage, error) {
+	query := url.Values{}
+	if len(opts.Statuses) > 0 {
+		query.Set("status", strings.Join(opts.Statuses, ","))
+	}
+	if len(opts.Priorities) > 0 {
+		query.Set("priority", strings.Join(opts.Priorities, ","))
+	}
+	if opts.Tag != "" {
+		query.Set("tag", opts.Tag)
+	}
+	if opts.ExternalSource != "" {
+		query.Set("external_source", opts.ExternalSource)
+	}
+	if !opts.UpdatedAfter.IsZero() {
+		query.Set("updated_after", opts.UpdatedAfter.Format(time.RFC3339Nano))
+	}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	if opts.Order != "" {
+		query.Set("order", opts.Order)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Cursor != "" {
+		query.Set("cursor", opts.Cursor)
+	}
+	var page TaskPage
+	if err := c.do(ctx, http.MethodGet, "/tasks", query, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// Get returns one of the user's tasks.
+func (c *TasksClient) Get(ctx context.Context, id int) (*Task, error) {
+	var task Task
+	if err := c.do(ctx, http.MethodGet, "/tasks/"+strconv.Itoa(id), nil, nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// Create adds a task for the user and returns it.
+func (c *TasksClient) Create(ctx context.Context, req CreateTaskRequest) (*Task, error) {
+	var task Task
+	if err := c.do(ctx, http.MethodPost, "/tasks", nil, req, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// CreateMany adds several tasks for the user at once, all of them or none,
+// and returns them in order.
+func (c *TasksClient) CreateMany(ctx context.Context, reqs []CreateTaskRequest) ([]Task, error) {
+	body := struct {
+		Tasks []CreateTaskRequest `json:"tasks"`
+	}{reqs}
+	var resp struct {
+		Tasks []Task `json:"tasks"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/tasks/bulk", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Tasks, nil
+}
+
+// External returns the user's task synced with the item externalID of
+// source.
+func (c *TasksClient) External(ctx context.Context, source, externalID string) (*Task, error) {
+	var task Task
+	if err := c.do(ctx, http.MethodGet, externalPath(source, externalID), nil, nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// UpsertExternal creates or updates the user's task synced with the item
+// externalID of source. Calling it again with the same item never creates
+// a second task.
+func (c *TasksClient) UpsertExternal(ctx context.Context, source, externalID string, req ExternalTaskRequest) (*UpsertResult, error) {
+	var result UpsertResult
+	if err := c.do(ctx, http.MethodPut, externalPath(source, externalID), nil, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func externalPath(source, externalID string) string {
+	return "/tasks/external/" + url.PathEscape(source) + "/" + url.PathEscape(externalID)
+}
+
+// Update changes one of the user's tasks and returns it.
+func (c *TasksClient) Update(ctx context.Context, id int, req UpdateTaskRequest) (*Task, error) {
+	var task Task
+	if err := c.do(ctx, http.MethodPatch, "/tasks/"+strconv.Itoa(id), nil, req, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// Delete removes one of the user's tasks.
+func (c *TasksClient) Delete(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/tasks/"+strconv.Itoa(id), nil, nil, nil)
+}
+
+// TaskFilter selects tasks to delete in bulk; a task must match every
+// field that is set.
+type TaskFilter struct {
+	Statuses []string
+	// CompletedBefore, YYYY-MM-DD, matches tasks completed before that day.
+	CompletedBefore string
+	Tag             string
+}
+
+// BulkDeleteResult is the outcome of DeleteMany.
+type BulkDeleteResult struct {
+	Matched int64 `json:"matched"`
+	Deleted int64 `json:"deleted"`
+	DryRun  bool  `json:"dry_run"`
+}
+
+// DeleteMany removes the user's tasks matching filter, which must set at
+// least one field. A dry run only counts them.
+func (c *TasksClient) DeleteMany(ctx context.Context, filter TaskFilter, dryRun bool) (*BulkDeleteResult, error) {
+	query := url.Values{}
+	if len(filter.Statuses) > 0 {
+		query.Set("status", strings.Join(filter.Statuses, ","))
+	}
+	if filter.CompletedBefore != "" {
+		query.Set("completed_before", filter.CompletedBefore)
+	}
+	if filter.Tag != "" {
+		query.Set("tag", filter.Tag)
+	}
+	if dryRun {
+		query.Set("dry_run", "true")
+	}
+	var result BulkDeleteResult
+	if err := c.do(ctx, http.MethodDelete, "/tasks", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,321 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Weather is the current weather of a city.
+type Weather struct {
+	City        string  `json:"city"`
+	Country     string  `json:"country"`
+	Temperature float64 `json:"temperature"`
+	// Description is the weather provider's own; Condition and Icon are
+	// the same whichever provider the weather is from.
+	Description string `json:"description"`
+	// Condition is clear, partly_cloudy, cloudy, fog, haze, drizzle, rain,
+	// sleet, snow, thunderstorm, wind or unknown.
+	Condition string `json:"condition"`
+	// Icon is the condition with dashes, suffixed -day or -night for clear
+	// and partly cloudy skies, e.g. "partly-cloudy-night" or "rain".
+	Icon      string  `json:"icon"`
+	Humidity  int     `json:"humidity"`
+	WindSpeed float64 `json:"wind_speed"`
+	Timestamp int64   `json:"timestamp"`
+	// Source is "api", "cache" or "mock", or "local" when the temperature
+	// and humidity are those of the user's own sensor.
+	Source string `json:"source"`
+	// Station names the sensor of a local reading.
+	Station string `json:"station,omitempty"`
+	// Units is "metric" (°C, m/s) or "imperial" (°F, mph).
+	Units string `json:"units"`
+	// TemperatureUnit is "°C" or "°F" and WindSpeedUnit "m/s" or "mph".
+	TemperatureUnit string `json:"temperature_unit"`
+	WindSpeedUnit   string `json:"wind_speed_unit"`
+	// LocationID identifies the place; it is unset in mock mode.
+	LocationID string `json:"location_id,omitempty"`
+}
+
+// Forecast is the forecast for a time window, summed up over the forecast
+// slots it overlaps.
+type Forecast struct {
+	City           string    `json:"city"`
+	Country        string    `json:"country"`
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	TemperatureMin float64   `json:"temperature_min"`
+	TemperatureMax float64   `json:"temperature_max"`
+	// Description, Condition and Icon are those of the wettest forecast
+	// slot, as in Weather.
+	Description         string  `json:"description"`
+	Condition           string  `json:"condition"`
+	Icon                string  `json:"icon"`
+	PrecipitationChance float64 `json:"precipitation_chance"`
+	WindSpeed           float64 `json:"wind_speed"`
+	Source              string  `json:"source"`
+	Units               string  `json:"units"`
+	TemperatureUnit     string  `json:"temperature_unit"`
+	WindSpeedUnit       string  `json:"wind_speed_unit"`
+	LocationID          string  `json:"location_id,omitempty"`
+}
+
+// NowcastMinute is the precipitation forecast for the minute from Time.
+type NowcastMinute struct {
+	Time time.Time `json:"time"`
+	// Precipitation is in PrecipitationUnit.
+	Precipitation float64 `json:"precipitation"`
+}
+
+// Nowcast is the precipitation of the next hour, minute by minute, with
+// whether and when it will rain.
+type Nowcast struct {
+	City       string `json:"city"`
+	Country    string `json:"country,omitempty"`
+	LocationID string `json:"location_id,omitempty"`
+	RainingNow bool   `json:"raining_now"`
+	WillRain   bool   `json:"will_rain"`
+	// RainStartsIn is the minutes until it starts raining, when it is dry
+	// now, and RainStopsIn those until it stops, when it is raining now.
+	RainStartsIn      *int            `json:"rain_starts_in,omitempty"`
+	RainStopsIn       *int            `json:"rain_stops_in,omitempty"`
+	PeakPrecipitation float64         `json:"peak_precipitation"`
+	WindowMinutes     int             `json:"window_minutes"`
+	Minutes           []NowcastMinute `json:"minutes"`
+	Source            string          `json:"source"`
+	Units             string          `json:"units"`
+	// PrecipitationUnit is "mm/h" or "in/h".
+	PrecipitationUnit string `json:"precipitation_unit"`
+}
+
+// WeatherSummary is the weather of a place at a glance, in a small schema
+// for widgets and LLMs.
+type WeatherSummary struct {
+	City string `json:"city"`
+	// Text is a one-line summary, e.g. "Paris: 15°C, light rain. Rain
+	// likely tomorrow (80%)."
+	Text            string `json:"text"`
+	Icon            string `json:"icon"`
+	Temperature     int    `json:"temperature"`
+	TemperatureUnit string `json:"temperature_unit"`
+	// Days are the forecast of the next 3 days from today, or none when
+	// the forecast is unavailable.
+	Days   []DaySummary `json:"days"`
+	Source string       `json:"source"`
+}
+
+// DaySummary is the forecast of one day of a WeatherSummary.
+type DaySummary struct {
+	Date string `json:"date"` // YYYY-MM-DD
+	// Label is "Today", "Tomorrow" or the day of the week, e.g. "Sat".
+	Label          string `json:"label"`
+	Icon           string `json:"icon"`
+	TemperatureMin int    `json:"temperature_min"`
+	TemperatureMax int    `json:"temperature_max"`
+	// PrecipitationChance is in percent.
+	PrecipitationChance int `json:"precipitation_chance"`
+}
+
+// Location is one of the places an ambiguous city name may refer to.
+type Location struct {
+	// LocationID is passed to CurrentAt or ForecastAt to choose the place.
+	LocationID string  `json:"location_id"`
+	Name       string  `json:"name"`
+	Country    string  `json:"country"`
+	State      string  `json:"state,omitempty"`
+	Lat        float64 `json:"lat"`
+	Lon        float64 `json:"lon"`
+}
+
+// AmbiguousCityError is returned for a city name several places have. The
+// request is made again with the LocationID of one of the Candidates.
+type AmbiguousCityError struct {
+	City       string     `json:"city"`
+	Message    string     `json:"message"`
+	Candidates []Location `json:"candidates"`
+}
+
+func (e *AmbiguousCityError) Error() string {
+	return fmt.Sprintf("weather-service: %d places are called %s", len(e.Candidates), e.City)
+}
+
+// ambiguousCity turns the 300 the weather service answers an ambiguous
+// city with into an *AmbiguousCityError.
+func ambiguousCity(err error) error {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusMultipleChoices {
+		return err
+	}
+	ambiguous := &AmbiguousCityError{}
+	if json.Unmarshal([]byte(apiErr.Message), ambiguous) != nil {
+		return err
+	}
+	return ambiguous
+}
+
+// WeatherPreferences are a user's weather settings.
+type WeatherPreferences struct {
+	// HomeCity is used when a request names no city.
+	HomeCity string `json:"home_city,omitempty"`
+	// Units is "metric" or "imperial".
+	Units string `json:"units"`
+}
+
+// WeatherClient is a client of the weather service.
+type WeatherClient struct {
+	base
+}
+
+// NewWeatherClient returns a client of the weather service at baseURL,
+// such as "http://weather-service:8083".
+func NewWeatherClient(baseURL string, opts ...Option) *WeatherClient {
+	return &WeatherClient{newBase("weather-service", baseURL, opts)}
+}
+
+// Current returns the weather of city, or "" for the user's home city. A
+// city several places are called fails with an *AmbiguousCityError.
+func (c *WeatherClient) Current(ctx context.Context, city string) (*Weather, error) {
+	query := url.Values{}
+	if city != "" {
+		query.Set("city", city)
+	}
+	return c.current(ctx, query)
+}
+
+// CurrentAt returns the weather of the place with locationID.
+func (c *WeatherClient) CurrentAt(ctx context.Context, locationID string) (*Weather, error) {
+	return c.current(ctx, url.Values{"location_id": {locationID}})
+}
+
+func (c *WeatherClient) current(ctx context.Context, query url.Values) (*Weather, error) {
+	var weather Weather
+	if err := c.do(ctx, http.MethodGet, "/weather", query, nil, &weather); err != nil {
+		return nil, ambiguousCity(err)
+	}
+	return &weather, nil
+}
+
+// Forecast returns the forecast of city, or "" for the user's home city,
+// between start and end. A zero end is the hour from start. A city several
+// places are called fails with an *AmbiguousCityError.
+func (c *WeatherClient) Forecast(ctx context.Context, city string, start, end time.Time) (*Forecast, error) {
+	query := url.Values{}
+	if city != "" {
+		query.Set("city", city)
+	}
+	return c.forecast(ctx, query, start, end)
+}
+
+// ForecastAt returns the forecast of the place with locationID between
+// start and end.
+func (c *WeatherClient) ForecastAt(ctx context.Context, locationID string, start, end time.Time) (*Forecast, error) {
+	return c.forecast(ctx, url.Values{"location_id": {locationID}}, start, end)
+}
+
+func (c *WeatherClient) forecast(ctx context.Context, query url.Values, start, end time.Time) (*Forecast, error) {
+	query.Set("start", start.Format(time.RFC3339))
+	if !end.IsZero() {
+		query.Set("end", end.Format(time.RFC3339))
+	}
+	var forecast Forecast
+	if err := c.do(ctx, http.MethodGet, "/forecast", query, nil, &forecast); err != nil {
+		return nil, ambiguousCity(err)
+	}
+	return &forecast, nil
+}
+
+// Nowcast returns the precipitation of the next hour in city, or "" for
+// the user's home city. A city several places are called fails with an
+// *AmbiguousCityError, and a place without minute forecasts with a 404
+// *APIError.
+func (c *WeatherClient) Nowcast(ctx context.Context, city string) (*Nowcast, error) {
+	query := url.Values{}
+	if city != "" {
+		query.Set("city", city)
+	}
+	return c.nowcast(ctx, query)
+}
+
+// NowcastAt returns the precipitation of the next hour at the place with
+// locationID.
+func (c *WeatherClient) NowcastAt(ctx context.Context, locationID string) (*Nowcast, error) {
+	return c.nowcast(ctx, url.Values{"location_id": {locationID}})
+}
+
+func (c *WeatherClient) nowcast(ctx context.Context, query url.Values) (*Nowcast, error) {
+	var nowcast Nowcast
+	if err := c.do(ctx, http.MethodGet, "/weather/nowcast", query, nil, &nowcast); err != nil {
+		return nil, ambiguousCity(err)
+	}
+	return &nowcast, nil
+}
+
+// Summary returns the weather of city at a glance, or of the user's home
+// city for "". A city several places are called fails with an
+// *AmbiguousCityError.
+func (c *WeatherClient) Summary(ctx context.Context, city string) (*WeatherSummary, error) {
+	query := url.Values{}
+	if city != "" {
+		query.Set("city", city)
+	}
+	return c.summary(ctx, query)
+}
+
+// SummaryAt returns the weather at a glance of the place with locationID.
+func (c *WeatherClient) SummaryAt(ctx context.Context, locationID string) (*WeatherSummary, error) {
+	return c.summary(ctx, url.Values{"location_id": {locationID}})
+}
+
+func (c *WeatherClient) summary(ctx context.Context, query url.Values) (*WeatherSummary, error) {
+	var summary WeatherSummary
+	if err := c.do(ctx, http.MethodGet, "/weather/summary", query, nil, &summary); err != nil {
+		return nil, ambiguousCity(err)
+	}
+	return &summary, nil
+}
+
+// CitySuggestions are city names the weather service knows, for
+// autocompletion.
+type CitySuggestions struct {
+	Cities []string `json:"cities"`
+	// HasMore is set when more names start with the prefix.
+	HasMore bool `json:"has_more"`
+}
+
+// Cities returns up to limit known city names starting with prefix: the
+// home city, the favorites and places looked up before. A limit of 0
+// leaves it to the weather service.
+func (c *WeatherClient) Cities(ctx context.Context, prefix string, limit int) (*CitySuggestions, error) {
+	query := url.Values{"prefix": {prefix}}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var suggestions CitySuggestions
+	if err := c.do(ctx, http.MethodGet, "/cities", query, nil, &suggestions); err != nil {
+		return nil, err
+	}
+	return &suggestions, nil
+}
+
+// Preferences returns the user's weather preferences.
+func (c *WeatherClient) Preferences(ctx context.Context) (*WeatherPreferences, error) {
+	var prefs WeatherPreferences
+	if err := c.do(ctx, http.MethodGet, "/preferences", nil, nil, &prefs); err != nil {
+		return nil, err
+	}
+	return &prefs, nil
+}
+
+// UpdatePreferences replaces the user's weather preferences.
+func (c *WeatherClient) UpdatePreferences(ctx context.Context, prefs WeatherPreferences) (*WeatherPreferences, error) {
+	var saved WeatherPreferences
+	if err := c.do(ctx, http.MethodPut, "/preferences", nil, prefs, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// An archive is a gzipped tar holding manifest.json first, then one JSON
+// lines file per table, <store>/<table>.jsonl, in an order where every
+// table comes after the tables it references, then redis/keys.jsonl.
+
+// formatVersion is the version of the archive layout; restore refuses
+// archives newer than it knows
+const formatVersion = 1
+
+const manifestName = "manifest.json"
+
+// Names of the stores in an archive
+const (
+	platformStore  = "platform"
+	documentsStore = "documents"
+	redisStore     = "redis"
+)
+
+// Manifest describes the content of an archive. The columns of each table
+// are its schema at backup time: restore checks them against the target
+// database before writing anything.
+type Manifest struct {
+	FormatVersion int             `json:"format_version"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Stores        []StoreManifest `json:"stores"`
+}
+
+// StoreManifest describes one store: the tables of a Postgres store, or the
+// number of keys of Redis
+type StoreManifest struct {
+	Name   string          `json:"name"`
+	Tables []TableManifest `json:"tables,omitempty"`
+	Keys   int             `json:"keys,omitempty"`
+}
+
+// TableManifest describes a table and the rows backed up from it
+type TableManifest struct {
+	Name    string   `json:"name"`
+	Columns []Column `json:"columns"`
+	Rows    int      `json:"rows"`
+}
+
+// Column is a column and its type as Postgres formats it, e.g. "integer"
+// or "vector(768)"
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// archiveFile is a file of the archive, exported to a temporary file first
+type archiveFile struct {
+	name string
+	path string
+}
+
+// postgresStores returns the Postgres stores that have a URL.
+func (s *stores) postgresStores() map[string]string {
+	urls := map[string]string{}
+	if s.platformURL != "" {
+		urls[platformStore] = s.platformURL
+	}
+	if s.documentsURL != "" {
+		urls[documentsStore] = s.documentsURL
+	}
+	return urls
+}
+
+// backup exports every configured store and writes them to out. The archive
+// is written under a temporary name and renamed once complete, so a failed
+// backup never leaves a truncated archive behind.
+func backup(ctx context.Context, s *stores, out string) (*Manifest, error) {
+	dir, err := os.MkdirTemp("", "mcp-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := &Manifest{FormatVersion: formatVersion, CreatedAt: time.Now().UTC()}
+	var files []archiveFile
+
+	pgStores := s.postgresStores()
+	for _, name := range []string{platformStore, documentsStore} {
+		url, ok := pgStores[name]
+		if !ok {
+			log.Printf("Skipping %s: no database URL given", name)
+			continue
+		}
+		db, err := openPostgres(ctx, url)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		store, storeFiles, err := exportPostgres(ctx, db, name, dir)
+		db.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		manifest.Stores = append(manifest.Stores, store)
+		files = append(files, storeFiles...)
+	}
+
+	if s.redisAddr == "" {
+		log.Printf("Skipping %s: no Redis address given", redisStore)
+	} else {
+		client, err := openRedis(ctx, s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", redisStore, err)
+		}
+		store, file, err := exportRedis(ctx, client, dir)
+		client.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", redisStore, err)
+		}
+		manifest.Stores = append(manifest.Stores, store)
+		files = append(files, file)
+	}
+
+	if len(manifest.Stores) == 0 {
+		return nil, errors.New("no store to back up: give -database-url, -docs-database-url or -redis-url")
+	}
+
+	partial := out + ".partial"
+	if err := writeArchive(partial, manifest, files); err != nil {
+		os.Remove(partial)
+		return nil, err
+	}
+	if err := os.Rename(partial, out); err != nil {
+		os.Remove(partial)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// exportFile creates the temporary file of an archive entry.
+func exportFile(dir, name string) (*os.File, error) {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+func writeArchive(path string, manifest *Manifest, files []archiveFile) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: manifestName, Mode: 0o600, Size: int64(len(data)), ModTime: manifest.CreatedAt}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := addFile(tw, file, manifest.CreatedAt); err != nil {
+			return fmt.Errorf("adding %s: %w", file.name, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func addFile(tw *tar.Writer, file archiveFile, modTime time.Time) error {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: file.name, Mode: 0o600, Size: info.Size(), ModTime: modTime}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// errStop ends eachEntry early without an error
+var errStop = errors.New("stop")
+
+// eachEntry calls fn for every file of the archive at path, in order.
+func eachEntry(path string, fn func(name string, r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("not a backup archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.Name, tr); err != nil {
+			if err == errStop {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// readManifest reads the manifest of the archive at path.
+func readManifest(path string) (*Manifest, error) {
+	var manifest *Manifest
+	err := eachEntry(path, func(name string, r io.Reader) error {
+		if name != manifestName {
+			return fmt.Errorf("not a backup archive: %s comes before %s", name, manifestName)
+		}
+		manifest = &Manifest{}
+		if err := json.NewDecoder(r).Decode(manifest); err != nil {
+			return fmt.Errorf("reading %s: %w", manifestName, err)
+		}
+		return errStop
+	})
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("not a backup archive: no %s", manifestName)
+	}
+	return manifest, nil
+}
+
+// splitEntry splits an archive entry into its store and table.
+func splitEntry(name string) (store, table string, ok bool) {
+	store, file, ok := strings.Cut(name, "/")
+	if !ok || !strings.HasSuffix(file, ".jsonl") {
+		return "", "", false
+	}
+	return store, strings.TrimSuffix(file, ".jsonl"), true
+}
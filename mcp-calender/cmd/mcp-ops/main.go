@@ -0,0 +1,127 @@
+// Command mcp-ops backs up the persistent stores of the platform into one
+// archive and restores them, so that a self-hosted deployment can move to
+// another machine:
+//
+//   - platform: the Postgres database of the task, auth, notification and
+//     rules services and the weather preferences
+//   - documents: the Postgres index of the unified doc agent
+//   - redis: favorite cities, undo history and working memory, and
+//     weather preferences saved before they moved to Postgres; cached
+//     weather is left out
+//
+// The calendar service keeps no data of its own, its events live in Google
+// Calendar.
+//
+// Usage:
+//
+//	mcp-ops backup [-o archive.tar.gz]
+//	mcp-ops restore -i archive.tar.gz [-replace] [-check]
+//	mcp-ops inspect -i archive.tar.gz
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// stores says where each store lives; stores left empty are skipped
+type stores struct {
+	platformURL   string
+	documentsURL  string
+	redisAddr     string
+	redisPassword string
+	redisDB       int
+}
+
+func storeFlags(fs *flag.FlagSet) *stores {
+	s := &stores{}
+	fs.StringVar(&s.platformURL, "database-url", os.Getenv("DATABASE_URL"), "Postgres database of the task, auth, notification and rules services")
+	fs.StringVar(&s.documentsURL, "docs-database-url", os.Getenv("DOC_AGENT_DATABASE_URL"), "Postgres database of the unified doc agent")
+	fs.StringVar(&s.redisAddr, "redis-url", os.Getenv("REDIS_URL"), "Redis address, e.g. localhost:6379")
+	fs.StringVar(&s.redisPassword, "redis-password", os.Getenv("REDIS_PASSWORD"), "Redis password")
+	redisDB, _ := strconv.Atoi(os.Getenv("REDIS_DB"))
+	fs.IntVar(&s.redisDB, "redis-db", redisDB, "Redis database number")
+	return s
+}
+
+func main() {
+	backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
+	backupOut := backupCmd.String("o", "", "archive to write (default mcp-backup-<timestamp>.tar.gz)")
+	backupStores := storeFlags(backupCmd)
+
+	restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+	restoreIn := restoreCmd.String("i", "", "archive to restore")
+	restoreReplace := restoreCmd.Bool("replace", false, "replace the data already in the stores instead of refusing to restore over it")
+	restoreCheck := restoreCmd.Bool("check", false, "only check that the archive can be restored, without writing anything")
+	restoreStores := storeFlags(restoreCmd)
+
+	inspectCmd := flag.NewFlagSet("inspect", flag.ExitOnError)
+	inspectIn := inspectCmd.String("i", "", "archive to describe")
+
+	if len(os.Args) < 2 {
+		fmt.Println("Usage: mcp-ops <backup|restore|inspect> [flags]")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch os.Args[1] {
+	case "backup":
+		backupCmd.Parse(os.Args[2:])
+		out := *backupOut
+		if out == "" {
+			out = fmt.Sprintf("mcp-backup-%s.tar.gz", time.Now().UTC().Format("20060102-150405"))
+		}
+		manifest, err := backup(ctx, backupStores, out)
+		if err != nil {
+			log.Fatalf("Backup failed: %v", err)
+		}
+		printManifest(manifest)
+		fmt.Printf("Backup written to %s\n", out)
+
+	case "restore":
+		restoreCmd.Parse(os.Args[2:])
+		if *restoreIn == "" {
+			log.Fatal("Please provide the archive to restore with -i")
+		}
+		err := restore(ctx, restoreStores, *restoreIn, restoreOptions{replace: *restoreReplace, checkOnly: *restoreCheck})
+		if err != nil {
+			log.Fatalf("Restore failed: %v", err)
+		}
+		if *restoreCheck {
+			fmt.Println("The archive can be restored")
+		} else {
+			fmt.Printf("Restored %s\n", *restoreIn)
+		}
+
+	case "inspect":
+		inspectCmd.Parse(os.Args[2:])
+		if *inspectIn == "" {
+			log.Fatal("Please provide the archive to describe with -i")
+		}
+		manifest, err := readManifest(*inspectIn)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", *inspectIn, err)
+		}
+		printManifest(manifest)
+
+	default:
+		fmt.Println("Unknown command:", os.Args[1])
+		fmt.Println("Usage: mcp-ops <backup|restore|inspect> [flags]")
+		os.Exit(1)
+	}
+}
+
+func printManifest(m *Manifest) {
+	out, _ := json.MarshalIndent(m, "", "  ")
+	fmt.Println(string(out))
+}
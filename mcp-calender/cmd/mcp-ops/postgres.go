@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Rows are restored in batches of at most restoreBatchRows rows or about
+// restoreBatchBytes of JSON, whichever comes first
+const (
+	restoreBatchRows  = 500
+	restoreBatchBytes = 8 << 20
+)
+
+const connectTimeout = 10 * time.Second
+
+// queryer is a database or a transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func openPostgres(ctx context.Context, url string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to Postgres: %w", err)
+	}
+	return db, nil
+}
+
+// pgTables returns the tables of the public schema with their columns,
+// every table after the tables its foreign keys reference so that restoring
+// them in order satisfies the keys. Generated columns are left out, they
+// are computed again on restore.
+func pgTables(ctx context.Context, q queryer) ([]TableManifest, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT c.relname, a.attname, format_type(a.atttypid, a.atttypmod)
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_attribute a ON a.attrelid = c.oid
+		WHERE n.nspname = 'public' AND c.relkind = 'r' AND NOT c.relispartition
+		  AND a.attnum > 0 AND NOT a.attisdropped AND a.attgenerated = ''
+		ORDER BY c.relname, a.attnum`)
+	if err != nil {
+		return nil, fmt.Errorf("listing tables: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []TableManifest
+	for rows.Next() {
+		var table string
+		var column Column
+		if err := rows.Scan(&table, &column.Name, &column.Type); err != nil {
+			return nil, err
+		}
+		if len(tables) == 0 || tables[len(tables)-1].Name != table {
+			tables = append(tables, TableManifest{Name: table})
+		}
+		last := &tables[len(tables)-1]
+		last.Columns = append(last.Columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	refRows, err := q.QueryContext(ctx, `
+		SELECT src.relname, dst.relname
+		FROM pg_constraint con
+		JOIN pg_class src ON src.oid = con.conrelid
+		JOIN pg_class dst ON dst.oid = con.confrelid
+		JOIN pg_namespace n ON n.oid = src.relnamespace
+		WHERE con.contype = 'f' AND n.nspname = 'public'`)
+	if err != nil {
+		return nil, fmt.Errorf("listing foreign keys: %w", err)
+	}
+	defer refRows.Close()
+
+	references := map[string][]string{}
+	for refRows.Next() {
+		var from, to string
+		if err := refRows.Scan(&from, &to); err != nil {
+			return nil, err
+		}
+		references[from] = append(references[from], to)
+	}
+	if err := refRows.Err(); err != nil {
+		return nil, err
+	}
+	return sortByReferences(tables, references), nil
+}
+
+// sortByReferences orders tables so that each comes after the tables it
+// references. Tables referencing themselves, or each other in a cycle, keep
+// their relative order.
+func sortByReferences(tables []TableManifest, references map[string][]string) []TableManifest {
+	byName := make(map[string]TableManifest, len(tables))
+	for _, table := range tables {
+		byName[table.Name] = table
+	}
+	sorted := make([]TableManifest, 0, len(tables))
+	visited := map[string]bool{}
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		for _, ref := range references[name] {
+			visit(ref)
+		}
+		if table, ok := byName[name]; ok {
+			sorted = append(sorted, table)
+		}
+	}
+	for _, table := range tables {
+		visit(table.Name)
+	}
+	return sorted
+}
+
+// exportPostgres writes every table of db to a file of dir, all from one
+// snapshot so that the tables are consistent with each other.
+func exportPostgres(ctx context.Context, db *sql.DB, store, dir string) (StoreManifest, []archiveFile, error) {
+	manifest := StoreManifest{Name: store}
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return manifest, nil, err
+	}
+	defer tx.Rollback()
+
+	tables, err := pgTables(ctx, tx)
+	if err != nil {
+		return manifest, nil, err
+	}
+
+	var files []archiveFile
+	for i := range tables {
+		name := store + "/" + tables[i].Name + ".jsonl"
+		f, err := exportFile(dir, name)
+		if err != nil {
+			return manifest, nil, err
+		}
+		rows, err := exportTable(ctx, tx, tables[i], f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return manifest, nil, fmt.Errorf("backing up %s: %w", tables[i].Name, err)
+		}
+		tables[i].Rows = rows
+		files = append(files, archiveFile{name: name, path: f.Name()})
+		log.Printf("Backed up %d rows of %s.%s", rows, store, tables[i].Name)
+	}
+	manifest.Tables = tables
+	return manifest, files, nil
+}
+
+// exportTable writes the rows of table to w as JSON lines and returns how
+// many it wrote.
+func exportTable(ctx context.Context, q queryer, table TableManifest, w io.Writer) (int, error) {
+	query := fmt.Sprintf(`SELECT row_to_json(t)::text FROM (SELECT %s FROM %s) t`,
+		columnList(table), pq.QuoteIdentifier(table.Name))
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	bw := bufio.NewWriter(w)
+	count := 0
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return count, err
+		}
+		bw.WriteString(line)
+		bw.WriteByte('\n')
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	return count, bw.Flush()
+}
+
+func columnList(table TableManifest) string {
+	names := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		names[i] = pq.QuoteIdentifier(column.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// checkPostgres returns why the archived tables of store cannot be restored
+// into db: tables or columns that are missing or of another type, and,
+// unless replace is set, tables that already hold rows. Tables and columns
+// the database has beyond the archive are fine, as after an upgrade of the
+// services; restored rows take their defaults.
+func checkPostgres(ctx context.Context, db *sql.DB, store StoreManifest, replace bool) ([]string, error) {
+	current, err := pgTables(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]TableManifest, len(current))
+	for _, table := range current {
+		byName[table.Name] = table
+	}
+
+	var problems []string
+	for _, table := range store.Tables {
+		target, ok := byName[table.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("table %s does not exist", table.Name))
+			continue
+		}
+		types := make(map[string]string, len(target.Columns))
+		for _, column := range target.Columns {
+			types[column.Name] = column.Type
+		}
+		for _, column := range table.Columns {
+			switch targetType, ok := types[column.Name]; {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("column %s.%s does not exist", table.Name, column.Name))
+			case targetType != column.Type:
+				problems = append(problems, fmt.Sprintf("column %s.%s is %s, the archive has %s", table.Name, column.Name, targetType, column.Type))
+			}
+		}
+
+		if replace {
+			continue
+		}
+		var hasRows bool
+		query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s)`, pq.QuoteIdentifier(table.Name))
+		if err := db.QueryRowContext(ctx, query).Scan(&hasRows); err != nil {
+			return nil, fmt.Errorf("checking %s: %w", table.Name, err)
+		}
+		if hasRows {
+			problems = append(problems, fmt.Sprintf("table %s already has rows", table.Name))
+		}
+	}
+	return problems, nil
+}
+
+// truncateTables empties the archived tables of store, and the tables
+// referencing them, before they are replaced.
+func truncateTables(ctx context.Context, tx *sql.Tx, store StoreManifest) error {
+	if len(store.Tables) == 0 {
+		return nil
+	}
+	names := make([]string, len(store.Tables))
+	for i, table := range store.Tables {
+		names[i] = pq.QuoteIdentifier(table.Name)
+	}
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`TRUNCATE %s RESTART IDENTITY CASCADE`, strings.Join(names, ", ")))
+	return err
+}
+
+// restoreTable inserts the JSON lines of r into table, then moves the
+// table's sequences past the restored ids.
+func restoreTable(ctx context.Context, tx *sql.Tx, table TableManifest, r io.Reader) error {
+	name := pq.QuoteIdentifier(table.Name)
+	columns := columnList(table)
+	insert := fmt.Sprintf(`INSERT INTO %s (%s) OVERRIDING SYSTEM VALUE SELECT %s FROM json_populate_recordset(NULL::%s, $1::json)`,
+		name, columns, columns, name)
+
+	var batch bytes.Buffer
+	batchRows, restored := 0, 0
+	flush := func() error {
+		if batchRows == 0 {
+			return nil
+		}
+		batch.WriteByte(']')
+		if _, err := tx.ExecContext(ctx, insert, batch.String()); err != nil {
+			return err
+		}
+		restored += batchRows
+		batch.Reset()
+		batchRows = 0
+		return nil
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if batchRows == 0 {
+				batch.WriteByte('[')
+			} else {
+				batch.WriteByte(',')
+			}
+			batch.Write(line)
+			batchRows++
+			if batchRows >= restoreBatchRows || batch.Len() >= restoreBatchBytes {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if restored != table.Rows {
+		return fmt.Errorf("archive holds %d rows, its manifest says %d", restored, table.Rows)
+	}
+	return resetSequences(ctx, tx, table)
+}
+
+// resetSequences makes the serial and identity columns of table continue
+// after the largest restored value.
+func resetSequences(ctx context.Context, tx *sql.Tx, table TableManifest) error {
+	name := pq.QuoteIdentifier(table.Name)
+	for _, column := range table.Columns {
+		var sequence sql.NullString
+		if err := tx.QueryRowContext(ctx, `SELECT pg_get_serial_sequence($1, $2)`, name, column.Name).Scan(&sequence); err != nil {
+			return err
+		}
+		if !sequence.Valid {
+			continue
+		}
+		setval := fmt.Sprintf(`SELECT setval($1, COALESCE((SELECT MAX(%s) FROM %s), 0) + 1, false)`,
+			pq.QuoteIdentifier(column.Name), name)
+		if _, err := tx.ExecContext(ctx, setval, sequence.String); err != nil {
+			return fmt.Errorf("resetting %s: %w", sequence.String, err)
+		}
+	}
+	return nil
+}
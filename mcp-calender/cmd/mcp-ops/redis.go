@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisPatterns match the keys holding data that cannot be fetched again:
+// favorite cities, undo history, working memory and weather preferences
+// not yet moved to Postgres. Cached weather and forecasts are left out.
+var redisPatterns = []string{"preferences:*", "favorites:*", "actions:*", "memory:*"}
+
+const redisKeysEntry = redisStore + "/keys.jsonl"
+
+// redisEntry is a key of redis/keys.jsonl
+type redisEntry struct {
+	Key string `json:"key"`
+	// TTLMillis is the time the key had left to live, 0 if it does not
+	// expire
+	TTLMillis int64 `json:"ttl_ms"`
+	// Dump is the value as serialized by DUMP
+	Dump []byte `json:"dump"`
+}
+
+func openRedis(ctx context.Context, s *stores) (*redis.Client, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     s.redisAddr,
+		Password: s.redisPassword,
+		DB:       s.redisDB,
+	})
+	pingCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	if err := client.Ping(pingCtx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connecting to Redis: %w", err)
+	}
+	return client, nil
+}
+
+// scanKeys calls fn for every key matching redisPatterns.
+func scanKeys(ctx context.Context, client *redis.Client, fn func(key string) error) error {
+	for _, pattern := range redisPatterns {
+		iter := client.Scan(ctx, 0, pattern, 1000).Iterator()
+		for iter.Next(ctx) {
+			if err := fn(iter.Val()); err != nil {
+				return err
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportRedis writes the durable keys of Redis to a file of dir.
+func exportRedis(ctx context.Context, client *redis.Client, dir string) (StoreManifest, archiveFile, error) {
+	manifest := StoreManifest{Name: redisStore}
+	file := archiveFile{name: redisKeysEntry}
+
+	f, err := exportFile(dir, redisKeysEntry)
+	if err != nil {
+		return manifest, file, err
+	}
+	defer f.Close()
+	file.path = f.Name()
+
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	err = scanKeys(ctx, client, func(key string) error {
+		dump, err := client.Dump(ctx, key).Result()
+		if err == redis.Nil {
+			// Expired since the scan
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("dumping %s: %w", key, err)
+		}
+		entry := redisEntry{Key: key, Dump: []byte(dump)}
+		ttl, err := client.PTTL(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if ttl > 0 {
+			entry.TTLMillis = ttl.Milliseconds()
+		}
+		manifest.Keys++
+		return enc.Encode(entry)
+	})
+	if err != nil {
+		return manifest, file, err
+	}
+	if err := bw.Flush(); err != nil {
+		return manifest, file, err
+	}
+	log.Printf("Backed up %d Redis keys", manifest.Keys)
+	return manifest, file, f.Close()
+}
+
+// checkRedis returns why the archived keys cannot be restored: unless
+// replace is set, Redis must not hold durable keys yet.
+func checkRedis(ctx context.Context, client *redis.Client, replace bool) ([]string, error) {
+	if replace {
+		return nil, nil
+	}
+	existing := 0
+	err := scanKeys(ctx, client, func(string) error {
+		existing++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return []string{fmt.Sprintf("Redis already holds %d preference, favorite, action history or memory keys", existing)}, nil
+	}
+	return nil, nil
+}
+
+// restoreRedis restores the keys of r, replacing the durable keys Redis
+// holds when replace is set. Keys come back with the time to live they had
+// left at backup time.
+func restoreRedis(ctx context.Context, client *redis.Client, store StoreManifest, r io.Reader, replace bool) error {
+	if replace {
+		err := scanKeys(ctx, client, func(key string) error {
+			return client.Del(ctx, key).Err()
+		})
+		if err != nil {
+			return fmt.Errorf("deleting existing keys: %w", err)
+		}
+	}
+
+	dec := json.NewDecoder(r)
+	restored := 0
+	for {
+		var entry redisEntry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", redisKeysEntry, err)
+		}
+		ttl := time.Duration(entry.TTLMillis) * time.Millisecond
+		if err := client.RestoreReplace(ctx, entry.Key, ttl, string(entry.Dump)).Err(); err != nil {
+			// DUMP payloads only restore into the same or a newer Redis
+			return fmt.Errorf("restoring %s: %w", entry.Key, err)
+		}
+		restored++
+	}
+	if restored != store.Keys {
+		return fmt.Errorf("archive holds %d Redis keys, its manifest says %d", restored, store.Keys)
+	}
+	log.Printf("Restored %d Redis keys", restored)
+	return nil
+}
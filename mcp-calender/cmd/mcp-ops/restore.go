@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type restoreOptions struct {
+	// replace empties the stores before restoring instead of refusing to
+	// restore over existing data
+	replace bool
+	// checkOnly stops after checking the stores
+	checkOnly bool
+}
+
+// restore restores the archive at path. Every store of the archive is
+// checked against its target before anything is written; then each
+// Postgres store is restored in one transaction, and Redis once they have
+// committed.
+func restore(ctx context.Context, s *stores, path string, opts restoreOptions) error {
+	manifest, err := readManifest(path)
+	if err != nil {
+		return err
+	}
+	if manifest.FormatVersion > formatVersion {
+		return fmt.Errorf("archive format %d is newer than this mcp-ops reads (%d)", manifest.FormatVersion, formatVersion)
+	}
+
+	pgURLs := s.postgresStores()
+	databases := map[string]*sql.DB{}
+	defer func() {
+		for _, db := range databases {
+			db.Close()
+		}
+	}()
+	var client *redis.Client
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+
+	stores := map[string]StoreManifest{}
+	var problems []string
+	for _, store := range manifest.Stores {
+		var storeProblems []string
+		switch store.Name {
+		case platformStore, documentsStore:
+			url, ok := pgURLs[store.Name]
+			if !ok {
+				log.Printf("Skipping %s: no database URL given", store.Name)
+				continue
+			}
+			db, err := openPostgres(ctx, url)
+			if err != nil {
+				return fmt.Errorf("%s: %w", store.Name, err)
+			}
+			databases[store.Name] = db
+			if storeProblems, err = checkPostgres(ctx, db, store, opts.replace); err != nil {
+				return fmt.Errorf("%s: %w", store.Name, err)
+			}
+		case redisStore:
+			if s.redisAddr == "" {
+				log.Printf("Skipping %s: no Redis address given", store.Name)
+				continue
+			}
+			if client, err = openRedis(ctx, s); err != nil {
+				return fmt.Errorf("%s: %w", store.Name, err)
+			}
+			if storeProblems, err = checkRedis(ctx, client, opts.replace); err != nil {
+				return fmt.Errorf("%s: %w", store.Name, err)
+			}
+		default:
+			return fmt.Errorf("archive holds an unknown store %q", store.Name)
+		}
+		for _, problem := range storeProblems {
+			problems = append(problems, store.Name+": "+problem)
+		}
+		stores[store.Name] = store
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("the archive does not fit the stores:\n  - %s\n"+
+			"Start the services of the backed-up version once against empty databases to create their tables, "+
+			"or pass -replace to restore over existing data", strings.Join(problems, "\n  - "))
+	}
+	if len(stores) == 0 {
+		return errors.New("no store to restore: give -database-url, -docs-database-url or -redis-url")
+	}
+	if opts.checkOnly {
+		return nil
+	}
+
+	if err := restorePostgres(ctx, path, databases, stores, opts.replace); err != nil {
+		return err
+	}
+
+	if client == nil {
+		return nil
+	}
+	return eachEntry(path, func(name string, r io.Reader) error {
+		if name != redisKeysEntry {
+			return nil
+		}
+		return restoreRedis(ctx, client, stores[redisStore], r, opts.replace)
+	})
+}
+
+// restorePostgres restores the tables of the archive into databases, one
+// transaction per database, and commits once every table is in.
+func restorePostgres(ctx context.Context, path string, databases map[string]*sql.DB, stores map[string]StoreManifest, replace bool) error {
+	txs := map[string]*sql.Tx{}
+	defer func() {
+		for _, tx := range txs {
+			tx.Rollback()
+		}
+	}()
+	for name, db := range databases {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		txs[name] = tx
+		if replace {
+			if err := truncateTables(ctx, tx, stores[name]); err != nil {
+				return fmt.Errorf("%s: emptying tables: %w", name, err)
+			}
+		}
+	}
+
+	restored := map[string]bool{}
+	err := eachEntry(path, func(name string, r io.Reader) error {
+		store, tableName, ok := splitEntry(name)
+		if !ok {
+			return nil
+		}
+		tx, ok := txs[store]
+		if !ok {
+			return nil
+		}
+		table, ok := findTable(stores[store], tableName)
+		if !ok {
+			return fmt.Errorf("archive holds %s, which its manifest does not list", name)
+		}
+		if err := restoreTable(ctx, tx, table, r); err != nil {
+			return fmt.Errorf("restoring %s.%s: %w", store, table.Name, err)
+		}
+		restored[name] = true
+		log.Printf("Restored %d rows of %s.%s", table.Rows, store, table.Name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for name := range txs {
+		for _, table := range stores[name].Tables {
+			if entry := name + "/" + table.Name + ".jsonl"; !restored[entry] {
+				return fmt.Errorf("archive is missing %s", entry)
+			}
+		}
+	}
+
+	for _, name := range []string{platformStore, documentsStore} {
+		if tx, ok := txs[name]; ok {
+			if err := tx.Commit(); err != nil {
+				return fmt.Errorf("%s: committing: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func findTable(store StoreManifest, name string) (TableManifest, bool) {
+	for _, table := range store.Tables {
+		if table.Name == name {
+			return table, true
+		}
+	}
+	return TableManifest{}, false
+}
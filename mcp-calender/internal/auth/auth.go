@@ -0,0 +1,201 @@
+// Package auth issues and verifies the HS256 JWTs that identify users
+// across the services, and scopes requests to the user they carry.
+//
+// Every service shares JWT_SECRET with the auth service. While
+// AUTH_REQUIRED is not "true", requests without a token act as
+// DefaultUser, which owns all data created before identities existed.
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultUser owns requests made without a token when auth is optional.
+const DefaultUser = "default"
+
+// Issuer is the iss claim of the tokens the auth service issues.
+const Issuer = "mcp-auth-service"
+
+// Claims identify the user a token was issued to.
+type Claims struct {
+	// Subject is the user ID.
+	Subject   string `json:"sub"`
+	Email     string `json:"email,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Issuer    string `json:"iss"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Errors returned by Verify.
+var (
+	ErrMalformed = errors.New("malformed token")
+	ErrSignature = errors.New("invalid token signature")
+	ErrExpired   = errors.New("token expired")
+	ErrNoSecret  = errors.New("JWT_SECRET not configured")
+)
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Sign returns the JWT for claims, signed with secret.
+func Sign(claims Claims, secret []byte) (string, error) {
+	if len(secret) == 0 {
+		return "", ErrNoSecret
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + signature(unsigned, secret), nil
+}
+
+// Verify checks a JWT's signature and expiry and returns its claims.
+func Verify(token string, secret []byte) (*Claims, error) {
+	if len(secret) == 0 {
+		return nil, ErrNoSecret
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	// Only HS256 is accepted, whatever else the header claims
+	if err := json.Unmarshal(header, &h); err != nil || h.Alg != "HS256" {
+		return nil, ErrMalformed
+	}
+	want := signature(parts[0]+"."+parts[1], secret)
+	if !hmac.Equal([]byte(parts[2]), []byte(want)) {
+		return nil, ErrSignature
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return nil, ErrMalformed
+	}
+	// A token without an expiry would be valid for ever
+	if claims.ExpiresAt == 0 {
+		return nil, ErrMalformed
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+	return &claims, nil
+}
+
+func signature(unsigned string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Config is how a service authenticates requests.
+type Config struct {
+	Secret []byte
+	// Required rejects requests without a valid token; otherwise they
+	// act as DefaultUser.
+	Required bool
+}
+
+// ConfigFromEnv reads JWT_SECRET and AUTH_REQUIRED.
+func ConfigFromEnv() Config {
+	return Config{
+		Secret:   []byte(os.Getenv("JWT_SECRET")),
+		Required: os.Getenv("AUTH_REQUIRED") == "true",
+	}
+}
+
+type contextKey struct{}
+
+// Middleware puts the claims of the request's bearer token in its
+// context. Invalid tokens are always rejected; missing ones only when
+// auth is required. Paths in public, such as /health and /metrics, pass
+// through untouched.
+func (c Config) Middleware(public ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, p := range public {
+				if r.URL.Path == p {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			claims, err := c.Authenticate(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="mcp"`)
+				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// Authenticate returns the claims of the request's bearer token, or
+// those of DefaultUser when there is none and auth is optional.
+func (c Config) Authenticate(r *http.Request) (*Claims, error) {
+	token := BearerToken(r)
+	if token == "" {
+		if c.Required {
+			return nil, errors.New("missing bearer token")
+		}
+		return &Claims{Subject: DefaultUser}, nil
+	}
+	claims, err := Verify(token, c.Secret)
+	if err != nil {
+		return nil, err
+	}
+	// Services sign short-lived tokens of their own, such as OAuth
+	// state; only the auth service's tokens identify a caller
+	if claims.Issuer != Issuer {
+		return nil, errors.New("token not issued by the auth service")
+	}
+	return claims, nil
+}
+
+// BearerToken returns the token of the request's Authorization header.
+func BearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// WithClaims returns ctx carrying claims.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the claims Middleware stored in ctx.
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*Claims)
+	return claims, ok
+}
+
+// UserID returns the ID of the user a request acts as, DefaultUser when
+// it was not authenticated.
+func UserID(r *http.Request) string {
+	if claims, ok := FromContext(r.Context()); ok {
+		return claims.Subject
+	}
+	return DefaultUser
+}
@@ -0,0 +1,190 @@
+// Package clock is the virtual clock of the mock data of the services, so
+// that integration tests and demos can set "now" instead of sleeping.
+//
+// The calendar service lays its mock events out from the time the clock
+// was set to, and the weather service dates its mock weather by the
+// clock's now. Setting the clock to a time and then advancing it moves now
+// past the mock data: 50 minutes after being set, the mock event an hour
+// out starts in 10 minutes. The clock only ever applies to mock data;
+// Google Calendar and OpenWeatherMap are asked about the real time.
+//
+// MOCK_NOW, an RFC3339 time, sets the clock when the service starts.
+// MOCK_NOW or MOCK_TIME_TRAVEL=true serves the clock on /mock/clock, where
+// tests read, set, advance and reset it. The clock is the service's, not a
+// user's, so it is never enabled outside tests and demos.
+package clock
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Clock is a virtual clock, which tells the real time until it is set.
+type Clock struct {
+	// Enabled clocks are served on /mock/clock
+	Enabled bool
+
+	mu  sync.Mutex
+	set bool
+	// start is the time the clock was last set to
+	start time.Time
+	// now was the virtual time at realAt
+	now    time.Time
+	realAt time.Time
+	// A running clock goes on from now at the pace of the real one; a
+	// stopped one stays at now until it is set or advanced
+	running bool
+}
+
+// FromEnv returns the clock set to MOCK_NOW, if any, and enabled by it or
+// MOCK_TIME_TRAVEL.
+func FromEnv() *Clock {
+	c := &Clock{Enabled: os.Getenv("MOCK_TIME_TRAVEL") == "true"}
+	if value := os.Getenv("MOCK_NOW"); value != "" {
+		now, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Printf("Invalid MOCK_NOW %q, using the real time: %v", value, err)
+			return c
+		}
+		c.Enabled = true
+		c.Set(now, false)
+		log.Printf("Mock data is at %s", now.Format(time.RFC3339))
+	}
+	return c
+}
+
+// Now returns the virtual time, or the real one while the clock is not set.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nowLocked()
+}
+
+func (c *Clock) nowLocked() time.Time {
+	if !c.set {
+		return time.Now()
+	}
+	if c.running {
+		return c.now.Add(time.Since(c.realAt))
+	}
+	return c.now
+}
+
+// Start returns the time the clock was set to, which mock data is laid out
+// from, or the real time while it is not set.
+func (c *Clock) Start() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.set {
+		return time.Now()
+	}
+	return c.start
+}
+
+// Set sets the clock to now, stopped unless running.
+func (c *Clock) Set(now time.Time, running bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set, c.start, c.now, c.realAt, c.running = true, now, now, time.Now(), running
+}
+
+// Advance moves the clock d ahead, leaving the time it was set to alone;
+// a clock that is not set is set to the real time first.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.nowLocked()
+	if !c.set {
+		c.set, c.start = true, now
+	}
+	c.now, c.realAt = now.Add(d), time.Now()
+}
+
+// Reset puts the clock back on the real time.
+func (c *Clock) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set, c.running = false, false
+}
+
+// State is the body of /mock/clock.
+type State struct {
+	Now   time.Time `json:"now"`
+	Start time.Time `json:"start"`
+	// Virtual is false while the clock tells the real time
+	Virtual bool `json:"virtual"`
+	Running bool `json:"running"`
+}
+
+// SetRequest is the body of PUT /mock/clock. Now sets the clock, Advance
+// (a Go duration such as "50m") moves it ahead, after Now when both are
+// given, and Running keeps it going at the real pace.
+type SetRequest struct {
+	Now     *time.Time `json:"now"`
+	Advance string     `json:"advance"`
+	Running bool       `json:"running"`
+}
+
+func (c *Clock) state() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := State{Now: c.nowLocked(), Start: c.start, Virtual: c.set, Running: c.running || !c.set}
+	if !c.set {
+		state.Start = state.Now
+	}
+	return state
+}
+
+// Handle serves /mock/clock: GET returns the clock, PUT sets or advances it
+// and DELETE puts it back on the real time, each answering with the clock.
+func (c *Clock) Handle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req SetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		var advance time.Duration
+		if req.Advance != "" {
+			d, err := time.ParseDuration(req.Advance)
+			if err != nil || d < 0 {
+				http.Error(w, "advance must be a positive duration such as 50m", http.StatusBadRequest)
+				return
+			}
+			advance = d
+		}
+		if req.Now == nil && req.Advance == "" {
+			http.Error(w, "now or advance is required", http.StatusBadRequest)
+			return
+		}
+
+		if req.Now != nil {
+			c.Set(*req.Now, req.Running)
+		}
+		if advance > 0 {
+			c.Advance(advance)
+		}
+		c.mu.Lock()
+		if c.running != req.Running {
+			c.now, c.realAt, c.running = c.nowLocked(), time.Now(), req.Running
+		}
+		c.mu.Unlock()
+		log.Printf("Mock data is at %s", c.Now().Format(time.RFC3339))
+	case http.MethodDelete:
+		c.Reset()
+		log.Println("Mock data is at the real time")
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.state())
+}
@@ -0,0 +1,18 @@
+// Package database holds what the services storing their data in
+// Postgres share.
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// Timeout bounds the database work of a request, which also stops when
+// the client goes away.
+const Timeout = 5 * time.Second
+
+// WithTimeout returns a copy of ctx that is done after Timeout at the
+// latest.
+func WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, Timeout)
+}
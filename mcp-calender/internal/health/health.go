@@ -0,0 +1,237 @@
+// Package health lets a service wait for its dependencies when it starts
+// and report liveness and readiness separately.
+//
+// Containers start in any order, so a service starts serving /health/live
+// at once, waits with backoff for the dependencies it needs, and only then
+// reports ready on /health/ready. Liveness never checks dependencies: a
+// database outage should take a service out of load balancing, not get it
+// restarted.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backoff between checks of a dependency that is not up yet
+const (
+	initialBackoff = 500 * time.Millisecond
+	maxBackoff     = 10 * time.Second
+)
+
+// checkTimeout bounds a single check of a dependency
+const checkTimeout = 2 * time.Second
+
+// DefaultStartupTimeout is how long a service waits for its dependencies
+// unless STARTUP_TIMEOUT says otherwise
+const DefaultStartupTimeout = 60 * time.Second
+
+// Dependency is something a service needs to serve requests
+type Dependency struct {
+	Name  string
+	Check func(ctx context.Context) error
+	// Optional dependencies only degrade the service: it starts without
+	// them once the startup timeout has passed, and reports ready while
+	// they are down
+	Optional bool
+}
+
+// Paths are the health endpoints a Checker serves: /health/live answers
+// liveness probes, /health/ready and /health readiness ones
+var Paths = []string{"/health", "/health/live", "/health/ready"}
+
+// PublicPaths returns Paths and extra, the paths a service serves without
+// authentication.
+func PublicPaths(extra ...string) []string {
+	return append(append([]string(nil), Paths...), extra...)
+}
+
+// Checker tracks the dependencies of a service and whether it has started
+type Checker struct {
+	service      string
+	dependencies []Dependency
+	started      atomic.Bool
+}
+
+// NewChecker returns a checker for the dependencies of service.
+func NewChecker(service string, dependencies ...Dependency) *Checker {
+	return &Checker{service: service, dependencies: dependencies}
+}
+
+// StartupTimeoutFromEnv reads how long to wait for dependencies from
+// STARTUP_TIMEOUT, a Go duration such as "90s".
+func StartupTimeoutFromEnv() time.Duration {
+	if value := os.Getenv("STARTUP_TIMEOUT"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid STARTUP_TIMEOUT %q, using %s", value, DefaultStartupTimeout)
+	}
+	return DefaultStartupTimeout
+}
+
+// WaitForDependencies checks every dependency until it is up, backing off
+// exponentially, for at most timeout. It fails if a required dependency is
+// still down by then; optional ones are only logged.
+func (c *Checker) WaitForDependencies(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	errs := make([]error, len(c.dependencies))
+	var wg sync.WaitGroup
+	for i, dep := range c.dependencies {
+		wg.Add(1)
+		go func(i int, dep Dependency) {
+			defer wg.Done()
+			errs[i] = waitFor(ctx, dep)
+		}(i, dep)
+	}
+	wg.Wait()
+
+	for i, dep := range c.dependencies {
+		if errs[i] == nil {
+			continue
+		}
+		if !dep.Optional {
+			return fmt.Errorf("%s not available after %s: %w", dep.Name, timeout, errs[i])
+		}
+		log.Printf("Warning: %s not available after %s, starting without it: %v", dep.Name, timeout, errs[i])
+	}
+	return nil
+}
+
+// waitFor retries the check of dep until it passes or ctx ends, returning
+// the last error.
+func waitFor(ctx context.Context, dep Dependency) error {
+	backoff := initialBackoff
+	for {
+		err := check(ctx, dep)
+		if err == nil {
+			log.Printf("%s is available", dep.Name)
+			return nil
+		}
+		log.Printf("Waiting for %s: %v; retrying in %s", dep.Name, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func check(ctx context.Context, dep Dependency) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	return dep.Check(ctx)
+}
+
+// Start waits for the dependencies for StartupTimeoutFromEnv, then runs
+// setup, such as creating tables, and reports the service ready. Liveness
+// probes are answered meanwhile.
+func (c *Checker) Start(ctx context.Context, setup ...func() error) error {
+	if err := c.WaitForDependencies(ctx, StartupTimeoutFromEnv()); err != nil {
+		return err
+	}
+	for _, f := range setup {
+		if err := f(); err != nil {
+			return fmt.Errorf("setup: %w", err)
+		}
+	}
+	c.MarkStarted()
+	return nil
+}
+
+// MarkStarted reports the service ready once its startup, such as creating
+// tables, is done.
+func (c *Checker) MarkStarted() {
+	c.started.Store(true)
+	log.Printf("%s is ready", c.service)
+}
+
+// Status is the body of the health endpoints
+type Status struct {
+	// Status is alive, starting, ready, degraded or unavailable
+	Status string `json:"status"`
+	// Dependencies maps each dependency to "ok" or why its check failed
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+}
+
+// ServeHTTP serves Paths.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/health/live":
+		c.HandleLive(w, r)
+	case "/health", "/health/ready":
+		c.HandleReady(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// HandleLive answers liveness probes: the process is up and serving HTTP.
+func (c *Checker) HandleLive(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, Status{Status: "alive"})
+}
+
+// HandleReady answers readiness probes. The service is ready once it has
+// started and its required dependencies are up; down optional ones make it
+// degraded but still ready.
+func (c *Checker) HandleReady(w http.ResponseWriter, r *http.Request) {
+	if !c.started.Load() {
+		writeStatus(w, http.StatusServiceUnavailable, Status{Status: "starting"})
+		return
+	}
+
+	status := Status{Status: "ready", Dependencies: map[string]string{}}
+	code := http.StatusOK
+	for _, dep := range c.dependencies {
+		if err := check(r.Context(), dep); err != nil {
+			status.Dependencies[dep.Name] = err.Error()
+			if !dep.Optional {
+				status.Status, code = "unavailable", http.StatusServiceUnavailable
+			} else if code == http.StatusOK {
+				status.Status = "degraded"
+			}
+			continue
+		}
+		status.Dependencies[dep.Name] = "ok"
+	}
+	writeStatus(w, code, status)
+}
+
+func writeStatus(w http.ResponseWriter, code int, status Status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// HTTPCheck checks a dependency by a GET of url, typically the readiness
+// endpoint of another service, which must answer 2xx.
+func HTTPCheck(url string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("%s returned %s", url, resp.Status)
+		}
+		return nil
+	}
+}
@@ -91,6 +91,20 @@ for file in "$CONFIG_DIR"/*.env.sample; do
     fi
 done
 
+# Every service verifies user tokens with the same secret; keep the one
+# already configured when rerun
+JWT_SECRET=$(grep -h "^JWT_SECRET=" "$CONFIG_DIR"/*.env 2>/dev/null | grep -v your-jwt-secret-here | head -n 1 | cut -d= -f2)
+if [[ -z "$JWT_SECRET" ]]; then
+    JWT_SECRET=$(openssl rand -hex 32 2>/dev/null || head -c 32 /dev/urandom | od -An -tx1 | tr -d ' \n')
+fi
+for file in "$CONFIG_DIR"/*.env "$CONFIG_DIR/environment.sh"; do
+    if [[ -f "$file" ]]; then
+        sed -i.bak "s/your-jwt-secret-here/$JWT_SECRET/g" "$file"
+        rm "$file.bak"
+    fi
+done
+echo -e "✅ Configured a shared JWT_SECRET for the auth service"
+
 echo ""
 
 # API Credentials setup
@@ -219,7 +233,19 @@ echo ""
 echo "   # Terminal 3: Weather Service"
 echo "   source config/weather-service.env && cd services/weather-service && go run main.go"
 echo ""
-echo "   # Terminal 4: MCP Server"
+echo "   # Terminal 4: Notification Service"
+echo "   source config/notification-service.env && cd services/notification-service && go run ."
+echo ""
+echo "   # Terminal 5: Auth Service"
+echo "   source config/auth-service.env && cd services/auth-service && go run main.go"
+echo ""
+echo "   # Terminal 6: Rules Service"
+echo "   source config/rules-service.env && cd services/rules-service && go run ."
+echo ""
+echo "   # Terminal 7: GitHub Sync Service"
+echo "   source config/github-sync-service.env && cd services/github-sync-service && go run ."
+echo ""
+echo "   # Terminal 8: MCP Server"
 echo "   source config/mcp-server.env && cd services/mcp-server && go run main.go"
 echo ""
 echo "3. Or use Kubernetes deployment:"
@@ -230,6 +256,9 @@ echo "   curl http://localhost:8080/tools/list"
 echo "   curl http://localhost:8081/health"
 echo "   curl http://localhost:8082/health"
 echo "   curl http://localhost:8083/health"
+echo "   curl http://localhost:8084/health"
+echo "   curl http://localhost:8085/health"
+echo "   curl http://localhost:8086/health"
 echo ""
 echo -e "${BLUE}📚 Documentation:${NC}"
 echo "   • README.md - Complete project documentation"
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Events may have Google Drive files attached, given when the event is
+// created or added later with POST /events/{id}/attachments. Google
+// Calendar only takes Drive files, named by their URL or their file ID.
+
+// maxAttachments is the most files Google Calendar attaches to an event
+const maxAttachments = 25
+
+// errTooManyAttachments is returned when files would take an event past
+// maxAttachments
+var errTooManyAttachments = fmt.Errorf("at most %d attachments are allowed", maxAttachments)
+
+// Attachment is a Drive file attached to an event
+type Attachment struct {
+	FileID   string `json:"file_id,omitempty"`
+	FileURL  string `json:"file_url"`
+	Title    string `json:"title,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	IconLink string `json:"icon_link,omitempty"`
+}
+
+// AttachRequest is the body of POST /events/{id}/attachments
+type AttachRequest struct {
+	Attachments []Attachment `json:"attachments"`
+}
+
+// prepareAttachments checks the attachments of a request and fills in the
+// URL of those only given a file ID, returning why they are invalid or "".
+func prepareAttachments(attachments []Attachment) string {
+	if len(attachments) > maxAttachments {
+		return fmt.Sprintf("At most %d attachments are allowed", maxAttachments)
+	}
+	for i := range attachments {
+		a := &attachments[i]
+		if a.FileURL == "" && a.FileID == "" {
+			return fmt.Sprintf("Attachment %d: file_url or file_id is required", i+1)
+		}
+		if a.FileURL == "" {
+			a.FileURL = "https://drive.google.com/open?id=" + url.QueryEscape(a.FileID)
+			continue
+		}
+		u, err := url.Parse(a.FileURL)
+		if err != nil || u.Scheme != "https" || (u.Host != "drive.google.com" && u.Host != "docs.google.com") {
+			return fmt.Sprintf("Attachment %d: file_url must be a Google Drive link", i+1)
+		}
+	}
+	return ""
+}
+
+// googleAttachments converts attachments to those of a Google event.
+func googleAttachments(attachments []Attachment) []*calendar.EventAttachment {
+	var items []*calendar.EventAttachment
+	for _, a := range attachments {
+		items = append(items, &calendar.EventAttachment{
+			FileId:   a.FileID,
+			FileUrl:  a.FileURL,
+			Title:    a.Title,
+			MimeType: a.MimeType,
+		})
+	}
+	return items
+}
+
+// convertAttachments converts the attachments of a Google event.
+func convertAttachments(items []*calendar.EventAttachment) []Attachment {
+	var attachments []Attachment
+	for _, item := range items {
+		attachments = append(attachments, Attachment{
+			FileID:   item.FileId,
+			FileURL:  item.FileUrl,
+			Title:    item.Title,
+			MimeType: item.MimeType,
+			IconLink: item.IconLink,
+		})
+	}
+	return attachments
+}
+
+// handleAttachFiles adds Drive files to an existing event, keeping those
+// it has. A file attached already is not added again.
+func handleAttachFiles(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		calendarRequestDuration.WithLabelValues("POST", "/events/:id/attachments").Observe(time.Since(start).Seconds())
+	}()
+
+	id := mux.Vars(r)["id"]
+	var req AttachRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		calendarRequestsTotal.WithLabelValues("POST", "/events/:id/attachments", "error").Inc()
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	msg := prepareAttachments(req.Attachments)
+	if msg == "" && len(req.Attachments) == 0 {
+		msg = "At least one attachment is required"
+	}
+	if msg != "" {
+		calendarRequestsTotal.WithLabelValues("POST", "/events/:id/attachments", "error").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	// Mock events are not stored: the event is returned with the files
+	// but keeps none of them
+	token := getGoogleToken(r)
+	if token == nil {
+		calendarRequestsTotal.WithLabelValues("POST", "/events/:id/attachments", "mock").Inc()
+		for _, event := range getMockEvents("", "") {
+			if event.ID == id {
+				event.Attachments = mergeAttachments(event.Attachments, req.Attachments)
+				writeJSONResponse(w, event)
+				return
+			}
+		}
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+
+	event, err := attachGoogleFiles(r.Context(), token, id, req.Attachments)
+	if errors.Is(err, errTooManyAttachments) {
+		calendarRequestsTotal.WithLabelValues("POST", "/events/:id/attachments", "error").Inc()
+		http.Error(w, fmt.Sprintf("The event would have more than %d attachments", maxAttachments), http.StatusBadRequest)
+		return
+	}
+	if isNotFound(err) {
+		calendarRequestsTotal.WithLabelValues("POST", "/events/:id/attachments", "error").Inc()
+		googleAPICallsTotal.WithLabelValues("attach_files", "not_found").Inc()
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		calendarRequestsTotal.WithLabelValues("POST", "/events/:id/attachments", "error").Inc()
+		googleAPICallsTotal.WithLabelValues("attach_files", "error").Inc()
+		http.Error(w, fmt.Sprintf("Failed to attach files: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	calendarRequestsTotal.WithLabelValues("POST", "/events/:id/attachments", "success").Inc()
+	googleAPICallsTotal.WithLabelValues("attach_files", "success").Inc()
+	writeJSONResponse(w, event)
+}
+
+// mergeAttachments adds the files of added that existing lacks, by URL.
+func mergeAttachments(existing, added []Attachment) []Attachment {
+	merged := append([]Attachment(nil), existing...)
+	for _, a := range added {
+		found := false
+		for _, e := range merged {
+			if strings.EqualFold(e.FileURL, a.FileURL) || (a.FileID != "" && e.FileID == a.FileID) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, a)
+		}
+	}
+	return merged
+}
+
+// attachGoogleFiles adds attachments to a Google event. The event's list
+// of attachments is replaced as a whole, so it is read first.
+func attachGoogleFiles(ctx context.Context, token *oauth2.Token, id string, attachments []Attachment) (*Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, googleAPITimeout)
+	defer cancel()
+
+	service, err := newCalendarService(ctx, token, "attach_files")
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := service.Events.Get("primary", id).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	merged := mergeAttachments(convertAttachments(item.Attachments), attachments)
+	if len(merged) > maxAttachments {
+		return nil, errTooManyAttachments
+	}
+
+	patched, err := service.Events.Patch("primary", id, &calendar.Event{Attachments: googleAttachments(merged)}).
+		SupportsAttachments(true).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	event := convertGoogleEvent(patched)
+	return &event, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+)
+
+// primaryCalendar is the ID Google gives the user's own calendar, the one
+// events are read from and written to by default
+const primaryCalendar = "primary"
+
+// Calendar is a calendar of the user's calendar list
+type Calendar struct {
+	ID      string `json:"id"`
+	Summary string `json:"summary"`
+	// Primary is set on the user's own calendar
+	Primary bool `json:"primary,omitempty"`
+	// AccessRole is owner, writer, reader or freeBusyReader
+	AccessRole string `json:"access_role,omitempty"`
+}
+
+// handleListCalendars lists the calendars the user can read events of,
+// for picking the calendar_id of GET /events.
+func handleListCalendars(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		calendarRequestDuration.WithLabelValues("GET", "/calendars").Observe(time.Since(start).Seconds())
+	}()
+
+	token := getGoogleToken(r)
+	if token == nil {
+		calendarRequestsTotal.WithLabelValues("GET", "/calendars", "mock").Inc()
+		writeJSONResponse(w, map[string]interface{}{"calendars": getMockCalendars()})
+		return
+	}
+
+	calendars, err := getGoogleCalendars(r.Context(), token)
+	if err != nil {
+		calendarRequestsTotal.WithLabelValues("GET", "/calendars", "error").Inc()
+		googleAPICallsTotal.WithLabelValues("list_calendars", "error").Inc()
+		http.Error(w, fmt.Sprintf("Failed to get calendars: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	calendarRequestsTotal.WithLabelValues("GET", "/calendars", "success").Inc()
+	googleAPICallsTotal.WithLabelValues("list_calendars", "success").Inc()
+	writeJSONResponse(w, map[string]interface{}{"calendars": calendars})
+}
+
+func getGoogleCalendars(ctx context.Context, token *oauth2.Token) ([]Calendar, error) {
+	ctx, cancel := context.WithTimeout(ctx, googleAPITimeout)
+	defer cancel()
+
+	service, err := newCalendarService(ctx, token, "list_calendars")
+	if err != nil {
+		return nil, err
+	}
+
+	calendars := []Calendar{}
+	err = service.CalendarList.List().Context(ctx).Pages(ctx, func(list *calendar.CalendarList) error {
+		for _, item := range list.Items {
+			summary := item.SummaryOverride
+			if summary == "" {
+				summary = item.Summary
+			}
+			calendars = append(calendars, Calendar{
+				ID:         item.Id,
+				Summary:    summary,
+				Primary:    item.Primary,
+				AccessRole: item.AccessRole,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return calendars, nil
+}
+
+func getMockCalendars() []Calendar {
+	return []Calendar{
+		{ID: primaryCalendar, Summary: "Personal", Primary: true, AccessRole: "owner"},
+		{ID: "team@group.calendar.google.com", Summary: "Team", AccessRole: "writer"},
+		{ID: "en.usa#holiday@group.v.calendar.google.com", Summary: "Holidays", AccessRole: "reader"},
+	}
+}
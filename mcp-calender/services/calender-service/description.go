@@ -0,0 +1,243 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Google Calendar keeps the descriptions of invites as HTML, with links,
+// bold text, lists and line breaks. Events carry the description as
+// Markdown, which agents and chat clients read and render, and the HTML
+// as it was in description_html so that nothing is lost.
+
+// htmlTagPattern matches the tags descriptions are formatted with
+var htmlTagPattern = regexp.MustCompile(`(?i)<(br|p|div|span|b|strong|i|em|u|a|ul|ol|li|h[1-6]|pre|code)\b[^>]*>`)
+
+// blankLinesPattern matches the runs of empty lines block elements leave
+var blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+
+// eventDescription returns the Markdown of a description and, when it was
+// HTML, the HTML; plain text is returned as it is.
+func eventDescription(description string) (markdown, htmlDescription string) {
+	if !htmlTagPattern.MatchString(description) {
+		return description, ""
+	}
+	return htmlToMarkdown(description), description
+}
+
+// htmlToMarkdown converts the HTML of a description to Markdown. Tags
+// Markdown has nothing for, such as underline and spans, keep only their
+// text.
+func htmlToMarkdown(source string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(source), &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div})
+	if err != nil {
+		return source
+	}
+	w := &markdownWriter{}
+	for _, node := range nodes {
+		w.node(node)
+	}
+
+	lines := strings.Split(w.b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// markdownWriter writes the Markdown of HTML nodes
+type markdownWriter struct {
+	b strings.Builder
+	// lists are the kinds of the lists being written, innermost last,
+	// with the number of the next item of ordered ones
+	lists []listState
+	pre   bool
+}
+
+type listState struct {
+	ordered bool
+	next    int
+}
+
+func (w *markdownWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		w.children(n)
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		w.b.WriteString("\n")
+	case atom.P, atom.Div:
+		w.block()
+		w.children(n)
+		w.block()
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		w.block()
+		w.b.WriteString(strings.Repeat("#", int(n.Data[1]-'0')) + " ")
+		w.children(n)
+		w.block()
+	case atom.B, atom.Strong:
+		w.wrap(n, "**")
+	case atom.I, atom.Em:
+		w.wrap(n, "_")
+	case atom.Code:
+		if w.pre {
+			w.children(n)
+		} else {
+			w.wrap(n, "`")
+		}
+	case atom.Pre:
+		w.block()
+		w.b.WriteString("```\n")
+		w.pre = true
+		w.children(n)
+		w.pre = false
+		w.b.WriteString("\n```")
+		w.block()
+	case atom.A:
+		w.link(n)
+	case atom.Ul, atom.Ol:
+		w.line()
+		w.lists = append(w.lists, listState{ordered: n.DataAtom == atom.Ol, next: 1})
+		w.children(n)
+		w.lists = w.lists[:len(w.lists)-1]
+		w.line()
+	case atom.Li:
+		w.item(n)
+	default:
+		w.children(n)
+	}
+}
+
+func (w *markdownWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.node(c)
+	}
+}
+
+// text writes text with its runs of whitespace collapsed, as a browser
+// shows it, except in preformatted blocks.
+func (w *markdownWriter) text(s string) {
+	if w.pre {
+		w.b.WriteString(s)
+		return
+	}
+	fields := strings.Fields(s)
+	if len(fields) == 0 || isSpace(s[0]) {
+		w.space()
+	}
+	if len(fields) == 0 {
+		return
+	}
+	w.b.WriteString(strings.Join(fields, " "))
+	if isSpace(s[len(s)-1]) {
+		w.space()
+	}
+}
+
+// space separates words, once and not at the start of a line.
+func (w *markdownWriter) space() {
+	s := w.b.String()
+	if s != "" && !strings.HasSuffix(s, "\n") && !strings.HasSuffix(s, " ") {
+		w.b.WriteString(" ")
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\t' || c == '\r'
+}
+
+// wrap writes the text of n between marker, leaving out empty elements.
+func (w *markdownWriter) wrap(n *html.Node, marker string) {
+	inner := &markdownWriter{lists: w.lists, pre: w.pre}
+	inner.children(n)
+	text := strings.TrimSpace(inner.b.String())
+	if text == "" {
+		return
+	}
+	w.b.WriteString(marker + text + marker)
+}
+
+// link writes a link, with the target of Google's redirect links rather
+// than the redirect.
+func (w *markdownWriter) link(n *html.Node) {
+	href := ""
+	for _, attr := range n.Attr {
+		if attr.Key == "href" {
+			href = unwrapRedirect(attr.Val)
+		}
+	}
+	inner := &markdownWriter{lists: w.lists}
+	inner.children(n)
+	text := strings.TrimSpace(inner.b.String())
+	switch {
+	case href == "":
+		w.b.WriteString(text)
+	case text == "" || text == href:
+		w.b.WriteString("<" + href + ">")
+	default:
+		w.b.WriteString("[" + text + "](" + href + ")")
+	}
+}
+
+// item writes a list item, indented two spaces per enclosing list.
+func (w *markdownWriter) item(n *html.Node) {
+	w.line()
+	depth := len(w.lists)
+	marker := "- "
+	if depth > 0 && w.lists[depth-1].ordered {
+		marker = strconv.Itoa(w.lists[depth-1].next) + ". "
+		w.lists[depth-1].next++
+	}
+	if depth > 1 {
+		w.b.WriteString(strings.Repeat("  ", depth-1))
+	}
+	w.b.WriteString(marker)
+	w.children(n)
+	w.line()
+}
+
+// line ends the current line, if anything is on it.
+func (w *markdownWriter) line() {
+	if !w.atLineStart() {
+		w.b.WriteString("\n")
+	}
+}
+
+// block starts a paragraph: a blank line after whatever came before.
+func (w *markdownWriter) block() {
+	if w.b.Len() == 0 {
+		return
+	}
+	w.line()
+	w.b.WriteString("\n")
+}
+
+func (w *markdownWriter) atLineStart() bool {
+	s := w.b.String()
+	return s == "" || strings.HasSuffix(s, "\n")
+}
+
+// unwrapRedirect returns the target of a https://www.google.com/url?q=...
+// link, which Google puts around the links of invites, or href itself.
+func unwrapRedirect(href string) string {
+	u, err := url.Parse(href)
+	if err != nil || u.Host != "www.google.com" || u.Path != "/url" {
+		return href
+	}
+	if target := u.Query().Get("q"); target != "" {
+		return target
+	}
+	return href
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Follow-ups: meetings marked with one of the follow-up keywords, such as
+// "#followup" in their title or description, get a task to send their
+// notes once they have ended. Each task is linked to its event as the
+// external item "followup:<calendar>:<event>", which task-service keeps
+// unique per user, so checking the same meetings again, or from two
+// places at once, never creates a second task, even after the first was
+// completed or edited.
+
+// followUpSource is the external_source of follow-up tasks
+const followUpSource = "calendar"
+
+// Task service the follow-up tasks are created in
+var tasksClient = client.NewTasksClient(getEnv("TASK_SERVICE_URL", "http://task-service:8081"))
+
+// followUpKeywords mark a meeting as needing a follow-up when its summary
+// or description contains one of them, ignoring case
+var followUpKeywords = splitKeywords(getEnv("FOLLOWUP_KEYWORDS", "#followup,#follow-up"))
+
+// followUpLookback is how long ago the meetings checked may have ended
+var followUpLookback = parseDurationEnv("FOLLOWUP_LOOKBACK", 24*time.Hour)
+
+var followUpsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "calendar_followups_total",
+		Help: "Total number of ended meetings checked for a follow-up task, by outcome",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(followUpsTotal)
+}
+
+// FollowUpRequest is the body of POST /followups, all optional
+type FollowUpRequest struct {
+	// Since is the earliest end of the meetings checked, RFC3339;
+	// FOLLOWUP_LOOKBACK ago by default
+	Since string `json:"since"`
+	// Keywords replace FOLLOWUP_KEYWORDS
+	Keywords   []string `json:"keywords"`
+	CalendarID string   `json:"calendar_id"`
+}
+
+// FollowUpResult is the response of POST /followups
+type FollowUpResult struct {
+	// Matched counts the ended meetings with a keyword
+	Matched int           `json:"matched"`
+	Created []client.Task `json:"created"`
+	// Existing counts the meetings that already had their follow-up
+	Existing int `json:"existing"`
+}
+
+// handleCreateFollowUps creates a task to send the notes of each meeting
+// with a follow-up keyword that ended between since and now, unless it
+// already has one. It answers 200 OK with the tasks created; a failure to
+// create one stops the others, which a later call picks up.
+func handleCreateFollowUps(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		calendarRequestDuration.WithLabelValues("POST", "/followups").Observe(time.Since(start).Seconds())
+	}()
+
+	var req FollowUpRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil && err != io.EOF {
+		calendarRequestsTotal.WithLabelValues("POST", "/followups", "error").Inc()
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	token := getGoogleToken(r)
+	now := time.Now().UTC()
+	if token == nil {
+		now = mockClock.Now().UTC()
+	}
+	since := now.Add(-followUpLookback)
+	if req.Since != "" {
+		parsed, err := time.Parse(time.RFC3339, req.Since)
+		if err != nil || !parsed.Before(now) {
+			calendarRequestsTotal.WithLabelValues("POST", "/followups", "error").Inc()
+			http.Error(w, "since must be an RFC3339 time in the past", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+	keywords := followUpKeywords
+	if req.Keywords != nil {
+		keywords = splitKeywords(strings.Join(req.Keywords, ","))
+	}
+	if len(keywords) == 0 {
+		calendarRequestsTotal.WithLabelValues("POST", "/followups", "error").Inc()
+		http.Error(w, "At least one keyword is required", http.StatusBadRequest)
+		return
+	}
+	calendarID := req.CalendarID
+	if calendarID == "" {
+		calendarID = primaryCalendar
+	}
+
+	var events []Event
+	status := "success"
+	if token == nil {
+		status = "mock"
+		events = getMockEvents("", "")
+	} else {
+		var err error
+		// Google bounds the end of events by the start date, and their start
+		// by the end date
+		events, err = getGoogleCalendarEvents(r.Context(), token, calendarID, since.Format(time.RFC3339), now.Format(time.RFC3339))
+		if err != nil {
+			calendarRequestsTotal.WithLabelValues("POST", "/followups", "error").Inc()
+			googleAPICallsTotal.WithLabelValues("list_events", "error").Inc()
+			http.Error(w, fmt.Sprintf("Failed to get events: %v", err), http.StatusInternalServerError)
+			return
+		}
+		googleAPICallsTotal.WithLabelValues("list_events", "success").Inc()
+	}
+
+	ctx := client.WithToken(r.Context(), auth.BearerToken(r))
+	result := FollowUpResult{Created: []client.Task{}}
+	for _, event := range events {
+		if event.End.Before(since) || event.End.After(now) || !needsFollowUp(event, keywords) {
+			continue
+		}
+		result.Matched++
+		task, err := tasksClient.Create(ctx, followUpTask(calendarID, event))
+		if errors.Is(err, client.ErrConflict) {
+			followUpsTotal.WithLabelValues("existing").Inc()
+			result.Existing++
+			continue
+		}
+		if err != nil {
+			followUpsTotal.WithLabelValues("error").Inc()
+			calendarRequestsTotal.WithLabelValues("POST", "/followups", "error").Inc()
+			http.Error(w, fmt.Sprintf("Failed to create follow-up of %s: %v", event.Summary, err), http.StatusBadGateway)
+			return
+		}
+		followUpsTotal.WithLabelValues("created").Inc()
+		result.Created = append(result.Created, *task)
+	}
+
+	calendarRequestsTotal.WithLabelValues("POST", "/followups", status).Inc()
+	writeJSONResponse(w, result)
+}
+
+// needsFollowUp reports whether a meeting mentions one of keywords, which
+// are lower case. Events lasting a day or more are not meetings.
+func needsFollowUp(event Event, keywords []string) bool {
+	if event.End.Sub(event.Start) >= 24*time.Hour {
+		return false
+	}
+	text := strings.ToLower(event.Summary + "\n" + event.Description)
+	for _, keyword := range keywords {
+		if strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// followUpTask is the task to send the notes of a meeting of calendarID,
+// due the day after it.
+func followUpTask(calendarID string, event Event) client.CreateTaskRequest {
+	summary := strings.TrimSpace(event.Summary)
+	if summary == "" {
+		summary = "meeting"
+	}
+	return client.CreateTaskRequest{
+		Title:          "Send notes for " + summary,
+		Description:    fmt.Sprintf("Follow-up of %s on %s", summary, event.Start.Format("Mon 2 Jan 15:04 MST")),
+		Priority:       "medium",
+		DueDate:        event.End.AddDate(0, 0, 1).Format("2006-01-02"),
+		Tags:           []string{"follow-up"},
+		ExternalSource: followUpSource,
+		ExternalID:     "followup:" + calendarID + ":" + event.ID,
+	}
+}
+
+// splitKeywords splits a comma separated list of keywords, in lower case.
+func splitKeywords(s string) []string {
+	var keywords []string
+	for _, keyword := range strings.Split(s, ",") {
+		if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return keywords
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// Calls to Google are measured so that operators see a user's access
+// decaying before the user does: token refreshes and their failures,
+// calls rejected for an expired or revoked token (401) or over quota,
+// how long calls take and how many events each sync of a calendar
+// returns. Requests carry the user's Google access token and may carry
+// their refresh token in X-Google-Refresh-Token instead, or as well; the
+// service then gets an access token from Google when given none, and
+// keeps it until it expires.
+
+// quotaReasons are the reasons Google gives calls rejected over quota,
+// answered 403 or 429
+var quotaReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+	"quotaExceeded":         true,
+	"dailyLimitExceeded":    true,
+}
+
+var (
+	googleTokenRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "calendar_google_token_requests_total",
+			Help: "Total number of OAuth token requests to Google, by grant type",
+		},
+		[]string{"grant", "status"},
+	)
+	googleAuthErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "calendar_google_auth_errors_total",
+			Help: "Total number of Google API calls rejected for an expired or revoked token",
+		},
+		[]string{"operation"},
+	)
+	googleQuotaErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "calendar_google_quota_errors_total",
+			Help: "Total number of Google API calls rejected over quota",
+		},
+		[]string{"operation", "reason"},
+	)
+	googleAPIDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "calendar_google_api_duration_seconds",
+			Help: "Duration of Google API calls",
+		},
+		[]string{"operation"},
+	)
+	calendarSyncEvents = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "calendar_sync_events",
+			Help:    "Number of events a sync of a calendar returned",
+			Buckets: []float64{0, 1, 5, 10, 25, 50, 100, 250},
+		},
+		[]string{"calendar"},
+	)
+)
+
+// refreshedTokens are the access tokens got with refresh tokens, by the
+// hash of the refresh token
+var refreshedTokens = struct {
+	sync.Mutex
+	byRefresh map[string]*oauth2.Token
+}{byRefresh: map[string]*oauth2.Token{}}
+
+func init() {
+	prometheus.MustRegister(googleTokenRequestsTotal)
+	prometheus.MustRegister(googleAuthErrorsTotal)
+	prometheus.MustRegister(googleQuotaErrorsTotal)
+	prometheus.MustRegister(googleAPIDuration)
+	prometheus.MustRegister(calendarSyncEvents)
+}
+
+// getGoogleToken returns the Google tokens of a request, or nil when it
+// has neither an access nor a refresh token.
+func getGoogleToken(r *http.Request) *oauth2.Token {
+	token := &oauth2.Token{
+		AccessToken:  getAccessToken(r),
+		RefreshToken: r.Header.Get("X-Google-Refresh-Token"),
+	}
+	if token.AccessToken == "" && token.RefreshToken == "" {
+		return nil
+	}
+	return token
+}
+
+// newCalendarService returns a Calendar client calling Google with token
+// for operation. An access token is used as it is; without one, one is
+// got with the refresh token.
+func newCalendarService(ctx context.Context, token *oauth2.Token, operation string) (*calendar.Service, error) {
+	key := refreshKey(token.RefreshToken)
+	if token.AccessToken == "" {
+		refreshedTokens.Lock()
+		if cached := refreshedTokens.byRefresh[key]; cached.Valid() {
+			token = cached
+		}
+		refreshedTokens.Unlock()
+	}
+	refresher := &countedRefresh{
+		src: oauth2Config.TokenSource(ctx, &oauth2.Token{RefreshToken: token.RefreshToken}),
+		key: key,
+	}
+	client := &http.Client{Transport: &oauth2.Transport{
+		Source: oauth2.ReuseTokenSource(token, refresher),
+		Base:   &measuredTransport{operation: operation},
+	}}
+	return calendar.NewService(ctx, option.WithHTTPClient(client))
+}
+
+func refreshKey(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// countedRefresh counts the token refreshes of src and keeps the tokens
+// they get in refreshedTokens under key.
+type countedRefresh struct {
+	src oauth2.TokenSource
+	key string
+}
+
+func (c *countedRefresh) Token() (*oauth2.Token, error) {
+	token, err := c.src.Token()
+	if err != nil {
+		googleTokenRequestsTotal.WithLabelValues("refresh_token", "error").Inc()
+		return nil, err
+	}
+	googleTokenRequestsTotal.WithLabelValues("refresh_token", "success").Inc()
+
+	refreshedTokens.Lock()
+	defer refreshedTokens.Unlock()
+	for k, t := range refreshedTokens.byRefresh {
+		if !t.Valid() {
+			delete(refreshedTokens.byRefresh, k)
+		}
+	}
+	refreshedTokens.byRefresh[c.key] = token
+	return token, nil
+}
+
+// measuredTransport times the Google API calls of operation and counts
+// those rejected for their token or over quota.
+type measuredTransport struct {
+	operation string
+}
+
+func (t *measuredTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	googleAPIDuration.WithLabelValues(t.operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		googleAuthErrorsTotal.WithLabelValues(t.operation).Inc()
+	case http.StatusTooManyRequests, http.StatusForbidden:
+		// A 403 may also deny access to the calendar, which is no quota
+		// error; the reason tells them apart
+		reason := errorReason(resp)
+		if !quotaReasons[reason] {
+			if resp.StatusCode != http.StatusTooManyRequests {
+				break
+			}
+			reason = "rateLimitExceeded"
+		}
+		googleQuotaErrorsTotal.WithLabelValues(t.operation, reason).Inc()
+	}
+	return resp, nil
+}
+
+// errorReason returns the reason of the first error of a Google API error
+// response, leaving the body to be read again.
+func errorReason(resp *http.Response) string {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var apiErr struct {
+		Error struct {
+			Errors []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &apiErr) != nil || len(apiErr.Error.Errors) == 0 {
+		return ""
+	}
+	return apiErr.Error.Errors[0].Reason
+}
@@ -1,33 +1,66 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/clock"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/health"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3"
-	"google.golang.org/api/option"
+	"google.golang.org/api/googleapi"
 )
 
 // Event represents a calendar event
 type Event struct {
-	ID          string    `json:"id"`
-	Summary     string    `json:"summary"`
-	Description string    `json:"description"`
-	Start       time.Time `json:"start"`
-	End         time.Time `json:"end"`
-	Location    string    `json:"location"`
+	ID      string `json:"id"`
+	Summary string `json:"summary"`
+	// Description is Markdown, converted from the HTML Google keeps the
+	// descriptions of formatted invites in, which is in DescriptionHTML.
+	Description     string       `json:"description"`
+	DescriptionHTML string       `json:"description_html,omitempty"`
+	Start           time.Time    `json:"start"`
+	End             time.Time    `json:"end"`
+	Location        string       `json:"location"`
+	Attachments     []Attachment `json:"attachments,omitempty"`
+	// Weather is the forecast for an outdoor event, set in agendas only.
+	Weather *EventWeather `json:"weather,omitempty"`
+	// Travel is the travel from the previous event, set on events created
+	// with AddTravelBuffer only.
+	Travel *EventTravel `json:"travel,omitempty"`
+}
+
+// EventWeather is the forecast for the time window of an event, as
+// returned by the weather service, with advice an agent can pass on.
+type EventWeather struct {
+	City                string  `json:"city"`
+	TemperatureMin      float64 `json:"temperature_min"`
+	TemperatureMax      float64 `json:"temperature_max"`
+	Description         string  `json:"description"`
+	Condition           string  `json:"condition,omitempty"`
+	PrecipitationChance float64 `json:"precipitation_chance"`
+	WindSpeed           float64 `json:"wind_speed"`
+	// Units are those of the user's weather preferences, "metric" or
+	// "imperial".
+	Units  string `json:"units"`
+	Advice string `json:"advice,omitempty"`
 }
 
 // CreateEventRequest represents the request payload for creating an event
@@ -37,11 +70,52 @@ type CreateEventRequest struct {
 	Start       string `json:"start"` // RFC3339 format
 	End         string `json:"end"`   // RFC3339 format
 	Location    string `json:"location"`
+	// Attachments are Drive files to attach; see attachments.go.
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// AddTravelBuffer checks the travel from the previous event and, when
+	// there is time, adds a block for it before the event; see travel.go.
+	AddTravelBuffer bool `json:"add_travel_buffer,omitempty"`
 }
 
 // OAuth2 configuration
 var oauth2Config *oauth2.Config
 
+// authConfig identifies the user of each request; the Google token of a
+// request comes in its own header since the Authorization header carries
+// the user's JWT
+var authConfig = auth.ConfigFromEnv()
+
+// mockClock is the time of the mock events, which tests can set on
+// /mock/clock when MOCK_NOW or MOCK_TIME_TRAVEL is set
+var mockClock = clock.FromEnv()
+
+// oauthStateTTL bounds how long a Google consent screen may stay open
+const oauthStateTTL = 10 * time.Minute
+
+// Timeouts of the calls a request makes; each also stops when the client
+// goes away
+const (
+	googleAPITimeout = 10 * time.Second
+	forecastTimeout  = 5 * time.Second
+)
+
+// Weather service used to annotate outdoor events in agendas
+var weatherServiceURL = getEnv("WEATHER_SERVICE_URL", "http://weather-service:8083")
+
+// Rules service told about each new event, so that event_created rules
+// fire; unset disables it
+var rulesServiceURL = os.Getenv("RULES_SERVICE_URL")
+
+// rulesTimeout bounds running the rules a new event triggers
+const rulesTimeout = time.Minute
+
+// outdoorKeywords mark an event as taking place outdoors when its
+// location or summary mentions one of them.
+var outdoorKeywords = []string{
+	"outdoor", "outside", "offsite", "off-site", "park", "garden", "beach", "field",
+	"stadium", "rooftop", "terrace", "trail", "hike", "picnic", "golf", "bbq", "barbecue",
+}
+
 // Prometheus metrics
 var (
 	calendarRequestsTotal = prometheus.NewCounterVec(
@@ -65,26 +139,53 @@ var (
 		},
 		[]string{"operation", "status"},
 	)
+	weatherLookupsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "calendar_weather_lookups_total",
+			Help: "Total number of forecast lookups for agenda events",
+		},
+		[]string{"status"},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(calendarRequestsTotal)
 	prometheus.MustRegister(calendarRequestDuration)
 	prometheus.MustRegister(googleAPICallsTotal)
+	prometheus.MustRegister(weatherLookupsTotal)
 }
 
 func main() {
 	// Initialize OAuth2 configuration
 	initOAuth2Config()
 
+	// Agendas are served without forecasts while the weather service is down
+	checker := health.NewChecker("Calendar Service",
+		health.Dependency{Name: "weather-service", Check: health.HTTPCheck(weatherServiceURL + "/health/ready"), Optional: true})
+
 	router := mux.NewRouter()
+	// The OAuth callback is a browser redirect without a token; its
+	// state parameter identifies the user instead
+	router.Use(authConfig.Middleware(health.PublicPaths("/metrics", "/callback")...))
 
 	// Calendar endpoints
+	router.HandleFunc("/calendars", handleListCalendars).Methods("GET")
 	router.HandleFunc("/events", handleGetEvents).Methods("GET")
 	router.HandleFunc("/events", handleCreateEvent).Methods("POST")
+	router.HandleFunc("/events/{id}", handleGetEvent).Methods("GET")
+	router.HandleFunc("/events/{id}", handleDeleteEvent).Methods("DELETE")
+	router.HandleFunc("/events/{id}/attachments", handleAttachFiles).Methods("POST")
+	router.HandleFunc("/agenda", handleGetAgenda).Methods("GET")
+	router.HandleFunc("/followups", handleCreateFollowUps).Methods("POST")
 	router.HandleFunc("/auth", handleAuth).Methods("GET")
 	router.HandleFunc("/callback", handleCallback).Methods("GET")
-	router.HandleFunc("/health", handleHealth).Methods("GET")
+	for _, path := range health.Paths {
+		router.Handle(path, checker).Methods("GET")
+	}
+
+	if mockClock.Enabled {
+		router.HandleFunc("/mock/clock", mockClock.Handle).Methods("GET", "PUT", "DELETE")
+	}
 
 	// Metrics endpoint
 	router.Handle("/metrics", promhttp.Handler())
@@ -103,6 +204,11 @@ func main() {
 		}
 	}()
 
+	// Give the weather service started alongside this one time to come up
+	if err := checker.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+
 	// Wait for interrupt signal
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -146,10 +252,14 @@ func handleGetEvents(w http.ResponseWriter, r *http.Request) {
 	// Get query parameters
 	startDate := r.URL.Query().Get("start_date")
 	endDate := r.URL.Query().Get("end_date")
+	calendarID := r.URL.Query().Get("calendar_id")
+	if calendarID == "" {
+		calendarID = primaryCalendar
+	}
 
 	// For demo purposes, return mock data if no OAuth token is available
-	accessToken := getAccessToken(r)
-	if accessToken == "" {
+	token := getGoogleToken(r)
+	if token == nil {
 		calendarRequestsTotal.WithLabelValues("GET", "/events", "mock").Inc()
 		events := getMockEvents(startDate, endDate)
 		writeJSONResponse(w, map[string]interface{}{"events": events})
@@ -157,7 +267,7 @@ func handleGetEvents(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get real events from Google Calendar
-	events, err := getGoogleCalendarEvents(accessToken, startDate, endDate)
+	events, err := getGoogleCalendarEvents(r.Context(), token, calendarID, startDate, endDate)
 	if err != nil {
 		calendarRequestsTotal.WithLabelValues("GET", "/events", "error").Inc()
 		googleAPICallsTotal.WithLabelValues("list_events", "error").Inc()
@@ -170,6 +280,182 @@ func handleGetEvents(w http.ResponseWriter, r *http.Request) {
 	writeJSONResponse(w, map[string]interface{}{"events": events})
 }
 
+// handleGetAgenda returns the events of a day (date=YYYY-MM-DD, today in
+// UTC by default, or of the mock clock for mock events) with the forecast
+// inline for those held outdoors.
+func handleGetAgenda(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		calendarRequestDuration.WithLabelValues("GET", "/agenda").Observe(time.Since(start).Seconds())
+	}()
+
+	token := getGoogleToken(r)
+	day := time.Now().Truncate(24 * time.Hour)
+	if token == nil {
+		day = mockClock.Now().UTC().Truncate(24 * time.Hour)
+	}
+	if dateStr := r.URL.Query().Get("date"); dateStr != "" {
+		parsed, err := time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			calendarRequestsTotal.WithLabelValues("GET", "/agenda", "error").Inc()
+			http.Error(w, "Date must be in YYYY-MM-DD format", http.StatusBadRequest)
+			return
+		}
+		day = parsed
+	}
+	dayEnd := day.Add(24 * time.Hour)
+
+	var events []Event
+	status := "success"
+	if token == nil {
+		status = "mock"
+		for _, event := range getMockEvents("", "") {
+			if event.Start.Before(dayEnd) && event.End.After(day) {
+				events = append(events, event)
+			}
+		}
+		sort.Slice(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	} else {
+		var err error
+		events, err = getGoogleCalendarEvents(r.Context(), token, primaryCalendar, day.Format(time.RFC3339), dayEnd.Format(time.RFC3339))
+		if err != nil {
+			calendarRequestsTotal.WithLabelValues("GET", "/agenda", "error").Inc()
+			googleAPICallsTotal.WithLabelValues("list_events", "error").Inc()
+			http.Error(w, fmt.Sprintf("Failed to get events: %v", err), http.StatusInternalServerError)
+			return
+		}
+		googleAPICallsTotal.WithLabelValues("list_events", "success").Inc()
+	}
+
+	for i := range events {
+		if r.Context().Err() != nil {
+			// The client went away; nobody will read the forecasts
+			calendarRequestsTotal.WithLabelValues("GET", "/agenda", "canceled").Inc()
+			return
+		}
+		if !isOutdoorEvent(events[i]) {
+			continue
+		}
+		weather, err := getEventWeather(r.Context(), events[i], auth.BearerToken(r))
+		if err != nil {
+			// The agenda is still useful without the forecast
+			weatherLookupsTotal.WithLabelValues("error").Inc()
+			log.Printf("Warning: Failed to get forecast for event %s: %v", events[i].ID, err)
+			continue
+		}
+		weatherLookupsTotal.WithLabelValues("success").Inc()
+		events[i].Weather = weather
+	}
+
+	calendarRequestsTotal.WithLabelValues("GET", "/agenda", status).Inc()
+	writeJSONResponse(w, map[string]interface{}{
+		"date":   day.Format("2006-01-02"),
+		"events": events,
+	})
+}
+
+// isOutdoorEvent reports whether an event has a physical location that
+// looks to be outdoors.
+func isOutdoorEvent(event Event) bool {
+	location := strings.ToLower(event.Location)
+	if location == "" || location == "online" || strings.Contains(location, "://") {
+		return false
+	}
+	text := location + " " + strings.ToLower(event.Summary)
+	for _, keyword := range outdoorKeywords {
+		if strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventCity returns the city of a location such as "Hyde Park, London",
+// taken to be its last comma-separated part that is not a postcode.
+func eventCity(location string) string {
+	parts := strings.Split(location, ",")
+	for i := len(parts) - 1; i >= 0; i-- {
+		part := strings.TrimSpace(parts[i])
+		if part != "" && strings.ContainsAny(part, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") &&
+			!strings.ContainsAny(part, "0123456789") {
+			return part
+		}
+	}
+	return strings.TrimSpace(location)
+}
+
+// getEventWeather asks the weather service for the forecast during an
+// event at its location, on behalf of the user whose token is given.
+func getEventWeather(ctx context.Context, event Event, userToken string) (*EventWeather, error) {
+	query := url.Values{
+		"city":  {eventCity(event.Location)},
+		"start": {event.Start.Format(time.RFC3339)},
+	}
+	if event.End.After(event.Start) {
+		query.Set("end", event.End.Format(time.RFC3339))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, forecastTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, weatherServiceURL+"/forecast?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if userToken != "" {
+		req.Header.Set("Authorization", "Bearer "+userToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("weather service returned status: %s", resp.Status)
+	}
+
+	var weather EventWeather
+	if err := json.NewDecoder(resp.Body).Decode(&weather); err != nil {
+		return nil, err
+	}
+	weather.Advice = weatherAdvice(weather)
+	return &weather, nil
+}
+
+// weatherAdvice suggests what to bring or wear for the forecast, or
+// returns "" when the weather needs no warning.
+func weatherAdvice(weather EventWeather) string {
+	var advice []string
+	switch weather.Condition {
+	case "snow", "sleet":
+		advice = append(advice, "dress for snow")
+	case "rain", "drizzle", "thunderstorm":
+		advice = append(advice, "bring an umbrella")
+	default:
+		if weather.PrecipitationChance >= 0.5 {
+			advice = append(advice, "bring an umbrella")
+		}
+	}
+	freezing, hot, windy := 0.0, 30.0, 10.0
+	if weather.Units == "imperial" {
+		freezing, hot, windy = 32, 86, 22.4
+	}
+	if weather.TemperatureMin <= freezing {
+		advice = append(advice, "dress warmly, it will be freezing")
+	} else if weather.TemperatureMax >= hot {
+		advice = append(advice, "bring water and sun protection")
+	}
+	if weather.WindSpeed >= windy {
+		advice = append(advice, "expect strong wind")
+	}
+	if len(advice) == 0 {
+		return ""
+	}
+	text := strings.Join(advice, "; ")
+	return strings.ToUpper(text[:1]) + text[1:]
+}
+
 func handleCreateEvent(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	defer func() {
@@ -188,34 +474,223 @@ func handleCreateEvent(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Summary, start, and end are required", http.StatusBadRequest)
 		return
 	}
+	if msg := prepareAttachments(req.Attachments); msg != "" {
+		calendarRequestsTotal.WithLabelValues("POST", "/events", "error").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
 
 	// For demo purposes, return mock data if no OAuth token is available
-	accessToken := getAccessToken(r)
-	if accessToken == "" {
+	token := getGoogleToken(r)
+	if token == nil {
 		calendarRequestsTotal.WithLabelValues("POST", "/events", "mock").Inc()
 		event := createMockEvent(req)
+		if req.AddTravelBuffer {
+			addTravelBuffer(r, &event, func(buffer CreateEventRequest) (*Event, error) {
+				created := createMockEvent(buffer)
+				return &created, nil
+			})
+		}
+		go publishEventCreated(auth.BearerToken(r), event)
 		w.WriteHeader(http.StatusCreated)
 		writeJSONResponse(w, event)
 		return
 	}
 
 	// Create real event in Google Calendar
-	event, err := createGoogleCalendarEvent(accessToken, req)
+	event, err := createGoogleCalendarEvent(r.Context(), token, req)
 	if err != nil {
 		calendarRequestsTotal.WithLabelValues("POST", "/events", "error").Inc()
 		googleAPICallsTotal.WithLabelValues("create_event", "error").Inc()
 		http.Error(w, fmt.Sprintf("Failed to create event: %v", err), http.StatusInternalServerError)
 		return
 	}
+	googleAPICallsTotal.WithLabelValues("create_event", "success").Inc()
+	if req.AddTravelBuffer {
+		addTravelBuffer(r, event, func(buffer CreateEventRequest) (*Event, error) {
+			created, err := createGoogleCalendarEvent(r.Context(), token, buffer)
+			if err != nil {
+				googleAPICallsTotal.WithLabelValues("create_event", "error").Inc()
+				return nil, err
+			}
+			googleAPICallsTotal.WithLabelValues("create_event", "success").Inc()
+			return created, nil
+		})
+	}
 
 	calendarRequestsTotal.WithLabelValues("POST", "/events", "success").Inc()
-	googleAPICallsTotal.WithLabelValues("create_event", "success").Inc()
+	go publishEventCreated(auth.BearerToken(r), *event)
 	w.WriteHeader(http.StatusCreated)
 	writeJSONResponse(w, event)
 }
 
+// addTravelBuffer checks the travel to a new event at a physical location
+// from the user's previous event and adds a travel block with create when
+// it fits. The event is created already, so failures only leave a warning
+// in event.Travel.
+func addTravelBuffer(r *http.Request, event *Event, create func(CreateEventRequest) (*Event, error)) {
+	if !hasPhysicalLocation(event.Location) {
+		return
+	}
+
+	var events []Event
+	if token := getGoogleToken(r); token == nil {
+		events = getMockEvents("", "")
+	} else {
+		var err error
+		events, err = getGoogleCalendarEvents(r.Context(), token, primaryCalendar,
+			event.Start.Add(-travelLookback).Format(time.RFC3339), event.Start.Format(time.RFC3339))
+		if err != nil {
+			googleAPICallsTotal.WithLabelValues("list_events", "error").Inc()
+			event.Travel = &EventTravel{Warning: fmt.Sprintf("Could not read the previous events: %v", err)}
+			return
+		}
+		googleAPICallsTotal.WithLabelValues("list_events", "success").Inc()
+	}
+
+	travel, needsBuffer := planTravel(r.Context(), *event, events, auth.BearerToken(r))
+	if needsBuffer {
+		buffer, err := create(travelBufferRequest(*event, travel))
+		if err != nil {
+			travel.Warning = fmt.Sprintf("Could not add the travel block: %v", err)
+		} else {
+			travel.Buffer = buffer
+		}
+	}
+	event.Travel = travel
+}
+
+func handleGetEvent(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		calendarRequestDuration.WithLabelValues("GET", "/events/:id").Observe(time.Since(start).Seconds())
+	}()
+
+	id := mux.Vars(r)["id"]
+
+	// For demo purposes, look the event up in the mock data if no OAuth
+	// token is available
+	token := getGoogleToken(r)
+	if token == nil {
+		calendarRequestsTotal.WithLabelValues("GET", "/events/:id", "mock").Inc()
+		for _, event := range getMockEvents("", "") {
+			if event.ID == id {
+				writeJSONResponse(w, event)
+				return
+			}
+		}
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+
+	event, err := getGoogleCalendarEvent(r.Context(), token, id)
+	if isNotFound(err) {
+		calendarRequestsTotal.WithLabelValues("GET", "/events/:id", "error").Inc()
+		googleAPICallsTotal.WithLabelValues("get_event", "not_found").Inc()
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		calendarRequestsTotal.WithLabelValues("GET", "/events/:id", "error").Inc()
+		googleAPICallsTotal.WithLabelValues("get_event", "error").Inc()
+		http.Error(w, fmt.Sprintf("Failed to get event: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	calendarRequestsTotal.WithLabelValues("GET", "/events/:id", "success").Inc()
+	googleAPICallsTotal.WithLabelValues("get_event", "success").Inc()
+	writeJSONResponse(w, event)
+}
+
+func handleDeleteEvent(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		calendarRequestDuration.WithLabelValues("DELETE", "/events/:id").Observe(time.Since(start).Seconds())
+	}()
+
+	id := mux.Vars(r)["id"]
+
+	// Mock events are not stored, so there is nothing to delete
+	token := getGoogleToken(r)
+	if token == nil {
+		calendarRequestsTotal.WithLabelValues("DELETE", "/events/:id", "mock").Inc()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	err := deleteGoogleCalendarEvent(r.Context(), token, id)
+	if isNotFound(err) {
+		calendarRequestsTotal.WithLabelValues("DELETE", "/events/:id", "error").Inc()
+		googleAPICallsTotal.WithLabelValues("delete_event", "not_found").Inc()
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		calendarRequestsTotal.WithLabelValues("DELETE", "/events/:id", "error").Inc()
+		googleAPICallsTotal.WithLabelValues("delete_event", "error").Inc()
+		http.Error(w, fmt.Sprintf("Failed to delete event: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	calendarRequestsTotal.WithLabelValues("DELETE", "/events/:id", "success").Inc()
+	googleAPICallsTotal.WithLabelValues("delete_event", "success").Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// publishEventCreated tells the rules service about a new event of the
+// user whose token is given. It runs after the response is sent, so
+// failures are only logged.
+func publishEventCreated(userToken string, event Event) {
+	if rulesServiceURL == "" {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rulesTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rulesServiceURL+"/triggers/event_created", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to publish event %s: %v", event.ID, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if userToken != "" {
+		req.Header.Set("Authorization", "Bearer "+userToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to publish event %s: %v", event.ID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Rules service returned %s for event %s", resp.Status, event.ID)
+	}
+}
+
+// handleAuth returns the Google consent URL. With JWT_SECRET set, its
+// state is a short-lived token naming the user, so the callback knows
+// whose consent it received and rejects forged redirects.
 func handleAuth(w http.ResponseWriter, r *http.Request) {
-	url := oauth2Config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+	state := "state-token"
+	if len(authConfig.Secret) > 0 {
+		var err error
+		state, err = auth.Sign(auth.Claims{
+			Subject:   auth.UserID(r),
+			Issuer:    "calendar-service",
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(oauthStateTTL).Unix(),
+		}, authConfig.Secret)
+		if err != nil {
+			http.Error(w, "Failed to create OAuth state", http.StatusInternalServerError)
+			return
+		}
+	}
+	url := oauth2Config.AuthCodeURL(state, oauth2.AccessTypeOffline)
 	writeJSONResponse(w, map[string]string{"auth_url": url})
 }
 
@@ -226,36 +701,55 @@ func handleCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := oauth2Config.Exchange(context.Background(), code)
+	userID := auth.DefaultUser
+	if len(authConfig.Secret) > 0 {
+		state, err := auth.Verify(r.URL.Query().Get("state"), authConfig.Secret)
+		if err != nil || state.Issuer != "calendar-service" {
+			http.Error(w, "Invalid or expired OAuth state", http.StatusBadRequest)
+			return
+		}
+		userID = state.Subject
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), googleAPITimeout)
+	defer cancel()
+
+	token, err := oauth2Config.Exchange(ctx, code)
 	if err != nil {
+		googleTokenRequestsTotal.WithLabelValues("authorization_code", "error").Inc()
 		http.Error(w, fmt.Sprintf("Failed to exchange code: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	googleTokenRequestsTotal.WithLabelValues("authorization_code", "success").Inc()
+
 	// In a real application, you would store this token securely
-	// For demo purposes, we'll just return it
+	// For demo purposes, we'll just return it. The refresh token, sent
+	// back in X-Google-Refresh-Token, keeps the calendar readable once
+	// the access token expires.
 	writeJSONResponse(w, map[string]interface{}{
-		"access_token": token.AccessToken,
-		"token_type":   token.TokenType,
-		"expires_in":   token.Expiry.Unix(),
+		"user_id":       userID,
+		"access_token":  token.AccessToken,
+		"refresh_token": token.RefreshToken,
+		"token_type":    token.TokenType,
+		"expires_in":    token.Expiry.Unix(),
 	})
 }
 
-func getGoogleCalendarEvents(accessToken, startDate, endDate string) ([]Event, error) {
-	ctx := context.Background()
-
-	// Create OAuth2 token
-	token := &oauth2.Token{AccessToken: accessToken}
-	client := oauth2Config.Client(ctx, token)
+// getGoogleCalendarEvents lists the events of the user's calendar
+// calendarID between the dates, which may be empty.
+func getGoogleCalendarEvents(ctx context.Context, token *oauth2.Token, calendarID, startDate, endDate string) ([]Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, googleAPITimeout)
+	defer cancel()
 
 	// Create Calendar service
-	service, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+	service, err := newCalendarService(ctx, token, "list_events")
 	if err != nil {
 		return nil, err
 	}
 
 	// Build the events list call
-	call := service.Events.List("primary").SingleEvents(true).OrderBy("startTime")
+	call := service.Events.List(calendarID).SingleEvents(true).OrderBy("startTime")
 
 	if startDate != "" {
 		call = call.TimeMin(startDate)
@@ -265,46 +759,101 @@ func getGoogleCalendarEvents(accessToken, startDate, endDate string) ([]Event, e
 	}
 
 	// Execute the call
-	events, err := call.Do()
+	events, err := call.Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
 
+	// Only the primary calendar is labelled by name, so that the other
+	// calendars of users do not each add a series
+	label := "other"
+	if calendarID == primaryCalendar {
+		label = primaryCalendar
+	}
+	calendarSyncEvents.WithLabelValues(label).Observe(float64(len(events.Items)))
+
 	// Convert to our Event format
 	var result []Event
 	for _, item := range events.Items {
-		start, _ := time.Parse(time.RFC3339, item.Start.DateTime)
-		if item.Start.DateTime == "" {
-			start, _ = time.Parse("2006-01-02", item.Start.Date)
-		}
+		result = append(result, convertGoogleEvent(item))
+	}
 
-		end, _ := time.Parse(time.RFC3339, item.End.DateTime)
-		if item.End.DateTime == "" {
-			end, _ = time.Parse("2006-01-02", item.End.Date)
-		}
+	return result, nil
+}
 
-		result = append(result, Event{
-			ID:          item.Id,
-			Summary:     item.Summary,
-			Description: item.Description,
-			Start:       start,
-			End:         end,
-			Location:    item.Location,
-		})
+// convertGoogleEvent converts a Google Calendar event to our Event format;
+// all-day events start and end at midnight.
+func convertGoogleEvent(item *calendar.Event) Event {
+	start, _ := time.Parse(time.RFC3339, item.Start.DateTime)
+	if item.Start.DateTime == "" {
+		start, _ = time.Parse("2006-01-02", item.Start.Date)
 	}
 
-	return result, nil
+	end, _ := time.Parse(time.RFC3339, item.End.DateTime)
+	if item.End.DateTime == "" {
+		end, _ = time.Parse("2006-01-02", item.End.Date)
+	}
+
+	description, descriptionHTML := eventDescription(item.Description)
+	return Event{
+		ID:              item.Id,
+		Summary:         item.Summary,
+		Description:     description,
+		DescriptionHTML: descriptionHTML,
+		Start:           start,
+		End:             end,
+		Location:        item.Location,
+		Attachments:     convertAttachments(item.Attachments),
+	}
 }
 
-func createGoogleCalendarEvent(accessToken string, req CreateEventRequest) (*Event, error) {
-	ctx := context.Background()
+func getGoogleCalendarEvent(ctx context.Context, token *oauth2.Token, id string) (*Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, googleAPITimeout)
+	defer cancel()
 
-	// Create OAuth2 token
-	token := &oauth2.Token{AccessToken: accessToken}
-	client := oauth2Config.Client(ctx, token)
+	service, err := newCalendarService(ctx, token, "get_event")
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := service.Events.Get("primary", id).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	// Deleted events stay readable for a while, cancelled
+	if item.Status == "cancelled" {
+		return nil, &googleapi.Error{Code: http.StatusGone, Message: "event was deleted"}
+	}
+	event := convertGoogleEvent(item)
+	return &event, nil
+}
+
+func deleteGoogleCalendarEvent(ctx context.Context, token *oauth2.Token, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, googleAPITimeout)
+	defer cancel()
+
+	service, err := newCalendarService(ctx, token, "delete_event")
+	if err != nil {
+		return err
+	}
+
+	return service.Events.Delete("primary", id).Context(ctx).Do()
+}
+
+// isNotFound reports whether a Google API call failed because the event
+// does not exist or was deleted.
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) &&
+		(apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusGone)
+}
+
+func createGoogleCalendarEvent(ctx context.Context, token *oauth2.Token, req CreateEventRequest) (*Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, googleAPITimeout)
+	defer cancel()
 
 	// Create Calendar service
-	service, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+	service, err := newCalendarService(ctx, token, "create_event")
 	if err != nil {
 		return nil, err
 	}
@@ -320,30 +869,25 @@ func createGoogleCalendarEvent(accessToken string, req CreateEventRequest) (*Eve
 		End: &calendar.EventDateTime{
 			DateTime: req.End,
 		},
+		Attachments: googleAttachments(req.Attachments),
 	}
 
 	// Insert the event
-	createdEvent, err := service.Events.Insert("primary", event).Do()
+	createdEvent, err := service.Events.Insert("primary", event).
+		SupportsAttachments(len(req.Attachments) > 0).Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
 
 	// Convert to our Event format
-	start, _ := time.Parse(time.RFC3339, createdEvent.Start.DateTime)
-	end, _ := time.Parse(time.RFC3339, createdEvent.End.DateTime)
-
-	return &Event{
-		ID:          createdEvent.Id,
-		Summary:     createdEvent.Summary,
-		Description: createdEvent.Description,
-		Start:       start,
-		End:         end,
-		Location:    createdEvent.Location,
-	}, nil
+	created := convertGoogleEvent(createdEvent)
+	return &created, nil
 }
 
+// getMockEvents returns the mock events, laid out from the time the mock
+// clock was set to.
 func getMockEvents(startDate, endDate string) []Event {
-	now := time.Now()
+	now := mockClock.Start()
 	return []Event{
 		{
 			ID:          "mock-1",
@@ -361,6 +905,14 @@ func getMockEvents(startDate, endDate string) []Event {
 			End:         now.Add(26 * time.Hour),
 			Location:    "Online",
 		},
+		{
+			ID:          "mock-3",
+			Summary:     "Team Offsite",
+			Description: "Quarterly planning and team lunch",
+			Start:       now.Add(3 * time.Hour),
+			End:         now.Add(6 * time.Hour),
+			Location:    "Hyde Park, London",
+		},
 	}
 }
 
@@ -368,31 +920,30 @@ func createMockEvent(req CreateEventRequest) Event {
 	start, _ := time.Parse(time.RFC3339, req.Start)
 	end, _ := time.Parse(time.RFC3339, req.End)
 
+	description, descriptionHTML := eventDescription(req.Description)
 	return Event{
-		ID:          fmt.Sprintf("mock-%d", time.Now().Unix()),
-		Summary:     req.Summary,
-		Description: req.Description,
-		Start:       start,
-		End:         end,
-		Location:    req.Location,
+		ID:              fmt.Sprintf("mock-%d", time.Now().Unix()),
+		Summary:         req.Summary,
+		Description:     description,
+		DescriptionHTML: descriptionHTML,
+		Start:           start,
+		End:             end,
+		Location:        req.Location,
+		Attachments:     req.Attachments,
 	}
 }
 
+// getAccessToken returns the request's Google access token.
 func getAccessToken(r *http.Request) string {
-	// Try to get token from Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader != "" && len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-		return authHeader[7:]
+	// Try to get token from the Google token header
+	if token := r.Header.Get("X-Google-Access-Token"); token != "" {
+		return token
 	}
 
 	// Try to get token from query parameter
 	return r.URL.Query().Get("access_token")
 }
 
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	writeJSONResponse(w, map[string]string{"status": "healthy"})
-}
-
 func writeJSONResponse(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(data)
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Travel buffers: an event created with add_travel_buffer at a physical
+// location is checked against the user's previous event of the day held
+// elsewhere. Both locations are geocoded by the weather service and the
+// travel time between them comes from a routing service (an OSRM server at
+// TRAVEL_ROUTING_URL) or, without one, from the straight-line distance at
+// TRAVEL_SPEED_KMH. When the gap between the events leaves time to travel,
+// a "Travel to" block is added before the event; when it does not, the
+// event is still created and the response warns that it cannot be
+// reached in time.
+
+// travelLookback is how far before an event its previous event is looked
+// for
+const travelLookback = 12 * time.Hour
+
+// detourFactor turns a straight-line distance into a rough road distance
+const detourFactor = 1.3
+
+// Travel settings
+var (
+	travelSpeedKMH = parseFloatEnv("TRAVEL_SPEED_KMH", 40)
+	// travelMinBuffer is the least time allowed between events at
+	// different places, however close
+	travelMinBuffer = parseDurationEnv("TRAVEL_MIN_BUFFER", 10*time.Minute)
+)
+
+// Timeouts of the geocoding and routing calls of a travel check
+const (
+	geocodeTimeout = 5 * time.Second
+	routingTimeout = 5 * time.Second
+)
+
+var travelChecksTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "calendar_travel_checks_total",
+		Help: "Total number of travel time checks of new events, by outcome",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(travelChecksTotal)
+}
+
+// EventTravel is the travel from the previous event to a new one
+type EventTravel struct {
+	FromEventID  string `json:"from_event_id,omitempty"`
+	FromLocation string `json:"from_location,omitempty"`
+	// DistanceKM is the road distance, or estimated from the straight line
+	DistanceKM float64 `json:"distance_km,omitempty"`
+	Minutes    int     `json:"minutes,omitempty"`
+	// Source is "routing" or "estimate", how Minutes was found
+	Source string `json:"source,omitempty"`
+	// GapMinutes is the time between the end of the previous event and
+	// the start of the new one
+	GapMinutes int `json:"gap_minutes"`
+	// Buffer is the travel block added before the event, if any
+	Buffer *Event `json:"buffer,omitempty"`
+	// Warning says why no buffer was added, such as the gap being too
+	// short to travel
+	Warning string `json:"warning,omitempty"`
+}
+
+// place is a geocoded location
+type place struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// travelRouter finds how long it takes to travel between two places and
+// how far apart they are, in km
+type travelRouter interface {
+	route(ctx context.Context, from, to place) (time.Duration, float64, error)
+}
+
+// routingProvider is the routing service of TRAVEL_ROUTING_URL, or nil to
+// only estimate
+var routingProvider = newRoutingProvider(os.Getenv("TRAVEL_ROUTING_URL"))
+
+func newRoutingProvider(baseURL string) travelRouter {
+	if baseURL == "" {
+		return nil
+	}
+	return osrmRouter{baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// estimateRouter estimates travel at travelSpeedKMH over the straight-line
+// distance lengthened by detourFactor
+type estimateRouter struct{}
+
+func (estimateRouter) route(_ context.Context, from, to place) (time.Duration, float64, error) {
+	km := haversineKM(from, to) * detourFactor
+	return time.Duration(km / travelSpeedKMH * float64(time.Hour)), km, nil
+}
+
+// osrmRouter asks an OSRM server for the driving time
+type osrmRouter struct {
+	baseURL string
+}
+
+func (o osrmRouter) route(ctx context.Context, from, to place) (time.Duration, float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, routingTimeout)
+	defer cancel()
+
+	target := fmt.Sprintf("%s/route/v1/driving/%f,%f;%f,%f?overview=false", o.baseURL, from.Lon, from.Lat, to.Lon, to.Lat)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Code   string `json:"code"`
+		Routes []struct {
+			Duration float64 `json:"duration"`
+			Distance float64 `json:"distance"`
+		} `json:"routes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, 0, fmt.Errorf("routing service returned status %s", resp.Status)
+	}
+	if body.Code != "Ok" || len(body.Routes) == 0 {
+		return 0, 0, fmt.Errorf("no route found (%s)", body.Code)
+	}
+	return time.Duration(body.Routes[0].Duration * float64(time.Second)), body.Routes[0].Distance / 1000, nil
+}
+
+// haversineKM is the great-circle distance between two places
+func haversineKM(a, b place) float64 {
+	const earthRadiusKM = 6371
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(b.Lat - a.Lat)
+	dLon := toRad(b.Lon - a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(a.Lat))*math.Cos(toRad(b.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKM * math.Asin(math.Sqrt(h))
+}
+
+// hasPhysicalLocation reports whether an event takes place somewhere one
+// travels to.
+func hasPhysicalLocation(location string) bool {
+	location = strings.ToLower(strings.TrimSpace(location))
+	return location != "" && location != "online" && !strings.Contains(location, "://")
+}
+
+// previousEvent returns the event of events at a physical location that
+// ends last, no later than start, or nil. All-day events are left out.
+func previousEvent(events []Event, start time.Time) *Event {
+	var prev *Event
+	for i := range events {
+		event := &events[i]
+		if !hasPhysicalLocation(event.Location) || event.End.Sub(event.Start) >= 24*time.Hour ||
+			event.End.After(start) || start.Sub(event.End) > travelLookback {
+			continue
+		}
+		if prev == nil || event.End.After(prev.End) {
+			prev = event
+		}
+	}
+	return prev
+}
+
+// planTravel works out the travel from the event before event, among
+// events, to event. needsBuffer is set when a travel block fits before it.
+func planTravel(ctx context.Context, event Event, events []Event, userToken string) (travel *EventTravel, needsBuffer bool) {
+	prev := previousEvent(events, event.Start)
+	if prev == nil {
+		travelChecksTotal.WithLabelValues("no_previous_event").Inc()
+		return nil, false
+	}
+	travel = &EventTravel{
+		FromEventID:  prev.ID,
+		FromLocation: prev.Location,
+		GapMinutes:   int(event.Start.Sub(prev.End).Minutes()),
+	}
+	if strings.EqualFold(strings.TrimSpace(prev.Location), strings.TrimSpace(event.Location)) {
+		travelChecksTotal.WithLabelValues("same_location").Inc()
+		return nil, false
+	}
+
+	duration, km, source, err := travelTime(ctx, prev.Location, event.Location, userToken)
+	if err != nil {
+		travelChecksTotal.WithLabelValues("unknown").Inc()
+		log.Printf("Warning: Failed to find travel time to %q: %v", event.Location, err)
+		travel.Warning = fmt.Sprintf("Travel time from %s is unknown: %v", prev.Location, err)
+		return travel, false
+	}
+	if duration < travelMinBuffer {
+		duration = travelMinBuffer
+	}
+	travel.Minutes = int(math.Ceil(duration.Minutes()))
+	travel.DistanceKM = math.Round(km*10) / 10
+	travel.Source = source
+
+	if time.Duration(travel.Minutes)*time.Minute > event.Start.Sub(prev.End) {
+		travelChecksTotal.WithLabelValues("impossible").Inc()
+		travel.Warning = fmt.Sprintf("%s ends %d minutes before this event but getting here from %s takes about %d minutes",
+			prev.Summary, travel.GapMinutes, prev.Location, travel.Minutes)
+		return travel, false
+	}
+	travelChecksTotal.WithLabelValues("buffered").Inc()
+	return travel, true
+}
+
+// travelTime finds how long it takes to go from one location to another,
+// falling back to the estimate when the routing service fails.
+func travelTime(ctx context.Context, from, to, userToken string) (time.Duration, float64, string, error) {
+	fromPlace, err := geocode(ctx, from, userToken)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	toPlace, err := geocode(ctx, to, userToken)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	if routingProvider != nil {
+		duration, km, err := routingProvider.route(ctx, fromPlace, toPlace)
+		if err == nil {
+			return duration, km, "routing", nil
+		}
+		log.Printf("Warning: Routing failed, estimating travel time instead: %v", err)
+	}
+	duration, km, _ := estimateRouter{}.route(ctx, fromPlace, toPlace)
+	return duration, km, "estimate", nil
+}
+
+// geocode asks the weather service where the city of location is, taking
+// its best match.
+func geocode(ctx context.Context, location, userToken string) (place, error) {
+	ctx, cancel := context.WithTimeout(ctx, geocodeTimeout)
+	defer cancel()
+
+	city := eventCity(location)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, weatherServiceURL+"/geocode?"+url.Values{"q": {city}}.Encode(), nil)
+	if err != nil {
+		return place{}, err
+	}
+	if userToken != "" {
+		req.Header.Set("Authorization", "Bearer "+userToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return place{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return place{}, fmt.Errorf("weather service returned status: %s", resp.Status)
+	}
+	var body struct {
+		Places []place `json:"places"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return place{}, err
+	}
+	if len(body.Places) == 0 {
+		return place{}, errors.New("could not locate " + city)
+	}
+	return body.Places[0], nil
+}
+
+// travelBufferRequest is the block of travel before event
+func travelBufferRequest(event Event, travel *EventTravel) CreateEventRequest {
+	start := event.Start.Add(-time.Duration(travel.Minutes) * time.Minute)
+	return CreateEventRequest{
+		Summary: "Travel to " + event.Summary,
+		Description: fmt.Sprintf("Travel buffer from %s to %s, about %d minutes (%s)",
+			travel.FromLocation, event.Location, travel.Minutes, travel.Source),
+		Start: start.Format(time.RFC3339),
+		End:   event.Start.Format(time.RFC3339),
+	}
+}
+
+func parseFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil && f > 0 {
+			return f
+		}
+		log.Printf("Warning: invalid %s %q, using %g", key, value, defaultValue)
+	}
+	return defaultValue
+}
+
+func parseDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Warning: invalid %s %q, using %s", key, value, defaultValue)
+	}
+	return defaultValue
+}
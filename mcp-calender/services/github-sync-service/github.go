@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+)
+
+// GitHub API settings. Without GITHUB_TOKEN only public repositories can
+// be read, at 60 requests an hour.
+var (
+	githubAPIURL = strings.TrimRight(getEnv("GITHUB_API_URL", "https://api.github.com"), "/")
+	githubToken  = os.Getenv("GITHUB_TOKEN")
+	githubHTTP   = &http.Client{Timeout: githubTimeout}
+)
+
+const (
+	// githubTimeout bounds one GitHub API call
+	githubTimeout = 15 * time.Second
+	// maxIssuePages bounds the pages of issues read from one repository per
+	// sync, 100 issues each
+	maxIssuePages = 20
+	// maxTitleLength is the size of the title column of tasks
+	maxTitleLength = 255
+	// maxDescriptionLength bounds the part of an issue's body a task keeps
+	maxDescriptionLength = 4000
+)
+
+// githubSource is the external_source of the tasks synced from issues
+const githubSource = "github"
+
+// Issue is the part of a GitHub issue a task is made from
+type Issue struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	// State is open or closed
+	State string `json:"state"`
+	// StateReason is completed or not_planned for a closed issue, and
+	// reopened for an open issue that was closed before
+	StateReason string `json:"state_reason"`
+	HTMLURL     string `json:"html_url"`
+	Labels      []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Assignees []struct {
+		Login string `json:"login"`
+	} `json:"assignees"`
+	Milestone *struct {
+		DueOn *time.Time `json:"due_on"`
+	} `json:"milestone"`
+	// PullRequest is set on pull requests, which the issues API lists too
+	PullRequest *struct{} `json:"pull_request"`
+}
+
+// assignedTo reports whether login is one of the issue's assignees.
+func (issue Issue) assignedTo(login string) bool {
+	for _, assignee := range issue.Assignees {
+		if strings.EqualFold(assignee.Login, login) {
+			return true
+		}
+	}
+	return false
+}
+
+// listAssignedIssues returns the issues of repo assigned to login: the
+// open ones when since is nil, or else those changed since, open or
+// closed.
+func listAssignedIssues(ctx context.Context, repo, login string, since *time.Time) ([]Issue, error) {
+	query := url.Values{"assignee": {login}, "per_page": {"100"}, "sort": {"updated"}, "direction": {"asc"}}
+	if since == nil {
+		query.Set("state", "open")
+	} else {
+		query.Set("state", "all")
+		query.Set("since", since.UTC().Format(time.RFC3339))
+	}
+
+	issues := []Issue{}
+	next := githubAPIURL + "/repos/" + repo + "/issues?" + query.Encode()
+	for page := 0; next != "" && page < maxIssuePages; page++ {
+		var batch []Issue
+		var err error
+		if next, err = githubGet(ctx, next, &batch); err != nil {
+			return nil, err
+		}
+		for _, issue := range batch {
+			if issue.PullRequest == nil {
+				issues = append(issues, issue)
+			}
+		}
+	}
+	return issues, nil
+}
+
+// nextLinkPattern finds the next page in a Link header
+var nextLinkPattern = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// githubGet reads the JSON at rawURL into out and returns the URL of the
+// next page, if any.
+func githubGet(ctx context.Context, rawURL string, out interface{}) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if githubToken != "" {
+		req.Header.Set("Authorization", "Bearer "+githubToken)
+	}
+
+	resp, err := githubHTTP.Do(req)
+	if err != nil {
+		githubAPICallsTotal.WithLabelValues("error").Inc()
+		return "", err
+	}
+	defer resp.Body.Close()
+	githubAPICallsTotal.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("not found or not accessible with the configured GITHUB_TOKEN")
+	case (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) &&
+		resp.Header.Get("X-RateLimit-Remaining") == "0":
+		reset, _ := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+		return "", fmt.Errorf("GitHub rate limit exceeded until %s", time.Unix(reset, 0).UTC().Format(time.RFC3339))
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("GitHub API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return "", err
+	}
+	if match := nextLinkPattern.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+		return match[1], nil
+	}
+	return "", nil
+}
+
+// issueTask returns the external_id of an issue of repo and the task it
+// is synced to. A closed issue is done; one reopened is pending again,
+// and any other open issue keeps the status of its task, so that work
+// started on it locally is not undone.
+func issueTask(repo string, issue Issue) (string, client.ExternalTaskRequest) {
+	task := client.ExternalTaskRequest{
+		Title:       truncate(issue.Title, maxTitleLength),
+		Description: issue.HTMLURL,
+		Priority:    labelPriority(issue),
+		Tags:        []string{githubSource},
+	}
+	if body := strings.TrimSpace(issue.Body); body != "" {
+		task.Description = strings.TrimSpace(task.Description + "\n\n" + truncate(body, maxDescriptionLength))
+	}
+	for _, label := range issue.Labels {
+		task.Tags = append(task.Tags, strings.ToLower(label.Name))
+	}
+	if issue.Milestone != nil && issue.Milestone.DueOn != nil {
+		task.DueDate = issue.Milestone.DueOn.UTC().Format("2006-01-02")
+	}
+	switch {
+	case issue.State == "closed":
+		task.Status = "done"
+	case issue.StateReason == "reopened":
+		task.Status = "pending"
+	}
+	return fmt.Sprintf("%s#%d", repo, issue.Number), task
+}
+
+// labelPriorities are the priorities of the labels teams commonly use
+// for them
+var labelPriorities = map[string]string{
+	"p0": "high", "p1": "high", "priority: high": "high", "high priority": "high",
+	"critical": "high", "urgent": "high", "priority/high": "high",
+	"p3": "low", "p4": "low", "priority: low": "low", "low priority": "low", "priority/low": "low",
+}
+
+// labelPriority returns the priority of an issue's labels, the highest
+// one if they disagree, medium when none tells.
+func labelPriority(issue Issue) string {
+	priority := "medium"
+	for _, label := range issue.Labels {
+		switch labelPriorities[strings.ToLower(label.Name)] {
+		case "high":
+			return "high"
+		case "low":
+			priority = "low"
+		}
+	}
+	return priority
+}
+
+// truncate shortens s to at most n characters.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/database"
+	"github.com/lib/pq"
+)
+
+// Issues reach task-service in two ways. The poller syncs each enabled
+// configuration every GITHUB_SYNC_INTERVAL, fetching only the issues
+// changed since the last sync that succeeded, and a webhook of the
+// repositories' "issues" events syncs an issue as soon as it changes.
+// Either way every issue is put with its external_id, owner/repo#number,
+// so syncing it again, or by both ways at once, never duplicates its task.
+
+// Sync triggers
+const (
+	triggerPoll    = "poll"
+	triggerManual  = "manual"
+	triggerWebhook = "webhook"
+)
+
+// tasksClient saves the synced issues as tasks, on behalf of each user
+var tasksClient = client.NewTasksClient(getEnv("TASK_SERVICE_URL", "http://task-service:8081"))
+
+// jwtSecret signs the short-lived tokens syncs act with
+var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+
+// webhookSecret is the secret GitHub signs webhook deliveries with;
+// without it webhooks are refused
+var webhookSecret = []byte(os.Getenv("GITHUB_WEBHOOK_SECRET"))
+
+// syncInterval is how often the poller syncs each configuration
+var syncInterval = parseDurationEnv("GITHUB_SYNC_INTERVAL", 15*time.Minute)
+
+const (
+	// syncTimeout bounds one sync of a user's repositories
+	syncTimeout = 5 * time.Minute
+	// userTokenTTL bounds the tokens a sync acts with
+	userTokenTTL = 5 * time.Minute
+	// maxWebhookSize bounds the body of a webhook delivery
+	maxWebhookSize = 1 << 20
+)
+
+// SyncResult is the outcome of a sync
+type SyncResult struct {
+	Repos     []RepoResult `json:"repos"`
+	Created   int          `json:"created"`
+	Updated   int          `json:"updated"`
+	Unchanged int          `json:"unchanged"`
+	// Errors counts the repositories that failed to sync
+	Errors   int       `json:"errors"`
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// RepoResult is the outcome of syncing one repository
+type RepoResult struct {
+	Repo      string `json:"repo"`
+	Issues    int    `json:"issues"`
+	Created   int    `json:"created"`
+	Updated   int    `json:"updated"`
+	Unchanged int    `json:"unchanged"`
+	Error     string `json:"error,omitempty"`
+}
+
+// runPoller syncs the configurations that are due every minute until ctx
+// is done.
+func runPoller(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+		configs, err := claimDueConfigs(ctx, time.Now().UTC())
+		if err != nil {
+			log.Printf("Failed to load sync configs: %v", err)
+			continue
+		}
+		for _, cfg := range configs {
+			if ctx.Err() != nil {
+				return
+			}
+			if _, err := syncUser(ctx, cfg, cfg.Repos, triggerPoll); err != nil {
+				log.Printf("Failed to sync GitHub issues of user %s: %v", cfg.UserID, err)
+			}
+		}
+	}
+}
+
+// claimDueConfigs marks the enabled configurations not synced for
+// syncInterval as syncing at now and returns them. Claiming them in one
+// statement keeps several replicas from syncing the same user.
+func claimDueConfigs(ctx context.Context, now time.Time) ([]SyncConfig, error) {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		UPDATE github_sync_configs SET last_run_at = $1
+		WHERE enabled AND (last_run_at IS NULL OR last_run_at < $2)
+		RETURNING `+configColumns,
+		now, now.Add(-syncInterval))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	configs := []SyncConfig{}
+	for rows.Next() {
+		cfg, err := scanConfig(rows)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, rows.Err()
+}
+
+// syncUser syncs the issues of repos, all or some of the user's, and
+// records the outcome of a sync of all of them. A repository that fails
+// does not stop the others; the error returned is for the sync as a
+// whole, such as the user's token failing.
+func syncUser(ctx context.Context, cfg SyncConfig, repos []string, trigger string) (SyncResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+
+	started := time.Now().UTC()
+	result := SyncResult{Repos: []RepoResult{}, SyncedAt: started}
+	var failures []string
+	for _, repo := range repos {
+		repoResult := syncRepo(ctx, cfg, repo)
+		if repoResult.Error != "" {
+			result.Errors++
+			failures = append(failures, repo+": "+repoResult.Error)
+		}
+		result.Created += repoResult.Created
+		result.Updated += repoResult.Updated
+		result.Unchanged += repoResult.Unchanged
+		result.Repos = append(result.Repos, repoResult)
+	}
+
+	status := "success"
+	if len(failures) > 0 {
+		status = "error"
+	}
+	githubSyncRunsTotal.WithLabelValues(trigger, status).Inc()
+
+	if len(repos) == len(cfg.Repos) {
+		if err := finishSync(ctx, cfg, started, strings.Join(failures, "; ")); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// syncRepo syncs the issues of repo assigned to the user, stopping at the
+// first that fails.
+func syncRepo(ctx context.Context, cfg SyncConfig, repo string) RepoResult {
+	result := RepoResult{Repo: repo}
+	issues, err := listAssignedIssues(ctx, repo, cfg.GitHubLogin, cfg.SyncedUntil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Issues = len(issues)
+
+	userCtx, err := userContext(ctx, cfg.UserID)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for _, issue := range issues {
+		outcome, err := saveIssue(userCtx, repo, issue)
+		if err != nil {
+			result.Error = fmt.Sprintf("issue #%d: %v", issue.Number, err)
+			return result
+		}
+		switch outcome {
+		case "created":
+			result.Created++
+		case "updated":
+			result.Updated++
+		default:
+			result.Unchanged++
+		}
+	}
+	return result
+}
+
+// saveIssue puts the task of an issue of repo, acting for the user of
+// ctx, and returns whether it was created, updated or unchanged.
+func saveIssue(ctx context.Context, repo string, issue Issue) (string, error) {
+	externalID, task := issueTask(repo, issue)
+	saved, err := tasksClient.UpsertExternal(ctx, githubSource, externalID, task)
+	if err != nil {
+		githubSyncIssuesTotal.WithLabelValues("error").Inc()
+		return "", err
+	}
+	githubSyncIssuesTotal.WithLabelValues(saved.Result).Inc()
+	return saved.Result, nil
+}
+
+// finishSync records a sync of all of cfg's repositories that started at
+// started. The issues changed since are only fetched next time when every
+// repository synced and the configuration did not change meanwhile.
+func finishSync(ctx context.Context, cfg SyncConfig, started time.Time, failure string) error {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		UPDATE github_sync_configs SET
+			last_run_at = GREATEST(last_run_at, $2),
+			synced_until = CASE
+				WHEN $3 = '' AND github_login = $4 AND repos = $5::text[] THEN $2
+				ELSE synced_until
+			END,
+			last_error = NULLIF($3, '')
+		WHERE user_id = $1
+	`, cfg.UserID, started, failure, cfg.GitHubLogin, pq.Array(cfg.Repos))
+	return err
+}
+
+// webhookEvent is the part of an "issues" webhook delivery a sync needs
+type webhookEvent struct {
+	Action     string `json:"action"`
+	Issue      Issue  `json:"issue"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// handleWebhook syncs the issue of an "issues" event, signed with
+// GITHUB_WEBHOOK_SECRET, for every user syncing its repository it is
+// assigned to. An issue unassigned from a user keeps its task. Other
+// events are acknowledged and ignored.
+func handleWebhook(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		githubSyncRequestDuration.WithLabelValues("POST", "/webhooks/github").Observe(time.Since(start).Seconds())
+	}()
+
+	if len(webhookSecret) == 0 {
+		githubSyncRequestsTotal.WithLabelValues("POST", "/webhooks/github", "error").Inc()
+		http.Error(w, "Webhooks are not configured", http.StatusServiceUnavailable)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookSize))
+	if err != nil {
+		githubSyncRequestsTotal.WithLabelValues("POST", "/webhooks/github", "error").Inc()
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	if !validSignature(body, r.Header.Get("X-Hub-Signature-256")) {
+		githubSyncRequestsTotal.WithLabelValues("POST", "/webhooks/github", "error").Inc()
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("X-GitHub-Event") != "issues" {
+		githubSyncRequestsTotal.WithLabelValues("POST", "/webhooks/github", "ignored").Inc()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var event webhookEvent
+	if err := json.Unmarshal(body, &event); err != nil || event.Repository.FullName == "" {
+		githubSyncRequestsTotal.WithLabelValues("POST", "/webhooks/github", "error").Inc()
+		http.Error(w, "Invalid event", http.StatusBadRequest)
+		return
+	}
+	repo := strings.ToLower(event.Repository.FullName)
+
+	ctx, cancel := database.WithTimeout(r.Context())
+	configs, err := repoConfigs(ctx, repo)
+	cancel()
+	if err != nil {
+		githubSyncRequestsTotal.WithLabelValues("POST", "/webhooks/github", "error").Inc()
+		http.Error(w, "Failed to query sync configs", http.StatusInternalServerError)
+		return
+	}
+
+	synced := []string{}
+	for _, cfg := range configs {
+		if !event.Issue.assignedTo(cfg.GitHubLogin) {
+			continue
+		}
+		userCtx, err := userContext(r.Context(), cfg.UserID)
+		if err == nil {
+			_, err = saveIssue(userCtx, repo, event.Issue)
+		}
+		if err != nil {
+			githubSyncRunsTotal.WithLabelValues(triggerWebhook, "error").Inc()
+			githubSyncRequestsTotal.WithLabelValues("POST", "/webhooks/github", "error").Inc()
+			log.Printf("Failed to sync %s#%d for user %s: %v", repo, event.Issue.Number, cfg.UserID, err)
+			http.Error(w, "Failed to sync issue", http.StatusBadGateway)
+			return
+		}
+		githubSyncRunsTotal.WithLabelValues(triggerWebhook, "success").Inc()
+		synced = append(synced, cfg.UserID)
+	}
+
+	githubSyncRequestsTotal.WithLabelValues("POST", "/webhooks/github", "success").Inc()
+	writeJSONResponse(w, map[string]interface{}{"action": event.Action, "synced_users": len(synced)})
+}
+
+// validSignature reports whether signature, "sha256=" and the hex HMAC of
+// body, was made with webhookSecret.
+func validSignature(body []byte, signature string) bool {
+	sum, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	mac := hmac.New(sha256.New, webhookSecret)
+	mac.Write(body)
+	return hmac.Equal(sum, mac.Sum(nil))
+}
+
+// repoConfigs returns the enabled configurations syncing repo.
+func repoConfigs(ctx context.Context, repo string) ([]SyncConfig, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+configColumns+" FROM github_sync_configs WHERE enabled AND repos @> $1",
+		pq.Array([]string{repo}))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	configs := []SyncConfig{}
+	for rows.Next() {
+		cfg, err := scanConfig(rows)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, rows.Err()
+}
+
+// userContext returns ctx carrying a short-lived token of userID, which
+// task-service accepts like one from the auth service. Without JWT_SECRET
+// only the default user's issues can be synced.
+func userContext(ctx context.Context, userID string) (context.Context, error) {
+	if len(jwtSecret) == 0 {
+		if userID != auth.DefaultUser {
+			return nil, fmt.Errorf("JWT_SECRET is required to sync issues of user %s", userID)
+		}
+		return ctx, nil
+	}
+	now := time.Now()
+	token, err := auth.Sign(auth.Claims{
+		Subject:   userID,
+		Issuer:    auth.Issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(userTokenTTL).Unix(),
+	}, jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+	return client.WithToken(ctx, token), nil
+}
+
+func parseDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Warning: invalid %s %q, using %s", key, value, defaultValue)
+	}
+	return defaultValue
+}
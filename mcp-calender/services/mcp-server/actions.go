@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Types of the actions that can be undone
+const (
+	actionCreateTask  = "create_task"
+	actionDeleteTask  = "delete_task"
+	actionCreateEvent = "create_event"
+	actionDeleteEvent = "delete_event"
+)
+
+// Action is a change made through a tool call, with what is needed to
+// reverse it
+type Action struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Task or Event is the object as it was right after it was created or
+	// right before it was deleted
+	Task      *client.Task  `json:"task,omitempty"`
+	Event     *client.Event `json:"event,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// UndoResult is the result of undo_last_action. Undoing a deletion
+// recreates the object, under a new ID.
+type UndoResult struct {
+	Undone        Action        `json:"undone"`
+	RestoredTask  *client.Task  `json:"restored_task,omitempty"`
+	RestoredEvent *client.Event `json:"restored_event,omitempty"`
+}
+
+// The actions of each user are a Redis list, newest first, so that every
+// replica of the server can undo them
+const (
+	maxActions   = 50
+	actionsTTL   = 7 * 24 * time.Hour
+	redisTimeout = 2 * time.Second
+)
+
+var redisClient *redis.Client
+
+// errNothingToUndo is returned by undoLastAction when the user has no
+// recorded actions left
+var errNothingToUndo = errors.New("no action to undo")
+
+var actionsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mcp_actions_total",
+		Help: "Total number of reversible actions recorded and undone",
+	},
+	[]string{"type", "status"},
+)
+
+func init() {
+	prometheus.MustRegister(actionsTotal)
+}
+
+// initRedis creates the client of the action log. Startup waits for Redis
+// with the other dependencies; until it is reachable, actions cannot be
+// undone.
+func initRedis() {
+	redisClient = redis.NewClient(&redis.Options{
+		Addr:     getEnv("REDIS_URL", "redis:6379"),
+		Password: getEnv("REDIS_PASSWORD", ""),
+	})
+}
+
+func pingRedis(ctx context.Context) error {
+	return redisClient.Ping(ctx).Err()
+}
+
+func actionsKey(userID string) string {
+	return "actions:" + userID
+}
+
+// recordAction appends an action to the user's history, dropping the
+// oldest beyond maxActions. The change it records has already been made,
+// so a failure is only logged.
+func recordAction(userID string, action Action) {
+	action.ID = strconv.FormatInt(time.Now().UnixNano(), 36)
+	action.CreatedAt = time.Now().UTC()
+	data, err := json.Marshal(action)
+	if err != nil {
+		log.Printf("Failed to encode %s action: %v", action.Type, err)
+		return
+	}
+
+	// Record the action even if the client has gone away meanwhile
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	key := actionsKey(userID)
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, key, data)
+		pipe.LTrim(ctx, key, 0, maxActions-1)
+		pipe.Expire(ctx, key, actionsTTL)
+		return nil
+	})
+	if err != nil {
+		actionsTotal.WithLabelValues(action.Type, "record_failed").Inc()
+		log.Printf("Failed to record %s action for %s: %v", action.Type, userID, err)
+		return
+	}
+	actionsTotal.WithLabelValues(action.Type, "recorded").Inc()
+}
+
+// listActions returns the user's actions that can still be undone, newest
+// first.
+func listActions(ctx context.Context, userID string) ([]Action, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	items, err := redisClient.LRange(ctx, actionsKey(userID), 0, maxActions-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("reading actions: %w", err)
+	}
+	actions := make([]Action, 0, len(items))
+	for _, item := range items {
+		var action Action
+		if err := json.Unmarshal([]byte(item), &action); err != nil {
+			continue
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+// undoLastAction reverses the user's most recent action. The action is
+// taken off the history first, so concurrent undos never reverse it
+// twice, and put back if it cannot be reversed.
+func undoLastAction(ctx context.Context, userID string) (*UndoResult, error) {
+	key := actionsKey(userID)
+	redisCtx, cancel := context.WithTimeout(ctx, redisTimeout)
+	item, err := redisClient.LPop(redisCtx, key).Result()
+	cancel()
+	if err == redis.Nil {
+		return nil, errNothingToUndo
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading actions: %w", err)
+	}
+
+	var action Action
+	if err := json.Unmarshal([]byte(item), &action); err != nil {
+		return nil, fmt.Errorf("decoding action: %w", err)
+	}
+
+	result, err := reverseAction(ctx, action)
+	if err != nil {
+		actionsTotal.WithLabelValues(action.Type, "undo_failed").Inc()
+		restoreCtx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+		if pushErr := redisClient.LPush(restoreCtx, key, item).Err(); pushErr != nil {
+			log.Printf("Failed to put back %s action for %s: %v", action.Type, userID, pushErr)
+		}
+		return nil, err
+	}
+	actionsTotal.WithLabelValues(action.Type, "undone").Inc()
+	return result, nil
+}
+
+// reverseAction makes the change that cancels out action. Objects that
+// were created and are already gone count as undone.
+func reverseAction(ctx context.Context, action Action) (*UndoResult, error) {
+	result := &UndoResult{Undone: action}
+	switch {
+	case action.Type == actionCreateTask && action.Task != nil:
+		if err := tasksClient.Delete(ctx, action.Task.ID); err != nil && !errors.Is(err, client.ErrNotFound) {
+			return nil, err
+		}
+	case action.Type == actionDeleteTask && action.Task != nil:
+		task, err := tasksClient.Create(ctx, client.CreateTaskRequest{
+			Title:       action.Task.Title,
+			Description: action.Task.Description,
+			Priority:    action.Task.Priority,
+			DueDate:     action.Task.DueDate,
+			Tags:        action.Task.Tags,
+		})
+		if err != nil {
+			return nil, err
+		}
+		// The task is back by now, so the undo stands even if its status
+		// cannot be restored
+		if action.Task.Status != "" && action.Task.Status != task.Status {
+			status := action.Task.Status
+			if updated, err := tasksClient.Update(ctx, task.ID, client.UpdateTaskRequest{Status: &status}); err != nil {
+				log.Printf("Failed to restore status of task %d: %v", task.ID, err)
+			} else {
+				task = updated
+			}
+		}
+		result.RestoredTask = task
+	case action.Type == actionCreateEvent && action.Event != nil:
+		if err := calendarClient.DeleteEvent(ctx, action.Event.ID); err != nil && !errors.Is(err, client.ErrNotFound) {
+			return nil, err
+		}
+	case action.Type == actionDeleteEvent && action.Event != nil:
+		// Restore a formatted description as it was, not as Markdown
+		description := action.Event.Description
+		if action.Event.DescriptionHTML != "" {
+			description = action.Event.DescriptionHTML
+		}
+		event, err := calendarClient.CreateEvent(ctx, client.CreateEventRequest{
+			Summary:     action.Event.Summary,
+			Description: description,
+			Start:       action.Event.Start.Format(time.RFC3339),
+			End:         action.Event.End.Format(time.RFC3339),
+			Location:    action.Event.Location,
+			Attachments: action.Event.Attachments,
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.RestoredEvent = event
+	default:
+		return nil, fmt.Errorf("cannot undo %s action", action.Type)
+	}
+	return result, nil
+}
+
+// handleListActions returns the caller's actions that can be undone,
+// newest first.
+func handleListActions(w http.ResponseWriter, r *http.Request) {
+	actions, err := listActions(r.Context(), auth.UserID(r))
+	if err != nil {
+		http.Error(w, "Failed to read actions", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSONResponse(w, map[string]interface{}{"actions": actions})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/health"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The backends are checked every backendCheckInterval while the server
+// runs, and tools/list reports the tools needing a backend that is down
+// with "status": "degraded" and why, so that clients can avoid calls that
+// are bound to fail. With MCP_HIDE_DEGRADED_TOOLS=true they are left out
+// of the list instead. Either way they come back as soon as their backend
+// does, and calling them still works the same. Clients with an open stream
+// are sent notifications/tools/list_changed each time; see streams.go.
+
+// backendCheckInterval is how often the backends are checked
+var backendCheckInterval = 15 * time.Second
+
+// backendCheckTimeout bounds a check of a backend
+const backendCheckTimeout = 2 * time.Second
+
+// hideDegradedTools leaves degraded tools out of tools/list
+var hideDegradedTools bool
+
+// toolDegraded is the status of a tool whose backend is down
+const toolDegraded = "degraded"
+
+var backendUp = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mcp_backend_up",
+		Help: "Whether a backend passed its last health check (1) or not (0)",
+	},
+	[]string{"backend"},
+)
+
+func init() {
+	prometheus.MustRegister(backendUp)
+}
+
+// backendStatus is the backends that failed their last check
+var backendStatus = struct {
+	sync.RWMutex
+	down map[string]bool
+}{down: map[string]bool{}}
+
+// initBackendStatus reads the settings of the backend checks.
+func initBackendStatus() {
+	if interval := os.Getenv("BACKEND_CHECK_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid BACKEND_CHECK_INTERVAL %q: expected a positive duration such as 15s", interval)
+		}
+		backendCheckInterval = d
+	}
+	if hide := os.Getenv("MCP_HIDE_DEGRADED_TOOLS"); hide != "" {
+		b, err := strconv.ParseBool(hide)
+		if err != nil {
+			log.Fatalf("Invalid MCP_HIDE_DEGRADED_TOOLS %q: expected true or false", hide)
+		}
+		hideDegradedTools = b
+	}
+}
+
+// monitorBackends checks backends every backendCheckInterval until ctx is
+// done.
+func monitorBackends(ctx context.Context, backends []health.Dependency) {
+	ticker := time.NewTicker(backendCheckInterval)
+	defer ticker.Stop()
+	for {
+		checkBackends(ctx, backends)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkBackends checks every backend at once and records which are down,
+// logging those that went down or came back and telling the clients that
+// the tools changed if any did.
+func checkBackends(ctx context.Context, backends []health.Dependency) {
+	errs := make([]error, len(backends))
+	var wg sync.WaitGroup
+	for i, backend := range backends {
+		wg.Add(1)
+		go func(i int, backend health.Dependency) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, backendCheckTimeout)
+			defer cancel()
+			errs[i] = backend.Check(checkCtx)
+		}(i, backend)
+	}
+	wg.Wait()
+
+	if recordBackends(backends, errs) {
+		notifyClients("notifications/tools/list_changed", nil)
+	}
+}
+
+// recordBackends records which backends are down from the errors of their
+// checks, and reports whether any went down or came back.
+func recordBackends(backends []health.Dependency, errs []error) bool {
+	backendStatus.Lock()
+	defer backendStatus.Unlock()
+	changed := false
+	for i, backend := range backends {
+		wasDown := backendStatus.down[backend.Name]
+		if errs[i] != nil {
+			if !wasDown {
+				log.Printf("Warning: %s is down, its tools are degraded: %v", backend.Name, errs[i])
+				changed = true
+			}
+			backendStatus.down[backend.Name] = true
+			backendUp.WithLabelValues(backend.Name).Set(0)
+			continue
+		}
+		if wasDown {
+			log.Printf("%s is back up", backend.Name)
+			changed = true
+		}
+		delete(backendStatus.down, backend.Name)
+		backendUp.WithLabelValues(backend.Name).Set(1)
+	}
+	return changed
+}
+
+// listedTools returns the tools for tools/list: those whose backends are
+// down marked degraded, or left out with hideDegradedTools.
+func listedTools() []Tool {
+	backendStatus.RLock()
+	defer backendStatus.RUnlock()
+
+	tools := []Tool{}
+	for _, tool := range registeredTools() {
+		var down []string
+		for _, backend := range backendsOf(tool.Name) {
+			if backendStatus.down[backend] {
+				down = append(down, backend)
+			}
+		}
+		if len(down) > 0 {
+			if hideDegradedTools {
+				continue
+			}
+			tool.Status = toolDegraded
+			tool.StatusReason = fmt.Sprintf("%s unavailable", strings.Join(down, " and "))
+		}
+		tools = append(tools, tool)
+	}
+	return tools
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Batches: a POST to /mcp may hold a JSON array of requests, such as
+// calls fetching the tasks, the events and the weather, to save round
+// trips. They are served concurrently by up to batchWorkers at a time, so
+// in no particular order, and answered with an array of the responses to
+// those that are not notifications, in the order of the requests. A batch
+// of notifications only is answered with 202 Accepted and no body, and an
+// empty batch, or one of more than maxBatchSize requests, with a single
+// error. Requests that depend on each other, such as a call and an
+// undo_last_action undoing it, belong in separate POSTs.
+
+// maxBatchSize bounds the requests of a batch
+var maxBatchSize = 20
+
+// batchWorkers is how many requests of a batch are served at a time
+var batchWorkers = 4
+
+// initBatches reads the settings of batches.
+func initBatches() {
+	if size := os.Getenv("MCP_MAX_BATCH_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid MCP_MAX_BATCH_SIZE %q: expected a positive number", size)
+		}
+		maxBatchSize = n
+	}
+	if workers := os.Getenv("MCP_BATCH_WORKERS"); workers != "" {
+		n, err := strconv.Atoi(workers)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid MCP_BATCH_WORKERS %q: expected a positive number", workers)
+		}
+		batchWorkers = n
+	}
+}
+
+// handleBatch serves a batch of requests of r, data being its JSON array.
+func handleBatch(w http.ResponseWriter, r *http.Request, data []byte) {
+	var messages []json.RawMessage
+	if err := json.Unmarshal(data, &messages); err != nil || len(messages) == 0 {
+		mcpRequestsTotal.WithLabelValues("", "error").Inc()
+		writeErrorResponse(w, nil, &MCPError{Code: -32600, Message: "Invalid Request", Data: "a batch holds at least one request"})
+		return
+	}
+	if len(messages) > maxBatchSize {
+		mcpRequestsTotal.WithLabelValues("", "error").Inc()
+		writeErrorResponse(w, nil, &MCPError{Code: -32600, Message: "Invalid Request", Data: map[string]int{"max_batch_size": maxBatchSize}})
+		return
+	}
+
+	responses := make([]MCPResponse, len(messages))
+	answered := make([]bool, len(messages))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < batchWorkers && i < len(messages); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range next {
+				responses[j], answered[j] = serveMessage(r, messages[j])
+			}
+		}()
+	}
+	for i := range messages {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var out []MCPResponse
+	for i, response := range responses {
+		if answered[i] {
+			out = append(out, rpcResponse(response))
+		}
+	}
+	if len(out) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	writeJSONResponse(w, out)
+}
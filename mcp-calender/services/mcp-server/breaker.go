@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Circuit breakers: without them, a backend that is down or hanging makes
+// every call to it wait for the client's timeout, 10 seconds an attempt,
+// before failing. Each backend has a breaker, which opens once
+// MCP_BREAKER_FAILURES calls to it in a row have failed; calls to it then
+// fail at once with error -32012 for MCP_BREAKER_COOLDOWN. After that, a
+// single call at a time goes through as a probe (half-open): the breaker
+// closes when it succeeds and opens for another cooldown when it fails.
+//
+// Failures are calls that got no response, timeouts included, and 5xx
+// responses, injected by chaos mode or not; a 4xx is the caller's doing,
+// and calls the MCP client cancelled count for nothing. The breakers'
+// states are in mcp_backend_breaker_state, 0 closed, 1 half-open and 2
+// open, and the calls they refused in mcp_backend_breaker_rejections_total.
+// The backend checks of backends.go are separate: they only mark tools
+// degraded and never refuse calls.
+
+// Settings of the breakers, read by initBreakers before any call is made.
+// No failures turns the breakers off.
+var (
+	breakerFailures = 5
+	breakerCooldown = 30 * time.Second
+)
+
+// breakerState is the state of a breaker, as in mcp_backend_breaker_state
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+var (
+	breakerStateGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mcp_backend_breaker_state",
+			Help: "State of the circuit breaker of a backend: 0 closed, 1 half-open, 2 open",
+		},
+		[]string{"backend"},
+	)
+	breakerRejectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mcp_backend_breaker_rejections_total",
+			Help: "Number of calls to a backend refused by its open circuit breaker",
+		},
+		[]string{"backend"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(breakerStateGauge)
+	prometheus.MustRegister(breakerRejectionsTotal)
+}
+
+// initBreakers reads MCP_BREAKER_FAILURES and MCP_BREAKER_COOLDOWN.
+func initBreakers() {
+	if failures := os.Getenv("MCP_BREAKER_FAILURES"); failures != "" {
+		n, err := strconv.Atoi(failures)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid MCP_BREAKER_FAILURES %q: expected a number of calls, or 0 to turn the breakers off", failures)
+		}
+		breakerFailures = n
+	}
+	if cooldown := os.Getenv("MCP_BREAKER_COOLDOWN"); cooldown != "" {
+		d, err := time.ParseDuration(cooldown)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid MCP_BREAKER_COOLDOWN %q: expected a positive duration such as 30s", cooldown)
+		}
+		breakerCooldown = d
+	}
+}
+
+// breakerOpenError is the error of a call refused by an open breaker.
+type breakerOpenError struct {
+	Service string
+	// RetryIn is how long until the next probe, or 0 while a probe is on
+	RetryIn time.Duration
+}
+
+func (e *breakerOpenError) Error() string {
+	if e.RetryIn <= 0 {
+		return fmt.Sprintf("%s keeps failing; calls resume once the call testing it succeeds", e.Service)
+	}
+	return fmt.Sprintf("%s keeps failing; calls resume in %s", e.Service, e.RetryIn.Round(time.Second))
+}
+
+func (e *breakerOpenError) Unwrap() error {
+	return client.ErrCircuitOpen
+}
+
+// breaker is the circuit breaker of a backend.
+type breaker struct {
+	service string
+
+	mu    sync.Mutex
+	state breakerState
+	// failures is the count of failed calls in a row while closed
+	failures int
+	// until is when an open breaker lets a probe through
+	until time.Time
+	// probing is set while a half-open breaker's probe is on
+	probing bool
+}
+
+// breakers are the breakers by backend, made on the first call
+var breakers = struct {
+	sync.Mutex
+	byService map[string]*breaker
+}{byService: map[string]*breaker{}}
+
+// breakerOf returns the breaker of service, or nil if breakers are off.
+func breakerOf(service string) *breaker {
+	if breakerFailures == 0 {
+		return nil
+	}
+	breakers.Lock()
+	defer breakers.Unlock()
+	b := breakers.byService[service]
+	if b == nil {
+		b = &breaker{service: service}
+		breakers.byService[service] = b
+		breakerStateGauge.WithLabelValues(service).Set(float64(breakerClosed))
+	}
+	return b
+}
+
+// allow lets a call through, reporting whether it is the probe of a
+// half-open breaker, or refuses it with a *breakerOpenError.
+func (b *breaker) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if wait := time.Until(b.until); wait > 0 {
+			return false, &breakerOpenError{Service: b.service, RetryIn: wait}
+		}
+		b.setState(breakerHalfOpen)
+		log.Printf("Circuit breaker of %s is half-open, testing it with the next call", b.service)
+	case breakerHalfOpen:
+		if b.probing {
+			return false, &breakerOpenError{Service: b.service}
+		}
+	default:
+		return false, nil
+	}
+	b.probing = true
+	return true, nil
+}
+
+// done records the outcome of a call allow let through: whether it failed,
+// or ignore for a call that tells nothing about the backend.
+func (b *breaker) done(probe, failed, ignore bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	switch {
+	case ignore:
+	case !failed:
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+			log.Printf("Circuit breaker of %s is closed, %s is back", b.service, b.service)
+		}
+	case probe:
+		b.open()
+	case b.state == breakerClosed:
+		b.failures++
+		if b.failures >= breakerFailures {
+			b.open()
+		}
+	}
+}
+
+// open opens the breaker for breakerCooldown.
+func (b *breaker) open() {
+	b.failures = 0
+	b.until = time.Now().Add(breakerCooldown)
+	b.setState(breakerOpen)
+	log.Printf("Warning: Circuit breaker of %s is open, refusing calls for %s", b.service, breakerCooldown)
+}
+
+func (b *breaker) setState(state breakerState) {
+	b.state = state
+	breakerStateGauge.WithLabelValues(b.service).Set(float64(state))
+}
+
+// breakerTransport sends requests to a backend through its breaker.
+type breakerTransport struct {
+	service string
+	next    http.RoundTripper
+}
+
+func (t breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := breakerOf(t.service)
+	if b == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	probe, err := b.allow()
+	if err != nil {
+		breakerRejectionsTotal.WithLabelValues(t.service).Inc()
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	cancelled := err != nil && errors.Is(req.Context().Err(), context.Canceled)
+	b.done(probe, err != nil || resp.StatusCode >= 500, cancelled)
+	return resp, err
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Response cache: the results of read-only tools are kept in Redis for a
+// while, so that an agent asking for the weather or the tasks again and
+// again within a conversation does not call the backends each time. A
+// result is kept per user, tool and arguments, the arguments taken as
+// they are after memory filled them in, with the null ones left out. The
+// TTL of each tool is in toolCacheTTLs, which MCP_CACHE_TTLS changes as
+// tool=duration pairs, 0 turning a tool's cache off. A call with the
+// no_cache param set to true skips the cache and keeps its fresh result.
+//
+// A call of a tool that may change tasks drops the user's cached get_tasks
+// results; tasks changed through the task service directly may be seen
+// late by up to the TTL. Errors are never cached, and a cache Redis cannot
+// serve is skipped. Lookups are counted in mcp_tool_cache_total.
+
+// toolCacheTTLs are how long the results of the cached tools are kept
+var toolCacheTTLs = map[string]time.Duration{
+	"get_weather":       5 * time.Minute,
+	"will_it_rain_soon": time.Minute,
+	"get_tasks":         15 * time.Second,
+}
+
+// tasksUntouchedBy are the tools whose calls never change tasks, and so
+// leave the cached get_tasks results alone
+var tasksUntouchedBy = map[string]bool{
+	"get_tasks":           true,
+	"get_calendar_events": true,
+	"create_event":        true,
+	"delete_event":        true,
+	"get_agenda":          true,
+	"get_weather":         true,
+	"will_it_rain_soon":   true,
+	"send_notification":   true,
+	"remember":            true,
+	"recall":              true,
+}
+
+var toolCacheTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mcp_tool_cache_total",
+		Help: "Total number of tool calls looked up in the response cache, by result: hit, miss, bypass or error",
+	},
+	[]string{"tool", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(toolCacheTotal)
+}
+
+// initCache reads MCP_CACHE_TTLS.
+func initCache() {
+	setting := os.Getenv("MCP_CACHE_TTLS")
+	if setting == "" {
+		return
+	}
+	for _, pair := range strings.Split(setting, ",") {
+		tool, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if _, cached := toolCacheTTLs[tool]; !ok || !cached {
+			log.Fatalf("Invalid MCP_CACHE_TTLS %q: expected tool=duration pairs of get_weather, will_it_rain_soon or get_tasks", setting)
+		}
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			log.Fatalf("Invalid MCP_CACHE_TTLS %q: %s must have a duration such as 30s, or 0", setting, tool)
+		}
+		toolCacheTTLs[tool] = ttl
+	}
+}
+
+// cachedCall is a result kept in the cache.
+type cachedCall struct {
+	Result json.RawMessage `json:"result"`
+	// Expires is when the result goes stale; the hash holding it may
+	// live on for the results stored after it
+	Expires time.Time `json:"expires"`
+}
+
+func toolCacheKey(userID, toolName string) string {
+	return "toolcache:" + userID + ":" + toolName
+}
+
+// cacheField is the field of a call with arguments in the hash of its
+// tool: a hash of the arguments without their null values, which
+// json.Marshal writes with their keys sorted.
+func cacheField(arguments map[string]interface{}) string {
+	canonical := make(map[string]interface{}, len(arguments))
+	for name, value := range arguments {
+		if value != nil {
+			canonical[name] = value
+		}
+	}
+	data, _ := json.Marshal(canonical)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedResult returns the cached result of a call, if toolName is cached
+// and one is fresh. With bypass it only counts the call.
+func cachedResult(ctx context.Context, userID, toolName string, arguments map[string]interface{}, bypass bool) (interface{}, bool) {
+	if toolCacheTTLs[toolName] <= 0 {
+		return nil, false
+	}
+	if bypass {
+		toolCacheTotal.WithLabelValues(toolName, "bypass").Inc()
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	data, err := redisClient.HGet(ctx, toolCacheKey(userID, toolName), cacheField(arguments)).Bytes()
+	if err != nil && err != redis.Nil {
+		toolCacheTotal.WithLabelValues(toolName, "error").Inc()
+		log.Printf("Failed to read the cached %s results of %s: %v", toolName, userID, err)
+		return nil, false
+	}
+	var cached cachedCall
+	var result interface{}
+	if err != nil || json.Unmarshal(data, &cached) != nil || time.Now().After(cached.Expires) ||
+		json.Unmarshal(cached.Result, &result) != nil {
+		toolCacheTotal.WithLabelValues(toolName, "miss").Inc()
+		return nil, false
+	}
+	toolCacheTotal.WithLabelValues(toolName, "hit").Inc()
+	return result, true
+}
+
+// cacheResult keeps the result of a successful call of toolName, if it is
+// cached, and drops the cached tasks after a call that may change them.
+// Failing to is only logged.
+func cacheResult(ctx context.Context, userID, toolName string, arguments map[string]interface{}, result interface{}) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	if !tasksUntouchedBy[toolName] && toolCacheTTLs["get_tasks"] > 0 {
+		if err := redisClient.Del(ctx, toolCacheKey(userID, "get_tasks")).Err(); err != nil {
+			log.Printf("Failed to drop the cached tasks of %s: %v", userID, err)
+		}
+	}
+
+	ttl := toolCacheTTLs[toolName]
+	if ttl <= 0 {
+		return
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(cachedCall{Result: raw, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return
+	}
+	key := toolCacheKey(userID, toolName)
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, cacheField(arguments), data)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to cache the %s results of %s: %v", toolName, userID, err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+)
+
+// Cancellation: a client that no longer wants the answer to a request, such
+// as a get_weather call waiting on a hanging provider, sends the
+// notifications/cancelled notification with its requestId, and the server
+// cancels the backend calls the request is making instead of letting them
+// run to their timeout. The LSP-style $/cancelRequest, with the request's
+// id, is understood too. Only requests of the same user and session can be
+// cancelled; cancelling one that has been answered or is unknown does
+// nothing. A cancelled request is answered with error -32800, as its HTTP
+// request is still waiting for a response.
+
+// inflight are the requests being served that can be cancelled, by caller
+// and id, each with the cancel function of its context under a token of
+// its own, as a client may reuse an id.
+var inflight = struct {
+	sync.Mutex
+	requests map[string]map[uint64]context.CancelFunc
+	next     uint64
+}{requests: map[string]map[uint64]context.CancelFunc{}}
+
+// inflightKey returns the key of the request of id, as raw JSON, of the
+// caller of r, or "" if id is not a valid one.
+func inflightKey(r *http.Request, id json.RawMessage) string {
+	var value interface{}
+	if err := json.Unmarshal(id, &value); err != nil || value == nil {
+		return ""
+	}
+	return requestKey(r, value)
+}
+
+// requestKey returns the key of the request of id, as decoded from JSON,
+// of the caller of r. Ids are compared by their canonical JSON, so that 7
+// and 7.0 are the same request.
+func requestKey(r *http.Request, id interface{}) string {
+	switch id.(type) {
+	case string, float64:
+	default:
+		return ""
+	}
+	canonical, err := json.Marshal(id)
+	if err != nil {
+		return ""
+	}
+	return auth.UserID(r) + "\x00" + sessionID(r) + "\x00" + string(canonical)
+}
+
+// trackRequest returns the context of serving req, a request of r, which
+// is cancelled when the client cancels the request, and the function to
+// call once it has been served.
+func trackRequest(r *http.Request, req MCPRequest) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(r.Context())
+	key := inflightKey(r, req.ID)
+	if key == "" {
+		return ctx, cancel
+	}
+
+	inflight.Lock()
+	inflight.next++
+	token := inflight.next
+	if inflight.requests[key] == nil {
+		inflight.requests[key] = map[uint64]context.CancelFunc{}
+	}
+	inflight.requests[key][token] = cancel
+	inflight.Unlock()
+
+	return ctx, func() {
+		inflight.Lock()
+		delete(inflight.requests[key], token)
+		if len(inflight.requests[key]) == 0 {
+			delete(inflight.requests, key)
+		}
+		inflight.Unlock()
+		cancel()
+	}
+}
+
+// handleCancel serves a cancellation notification of r, method being
+// notifications/cancelled or $/cancelRequest.
+func handleCancel(r *http.Request, req MCPRequest) MCPResponse {
+	param := "requestId"
+	if req.Method == "$/cancelRequest" {
+		param = "id"
+	}
+	id, ok := req.Params[param]
+	key := requestKey(r, id)
+	if !ok || key == "" {
+		return MCPResponse{ID: req.ID, Error: &MCPError{Code: -32602, Message: "Invalid params: " + param + " must be a string or a number"}}
+	}
+
+	inflight.Lock()
+	cancels := inflight.requests[key]
+	for _, cancel := range cancels {
+		cancel()
+	}
+	inflight.Unlock()
+
+	if len(cancels) > 0 {
+		reason, _ := req.Params["reason"].(string)
+		log.Printf("Cancelled request %v of %s: %s", id, auth.UserID(r), reason)
+	}
+	return MCPResponse{ID: req.ID, Result: map[string]interface{}{}}
+}
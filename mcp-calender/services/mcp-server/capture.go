@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+)
+
+// maxTags bounds the tags extracted from a sentence
+const maxTags = 5
+
+// TaskInterpretation is how capture_task read a sentence, returned so the
+// user can confirm or correct it
+type TaskInterpretation struct {
+	Title string `json:"title"`
+	// DueDate is YYYY-MM-DD, or "" when the sentence names no date
+	DueDate  string   `json:"due_date,omitempty"`
+	Priority string   `json:"priority"`
+	Tags     []string `json:"tags"`
+}
+
+const capturePrompt = `Today is %s, %s. Turn the request below into a task.
+Reply with JSON only, in the form {"title": "...", "due_date": "YYYY-MM-DD", "priority": "low|medium|high", "tags": ["..."]}.
+- title: a short imperative phrase, without the date or priority
+- due_date: the date the task is due, resolving words such as "Friday" or "next week" to the next such date; "" if none is given
+- priority: "medium" unless the request says otherwise
+- tags: up to %d lowercase words naming the people, companies or topics involved
+
+Request: %s`
+
+// CaptureResult is the result of capture_task: the interpretation of the
+// sentence and, unless it was a dry run, the task created from it
+type CaptureResult struct {
+	Interpretation *TaskInterpretation `json:"interpretation"`
+	Task           *client.Task        `json:"task,omitempty"`
+}
+
+// captureTask creates a task from a sentence such as "remind me to send
+// the invoice to Acme by Friday, high priority". The sentence is kept as
+// the description of the task.
+func captureTask(ctx context.Context, text string, dryRun bool) (*CaptureResult, error) {
+	interpretation, err := interpretTask(ctx, text, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	result := &CaptureResult{Interpretation: interpretation}
+	if dryRun {
+		return result, nil
+	}
+	result.Task, err = tasksClient.Create(ctx, client.CreateTaskRequest{
+		Title:       interpretation.Title,
+		Description: text,
+		Priority:    interpretation.Priority,
+		DueDate:     interpretation.DueDate,
+		Tags:        interpretation.Tags,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// interpretTask asks the LLM for the title, due date, priority and tags
+// of a task described in free text.
+func interpretTask(ctx context.Context, text string, now time.Time) (*TaskInterpretation, error) {
+	var interpretation TaskInterpretation
+	prompt := fmt.Sprintf(capturePrompt, now.Format("Monday"), now.Format("2006-01-02"), maxTags, text)
+	if err := generateJSON(ctx, prompt, &interpretation); err != nil {
+		return nil, err
+	}
+	normalizeInterpretation(&interpretation, text)
+	return &interpretation, nil
+}
+
+// normalizeInterpretation makes what the LLM returned valid for the task
+// service: unknown priorities become medium and unparseable dates none.
+func normalizeInterpretation(t *TaskInterpretation, text string) {
+	t.Title = strings.TrimSpace(t.Title)
+	if t.Title == "" {
+		t.Title = strings.TrimSpace(text)
+	}
+
+	t.Priority = strings.ToLower(strings.TrimSpace(t.Priority))
+	switch t.Priority {
+	case "low", "medium", "high":
+	case "urgent", "critical":
+		t.Priority = "high"
+	default:
+		t.Priority = "medium"
+	}
+
+	t.DueDate = strings.TrimSpace(t.DueDate)
+	if _, err := time.Parse("2006-01-02", t.DueDate); err != nil {
+		t.DueDate = ""
+	}
+
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, tag := range t.Tags {
+		tag = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(tag, "#")))
+		if tag != "" && !seen[tag] && len(tags) < maxTags {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	t.Tags = tags
+}
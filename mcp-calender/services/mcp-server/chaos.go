@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Chaos mode: for testing how agents cope with failing tools, MCP_CHAOS
+// injects faults into the server's calls to its backends, without
+// touching the backends themselves. It holds rules separated by
+// semicolons, each a backend, or * for the backends no rule names,
+// followed by a colon and comma-separated settings:
+//
+//	MCP_CHAOS="task-service:latency=2s,jitter=1s;weather-service:error_rate=0.3;*:malformed_rate=0.1"
+//
+//   - latency delays each call, plus up to jitter more
+//   - error_rate is the share of calls failing with error_status (default
+//     503) without reaching the backend
+//   - malformed_rate is the share of calls whose response, once the
+//     backend has answered, is cut short so that it is not valid JSON
+//
+// Faults are counted in mcp_chaos_faults_total. The calls of the configured
+// tools are subject to it too, but those to the doc agent and the LLM are
+// not. Never set MCP_CHAOS in production.
+
+// ChaosRule is the faults injected into the calls to a backend.
+type ChaosRule struct {
+	Latency       time.Duration
+	Jitter        time.Duration
+	ErrorRate     float64
+	ErrorStatus   int
+	MalformedRate float64
+}
+
+// chaosRules are the rules of MCP_CHAOS by backend, empty unless chaos
+// mode is on. They are set by initChaos before any call is made.
+var chaosRules map[string]ChaosRule
+
+var chaosFaultsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mcp_chaos_faults_total",
+		Help: "Number of faults injected into backend calls in chaos mode",
+	},
+	[]string{"service", "fault"},
+)
+
+func init() {
+	prometheus.MustRegister(chaosFaultsTotal)
+}
+
+// initChaos reads MCP_CHAOS.
+func initChaos() {
+	spec := os.Getenv("MCP_CHAOS")
+	if spec == "" {
+		return
+	}
+	rules, err := parseChaos(spec)
+	if err != nil {
+		log.Fatalf("Invalid MCP_CHAOS: %v", err)
+	}
+	chaosRules = rules
+	for service := range rules {
+		if _, known := knownBackends()[service]; !known && service != "*" {
+			log.Printf("Warning: MCP_CHAOS names %s, which is not a known backend; it only applies to the configured tools' backends", service)
+		}
+	}
+	log.Printf("Warning: Chaos mode is on, injecting faults into backend calls: %s", spec)
+}
+
+// parseChaos parses the rules of MCP_CHAOS.
+func parseChaos(spec string) (map[string]ChaosRule, error) {
+	rules := map[string]ChaosRule{}
+	for _, part := range strings.Split(spec, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		service, settings, ok := strings.Cut(part, ":")
+		service = strings.TrimSpace(service)
+		if !ok || service == "" {
+			return nil, fmt.Errorf("%q: expected service:setting=value,...", part)
+		}
+		if _, dup := rules[service]; dup {
+			return nil, fmt.Errorf("%s has two rules", service)
+		}
+		rule := ChaosRule{ErrorStatus: http.StatusServiceUnavailable}
+		for _, setting := range strings.Split(settings, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(setting), "=")
+			var err error
+			switch key {
+			case "latency":
+				rule.Latency, err = time.ParseDuration(value)
+			case "jitter":
+				rule.Jitter, err = time.ParseDuration(value)
+			case "error_rate":
+				rule.ErrorRate, err = parseRate(value)
+			case "error_status":
+				rule.ErrorStatus, err = strconv.Atoi(value)
+				if err == nil && (rule.ErrorStatus < 400 || rule.ErrorStatus > 599) {
+					err = fmt.Errorf("not an error status")
+				}
+			case "malformed_rate":
+				rule.MalformedRate, err = parseRate(value)
+			default:
+				err = fmt.Errorf("unknown setting")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", service, setting, err)
+			}
+		}
+		if rule.Latency < 0 || rule.Jitter < 0 {
+			return nil, fmt.Errorf("%s: latency and jitter must not be negative", service)
+		}
+		rules[service] = rule
+	}
+	return rules, nil
+}
+
+// parseRate parses a share of calls, from 0 to 1.
+func parseRate(value string) (float64, error) {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err == nil && (rate < 0 || rate > 1) {
+		err = fmt.Errorf("must be between 0 and 1")
+	}
+	return rate, err
+}
+
+// chaosRule returns the rule of service, if chaos mode has one for it.
+func chaosRule(service string) (ChaosRule, bool) {
+	if rule, ok := chaosRules[service]; ok {
+		return rule, true
+	}
+	rule, ok := chaosRules["*"]
+	return rule, ok
+}
+
+// chaosTransport sends requests to a backend, injecting the faults of its
+// rule.
+type chaosTransport struct {
+	service string
+	next    http.RoundTripper
+}
+
+func (t chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rule, ok := chaosRule(t.service)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+
+	if delay := rule.Latency + randomDuration(rule.Jitter); delay > 0 {
+		chaosFaultsTotal.WithLabelValues(t.service, "latency").Inc()
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+	if rand.Float64() < rule.ErrorRate {
+		chaosFaultsTotal.WithLabelValues(t.service, "error").Inc()
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return chaosResponse(req, rule.ErrorStatus, "chaos mode: injected failure"), nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode >= 300 || rand.Float64() >= rule.MalformedRate {
+		return resp, err
+	}
+	chaosFaultsTotal.WithLabelValues(t.service, "malformed").Inc()
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	malformed := chaosResponse(req, resp.StatusCode, `{"chaos": `+string(body[:len(body)/2]))
+	malformed.Header.Set("Content-Type", "application/json")
+	return malformed, nil
+}
+
+// chaosResponse is a response of a backend made up by chaos mode.
+func chaosResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"X-Chaos-Fault": {"true"}},
+		Body:          io.NopCloser(bytes.NewBufferString(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// randomDuration returns a random duration below limit.
+func randomDuration(limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit)))
+}
+
+// sleepContext waits for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
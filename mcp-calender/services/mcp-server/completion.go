@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Completions: MCP's completion/complete suggests values for an argument
+// as the user or model types it. MCP defines completions for the
+// arguments of prompts, named by a ref of the type "ref/prompt", and of
+// resource templates, such as the city of weather://{city}, named by a ref
+// of the type "ref/resource" with the template as its uri. Tool arguments
+// are completed too, for a ref of the type "ref/tool" naming the tool:
+//
+//	"params": {"ref": {"type": "ref/tool", "name": "get_weather"},
+//	           "argument": {"name": "city", "value": "Lon"}}
+//
+// Values come from the argument's enum in the tool's schema, such as a
+// task's priority, or from the backends: city names the weather service
+// knows and the IDs of the user's calendars. A backend that fails gives no
+// suggestions rather than an error, since completing is only a help.
+
+// completionTimeout bounds a completion; a suggestion arriving later than
+// the user types is of no use
+const completionTimeout = 5 * time.Second
+
+// maxCompletionValues is the most values MCP lets a completion return
+const maxCompletionValues = 100
+
+// completer suggests the values of an argument starting with prefix. It
+// returns at most maxCompletionValues values, and whether there are more.
+type completer func(ctx context.Context, prefix string) ([]string, bool, error)
+
+// argumentCompleters complete the arguments whose values come from a
+// backend rather than from an enum, whichever tool they belong to
+var argumentCompleters = map[string]completer{
+	"city":        completeCity,
+	"calendar_id": completeCalendarID,
+}
+
+// handleCompletion answers completion/complete with the values of the
+// argument of a tool that start with what has been typed.
+func handleCompletion(ctx context.Context, req MCPRequest) MCPResponse {
+	invalid := func(format string, args ...interface{}) MCPResponse {
+		return MCPResponse{ID: req.ID, Error: &MCPError{Code: -32602, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	ref, _ := req.Params["ref"].(map[string]interface{})
+	argument, _ := req.Params["argument"].(map[string]interface{})
+	refType, _ := ref["type"].(string)
+	name, _ := argument["name"].(string)
+	value, _ := argument["value"].(string)
+	switch {
+	case ref == nil:
+		return invalid("ref is required")
+	case argument == nil || name == "":
+		return invalid("argument.name is required")
+	case refType == "ref/prompt":
+		return completePromptArgument(ctx, req, ref, name, value)
+	case refType == "ref/resource":
+		return completeResourceArgument(ctx, req, ref, name, value)
+	case refType != "ref/tool":
+		return invalid("Invalid ref type: expected ref/tool, ref/prompt or ref/resource")
+	}
+
+	toolName, _ := ref["name"].(string)
+	tool, ok := findTool(toolName)
+	if !ok {
+		return invalid("Unknown tool: %s", toolName)
+	}
+	properties, _ := tool.InputSchema["properties"].(map[string]interface{})
+	schema, ok := properties[name].(map[string]interface{})
+	if !ok {
+		return invalid("Tool %s has no argument %s", toolName, name)
+	}
+
+	if enum, ok := schema["enum"].([]string); ok {
+		values, more := matchPrefix(enum, value)
+		return completionResponse(req, values, more)
+	}
+	values, more := completeArgument(ctx, toolName, name, value)
+	return completionResponse(req, values, more)
+}
+
+// completeResourceArgument answers completion/complete for an argument of
+// a resource template.
+func completeResourceArgument(ctx context.Context, req MCPRequest, ref map[string]interface{}, name, value string) MCPResponse {
+	uri, _ := ref["uri"].(string)
+	known := false
+	for _, template := range resourceTemplates {
+		known = known || template.URITemplate == uri
+	}
+	if !known {
+		return MCPResponse{ID: req.ID, Error: &MCPError{Code: -32602, Message: fmt.Sprintf("Unknown resource: %s", uri)}}
+	}
+	if !strings.Contains(uri, "{"+name+"}") {
+		return MCPResponse{ID: req.ID, Error: &MCPError{Code: -32602, Message: fmt.Sprintf("Resource %s has no argument %s", uri, name)}}
+	}
+
+	values, more := completeArgument(ctx, uri, name, value)
+	return completionResponse(req, values, more)
+}
+
+// completePromptArgument answers completion/complete for an argument of
+// a prompt.
+func completePromptArgument(ctx context.Context, req MCPRequest, ref map[string]interface{}, name, value string) MCPResponse {
+	promptName, _ := ref["name"].(string)
+	prompt, ok := findPrompt(promptName)
+	if !ok {
+		return MCPResponse{ID: req.ID, Error: &MCPError{Code: -32602, Message: fmt.Sprintf("Unknown prompt: %s", promptName)}}
+	}
+	for _, arg := range prompt.Arguments {
+		if arg.Name == name {
+			values, more := completeArgument(ctx, promptName, name, value)
+			return completionResponse(req, values, more)
+		}
+	}
+	return MCPResponse{ID: req.ID, Error: &MCPError{Code: -32602, Message: fmt.Sprintf("Prompt %s has no argument %s", promptName, name)}}
+}
+
+// completeArgument suggests the values of the argument name of owner, a
+// tool or resource template, from its backend; none when it has no
+// completer or the backend fails.
+func completeArgument(ctx context.Context, owner, name, value string) ([]string, bool) {
+	complete, ok := argumentCompleters[name]
+	if !ok {
+		return []string{}, false
+	}
+	values, more, err := complete(ctx, value)
+	if err != nil {
+		log.Printf("Failed to complete %s of %s: %v", name, owner, err)
+		return []string{}, false
+	}
+	if values == nil {
+		values = []string{}
+	}
+	return values, more
+}
+
+// completionResponse answers a completion/complete request with values.
+func completionResponse(req MCPRequest, values []string, more bool) MCPResponse {
+	completion := map[string]interface{}{"values": values, "hasMore": more}
+	if !more {
+		completion["total"] = len(values)
+	}
+	return MCPResponse{ID: req.ID, Result: map[string]interface{}{"completion": completion}}
+}
+
+// matchPrefix returns the candidates starting with prefix, ignoring case,
+// in their order.
+func matchPrefix(candidates []string, prefix string) ([]string, bool) {
+	prefix = strings.ToLower(prefix)
+	values := []string{}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToLower(candidate), prefix) {
+			if len(values) == maxCompletionValues {
+				return values, true
+			}
+			values = append(values, candidate)
+		}
+	}
+	return values, false
+}
+
+// completeCity suggests the city names the weather service knows: the
+// home city, the favorites and the places looked up before.
+func completeCity(ctx context.Context, prefix string) ([]string, bool, error) {
+	suggestions, err := weatherClient.Cities(ctx, prefix, maxCompletionValues)
+	if err != nil {
+		return nil, false, err
+	}
+	return suggestions.Cities, suggestions.HasMore, nil
+}
+
+// completeCalendarID suggests the IDs of the user's calendars whose ID or
+// name starts with prefix, the primary calendar first.
+func completeCalendarID(ctx context.Context, prefix string) ([]string, bool, error) {
+	calendars, err := calendarClient.Calendars(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	sort.SliceStable(calendars, func(i, j int) bool {
+		return calendars[i].Primary && !calendars[j].Primary
+	})
+	prefix = strings.ToLower(prefix)
+	var ids []string
+	for _, c := range calendars {
+		if strings.HasPrefix(strings.ToLower(c.ID), prefix) || strings.HasPrefix(strings.ToLower(c.Summary), prefix) {
+			ids = append(ids, c.ID)
+		}
+	}
+	values, more := matchPrefix(ids, "")
+	return values, more, nil
+}
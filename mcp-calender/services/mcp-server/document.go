@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+)
+
+// Bounds of create_tasks_from_document
+const (
+	// maxDocumentChars bounds the text of the document shown to the LLM;
+	// chunks past it are left out
+	maxDocumentChars = 24000
+	// maxDocumentTasks bounds the action items taken from a document
+	maxDocumentTasks = 20
+)
+
+// documentTag marks the tasks created from documents
+const documentTag = "from-document"
+
+// errNoDocAgent is returned by document tools when DOC_AGENT_URL is unset
+var errNoDocAgent = errors.New("no doc agent configured: set DOC_AGENT_URL")
+
+// docChunk is a chunk of a document as listed by the doc agent
+type docChunk struct {
+	ID      int    `json:"id"`
+	Page    int    `json:"page"`
+	Content string `json:"content"`
+}
+
+// indexedDocumentText is a document the doc agent returned chunk by chunk
+type indexedDocumentText struct {
+	Filename string     `json:"filename"`
+	Chunks   []docChunk `json:"chunks"`
+}
+
+// ActionItem is an action item found in a document and, unless it was a
+// dry run, the ID of the task created for it
+type ActionItem struct {
+	Title string `json:"title"`
+	// Owner is who the document makes responsible, "" when it names no one
+	Owner string `json:"owner,omitempty"`
+	// DueDate is YYYY-MM-DD, or "" when the document names no date
+	DueDate  string         `json:"due_date,omitempty"`
+	Priority string         `json:"priority"`
+	Source   SourceCitation `json:"source"`
+	TaskID   int            `json:"task_id,omitempty"`
+}
+
+// SourceCitation is where in a document an action item comes from
+type SourceCitation struct {
+	Filename string `json:"filename"`
+	ChunkIDs []int  `json:"chunk_ids"`
+	Pages    []int  `json:"pages,omitempty"`
+	// Quote is the sentence stating the item
+	Quote string `json:"quote,omitempty"`
+}
+
+// DocumentTasksResult is the result of create_tasks_from_document
+type DocumentTasksResult struct {
+	Filename    string       `json:"filename"`
+	ActionItems []ActionItem `json:"action_items"`
+	// Truncated is set when the document was too long to be read whole
+	Truncated bool `json:"truncated"`
+}
+
+const documentTasksPrompt = `Today is %s. Below is the document %s, such as meeting minutes, split into numbered chunks.
+List the action items it assigns: what someone agreed, or was asked, to do.
+Reply with JSON only, in the form {"action_items": [{"title": "...", "owner": "...", "due_date": "YYYY-MM-DD", "priority": "low|medium|high", "chunk_ids": [1], "quote": "..."}]}.
+- title: a short imperative phrase, without the owner or date
+- owner: the person or team responsible; "" if none is named
+- due_date: the date the item is due, resolving words such as "Friday" against the date of the document if it gives one, else today; "" if none is given
+- priority: "medium" unless the document says otherwise
+- chunk_ids: the numbers of the chunks the item is stated in
+- quote: the sentence of the document stating the item
+List at most %d items, and none if the document assigns nothing. Do not invent anything.
+
+%s`
+
+// createTasksFromDocument has the LLM find the action items of a document
+// the doc agent indexed, such as meeting minutes, and creates a task for
+// each, citing where in the document it comes from. The tasks are created
+// together, all or none.
+func createTasksFromDocument(ctx context.Context, userID, filename string, dryRun bool) (*DocumentTasksResult, error) {
+	doc, err := loadDocumentText(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	text, truncated := formatChunks(doc.Chunks)
+	var generated struct {
+		ActionItems []struct {
+			Title    string `json:"title"`
+			Owner    string `json:"owner"`
+			DueDate  string `json:"due_date"`
+			Priority string `json:"priority"`
+			ChunkIDs []int  `json:"chunk_ids"`
+			Quote    string `json:"quote"`
+		} `json:"action_items"`
+	}
+	prompt := fmt.Sprintf(documentTasksPrompt, now.Format("Monday, 2006-01-02"), doc.Filename, maxDocumentTasks, text)
+	if err := generateJSON(ctx, prompt, &generated); err != nil {
+		return nil, err
+	}
+
+	pages := map[int]int{}
+	for _, chunk := range doc.Chunks {
+		pages[chunk.ID] = chunk.Page
+	}
+	result := &DocumentTasksResult{Filename: doc.Filename, ActionItems: []ActionItem{}, Truncated: truncated}
+	seen := map[string]bool{}
+	for _, g := range generated.ActionItems {
+		quote := strings.TrimSpace(g.Quote)
+		t := TaskInterpretation{Title: g.Title, DueDate: g.DueDate, Priority: g.Priority}
+		normalizeInterpretation(&t, quote)
+		if t.Title == "" || seen[strings.ToLower(t.Title)] || len(result.ActionItems) == maxDocumentTasks {
+			continue
+		}
+		seen[strings.ToLower(t.Title)] = true
+
+		source := SourceCitation{Filename: doc.Filename, ChunkIDs: []int{}, Quote: quote}
+		for _, id := range g.ChunkIDs {
+			// The LLM may cite chunks that are not in the document
+			page, ok := pages[id]
+			if !ok || containsInt(source.ChunkIDs, id) {
+				continue
+			}
+			source.ChunkIDs = append(source.ChunkIDs, id)
+			if page > 0 && !containsInt(source.Pages, page) {
+				source.Pages = append(source.Pages, page)
+			}
+		}
+		result.ActionItems = append(result.ActionItems, ActionItem{
+			Title:    t.Title,
+			Owner:    strings.TrimSpace(g.Owner),
+			DueDate:  t.DueDate,
+			Priority: t.Priority,
+			Source:   source,
+		})
+	}
+	if dryRun || len(result.ActionItems) == 0 {
+		return result, nil
+	}
+
+	requests := make([]client.CreateTaskRequest, len(result.ActionItems))
+	for i, item := range result.ActionItems {
+		requests[i] = client.CreateTaskRequest{
+			Title:       item.Title,
+			Description: describeActionItem(item),
+			Priority:    item.Priority,
+			DueDate:     item.DueDate,
+			Tags:        []string{documentTag},
+		}
+	}
+	tasks, err := tasksClient.CreateMany(ctx, requests)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tasks {
+		recordAction(userID, Action{Type: actionCreateTask, Task: &tasks[i]})
+		if i < len(result.ActionItems) {
+			result.ActionItems[i].TaskID = tasks[i].ID
+		}
+	}
+	return result, nil
+}
+
+// loadDocumentText gets the chunks of an indexed document from the doc
+// agent.
+func loadDocumentText(ctx context.Context, filename string) (*indexedDocumentText, error) {
+	if docAgentURL == "" {
+		return nil, errNoDocAgent
+	}
+	ctx, cancel := context.WithTimeout(ctx, docAgentTimeout)
+	defer cancel()
+
+	target := docAgentURL + "/docs/chunks?" + url.Values{"filename": {filename}}.Encode()
+	req, err := newDocAgentRequest(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("doc agent unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Missing or ambiguous documents are the caller's to fix
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &client.APIError{Service: "doc-agent", StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+
+	var doc indexedDocumentText
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decoding document: %w", err)
+	}
+	return &doc, nil
+}
+
+// formatChunks renders chunks for the LLM, numbered by ID, up to
+// maxDocumentChars. truncated is set when chunks were left out.
+func formatChunks(chunks []docChunk) (text string, truncated bool) {
+	var b strings.Builder
+	for _, chunk := range chunks {
+		header := "[chunk " + strconv.Itoa(chunk.ID)
+		if chunk.Page > 0 {
+			header += ", page " + strconv.Itoa(chunk.Page)
+		}
+		entry := header + "]\n" + strings.TrimSpace(chunk.Content) + "\n\n"
+		if b.Len()+len(entry) > maxDocumentChars && b.Len() > 0 {
+			return b.String(), true
+		}
+		b.WriteString(entry)
+	}
+	return b.String(), false
+}
+
+// describeActionItem is the description of the task created for item:
+// the sentence it comes from, its owner and where it was found.
+func describeActionItem(item ActionItem) string {
+	var b strings.Builder
+	if item.Source.Quote != "" {
+		fmt.Fprintf(&b, "%q\n\n", item.Source.Quote)
+	}
+	if item.Owner != "" {
+		fmt.Fprintf(&b, "Owner: %s\n", item.Owner)
+	}
+	fmt.Fprintf(&b, "Source: %s", item.Source.Filename)
+	if len(item.Source.Pages) > 0 {
+		fmt.Fprintf(&b, ", page %s", joinInts(item.Source.Pages))
+	}
+	if len(item.Source.ChunkIDs) > 0 {
+		fmt.Fprintf(&b, " (chunk %s)", joinInts(item.Source.ChunkIDs))
+	}
+	return b.String()
+}
+
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func joinInts(values []int) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ", ")
+}
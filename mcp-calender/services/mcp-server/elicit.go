@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Elicitation: a tool call missing required arguments, such as add_task
+// without a title, is not refused. Its result asks for the missing fields
+// the way MCP's elicitation/create does, with a message and the schema of
+// the fields, and the arguments it had are kept for elicitationTTL. The
+// client gathers the fields, from the user or the conversation, and
+// completes the call by calling the tool again with
+//
+//	"elicitation": {"id": "...", "action": "accept", "content": {"title": "..."}}
+//
+// in the tools/call params, or declines it with action "decline" or
+// "cancel". Clients that do not know elicitation see the message as text
+// and may simply call again with every argument.
+
+// elicitationTTL is how long the arguments of an incomplete call are kept
+const elicitationTTL = 10 * time.Minute
+
+// Elicitation actions of MCP
+const (
+	elicitAccept  = "accept"
+	elicitDecline = "decline"
+	elicitCancel  = "cancel"
+)
+
+// Errors of completing an elicitation
+var (
+	// errElicitationDeclined is returned when the user declined to give
+	// the missing arguments
+	errElicitationDeclined = errors.New("the user declined to provide the missing arguments")
+	// errElicitationNotFound is returned for an elicitation that expired,
+	// was completed already or belongs to another tool
+	errElicitationNotFound = errors.New("elicitation not found or expired")
+	// errInvalidElicitation wraps the reasons an elicitation response is
+	// refused
+	errInvalidElicitation = errors.New("invalid elicitation")
+	// errMissingArguments is returned for a call missing arguments when
+	// they cannot be elicited
+	errMissingArguments = errors.New("missing required arguments")
+)
+
+var elicitationsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mcp_elicitations_total",
+		Help: "Total number of tool calls asking for missing arguments, and how they ended",
+	},
+	[]string{"tool", "status"},
+)
+
+func init() {
+	prometheus.MustRegister(elicitationsTotal)
+}
+
+// Elicitation asks the client for the missing arguments of a tool call,
+// like the params of MCP's elicitation/create
+type Elicitation struct {
+	ID              string                 `json:"id"`
+	Message         string                 `json:"message"`
+	RequestedSchema map[string]interface{} `json:"requestedSchema"`
+}
+
+// pendingCall is a tool call waiting for its missing arguments
+type pendingCall struct {
+	Tool      string                 `json:"tool"`
+	Arguments map[string]interface{} `json:"arguments"`
+}
+
+func elicitationKey(userID, session, id string) string {
+	return "elicitation:" + userID + ":" + session + ":" + id
+}
+
+// missingArguments returns the required arguments of a tool a call leaves
+// out or empty, in order.
+func missingArguments(toolName string, arguments map[string]interface{}) []string {
+	tool, ok := findTool(toolName)
+	if !ok {
+		return nil
+	}
+	required, _ := tool.InputSchema["required"].([]string)
+	var missing []string
+	for _, name := range required {
+		switch value := arguments[name].(type) {
+		case nil:
+			missing = append(missing, name)
+		case string:
+			if strings.TrimSpace(value) == "" {
+				missing = append(missing, name)
+			}
+		}
+	}
+	return missing
+}
+
+// findTool returns the tool called name.
+func findTool(name string) (Tool, bool) {
+	for _, tool := range registeredTools() {
+		if tool.Name == name {
+			return tool, true
+		}
+	}
+	return Tool{}, false
+}
+
+// elicit keeps the arguments of an incomplete call and returns the result
+// asking for the missing ones.
+func elicit(ctx context.Context, userID, session, toolName string, arguments map[string]interface{}, missing []string) (map[string]interface{}, error) {
+	data, err := json.Marshal(pendingCall{Tool: toolName, Arguments: arguments})
+	if err != nil {
+		return nil, err
+	}
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	if err := redisClient.Set(ctx, elicitationKey(userID, session, id), data, elicitationTTL).Err(); err != nil {
+		elicitationsTotal.WithLabelValues(toolName, "error").Inc()
+		log.Printf("Failed to keep the arguments of %s for %s: %v", toolName, userID, err)
+		return nil, fmt.Errorf("%w: %s", errMissingArguments, strings.Join(missing, ", "))
+	}
+	elicitationsTotal.WithLabelValues(toolName, "requested").Inc()
+
+	elicitation := Elicitation{
+		ID:              id,
+		Message:         fmt.Sprintf("%s needs %s to continue.", toolName, joinNames(missing)),
+		RequestedSchema: requestedSchema(toolName, missing),
+	}
+	return map[string]interface{}{
+		"content": []ContentBlock{{Type: "text", Text: elicitation.Message +
+			" Provide it by calling the tool again with elicitation " + id + ", or with every argument."}},
+		"elicitation": elicitation,
+	}, nil
+}
+
+// requestedSchema is the schema of the missing arguments of a tool, taken
+// from its input schema. MCP only elicits flat objects of primitive
+// fields, so the others are asked for as strings.
+func requestedSchema(toolName string, missing []string) map[string]interface{} {
+	tool, _ := findTool(toolName)
+	all, _ := tool.InputSchema["properties"].(map[string]interface{})
+	properties := map[string]interface{}{}
+	for _, name := range missing {
+		schema, _ := all[name].(map[string]interface{})
+		field := map[string]interface{}{"type": "string"}
+		switch schema["type"] {
+		case "string", "number", "integer", "boolean":
+			field["type"] = schema["type"]
+		}
+		if description, ok := schema["description"]; ok {
+			field["description"] = description
+		}
+		if enum, ok := schema["enum"]; ok {
+			field["enum"] = enum
+		}
+		properties[name] = field
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   missing,
+	}
+}
+
+// resumeElicitation returns the arguments of the call an elicitation
+// response completes: those kept for it, overridden by the content of the
+// response and then by arguments. A declined elicitation is dropped.
+func resumeElicitation(ctx context.Context, userID, session, toolName string, response map[string]interface{}, arguments map[string]interface{}) (map[string]interface{}, error) {
+	id, _ := response["id"].(string)
+	action, _ := response["action"].(string)
+	if id == "" {
+		return nil, fmt.Errorf("%w: id is required", errInvalidElicitation)
+	}
+	switch action {
+	case elicitAccept, elicitDecline, elicitCancel:
+	default:
+		return nil, fmt.Errorf("%w: action must be accept, decline or cancel", errInvalidElicitation)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	data, err := redisClient.GetDel(ctx, elicitationKey(userID, session, id)).Result()
+	if err == redis.Nil {
+		return nil, errElicitationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading elicitation: %w", err)
+	}
+	var pending pendingCall
+	if err := json.Unmarshal([]byte(data), &pending); err != nil || pending.Tool != toolName {
+		return nil, errElicitationNotFound
+	}
+	if action != elicitAccept {
+		elicitationsTotal.WithLabelValues(toolName, action).Inc()
+		return nil, errElicitationDeclined
+	}
+	elicitationsTotal.WithLabelValues(toolName, "accepted").Inc()
+
+	content, _ := response["content"].(map[string]interface{})
+	merged := make(map[string]interface{}, len(pending.Arguments)+len(content)+len(arguments))
+	for _, values := range []map[string]interface{}{pending.Arguments, content, arguments} {
+		for name, value := range values {
+			merged[name] = value
+		}
+	}
+	return merged, nil
+}
+
+// joinNames lists names as "a", "a and b" or "a, b and c".
+func joinNames(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LLM behind capture_task, generate_weekly_review and
+// create_tasks_from_document: an Ollama server, such as the one the doc
+// agent uses, and a model that supports JSON mode
+var (
+	llmURL   = strings.TrimSuffix(getEnv("OLLAMA_URL", "http://localhost:11434"), "/")
+	llmModel = getEnv("LLM_MODEL", "llama3")
+)
+
+// llmTimeout bounds a generation, leaving the rest of the tool call
+// timeout for the service calls around it
+const llmTimeout = 20 * time.Second
+
+// errLLM wraps failures to get an answer from the LLM
+var errLLM = errors.New("LLM request failed")
+
+type generateRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+	Stream bool   `json:"stream"`
+	Format string `json:"format"`
+}
+
+type generateResponse struct {
+	Response string `json:"response"`
+}
+
+// generateJSON has the LLM answer prompt in JSON mode and decodes the
+// answer into v.
+func generateJSON(ctx context.Context, prompt string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, llmTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(generateRequest{
+		Model:  llmModel,
+		Prompt: prompt,
+		Format: "json",
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, llmURL+"/api/generate", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s unreachable: %v", errLLM, llmURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%w: returned %s: %s", errLLM, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var generated generateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&generated); err != nil {
+		return fmt.Errorf("%w: decoding response: %v", errLLM, err)
+	}
+	if err := json.Unmarshal([]byte(generated.Response), v); err != nil {
+		return fmt.Errorf("%w: invalid JSON: %v", errLLM, err)
+	}
+	return nil
+}
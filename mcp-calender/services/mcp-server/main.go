@@ -4,51 +4,107 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/health"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// MCP Protocol structures
+// MCP Protocol structures, JSON-RPC 2.0 messages. The id of a request is
+// a string or a number, echoed in the response as it was sent; a request
+// without one is a notification, which is answered with 202 Accepted and
+// no body.
 type MCPRequest struct {
-	ID     string                 `json:"id"`
-	Method string                 `json:"method"`
-	Params map[string]interface{} `json:"params,omitempty"`
+	JSONRPC string                 `json:"jsonrpc"`
+	ID      json.RawMessage        `json:"id,omitempty"`
+	Method  string                 `json:"method"`
+	Params  map[string]interface{} `json:"params,omitempty"`
 }
 
 type MCPResponse struct {
-	ID     string      `json:"id"`
-	Result interface{} `json:"result,omitempty"`
-	Error  *MCPError   `json:"error,omitempty"`
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *MCPError       `json:"error,omitempty"`
 }
 
 type MCPError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+	// Data tells more about the error, e.g. the status a service
+	// answered with
+	Data interface{} `json:"data,omitempty"`
 }
 
+// serverVersion is reported to clients; release builds set it with
+// -ldflags
+var serverVersion = "dev"
+
+// protocolVersions are the MCP revisions the server implements, newest
+// first. A client asking for another one is offered the newest.
+var protocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
 type Tool struct {
 	Name        string                 `json:"name"`
 	Description string                 `json:"description"`
 	InputSchema map[string]interface{} `json:"inputSchema"`
+	// Status is "degraded" while a backend the tool needs is down, with
+	// StatusReason naming it
+	Status       string `json:"status,omitempty"`
+	StatusReason string `json:"statusReason,omitempty"`
 }
 
-// Service endpoints configuration
-var serviceEndpoints = map[string]string{
-	"task-service":     getEnv("TASK_SERVICE_URL", "http://task-service:8081"),
-	"calendar-service": getEnv("CALENDAR_SERVICE_URL", "http://calendar-service:8082"),
-	"weather-service":  getEnv("WEATHER_SERVICE_URL", "http://weather-service:8083"),
+// Backend services
+var (
+	taskServiceURL         = getEnv("TASK_SERVICE_URL", "http://task-service:8081")
+	calendarServiceURL     = getEnv("CALENDAR_SERVICE_URL", "http://calendar-service:8082")
+	weatherServiceURL      = getEnv("WEATHER_SERVICE_URL", "http://weather-service:8083")
+	notificationServiceURL = getEnv("NOTIFICATION_SERVICE_URL", "http://notification-service:8084")
+	githubSyncServiceURL   = getEnv("GITHUB_SYNC_SERVICE_URL", "http://github-sync-service:8087")
+)
+
+// Clients of the backend services
+var (
+	tasksClient         = client.NewTasksClient(taskServiceURL, withBackend("task-service")...)
+	calendarClient      = client.NewCalendarClient(calendarServiceURL, withBackend("calendar-service")...)
+	weatherClient       = client.NewWeatherClient(weatherServiceURL, withBackend("weather-service")...)
+	notificationsClient = client.NewNotificationsClient(notificationServiceURL, withBackend("notification-service")...)
+	githubSyncClient    = client.NewGitHubSyncClient(githubSyncServiceURL, withBackend("github-sync-service")...)
+)
+
+// withBackend makes a client of service call it through its circuit
+// breaker, subject to chaos mode, and retry as retrySettings say.
+func withBackend(service string) []client.Option {
+	return append(retryOptions(service), client.WithHTTPClient(&http.Client{
+		Timeout: client.DefaultTimeout,
+		Transport: breakerTransport{
+			service: service,
+			next:    chaosTransport{service: service, next: http.DefaultTransport},
+		},
+	}))
 }
 
+// toolCallTimeout bounds a tool call, retries included. Each attempt is
+// also bounded by the client's own timeout, and the call stops as soon as
+// the MCP client goes away.
+const toolCallTimeout = 30 * time.Second
+
+// maxRequestBody caps the size of a POST /mcp body, a batch included.
+const maxRequestBody = 1 << 20
+
 // Prometheus metrics
 var (
 	mcpRequestsTotal = prometheus.NewCounterVec(
@@ -73,12 +129,47 @@ func init() {
 }
 
 func main() {
+	// Initialize the action log used to undo tool calls
+	initRedis()
+	defer redisClient.Close()
+	initMemory()
+	initResponseFormat()
+	initBackendStatus()
+	initChaos()
+	initBreakers()
+	initCache()
+	initBatches()
+	if err := loadToolRegistry(); err != nil {
+		log.Fatalf("Failed to load the tools: %v", err)
+	}
+	go reloadToolsOnHangup()
+
+	// Each tool only needs its own backend, so the server serves the
+	// others while one is down and reports itself and their tools degraded
+	backends := []health.Dependency{
+		{Name: "task-service", Check: health.HTTPCheck(taskServiceURL + "/health/ready"), Optional: true},
+		{Name: "calendar-service", Check: health.HTTPCheck(calendarServiceURL + "/health/ready"), Optional: true},
+		{Name: "weather-service", Check: health.HTTPCheck(weatherServiceURL + "/health/ready"), Optional: true},
+		{Name: "notification-service", Check: health.HTTPCheck(notificationServiceURL + "/health/ready"), Optional: true},
+		{Name: "github-sync-service", Check: health.HTTPCheck(githubSyncServiceURL + "/health/ready"), Optional: true},
+		{Name: "redis", Check: pingRedis, Optional: true},
+	}
+	checker := health.NewChecker("MCP Server", backends...)
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	go monitorBackends(monitorCtx, backends)
+
 	router := mux.NewRouter()
+	router.Use(auth.ConfigFromEnv().Middleware(health.PublicPaths("/metrics")...))
 
 	// MCP endpoints
 	router.HandleFunc("/mcp", handleMCP).Methods("POST")
+	router.HandleFunc("/mcp", handleMCPStream).Methods("GET")
 	router.HandleFunc("/tools/list", handleToolsList).Methods("GET")
-	router.HandleFunc("/health", handleHealth).Methods("GET")
+	router.HandleFunc("/actions", handleListActions).Methods("GET")
+	for _, path := range health.Paths {
+		router.Handle(path, checker).Methods("GET")
+	}
 
 	// Metrics endpoint
 	router.Handle("/metrics", promhttp.Handler())
@@ -88,6 +179,8 @@ func main() {
 		Addr:    ":" + port,
 		Handler: router,
 	}
+	// Open SSE streams would otherwise hold up the shutdown
+	server.RegisterOnShutdown(closeStreams)
 
 	// Graceful shutdown
 	go func() {
@@ -97,6 +190,11 @@ func main() {
 		}
 	}()
 
+	// Give backends started alongside this server time to come up
+	if err := checker.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+
 	// Wait for interrupt signal
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -113,50 +211,157 @@ func main() {
 }
 
 func handleMCP(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if maxErr := (*http.MaxBytesError)(nil); errors.As(err, &maxErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(rpcResponse(MCPResponse{Error: &MCPError{Code: -32700, Message: fmt.Sprintf("Parse error: request body exceeds %d bytes", maxRequestBody)}}))
+		mcpRequestsTotal.WithLabelValues("", "error").Inc()
+		return
+	}
+	if err != nil || !json.Valid(data) {
+		writeErrorResponse(w, nil, &MCPError{Code: -32700, Message: "Parse error"})
+		mcpRequestsTotal.WithLabelValues("", "error").Inc()
+		return
+	}
+	if data = bytes.TrimSpace(data); data[0] == '[' {
+		handleBatch(w, r, data)
+		return
+	}
+
+	response, answered := serveMessage(r, data)
+	if !answered {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	writeRPCResponse(w, response)
+}
+
+// serveMessage serves a JSON-RPC message of r, alone or in a batch, and
+// returns its response. Notifications are never answered, not even with
+// their errors, so answered is false for them.
+func serveMessage(r *http.Request, data []byte) (response MCPResponse, answered bool) {
 	start := time.Now()
 
-	var req MCPRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErrorResponse(w, req.ID, -32700, "Parse error")
+	req, rpcErr := decodeRequest(data)
+	if rpcErr != nil {
 		mcpRequestsTotal.WithLabelValues(req.Method, "error").Inc()
-		return
+		return MCPResponse{ID: req.ID, Error: rpcErr}, true
 	}
 
 	defer func() {
 		mcpRequestDuration.WithLabelValues(req.Method).Observe(time.Since(start).Seconds())
 	}()
 
-	var response MCPResponse
 	response.ID = req.ID
+	ctx, done := trackRequest(r, req)
+	defer done()
 
 	switch req.Method {
+	case "initialize":
+		response = handleInitialize(req)
+	case "ping":
+		response = MCPResponse{ID: req.ID, Result: map[string]interface{}{}}
+	case "notifications/initialized":
+		// Nothing to do: the server keeps no connection state
+		response.Result = map[string]interface{}{}
+	case "notifications/cancelled", "$/cancelRequest":
+		response = handleCancel(r, req)
 	case "tools/call":
-		response = handleToolCall(req)
+		// Forward the caller's identity so each service scopes its data
+		// to the same user
+		ctx, cancel := backendContext(ctx, r, toolCallTimeout)
+		defer cancel()
+		response = handleToolCall(ctx, req, auth.UserID(r), sessionID(r))
 	case "tools/list":
 		response = handleToolsListMCP(req)
+	case "resources/list":
+		ctx, cancel := backendContext(ctx, r, toolCallTimeout)
+		defer cancel()
+		response = handleResourcesList(ctx, req)
+	case "resources/read":
+		ctx, cancel := backendContext(ctx, r, toolCallTimeout)
+		defer cancel()
+		response = handleResourcesRead(ctx, req)
+	case "resources/templates/list":
+		response = handleResourceTemplatesList(req)
+	case "prompts/list":
+		response = handlePromptsList(req)
+	case "prompts/get":
+		ctx, cancel := backendContext(ctx, r, toolCallTimeout)
+		defer cancel()
+		response = handlePromptsGet(ctx, req)
+	case "completion/complete":
+		ctx, cancel := backendContext(ctx, r, completionTimeout)
+		defer cancel()
+		response = handleCompletion(ctx, req)
 	default:
-		response = MCPResponse{
+		mcpRequestsTotal.WithLabelValues(req.Method, "error").Inc()
+		return MCPResponse{
 			ID: req.ID,
 			Error: &MCPError{
 				Code:    -32601,
 				Message: "Method not found",
+				Data:    map[string]string{"method": req.Method},
 			},
-		}
-		mcpRequestsTotal.WithLabelValues(req.Method, "error").Inc()
-		writeJSONResponse(w, response)
-		return
+		}, req.ID != nil
 	}
 
 	status := "success"
-	if response.Error != nil {
+	if ctx.Err() != nil {
+		status = "canceled"
+	} else if response.Error != nil {
 		status = "error"
 	}
 	mcpRequestsTotal.WithLabelValues(req.Method, status).Inc()
 
-	writeJSONResponse(w, response)
+	if req.ID == nil {
+		if response.Error != nil {
+			log.Printf("Notification %s failed: %s", req.Method, response.Error.Message)
+		}
+		return response, false
+	}
+	return response, true
+}
+
+// backendContext returns the context of calls to the backends on behalf of
+// the caller of r, derived from ctx, that of serving its request, and
+// bounded by timeout.
+func backendContext(ctx context.Context, r *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	ctx = client.WithToken(ctx, auth.BearerToken(r))
+	ctx = client.WithGoogleToken(ctx, r.Header.Get("X-Google-Access-Token"))
+	ctx = client.WithGoogleRefreshToken(ctx, r.Header.Get("X-Google-Refresh-Token"))
+	return ctx, cancel
+}
+
+// handleInitialize answers the initialize request that starts an MCP
+// session with the protocol version and capabilities of the server.
+func handleInitialize(req MCPRequest) MCPResponse {
+	version := protocolVersions[0]
+	if requested, _ := req.Params["protocolVersion"].(string); requested != "" {
+		for _, v := range protocolVersions {
+			if v == requested {
+				version = v
+			}
+		}
+	}
+	return MCPResponse{
+		ID: req.ID,
+		Result: map[string]interface{}{
+			"protocolVersion": version,
+			"capabilities": map[string]interface{}{
+				"tools":       map[string]interface{}{"listChanged": true},
+				"resources":   map[string]interface{}{},
+				"prompts":     map[string]interface{}{},
+				"completions": map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": "mcp-productivity-hub", "version": serverVersion},
+		},
+	}
 }
 
-func handleToolCall(req MCPRequest) MCPResponse {
+func handleToolCall(ctx context.Context, req MCPRequest, userID, session string) MCPResponse {
 	toolName, ok := req.Params["name"].(string)
 	if !ok {
 		return MCPResponse{
@@ -168,19 +373,40 @@ func handleToolCall(req MCPRequest) MCPResponse {
 		}
 	}
 
+	format, ok := responseFormat(req.Params)
+	if !ok {
+		return MCPResponse{
+			ID: req.ID,
+			Error: &MCPError{
+				Code:    -32602,
+				Message: "Invalid format: expected json or text",
+			},
+		}
+	}
+
 	arguments, _ := req.Params["arguments"].(map[string]interface{})
+	if response, ok := req.Params["elicitation"].(map[string]interface{}); ok {
+		var err error
+		if arguments, err = resumeElicitation(ctx, userID, session, toolName, response, arguments); err != nil {
+			return MCPResponse{ID: req.ID, Error: toolError(err)}
+		}
+	}
+	arguments = withRememberedArguments(ctx, userID, session, toolName, arguments)
 
-	switch toolName {
-	case "get_tasks":
-		return callTaskService("GET", "/tasks", nil)
-	case "add_task":
-		return callTaskService("POST", "/tasks", arguments)
-	case "get_calendar_events":
-		return callCalendarService("GET", "/events", arguments)
-	case "get_weather":
-		city, _ := arguments["city"].(string)
-		return callWeatherService("GET", fmt.Sprintf("/weather?city=%s", city), nil)
-	default:
+	// Ask for the required arguments the call lacks rather than failing
+	if missing := missingArguments(toolName, arguments); len(missing) > 0 {
+		result, err := elicit(ctx, userID, session, toolName, arguments, missing)
+		if err != nil {
+			return MCPResponse{ID: req.ID, Error: toolError(err)}
+		}
+		return MCPResponse{ID: req.ID, Result: result}
+	}
+	if errs := validateArguments(toolName, arguments); len(errs) > 0 {
+		return MCPResponse{ID: req.ID, Error: invalidParams(errs)}
+	}
+
+	tool, ok := configuredToolNamed(toolName)
+	if !ok {
 		return MCPResponse{
 			ID: req.ID,
 			Error: &MCPError{
@@ -189,194 +415,410 @@ func handleToolCall(req MCPRequest) MCPResponse {
 			},
 		}
 	}
+	if tool.Idempotent {
+		ctx = client.WithIdempotent(ctx)
+	}
+	bypass, _ := req.Params["no_cache"].(bool)
+	if cached, ok := cachedResult(ctx, userID, toolName, arguments, bypass); ok {
+		return MCPResponse{ID: req.ID, Result: transformResult(toolName, format, cached)}
+	}
+	var result interface{}
+	var err error
+	if tool.handler != nil {
+		result, err = tool.handler(ctx, toolCall{userID: userID, session: session, arguments: arguments})
+	} else {
+		result, err = callConfiguredTool(ctx, tool, arguments)
+	}
+	if err != nil {
+		return MCPResponse{ID: req.ID, Error: toolError(err)}
+	}
+	cacheResult(ctx, userID, toolName, arguments, result)
+	return MCPResponse{ID: req.ID, Result: transformResult(toolName, format, result)}
 }
 
-func handleToolsListMCP(req MCPRequest) MCPResponse {
-	tools := getAvailableTools()
-	return MCPResponse{
-		ID:     req.ID,
-		Result: map[string]interface{}{"tools": tools},
+// toolHandlers are the built-in code the tools of the registry may run,
+// by the name their handler gives; see registry.go.
+var toolHandlers = map[string]toolHandler{
+	"get_tasks":                  getTasks,
+	"add_task":                   addTask,
+	"delete_task":                deleteTask,
+	"cleanup_completed_tasks":    cleanupCompletedTasks,
+	"capture_task":               captureTaskTool,
+	"get_calendar_events":        getCalendarEvents,
+	"create_event":               createEvent,
+	"delete_event":               deleteEvent,
+	"get_agenda":                 getAgenda,
+	"create_meeting_followups":   createMeetingFollowUps,
+	"get_weather":                getWeather,
+	"will_it_rain_soon":          willItRainSoon,
+	"send_notification":          sendNotification,
+	"sync_github_issues":         syncGitHubIssues,
+	"undo_last_action":           undoLastActionTool,
+	"generate_weekly_review":     generateWeeklyReviewTool,
+	"create_tasks_from_document": createTasksFromDocumentTool,
+	"plan_my_day":                planMyDayTool,
+	"remember":                   rememberTool,
+	"recall":                     recallTool,
+}
+
+func getTasks(ctx context.Context, call toolCall) (interface{}, error) {
+	var args struct {
+		Limit    int    `json:"limit"`
+		Cursor   string `json:"cursor"`
+		Status   string `json:"status"`
+		Priority string `json:"priority"`
+	}
+	if err := decodeArguments(call.arguments, &args); err != nil {
+		return nil, err
+	}
+	opts := client.ListOptions{Limit: args.Limit, Cursor: args.Cursor}
+	if args.Status != "" {
+		opts.Statuses = []string{args.Status}
+	}
+	if args.Priority != "" {
+		opts.Priorities = []string{args.Priority}
 	}
+	return tasksClient.ListPage(ctx, opts)
 }
 
-func handleToolsList(w http.ResponseWriter, r *http.Request) {
-	tools := getAvailableTools()
-	writeJSONResponse(w, map[string]interface{}{"tools": tools})
+func addTask(ctx context.Context, call toolCall) (interface{}, error) {
+	var task client.CreateTaskRequest
+	if err := decodeArguments(call.arguments, &task); err != nil {
+		return nil, err
+	}
+	created, err := tasksClient.Create(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+	recordAction(call.userID, Action{Type: actionCreateTask, Task: created})
+	return created, nil
 }
 
-func getAvailableTools() []Tool {
-	return []Tool{
-		{
-			Name:        "get_tasks",
-			Description: "Retrieve all tasks",
-			InputSchema: map[string]interface{}{
-				"type":       "object",
-				"properties": map[string]interface{}{},
-			},
-		},
-		{
-			Name:        "add_task",
-			Description: "Add a new task",
-			InputSchema: map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"title": map[string]interface{}{
-						"type":        "string",
-						"description": "Task title",
-					},
-					"description": map[string]interface{}{
-						"type":        "string",
-						"description": "Task description",
-					},
-					"priority": map[string]interface{}{
-						"type":        "string",
-						"description": "Task priority (low, medium, high)",
-					},
-				},
-				"required": []string{"title"},
-			},
-		},
-		{
-			Name:        "get_calendar_events",
-			Description: "Get calendar events",
-			InputSchema: map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"start_date": map[string]interface{}{
-						"type":        "string",
-						"description": "Start date (YYYY-MM-DD)",
-					},
-					"end_date": map[string]interface{}{
-						"type":        "string",
-						"description": "End date (YYYY-MM-DD)",
-					},
-				},
-			},
-		},
-		{
-			Name:        "get_weather",
-			Description: "Get weather information for a city",
-			InputSchema: map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"city": map[string]interface{}{
-						"type":        "string",
-						"description": "City name",
-					},
-				},
-				"required": []string{"city"},
-			},
-		},
+func deleteTask(ctx context.Context, call toolCall) (interface{}, error) {
+	var args struct {
+		TaskID int `json:"task_id"`
+	}
+	if err := decodeArguments(call.arguments, &args); err != nil {
+		return nil, err
+	}
+	// Keep the task so that the deletion can be undone
+	task, err := tasksClient.Get(ctx, args.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tasksClient.Delete(ctx, task.ID); err != nil {
+		return nil, err
 	}
+	recordAction(call.userID, Action{Type: actionDeleteTask, Task: task})
+	return map[string]interface{}{"deleted": task}, nil
 }
 
-func callTaskService(method, path string, body interface{}) MCPResponse {
-	return callService("task-service", method, path, body)
+// cleanupCompletedTasks only counts unless dry_run is false, so that the
+// caller sees how many tasks would go before any are deleted.
+func cleanupCompletedTasks(ctx context.Context, call toolCall) (interface{}, error) {
+	args := struct {
+		OlderThanDays *int   `json:"older_than_days"`
+		DryRun        *bool  `json:"dry_run"`
+		Tag           string `json:"tag"`
+	}{}
+	if err := decodeArguments(call.arguments, &args); err != nil {
+		return nil, err
+	}
+	days, dryRun := 30, true
+	if args.OlderThanDays != nil {
+		days = *args.OlderThanDays
+	}
+	if args.DryRun != nil {
+		dryRun = *args.DryRun
+	}
+	if days < 0 {
+		return nil, argumentError("older_than_days must not be negative")
+	}
+	before := time.Now().AddDate(0, 0, -days).Format("2006-01-02")
+	deleted, err := tasksClient.DeleteMany(ctx, client.TaskFilter{
+		Statuses:        []string{"completed", "done"},
+		CompletedBefore: before,
+		Tag:             args.Tag,
+	}, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"matched":          deleted.Matched,
+		"deleted":          deleted.Deleted,
+		"dry_run":          deleted.DryRun,
+		"completed_before": before,
+	}, nil
 }
 
-func callCalendarService(method, path string, body interface{}) MCPResponse {
-	return callService("calendar-service", method, path, body)
+func captureTaskTool(ctx context.Context, call toolCall) (interface{}, error) {
+	text, _ := call.arguments["text"].(string)
+	if strings.TrimSpace(text) == "" {
+		return nil, argumentError("text is required")
+	}
+	dryRun, _ := call.arguments["dry_run"].(bool)
+	captured, err := captureTask(ctx, text, dryRun)
+	if err == nil && captured.Task != nil {
+		recordAction(call.userID, Action{Type: actionCreateTask, Task: captured.Task})
+	}
+	return captured, err
 }
 
-func callWeatherService(method, path string, body interface{}) MCPResponse {
-	return callService("weather-service", method, path, body)
+func getCalendarEvents(ctx context.Context, call toolCall) (interface{}, error) {
+	startDate, _ := call.arguments["start_date"].(string)
+	endDate, _ := call.arguments["end_date"].(string)
+	calendarID, _ := call.arguments["calendar_id"].(string)
+	events, err := calendarClient.Events(ctx, client.EventsQuery{StartDate: startDate, EndDate: endDate, CalendarID: calendarID})
+	return map[string]interface{}{"events": events}, err
 }
 
-func callService(serviceName, method, path string, body interface{}) MCPResponse {
-	baseURL, exists := serviceEndpoints[serviceName]
-	if !exists {
-		return MCPResponse{
-			Error: &MCPError{
-				Code:    -32001,
-				Message: fmt.Sprintf("Service %s not configured", serviceName),
-			},
-		}
+func createEvent(ctx context.Context, call toolCall) (interface{}, error) {
+	var event client.CreateEventRequest
+	if err := decodeArguments(call.arguments, &event); err != nil {
+		return nil, err
 	}
-
-	// Prepare request body
-	var reqBody io.Reader
-	if body != nil && (method == "POST" || method == "PATCH") {
-		bodyBytes, err := json.Marshal(body)
-		if err != nil {
-			return MCPResponse{
-				Error: &MCPError{
-					Code:    -32002,
-					Message: fmt.Sprintf("Failed to marshal request body: %v", err),
-				},
-			}
-		}
-		reqBody = bytes.NewBuffer(bodyBytes)
+	created, err := calendarClient.CreateEvent(ctx, event)
+	if err != nil {
+		return nil, err
 	}
+	recordAction(call.userID, Action{Type: actionCreateEvent, Event: created})
+	// The travel block is undone on its own, after the event
+	if created.Travel != nil && created.Travel.Buffer != nil {
+		recordAction(call.userID, Action{Type: actionCreateEvent, Event: created.Travel.Buffer})
+	}
+	return created, nil
+}
 
-	// Create HTTP request
-	url := baseURL + path
-	req, err := http.NewRequest(method, url, reqBody)
+func deleteEvent(ctx context.Context, call toolCall) (interface{}, error) {
+	eventID, _ := call.arguments["event_id"].(string)
+	// Keep the event so that the deletion can be undone
+	event, err := calendarClient.Event(ctx, eventID)
 	if err != nil {
-		return MCPResponse{
-			Error: &MCPError{
-				Code:    -32003,
-				Message: fmt.Sprintf("Failed to create request: %v", err),
-			},
+		return nil, err
+	}
+	if err := calendarClient.DeleteEvent(ctx, eventID); err != nil {
+		return nil, err
+	}
+	recordAction(call.userID, Action{Type: actionDeleteEvent, Event: event})
+	return map[string]interface{}{"deleted": event}, nil
+}
+
+func getAgenda(ctx context.Context, call toolCall) (interface{}, error) {
+	date, _ := call.arguments["date"].(string)
+	return calendarClient.Agenda(ctx, date)
+}
+
+func createMeetingFollowUps(ctx context.Context, call toolCall) (interface{}, error) {
+	var followUps client.FollowUpsRequest
+	if err := decodeArguments(call.arguments, &followUps); err != nil {
+		return nil, err
+	}
+	return calendarClient.CreateFollowUps(ctx, followUps)
+}
+
+// getWeather uses the user's home city when there is no city.
+func getWeather(ctx context.Context, call toolCall) (interface{}, error) {
+	city, _ := call.arguments["city"].(string)
+	if locationID, _ := call.arguments["location_id"].(string); locationID != "" {
+		return withAmbiguousCity(weatherClient.CurrentAt(ctx, locationID))
+	}
+	return withAmbiguousCity(weatherClient.Current(ctx, city))
+}
+
+func willItRainSoon(ctx context.Context, call toolCall) (interface{}, error) {
+	city, _ := call.arguments["city"].(string)
+	if locationID, _ := call.arguments["location_id"].(string); locationID != "" {
+		return withAmbiguousCity(weatherClient.NowcastAt(ctx, locationID))
+	}
+	return withAmbiguousCity(weatherClient.Nowcast(ctx, city))
+}
+
+func sendNotification(ctx context.Context, call toolCall) (interface{}, error) {
+	var notification client.SendNotificationRequest
+	if err := decodeArguments(call.arguments, &notification); err != nil {
+		return nil, err
+	}
+	return notificationsClient.Send(ctx, notification)
+}
+
+func syncGitHubIssues(ctx context.Context, call toolCall) (interface{}, error) {
+	var args struct {
+		Repos []string `json:"repos"`
+	}
+	if err := decodeArguments(call.arguments, &args); err != nil {
+		return nil, err
+	}
+	return githubSyncClient.Sync(ctx, args.Repos...)
+}
+
+func undoLastActionTool(ctx context.Context, call toolCall) (interface{}, error) {
+	return undoLastAction(ctx, call.userID)
+}
+
+func generateWeeklyReviewTool(ctx context.Context, call toolCall) (interface{}, error) {
+	day := time.Now()
+	if weekOf, _ := call.arguments["week_of"].(string); weekOf != "" {
+		var err error
+		if day, err = time.Parse("2006-01-02", weekOf); err != nil {
+			return nil, argumentError("week_of must be a YYYY-MM-DD date")
 		}
 	}
+	includeDocuments, ok := call.arguments["include_documents"].(bool)
+	if !ok {
+		includeDocuments = true
+	}
+	saveAsTask, _ := call.arguments["save_as_task"].(bool)
+	return generateWeeklyReview(ctx, call.userID, day, includeDocuments, saveAsTask)
+}
 
-	if reqBody != nil {
-		req.Header.Set("Content-Type", "application/json")
+func createTasksFromDocumentTool(ctx context.Context, call toolCall) (interface{}, error) {
+	filename, _ := call.arguments["filename"].(string)
+	if strings.TrimSpace(filename) == "" {
+		return nil, argumentError("filename is required")
 	}
+	dryRun, _ := call.arguments["dry_run"].(bool)
+	return createTasksFromDocument(ctx, call.userID, strings.TrimSpace(filename), dryRun)
+}
 
-	// Make HTTP request with timeout
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+func planMyDayTool(ctx context.Context, call toolCall) (interface{}, error) {
+	var args PlanArgs
+	if err := decodeArguments(call.arguments, &args); err != nil {
+		return nil, err
+	}
+	return planMyDay(ctx, call.userID, args)
+}
+
+func rememberTool(ctx context.Context, call toolCall) (interface{}, error) {
+	key, _ := call.arguments["key"].(string)
+	value, _ := call.arguments["value"].(string)
+	facts, err := remember(ctx, call.userID, call.session, key, value)
 	if err != nil {
-		return MCPResponse{
-			Error: &MCPError{
-				Code:    -32004,
-				Message: fmt.Sprintf("Service request failed: %v", err),
-			},
-		}
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return map[string]interface{}{"facts": facts}, nil
+}
 
-	// Read response body
-	responseBody, err := io.ReadAll(resp.Body)
+func recallTool(ctx context.Context, call toolCall) (interface{}, error) {
+	key, _ := call.arguments["key"].(string)
+	facts, err := recall(ctx, call.userID, call.session, key)
 	if err != nil {
-		return MCPResponse{
-			Error: &MCPError{
-				Code:    -32005,
-				Message: fmt.Sprintf("Failed to read response: %v", err),
-			},
-		}
+		return nil, err
 	}
+	return map[string]interface{}{"facts": facts}, nil
+}
 
-	// Check for HTTP errors
-	if resp.StatusCode >= 400 {
-		return MCPResponse{
-			Error: &MCPError{
-				Code:    -32006,
-				Message: fmt.Sprintf("Service returned error %d: %s", resp.StatusCode, string(responseBody)),
-			},
-		}
+// argumentError is an argument of a call a tool handler rejects.
+type argumentError string
+
+func (e argumentError) Error() string { return string(e) }
+
+// decodeArguments decodes the arguments of a tool call into the request
+// of the client method it maps to.
+func decodeArguments(arguments map[string]interface{}, v interface{}) error {
+	data, err := json.Marshal(arguments)
+	if err != nil {
+		return err
 	}
+	return json.Unmarshal(data, v)
+}
 
-	// Parse JSON response
-	var result interface{}
-	if len(responseBody) > 0 {
-		if err := json.Unmarshal(responseBody, &result); err != nil {
-			// If JSON parsing fails, return raw response
-			result = map[string]interface{}{
-				"raw_response": string(responseBody),
-				"content_type": resp.Header.Get("Content-Type"),
-			}
+// withAmbiguousCity turns the *client.AmbiguousCityError of a weather call
+// into its result. An ambiguous city is not a failure: the candidates let
+// the caller pick one and ask again with its location_id.
+func withAmbiguousCity(result interface{}, err error) (interface{}, error) {
+	var ambiguous *client.AmbiguousCityError
+	if !errors.As(err, &ambiguous) {
+		return result, err
+	}
+	return map[string]interface{}{
+		"status":     "ambiguous",
+		"message":    ambiguous.Message,
+		"city":       ambiguous.City,
+		"candidates": ambiguous.Candidates,
+	}, nil
+}
+
+// toolError maps a failed tool call to an MCP error.
+func toolError(err error) *MCPError {
+	var argErr argumentError
+	var apiErr *client.APIError
+	var openErr *breakerOpenError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, errNothingToUndo):
+		return &MCPError{
+			Code:    -32008,
+			Message: "Nothing to undo",
+		}
+	case errors.Is(err, errInvalidFact), errors.Is(err, errInvalidPlan),
+		errors.Is(err, errInvalidElicitation), errors.Is(err, errMissingArguments),
+		errors.As(err, &argErr):
+		return &MCPError{
+			Code:    -32602,
+			Message: err.Error(),
+		}
+	case errors.Is(err, errMemoryFull):
+		return &MCPError{
+			Code:    -32009,
+			Message: fmt.Sprintf("Session memory is full: forget facts first, at most %d are kept", maxFacts),
+		}
+	case errors.Is(err, errElicitationDeclined):
+		return &MCPError{
+			Code:    -32010,
+			Message: "Tool call cancelled: " + err.Error(),
+		}
+	case errors.Is(err, errElicitationNotFound):
+		return &MCPError{
+			Code:    -32011,
+			Message: "Elicitation not found: it expired or was already answered; call the tool again with every argument",
+		}
+	case errors.Is(err, context.Canceled):
+		return &MCPError{
+			Code:    -32800,
+			Message: "Request cancelled",
+		}
+	case errors.As(err, &openErr):
+		return &MCPError{
+			Code:    -32012,
+			Message: "Service unavailable: " + openErr.Error(),
+			Data:    map[string]interface{}{"service": openErr.Service, "retry_after": int(openErr.RetryIn.Round(time.Second).Seconds())},
+		}
+	case errors.Is(err, errLLM):
+		return &MCPError{
+			Code:    -32007,
+			Message: err.Error(),
+		}
+	case errors.As(err, &apiErr):
+		return &MCPError{
+			Code:    -32006,
+			Message: fmt.Sprintf("Service returned error %d: %s", apiErr.StatusCode, apiErr.Message),
+			Data:    map[string]int{"status": apiErr.StatusCode},
+		}
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return &MCPError{
+			Code:    -32602,
+			Message: fmt.Sprintf("Invalid arguments: %v", err),
+		}
+	default:
+		return &MCPError{
+			Code:    -32004,
+			Message: fmt.Sprintf("Service request failed: %v", err),
 		}
 	}
+}
 
+func handleToolsListMCP(req MCPRequest) MCPResponse {
+	tools := listedTools()
 	return MCPResponse{
-		Result: result,
+		ID:     req.ID,
+		Result: map[string]interface{}{"tools": tools},
 	}
 }
 
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	writeJSONResponse(w, map[string]string{"status": "healthy"})
+func handleToolsList(w http.ResponseWriter, r *http.Request) {
+	tools := listedTools()
+	writeJSONResponse(w, map[string]interface{}{"tools": tools})
 }
 
 func writeJSONResponse(w http.ResponseWriter, data interface{}) {
@@ -384,15 +826,24 @@ func writeJSONResponse(w http.ResponseWriter, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
-func writeErrorResponse(w http.ResponseWriter, id string, code int, message string) {
-	response := MCPResponse{
-		ID: id,
-		Error: &MCPError{
-			Code:    code,
-			Message: message,
-		},
+// writeRPCResponse writes response as a JSON-RPC 2.0 response, with a
+// null id when the request's could not be read.
+func writeRPCResponse(w http.ResponseWriter, response MCPResponse) {
+	writeJSONResponse(w, rpcResponse(response))
+}
+
+// rpcResponse completes a response to be sent: a request whose id could
+// not be read is answered with a null one.
+func rpcResponse(response MCPResponse) MCPResponse {
+	response.JSONRPC = "2.0"
+	if response.ID == nil {
+		response.ID = json.RawMessage("null")
 	}
-	writeJSONResponse(w, response)
+	return response
+}
+
+func writeErrorResponse(w http.ResponseWriter, id json.RawMessage, rpcErr *MCPError) {
+	writeRPCResponse(w, MCPResponse{ID: id, Error: rpcErr})
 }
 
 func getEnv(key, defaultValue string) string {
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Working memory: facts such as the home city or the current project that
+// an agent remembers for the rest of a conversation, so that it does not
+// ask the user for them again. The facts of each session are a Redis hash
+// that expires once the session has not been used for memoryTTL.
+
+// sessionHeader identifies the conversation of a request, as in MCP's
+// streamable HTTP transport. Requests without it share one session per
+// user.
+const (
+	sessionHeader  = "Mcp-Session-Id"
+	defaultSession = "default"
+)
+
+// Bounds of a session's memory
+const (
+	maxFacts         = 100
+	maxFactKeyLength = 64
+	maxFactLength    = 1000
+	maxSessionLength = 128
+)
+
+// memoryTTL is how long the facts of an idle session are kept, set by
+// MEMORY_TTL
+var memoryTTL = 24 * time.Hour
+
+// Errors of remember and recall
+var (
+	// errInvalidFact wraps the reasons a key or value is refused
+	errInvalidFact = errors.New("invalid fact")
+	// errMemoryFull is returned when a session holds maxFacts facts and a
+	// new one is added
+	errMemoryFull = errors.New("session memory is full")
+)
+
+// unrememberedTools never take arguments from memory: the memory tools
+// themselves, and deletions, which must name what they delete
+var unrememberedTools = map[string]bool{"remember": true, "recall": true, "delete_event": true}
+
+var memoryOperationsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mcp_memory_operations_total",
+		Help: "Total number of working memory reads and writes",
+	},
+	[]string{"operation", "status"},
+)
+
+func init() {
+	prometheus.MustRegister(memoryOperationsTotal)
+}
+
+// initMemory reads the memory settings.
+func initMemory() {
+	if ttl := os.Getenv("MEMORY_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid MEMORY_TTL %q: expected a positive duration such as 24h", ttl)
+		}
+		memoryTTL = d
+	}
+}
+
+// sessionID returns the session of a request: its Mcp-Session-Id header,
+// or defaultSession when the header is missing or invalid.
+func sessionID(r *http.Request) string {
+	id := r.Header.Get(sessionHeader)
+	if id == "" || len(id) > maxSessionLength {
+		return defaultSession
+	}
+	// The header is visible ASCII, which keeps it safe in a Redis key
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return defaultSession
+		}
+	}
+	return id
+}
+
+func memoryKey(userID, session string) string {
+	return "memory:" + userID + ":" + session
+}
+
+// normalizeFactKey makes keys such as "Home City" and "home_city" the same
+// fact.
+func normalizeFactKey(key string) (string, error) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	key = strings.Join(strings.Fields(key), "_")
+	if key == "" {
+		return "", fmt.Errorf("%w: key is required", errInvalidFact)
+	}
+	if len(key) > maxFactKeyLength {
+		return "", fmt.Errorf("%w: key is longer than %d characters", errInvalidFact, maxFactKeyLength)
+	}
+	return key, nil
+}
+
+// remember stores a fact in the session's memory, or forgets it when
+// value is empty, and returns the facts the session then holds.
+func remember(ctx context.Context, userID, session, key, value string) (map[string]string, error) {
+	key, err := normalizeFactKey(key)
+	if err != nil {
+		return nil, err
+	}
+	value = strings.TrimSpace(value)
+	if len(value) > maxFactLength {
+		return nil, fmt.Errorf("%w: value is longer than %d characters", errInvalidFact, maxFactLength)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	redisKey := memoryKey(userID, session)
+	operation := "remember"
+	if value == "" {
+		operation = "forget"
+		err = redisClient.HDel(ctx, redisKey, key).Err()
+	} else {
+		err = setFact(ctx, redisKey, key, value)
+	}
+	if err != nil {
+		if errors.Is(err, errMemoryFull) {
+			memoryOperationsTotal.WithLabelValues(operation, "full").Inc()
+			return nil, err
+		}
+		memoryOperationsTotal.WithLabelValues(operation, "error").Inc()
+		return nil, fmt.Errorf("writing memory: %w", err)
+	}
+	memoryOperationsTotal.WithLabelValues(operation, "success").Inc()
+	return readFacts(ctx, redisKey)
+}
+
+// setFact sets a fact, refusing new ones beyond maxFacts, and renews the
+// session's expiry. Concurrent writes may overshoot the bound slightly.
+func setFact(ctx context.Context, redisKey, key, value string) error {
+	exists, err := redisClient.HExists(ctx, redisKey, key).Result()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		count, err := redisClient.HLen(ctx, redisKey).Result()
+		if err != nil {
+			return err
+		}
+		if count >= maxFacts {
+			return errMemoryFull
+		}
+	}
+	pipe := redisClient.TxPipeline()
+	pipe.HSet(ctx, redisKey, key, value)
+	pipe.Expire(ctx, redisKey, memoryTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// recall returns the facts of the session, only the one named key when it
+// is set. Reading the memory keeps the session alive.
+func recall(ctx context.Context, userID, session, key string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	facts, err := readFacts(ctx, memoryKey(userID, session))
+	if err != nil {
+		memoryOperationsTotal.WithLabelValues("recall", "error").Inc()
+		return nil, fmt.Errorf("reading memory: %w", err)
+	}
+	memoryOperationsTotal.WithLabelValues("recall", "success").Inc()
+	if key == "" {
+		return facts, nil
+	}
+	if key, err = normalizeFactKey(key); err != nil {
+		return nil, err
+	}
+	if value, ok := facts[key]; ok {
+		return map[string]string{key: value}, nil
+	}
+	return map[string]string{}, nil
+}
+
+func readFacts(ctx context.Context, redisKey string) (map[string]string, error) {
+	facts, err := redisClient.HGetAll(ctx, redisKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(facts) > 0 {
+		redisClient.Expire(ctx, redisKey, memoryTTL)
+	}
+	return facts, nil
+}
+
+// withRememberedArguments fills the string arguments a tool call leaves
+// out with the session's facts of the same name, so that once the agent
+// has remembered city, get_weather uses it. Memory is a convenience here:
+// when it cannot be read, the arguments are used as they are.
+func withRememberedArguments(ctx context.Context, userID, session, toolName string, arguments map[string]interface{}) map[string]interface{} {
+	if unrememberedTools[toolName] {
+		return arguments
+	}
+	properties := stringProperties(toolName)
+	if len(properties) == 0 {
+		return arguments
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	facts, err := redisClient.HGetAll(ctx, memoryKey(userID, session)).Result()
+	if err != nil {
+		log.Printf("Failed to read memory of %s: %v", userID, err)
+		return arguments
+	}
+
+	filled := make(map[string]interface{}, len(arguments))
+	for name, value := range arguments {
+		filled[name] = value
+	}
+	for _, name := range properties {
+		if value, ok := facts[name]; ok {
+			if current, _ := filled[name].(string); current == "" {
+				filled[name] = value
+			}
+		}
+	}
+	return filled
+}
+
+// stringProperties returns the string arguments of a tool.
+func stringProperties(toolName string) []string {
+	for _, tool := range registeredTools() {
+		if tool.Name != toolName {
+			continue
+		}
+		properties, _ := tool.InputSchema["properties"].(map[string]interface{})
+		var names []string
+		for name, schema := range properties {
+			if s, _ := schema.(map[string]interface{}); s["type"] == "string" {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+)
+
+// Defaults of plan_my_day
+const (
+	defaultWorkStart       = "09:00"
+	defaultWorkEnd         = "17:00"
+	defaultEstimateMinutes = 60
+	// estimateTagPrefix marks the tag holding a task's estimate, such as
+	// estimate:45m
+	estimateTagPrefix = "estimate:"
+	// planBlockPrefix starts the summary of the tentative blocks created
+	// for tasks
+	planBlockPrefix = "Focus: "
+	// planBlockDescription describes a tentative block, which is how later
+	// plans recognise it
+	planBlockDescription = "Tentative block for task #%d, planned by plan_my_day"
+)
+
+// errInvalidPlan wraps the reasons plan_my_day arguments are refused
+var errInvalidPlan = errors.New("invalid plan arguments")
+
+// PlanArgs are the arguments of plan_my_day
+type PlanArgs struct {
+	Date      string `json:"date"`
+	WorkStart string `json:"work_start"`
+	WorkEnd   string `json:"work_end"`
+	Timezone  string `json:"timezone"`
+	// TaskIDs limits the plan to these tasks, whatever their due date
+	TaskIDs []int `json:"task_ids"`
+	// Estimates maps task IDs to minutes and overrides estimate tags
+	Estimates              map[string]int `json:"estimates"`
+	DefaultEstimateMinutes int            `json:"default_estimate_minutes"`
+	BufferMinutes          int            `json:"buffer_minutes"`
+	CreateBlocks           bool           `json:"create_blocks"`
+}
+
+// DayPlan is what plan_my_day proposes for a day
+type DayPlan struct {
+	Date      string `json:"date"`
+	Timezone  string `json:"timezone"`
+	WorkStart string `json:"work_start"`
+	WorkEnd   string `json:"work_end"`
+	// Blocks are the events and the scheduled tasks in time order
+	Blocks      []PlanBlock       `json:"blocks"`
+	Unscheduled []UnscheduledTask `json:"unscheduled"`
+	Conflicts   []PlanConflict    `json:"conflicts"`
+	// FreeMinutes are the working minutes left once tasks are scheduled
+	FreeMinutes int `json:"free_minutes"`
+	// Committed is set once tentative blocks were created for the tasks
+	Committed bool `json:"committed"`
+}
+
+// PlanBlock is a slot of a day plan: an existing event or a task
+type PlanBlock struct {
+	Kind    string    `json:"kind"` // event or task
+	Title   string    `json:"title"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	EventID string    `json:"event_id,omitempty"`
+	TaskID  int       `json:"task_id,omitempty"`
+	// AllDay events are shown but do not take working time
+	AllDay bool `json:"all_day,omitempty"`
+}
+
+// UnscheduledTask is a task that did not fit the day
+type UnscheduledTask struct {
+	TaskID          int    `json:"task_id"`
+	Title           string `json:"title"`
+	EstimateMinutes int    `json:"estimate_minutes"`
+	Reason          string `json:"reason"`
+}
+
+// PlanConflict is a pair of overlapping events
+type PlanConflict struct {
+	EventIDs  []string  `json:"event_ids"`
+	Summaries []string  `json:"summaries"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+}
+
+// PlanResult is the result of plan_my_day, shaped like ReviewResult
+type PlanResult struct {
+	Content           []ContentBlock `json:"content"`
+	StructuredContent *DayPlan       `json:"structuredContent"`
+}
+
+// interval is a span of free time
+type interval struct {
+	start, end time.Time
+}
+
+// planMyDay schedules the user's open tasks due by the day, or those in
+// args.TaskIDs, into the working hours left free by the day's events.
+// Tasks are taken in sortOpenTasks order and each goes into the first gap
+// it fits whole. The plan is only a proposal unless args.CreateBlocks is
+// set, in which case a tentative event is created for each scheduled task.
+func planMyDay(ctx context.Context, userID string, args PlanArgs) (*PlanResult, error) {
+	loc, day, workStart, workEnd, err := planWindow(args)
+	if err != nil {
+		return nil, err
+	}
+	if args.DefaultEstimateMinutes <= 0 {
+		args.DefaultEstimateMinutes = defaultEstimateMinutes
+	}
+	if args.BufferMinutes < 0 {
+		return nil, fmt.Errorf("%w: buffer_minutes must not be negative", errInvalidPlan)
+	}
+	buffer := time.Duration(args.BufferMinutes) * time.Minute
+
+	tasks, err := planTasks(ctx, day, args.TaskIDs)
+	if err != nil {
+		return nil, err
+	}
+	dayStart := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+	events, err := calendarClient.Events(ctx, client.EventsQuery{
+		StartDate: dayStart.Format(time.RFC3339),
+		EndDate:   dayStart.AddDate(0, 0, 1).Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+
+	plan := &DayPlan{
+		Date:        day.Format("2006-01-02"),
+		Timezone:    loc.String(),
+		WorkStart:   workStart.Format("15:04"),
+		WorkEnd:     workEnd.Format("15:04"),
+		Blocks:      []PlanBlock{},
+		Unscheduled: []UnscheduledTask{},
+		Conflicts:   findConflicts(events, loc),
+	}
+
+	// Nothing can be scheduled in the past
+	from := workStart
+	if now := time.Now().In(loc); now.After(from) {
+		from = now.Truncate(5 * time.Minute).Add(5 * time.Minute)
+	}
+	free := []interval{}
+	if from.Before(workEnd) {
+		free = append(free, interval{from, workEnd})
+	}
+	// Tasks given a block by an earlier plan are not planned again
+	blocked := map[int]bool{}
+	for _, event := range events {
+		allDay := event.End.Sub(event.Start) >= 24*time.Hour
+		block := PlanBlock{
+			Kind:    "event",
+			Title:   event.Summary,
+			Start:   event.Start.In(loc),
+			End:     event.End.In(loc),
+			EventID: event.ID,
+			AllDay:  allDay,
+		}
+		if _, err := fmt.Sscanf(event.Description, planBlockDescription, &block.TaskID); err == nil {
+			blocked[block.TaskID] = true
+		}
+		plan.Blocks = append(plan.Blocks, block)
+		if !allDay {
+			free = subtractInterval(free, interval{event.Start.Add(-buffer), event.End.Add(buffer)})
+		}
+	}
+
+	for _, task := range tasks {
+		if blocked[task.ID] {
+			continue
+		}
+		estimate := taskEstimate(task, args)
+		placed := false
+		for i, gap := range free {
+			if gap.end.Sub(gap.start) < estimate {
+				continue
+			}
+			plan.Blocks = append(plan.Blocks, PlanBlock{
+				Kind:   "task",
+				Title:  task.Title,
+				Start:  gap.start,
+				End:    gap.start.Add(estimate),
+				TaskID: task.ID,
+			})
+			free[i].start = gap.start.Add(estimate + buffer)
+			placed = true
+			break
+		}
+		if !placed {
+			plan.Unscheduled = append(plan.Unscheduled, UnscheduledTask{
+				TaskID:          task.ID,
+				Title:           task.Title,
+				EstimateMinutes: int(estimate / time.Minute),
+				Reason:          fmt.Sprintf("no free slot of %d minutes left", int(estimate/time.Minute)),
+			})
+		}
+	}
+	for _, gap := range free {
+		if gap.end.After(gap.start) {
+			plan.FreeMinutes += int(gap.end.Sub(gap.start) / time.Minute)
+		}
+	}
+	sort.SliceStable(plan.Blocks, func(i, j int) bool { return plan.Blocks[i].Start.Before(plan.Blocks[j].Start) })
+
+	if args.CreateBlocks {
+		for i, block := range plan.Blocks {
+			if block.Kind != "task" {
+				continue
+			}
+			created, err := calendarClient.CreateEvent(ctx, client.CreateEventRequest{
+				Summary:     planBlockPrefix + block.Title,
+				Description: fmt.Sprintf(planBlockDescription, block.TaskID),
+				Start:       block.Start.Format(time.RFC3339),
+				End:         block.End.Format(time.RFC3339),
+			})
+			if err != nil {
+				// The blocks created so far can be undone one by one
+				return nil, err
+			}
+			recordAction(userID, Action{Type: actionCreateEvent, Event: created})
+			plan.Blocks[i].EventID = created.ID
+		}
+		plan.Committed = true
+	}
+
+	return &PlanResult{
+		Content:           []ContentBlock{{Type: "text", Text: formatPlan(plan)}},
+		StructuredContent: plan,
+	}, nil
+}
+
+// planWindow resolves the day and the working hours of args.
+func planWindow(args PlanArgs) (loc *time.Location, day, workStart, workEnd time.Time, err error) {
+	loc = time.UTC
+	if args.Timezone != "" {
+		if loc, err = time.LoadLocation(args.Timezone); err != nil {
+			return nil, day, workStart, workEnd, fmt.Errorf("%w: unknown timezone %q", errInvalidPlan, args.Timezone)
+		}
+	}
+
+	day = time.Now().In(loc)
+	if args.Date != "" {
+		if day, err = time.ParseInLocation("2006-01-02", args.Date, loc); err != nil {
+			return nil, day, workStart, workEnd, fmt.Errorf("%w: date must be a YYYY-MM-DD date", errInvalidPlan)
+		}
+	}
+
+	clock := func(name, value, fallback string) (time.Time, error) {
+		if value == "" {
+			value = fallback
+		}
+		t, err := time.Parse("15:04", value)
+		if err != nil {
+			return t, fmt.Errorf("%w: %s must be a HH:MM time", errInvalidPlan, name)
+		}
+		return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, loc), nil
+	}
+	if workStart, err = clock("work_start", args.WorkStart, defaultWorkStart); err != nil {
+		return nil, day, workStart, workEnd, err
+	}
+	if workEnd, err = clock("work_end", args.WorkEnd, defaultWorkEnd); err != nil {
+		return nil, day, workStart, workEnd, err
+	}
+	if !workEnd.After(workStart) {
+		return nil, day, workStart, workEnd, fmt.Errorf("%w: work_end must be after work_start", errInvalidPlan)
+	}
+	return loc, day, workStart, workEnd, nil
+}
+
+// planTasks returns the tasks to plan in the order they are scheduled: the
+// open tasks due by day or undated, or the tasks of ids when given.
+func planTasks(ctx context.Context, day time.Time, ids []int) ([]client.Task, error) {
+	all, err := tasksClient.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	wanted := map[int]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	date := day.Format("2006-01-02")
+	var tasks []client.Task
+	for _, task := range all {
+		if doneStatuses[task.Status] || task.Status == "cancelled" || hasTag(task, reviewTag) {
+			continue
+		}
+		if len(wanted) > 0 {
+			if wanted[task.ID] {
+				tasks = append(tasks, task)
+			}
+			continue
+		}
+		if task.DueDate == "" || task.DueDate <= date {
+			tasks = append(tasks, task)
+		}
+	}
+	sortOpenTasks(tasks)
+	return tasks, nil
+}
+
+// taskEstimate is how long task takes: from args.Estimates, else from its
+// estimate tag, a duration such as estimate:1h30m or minutes such as
+// estimate:45, else the default estimate.
+func taskEstimate(task client.Task, args PlanArgs) time.Duration {
+	if minutes, ok := args.Estimates[strconv.Itoa(task.ID)]; ok && minutes > 0 {
+		return time.Duration(minutes) * time.Minute
+	}
+	for _, tag := range task.Tags {
+		value, ok := strings.CutPrefix(tag, estimateTagPrefix)
+		if !ok {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		if minutes, err := strconv.Atoi(value); err == nil && minutes > 0 {
+			return time.Duration(minutes) * time.Minute
+		}
+	}
+	return time.Duration(args.DefaultEstimateMinutes) * time.Minute
+}
+
+// subtractInterval removes busy from the free intervals.
+func subtractInterval(free []interval, busy interval) []interval {
+	var left []interval
+	for _, gap := range free {
+		if !busy.start.Before(gap.end) || !busy.end.After(gap.start) {
+			left = append(left, gap)
+			continue
+		}
+		if busy.start.After(gap.start) {
+			left = append(left, interval{gap.start, busy.start})
+		}
+		if busy.end.Before(gap.end) {
+			left = append(left, interval{busy.end, gap.end})
+		}
+	}
+	return left
+}
+
+// findConflicts returns the pairs of timed events that overlap, in loc.
+// events must be sorted by start.
+func findConflicts(events []client.Event, loc *time.Location) []PlanConflict {
+	conflicts := []PlanConflict{}
+	for i, a := range events {
+		if a.End.Sub(a.Start) >= 24*time.Hour {
+			continue
+		}
+		for _, b := range events[i+1:] {
+			if !b.Start.Before(a.End) {
+				break
+			}
+			if b.End.Sub(b.Start) >= 24*time.Hour {
+				continue
+			}
+			end := a.End
+			if b.End.Before(end) {
+				end = b.End
+			}
+			conflicts = append(conflicts, PlanConflict{
+				EventIDs:  []string{a.ID, b.ID},
+				Summaries: []string{a.Summary, b.Summary},
+				Start:     b.Start.In(loc),
+				End:       end.In(loc),
+			})
+		}
+	}
+	return conflicts
+}
+
+// formatPlan renders a plan as Markdown.
+func formatPlan(plan *DayPlan) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Plan for %s (%s to %s, %s)\n\n", plan.Date, plan.WorkStart, plan.WorkEnd, plan.Timezone)
+	if len(plan.Blocks) == 0 {
+		b.WriteString("Nothing planned.\n")
+	}
+	for _, block := range plan.Blocks {
+		switch {
+		case block.AllDay:
+			fmt.Fprintf(&b, "- All day: %s\n", block.Title)
+		case block.Kind == "task":
+			fmt.Fprintf(&b, "- %s-%s %s (task #%d)\n", block.Start.Format("15:04"), block.End.Format("15:04"), block.Title, block.TaskID)
+		default:
+			fmt.Fprintf(&b, "- %s-%s %s\n", block.Start.Format("15:04"), block.End.Format("15:04"), block.Title)
+		}
+	}
+	if len(plan.Unscheduled) > 0 {
+		b.WriteString("\n## Not scheduled\n")
+		for _, task := range plan.Unscheduled {
+			fmt.Fprintf(&b, "- %s (task #%d): %s\n", task.Title, task.TaskID, task.Reason)
+		}
+	}
+	if len(plan.Conflicts) > 0 {
+		b.WriteString("\n## Conflicts\n")
+		for _, c := range plan.Conflicts {
+			fmt.Fprintf(&b, "- %s overlaps %s from %s\n", c.Summaries[0], c.Summaries[1], c.Start.Format("15:04"))
+		}
+	}
+	fmt.Fprintf(&b, "\nFree time left: %d minutes\n", plan.FreeMinutes)
+	if plan.Committed {
+		b.WriteString("Tentative blocks were added to the calendar.\n")
+	} else {
+		b.WriteString("Call plan_my_day again with create_blocks to add the tasks to the calendar.\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+)
+
+// Prompts: canned workflows an MCP client can offer its user, such as
+// planning the day. prompts/get fills a prompt's arguments into its
+// instructions, written with {{argument}} placeholders, and puts what the
+// workflow needs from the backends before them: the day's tasks, events
+// and weather for plan_my_day, the event for prepare_for_meeting. The
+// model then follows the instructions with the tools.
+
+// PromptArgument is an argument of a prompt, filled in by the user
+type PromptArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+// Prompt is an entry of prompts/list
+type Prompt struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Arguments   []PromptArgument `json:"arguments"`
+}
+
+// PromptMessage is a message of a prompt; its content is a text or an
+// embedded resource.
+type PromptMessage struct {
+	Role    string                 `json:"role"`
+	Content map[string]interface{} `json:"content"`
+}
+
+// promptDef is a prompt of the registry
+type promptDef struct {
+	Prompt
+	// defaults fills in the optional arguments left out
+	defaults func(args map[string]string)
+	// context returns the messages with what the prompt needs from the
+	// backends. It fails only when an argument names nothing.
+	context func(ctx context.Context, args map[string]string) ([]PromptMessage, error)
+	// instructions is the last message, with each {{argument}} replaced
+	// by its value
+	instructions string
+}
+
+// prompts is the prompt registry, in the order prompts/list lists it
+var prompts = []promptDef{
+	{
+		Prompt: Prompt{
+			Name:        "plan_my_day",
+			Description: "Plan a day around its events, the open tasks due by then and the weather",
+			Arguments: []PromptArgument{
+				{Name: "date", Description: "Day to plan, YYYY-MM-DD (default: today)"},
+				{Name: "city", Description: "City to check the weather of (default: the home city)"},
+			},
+		},
+		defaults: func(args map[string]string) {
+			if args["date"] == "" {
+				args["date"] = time.Now().Format("2006-01-02")
+			}
+		},
+		context: planPromptContext,
+		instructions: "Plan my day for {{date}}. Above are my events that day, my open tasks due by then and the weather. " +
+			"Propose an order for the tasks around the events, most urgent first, say which are unlikely to fit, " +
+			"and warn me about weather that affects going out. Once I agree, schedule the plan with the plan_my_day tool for {{date}}.",
+	},
+	{
+		Prompt: Prompt{
+			Name:        "prepare_for_meeting",
+			Description: "Prepare for a calendar event: its agenda, open questions and the tasks to take from it",
+			Arguments: []PromptArgument{
+				{Name: "event_id", Description: "ID of the event", Required: true},
+			},
+		},
+		context: func(ctx context.Context, args map[string]string) ([]PromptMessage, error) {
+			message, err := resourceMessage(ctx, "calendar://"+url.PathEscape(args["event_id"]))
+			if err != nil {
+				return nil, err
+			}
+			return []PromptMessage{message}, nil
+		},
+		instructions: "Help me prepare for the meeting above (event {{event_id}}): summarize what it is about, " +
+			"draft an agenda and list the questions to settle. Suggest follow-up tasks and add the ones I accept with the add_task tool.",
+	},
+	{
+		Prompt: Prompt{
+			Name:        "weekly_review",
+			Description: "Review the past week and plan the next one",
+			Arguments: []PromptArgument{
+				{Name: "focus", Description: "What to pay attention to, e.g. overdue work (default: everything)"},
+			},
+		},
+		defaults: func(args map[string]string) {
+			if args["focus"] == "" {
+				args["focus"] = "everything"
+			}
+		},
+		instructions: "Review my week with the generate_weekly_review tool, paying attention to {{focus}}. " +
+			"Tell me what got done, what slipped and why it might have, then propose my three priorities for next week.",
+	},
+}
+
+// findPrompt returns the prompt of the registry named name.
+func findPrompt(name string) (promptDef, bool) {
+	for _, p := range prompts {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return promptDef{}, false
+}
+
+// handlePromptsList answers prompts/list.
+func handlePromptsList(req MCPRequest) MCPResponse {
+	list := make([]Prompt, len(prompts))
+	for i, p := range prompts {
+		list[i] = p.Prompt
+	}
+	return MCPResponse{ID: req.ID, Result: map[string]interface{}{"prompts": list}}
+}
+
+// handlePromptsGet answers prompts/get with the messages of the prompt
+// named by the name param, filled with its arguments param.
+func handlePromptsGet(ctx context.Context, req MCPRequest) MCPResponse {
+	invalid := func(format string, args ...interface{}) MCPResponse {
+		return MCPResponse{ID: req.ID, Error: &MCPError{Code: -32602, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	name, _ := req.Params["name"].(string)
+	prompt, ok := findPrompt(name)
+	if !ok {
+		return invalid("Unknown prompt: %s", name)
+	}
+	raw, _ := req.Params["arguments"].(map[string]interface{})
+	args := map[string]string{}
+	for key, value := range raw {
+		s, ok := value.(string)
+		if !ok {
+			return invalid("Argument %s of prompt %s must be a string", key, name)
+		}
+		args[key] = strings.TrimSpace(s)
+	}
+	for _, arg := range prompt.Arguments {
+		if arg.Required && args[arg.Name] == "" {
+			return invalid("Prompt %s needs argument %s", name, arg.Name)
+		}
+	}
+	if prompt.defaults != nil {
+		prompt.defaults(args)
+	}
+
+	messages := []PromptMessage{}
+	if prompt.context != nil {
+		found, err := prompt.context(ctx, args)
+		if err != nil {
+			return MCPResponse{ID: req.ID, Error: toolError(err)}
+		}
+		messages = append(messages, found...)
+	}
+	messages = append(messages, textMessage(fillPrompt(prompt.instructions, args)))
+	return MCPResponse{
+		ID:     req.ID,
+		Result: map[string]interface{}{"description": prompt.Description, "messages": messages},
+	}
+}
+
+// fillPrompt replaces each {{argument}} of text by its value in args.
+func fillPrompt(text string, args map[string]string) string {
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, "{{"+key+"}}", args[key])
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// textMessage is a user message of text.
+func textMessage(text string) PromptMessage {
+	return PromptMessage{Role: "user", Content: map[string]interface{}{"type": "text", "text": text}}
+}
+
+// resourceMessage is a user message embedding the resource at uri.
+func resourceMessage(ctx context.Context, uri string) (PromptMessage, error) {
+	resource, err := readResource(ctx, uri)
+	if err != nil {
+		return PromptMessage{}, fmt.Errorf("%s: %w", uri, err)
+	}
+	data, err := json.MarshalIndent(resource, "", "  ")
+	if err != nil {
+		return PromptMessage{}, err
+	}
+	return PromptMessage{Role: "user", Content: map[string]interface{}{
+		"type":     "resource",
+		"resource": map[string]string{"uri": uri, "mimeType": resourceMIMEType, "text": string(data)},
+	}}, nil
+}
+
+// planPromptContext returns the events of the day to plan, the open tasks
+// due by then and the weather. What a backend fails to give is said
+// instead, so the plan can still be made from the rest.
+func planPromptContext(ctx context.Context, args map[string]string) ([]PromptMessage, error) {
+	day, err := time.ParseInLocation("2006-01-02", args["date"], time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("%w: date must be a YYYY-MM-DD date", errInvalidPlan)
+	}
+	section := func(title string, data interface{}, err error) PromptMessage {
+		if err != nil {
+			return textMessage(fmt.Sprintf("%s: unavailable (%v)", title, err))
+		}
+		text, _ := json.MarshalIndent(data, "", "  ")
+		return textMessage(title + ":\n" + string(text))
+	}
+
+	events, err := calendarClient.Events(ctx, client.EventsQuery{
+		StartDate: day.Format(time.RFC3339),
+		EndDate:   day.AddDate(0, 0, 1).Format(time.RFC3339),
+	})
+	sort.Slice(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	messages := []PromptMessage{section("Events on "+args["date"], events, err)}
+
+	tasks, err := planTasks(ctx, day, nil)
+	messages = append(messages, section("Open tasks due by "+args["date"], tasks, err))
+
+	if args["city"] != "" {
+		message, err := resourceMessage(ctx, "weather://"+url.PathEscape(args["city"]))
+		if err != nil {
+			message = textMessage(fmt.Sprintf("Weather in %s: unavailable (%v)", args["city"], err))
+		}
+		messages = append(messages, message)
+	} else {
+		weather, err := weatherClient.Current(ctx, "")
+		messages = append(messages, section("Weather in the home city", weather, err))
+	}
+	return messages, nil
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+	"gopkg.in/yaml.v3"
+)
+
+// Tool registry: the tools the server offers are those of the file
+// tools.yaml, built into the server, and of the YAML or JSON file
+// MCP_TOOLS_FILE, which adds tools to them, replaces those of the same
+// name and removes those it marks disabled. A tool runs the built-in handler it
+// names, or calls one endpoint of a backend with its arguments, so a tool
+// an endpoint already provides is added by configuration alone:
+//
+//	backends:
+//	  inventory-service: http://inventory-service:8090
+//	tools:
+//	  - name: get_task
+//	    description: Get a task by its ID
+//	    backend: task-service
+//	    method: GET
+//	    path: /tasks/{task_id}
+//	    input_schema:
+//	      type: object
+//	      properties:
+//	        task_id: {type: integer, description: ID of the task}
+//	      required: [task_id]
+//	  - name: cleanup_completed_tasks
+//	    disabled: true
+//
+// The arguments named in the path fill it in; the others go in the query
+// string of GET and DELETE requests and in the JSON body of the others.
+// The backend is one of the services the server knows or one of the
+// backends of the file, and the calls are made as the caller, as those of
+// the handlers are. The files are read at startup and again on SIGHUP,
+// after which clients with a stream are told the tools changed.
+
+// defaultTools is the contents of tools.yaml.
+//
+//go:embed tools.yaml
+var defaultTools []byte
+
+// ToolConfig is a tool of tools.yaml or MCP_TOOLS_FILE.
+type ToolConfig struct {
+	Name        string                 `yaml:"name"`
+	Description string                 `yaml:"description"`
+	InputSchema map[string]interface{} `yaml:"input_schema"`
+	// Handler names the built-in code of toolHandlers the tool runs, in
+	// place of a backend endpoint.
+	Handler string `yaml:"handler"`
+	// Needs are the backends a tool with a handler cannot do without;
+	// those it only uses when they are up are left out.
+	Needs   []string `yaml:"needs"`
+	Backend string   `yaml:"backend"`
+	// Method is GET by default.
+	Method string `yaml:"method"`
+	// Path is the path of the endpoint, with {argument} placeholders.
+	Path string `yaml:"path"`
+	// Idempotent tools are retried like GET tools even when their method
+	// is POST or PATCH, as calling them twice changes nothing more.
+	Idempotent bool `yaml:"idempotent"`
+	// Disabled removes the tool of that name from those of tools.yaml.
+	Disabled bool `yaml:"disabled"`
+}
+
+// toolsFile is the contents of tools.yaml or MCP_TOOLS_FILE.
+type toolsFile struct {
+	// Backends are the base URLs of services besides the known ones.
+	Backends map[string]string `yaml:"backends"`
+	Tools    []ToolConfig      `yaml:"tools"`
+}
+
+// configuredTool is a tool of the registry with its handler or the client
+// of its backend.
+type configuredTool struct {
+	ToolConfig
+	handler toolHandler
+	client  *client.ServiceClient
+}
+
+// toolCall is a call of a tool handler.
+type toolCall struct {
+	userID, session string
+	arguments       map[string]interface{}
+}
+
+// toolHandler runs a tool with the arguments of a call.
+type toolHandler func(ctx context.Context, call toolCall) (interface{}, error)
+
+// toolRegistry is the tools of the server, in the order of tools.yaml
+// followed by those MCP_TOOLS_FILE adds
+var toolRegistry = struct {
+	sync.RWMutex
+	tools []configuredTool
+}{}
+
+// pathPlaceholder is an {argument} of the path of a configured tool
+var pathPlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// knownBackends are the base URLs of the services the server knows.
+func knownBackends() map[string]string {
+	return map[string]string{
+		"task-service":         taskServiceURL,
+		"calendar-service":     calendarServiceURL,
+		"weather-service":      weatherServiceURL,
+		"notification-service": notificationServiceURL,
+		"github-sync-service":  githubSyncServiceURL,
+	}
+}
+
+// loadToolRegistry reads tools.yaml and MCP_TOOLS_FILE, if set, and
+// replaces the tools of the registry with theirs. Files with an invalid
+// tool leave the registry as it was.
+func loadToolRegistry() error {
+	file, err := readToolsFile("tools.yaml", defaultTools)
+	if err != nil {
+		return err
+	}
+	source := "tools.yaml"
+	if path := os.Getenv("MCP_TOOLS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		overrides, err := readToolsFile(path, data)
+		if err != nil {
+			return err
+		}
+		if file, err = mergeTools(file, overrides); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		source += " and " + path
+	}
+	tools, err := parseTools(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+
+	toolRegistry.Lock()
+	toolRegistry.tools = tools
+	toolRegistry.Unlock()
+	log.Printf("Loaded %d tools from %s", len(tools), source)
+	return nil
+}
+
+// readToolsFile decodes the tools file at path, whose names must be
+// unique.
+func readToolsFile(path string, data []byte) (toolsFile, error) {
+	var file toolsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return file, fmt.Errorf("%s: %w", path, err)
+	}
+	names := map[string]bool{}
+	for _, cfg := range file.Tools {
+		if cfg.Name == "" {
+			return file, fmt.Errorf("%s: a tool has no name", path)
+		}
+		if names[cfg.Name] {
+			return file, fmt.Errorf("%s: tool %s is defined twice", path, cfg.Name)
+		}
+		names[cfg.Name] = true
+	}
+	return file, nil
+}
+
+// mergeTools returns the tools of file with those of overrides added,
+// replacing the tools of the same name, and those overrides disable
+// removed.
+func mergeTools(file, overrides toolsFile) (toolsFile, error) {
+	merged := toolsFile{Backends: map[string]string{}}
+	for name, baseURL := range file.Backends {
+		merged.Backends[name] = baseURL
+	}
+	for name, baseURL := range overrides.Backends {
+		merged.Backends[name] = baseURL
+	}
+	replaced := map[string]bool{}
+	byName := map[string]ToolConfig{}
+	for _, cfg := range overrides.Tools {
+		byName[cfg.Name] = cfg
+	}
+	for _, cfg := range file.Tools {
+		if override, ok := byName[cfg.Name]; ok {
+			replaced[cfg.Name] = true
+			if override.Disabled {
+				continue
+			}
+			cfg = override
+		}
+		merged.Tools = append(merged.Tools, cfg)
+	}
+	for _, cfg := range overrides.Tools {
+		if replaced[cfg.Name] {
+			continue
+		}
+		if cfg.Disabled {
+			return merged, fmt.Errorf("tool %s is disabled but there is no such tool", cfg.Name)
+		}
+		merged.Tools = append(merged.Tools, cfg)
+	}
+	return merged, nil
+}
+
+// parseTools validates the tools of file.
+func parseTools(file toolsFile) ([]configuredTool, error) {
+	backends := knownBackends()
+	for name, baseURL := range file.Backends {
+		if _, known := backends[name]; known {
+			return nil, fmt.Errorf("backend %s is known already", name)
+		}
+		if u, err := url.Parse(baseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("backend %s: invalid URL %q", name, baseURL)
+		}
+		backends[name] = strings.TrimRight(baseURL, "/")
+	}
+
+	tools := make([]configuredTool, 0, len(file.Tools))
+	for _, cfg := range file.Tools {
+		if cfg.Disabled {
+			continue
+		}
+		if err := normalizeSchema(&cfg); err != nil {
+			return nil, fmt.Errorf("tool %s: %w", cfg.Name, err)
+		}
+		if cfg.Handler != "" {
+			handler, ok := toolHandlers[cfg.Handler]
+			if !ok {
+				return nil, fmt.Errorf("tool %s: unknown handler %q", cfg.Name, cfg.Handler)
+			}
+			if cfg.Backend != "" || cfg.Method != "" || cfg.Path != "" {
+				return nil, fmt.Errorf("tool %s: a tool with a handler has no backend, method or path", cfg.Name)
+			}
+			for _, backend := range cfg.Needs {
+				if _, ok := backends[backend]; !ok && backend != "redis" {
+					return nil, fmt.Errorf("tool %s: unknown backend %q", cfg.Name, backend)
+				}
+			}
+			tools = append(tools, configuredTool{ToolConfig: cfg, handler: handler})
+			continue
+		}
+		baseURL, ok := backends[cfg.Backend]
+		if !ok {
+			return nil, fmt.Errorf("tool %s: unknown backend %q", cfg.Name, cfg.Backend)
+		}
+		cfg.Method = strings.ToUpper(cfg.Method)
+		switch cfg.Method {
+		case "":
+			cfg.Method = http.MethodGet
+		case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			return nil, fmt.Errorf("tool %s: unsupported method %s", cfg.Name, cfg.Method)
+		}
+		if !strings.HasPrefix(cfg.Path, "/") {
+			return nil, fmt.Errorf("tool %s: path must start with /", cfg.Name)
+		}
+		if err := checkPathArguments(cfg); err != nil {
+			return nil, fmt.Errorf("tool %s: %w", cfg.Name, err)
+		}
+		tools = append(tools, configuredTool{ToolConfig: cfg, client: client.NewServiceClient(cfg.Backend, baseURL, withBackend(cfg.Backend)...)})
+	}
+	return tools, nil
+}
+
+// normalizeSchema gives a tool an object schema with its required
+// arguments as a []string.
+func normalizeSchema(cfg *ToolConfig) error {
+	if cfg.InputSchema == nil {
+		cfg.InputSchema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	}
+	if cfg.InputSchema["type"] != "object" {
+		return fmt.Errorf("input_schema must be of type object")
+	}
+	var required []string
+	switch list := cfg.InputSchema["required"].(type) {
+	case nil:
+	case []interface{}:
+		for _, name := range list {
+			s, ok := name.(string)
+			if !ok {
+				return fmt.Errorf("required must list argument names")
+			}
+			required = append(required, s)
+		}
+	default:
+		return fmt.Errorf("required must list argument names")
+	}
+	if required != nil {
+		cfg.InputSchema["required"] = required
+	}
+	return nil
+}
+
+// checkPathArguments checks that the arguments of the path of a tool are
+// required.
+func checkPathArguments(cfg ToolConfig) error {
+	required, _ := cfg.InputSchema["required"].([]string)
+	for _, match := range pathPlaceholder.FindAllStringSubmatch(cfg.Path, -1) {
+		if !contains(required, match[1]) {
+			return fmt.Errorf("path argument %s must be required", match[1])
+		}
+	}
+	return nil
+}
+
+// contains reports whether list holds s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// registeredTools returns the tools of the registry.
+func registeredTools() []Tool {
+	toolRegistry.RLock()
+	defer toolRegistry.RUnlock()
+	tools := make([]Tool, 0, len(toolRegistry.tools))
+	for _, t := range toolRegistry.tools {
+		tools = append(tools, Tool{Name: t.Name, Description: t.Description, InputSchema: t.InputSchema})
+	}
+	return tools
+}
+
+// configuredToolNamed returns the tool of the registry called name.
+func configuredToolNamed(name string) (configuredTool, bool) {
+	toolRegistry.RLock()
+	defer toolRegistry.RUnlock()
+	for _, t := range toolRegistry.tools {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return configuredTool{}, false
+}
+
+// backendsOf returns the backends the tool called name needs.
+func backendsOf(name string) []string {
+	t, ok := configuredToolNamed(name)
+	switch {
+	case !ok:
+		return nil
+	case t.handler != nil:
+		return t.Needs
+	}
+	return []string{t.Backend}
+}
+
+// callConfiguredTool calls the endpoint of tool with arguments.
+func callConfiguredTool(ctx context.Context, tool configuredTool, arguments map[string]interface{}) (interface{}, error) {
+	rest := make(map[string]interface{}, len(arguments))
+	for name, value := range arguments {
+		rest[name] = value
+	}
+	var missing []string
+	path := pathPlaceholder.ReplaceAllStringFunc(tool.Path, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		value, ok := rest[name]
+		if !ok || value == nil {
+			missing = append(missing, name)
+			return placeholder
+		}
+		delete(rest, name)
+		return url.PathEscape(fmt.Sprint(value))
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", errMissingArguments, strings.Join(missing, ", "))
+	}
+
+	var query url.Values
+	var body interface{}
+	if tool.Method == http.MethodGet || tool.Method == http.MethodDelete {
+		query = url.Values{}
+		for name, value := range rest {
+			if list, ok := value.([]interface{}); ok {
+				for _, v := range list {
+					query.Add(name, fmt.Sprint(v))
+				}
+				continue
+			}
+			query.Set(name, fmt.Sprint(value))
+		}
+	} else {
+		body = rest
+	}
+
+	var result interface{}
+	if err := tool.client.Do(ctx, tool.Method, path, query, body, &result); err != nil {
+		return nil, err
+	}
+	if result == nil {
+		result = map[string]interface{}{"status": "ok"}
+	}
+	return result, nil
+}
+
+// reloadToolsOnHangup reloads the tools on each SIGHUP, telling the
+// clients the tools changed.
+func reloadToolsOnHangup() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := loadToolRegistry(); err != nil {
+			log.Printf("Failed to reload the tools, keeping the previous ones: %v", err)
+			continue
+		}
+		notifyClients("notifications/tools/list_changed", nil)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Request parsing: a message is checked before it is served, so that
+// input that would otherwise be misread fails with a precise error. An
+// object with a key twice, which Go would read as its last value, and
+// arrays and objects nested more than maxRequestDepth deep are refused; a
+// request with members JSON-RPC does not define is invalid; and the params
+// of each method are checked for their type and presence, with unknown
+// params refused unless the method is open to them. Flaws of the envelope
+// are -32600 Invalid Request and those of params -32602 Invalid params,
+// whose data lists each param that is wrong as those of tool arguments
+// are. Methods the server does not serve are left to fail with -32601.
+
+// maxRequestDepth is how deeply the arrays and objects of a request may
+// nest, the request itself counting as one
+const maxRequestDepth = 32
+
+// paramSpec is what a param of a method must be.
+type paramSpec struct {
+	// Kind is string, object, array, boolean or id, a string or a number
+	Kind     string
+	Required bool
+}
+
+// methodSpec is the params a method takes.
+type methodSpec struct {
+	Params map[string]paramSpec
+	// Open methods ignore the params they do not know rather than refuse
+	// them
+	Open bool
+}
+
+// methodSpecs are the params of the methods the server serves. Every
+// method also takes _meta, an object.
+var methodSpecs = map[string]methodSpec{
+	"initialize": {
+		Params: map[string]paramSpec{
+			"protocolVersion": {Kind: "string"},
+			"capabilities":    {Kind: "object"},
+			"clientInfo":      {Kind: "object"},
+		},
+		// Later revisions of MCP may add to it
+		Open: true,
+	},
+	"ping":                      {},
+	"notifications/initialized": {},
+	"notifications/cancelled": {Params: map[string]paramSpec{
+		"requestId": {Kind: "id", Required: true},
+		"reason":    {Kind: "string"},
+	}},
+	"$/cancelRequest": {Params: map[string]paramSpec{
+		"id": {Kind: "id", Required: true},
+	}},
+	"tools/call": {Params: map[string]paramSpec{
+		"name":        {Kind: "string", Required: true},
+		"arguments":   {Kind: "object"},
+		"format":      {Kind: "string"},
+		"elicitation": {Kind: "object"},
+		"no_cache":    {Kind: "boolean"},
+	}},
+	"tools/list": {Params: map[string]paramSpec{
+		"cursor": {Kind: "string"},
+	}},
+	"resources/list": {Params: map[string]paramSpec{
+		"cursor": {Kind: "string"},
+	}},
+	"resources/read": {Params: map[string]paramSpec{
+		"uri": {Kind: "string", Required: true},
+	}},
+	"resources/templates/list": {Params: map[string]paramSpec{
+		"cursor": {Kind: "string"},
+	}},
+	"prompts/list": {Params: map[string]paramSpec{
+		"cursor": {Kind: "string"},
+	}},
+	"prompts/get": {Params: map[string]paramSpec{
+		"name":      {Kind: "string", Required: true},
+		"arguments": {Kind: "object"},
+	}},
+	"completion/complete": {Params: map[string]paramSpec{
+		"ref":      {Kind: "object", Required: true},
+		"argument": {Kind: "object", Required: true},
+		"context":  {Kind: "object"},
+	}},
+}
+
+// decodeRequest reads a JSON-RPC request from data, valid JSON. A request
+// that cannot be read is returned with its id when it has a valid one,
+// along with the error to answer it with.
+func decodeRequest(data []byte) (MCPRequest, *MCPError) {
+	var req MCPRequest
+	if data = bytes.TrimSpace(data); data[0] != '{' {
+		return req, &MCPError{Code: -32600, Message: "Invalid Request", Data: "expected a JSON object"}
+	}
+
+	var envelope struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params"`
+	}
+	// Unknown members and members of the wrong type are reported after
+	// the others are read, so the id is known even then
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	decodeErr := dec.Decode(&envelope)
+	flaw := checkStructure(data)
+	// An id is kept as sent, but only a string, a number or null may be
+	// echoed; a request with another one, or with two, is answered with a
+	// null id
+	if envelope.ID != nil && (flaw == nil || flaw.Field != "id") {
+		switch c := envelope.ID[0]; {
+		case c == '"', c == '-', c >= '0' && c <= '9', string(envelope.ID) == "null":
+			req.ID = envelope.ID
+		default:
+			return req, &MCPError{Code: -32600, Message: "Invalid Request", Data: "id must be a string, a number or null"}
+		}
+	}
+	req.Method = envelope.Method
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case flaw != nil && flaw.Field != "params" && !strings.HasPrefix(flaw.Field, "params."):
+		return req, &MCPError{Code: -32600, Message: "Invalid Request", Data: flaw.Field + " " + flaw.Message}
+	case errors.As(decodeErr, &typeErr):
+		return req, &MCPError{Code: -32600, Message: "Invalid Request", Data: typeErr.Field + " must be a string"}
+	case decodeErr != nil:
+		return req, &MCPError{Code: -32600, Message: "Invalid Request", Data: strings.TrimPrefix(decodeErr.Error(), "json: ")}
+	case envelope.JSONRPC != "2.0":
+		return req, &MCPError{Code: -32600, Message: "Invalid Request", Data: `jsonrpc must be "2.0"`}
+	case envelope.Method == "":
+		return req, &MCPError{Code: -32600, Message: "Invalid Request", Data: "method is required"}
+	case flaw != nil:
+		return req, invalidParams([]FieldError{*flaw})
+	}
+	req.JSONRPC = envelope.JSONRPC
+
+	// Params by position are valid JSON-RPC, but no MCP method takes them
+	if len(envelope.Params) > 0 && string(envelope.Params) != "null" {
+		if err := json.Unmarshal(envelope.Params, &req.Params); err != nil {
+			return req, &MCPError{Code: -32602, Message: "Invalid params", Data: "params must be an object"}
+		}
+	}
+	if errs := checkParams(req.Method, req.Params); len(errs) > 0 {
+		return req, invalidParams(errs)
+	}
+	return req, nil
+}
+
+// checkParams returns how params do not match the spec of method, in the
+// order of the params.
+func checkParams(method string, params map[string]interface{}) []FieldError {
+	spec, ok := methodSpecs[method]
+	if !ok {
+		return nil
+	}
+	var errs []FieldError
+	for name, value := range params {
+		param, known := spec.Params[name]
+		if name == "_meta" {
+			param, known = paramSpec{Kind: "object"}, true
+		}
+		switch {
+		case !known && !spec.Open:
+			errs = append(errs, FieldError{Field: "params." + name, Message: "is not a param of " + method})
+		case !known, value == nil:
+		case !isKind(value, param.Kind):
+			errs = append(errs, FieldError{Field: "params." + name, Message: "must be " + kindName(param.Kind)})
+		}
+	}
+	for name, param := range spec.Params {
+		if param.Required && params[name] == nil {
+			errs = append(errs, FieldError{Field: "params." + name, Message: "is required"})
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
+
+// isKind reports whether value, decoded from JSON, is of kind.
+func isKind(value interface{}, kind string) bool {
+	switch value.(type) {
+	case string:
+		return kind == "string" || kind == "id"
+	case float64:
+		return kind == "id"
+	case bool:
+		return kind == "boolean"
+	case []interface{}:
+		return kind == "array"
+	case map[string]interface{}:
+		return kind == "object"
+	}
+	return false
+}
+
+// kindName is how errors name kind.
+func kindName(kind string) string {
+	switch kind {
+	case "id":
+		return "a string or a number"
+	case "array", "object":
+		return "an " + kind
+	}
+	return "a " + kind
+}
+
+// checkStructure walks data, valid JSON, and returns its first flaw: an
+// object with a key twice, or arrays and objects nested more than
+// maxRequestDepth deep. The field of the flaw is its path, such as
+// params.arguments.tags[2].
+func checkStructure(data []byte) *FieldError {
+	// frame is an array or object being walked
+	type frame struct {
+		path string
+		// keys are those of an object so far, nil for an array
+		keys map[string]bool
+		// key is that of the next value of an object, and index that of
+		// an array
+		key     string
+		index   int
+		wantKey bool
+	}
+	var stack []*frame
+	childPath := func(f *frame) string {
+		switch {
+		case f == nil:
+			return ""
+		case f.keys == nil:
+			return fmt.Sprintf("%s[%d]", f.path, f.index)
+		case f.path == "":
+			return f.key
+		}
+		return f.path + "." + f.key
+	}
+	valueDone := func() {
+		if len(stack) == 0 {
+			return
+		}
+		if f := stack[len(stack)-1]; f.keys != nil {
+			f.wantKey = true
+		} else {
+			f.index++
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &FieldError{Field: "request", Message: err.Error()}
+		}
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			valueDone()
+			continue
+		}
+		if top != nil && top.wantKey {
+			key, _ := tok.(string)
+			if top.keys[key] {
+				top.key = key
+				return &FieldError{Field: childPath(top), Message: "is given twice"}
+			}
+			top.keys[key], top.key, top.wantKey = true, key, false
+			continue
+		}
+		if delim, ok := tok.(json.Delim); ok {
+			if len(stack) == maxRequestDepth {
+				return &FieldError{Field: childPath(top), Message: fmt.Sprintf("is nested more than %d deep", maxRequestDepth)}
+			}
+			f := &frame{path: childPath(top)}
+			if delim == '{' {
+				f.keys, f.wantKey = map[string]bool{}, true
+			}
+			stack = append(stack, f)
+			continue
+		}
+		valueDone()
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+)
+
+// Resources: the user's tasks, upcoming calendar events and the weather of
+// the cities the weather service knows are exposed as MCP resources, so a
+// client can list them and read one as JSON by its URI, and a result
+// could point at one rather than inline it:
+//
+//	task://{id}         a task, by its ID
+//	calendar://{id}     an event of the primary calendar, by its ID
+//	weather://{city}    the current weather of a city, from the cache when
+//	                    the weather service has it
+//
+// Each resource is read from its backend when asked for, as the user the
+// request is made for; nothing is kept here.
+
+// resourceMIMEType is the type of the contents of every resource
+const resourceMIMEType = "application/json"
+
+// resourceEventDays is how far ahead resources/list lists events
+const resourceEventDays = 7
+
+// resourcePageSize is the most tasks of one page of resources/list
+const resourcePageSize = 100
+
+// Resource is an entry of resources/list.
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType"`
+}
+
+// ResourceTemplate is an entry of resources/templates/list.
+type ResourceTemplate struct {
+	URITemplate string `json:"uriTemplate"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	MimeType    string `json:"mimeType"`
+}
+
+// resourceTemplates describe the URIs resources/read accepts
+var resourceTemplates = []ResourceTemplate{
+	{URITemplate: "task://{id}", Name: "Task", Description: "A task, by its ID", MimeType: resourceMIMEType},
+	{URITemplate: "calendar://{id}", Name: "Calendar event", Description: "An event of the primary calendar, by its ID", MimeType: resourceMIMEType},
+	{URITemplate: "weather://{city}", Name: "Current weather", Description: "The current weather of a city", MimeType: resourceMIMEType},
+}
+
+// errInvalidResourceURI is a resource URI of none of the templates
+var errInvalidResourceURI = errors.New("invalid resource URI")
+
+// handleResourcesList answers resources/list. The first page lists the
+// weather of the known cities, the events of the next week and the first
+// tasks; the following ones, reached by the nextCursor of the previous
+// page, list more tasks. A backend that is down leaves its resources out
+// rather than failing the list.
+func handleResourcesList(ctx context.Context, req MCPRequest) MCPResponse {
+	cursor, _ := req.Params["cursor"].(string)
+	resources := []Resource{}
+	if cursor == "" {
+		resources = append(resources, weatherResources(ctx)...)
+		resources = append(resources, eventResources(ctx)...)
+	}
+
+	result := map[string]interface{}{}
+	page, err := tasksClient.ListPage(ctx, client.ListOptions{Limit: resourcePageSize, Cursor: cursor})
+	switch {
+	case err != nil && cursor != "":
+		return MCPResponse{ID: req.ID, Error: toolError(err)}
+	case err != nil:
+		log.Printf("Failed to list task resources: %v", err)
+	default:
+		for _, task := range page.Tasks {
+			resources = append(resources, Resource{
+				URI:         "task://" + strconv.Itoa(task.ID),
+				Name:        task.Title,
+				Description: fmt.Sprintf("%s task, %s priority", task.Status, task.Priority),
+				MimeType:    resourceMIMEType,
+			})
+		}
+		if page.NextCursor != "" {
+			result["nextCursor"] = page.NextCursor
+		}
+	}
+	result["resources"] = resources
+	return MCPResponse{ID: req.ID, Result: result}
+}
+
+// eventResources lists the events of the next resourceEventDays days.
+func eventResources(ctx context.Context) []Resource {
+	now := time.Now()
+	events, err := calendarClient.Events(ctx, client.EventsQuery{
+		StartDate: now.Format(time.RFC3339),
+		EndDate:   now.AddDate(0, 0, resourceEventDays).Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Printf("Failed to list calendar resources: %v", err)
+		return nil
+	}
+	resources := make([]Resource, 0, len(events))
+	for _, event := range events {
+		resources = append(resources, Resource{
+			URI:         "calendar://" + url.PathEscape(event.ID),
+			Name:        event.Summary,
+			Description: "Event on " + event.Start.Format("Mon 2 Jan 15:04 MST"),
+			MimeType:    resourceMIMEType,
+		})
+	}
+	return resources
+}
+
+// weatherResources lists the weather of the cities the weather service
+// knows: the home city, the favorites and the places looked up before.
+func weatherResources(ctx context.Context) []Resource {
+	cities, err := weatherClient.Cities(ctx, "", 0)
+	if err != nil {
+		log.Printf("Failed to list weather resources: %v", err)
+		return nil
+	}
+	resources := make([]Resource, 0, len(cities.Cities))
+	for _, city := range cities.Cities {
+		resources = append(resources, Resource{
+			URI:      "weather://" + url.PathEscape(city),
+			Name:     "Weather in " + city,
+			MimeType: resourceMIMEType,
+		})
+	}
+	return resources
+}
+
+// handleResourcesRead answers resources/read with the contents of the
+// resource at the uri param.
+func handleResourcesRead(ctx context.Context, req MCPRequest) MCPResponse {
+	uri, _ := req.Params["uri"].(string)
+	if uri == "" {
+		return MCPResponse{ID: req.ID, Error: &MCPError{Code: -32602, Message: "uri is required"}}
+	}
+	resource, err := readResource(ctx, uri)
+	if err != nil {
+		return MCPResponse{ID: req.ID, Error: resourceError(uri, err)}
+	}
+	data, err := json.MarshalIndent(resource, "", "  ")
+	if err != nil {
+		return MCPResponse{ID: req.ID, Error: &MCPError{Code: -32603, Message: err.Error()}}
+	}
+	return MCPResponse{
+		ID: req.ID,
+		Result: map[string]interface{}{
+			"contents": []map[string]string{{"uri": uri, "mimeType": resourceMIMEType, "text": string(data)}},
+		},
+	}
+}
+
+// readResource fetches the resource at uri from its backend.
+func readResource(ctx context.Context, uri string) (interface{}, error) {
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok || rest == "" {
+		return nil, errInvalidResourceURI
+	}
+	name, err := url.PathUnescape(rest)
+	if err != nil {
+		return nil, errInvalidResourceURI
+	}
+	switch scheme {
+	case "task":
+		id, err := strconv.Atoi(name)
+		if err != nil || id <= 0 {
+			return nil, errInvalidResourceURI
+		}
+		return tasksClient.Get(ctx, id)
+	case "calendar":
+		return calendarClient.Event(ctx, name)
+	case "weather":
+		return weatherClient.Current(ctx, name)
+	}
+	return nil, errInvalidResourceURI
+}
+
+// resourceError maps a failed read of the resource at uri to an MCP
+// error: -32002 for a resource that does not exist, as MCP asks.
+func resourceError(uri string, err error) *MCPError {
+	var ambiguous *client.AmbiguousCityError
+	switch {
+	case errors.Is(err, errInvalidResourceURI):
+		return &MCPError{Code: -32602, Message: "Invalid resource URI: expected task://{id}, calendar://{id} or weather://{city}", Data: map[string]string{"uri": uri}}
+	case errors.Is(err, client.ErrNotFound), errors.As(err, &ambiguous):
+		return &MCPError{Code: -32002, Message: "Resource not found", Data: map[string]string{"uri": uri}}
+	}
+	return toolError(err)
+}
+
+// handleResourceTemplatesList answers resources/templates/list.
+func handleResourceTemplatesList(req MCPRequest) MCPResponse {
+	return MCPResponse{ID: req.ID, Result: map[string]interface{}{"resourceTemplates": resourceTemplates}}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Retries: a backend call that gets no response, or a 429, 502, 503 or
+// 504, is made again up to MCP_RETRY_ATTEMPTS attempts in all, waiting
+// MCP_RETRY_BACKOFF before the first retry and twice as long before each
+// next one, give or take MCP_RETRY_JITTER of it at random. Only calls that
+// are safe to make twice are retried: GET, PUT and DELETE requests, and
+// the POST and PATCH requests of the tools marked idempotent in the tool
+// registry; see registry.go. A call is still bounded by toolCallTimeout,
+// and each failed attempt counts towards opening the backend's circuit
+// breaker, after which it is not retried. Retries are counted in
+// mcp_backend_retries_total.
+
+var backendRetriesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mcp_backend_retries_total",
+		Help: "Number of backend calls made again after a failed attempt",
+	},
+	[]string{"backend"},
+)
+
+func init() {
+	prometheus.MustRegister(backendRetriesTotal)
+}
+
+// retrySettings are the settings of the retries, read before the clients
+// of the backends are made
+var retrySettings = retrySettingsFromEnv()
+
+type retryConfig struct {
+	attempts int
+	backoff  time.Duration
+	jitter   float64
+}
+
+// retrySettingsFromEnv reads MCP_RETRY_ATTEMPTS, MCP_RETRY_BACKOFF and
+// MCP_RETRY_JITTER.
+func retrySettingsFromEnv() retryConfig {
+	cfg := retryConfig{attempts: 1 + client.DefaultRetries, backoff: client.DefaultBackoff, jitter: 0.2}
+	if attempts := os.Getenv("MCP_RETRY_ATTEMPTS"); attempts != "" {
+		n, err := strconv.Atoi(attempts)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid MCP_RETRY_ATTEMPTS %q: expected a number of attempts, 1 for no retries", attempts)
+		}
+		cfg.attempts = n
+	}
+	if backoff := os.Getenv("MCP_RETRY_BACKOFF"); backoff != "" {
+		d, err := time.ParseDuration(backoff)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid MCP_RETRY_BACKOFF %q: expected a duration such as 200ms", backoff)
+		}
+		cfg.backoff = d
+	}
+	if jitter := os.Getenv("MCP_RETRY_JITTER"); jitter != "" {
+		f, err := strconv.ParseFloat(jitter, 64)
+		if err != nil || f < 0 || f > 1 {
+			log.Fatalf("Invalid MCP_RETRY_JITTER %q: expected a fraction between 0 and 1", jitter)
+		}
+		cfg.jitter = f
+	}
+	return cfg
+}
+
+// retryOptions make a client of service retry as the settings say,
+// counting its retries.
+func retryOptions(service string) []client.Option {
+	return []client.Option{
+		client.WithRetries(retrySettings.attempts - 1),
+		client.WithBackoff(retrySettings.backoff),
+		client.WithJitter(retrySettings.jitter),
+		client.WithRetryHook(func(retry int, err error) {
+			backendRetriesTotal.WithLabelValues(service).Inc()
+			log.Printf("Retrying call to %s (retry %d of %d): %v", service, retry, retrySettings.attempts-1, err)
+		}),
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+)
+
+// Doc agent listing the documents indexed during a week and reading
+// documents for create_tasks_from_document; unset leaves documents out of
+// weekly reviews
+var docAgentURL = strings.TrimSuffix(os.Getenv("DOC_AGENT_URL"), "/")
+
+// API key the doc agent is called with, one of its api_keys; unset calls
+// it without one
+var docAgentKey = os.Getenv("DOC_AGENT_API_KEY")
+
+// newDocAgentRequest returns a GET request for target of the doc agent,
+// bearing docAgentKey.
+func newDocAgentRequest(ctx context.Context, target string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if docAgentKey != "" {
+		req.Header.Set("Authorization", "Bearer "+docAgentKey)
+	}
+	return req, nil
+}
+
+// Bounds of a weekly review
+const (
+	// maxReviewItems bounds the highlights and the priorities
+	maxReviewItems = 5
+	// maxReviewEntries bounds the tasks, events or documents of each kind
+	// shown to the LLM
+	maxReviewEntries = 30
+	// docAgentTimeout bounds listing documents, which reviews do without
+	docAgentTimeout = 5 * time.Second
+)
+
+// reviewTag marks the tasks weekly reviews are saved as
+const reviewTag = "weekly-review"
+
+// doneStatuses are the task statuses that count as completed
+var doneStatuses = map[string]bool{"completed": true, "done": true}
+
+// WeeklyReview is what generate_weekly_review produces for a week
+type WeeklyReview struct {
+	// WeekStart and WeekEnd are the Monday and Sunday of the week
+	WeekStart          string      `json:"week_start"`
+	WeekEnd            string      `json:"week_end"`
+	Summary            string      `json:"summary"`
+	Highlights         []string    `json:"highlights"`
+	NextWeekPriorities []string    `json:"next_week_priorities"`
+	Stats              ReviewStats `json:"stats"`
+}
+
+// ReviewStats counts what a review is based on
+type ReviewStats struct {
+	TasksCompleted   int `json:"tasks_completed"`
+	TasksCreated     int `json:"tasks_created"`
+	EventsAttended   int `json:"events_attended"`
+	DocumentsIndexed int `json:"documents_indexed"`
+}
+
+// ContentBlock is an MCP content block of a tool result
+type ContentBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// ReviewResult is the result of generate_weekly_review: the review as
+// Markdown text for the user and as structured content for the agent, and
+// the task it was saved as, if any
+type ReviewResult struct {
+	Content           []ContentBlock `json:"content"`
+	StructuredContent *WeeklyReview  `json:"structuredContent"`
+	Task              *client.Task   `json:"task,omitempty"`
+}
+
+// indexedDocument is a document listed by the doc agent
+type indexedDocument struct {
+	Filename   string     `json:"filename"`
+	Collection string     `json:"collection"`
+	IndexedAt  *time.Time `json:"indexed_at"`
+}
+
+// weekActivity is what happened during a week
+type weekActivity struct {
+	completed, created, open []client.Task
+	events                   []client.Event
+	documents                []indexedDocument
+}
+
+const reviewPrompt = `You are writing a weekly review for the week of %s to %s. Today is %s.
+Reply with JSON only, in the form {"summary": "...", "highlights": ["..."], "next_week_priorities": ["..."]}.
+- summary: two or three sentences on how the week went
+- highlights: up to %d notable accomplishments or events of the week
+- next_week_priorities: up to %d concrete priorities for next week, favouring open tasks that are overdue, due soon or high priority
+Only use the activity below; do not invent anything.
+
+Tasks completed:
+%s
+Tasks created:
+%s
+Events attended:
+%s
+Documents indexed:
+%s
+Open tasks:
+%s`
+
+// generateWeeklyReview reviews the week of day, Monday to Sunday in UTC,
+// from the user's tasks and events and optionally the documents indexed
+// that week. The review can be saved as a task tagged weekly-review.
+func generateWeeklyReview(ctx context.Context, userID string, day time.Time, includeDocuments, save bool) (*ReviewResult, error) {
+	now := time.Now().UTC()
+	day = day.UTC().Truncate(24 * time.Hour)
+	weekStart := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	weekEnd := weekStart.AddDate(0, 0, 7)
+
+	activity, err := gatherWeek(ctx, weekStart, weekEnd, now, includeDocuments)
+	if err != nil {
+		return nil, err
+	}
+
+	review := &WeeklyReview{
+		WeekStart: weekStart.Format("2006-01-02"),
+		WeekEnd:   weekEnd.AddDate(0, 0, -1).Format("2006-01-02"),
+		Stats: ReviewStats{
+			TasksCompleted:   len(activity.completed),
+			TasksCreated:     len(activity.created),
+			EventsAttended:   len(activity.events),
+			DocumentsIndexed: len(activity.documents),
+		},
+	}
+	if activity.empty() {
+		// The LLM would only make a week up
+		review.Summary = "Nothing was recorded this week."
+		review.Highlights, review.NextWeekPriorities = []string{}, []string{}
+	} else {
+		prompt := fmt.Sprintf(reviewPrompt, review.WeekStart, review.WeekEnd, now.Format("2006-01-02"),
+			maxReviewItems, maxReviewItems,
+			formatTasks(activity.completed), formatTasks(activity.created), formatEvents(activity.events),
+			formatDocuments(activity.documents), formatTasks(activity.open))
+		var generated struct {
+			Summary            string   `json:"summary"`
+			Highlights         []string `json:"highlights"`
+			NextWeekPriorities []string `json:"next_week_priorities"`
+		}
+		if err := generateJSON(ctx, prompt, &generated); err != nil {
+			return nil, err
+		}
+		review.Summary = strings.TrimSpace(generated.Summary)
+		review.Highlights = cleanItems(generated.Highlights)
+		review.NextWeekPriorities = cleanItems(generated.NextWeekPriorities)
+	}
+
+	text := formatReview(review)
+	result := &ReviewResult{
+		Content:           []ContentBlock{{Type: "text", Text: text}},
+		StructuredContent: review,
+	}
+	if save {
+		task, err := tasksClient.Create(ctx, client.CreateTaskRequest{
+			Title:       "Weekly review, week of " + review.WeekStart,
+			Description: text,
+			Priority:    "low",
+			Tags:        []string{reviewTag},
+		})
+		if err != nil {
+			return nil, err
+		}
+		recordAction(userID, Action{Type: actionCreateTask, Task: task})
+		result.Task = task
+	}
+	return result, nil
+}
+
+// gatherWeek collects the activity of the week from start to end, up to
+// now when the week is not over.
+func gatherWeek(ctx context.Context, start, end, now time.Time, includeDocuments bool) (*weekActivity, error) {
+	activity := &weekActivity{}
+	inWeek := func(t time.Time) bool { return !t.Before(start) && t.Before(end) }
+
+	tasks, err := tasksClient.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		// Earlier reviews are not activity
+		if hasTag(task, reviewTag) {
+			continue
+		}
+		switch {
+		case doneStatuses[task.Status]:
+			// The last update of a completed task is taken as its completion
+			if inWeek(task.UpdatedAt) {
+				activity.completed = append(activity.completed, task)
+			}
+		case task.Status != "cancelled":
+			activity.open = append(activity.open, task)
+		}
+		if inWeek(task.CreatedAt) {
+			activity.created = append(activity.created, task)
+		}
+	}
+	sortOpenTasks(activity.open)
+
+	events, err := calendarClient.Events(ctx, client.EventsQuery{
+		StartDate: start.Format(time.RFC3339),
+		EndDate:   end.Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		// Only events that have started were attended
+		if inWeek(event.Start) && event.Start.Before(now) {
+			activity.events = append(activity.events, event)
+		}
+	}
+	sort.Slice(activity.events, func(i, j int) bool { return activity.events[i].Start.Before(activity.events[j].Start) })
+
+	if includeDocuments && docAgentURL != "" {
+		documents, err := listDocumentsSince(ctx, start)
+		if err != nil {
+			log.Printf("Weekly review without documents: %v", err)
+		}
+		for _, doc := range documents {
+			if doc.IndexedAt != nil && inWeek(*doc.IndexedAt) {
+				activity.documents = append(activity.documents, doc)
+			}
+		}
+	}
+	return activity, nil
+}
+
+func (a *weekActivity) empty() bool {
+	return len(a.completed) == 0 && len(a.created) == 0 && len(a.events) == 0 && len(a.documents) == 0
+}
+
+// listDocumentsSince lists the documents the doc agent indexed from since
+// on.
+func listDocumentsSince(ctx context.Context, since time.Time) ([]indexedDocument, error) {
+	ctx, cancel := context.WithTimeout(ctx, docAgentTimeout)
+	defer cancel()
+
+	target := docAgentURL + "/docs?" + url.Values{"since": {since.Format(time.RFC3339)}}.Encode()
+	req, err := newDocAgentRequest(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("doc agent unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doc agent returned %s", resp.Status)
+	}
+
+	var body struct {
+		Documents []indexedDocument `json:"documents"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding documents: %w", err)
+	}
+	return body.Documents, nil
+}
+
+func hasTag(task client.Task, tag string) bool {
+	for _, t := range task.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// sortOpenTasks orders open tasks by due date, those without one last,
+// then by priority.
+func sortOpenTasks(tasks []client.Task) {
+	rank := map[string]int{"high": 0, "medium": 1, "low": 2}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if a.DueDate != b.DueDate {
+			if a.DueDate == "" || b.DueDate == "" {
+				return b.DueDate == ""
+			}
+			return a.DueDate < b.DueDate
+		}
+		return rank[a.Priority] < rank[b.Priority]
+	})
+}
+
+func formatTasks(tasks []client.Task) string {
+	if len(tasks) == 0 {
+		return "(none)\n"
+	}
+	var b strings.Builder
+	for i, task := range tasks {
+		if i == maxReviewEntries {
+			fmt.Fprintf(&b, "- and %d more\n", len(tasks)-i)
+			break
+		}
+		fmt.Fprintf(&b, "- %s (priority %s", task.Title, task.Priority)
+		if task.DueDate != "" {
+			fmt.Fprintf(&b, ", due %s", task.DueDate)
+		}
+		if len(task.Tags) > 0 {
+			fmt.Fprintf(&b, ", tags %s", strings.Join(task.Tags, ", "))
+		}
+		b.WriteString(")\n")
+	}
+	return b.String()
+}
+
+func formatEvents(events []client.Event) string {
+	if len(events) == 0 {
+		return "(none)\n"
+	}
+	var b strings.Builder
+	for i, event := range events {
+		if i == maxReviewEntries {
+			fmt.Fprintf(&b, "- and %d more\n", len(events)-i)
+			break
+		}
+		fmt.Fprintf(&b, "- %s %s", event.Start.Format("Mon 2006-01-02 15:04"), event.Summary)
+		if event.Location != "" {
+			fmt.Fprintf(&b, " at %s", event.Location)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func formatDocuments(documents []indexedDocument) string {
+	if len(documents) == 0 {
+		return "(none)\n"
+	}
+	var b strings.Builder
+	for i, doc := range documents {
+		if i == maxReviewEntries {
+			fmt.Fprintf(&b, "- and %d more\n", len(documents)-i)
+			break
+		}
+		fmt.Fprintf(&b, "- %s (%s)\n", doc.Filename, doc.Collection)
+	}
+	return b.String()
+}
+
+// cleanItems drops the empty items the LLM returned and keeps at most
+// maxReviewItems.
+func cleanItems(items []string) []string {
+	cleaned := []string{}
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" && len(cleaned) < maxReviewItems {
+			cleaned = append(cleaned, item)
+		}
+	}
+	return cleaned
+}
+
+// formatReview renders a review as Markdown.
+func formatReview(review *WeeklyReview) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Weekly review: %s to %s\n\n%s\n", review.WeekStart, review.WeekEnd, review.Summary)
+	if len(review.Highlights) > 0 {
+		b.WriteString("\n## Highlights\n")
+		for _, item := range review.Highlights {
+			fmt.Fprintf(&b, "- %s\n", item)
+		}
+	}
+	if len(review.NextWeekPriorities) > 0 {
+		b.WriteString("\n## Priorities for next week\n")
+		for _, item := range review.NextWeekPriorities {
+			fmt.Fprintf(&b, "- %s\n", item)
+		}
+	}
+	s := review.Stats
+	fmt.Fprintf(&b, "\nTasks completed: %d, tasks created: %d, events attended: %d, documents indexed: %d\n",
+		s.TasksCompleted, s.TasksCreated, s.EventsAttended, s.DocumentsIndexed)
+	return b.String()
+}
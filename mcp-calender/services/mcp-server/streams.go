@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Streams: a client that GETs /mcp accepting text/event-stream keeps an
+// SSE stream open, on which the server sends it notifications as they
+// happen, each as one "message" event holding the JSON-RPC notification.
+// notifications/tools/list_changed is sent whenever tools/list would
+// answer differently, e.g. when a backend goes down or comes back and
+// its tools become degraded or are restored, so clients list the tools
+// again rather than polling. A notification a client is too slow to take
+// is dropped for it; list_changed only asks it to list again, and one
+// still queued does that.
+
+// streamKeepAlive is how often an idle stream gets a comment, so that
+// proxies do not close it
+const streamKeepAlive = 25 * time.Second
+
+// streamBuffer is how many notifications a stream holds while its client
+// catches up
+const streamBuffer = 16
+
+// MCPNotification is a JSON-RPC notification sent to the clients.
+type MCPNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// streams are the open SSE streams, each with the notifications it has yet
+// to send
+var streams = struct {
+	sync.Mutex
+	open   map[chan MCPNotification]bool
+	closed bool
+}{open: map[chan MCPNotification]bool{}}
+
+var streamsOpen = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "mcp_streams_open",
+		Help: "Number of open SSE streams of MCP notifications",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(streamsOpen)
+}
+
+// notifyClients sends a notification to every open stream.
+func notifyClients(method string, params interface{}) {
+	notification := MCPNotification{JSONRPC: "2.0", Method: method, Params: params}
+	streams.Lock()
+	defer streams.Unlock()
+	for ch := range streams.open {
+		select {
+		case ch <- notification:
+		default:
+			log.Printf("Warning: Dropped %s for a slow stream", method)
+		}
+	}
+}
+
+// subscribe opens a stream; ok is false once the server is shutting down.
+func subscribe() (ch chan MCPNotification, ok bool) {
+	streams.Lock()
+	defer streams.Unlock()
+	if streams.closed {
+		return nil, false
+	}
+	ch = make(chan MCPNotification, streamBuffer)
+	streams.open[ch] = true
+	streamsOpen.Inc()
+	return ch, true
+}
+
+// unsubscribe closes a stream opened by subscribe, unless closeStreams
+// already has.
+func unsubscribe(ch chan MCPNotification) {
+	streams.Lock()
+	defer streams.Unlock()
+	if streams.open[ch] {
+		delete(streams.open, ch)
+		close(ch)
+		streamsOpen.Dec()
+	}
+}
+
+// closeStreams ends every stream, for the server to shut down without
+// waiting for clients to go away.
+func closeStreams() {
+	streams.Lock()
+	defer streams.Unlock()
+	streams.closed = true
+	for ch := range streams.open {
+		delete(streams.open, ch)
+		close(ch)
+		streamsOpen.Dec()
+	}
+}
+
+// handleMCPStream serves GET /mcp: the SSE stream of notifications, until
+// the client goes away or the server shuts down.
+func handleMCPStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") || !ok {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "GET /mcp serves an SSE stream: accept text/event-stream", http.StatusMethodNotAllowed)
+		return
+	}
+	ch, ok := subscribe()
+	if !ok {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case notification, open := <-ch:
+			if !open {
+				return
+			}
+			data, err := json.Marshal(notification)
+			if err != nil {
+				log.Printf("Failed to encode %s: %v", notification.Method, err)
+				continue
+			}
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Response formats: the result of a tool call is the JSON of the backend
+// by default. Clients that only pass results on to an LLM may ask for
+// "text" instead, per call with the format parameter of tools/call or for
+// every call with MCP_RESPONSE_FORMAT, and get a single text content block
+// rendered by the tool's template: tasks as a Markdown table, events as a
+// bulleted agenda. That takes a fraction of the tokens of the JSON.
+const (
+	formatJSON = "json"
+	formatText = "text"
+)
+
+// defaultFormat is the format of calls that do not ask for one, set by
+// MCP_RESPONSE_FORMAT
+var defaultFormat = formatJSON
+
+// responseTemplates render the results of tools in the text format. Each
+// sees the result as decoded from its JSON, so fields have their JSON
+// names. Tools without a template keep their text content, if they have
+// any, or else their JSON.
+var responseTemplates = map[string]*template.Template{
+	"get_tasks": newResponseTemplate("get_tasks",
+		`{{with .tasks}}| ID | Title | Priority | Status | Due | Tags |
+|---|---|---|---|---|---|
+{{range .}}| {{.id}} | {{cell .title}} | {{.priority}} | {{.status}} | {{or .due_date "-"}} | {{join .tags}} |
+{{end}}{{with $.next_cursor}}
+More tasks follow; call again with cursor {{.}}{{end}}{{else}}No tasks.{{end}}`),
+	"add_task": newResponseTemplate("add_task",
+		`Created task #{{.id}}: {{.title}} ({{.priority}}{{with .due_date}}, due {{.}}{{end}})`),
+	"delete_task": newResponseTemplate("delete_task",
+		`{{with .deleted}}Deleted task #{{.id}}: {{.title}}{{end}}`),
+	"cleanup_completed_tasks": newResponseTemplate("cleanup_completed_tasks",
+		`{{if .dry_run}}{{.matched}} completed tasks finished before {{.completed_before}} would be deleted; call again with dry_run false to delete them{{else}}Deleted {{.deleted}} completed tasks finished before {{.completed_before}}{{end}}`),
+	"get_calendar_events": newResponseTemplate("get_calendar_events",
+		`{{range .events}}- {{day .start}} {{clock .start}}–{{clock .end}} {{.summary}}{{with .location}} @ {{.}}{{end}} (id {{.id}})
+{{else}}No events.{{end}}`),
+	"create_event": newResponseTemplate("create_event",
+		`Created event {{.summary}} on {{day .start}} {{clock .start}}–{{clock .end}} (id {{.id}}){{with .travel}}{{with .buffer}}
+Added {{.summary}} {{clock .start}}–{{clock .end}} (id {{.id}}){{end}}{{with .warning}}
+Warning: {{.}}{{end}}{{end}}`),
+	"delete_event": newResponseTemplate("delete_event",
+		`{{with .deleted}}Deleted event {{.summary}} on {{day .start}} {{clock .start}}{{end}}`),
+	"get_agenda": newResponseTemplate("get_agenda",
+		`Agenda for {{.date}}:
+{{range .events}}- {{clock .start}}–{{clock .end}} {{.summary}}{{with .location}} @ {{.}}{{end}}{{with .weather}}: {{.description}}, {{printf "%.0f" .temperature_min}}–{{printf "%.0f" .temperature_max}}°{{with .advice}}. {{.}}{{end}}{{end}}
+{{else}}No events.{{end}}`),
+	"create_meeting_followups": newResponseTemplate("create_meeting_followups",
+		`{{range .created}}Created task #{{.id}}: {{.title}}{{with .due_date}} (due {{.}}){{end}}
+{{else}}No new follow-ups.
+{{end}}{{with .existing}}{{.}} meetings already had their follow-up task{{end}}`),
+	"get_weather": newResponseTemplate("get_weather",
+		`{{if eq (or .status "") "ambiguous"}}`+ambiguousCityTemplate+`{{else}}{{.city}}{{with .country}}, {{.}}{{end}}: {{.description}}, {{printf "%.1f" .temperature}}{{.temperature_unit}}, humidity {{.humidity}}%, wind {{.wind_speed}} {{.wind_speed_unit}}{{end}}`),
+	"will_it_rain_soon": newResponseTemplate("will_it_rain_soon",
+		`{{if eq (or .status "") "ambiguous"}}`+ambiguousCityTemplate+`{{else}}{{.city}}{{with .country}}, {{.}}{{end}}: {{if .raining_now}}raining now{{with .rain_stops_in}}, stopping in about {{.}} min{{else}} for the next {{.window_minutes}} min{{end}}{{else if .will_rain}}rain expected in about {{.rain_starts_in}} min{{else}}no rain expected in the next {{.window_minutes}} min{{end}}{{if .will_rain}} (up to {{printf "%.2g" .peak_precipitation}} {{.precipitation_unit}}){{end}}{{end}}`),
+	"sync_github_issues": newResponseTemplate("sync_github_issues",
+		`Synced GitHub issues: {{.created}} created, {{.updated}} updated, {{.unchanged}} unchanged
+{{range .repos}}- {{.repo}}: {{if .error}}failed: {{.error}}{{else}}{{.issues}} issues{{end}}
+{{end}}`),
+	"remember": newResponseTemplate("remember", factsTemplate),
+	"recall":   newResponseTemplate("recall", factsTemplate),
+}
+
+const ambiguousCityTemplate = `{{.message}}
+{{range .candidates}}- {{.name}}{{with .state}}, {{.}}{{end}}, {{.country}}: location_id {{.location_id}}
+{{end}}`
+
+const factsTemplate = `{{range $key, $value := .facts}}- {{$key}}: {{$value}}
+{{else}}No facts remembered.{{end}}`
+
+// responseFuncs are the functions response templates may use besides the
+// builtins
+var responseFuncs = template.FuncMap{
+	// cell escapes a string for a Markdown table cell
+	"cell": func(v interface{}) string {
+		s := strings.ReplaceAll(fmt.Sprint(v), "|", `\|`)
+		return strings.Join(strings.Fields(s), " ")
+	},
+	// join lists the strings of a JSON array, "-" when there are none
+	"join": func(v interface{}) string {
+		items, _ := v.([]interface{})
+		if len(items) == 0 {
+			return "-"
+		}
+		s := make([]string, len(items))
+		for i, item := range items {
+			s[i] = fmt.Sprint(item)
+		}
+		return strings.Join(s, ", ")
+	},
+	// day and clock format an RFC3339 time in its own offset
+	"day":   func(v interface{}) string { return formatTimeValue(v, "Mon Jan 2") },
+	"clock": func(v interface{}) string { return formatTimeValue(v, "15:04") },
+}
+
+func newResponseTemplate(name, text string) *template.Template {
+	return template.Must(template.New(name).Funcs(responseFuncs).Option("missingkey=zero").Parse(text))
+}
+
+// initResponseFormat reads MCP_RESPONSE_FORMAT and the templates in
+// MCP_RESPONSE_TEMPLATES_DIR, named after their tool such as
+// get_tasks.tmpl, which replace or add to the built-in ones.
+func initResponseFormat() {
+	if format := os.Getenv("MCP_RESPONSE_FORMAT"); format != "" {
+		if format != formatJSON && format != formatText {
+			log.Fatalf("Invalid MCP_RESPONSE_FORMAT %q: expected json or text", format)
+		}
+		defaultFormat = format
+	}
+
+	dir := os.Getenv("MCP_RESPONSE_TEMPLATES_DIR")
+	if dir == "" {
+		return
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		log.Fatalf("Invalid MCP_RESPONSE_TEMPLATES_DIR %q: %v", dir, err)
+	}
+	for _, path := range paths {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read response template: %v", err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+		tmpl, err := template.New(name).Funcs(responseFuncs).Option("missingkey=zero").Parse(string(text))
+		if err != nil {
+			log.Fatalf("Invalid response template %s: %v", path, err)
+		}
+		responseTemplates[name] = tmpl
+	}
+	log.Printf("Loaded %d response templates from %s", len(paths), dir)
+}
+
+// responseFormat returns the format a tools/call asks for.
+func responseFormat(params map[string]interface{}) (string, bool) {
+	format, _ := params["format"].(string)
+	switch format {
+	case "":
+		return defaultFormat, true
+	case formatJSON, formatText:
+		return format, true
+	}
+	return "", false
+}
+
+// transformResult returns the result of a tool call in format. A result
+// whose template fails is returned as JSON rather than not at all.
+func transformResult(toolName, format string, result interface{}) interface{} {
+	if format != formatText {
+		return result
+	}
+
+	var data interface{}
+	raw, err := json.Marshal(result)
+	if err == nil {
+		err = json.Unmarshal(raw, &data)
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to transform the result of %s: %v", toolName, err)
+		return result
+	}
+
+	tmpl, ok := responseTemplates[toolName]
+	if !ok {
+		// Results such as that of plan_my_day have text content already
+		if fields, ok := data.(map[string]interface{}); ok && fields["content"] != nil {
+			return map[string]interface{}{"content": fields["content"]}
+		}
+		return textResult(string(raw))
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		log.Printf("Warning: Failed to render the result of %s: %v", toolName, err)
+		return result
+	}
+	return textResult(strings.TrimSpace(b.String()))
+}
+
+func textResult(text string) map[string]interface{} {
+	return map[string]interface{}{"content": []ContentBlock{{Type: "text", Text: text}}}
+}
+
+// formatTimeValue formats an RFC3339 time of a JSON result with layout,
+// returning anything else as it is.
+func formatTimeValue(v interface{}, layout string) string {
+	s := fmt.Sprint(v)
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return s
+	}
+	return t.Format(layout)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Argument validation: the arguments of a tool call are checked against
+// the tool's inputSchema before the call reaches a backend, and a call
+// whose arguments do not match fails with -32602 Invalid params, its data
+// listing each field that is wrong and why. The JSON Schema keywords the
+// tools use are checked, in nested objects and array items too: type,
+// enum, properties and required. An optional argument given as null is
+// taken as left out, as clients often send them so. Required arguments a
+// call leaves out are asked for by elicitation first, so they are only
+// reported inside objects.
+
+// FieldError is an argument that does not match the schema of its tool.
+type FieldError struct {
+	// Field is the path of the argument, such as attachments[0].file_id
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// invalidParams is the error of a call whose arguments are invalid.
+func invalidParams(errs []FieldError) *MCPError {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Field + " " + e.Message
+	}
+	return &MCPError{
+		Code:    -32602,
+		Message: "Invalid params: " + strings.Join(messages, "; "),
+		Data:    map[string]interface{}{"errors": errs},
+	}
+}
+
+// validateArguments returns how arguments do not match the schema of the
+// tool called toolName, in the order of their fields.
+func validateArguments(toolName string, arguments map[string]interface{}) []FieldError {
+	tool, ok := findTool(toolName)
+	if !ok {
+		return nil
+	}
+	if arguments == nil {
+		arguments = map[string]interface{}{}
+	}
+	var errs []FieldError
+	validateObject("", tool.InputSchema, arguments, &errs)
+	return errs
+}
+
+// validateValue checks value, at path field, against schema.
+func validateValue(field string, schema map[string]interface{}, value interface{}, errs *[]FieldError) {
+	if types := schemaStrings(schema["type"]); len(types) > 0 && !hasType(value, types) {
+		*errs = append(*errs, FieldError{Field: field, Message: "must be " + strings.Join(withArticles(types), " or ")})
+		return
+	}
+	if enum, ok := schema["enum"]; ok && !inEnum(value, enum) {
+		*errs = append(*errs, FieldError{Field: field, Message: "must be one of " + formatEnum(enum)})
+		return
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		validateObject(field, schema, v, errs)
+	case []interface{}:
+		items, _ := schema["items"].(map[string]interface{})
+		if items == nil {
+			return
+		}
+		for i, item := range v {
+			validateValue(fmt.Sprintf("%s[%d]", field, i), items, item, errs)
+		}
+	}
+}
+
+// validateObject checks the properties of object, at path field, against
+// those of schema.
+func validateObject(field string, schema map[string]interface{}, object map[string]interface{}, errs *[]FieldError) {
+	properties, _ := schema["properties"].(map[string]interface{})
+	required := schemaStrings(schema["required"])
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := object[name]
+		property, ok := properties[name].(map[string]interface{})
+		if !ok || (value == nil && !contains(required, name)) {
+			continue
+		}
+		validateValue(joinField(field, name), property, value, errs)
+	}
+	for _, name := range required {
+		if _, ok := object[name]; !ok {
+			*errs = append(*errs, FieldError{Field: joinField(field, name), Message: "is required"})
+		}
+	}
+}
+
+// joinField returns the path of the property name of the object at field.
+func joinField(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+// schemaStrings returns a keyword of a schema listing strings, such as
+// type and required, which may be a single string or a list of them as
+// the tools of the registry give them.
+func schemaStrings(keyword interface{}) []string {
+	switch v := keyword.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		var out []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// hasType reports whether value, as decoded from JSON, is of one of the
+// JSON Schema types.
+func hasType(value interface{}, types []string) bool {
+	for _, t := range types {
+		switch v := value.(type) {
+		case string:
+			if t == "string" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case float64:
+			if t == "number" || (t == "integer" && v == math.Trunc(v)) {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case nil:
+			if t == "null" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withArticles returns the types as they read in a sentence, such as "an
+// integer".
+func withArticles(types []string) []string {
+	out := make([]string, len(types))
+	for i, t := range types {
+		switch t {
+		case "integer", "object", "array":
+			out[i] = "an " + t
+		case "null":
+			out[i] = t
+		default:
+			out[i] = "a " + t
+		}
+	}
+	return out
+}
+
+// enumValues returns the values of an enum, which the tools of the
+// registry give as a []interface{}.
+func enumValues(enum interface{}) []interface{} {
+	values, _ := enum.([]interface{})
+	return values
+}
+
+// inEnum reports whether value is one of the values of enum. Numbers
+// compare by value, whatever their Go type.
+func inEnum(value interface{}, enum interface{}) bool {
+	for _, allowed := range enumValues(enum) {
+		if a, ok := toFloat(allowed); ok {
+			if v, ok := toFloat(value); ok && a == v {
+				return true
+			}
+			continue
+		}
+		if fmt.Sprint(allowed) == fmt.Sprint(value) && fmt.Sprintf("%T", allowed) == fmt.Sprintf("%T", value) {
+			return true
+		}
+	}
+	return false
+}
+
+// toFloat returns a number of any Go type as a float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// formatEnum lists the values of an enum.
+func formatEnum(enum interface{}) string {
+	values := enumValues(enum)
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = fmt.Sprint(v)
+	}
+	return strings.Join(out, ", ")
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Channel delivers a rendered notification to one address, such as an
+// email address, a Slack webhook URL or an ntfy topic.
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, address string, msg Message) error
+}
+
+// Message is a notification rendered from its template.
+type Message struct {
+	Subject string
+	Body    string
+	// Priority is "low", "normal" or "high".
+	Priority string
+}
+
+// channels are the configured delivery channels by name
+var channels = map[string]Channel{}
+
+// registerChannels sets up every channel whose configuration is present.
+// Slack and ntfy need no service-wide configuration; email needs SMTP_HOST.
+func registerChannels() {
+	if host := getEnv("SMTP_HOST", ""); host != "" {
+		channels["email"] = &EmailChannel{
+			addr:     host + ":" + getEnv("SMTP_PORT", "587"),
+			host:     host,
+			username: getEnv("SMTP_USERNAME", ""),
+			password: getEnv("SMTP_PASSWORD", ""),
+			from:     getEnv("SMTP_FROM", "notifications@localhost"),
+		}
+	} else {
+		log.Println("Warning: SMTP_HOST not configured, email notifications disabled")
+	}
+	channels["slack"] = &SlackChannel{client: &http.Client{
+		Timeout: 10 * time.Second,
+		// A redirect could lead the post anywhere
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}}
+	channels["ntfy"] = &NtfyChannel{
+		baseURL: strings.TrimSuffix(getEnv("NTFY_URL", "https://ntfy.sh"), "/"),
+		token:   getEnv("NTFY_TOKEN", ""),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// EmailChannel sends plain-text email over SMTP.
+type EmailChannel struct {
+	addr, host         string
+	username, password string
+	from               string
+}
+
+func (c *EmailChannel) Name() string { return "email" }
+
+func (c *EmailChannel) Send(ctx context.Context, address string, msg Message) error {
+	if strings.ContainsAny(address, "\r\n") {
+		return fmt.Errorf("invalid email address %q", address)
+	}
+	// Keep a templated subject from injecting headers
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(msg.Subject)
+
+	var auth smtp.Auth
+	if c.username != "" {
+		auth = smtp.PlainAuth("", c.username, c.password, c.host)
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", c.from, address, subject)
+	if msg.Priority == "high" {
+		body.WriteString("X-Priority: 1\r\n")
+	}
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	body.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	// net/smtp has no context support; run it aside so a hung server
+	// does not outlive the delivery timeout
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(c.addr, auth, c.from, []string{address}, []byte(body.String()))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SlackChannel posts to a Slack incoming webhook; the address is the
+// webhook URL.
+type SlackChannel struct {
+	client *http.Client
+}
+
+// slackWebhookHost is the only host Slack addresses may point at, so that
+// a preference cannot make the service post to internal endpoints.
+const slackWebhookHost = "hooks.slack.com"
+
+// checkSlackWebhook reports whether address is a Slack incoming webhook
+// URL, https://hooks.slack.com/...
+func checkSlackWebhook(address string) error {
+	u, err := url.Parse(address)
+	if err != nil || u.Scheme != "https" || u.Host != slackWebhookHost || u.User != nil || !strings.HasPrefix(u.Path, "/") {
+		return fmt.Errorf("slack address must be a webhook URL starting with https://%s/", slackWebhookHost)
+	}
+	return nil
+}
+
+func (c *SlackChannel) Name() string { return "slack" }
+
+func (c *SlackChannel) Send(ctx context.Context, address string, msg Message) error {
+	if err := checkSlackWebhook(address); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(map[string]string{"text": "*" + msg.Subject + "*\n" + msg.Body})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, address, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doDelivery(c.client, req)
+}
+
+// NtfyChannel publishes push notifications to an ntfy server; the address
+// is the topic.
+type NtfyChannel struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func (c *NtfyChannel) Name() string { return "ntfy" }
+
+func (c *NtfyChannel) Send(ctx context.Context, address string, msg Message) error {
+	if address == "" || strings.ContainsAny(address, "/?#") {
+		return fmt.Errorf("invalid ntfy topic %q", address)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/"+address, strings.NewReader(msg.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", msg.Subject)
+	switch msg.Priority {
+	case "high":
+		req.Header.Set("Priority", "high")
+	case "low":
+		req.Header.Set("Priority", "low")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return doDelivery(c.client, req)
+}
+
+// doDelivery performs a webhook request and fails on any non-2xx status.
+func doDelivery(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("delivery failed with status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/client"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/database"
+)
+
+// RuleRun is one firing of a rule
+type RuleRun struct {
+	ID     int `json:"id"`
+	RuleID int `json:"rule_id"`
+	// Trigger identifies what fired the rule, e.g. "task_overdue:12:2024-01-15"
+	Trigger string `json:"trigger"`
+	// Status is running, success or failed
+	Status     string         `json:"status"`
+	Results    []ActionResult `json:"results"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+}
+
+// ActionResult is the outcome of one action of a run
+type ActionResult struct {
+	Type   string      `json:"type"`
+	Status string      `json:"status"`
+	Output interface{} `json:"output,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// Run statuses
+const (
+	runRunning = "running"
+	runSuccess = "success"
+	runFailed  = "failed"
+)
+
+// Clients of the services rules act on, on behalf of each rule's owner
+var (
+	tasksClient         = client.NewTasksClient(getEnv("TASK_SERVICE_URL", "http://task-service:8081"))
+	weatherClient       = client.NewWeatherClient(getEnv("WEATHER_SERVICE_URL", "http://weather-service:8083"))
+	notificationsClient = client.NewNotificationsClient(getEnv("NOTIFICATION_SERVICE_URL", "http://notification-service:8084"))
+	mcpServerURL        = getEnv("MCP_SERVER_URL", "http://mcp-server:8080")
+)
+
+// jwtSecret signs the short-lived tokens rules act with
+var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+
+// Scheduler settings. Overdue tasks and the weather are checked every
+// pollInterval; cron schedules are checked every minute.
+var pollInterval = parseDurationEnv("RULES_POLL_INTERVAL", 5*time.Minute)
+
+const (
+	// runTimeout bounds the actions of one run
+	runTimeout = time.Minute
+	// userTokenTTL bounds the tokens a run acts with
+	userTokenTTL = 5 * time.Minute
+	// runRetention is how long run history is kept; an overdue task still
+	// overdue after that fires its rules again
+	runRetention = 30 * 24 * time.Hour
+)
+
+// closedStatuses are the task statuses that are never overdue
+var closedStatuses = map[string]bool{"completed": true, "done": true, "cancelled": true}
+
+// runScheduler checks the enabled rules at the start of every minute
+// until ctx is done.
+func runScheduler(ctx context.Context) {
+	lastPolled := map[int]time.Time{}
+	for {
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		checkRules(ctx, next.UTC(), lastPolled)
+	}
+}
+
+// checkRules fires the rules whose trigger holds at now, a whole minute.
+// Every firing is claimed in the database first, so no trigger fires a
+// rule twice even with several replicas running.
+func checkRules(ctx context.Context, now time.Time, lastPolled map[int]time.Time) {
+	rules, err := queryRules(ctx, "WHERE enabled AND trigger_type IN ($1, $2, $3)",
+		triggerSchedule, triggerTaskOverdue, triggerWeatherAlert)
+	if err != nil {
+		log.Printf("Failed to load rules: %v", err)
+		return
+	}
+
+	for _, rule := range rules {
+		if ctx.Err() != nil {
+			return
+		}
+		switch rule.Trigger.Type {
+		case triggerSchedule:
+			schedule, err := parseCron(rule.Trigger.Schedule)
+			if err != nil || !schedule.matches(now) {
+				continue
+			}
+			fire(ctx, rule, "schedule:"+now.Format(time.RFC3339), triggerData(rule, now, "", nil))
+		case triggerTaskOverdue, triggerWeatherAlert:
+			if now.Sub(lastPolled[rule.ID]) < pollInterval {
+				continue
+			}
+			lastPolled[rule.ID] = now
+			if err := pollRule(ctx, rule, now); err != nil {
+				triggerChecksTotal.WithLabelValues(rule.Trigger.Type, "error").Inc()
+				log.Printf("Failed to check rule %d: %v", rule.ID, err)
+				continue
+			}
+			triggerChecksTotal.WithLabelValues(rule.Trigger.Type, "success").Inc()
+		}
+	}
+
+	if now.Minute() == 0 {
+		pruneRuns(ctx, now)
+	}
+}
+
+// pollRule fires a task_overdue rule for each matching overdue task, or a
+// weather_alert rule when the weather meets one of its conditions.
+func pollRule(ctx context.Context, rule Rule, now time.Time) error {
+	userCtx, err := userContext(ctx, rule.UserID)
+	if err != nil {
+		return err
+	}
+	today := now.Format("2006-01-02")
+
+	switch rule.Trigger.Type {
+	case triggerTaskOverdue:
+		tasks, err := tasksClient.List(userCtx)
+		if err != nil {
+			return err
+		}
+		for _, task := range tasks {
+			if task.DueDate == "" || task.DueDate >= today || closedStatuses[strings.ToLower(task.Status)] {
+				continue
+			}
+			if !matches(rule.Trigger.Match, append([]string{task.Title}, task.Tags...)...) {
+				continue
+			}
+			key := fmt.Sprintf("task_overdue:%d:%s", task.ID, task.DueDate)
+			fire(ctx, rule, key, triggerData(rule, now, "task", task))
+		}
+	case triggerWeatherAlert:
+		weather, err := weatherClient.Current(userCtx, rule.Trigger.City)
+		if err != nil {
+			return err
+		}
+		conditions := rule.Trigger.Conditions
+		if len(conditions) == 0 {
+			conditions = defaultConditions
+		}
+		// The condition matches whatever the provider's description says,
+		// e.g. "thunder" matches a thunderstorm described as heavy rain
+		description := strings.ToLower(weather.Description + " " + weather.Condition)
+		for _, condition := range conditions {
+			if strings.Contains(description, strings.ToLower(condition)) {
+				key := "weather_alert:" + strings.ToLower(weather.City) + ":" + today
+				fire(ctx, rule, key, triggerData(rule, now, "weather", weather))
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether one of texts contains match, ignoring case; an
+// empty match matches everything.
+func matches(match string, texts ...string) bool {
+	if match == "" {
+		return true
+	}
+	match = strings.ToLower(match)
+	for _, text := range texts {
+		if strings.Contains(strings.ToLower(text), match) {
+			return true
+		}
+	}
+	return false
+}
+
+// triggerData is what the templates of a rule's actions can refer to:
+// .rule, .time and .date, and the task, event or weather that fired it
+// under name.
+func triggerData(rule Rule, now time.Time, name string, object interface{}) map[string]interface{} {
+	data := map[string]interface{}{
+		"rule": rule.Name,
+		"time": now.Format(time.RFC3339),
+		"date": now.Format("2006-01-02"),
+	}
+	if name != "" {
+		// Templates use the JSON field names, e.g. {{.task.due_date}}
+		var fields map[string]interface{}
+		if raw, err := json.Marshal(object); err == nil && json.Unmarshal(raw, &fields) == nil {
+			data[name] = fields
+		}
+	}
+	return data
+}
+
+// fire runs the actions of rule unless the trigger identified by key has
+// already fired it, and returns the run, or nil when it had.
+func fire(ctx context.Context, rule Rule, key string, data map[string]interface{}) *RuleRun {
+	run, err := claimRun(ctx, rule.ID, key)
+	if err != nil {
+		log.Printf("Failed to start run of rule %d: %v", rule.ID, err)
+		return nil
+	}
+	if run == nil {
+		return nil
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, runTimeout)
+	defer cancel()
+
+	run.Status = runSuccess
+	run.Results = executeActions(runCtx, rule, data)
+	for _, result := range run.Results {
+		if result.Status != runSuccess {
+			run.Status = runFailed
+		}
+	}
+
+	// Record the outcome even if the scheduler is stopping
+	recordCtx, cancel := database.WithTimeout(context.Background())
+	defer cancel()
+	if err := finishRun(recordCtx, run); err != nil {
+		log.Printf("Failed to record run %d of rule %d: %v", run.ID, rule.ID, err)
+	}
+	ruleRunsTotal.WithLabelValues(rule.Trigger.Type, run.Status).Inc()
+	return run
+}
+
+// executeActions runs the actions of rule in order as its owner. A failed
+// action does not stop the ones after it.
+func executeActions(ctx context.Context, rule Rule, data map[string]interface{}) []ActionResult {
+	results := make([]ActionResult, 0, len(rule.Actions))
+	userCtx, tokenErr := userContext(ctx, rule.UserID)
+	for _, action := range rule.Actions {
+		result := ActionResult{Type: action.Type, Status: runSuccess}
+		var output interface{}
+		err := tokenErr
+		if err == nil {
+			output, err = executeAction(userCtx, rule, action, data)
+		}
+		if err != nil {
+			result.Status, result.Error = runFailed, err.Error()
+		} else {
+			result.Output = output
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func executeAction(ctx context.Context, rule Rule, action Action, data map[string]interface{}) (interface{}, error) {
+	switch action.Type {
+	case actionCreateTask:
+		title, err := render(action.Title, data)
+		if err != nil {
+			return nil, err
+		}
+		description, err := render(action.Description, data)
+		if err != nil {
+			return nil, err
+		}
+		req := client.CreateTaskRequest{
+			Title:       title,
+			Description: description,
+			Priority:    action.Priority,
+			Tags:        action.Tags,
+		}
+		if action.DueInDays != nil {
+			req.DueDate = time.Now().UTC().AddDate(0, 0, *action.DueInDays).Format("2006-01-02")
+		}
+		return tasksClient.Create(ctx, req)
+	case actionSendNotification:
+		subject := rule.Name
+		if action.Subject != "" {
+			var err error
+			if subject, err = render(action.Subject, data); err != nil {
+				return nil, err
+			}
+		}
+		message, err := render(action.Message, data)
+		if err != nil {
+			return nil, err
+		}
+		// ctx carries the token of the rule's owner, who is notified
+		return notificationsClient.Send(ctx, client.SendNotificationRequest{
+			Type:     "custom",
+			Subject:  subject,
+			Message:  message,
+			Priority: action.Priority,
+			Channels: action.Channels,
+		})
+	case actionCallTool:
+		arguments := map[string]interface{}{}
+		for name, value := range action.Arguments {
+			if text, ok := value.(string); ok {
+				rendered, err := render(text, data)
+				if err != nil {
+					return nil, err
+				}
+				value = rendered
+			}
+			arguments[name] = value
+		}
+		return callTool(ctx, action.Tool, arguments)
+	default:
+		return nil, fmt.Errorf("unknown action type %q", action.Type)
+	}
+}
+
+// callTool calls a tool of the MCP server with the token in ctx.
+func callTool(ctx context.Context, tool string, arguments map[string]interface{}) (interface{}, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "rules-service",
+		"method":  "tools/call",
+		"params":  map[string]interface{}{"name": tool, "arguments": arguments},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, mcpServerURL+"/mcp", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token, _ := ctx.Value(tokenKey{}).(string); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("MCP server returned status: %s", resp.Status)
+	}
+
+	var response struct {
+		Result interface{} `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("%s failed: %s (%d)", tool, response.Error.Message, response.Error.Code)
+	}
+	return response.Result, nil
+}
+
+type tokenKey struct{}
+
+// userContext returns ctx carrying a short-lived token of userID, which
+// the other services accept like one from the auth service. Without
+// JWT_SECRET only rules of the default user can run.
+func userContext(ctx context.Context, userID string) (context.Context, error) {
+	if len(jwtSecret) == 0 {
+		if userID != auth.DefaultUser {
+			return nil, fmt.Errorf("JWT_SECRET is required to run rules of user %s", userID)
+		}
+		return ctx, nil
+	}
+	now := time.Now()
+	token, err := auth.Sign(auth.Claims{
+		Subject:   userID,
+		Issuer:    auth.Issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(userTokenTTL).Unix(),
+	}, jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, tokenKey{}, token)
+	return client.WithToken(ctx, token), nil
+}
+
+// claimRun records the start of a run of a rule for the trigger key, or
+// returns nil when that trigger already fired the rule.
+func claimRun(ctx context.Context, ruleID int, key string) (*RuleRun, error) {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	run := &RuleRun{RuleID: ruleID, Trigger: key, Status: runRunning, Results: []ActionResult{}}
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO rule_runs (rule_id, trigger_key, status)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (rule_id, trigger_key) DO NOTHING
+		RETURNING id, started_at
+	`, ruleID, key, runRunning).Scan(&run.ID, &run.StartedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func finishRun(ctx context.Context, run *RuleRun) error {
+	results, err := json.Marshal(run.Results)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	run.FinishedAt = &now
+	_, err = db.ExecContext(ctx, `
+		UPDATE rule_runs SET status = $1, results = $2, finished_at = $3 WHERE id = $4
+	`, run.Status, results, now, run.ID)
+	return err
+}
+
+// pruneRuns deletes the run history older than runRetention.
+func pruneRuns(ctx context.Context, now time.Time) {
+	ctx, cancel := database.WithTimeout(ctx)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM rule_runs WHERE started_at < $1", now.Add(-runRetention)); err != nil {
+		log.Printf("Failed to prune rule runs: %v", err)
+	}
+}
+
+func parseDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Warning: invalid %s %q, using %s", key, value, defaultValue)
+	}
+	return defaultValue
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Trigger types
+const (
+	triggerSchedule     = "schedule"
+	triggerTaskOverdue  = "task_overdue"
+	triggerWeatherAlert = "weather_alert"
+	triggerEventCreated = "event_created"
+)
+
+// Action types
+const (
+	actionCreateTask       = "create_task"
+	actionSendNotification = "send_notification"
+	actionCallTool         = "call_tool"
+)
+
+// defaultConditions raise a weather alert when the description or the
+// condition of the current weather mentions one of them
+var defaultConditions = []string{"rain", "snow", "storm", "thunder"}
+
+// Rule runs its actions each time its trigger fires
+type Rule struct {
+	ID        int       `json:"id"`
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name"`
+	Enabled   bool      `json:"enabled"`
+	Trigger   Trigger   `json:"trigger"`
+	Actions   []Action  `json:"actions"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Trigger is when a rule fires
+type Trigger struct {
+	// Type is schedule, task_overdue, weather_alert or event_created
+	Type string `json:"type" yaml:"type"`
+	// Schedule is a cron expression in UTC, "minute hour day month weekday"
+	Schedule string `json:"schedule,omitempty" yaml:"schedule"`
+	// City is the city a weather alert watches, the user's home city by
+	// default
+	City string `json:"city,omitempty" yaml:"city"`
+	// Conditions are the words of a weather description or condition
+	// that raise an alert, defaultConditions when empty
+	Conditions []string `json:"conditions,omitempty" yaml:"conditions"`
+	// Match restricts overdue tasks and created events to those whose
+	// title, summary, location or tags contain it
+	Match string `json:"match,omitempty" yaml:"match"`
+}
+
+// Action is what a rule does when it fires. Its strings are templates
+// over the data of the trigger, e.g. "{{.task.title}} is overdue".
+type Action struct {
+	// Type is create_task, send_notification or call_tool
+	Type string `json:"type" yaml:"type"`
+
+	// create_task
+	Title       string   `json:"title,omitempty" yaml:"title"`
+	Description string   `json:"description,omitempty" yaml:"description"`
+	DueInDays   *int     `json:"due_in_days,omitempty" yaml:"due_in_days"`
+	Tags        []string `json:"tags,omitempty" yaml:"tags"`
+
+	// send_notification; the subject defaults to the rule's name
+	Subject  string   `json:"subject,omitempty" yaml:"subject"`
+	Message  string   `json:"message,omitempty" yaml:"message"`
+	Channels []string `json:"channels,omitempty" yaml:"channels"`
+
+	// Priority of the task or notification
+	Priority string `json:"priority,omitempty" yaml:"priority"`
+
+	// call_tool: an MCP server tool and its arguments
+	Tool      string                 `json:"tool,omitempty" yaml:"tool"`
+	Arguments map[string]interface{} `json:"arguments,omitempty" yaml:"arguments"`
+}
+
+// RuleRequest defines a rule, in JSON or YAML
+type RuleRequest struct {
+	Name string `json:"name" yaml:"name"`
+	// Enabled defaults to true
+	Enabled *bool    `json:"enabled" yaml:"enabled"`
+	Trigger Trigger  `json:"trigger" yaml:"trigger"`
+	Actions []Action `json:"actions" yaml:"actions"`
+}
+
+// parseRuleRequest decodes a rule definition, as YAML when the content
+// type says so and as JSON otherwise.
+func parseRuleRequest(body []byte, contentType string) (RuleRequest, error) {
+	var req RuleRequest
+	var err error
+	if strings.Contains(contentType, "yaml") {
+		err = yaml.Unmarshal(body, &req)
+	} else {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		return req, fmt.Errorf("invalid rule definition: %v", err)
+	}
+	return req, validateRule(req)
+}
+
+// validateRule checks a rule definition before it is stored, so that the
+// scheduler only meets rules it can run.
+func validateRule(req RuleRequest) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	switch req.Trigger.Type {
+	case triggerSchedule:
+		if _, err := parseCron(req.Trigger.Schedule); err != nil {
+			return fmt.Errorf("trigger.schedule: %v", err)
+		}
+	case triggerTaskOverdue, triggerWeatherAlert, triggerEventCreated:
+	default:
+		return fmt.Errorf("trigger.type must be schedule, task_overdue, weather_alert or event_created")
+	}
+
+	if len(req.Actions) == 0 {
+		return fmt.Errorf("at least one action is required")
+	}
+	for i, action := range req.Actions {
+		switch action.Type {
+		case actionCreateTask:
+			if action.Title == "" {
+				return fmt.Errorf("actions[%d]: create_task requires a title", i)
+			}
+		case actionSendNotification:
+			if action.Message == "" {
+				return fmt.Errorf("actions[%d]: send_notification requires a message", i)
+			}
+		case actionCallTool:
+			if action.Tool == "" {
+				return fmt.Errorf("actions[%d]: call_tool requires a tool", i)
+			}
+			// A rule creating the events that trigger it would never stop
+			if req.Trigger.Type == triggerEventCreated && action.Tool == "create_event" {
+				return fmt.Errorf("actions[%d]: event_created rules cannot call create_event", i)
+			}
+		default:
+			return fmt.Errorf("actions[%d]: type must be create_task, send_notification or call_tool", i)
+		}
+		for _, text := range []string{action.Title, action.Description, action.Subject, action.Message} {
+			if _, err := template.New("").Parse(text); err != nil {
+				return fmt.Errorf("actions[%d]: %v", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// render fills a template of an action with the data of a trigger;
+// missing fields render empty.
+func render(text string, data map[string]interface{}) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(out.String(), "<no value>", ""), nil
+}
+
+// cronSchedule is a parsed cron expression: the allowed values of each
+// field
+type cronSchedule struct {
+	minute, hour, day, month, weekday map[int]bool
+	// anyDay and anyWeekday follow cron: when both day fields are
+	// restricted, a time matching either matches
+	anyDay, anyWeekday bool
+}
+
+// parseCron parses a five-field cron expression. Fields take *, values,
+// ranges (1-5), lists (1,15) and steps (*/15, 0-30/10); weekdays run from
+// 0 (Sunday) to 6, and 7 is also Sunday.
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if s.day, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if s.weekday, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if s.weekday[7] {
+		s.weekday[0] = true
+	}
+	s.anyDay = fields[2] == "*"
+	s.anyWeekday = fields[4] == "*"
+	return &s, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", field)
+			}
+			step, part = n, part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value in %q", field)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid range in %q", field)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q is out of range %d-%d", field, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// matches reports whether the schedule fires in the minute of t.
+func (s *cronSchedule) matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+	day, weekday := s.day[t.Day()], s.weekday[int(t.Weekday())]
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
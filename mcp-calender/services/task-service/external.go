@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/database"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Tasks synced with another system, such as GitHub issues or Todoist, keep
+// the item they mirror as an external_source and external_id pair, unique
+// per user. A sync run puts every item with PUT /tasks/external/{source}/{id},
+// which creates the task the first time and updates it after, so running it
+// again never duplicates tasks. An item that has not changed leaves its
+// task, and its updated_at, alone; the other way round, GET /tasks with
+// external_source and updated_after lists the tasks changed locally since
+// the last run, to send back.
+
+// maxExternalIDLength is the size of the external_id column
+const maxExternalIDLength = 255
+
+// externalSourcePattern is what an external_source looks like, e.g.
+// "github" or "todoist"
+var externalSourcePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,49}$`)
+
+// Outcomes of an upsert
+const (
+	upsertCreated   = "created"
+	upsertUpdated   = "updated"
+	upsertUnchanged = "unchanged"
+)
+
+// ExternalTaskRequest is the body of PUT /tasks/external/{source}/{id}:
+// the item's current state, which replaces that of its task. Status is
+// only changed when given; a new task is pending by default.
+type ExternalTaskRequest struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Priority    string   `json:"priority"`
+	Status      string   `json:"status"`
+	DueDate     string   `json:"due_date"` // YYYY-MM-DD
+	Tags        []string `json:"tags"`
+}
+
+// UpsertResult is the response of PUT /tasks/external/{source}/{id}
+type UpsertResult struct {
+	Task Task `json:"task"`
+	// Result is created, updated or unchanged
+	Result string `json:"result"`
+}
+
+// validExternalRef returns why an external_source and external_id pair is
+// invalid, or "". Both or neither must be given.
+func validExternalRef(source, id string) string {
+	switch {
+	case source == "" && id == "":
+		return ""
+	case source == "" || id == "":
+		return "external_source and external_id must be given together"
+	case !externalSourcePattern.MatchString(source):
+		return "external_source must be up to 50 lowercase letters, digits, dots, dashes or underscores"
+	case len(id) > maxExternalIDLength:
+		return "external_id must be at most 255 bytes"
+	}
+	return ""
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation,
+// such as a second task for the same external item.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// handleGetExternalTask returns the user's task synced with an external
+// item.
+func handleGetExternalTask(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		taskRequestDuration.WithLabelValues("GET", "/tasks/external").Observe(time.Since(start).Seconds())
+	}()
+
+	displayer, msg := requestDisplayer(r)
+	if msg != "" {
+		taskRequestsTotal.WithLabelValues("GET", "/tasks/external", "error").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	if msg := validExternalRef(vars["source"], vars["external_id"]); msg != "" {
+		taskRequestsTotal.WithLabelValues("GET", "/tasks/external", "error").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := database.WithTimeout(r.Context())
+	defer cancel()
+
+	var task Task
+	err := withReadDB(ctx, auth.UserID(r), func(readDB *sql.DB) error {
+		var err error
+		task, err = externalTask(ctx, readDB, auth.UserID(r), vars["source"], vars["external_id"])
+		return err
+	})
+	if err == sql.ErrNoRows {
+		taskRequestsTotal.WithLabelValues("GET", "/tasks/external", "error").Inc()
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		taskRequestsTotal.WithLabelValues("GET", "/tasks/external", "error").Inc()
+		http.Error(w, "Failed to query task", http.StatusInternalServerError)
+		return
+	}
+
+	displayer.display(&task)
+	taskRequestsTotal.WithLabelValues("GET", "/tasks/external", "success").Inc()
+	writeJSONResponse(w, task)
+}
+
+// handleUpsertExternalTask creates or updates the user's task synced with
+// an external item, answering 201 Created for a new task and 200 OK
+// otherwise.
+func handleUpsertExternalTask(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		taskRequestDuration.WithLabelValues("PUT", "/tasks/external").Observe(time.Since(start).Seconds())
+	}()
+
+	displayer, msg := requestDisplayer(r)
+	if msg != "" {
+		taskRequestsTotal.WithLabelValues("PUT", "/tasks/external", "error").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	source, externalID := vars["source"], vars["external_id"]
+	if msg := validExternalRef(source, externalID); msg != "" {
+		taskRequestsTotal.WithLabelValues("PUT", "/tasks/external", "error").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	var req ExternalTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		taskRequestsTotal.WithLabelValues("PUT", "/tasks/external", "error").Inc()
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	create := CreateTaskRequest{
+		Title:          req.Title,
+		Description:    req.Description,
+		Priority:       req.Priority,
+		DueDate:        req.DueDate,
+		Tags:           req.Tags,
+		ExternalSource: source,
+		ExternalID:     externalID,
+	}
+	if msg := prepareCreate(&create); msg != "" {
+		taskRequestsTotal.WithLabelValues("PUT", "/tasks/external", "error").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := database.WithTimeout(r.Context())
+	defer cancel()
+
+	result, err := upsertExternalTask(ctx, create, req.Status, auth.UserID(r))
+	if err != nil {
+		taskRequestsTotal.WithLabelValues("PUT", "/tasks/external", "error").Inc()
+		http.Error(w, "Failed to save task", http.StatusInternalServerError)
+		return
+	}
+	if result.Result != upsertUnchanged {
+		noteWrite(auth.UserID(r))
+		tasksChanged()
+	}
+
+	displayer.display(&result.Task)
+	taskRequestsTotal.WithLabelValues("PUT", "/tasks/external", "success").Inc()
+	if result.Result == upsertCreated {
+		w.WriteHeader(http.StatusCreated)
+	}
+	writeJSONResponse(w, result)
+}
+
+// upsertExternalTask saves the task of the external item of a prepared
+// req in one statement, so that concurrent sync runs cannot both create
+// it. An empty status leaves that of an existing task as it is.
+func upsertExternalTask(ctx context.Context, req CreateTaskRequest, status, userID string) (UpsertResult, error) {
+	setStatus := status != ""
+	if !setStatus {
+		status = "pending"
+	}
+
+	var inserted bool
+	task, err := scanTask(withExtra{db.QueryRowContext(ctx, `
+		INSERT INTO tasks (title, description, priority, status, due_date, tags, external_source, external_id, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (user_id, external_source, external_id) WHERE external_id IS NOT NULL DO UPDATE SET
+			title = EXCLUDED.title,
+			description = EXCLUDED.description,
+			priority = EXCLUDED.priority,
+			status = CASE WHEN $10 THEN EXCLUDED.status ELSE tasks.status END,
+			due_date = EXCLUDED.due_date,
+			tags = EXCLUDED.tags
+		WHERE (tasks.title, tasks.description, tasks.priority, tasks.due_date, tasks.tags)
+			IS DISTINCT FROM (EXCLUDED.title, EXCLUDED.description, EXCLUDED.priority, EXCLUDED.due_date, EXCLUDED.tags)
+			OR ($10 AND tasks.status IS DISTINCT FROM EXCLUDED.status)
+		RETURNING `+taskColumns+`, xmax = 0`,
+		req.Title, req.Description, req.Priority, status,
+		nullIfEmpty(req.DueDate), pq.Array(req.Tags),
+		req.ExternalSource, req.ExternalID, userID, setStatus), []interface{}{&inserted}})
+
+	// The conflicting row was left alone, since nothing changed
+	if err == sql.ErrNoRows {
+		task, err = externalTask(ctx, db, userID, req.ExternalSource, req.ExternalID)
+		return UpsertResult{Task: task, Result: upsertUnchanged}, err
+	}
+	if err != nil {
+		return UpsertResult{}, err
+	}
+	if inserted {
+		return UpsertResult{Task: task, Result: upsertCreated}, nil
+	}
+	return UpsertResult{Task: task, Result: upsertUpdated}, nil
+}
+
+// externalTask reads the user's task synced with an external item.
+func externalTask(ctx context.Context, q interface {
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}, userID, source, externalID string) (Task, error) {
+	return scanTask(q.QueryRowContext(ctx, `
+		SELECT `+taskColumns+`
+		FROM tasks WHERE user_id = $1 AND external_source = $2 AND external_id = $3
+	`, userID, source, externalID))
+}
+
+// withExtra scans a row of taskColumns followed by more columns into
+// extra.
+type withExtra struct {
+	row   interface{ Scan(...interface{}) error }
+	extra []interface{}
+}
+
+func (w withExtra) Scan(dest ...interface{}) error {
+	return w.row.Scan(append(dest, w.extra...)...)
+}
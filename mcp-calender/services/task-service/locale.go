@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Localized display: a request with an Accept-Language header, or a
+// timezone in the tz parameter or the X-Timezone header, gets each task
+// with a display object beside its fields: the priority and status as
+// labels of the best language the service has, and the dates formatted as
+// is usual there, the timestamps in the timezone asked for (UTC by
+// default). The fields themselves keep their canonical values, so clients
+// that parse them are unaffected; requests without either header, such as
+// those of the Go client, get no display at all.
+
+// TaskDisplay is a task as shown to a person of a locale.
+type TaskDisplay struct {
+	Locale    string `json:"locale"`
+	Timezone  string `json:"timezone"`
+	Priority  string `json:"priority"`
+	Status    string `json:"status"`
+	DueDate   string `json:"due_date,omitempty"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// displayLocale is how tasks are shown in a locale. Priorities and statuses
+// it has no label for are shown as they are.
+type displayLocale struct {
+	dateLayout string
+	timeLayout string
+	priorities map[string]string
+	statuses   map[string]string
+}
+
+// displayLocales are the locales tasks are shown in, by lower-case
+// language tag; a tag with a region falls back to its language.
+var displayLocales = map[string]displayLocale{
+	"en": {
+		dateLayout: "01/02/2006",
+		timeLayout: "01/02/2006 3:04 PM MST",
+		priorities: map[string]string{"low": "Low", "medium": "Medium", "high": "High"},
+		statuses:   map[string]string{"pending": "Pending", "in_progress": "In progress", "completed": "Completed", "done": "Done", "cancelled": "Cancelled"},
+	},
+	"en-gb": {
+		dateLayout: "02/01/2006",
+		timeLayout: "02/01/2006 15:04 MST",
+		priorities: map[string]string{"low": "Low", "medium": "Medium", "high": "High"},
+		statuses:   map[string]string{"pending": "Pending", "in_progress": "In progress", "completed": "Completed", "done": "Done", "cancelled": "Cancelled"},
+	},
+	"de": {
+		dateLayout: "02.01.2006",
+		timeLayout: "02.01.2006 15:04 MST",
+		priorities: map[string]string{"low": "Niedrig", "medium": "Mittel", "high": "Hoch"},
+		statuses:   map[string]string{"pending": "Offen", "in_progress": "In Bearbeitung", "completed": "Abgeschlossen", "done": "Erledigt", "cancelled": "Abgebrochen"},
+	},
+	"es": {
+		dateLayout: "02/01/2006",
+		timeLayout: "02/01/2006 15:04 MST",
+		priorities: map[string]string{"low": "Baja", "medium": "Media", "high": "Alta"},
+		statuses:   map[string]string{"pending": "Pendiente", "in_progress": "En curso", "completed": "Completada", "done": "Hecha", "cancelled": "Cancelada"},
+	},
+	"fr": {
+		dateLayout: "02/01/2006",
+		timeLayout: "02/01/2006 15:04 MST",
+		priorities: map[string]string{"low": "Basse", "medium": "Moyenne", "high": "Haute"},
+		statuses:   map[string]string{"pending": "En attente", "in_progress": "En cours", "completed": "Terminée", "done": "Faite", "cancelled": "Annulée"},
+	},
+}
+
+// defaultDisplayLocale is the locale of requests whose languages the
+// service has none of
+const defaultDisplayLocale = "en"
+
+// taskDisplayer adds the display of one request's locale and timezone to
+// tasks.
+type taskDisplayer struct {
+	tag    string
+	locale displayLocale
+	zone   *time.Location
+}
+
+// requestDisplayer returns the displayer of r, or nil when r asks for no
+// display, and why r is invalid or "".
+func requestDisplayer(r *http.Request) (*taskDisplayer, string) {
+	acceptLanguage := r.Header.Get("Accept-Language")
+	timezone := r.URL.Query().Get("tz")
+	if timezone == "" {
+		timezone = r.Header.Get("X-Timezone")
+	}
+	if acceptLanguage == "" && timezone == "" {
+		return nil, ""
+	}
+
+	d := &taskDisplayer{zone: time.UTC}
+	d.tag = negotiateLocale(acceptLanguage)
+	d.locale = displayLocales[d.tag]
+	if timezone != "" {
+		zone, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Sprintf("Unknown timezone %q", timezone)
+		}
+		d.zone = zone
+	}
+	return d, ""
+}
+
+// negotiateLocale returns the display locale best matching an
+// Accept-Language header: that of the language of highest quality the
+// service has, the default one if it has none.
+func negotiateLocale(acceptLanguage string) string {
+	type choice struct {
+		tag     string
+		quality float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		c := choice{tag: strings.ToLower(strings.TrimSpace(tag)), quality: 1}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if quality, err := strconv.ParseFloat(q, 64); err == nil {
+				c.quality = quality
+			}
+		}
+		if c.tag != "" && c.quality > 0 {
+			choices = append(choices, c)
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].quality > choices[j].quality })
+
+	for _, c := range choices {
+		if _, ok := displayLocales[c.tag]; ok {
+			return c.tag
+		}
+		if lang, _, _ := strings.Cut(c.tag, "-"); lang != c.tag {
+			if _, ok := displayLocales[lang]; ok {
+				return lang
+			}
+		}
+	}
+	return defaultDisplayLocale
+}
+
+// display sets the display of task. A nil displayer leaves it unset.
+func (d *taskDisplayer) display(task *Task) {
+	if d == nil {
+		return
+	}
+	shown := &TaskDisplay{
+		Locale:    d.tag,
+		Timezone:  d.zone.String(),
+		Priority:  label(d.locale.priorities, task.Priority),
+		Status:    label(d.locale.statuses, task.Status),
+		CreatedAt: task.CreatedAt.In(d.zone).Format(d.locale.timeLayout),
+		UpdatedAt: task.UpdatedAt.In(d.zone).Format(d.locale.timeLayout),
+	}
+	// A due date is a day, the same in every timezone
+	if due, err := time.Parse("2006-01-02", task.DueDate); err == nil {
+		shown.DueDate = due.Format(d.locale.dateLayout)
+	}
+	task.Display = shown
+}
+
+// displayAll sets the display of each of tasks.
+func (d *taskDisplayer) displayAll(tasks []Task) {
+	for i := range tasks {
+		d.display(&tasks[i])
+	}
+}
+
+// label returns the label of value in labels, or value if it has none.
+func label(labels map[string]string, value string) string {
+	if l, ok := labels[value]; ok {
+		return l
+	}
+	return value
+}
@@ -4,45 +4,161 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"syscall"
 	"time"
 
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/database"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/health"
 	"github.com/gorilla/mux"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Task represents a task in the system
 type Task struct {
-	ID          int       `json:"id" db:"id"`
-	Title       string    `json:"title" db:"title"`
-	Description string    `json:"description" db:"description"`
-	Priority    string    `json:"priority" db:"priority"`
-	Status      string    `json:"status" db:"status"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	ID          int      `json:"id" db:"id"`
+	Title       string   `json:"title" db:"title"`
+	Description string   `json:"description" db:"description"`
+	Priority    string   `json:"priority" db:"priority"`
+	Status      string   `json:"status" db:"status"`
+	DueDate     string   `json:"due_date,omitempty" db:"due_date"` // YYYY-MM-DD
+	Tags        []string `json:"tags" db:"tags"`
+	// ExternalSource and ExternalID identify the item of another system
+	// the task is synced with, such as "github" and "owner/repo#12"
+	ExternalSource string    `json:"external_source,omitempty" db:"external_source"`
+	ExternalID     string    `json:"external_id,omitempty" db:"external_id"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+	// Display is the task as shown in the requester's locale, when asked
+	// for with Accept-Language
+	Display *TaskDisplay `json:"display,omitempty" db:"-"`
 }
 
-// CreateTaskRequest represents the request payload for creating a task
+// CreateTaskRequest represents the request payload for creating a task.
+// ExternalSource and ExternalID are given together or not at all.
 type CreateTaskRequest struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Priority    string `json:"priority"`
+	Title          string   `json:"title"`
+	Description    string   `json:"description"`
+	Priority       string   `json:"priority"`
+	DueDate        string   `json:"due_date"` // YYYY-MM-DD
+	Tags           []string `json:"tags"`
+	ExternalSource string   `json:"external_source"`
+	ExternalID     string   `json:"external_id"`
 }
 
-// UpdateTaskRequest represents the request payload for updating a task
+// BulkCreateRequest is the payload of POST /tasks/bulk
+type BulkCreateRequest struct {
+	Tasks []CreateTaskRequest `json:"tasks"`
+}
+
+// maxBulkTasks bounds the tasks created by one bulk request
+const maxBulkTasks = 100
+
+// BulkDeleteResult is the response of DELETE /tasks: the number of tasks
+// the filters match and, unless it was a dry run, deleted
+type BulkDeleteResult struct {
+	Matched int64 `json:"matched"`
+	Deleted int64 `json:"deleted"`
+	DryRun  bool  `json:"dry_run"`
+}
+
+// doneStatuses are the statuses that complete a task and set its
+// completed_at
+var doneStatuses = []string{"completed", "done"}
+
+// UpdateTaskRequest represents the request payload for updating a task.
+// An empty DueDate clears it. ExternalSource and ExternalID link the task
+// to an external item, given together; empty ones unlink it.
 type UpdateTaskRequest struct {
-	Title       *string `json:"title,omitempty"`
-	Description *string `json:"description,omitempty"`
-	Priority    *string `json:"priority,omitempty"`
-	Status      *string `json:"status,omitempty"`
+	Title          *string   `json:"title,omitempty"`
+	Description    *string   `json:"description,omitempty"`
+	Priority       *string   `json:"priority,omitempty"`
+	Status         *string   `json:"status,omitempty"`
+	DueDate        *string   `json:"due_date,omitempty"`
+	Tags           *[]string `json:"tags,omitempty"`
+	ExternalSource *string   `json:"external_source,omitempty"`
+	ExternalID     *string   `json:"external_id,omitempty"`
+}
+
+// taskColumns are the columns scanTask reads, in order
+const taskColumns = "id, title, description, priority, status, due_date, tags, external_source, external_id, created_at, updated_at"
+
+// scanTask reads a row of taskColumns
+func scanTask(row interface{ Scan(...interface{}) error }) (Task, error) {
+	var task Task
+	var dueDate sql.NullTime
+	var externalSource, externalID sql.NullString
+	err := row.Scan(
+		&task.ID, &task.Title, &task.Description, &task.Priority, &task.Status,
+		&dueDate, pq.Array(&task.Tags), &externalSource, &externalID, &task.CreatedAt, &task.UpdatedAt,
+	)
+	if dueDate.Valid {
+		task.DueDate = dueDate.Time.Format("2006-01-02")
+	}
+	task.ExternalSource, task.ExternalID = externalSource.String, externalID.String
+	if task.Tags == nil {
+		task.Tags = []string{}
+	}
+	return task, err
+}
+
+// validDueDate reports whether date is empty or a YYYY-MM-DD date
+func validDueDate(date string) bool {
+	if date == "" {
+		return true
+	}
+	_, err := time.Parse("2006-01-02", date)
+	return err == nil
+}
+
+// prepareCreate validates req and fills in its defaults, returning why it
+// is invalid or ""
+func prepareCreate(req *CreateTaskRequest) string {
+	if req.Title == "" {
+		return "Title is required"
+	}
+	if !validDueDate(req.DueDate) {
+		return "Due date must be in YYYY-MM-DD format"
+	}
+	if msg := validExternalRef(req.ExternalSource, req.ExternalID); msg != "" {
+		return msg
+	}
+	if req.Priority == "" {
+		req.Priority = "medium"
+	}
+	if req.Tags == nil {
+		req.Tags = []string{}
+	}
+	return ""
+}
+
+// insertTask creates a pending task for userID from a prepared req
+func insertTask(ctx context.Context, q interface {
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}, req CreateTaskRequest, userID string) (Task, error) {
+	return scanTask(q.QueryRowContext(ctx, `
+		INSERT INTO tasks (title, description, priority, status, due_date, tags, external_source, external_id, user_id) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) 
+		RETURNING `+taskColumns,
+		req.Title, req.Description, req.Priority, "pending",
+		nullIfEmpty(req.DueDate), pq.Array(req.Tags),
+		nullIfEmpty(req.ExternalSource), nullIfEmpty(req.ExternalID), userID))
+}
+
+// nullIfEmpty stores an empty string as NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
 }
 
 // Database connection
@@ -79,31 +195,37 @@ func init() {
 }
 
 func main() {
-	// Initialize database
+	// Initialize database, which may start after this service
 	if err := initDB(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.Close()
+	defer closeReplicas()
 
-	// Create tables
-	if err := createTables(); err != nil {
-		log.Fatalf("Failed to create tables: %v", err)
-	}
+	checker := health.NewChecker("Task Service",
+		append([]health.Dependency{{Name: "postgres", Check: db.PingContext}}, replicaDependencies()...)...)
 
 	router := mux.NewRouter()
+	// Tasks belong to the user of the request's token
+	router.Use(auth.ConfigFromEnv().Middleware(health.PublicPaths("/metrics", "/metrics/refresh")...))
 
 	// Task endpoints
 	router.HandleFunc("/tasks", handleGetTasks).Methods("GET")
 	router.HandleFunc("/tasks", handleCreateTask).Methods("POST")
+	router.HandleFunc("/tasks", handleDeleteTasks).Methods("DELETE")
+	router.HandleFunc("/tasks/bulk", handleBulkCreateTasks).Methods("POST")
+	router.HandleFunc("/tasks/external/{source}/{external_id:.+}", handleGetExternalTask).Methods("GET")
+	router.HandleFunc("/tasks/external/{source}/{external_id:.+}", handleUpsertExternalTask).Methods("PUT")
+	router.HandleFunc("/tasks/{id}", handleGetTask).Methods("GET")
 	router.HandleFunc("/tasks/{id}", handleUpdateTask).Methods("PATCH")
 	router.HandleFunc("/tasks/{id}", handleDeleteTask).Methods("DELETE")
-	router.HandleFunc("/health", handleHealth).Methods("GET")
+	for _, path := range health.Paths {
+		router.Handle(path, checker).Methods("GET")
+	}
 
 	// Metrics endpoint
 	router.Handle("/metrics", promhttp.Handler())
-
-	// Start metrics updater
-	go updateMetrics()
+	router.HandleFunc("/metrics/refresh", handleRefreshMetrics).Methods("POST")
 
 	port := getEnv("PORT", "8081")
 	server := &http.Server{
@@ -119,6 +241,22 @@ func main() {
 		}
 	}()
 
+	// Answer liveness probes while waiting for the database, and report
+	// ready once the tables exist
+	if err := checker.Start(context.Background(), func() error { return setupSchema(context.Background()) }); err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+
+	// Route reads to the replicas that keep up with the primary
+	replicaCtx, stopReplicas := context.WithCancel(context.Background())
+	defer stopReplicas()
+	go monitorReplicas(replicaCtx)
+
+	// Count the tasks now that the table exists, then after each write
+	metricsCtx, stopMetrics := context.WithCancel(context.Background())
+	defer stopMetrics()
+	go updateMetrics(metricsCtx)
+
 	// Wait for interrupt signal
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -142,87 +280,122 @@ func initDB() error {
 	if err != nil {
 		return err
 	}
+	return initReplicas()
+}
 
-	// Test connection
-	if err = db.Ping(); err != nil {
-		return err
+// handleGetTasks lists the user's tasks matching the filters of
+// taskFilters, in the order and page of listOptions; newest first and all
+// of them by default. A page followed by more tasks has a next_cursor.
+func handleGetTasks(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		taskRequestDuration.WithLabelValues("GET", "/tasks").Observe(time.Since(start).Seconds())
+	}()
+
+	displayer, msg := requestDisplayer(r)
+	if msg != "" {
+		taskRequestsTotal.WithLabelValues("GET", "/tasks", "error").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
+		return
 	}
 
-	log.Println("Connected to PostgreSQL database")
-	return nil
-}
+	q := newTaskQuery(auth.UserID(r))
+	var page taskPage
+	_, msg = taskFilters(q, r.URL.Query())
+	if msg == "" {
+		page, msg = listOptions(q, r.URL.Query())
+	}
+	if msg != "" {
+		taskRequestsTotal.WithLabelValues("GET", "/tasks", "error").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := database.WithTimeout(r.Context())
+	defer cancel()
 
-func createTables() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS tasks (
-		id SERIAL PRIMARY KEY,
-		title VARCHAR(255) NOT NULL,
-		description TEXT,
-		priority VARCHAR(20) DEFAULT 'medium',
-		status VARCHAR(20) DEFAULT 'pending',
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-	
-	CREATE OR REPLACE FUNCTION update_updated_at_column()
-	RETURNS TRIGGER AS $$
-	BEGIN
-		NEW.updated_at = CURRENT_TIMESTAMP;
-		RETURN NEW;
-	END;
-	$$ language 'plpgsql';
-	
-	DROP TRIGGER IF EXISTS update_tasks_updated_at ON tasks;
-	CREATE TRIGGER update_tasks_updated_at
-		BEFORE UPDATE ON tasks
-		FOR EACH ROW
-		EXECUTE FUNCTION update_updated_at_column();
-	`
-
-	_, err := db.Exec(query)
+	var tasks []Task
+	err := withReadDB(ctx, auth.UserID(r), func(readDB *sql.DB) error {
+		rows, err := readDB.QueryContext(ctx, q.selectSQL(), q.args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		tasks = nil
+		for rows.Next() {
+			task, err := scanTask(rows)
+			if err != nil {
+				return err
+			}
+			tasks = append(tasks, task)
+		}
+		return rows.Err()
+	})
 	if err != nil {
-		return err
+		taskRequestsTotal.WithLabelValues("GET", "/tasks", "error").Inc()
+		http.Error(w, "Failed to query tasks", http.StatusInternalServerError)
+		return
 	}
 
-	log.Println("Database tables created successfully")
-	return nil
+	response := map[string]interface{}{"tasks": tasks}
+	if page.limit > 0 && len(tasks) > page.limit {
+		tasks = tasks[:page.limit]
+		response["tasks"] = tasks
+		response["next_cursor"] = page.nextCursor(tasks[len(tasks)-1])
+	}
+
+	displayer.displayAll(tasks)
+	taskRequestsTotal.WithLabelValues("GET", "/tasks", "success").Inc()
+	writeJSONResponse(w, response)
 }
 
-func handleGetTasks(w http.ResponseWriter, r *http.Request) {
+func handleGetTask(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	defer func() {
-		taskRequestDuration.WithLabelValues("GET", "/tasks").Observe(time.Since(start).Seconds())
+		taskRequestDuration.WithLabelValues("GET", "/tasks/:id").Observe(time.Since(start).Seconds())
 	}()
 
-	rows, err := db.Query(`
-		SELECT id, title, description, priority, status, created_at, updated_at 
-		FROM tasks 
-		ORDER BY created_at DESC
-	`)
+	displayer, msg := requestDisplayer(r)
+	if msg != "" {
+		taskRequestsTotal.WithLabelValues("GET", "/tasks/:id", "error").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := database.WithTimeout(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		taskRequestsTotal.WithLabelValues("GET", "/tasks", "error").Inc()
-		http.Error(w, "Failed to query tasks", http.StatusInternalServerError)
+		taskRequestsTotal.WithLabelValues("GET", "/tasks/:id", "error").Inc()
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
 
-	var tasks []Task
-	for rows.Next() {
-		var task Task
-		err := rows.Scan(
-			&task.ID, &task.Title, &task.Description,
-			&task.Priority, &task.Status, &task.CreatedAt, &task.UpdatedAt,
-		)
-		if err != nil {
-			taskRequestsTotal.WithLabelValues("GET", "/tasks", "error").Inc()
-			http.Error(w, "Failed to scan task", http.StatusInternalServerError)
-			return
-		}
-		tasks = append(tasks, task)
+	var task Task
+	err = withReadDB(ctx, auth.UserID(r), func(readDB *sql.DB) error {
+		task, err = scanTask(readDB.QueryRowContext(ctx, `
+			SELECT `+taskColumns+`
+			FROM tasks WHERE id = $1 AND user_id = $2
+		`, id, auth.UserID(r)))
+		return err
+	})
+	if err == sql.ErrNoRows {
+		taskRequestsTotal.WithLabelValues("GET", "/tasks/:id", "error").Inc()
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		taskRequestsTotal.WithLabelValues("GET", "/tasks/:id", "error").Inc()
+		http.Error(w, "Failed to query task", http.StatusInternalServerError)
+		return
 	}
 
-	taskRequestsTotal.WithLabelValues("GET", "/tasks", "success").Inc()
-	writeJSONResponse(w, map[string]interface{}{"tasks": tasks})
+	displayer.display(&task)
+	taskRequestsTotal.WithLabelValues("GET", "/tasks/:id", "success").Inc()
+	writeJSONResponse(w, task)
 }
 
 func handleCreateTask(w http.ResponseWriter, r *http.Request) {
@@ -231,6 +404,16 @@ func handleCreateTask(w http.ResponseWriter, r *http.Request) {
 		taskRequestDuration.WithLabelValues("POST", "/tasks").Observe(time.Since(start).Seconds())
 	}()
 
+	displayer, msg := requestDisplayer(r)
+	if msg != "" {
+		taskRequestsTotal.WithLabelValues("POST", "/tasks", "error").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := database.WithTimeout(r.Context())
+	defer cancel()
+
 	var req CreateTaskRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		taskRequestsTotal.WithLabelValues("POST", "/tasks", "error").Inc()
@@ -238,43 +421,122 @@ func handleCreateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Title == "" {
+	if msg := prepareCreate(&req); msg != "" {
 		taskRequestsTotal.WithLabelValues("POST", "/tasks", "error").Inc()
-		http.Error(w, "Title is required", http.StatusBadRequest)
+		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
 
-	if req.Priority == "" {
-		req.Priority = "medium"
+	task, err := insertTask(ctx, db, req, auth.UserID(r))
+	if isUniqueViolation(err) {
+		taskRequestsTotal.WithLabelValues("POST", "/tasks", "error").Inc()
+		http.Error(w, "A task is already synced with this external item", http.StatusConflict)
+		return
 	}
-
-	var task Task
-	err := db.QueryRow(`
-		INSERT INTO tasks (title, description, priority, status) 
-		VALUES ($1, $2, $3, $4) 
-		RETURNING id, title, description, priority, status, created_at, updated_at
-	`, req.Title, req.Description, req.Priority, "pending").Scan(
-		&task.ID, &task.Title, &task.Description,
-		&task.Priority, &task.Status, &task.CreatedAt, &task.UpdatedAt,
-	)
-
 	if err != nil {
 		taskRequestsTotal.WithLabelValues("POST", "/tasks", "error").Inc()
 		http.Error(w, "Failed to create task", http.StatusInternalServerError)
 		return
 	}
+	noteWrite(auth.UserID(r))
+	tasksChanged()
 
+	displayer.display(&task)
 	taskRequestsTotal.WithLabelValues("POST", "/tasks", "success").Inc()
 	w.WriteHeader(http.StatusCreated)
 	writeJSONResponse(w, task)
 }
 
+// handleBulkCreateTasks creates several tasks at once: all of them, or
+// none if one is invalid or fails.
+func handleBulkCreateTasks(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		taskRequestDuration.WithLabelValues("POST", "/tasks/bulk").Observe(time.Since(start).Seconds())
+	}()
+
+	displayer, msg := requestDisplayer(r)
+	if msg != "" {
+		taskRequestsTotal.WithLabelValues("POST", "/tasks/bulk", "error").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := database.WithTimeout(r.Context())
+	defer cancel()
+
+	var req BulkCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		taskRequestsTotal.WithLabelValues("POST", "/tasks/bulk", "error").Inc()
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Tasks) == 0 || len(req.Tasks) > maxBulkTasks {
+		taskRequestsTotal.WithLabelValues("POST", "/tasks/bulk", "error").Inc()
+		http.Error(w, fmt.Sprintf("Between 1 and %d tasks are required", maxBulkTasks), http.StatusBadRequest)
+		return
+	}
+	for i := range req.Tasks {
+		if msg := prepareCreate(&req.Tasks[i]); msg != "" {
+			taskRequestsTotal.WithLabelValues("POST", "/tasks/bulk", "error").Inc()
+			http.Error(w, fmt.Sprintf("Task %d: %s", i+1, msg), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		taskRequestsTotal.WithLabelValues("POST", "/tasks/bulk", "error").Inc()
+		http.Error(w, "Failed to create tasks", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	tasks := make([]Task, 0, len(req.Tasks))
+	for _, create := range req.Tasks {
+		task, err := insertTask(ctx, tx, create, auth.UserID(r))
+		if isUniqueViolation(err) {
+			taskRequestsTotal.WithLabelValues("POST", "/tasks/bulk", "error").Inc()
+			http.Error(w, "A task is already synced with one of these external items", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			taskRequestsTotal.WithLabelValues("POST", "/tasks/bulk", "error").Inc()
+			http.Error(w, "Failed to create tasks", http.StatusInternalServerError)
+			return
+		}
+		tasks = append(tasks, task)
+	}
+	if err := tx.Commit(); err != nil {
+		taskRequestsTotal.WithLabelValues("POST", "/tasks/bulk", "error").Inc()
+		http.Error(w, "Failed to create tasks", http.StatusInternalServerError)
+		return
+	}
+	noteWrite(auth.UserID(r))
+	tasksChanged()
+
+	displayer.displayAll(tasks)
+	taskRequestsTotal.WithLabelValues("POST", "/tasks/bulk", "success").Inc()
+	w.WriteHeader(http.StatusCreated)
+	writeJSONResponse(w, map[string]interface{}{"tasks": tasks})
+}
+
 func handleUpdateTask(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	defer func() {
 		taskRequestDuration.WithLabelValues("PATCH", "/tasks/:id").Observe(time.Since(start).Seconds())
 	}()
 
+	displayer, msg := requestDisplayer(r)
+	if msg != "" {
+		taskRequestsTotal.WithLabelValues("PATCH", "/tasks/:id", "error").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := database.WithTimeout(r.Context())
+	defer cancel()
+
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -290,71 +552,71 @@ func handleUpdateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Build dynamic update query
-	setParts := []string{}
-	args := []interface{}{}
-	argIndex := 1
-
+	q := newTaskQuery(auth.UserID(r)).where("id = ?", id)
 	if req.Title != nil {
-		setParts = append(setParts, "title = $"+strconv.Itoa(argIndex))
-		args = append(args, *req.Title)
-		argIndex++
+		q.set("title", *req.Title)
 	}
 	if req.Description != nil {
-		setParts = append(setParts, "description = $"+strconv.Itoa(argIndex))
-		args = append(args, *req.Description)
-		argIndex++
+		q.set("description", *req.Description)
 	}
 	if req.Priority != nil {
-		setParts = append(setParts, "priority = $"+strconv.Itoa(argIndex))
-		args = append(args, *req.Priority)
-		argIndex++
+		q.set("priority", *req.Priority)
 	}
 	if req.Status != nil {
-		setParts = append(setParts, "status = $"+strconv.Itoa(argIndex))
-		args = append(args, *req.Status)
-		argIndex++
+		q.set("status", *req.Status)
+	}
+	if req.DueDate != nil {
+		if !validDueDate(*req.DueDate) {
+			taskRequestsTotal.WithLabelValues("PATCH", "/tasks/:id", "error").Inc()
+			http.Error(w, "Due date must be in YYYY-MM-DD format", http.StatusBadRequest)
+			return
+		}
+		q.set("due_date", nullIfEmpty(*req.DueDate))
+	}
+	if req.Tags != nil {
+		q.set("tags", pq.Array(*req.Tags))
+	}
+	if req.ExternalSource != nil || req.ExternalID != nil {
+		if req.ExternalSource == nil || req.ExternalID == nil {
+			taskRequestsTotal.WithLabelValues("PATCH", "/tasks/:id", "error").Inc()
+			http.Error(w, "external_source and external_id must be given together", http.StatusBadRequest)
+			return
+		}
+		if msg := validExternalRef(*req.ExternalSource, *req.ExternalID); msg != "" {
+			taskRequestsTotal.WithLabelValues("PATCH", "/tasks/:id", "error").Inc()
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		q.set("external_source", nullIfEmpty(*req.ExternalSource))
+		q.set("external_id", nullIfEmpty(*req.ExternalID))
 	}
 
-	if len(setParts) == 0 {
+	if len(q.sets) == 0 {
 		taskRequestsTotal.WithLabelValues("PATCH", "/tasks/:id", "error").Inc()
 		http.Error(w, "No fields to update", http.StatusBadRequest)
 		return
 	}
 
-	query := "UPDATE tasks SET " + strings.Join(setParts, ", ") + " WHERE id = $" + strconv.Itoa(argIndex)
-	args = append(args, id)
-
-	result, err := db.Exec(query, args...)
-	if err != nil {
+	task, err := scanTask(db.QueryRowContext(ctx, q.updateSQL(), q.args...))
+	if err == sql.ErrNoRows {
 		taskRequestsTotal.WithLabelValues("PATCH", "/tasks/:id", "error").Inc()
-		http.Error(w, "Failed to update task", http.StatusInternalServerError)
+		http.Error(w, "Task not found", http.StatusNotFound)
 		return
 	}
-
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
+	if isUniqueViolation(err) {
 		taskRequestsTotal.WithLabelValues("PATCH", "/tasks/:id", "error").Inc()
-		http.Error(w, "Task not found", http.StatusNotFound)
+		http.Error(w, "Another task is already synced with this external item", http.StatusConflict)
 		return
 	}
-
-	// Get updated task
-	var task Task
-	err = db.QueryRow(`
-		SELECT id, title, description, priority, status, created_at, updated_at 
-		FROM tasks WHERE id = $1
-	`, id).Scan(
-		&task.ID, &task.Title, &task.Description,
-		&task.Priority, &task.Status, &task.CreatedAt, &task.UpdatedAt,
-	)
-
 	if err != nil {
 		taskRequestsTotal.WithLabelValues("PATCH", "/tasks/:id", "error").Inc()
-		http.Error(w, "Failed to retrieve updated task", http.StatusInternalServerError)
+		http.Error(w, "Failed to update task", http.StatusInternalServerError)
 		return
 	}
+	noteWrite(auth.UserID(r))
+	tasksChanged()
 
+	displayer.display(&task)
 	taskRequestsTotal.WithLabelValues("PATCH", "/tasks/:id", "success").Inc()
 	writeJSONResponse(w, task)
 }
@@ -365,6 +627,9 @@ func handleDeleteTask(w http.ResponseWriter, r *http.Request) {
 		taskRequestDuration.WithLabelValues("DELETE", "/tasks/:id").Observe(time.Since(start).Seconds())
 	}()
 
+	ctx, cancel := database.WithTimeout(r.Context())
+	defer cancel()
+
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -373,7 +638,7 @@ func handleDeleteTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := db.Exec("DELETE FROM tasks WHERE id = $1", id)
+	result, err := db.ExecContext(ctx, "DELETE FROM tasks WHERE id = $1 AND user_id = $2", id, auth.UserID(r))
 	if err != nil {
 		taskRequestsTotal.WithLabelValues("DELETE", "/tasks/:id", "error").Inc()
 		http.Error(w, "Failed to delete task", http.StatusInternalServerError)
@@ -386,32 +651,66 @@ func handleDeleteTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Task not found", http.StatusNotFound)
 		return
 	}
+	noteWrite(auth.UserID(r))
+	tasksChanged()
 
 	taskRequestsTotal.WithLabelValues("DELETE", "/tasks/:id", "success").Inc()
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	// Check database connection
-	if err := db.Ping(); err != nil {
-		http.Error(w, "Database connection failed", http.StatusServiceUnavailable)
+// handleDeleteTasks deletes the user's tasks matching every filter of
+// taskFilters given. At least one is required so that a bare DELETE /tasks
+// cannot empty the list. With dry_run=true the matching tasks are only
+// counted.
+func handleDeleteTasks(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		taskRequestDuration.WithLabelValues("DELETE", "/tasks").Observe(time.Since(start).Seconds())
+	}()
+
+	q := newTaskQuery(auth.UserID(r))
+	filtered, msg := taskFilters(q, r.URL.Query())
+	if msg == "" && !filtered {
+		msg = "At least one of status, priority, tag, completed_before, due_before, external_source and updated_after is required"
+	}
+	if msg != "" {
+		taskRequestsTotal.WithLabelValues("DELETE", "/tasks", "error").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
 
-	writeJSONResponse(w, map[string]string{"status": "healthy"})
-}
-
-func updateMetrics() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+	ctx, cancel := database.WithTimeout(r.Context())
+	defer cancel()
 
-	for range ticker.C {
-		var count int
-		err := db.QueryRow("SELECT COUNT(*) FROM tasks").Scan(&count)
-		if err == nil {
-			tasksInDB.Set(float64(count))
+	result := BulkDeleteResult{DryRun: dryRun}
+	if dryRun {
+		err := db.QueryRowContext(ctx, q.countSQL(), q.args...).Scan(&result.Matched)
+		if err != nil {
+			taskRequestsTotal.WithLabelValues("DELETE", "/tasks", "error").Inc()
+			http.Error(w, "Failed to count tasks", http.StatusInternalServerError)
+			return
 		}
+		taskRequestsTotal.WithLabelValues("DELETE", "/tasks", "success").Inc()
+		writeJSONResponse(w, result)
+		return
 	}
+
+	res, err := db.ExecContext(ctx, q.deleteSQL(), q.args...)
+	if err != nil {
+		taskRequestsTotal.WithLabelValues("DELETE", "/tasks", "error").Inc()
+		http.Error(w, "Failed to delete tasks", http.StatusInternalServerError)
+		return
+	}
+	result.Deleted, _ = res.RowsAffected()
+	result.Matched = result.Deleted
+	if result.Deleted > 0 {
+		noteWrite(auth.UserID(r))
+		tasksChanged()
+	}
+
+	taskRequestsTotal.WithLabelValues("DELETE", "/tasks", "success").Inc()
+	writeJSONResponse(w, result)
 }
 
 func writeJSONResponse(w http.ResponseWriter, data interface{}) {
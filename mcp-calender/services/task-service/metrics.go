@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/database"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Task gauges: tasks_in_database_total and its breakdown by status are
+// counted again after each write, rather than polled, so they stay put
+// while the service is idle and follow writes within a query. Writes in a
+// burst are counted once: a refresh asked for while one is pending does
+// nothing more. Counts are taken on the primary, which replicas may lag.
+// Tasks written to the database by anything else are only seen on the next
+// write, on POST /metrics/refresh, or every TASK_METRICS_INTERVAL when it
+// is set.
+
+var tasksByStatus = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "tasks_in_database_by_status",
+		Help: "Number of tasks in database by status",
+	},
+	[]string{"status"},
+)
+
+func init() {
+	prometheus.MustRegister(tasksByStatus)
+}
+
+// metricsRefresh holds a pending request to count the tasks again
+var metricsRefresh = make(chan struct{}, 1)
+
+// metricsMu serializes the refreshes, so that an earlier count never
+// overwrites a later one, and guards countedStatuses
+var metricsMu sync.Mutex
+
+// countedStatuses are the statuses that have a gauge, which is set to 0
+// when no task has the status anymore
+var countedStatuses = map[string]bool{}
+
+// TaskCounts is the answer of POST /metrics/refresh.
+type TaskCounts struct {
+	Total    int            `json:"total"`
+	ByStatus map[string]int `json:"by_status"`
+}
+
+// tasksChanged asks for the task gauges to be updated after a write.
+func tasksChanged() {
+	select {
+	case metricsRefresh <- struct{}{}:
+	default:
+		// A refresh is pending already, and will see this write
+	}
+}
+
+// updateMetrics updates the task gauges whenever tasksChanged asks for it,
+// and every TASK_METRICS_INTERVAL if it is set, until ctx is done.
+func updateMetrics(ctx context.Context) {
+	var tick <-chan time.Time
+	if interval := parseDurationEnv("TASK_METRICS_INTERVAL", 0); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	tasksChanged()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-metricsRefresh:
+		case <-tick:
+		}
+		refreshCtx, cancel := database.WithTimeout(ctx)
+		if _, err := refreshMetrics(refreshCtx); err != nil {
+			log.Printf("Warning: Failed to count tasks: %v", err)
+		}
+		cancel()
+	}
+}
+
+// refreshMetrics counts the tasks by status and sets the gauges.
+func refreshMetrics(ctx context.Context) (TaskCounts, error) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	counts := TaskCounts{ByStatus: map[string]int{}}
+	rows, err := db.QueryContext(ctx, "SELECT status, COUNT(*) FROM tasks GROUP BY status")
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return counts, err
+		}
+		counts.ByStatus[status] = n
+		counts.Total += n
+	}
+	if err := rows.Err(); err != nil {
+		return counts, err
+	}
+
+	tasksInDB.Set(float64(counts.Total))
+	for status := range countedStatuses {
+		if _, ok := counts.ByStatus[status]; !ok {
+			tasksByStatus.WithLabelValues(status).Set(0)
+		}
+	}
+	for status, n := range counts.ByStatus {
+		countedStatuses[status] = true
+		tasksByStatus.WithLabelValues(status).Set(float64(n))
+	}
+	return counts, nil
+}
+
+// handleRefreshMetrics counts the tasks now and answers with the counts.
+func handleRefreshMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := database.WithTimeout(r.Context())
+	defer cancel()
+
+	counts, err := refreshMetrics(ctx)
+	if err != nil {
+		taskRequestsTotal.WithLabelValues("POST", "/metrics/refresh", "error").Inc()
+		http.Error(w, "Failed to count tasks", http.StatusInternalServerError)
+		return
+	}
+	taskRequestsTotal.WithLabelValues("POST", "/metrics/refresh", "success").Inc()
+	writeJSONResponse(w, counts)
+}
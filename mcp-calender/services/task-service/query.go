@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Queries of the tasks table that depend on the request are built with
+// taskQuery rather than by concatenating SQL. Every value a request
+// supplies is bound as a parameter, and the only SQL that varies, the
+// columns set and the sort order, comes from the code or from fixed
+// lists. Fragments are written with ? placeholders, numbered $1, $2...
+// as their values are added.
+
+// maxPageSize bounds the limit of GET /tasks
+const maxPageSize = 500
+
+// taskSortColumns are the orders GET /tasks may sort by. Ties are broken
+// by id so that pages do not overlap.
+var taskSortColumns = map[string]string{
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"due_date":   "due_date",
+	"priority":   "CASE priority WHEN 'high' THEN 3 WHEN 'medium' THEN 2 WHEN 'low' THEN 1 ELSE 0 END",
+	"title":      "lower(title)",
+}
+
+// taskQuery is a query of one user's tasks
+type taskQuery struct {
+	sets    []string
+	conds   []string
+	args    []interface{}
+	orderBy string
+	page    string
+}
+
+// newTaskQuery starts a query of userID's tasks.
+func newTaskQuery(userID string) *taskQuery {
+	return (&taskQuery{}).where("user_id = ?", userID)
+}
+
+// bind numbers the ? placeholders of fragment, adding values to the
+// arguments. A count mismatch is a bug in the caller.
+func (q *taskQuery) bind(fragment string, values ...interface{}) string {
+	parts := strings.Split(fragment, "?")
+	if len(parts)-1 != len(values) {
+		panic(fmt.Sprintf("query fragment %q takes %d values, got %d", fragment, len(parts)-1, len(values)))
+	}
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for i, value := range values {
+		q.args = append(q.args, value)
+		b.WriteString("$" + strconv.Itoa(len(q.args)))
+		b.WriteString(parts[i+1])
+	}
+	return b.String()
+}
+
+// where adds a condition every task must meet.
+func (q *taskQuery) where(cond string, values ...interface{}) *taskQuery {
+	q.conds = append(q.conds, q.bind(cond, values...))
+	return q
+}
+
+// set adds a column an update sets to value.
+func (q *taskQuery) set(column string, value interface{}) *taskQuery {
+	q.sets = append(q.sets, q.bind(column+" = ?", value))
+	return q
+}
+
+// sort orders the tasks by one of taskSortColumns.
+func (q *taskQuery) sort(column string, desc bool) error {
+	expr, ok := taskSortColumns[column]
+	if !ok {
+		return fmt.Errorf("sort must be one of %s", strings.Join(sortColumnNames(), ", "))
+	}
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+	q.orderBy = fmt.Sprintf(" ORDER BY %s %s NULLS LAST, id %s", expr, direction, direction)
+	return nil
+}
+
+// paginate returns at most limit tasks, skipping the first offset; a
+// limit of 0 returns them all.
+func (q *taskQuery) paginate(limit, offset int) *taskQuery {
+	q.page = ""
+	if limit > 0 {
+		q.page = q.bind(" LIMIT ?", limit)
+	}
+	if offset > 0 {
+		q.page += q.bind(" OFFSET ?", offset)
+	}
+	return q
+}
+
+func (q *taskQuery) whereSQL() string {
+	return " WHERE " + strings.Join(q.conds, " AND ")
+}
+
+func (q *taskQuery) selectSQL() string {
+	return "SELECT " + taskColumns + " FROM tasks" + q.whereSQL() + q.orderBy + q.page
+}
+
+func (q *taskQuery) countSQL() string {
+	return "SELECT COUNT(*) FROM tasks" + q.whereSQL()
+}
+
+// updateSQL returns the updated task, or no row when none matched.
+func (q *taskQuery) updateSQL() string {
+	return "UPDATE tasks SET " + strings.Join(q.sets, ", ") + q.whereSQL() + " RETURNING " + taskColumns
+}
+
+func (q *taskQuery) deleteSQL() string {
+	return "DELETE FROM tasks" + q.whereSQL()
+}
+
+func sortColumnNames() []string {
+	names := make([]string, 0, len(taskSortColumns))
+	for name := range taskSortColumns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// taskFilters adds the filters of query to q: status, a comma separated
+// list, priority, tag, completed_before, a YYYY-MM-DD date a done task was
+// completed before, due_before, a date a task is due before,
+// external_source, the system a task is synced with, and updated_after,
+// an RFC3339 time a task changed after. It returns whether any was given,
+// and why they are invalid or "".
+func taskFilters(q *taskQuery, query url.Values) (bool, string) {
+	filtered := false
+	if status := query.Get("status"); status != "" {
+		q.where("status = ANY(?)", pq.Array(splitList(status)))
+		filtered = true
+	}
+	if priority := query.Get("priority"); priority != "" {
+		q.where("priority = ANY(?)", pq.Array(splitList(priority)))
+		filtered = true
+	}
+	if tag := query.Get("tag"); tag != "" {
+		q.where("? = ANY(tags)", tag)
+		filtered = true
+	}
+	if before := query.Get("completed_before"); before != "" {
+		if !validDueDate(before) {
+			return false, "completed_before must be in YYYY-MM-DD format"
+		}
+		q.where("status = ANY(?) AND COALESCE(completed_at, updated_at) < ?", pq.Array(doneStatuses), before)
+		filtered = true
+	}
+	if before := query.Get("due_before"); before != "" {
+		if !validDueDate(before) {
+			return false, "due_before must be in YYYY-MM-DD format"
+		}
+		q.where("due_date < ?", before)
+		filtered = true
+	}
+	if source := query.Get("external_source"); source != "" {
+		q.where("external_source = ?", source)
+		filtered = true
+	}
+	if after := query.Get("updated_after"); after != "" {
+		t, err := time.Parse(time.RFC3339Nano, after)
+		if err != nil {
+			return false, "updated_after must be an RFC3339 time"
+		}
+		q.where("updated_at > ?", t.UTC().Format(cursorTimeLayout))
+		filtered = true
+	}
+	return filtered, ""
+}
+
+// taskPage is the order and size of a page of GET /tasks, from which the
+// cursor of the next page is made
+type taskPage struct {
+	sort  string
+	desc  bool
+	limit int
+}
+
+// listOptions adds the sort order and page of GET /tasks to q: sort, one
+// of taskSortColumns, created_at by default, order, asc or desc (the
+// default), limit, and either offset or cursor, the next_cursor of the
+// previous page. A cursor keeps the order it was made for. One more task
+// than limit is selected, to tell whether another page follows. It
+// returns why the options are invalid or "".
+func listOptions(q *taskQuery, query url.Values) (taskPage, string) {
+	page := taskPage{sort: query.Get("sort"), desc: true}
+	if page.sort == "" {
+		page.sort = "created_at"
+	}
+	order := strings.ToLower(query.Get("order"))
+	if order != "" && order != "asc" && order != "desc" {
+		return page, "order must be asc or desc"
+	}
+	page.desc = order != "asc"
+
+	var cursor *taskCursor
+	if s := query.Get("cursor"); s != "" {
+		if query.Get("offset") != "" {
+			return page, "cursor and offset cannot be combined"
+		}
+		c, err := decodeCursor(s)
+		if err != nil {
+			return page, "invalid cursor"
+		}
+		if (query.Get("sort") != "" && query.Get("sort") != c.Sort) || (order != "" && (order == "desc") != c.Desc) {
+			return page, "cursor was made for another sort order"
+		}
+		page.sort, page.desc = c.Sort, c.Desc
+		cursor = c
+	}
+	if err := q.sort(page.sort, page.desc); err != nil {
+		return page, err.Error()
+	}
+	if cursor != nil {
+		q.after(cursor)
+	}
+
+	offset := 0
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			return page, fmt.Sprintf("limit must be between 1 and %d", maxPageSize)
+		}
+		page.limit = n
+	}
+	if s := query.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return page, "offset must be a non-negative number"
+		}
+		offset = n
+	}
+	if page.limit > 0 {
+		q.paginate(page.limit+1, offset)
+	} else {
+		q.paginate(0, offset)
+	}
+	return page, ""
+}
+
+// Cursors page through GET /tasks by the sort key and id of the last task
+// of a page rather than by offset, so tasks added or deleted meanwhile
+// neither repeat nor skip tasks of later pages. They are base64 encoded
+// JSON, opaque to clients.
+
+// taskCursor is the position after the last task of a page
+type taskCursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"d"`
+	// Value is the task's sort key, nil when it is NULL
+	Value *string `json:"v"`
+	ID    int     `json:"id"`
+}
+
+// taskSortKeys return a task's value of each of taskSortColumns, as the
+// database compares it, or nil for NULL. Go lowers titles as PostgreSQL
+// does for all but a few non-ASCII letters.
+var taskSortKeys = map[string]func(Task) *string{
+	"created_at": func(t Task) *string { return stringPtr(t.CreatedAt.Format(cursorTimeLayout)) },
+	"updated_at": func(t Task) *string { return stringPtr(t.UpdatedAt.Format(cursorTimeLayout)) },
+	"due_date": func(t Task) *string {
+		if t.DueDate == "" {
+			return nil
+		}
+		return stringPtr(t.DueDate)
+	},
+	"priority": func(t Task) *string { return stringPtr(strconv.Itoa(priorityRank(t.Priority))) },
+	"title":    func(t Task) *string { return stringPtr(strings.ToLower(t.Title)) },
+}
+
+// cursorTimeLayout keeps the microseconds of the TIMESTAMP columns
+const cursorTimeLayout = "2006-01-02 15:04:05.999999"
+
+// priorityRank is the rank the priority sort gives priority
+func priorityRank(priority string) int {
+	switch priority {
+	case "high":
+		return 3
+	case "medium":
+		return 2
+	case "low":
+		return 1
+	}
+	return 0
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// nextCursor returns the cursor of the page after last.
+func (p taskPage) nextCursor(last Task) string {
+	data, _ := json.Marshal(taskCursor{Sort: p.sort, Desc: p.desc, Value: taskSortKeys[p.sort](last), ID: last.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*taskCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c taskCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if _, ok := taskSortColumns[c.Sort]; !ok {
+		return nil, fmt.Errorf("unknown sort %q", c.Sort)
+	}
+	return &c, nil
+}
+
+// after keeps the tasks that come after c in its order. NULL sort keys
+// come last either way, in the order of their ids.
+func (q *taskQuery) after(c *taskCursor) *taskQuery {
+	expr := taskSortColumns[c.Sort]
+	op := ">"
+	if c.Desc {
+		op = "<"
+	}
+	if c.Value == nil {
+		return q.where(fmt.Sprintf("(%s IS NULL AND id %s ?)", expr, op), c.ID)
+	}
+	return q.where(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?) OR %[1]s IS NULL)", expr, op),
+		*c.Value, *c.Value, c.ID)
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/database"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/health"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Read replicas take the reads of GET /tasks and GET /tasks/{id}, which
+// agents poll, off the primary. Writes always go to the primary. Reads go
+// round-robin to the replicas that passed their last check, and to the
+// primary when none did, when a replica fails a read, and for users who
+// wrote within the last REPLICA_READ_AFTER_WRITE so they see their own
+// changes.
+
+// replica is a read replica of the tasks database
+type replica struct {
+	name    string
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+var (
+	replicas    []*replica
+	nextReplica atomic.Uint64
+
+	// How often replicas are checked, and how far behind the primary
+	// one may fall before it stops taking reads
+	replicaCheckInterval = parseDurationEnv("REPLICA_CHECK_INTERVAL", 10*time.Second)
+	replicaMaxLag        = parseDurationEnv("REPLICA_MAX_LAG", 30*time.Second)
+	// readAfterWrite is how long a user's reads stay on the primary
+	// after they change a task
+	readAfterWrite = parseDurationEnv("REPLICA_READ_AFTER_WRITE", 5*time.Second)
+
+	// recentWriters maps users to the time of their last write
+	recentWriters   = map[string]time.Time{}
+	recentWritersMu sync.Mutex
+)
+
+// replicaLagQuery returns how many seconds a replica is behind: 0 once it
+// has replayed everything it received, or on a server that is no replica
+const replicaLagQuery = `
+	SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END`
+
+var (
+	taskReadsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "task_db_reads_total",
+			Help: "Total number of task reads by the database that served them",
+		},
+		[]string{"target"}, // primary, replica or fallback
+	)
+	replicasHealthy = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "task_db_replicas_healthy",
+			Help: "Number of read replicas taking reads",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(taskReadsTotal)
+	prometheus.MustRegister(replicasHealthy)
+}
+
+// initReplicas opens the replicas listed in DATABASE_REPLICA_URLS, comma
+// separated. They take no reads until their first check passes.
+func initReplicas() error {
+	for _, url := range strings.Split(os.Getenv("DATABASE_REPLICA_URLS"), ",") {
+		if url = strings.TrimSpace(url); url == "" {
+			continue
+		}
+		replicaDB, err := sql.Open("postgres", url)
+		if err != nil {
+			return fmt.Errorf("replica %d: %w", len(replicas)+1, err)
+		}
+		replicas = append(replicas, &replica{name: fmt.Sprintf("postgres-replica-%d", len(replicas)+1), db: replicaDB})
+	}
+	return nil
+}
+
+func closeReplicas() {
+	for _, rep := range replicas {
+		rep.db.Close()
+	}
+}
+
+// replicaDependencies are the replicas as optional dependencies: the
+// service runs on the primary alone while they are down.
+func replicaDependencies() []health.Dependency {
+	var deps []health.Dependency
+	for _, rep := range replicas {
+		deps = append(deps, health.Dependency{Name: rep.name, Check: rep.check, Optional: true})
+	}
+	return deps
+}
+
+// check fails if the replica is down or lags more than replicaMaxLag.
+func (rep *replica) check(ctx context.Context) error {
+	var lag float64
+	if err := rep.db.QueryRowContext(ctx, replicaLagQuery).Scan(&lag); err != nil {
+		return err
+	}
+	if d := time.Duration(lag * float64(time.Second)); d > replicaMaxLag {
+		return fmt.Errorf("replication lag %s exceeds %s", d.Round(time.Second), replicaMaxLag)
+	}
+	return nil
+}
+
+// monitorReplicas checks the replicas every replicaCheckInterval, taking
+// those failing their check out of the rotation until they pass again.
+func monitorReplicas(ctx context.Context) {
+	if len(replicas) == 0 {
+		return
+	}
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		healthy := 0
+		for _, rep := range replicas {
+			checkCtx, cancel := database.WithTimeout(ctx)
+			err := rep.check(checkCtx)
+			cancel()
+			if was := rep.healthy.Swap(err == nil); was != (err == nil) {
+				if err != nil {
+					log.Printf("Warning: %s taken out of rotation: %v", rep.name, err)
+				} else {
+					log.Printf("%s is taking reads", rep.name)
+				}
+			}
+			if err == nil {
+				healthy++
+			}
+		}
+		replicasHealthy.Set(float64(healthy))
+		forgetWriters()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// noteWrite keeps the reads of userID on the primary for readAfterWrite.
+func noteWrite(userID string) {
+	if len(replicas) == 0 {
+		return
+	}
+	recentWritersMu.Lock()
+	defer recentWritersMu.Unlock()
+	recentWriters[userID] = time.Now()
+}
+
+func wroteRecently(userID string) bool {
+	recentWritersMu.Lock()
+	defer recentWritersMu.Unlock()
+	at, ok := recentWriters[userID]
+	return ok && time.Since(at) < readAfterWrite
+}
+
+// forgetWriters drops the writes older than readAfterWrite.
+func forgetWriters() {
+	recentWritersMu.Lock()
+	defer recentWritersMu.Unlock()
+	for userID, at := range recentWriters {
+		if time.Since(at) >= readAfterWrite {
+			delete(recentWriters, userID)
+		}
+	}
+}
+
+// pickReplica returns the replica to read from for userID, or nil to read
+// from the primary.
+func pickReplica(userID string) *replica {
+	if len(replicas) == 0 || wroteRecently(userID) {
+		return nil
+	}
+	first := nextReplica.Add(1)
+	for i := range replicas {
+		if rep := replicas[(first+uint64(i))%uint64(len(replicas))]; rep.healthy.Load() {
+			return rep
+		}
+	}
+	return nil
+}
+
+// withReadDB runs read, a query of userID's tasks, on a replica if one is
+// available. If it fails there, or finds nothing because the replica may
+// not have the row yet, it runs again on the primary.
+func withReadDB(ctx context.Context, userID string, read func(*sql.DB) error) error {
+	rep := pickReplica(userID)
+	if rep == nil {
+		taskReadsTotal.WithLabelValues("primary").Inc()
+		return read(db)
+	}
+
+	err := read(rep.db)
+	if err == nil || ctx.Err() != nil {
+		taskReadsTotal.WithLabelValues("replica").Inc()
+		return err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Warning: read on %s failed, using the primary: %v", rep.name, err)
+		rep.healthy.Store(false)
+	}
+	taskReadsTotal.WithLabelValues("fallback").Inc()
+	return read(db)
+}
+
+func parseDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Warning: invalid %s %q, using %s", key, value, defaultValue)
+	}
+	return defaultValue
+}
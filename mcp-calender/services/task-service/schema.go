@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The schema is set up at startup by whichever replica gets there first.
+// Replicas starting together would race on the DDL, creating functions
+// and triggers another is dropping, so it runs in one transaction holding
+// an advisory lock: the others wait for it and then find everything in
+// place. A failed setup is retried a few times before the service gives
+// up. Where migrations are applied externally, SKIP_SCHEMA_SETUP=true
+// leaves the schema alone and only checks that the tasks table exists.
+
+// schemaLockID is the advisory lock held while setting up the schema. The
+// database is shared with other services, so it is the task service's own.
+const schemaLockID int64 = 0x7461736b73 // "tasks"
+
+// How many times schema setup is tried, and how long it waits after the
+// first failure, doubling after each
+const (
+	schemaSetupAttempts = 5
+	schemaSetupBackoff  = time.Second
+)
+
+// skipSchemaSetup leaves the schema to migrations applied externally
+var skipSchemaSetup = parseBoolEnv("SKIP_SCHEMA_SETUP", false)
+
+// setupSchema creates or updates the tables, retrying failures, or with
+// skipSchemaSetup checks that the tasks table exists.
+func setupSchema(ctx context.Context) error {
+	if skipSchemaSetup {
+		var table *string
+		if err := db.QueryRowContext(ctx, "SELECT to_regclass('tasks')::text").Scan(&table); err != nil {
+			return err
+		}
+		if table == nil {
+			return errors.New("SKIP_SCHEMA_SETUP is set but the tasks table does not exist; apply the migrations first")
+		}
+		log.Println("Skipping schema setup, SKIP_SCHEMA_SETUP is set")
+		return nil
+	}
+
+	backoff := schemaSetupBackoff
+	for attempt := 1; ; attempt++ {
+		err := createTables(ctx)
+		if err == nil || attempt == schemaSetupAttempts {
+			return err
+		}
+		log.Printf("Warning: schema setup failed (attempt %d of %d), retrying in %s: %v",
+			attempt, schemaSetupAttempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// createTables runs schemaDDL in a transaction holding schemaLockID, so
+// that it is applied whole, by one replica at a time.
+func createTables(ctx context.Context) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Released when the transaction ends
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", schemaLockID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, schemaDDL); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Println("Database tables created successfully")
+	return nil
+}
+
+// schemaDDL creates the tables, or brings those of an older version up to
+// date; every statement can run again.
+const schemaDDL = `
+CREATE TABLE IF NOT EXISTS tasks (
+	id SERIAL PRIMARY KEY,
+	title VARCHAR(255) NOT NULL,
+	description TEXT,
+	priority VARCHAR(20) DEFAULT 'medium',
+	status VARCHAR(20) DEFAULT 'pending',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Tasks created before users existed belong to the default user
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS user_id VARCHAR(255) NOT NULL DEFAULT 'default';
+CREATE INDEX IF NOT EXISTS tasks_user_id_idx ON tasks (user_id, created_at DESC);
+-- Pages of tasks in the default order, by cursor
+CREATE INDEX IF NOT EXISTS tasks_user_created_id_idx ON tasks (user_id, created_at DESC, id DESC);
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS due_date DATE;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+-- When a task was completed; tasks completed before the column existed
+-- have NULL and are taken as completed when last updated
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS completed_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS tasks_user_status_idx ON tasks (user_id, status);
+
+-- The item of another system a synced task mirrors, e.g. a GitHub issue;
+-- each is synced to at most one task of a user
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS external_source VARCHAR(50);
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS external_id VARCHAR(255);
+CREATE UNIQUE INDEX IF NOT EXISTS tasks_user_external_idx ON tasks (user_id, external_source, external_id)
+	WHERE external_id IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION update_updated_at_column()
+RETURNS TRIGGER AS $$
+BEGIN
+	NEW.updated_at = CURRENT_TIMESTAMP;
+	RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS update_tasks_updated_at ON tasks;
+CREATE TRIGGER update_tasks_updated_at
+	BEFORE UPDATE ON tasks
+	FOR EACH ROW
+	EXECUTE FUNCTION update_updated_at_column();
+
+CREATE OR REPLACE FUNCTION update_completed_at_column()
+RETURNS TRIGGER AS $$
+BEGIN
+	IF NEW.status NOT IN ('completed', 'done') THEN
+		NEW.completed_at = NULL;
+	ELSIF OLD.status NOT IN ('completed', 'done') THEN
+		NEW.completed_at = CURRENT_TIMESTAMP;
+	END IF;
+	RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS update_tasks_completed_at ON tasks;
+CREATE TRIGGER update_tasks_completed_at
+	BEFORE UPDATE OF status ON tasks
+	FOR EACH ROW
+	EXECUTE FUNCTION update_completed_at_column();
+`
+
+func parseBoolEnv(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+		log.Printf("Warning: invalid %s %q, using %t", key, value, defaultValue)
+	}
+	return defaultValue
+}
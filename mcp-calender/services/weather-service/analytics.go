@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Every weather and forecast query served is logged to a Redis stream:
+// the place asked about, where the answer came from (the cache, the API
+// or mock data), the provider called, the status and the latency. The
+// stream is capped at about WEATHER_HISTORY_MAX_LEN entries, the oldest
+// dropped first, and GET /analytics sums up a recent window of it: the
+// most asked places and how often they hit the cache, and each provider's
+// error rate, to tune cache TTLs and the API budget. Entries are written
+// in the background so queries never wait on the log, and dropped when
+// Redis falls behind. No user is recorded.
+
+// historyStream is the Redis stream of served queries
+const historyStream = "weather:history"
+
+// historyMaxLen caps the stream, 0 turning the log off
+var historyMaxLen = parseIntEnv("WEATHER_HISTORY_MAX_LEN", 100000)
+
+// historyQueue holds the entries waiting to be written
+var historyQueue = make(chan map[string]interface{}, 1000)
+
+// Analytics windows, and how many places they rank by default
+const (
+	defaultAnalyticsWindow = 24 * time.Hour
+	maxAnalyticsWindow     = 7 * 24 * time.Hour
+	defaultTopCities       = 10
+)
+
+var historyEntriesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "weather_history_entries_total",
+		Help: "Total number of served queries logged for analytics",
+	},
+	[]string{"status"},
+)
+
+func init() {
+	prometheus.MustRegister(historyEntriesTotal)
+}
+
+// servedQuery tracks a weather or forecast request for the history. It
+// wraps the response to see its status; handlers fill in the rest as
+// they learn it.
+type servedQuery struct {
+	http.ResponseWriter
+	endpoint string
+	status   int
+	// city is the place asked about, a city or location_id
+	city string
+	// source is "cache", "api" or "mock", or "" when nothing was served
+	source string
+	// provider is the weather provider called, "" on a cache hit
+	provider      string
+	providerError bool
+}
+
+func newServedQuery(w http.ResponseWriter, endpoint string) *servedQuery {
+	return &servedQuery{ResponseWriter: w, endpoint: endpoint, status: http.StatusOK}
+}
+
+func (s *servedQuery) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// fetched notes that the provider was called, serving source or failing
+// with err.
+func (s *servedQuery) fetched(source string, err error) {
+	s.provider = "openweathermap"
+	if getEnv("OPENWEATHER_API_KEY", "") == "" {
+		s.provider = "mock"
+	}
+	s.source = source
+	s.providerError = err != nil
+}
+
+// record queues s, served in the time since start, for the history.
+func (s *servedQuery) record(start time.Time) {
+	if historyMaxLen <= 0 || s.city == "" {
+		return
+	}
+	entry := map[string]interface{}{
+		"endpoint":       s.endpoint,
+		"city":           strings.ToLower(strings.TrimSpace(s.city)),
+		"source":         s.source,
+		"provider":       s.provider,
+		"provider_error": strconv.FormatBool(s.providerError),
+		"status":         strconv.Itoa(s.status),
+		"latency_ms":     strconv.FormatInt(time.Since(start).Milliseconds(), 10),
+	}
+	select {
+	case historyQueue <- entry:
+	default:
+		historyEntriesTotal.WithLabelValues("dropped").Inc()
+	}
+}
+
+// runHistoryWriter writes the queued entries to the stream until ctx is
+// done.
+func runHistoryWriter(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case entry := <-historyQueue:
+			writeCtx, cancel := context.WithTimeout(ctx, redisTimeout)
+			err := redisClient.XAdd(writeCtx, &redis.XAddArgs{
+				Stream: historyStream,
+				MaxLen: int64(historyMaxLen),
+				Approx: true,
+				Values: entry,
+			}).Err()
+			cancel()
+			if err != nil {
+				historyEntriesTotal.WithLabelValues("error").Inc()
+				log.Printf("Warning: Failed to log served query: %v", err)
+				continue
+			}
+			historyEntriesTotal.WithLabelValues("success").Inc()
+		}
+	}
+}
+
+// CityStats is how often a place was asked about in the window
+type CityStats struct {
+	City      string  `json:"city"`
+	Queries   int     `json:"queries"`
+	CacheHits int     `json:"cache_hits"`
+	HitRate   float64 `json:"cache_hit_rate"`
+}
+
+// ProviderStats is how a weather provider fared in the window
+type ProviderStats struct {
+	Provider     string  `json:"provider"`
+	Calls        int     `json:"calls"`
+	Errors       int     `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMS float64 `json:"avg_latency_ms"`
+}
+
+// Analytics sums up the queries served in a window
+type Analytics struct {
+	Since        time.Time       `json:"since"`
+	Queries      int             `json:"queries"`
+	BySource     map[string]int  `json:"by_source"`
+	CacheHitRate float64         `json:"cache_hit_rate"`
+	Failed       int             `json:"failed"`
+	LatencyP50MS int64           `json:"latency_p50_ms"`
+	LatencyP95MS int64           `json:"latency_p95_ms"`
+	TopCities    []CityStats     `json:"top_cities"`
+	Providers    []ProviderStats `json:"providers"`
+}
+
+// handleAnalytics sums up the queries served within window (24h by
+// default, at most 7 days), ranking the top places, limit of them.
+func handleAnalytics(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		weatherRequestDuration.WithLabelValues("GET", "/analytics").Observe(time.Since(start).Seconds())
+	}()
+
+	window := defaultAnalyticsWindow
+	if s := r.URL.Query().Get("window"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 || d > maxAnalyticsWindow {
+			weatherRequestsTotal.WithLabelValues("GET", "/analytics", "error").Inc()
+			http.Error(w, fmt.Sprintf("window must be a duration up to %s, such as 24h", maxAnalyticsWindow), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+	limit := defaultTopCities
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 100 {
+			weatherRequestsTotal.WithLabelValues("GET", "/analytics", "error").Inc()
+			http.Error(w, "limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), redisTimeout)
+	defer cancel()
+
+	since := time.Now().Add(-window)
+	entries, err := redisClient.XRange(ctx, historyStream, strconv.FormatInt(since.UnixMilli(), 10), "+").Result()
+	if err != nil {
+		weatherRequestsTotal.WithLabelValues("GET", "/analytics", "error").Inc()
+		http.Error(w, fmt.Sprintf("Failed to read query history: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	analytics := summarizeHistory(entries, limit)
+	analytics.Since = since.UTC().Truncate(time.Second)
+
+	weatherRequestsTotal.WithLabelValues("GET", "/analytics", "success").Inc()
+	writeJSONResponse(w, analytics)
+}
+
+// summarizeHistory sums up entries of the stream, ranking limit places.
+func summarizeHistory(entries []redis.XMessage, limit int) *Analytics {
+	analytics := &Analytics{BySource: map[string]int{}, TopCities: []CityStats{}, Providers: []ProviderStats{}}
+	cities := map[string]*CityStats{}
+	providers := map[string]*ProviderStats{}
+	latencies := make([]int64, 0, len(entries))
+
+	for _, entry := range entries {
+		field := func(name string) string {
+			s, _ := entry.Values[name].(string)
+			return s
+		}
+		analytics.Queries++
+		source := field("source")
+		if source != "" {
+			analytics.BySource[source]++
+		}
+		if status, _ := strconv.Atoi(field("status")); status >= 400 {
+			analytics.Failed++
+		}
+		latency, _ := strconv.ParseInt(field("latency_ms"), 10, 64)
+		latencies = append(latencies, latency)
+
+		city := cities[field("city")]
+		if city == nil {
+			city = &CityStats{City: field("city")}
+			cities[city.City] = city
+		}
+		city.Queries++
+		if source == "cache" {
+			city.CacheHits++
+		}
+
+		if name := field("provider"); name != "" {
+			provider := providers[name]
+			if provider == nil {
+				provider = &ProviderStats{Provider: name}
+				providers[name] = provider
+			}
+			provider.Calls++
+			if field("provider_error") == "true" {
+				provider.Errors++
+			}
+			provider.AvgLatencyMS += float64(latency)
+		}
+	}
+	if analytics.Queries == 0 {
+		return analytics
+	}
+
+	analytics.CacheHitRate = ratio(analytics.BySource["cache"], analytics.Queries)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	analytics.LatencyP50MS = percentile(latencies, 0.50)
+	analytics.LatencyP95MS = percentile(latencies, 0.95)
+
+	for _, city := range cities {
+		city.HitRate = ratio(city.CacheHits, city.Queries)
+		analytics.TopCities = append(analytics.TopCities, *city)
+	}
+	sort.Slice(analytics.TopCities, func(i, j int) bool {
+		a, b := analytics.TopCities[i], analytics.TopCities[j]
+		if a.Queries != b.Queries {
+			return a.Queries > b.Queries
+		}
+		return a.City < b.City
+	})
+	if len(analytics.TopCities) > limit {
+		analytics.TopCities = analytics.TopCities[:limit]
+	}
+
+	for _, provider := range providers {
+		provider.ErrorRate = ratio(provider.Errors, provider.Calls)
+		provider.AvgLatencyMS = math.Round(provider.AvgLatencyMS/float64(provider.Calls)*10) / 10
+		analytics.Providers = append(analytics.Providers, *provider)
+	}
+	sort.Slice(analytics.Providers, func(i, j int) bool {
+		return analytics.Providers[i].Provider < analytics.Providers[j].Provider
+	})
+	return analytics
+}
+
+// ratio is n/total to 3 decimals
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(n)/float64(total)*1000) / 1000
+}
+
+// percentile is the p-th of sorted latencies
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+}
+
+func parseIntEnv(key string, defaultValue int) int {
+	if value := getEnv(key, ""); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			return n
+		}
+		log.Printf("Warning: invalid %s %q, using %d", key, value, defaultValue)
+	}
+	return defaultValue
+}
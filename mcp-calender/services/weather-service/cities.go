@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+)
+
+// City names are suggested, for autocompleting the city of a request,
+// from the names the service already knows: the user's home city, the
+// favorites and the places in the geocoding cache. Nothing is looked up
+// with OpenWeatherMap, so suggesting costs no API calls and works without
+// an API key.
+
+// maxCitySuggestions bounds the names GET /cities returns
+const maxCitySuggestions = 100
+
+// geocodeScanBatch is how many cache keys each SCAN step asks Redis for
+const geocodeScanBatch = 200
+
+// handleListCities lists the known city names starting with prefix,
+// case-insensitively: the home city first, then favorites, then the
+// geocoded places, each in alphabetical order, up to limit (default and
+// at most maxCitySuggestions).
+func handleListCities(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		weatherRequestDuration.WithLabelValues("GET", "/cities").Observe(time.Since(start).Seconds())
+	}()
+
+	limit := maxCitySuggestions
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			weatherRequestsTotal.WithLabelValues("GET", "/cities", "error").Inc()
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxCitySuggestions)
+	}
+	prefix := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("prefix")))
+
+	names := []string{}
+	seen := map[string]bool{}
+	add := func(group []string) {
+		sort.Strings(group)
+		for _, name := range group {
+			key := strings.ToLower(name)
+			if name != "" && !seen[key] && strings.HasPrefix(key, prefix) {
+				seen[key] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	add([]string{getPreferences(r.Context(), auth.UserID(r)).HomeCity})
+	if favorites, err := listFavorites(r.Context(), auth.UserID(r)); err == nil {
+		// Favorites chosen by location_id have no name to suggest
+		var cities []string
+		for _, favorite := range favorites {
+			if _, err := parseLocationID(favorite); err != nil {
+				cities = append(cities, favorite)
+			}
+		}
+		add(cities)
+	}
+	add(geocodedNames(r.Context(), prefix))
+
+	more := len(names) > limit
+	if more {
+		names = names[:limit]
+	}
+	weatherRequestsTotal.WithLabelValues("GET", "/cities", "success").Inc()
+	writeJSONResponse(w, map[string]interface{}{"cities": names, "has_more": more})
+}
+
+// geocodedNames returns the names of the places in the geocoding cache
+// that were asked for by a name starting with prefix, which is lower
+// case. The cache is scanned only as long as redisTimeout allows; a slow
+// or unavailable Redis gives fewer names, not an error.
+func geocodedNames(ctx context.Context, prefix string) []string {
+	if redisClient == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	match := geocodeCacheKey(globEscaper.Replace(prefix)) + "*"
+	var names []string
+	var cursor uint64
+	for len(names) < maxCitySuggestions {
+		keys, next, err := redisClient.Scan(ctx, cursor, match, geocodeScanBatch).Result()
+		if err != nil {
+			break
+		}
+		if len(keys) > 0 {
+			values, err := redisClient.MGet(ctx, keys...).Result()
+			if err != nil {
+				break
+			}
+			for _, value := range values {
+				data, ok := value.(string)
+				if !ok {
+					continue
+				}
+				var places []location
+				if json.Unmarshal([]byte(data), &places) != nil {
+					continue
+				}
+				for _, place := range places {
+					names = append(names, place.Name)
+				}
+			}
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	return names
+}
+
+// globEscaper escapes the characters special in Redis key patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
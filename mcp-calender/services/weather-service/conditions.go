@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+)
+
+// Weather is reported the same way whichever provider it comes from: the
+// sky as one of a fixed set of conditions, an icon named after the
+// condition rather than after a provider's icon set, and the unit of each
+// measurement spelled out. Descriptions stay the provider's own words, for
+// people to read; programs go by the condition, so that a provider taking
+// over from another changes no code downstream. Each provider's codes are
+// mapped to conditions here.
+
+// Condition is the weather condition of a WeatherData or forecast slot.
+type Condition string
+
+const (
+	ConditionClear        Condition = "clear"
+	ConditionPartlyCloudy Condition = "partly_cloudy"
+	ConditionCloudy       Condition = "cloudy"
+	// ConditionFog is mist or fog, and ConditionHaze smoke, dust, sand or
+	// ash in the air
+	ConditionFog          Condition = "fog"
+	ConditionHaze         Condition = "haze"
+	ConditionDrizzle      Condition = "drizzle"
+	ConditionRain         Condition = "rain"
+	ConditionSleet        Condition = "sleet" // incl. freezing rain
+	ConditionSnow         Condition = "snow"
+	ConditionThunderstorm Condition = "thunderstorm"
+	// ConditionWind is squalls or a tornado
+	ConditionWind    Condition = "wind"
+	ConditionUnknown Condition = "unknown"
+)
+
+// Units of the measurements in metric and imperial units. Humidity is
+// always in percent.
+const (
+	celsius            = "°C"
+	fahrenheit         = "°F"
+	metersPerSecond    = "m/s"
+	milesPerHour       = "mph"
+	millimetersPerHour = "mm/h"
+	inchesPerHour      = "in/h"
+)
+
+// conditionIcon is the icon of condition, by day or at night: the
+// condition with dashes, suffixed -day or -night for clear and partly
+// cloudy skies, e.g. "partly-cloudy-night" or "rain".
+func conditionIcon(condition Condition, night bool) string {
+	icon := strings.ReplaceAll(string(condition), "_", "-")
+	switch condition {
+	case ConditionClear, ConditionPartlyCloudy:
+		if night {
+			return icon + "-night"
+		}
+		return icon + "-day"
+	}
+	return icon
+}
+
+// owmCondition maps an OpenWeatherMap weather condition id to a condition;
+// see https://openweathermap.org/weather-conditions.
+func owmCondition(id int) Condition {
+	switch {
+	case id >= 200 && id < 300:
+		return ConditionThunderstorm
+	case id >= 300 && id < 400:
+		return ConditionDrizzle
+	case id == 511:
+		return ConditionSleet
+	case id >= 500 && id < 600:
+		return ConditionRain
+	case id >= 611 && id <= 616:
+		return ConditionSleet
+	case id >= 600 && id < 700:
+		return ConditionSnow
+	case id == 701 || id == 741:
+		return ConditionFog
+	case id == 771 || id == 781:
+		return ConditionWind
+	case id >= 700 && id < 800:
+		return ConditionHaze
+	case id == 800:
+		return ConditionClear
+	case id == 801 || id == 802:
+		return ConditionPartlyCloudy
+	case id == 803 || id == 804:
+		return ConditionCloudy
+	}
+	return ConditionUnknown
+}
+
+// owmNight reports whether an OpenWeatherMap icon, such as "10n", is the
+// night one.
+func owmNight(icon string) bool {
+	return strings.HasSuffix(icon, "n")
+}
+
+// descriptionCondition guesses the condition from a description, for mock
+// data and for weather cached before conditions were reported.
+func descriptionCondition(description string) Condition {
+	description = strings.ToLower(description)
+	for _, c := range []struct {
+		word      string
+		condition Condition
+	}{
+		// Most specific first: "thunderstorm with rain" is a thunderstorm
+		{"thunder", ConditionThunderstorm},
+		{"sleet", ConditionSleet},
+		{"freezing", ConditionSleet},
+		{"snow", ConditionSnow},
+		{"drizzle", ConditionDrizzle},
+		{"rain", ConditionRain},
+		{"shower", ConditionRain},
+		{"mist", ConditionFog},
+		{"fog", ConditionFog},
+		{"haze", ConditionHaze},
+		{"smoke", ConditionHaze},
+		{"dust", ConditionHaze},
+		{"sand", ConditionHaze},
+		{"squall", ConditionWind},
+		{"tornado", ConditionWind},
+		{"partly", ConditionPartlyCloudy},
+		{"few clouds", ConditionPartlyCloudy},
+		{"scattered clouds", ConditionPartlyCloudy},
+		{"cloud", ConditionCloudy},
+		{"overcast", ConditionCloudy},
+		{"clear", ConditionClear},
+		{"sunny", ConditionClear},
+	} {
+		if strings.Contains(description, c.word) {
+			return c.condition
+		}
+	}
+	return ConditionUnknown
+}
+
+// normalizeWeather sets the condition and icon of data from its
+// description when its provider gave none.
+func normalizeWeather(data *WeatherData) {
+	if data.Condition == "" {
+		data.Condition = descriptionCondition(data.Description)
+		data.Icon = conditionIcon(data.Condition, false)
+	}
+}
+
+// normalizeForecast sets the conditions and icons of the slots of
+// forecast from their descriptions when its provider gave none.
+func normalizeForecast(forecast *cachedForecast) {
+	for i, slot := range forecast.Slots {
+		if slot.Condition == "" {
+			forecast.Slots[i].Condition = descriptionCondition(slot.Description)
+			forecast.Slots[i].Icon = conditionIcon(forecast.Slots[i].Condition, false)
+		}
+	}
+}
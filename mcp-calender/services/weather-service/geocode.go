@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// City names are looked up with the OpenWeatherMap geocoding API before
+// fetching weather. A name several places share is not guessed at: the
+// request is answered with 300 Multiple Choices listing the candidates,
+// named in the caller's language, and is made again with the location_id
+// of one of them. A location_id is the latitude and longitude of a place
+// to 4 decimals, e.g. "51.5073,-0.1276", and is also accepted by the
+// favorites.
+
+// maxCandidates bounds the places a city name is looked up among
+const maxCandidates = 5
+
+// geocodeCacheTTL is how long the places of a city name stay cached;
+// they hardly ever change
+const geocodeCacheTTL = 24 * time.Hour
+
+var errCityNotFound = errors.New("city not found")
+
+// location is a place weather is fetched for
+type location struct {
+	Name       string            `json:"name"`
+	LocalNames map[string]string `json:"local_names,omitempty"`
+	Country    string            `json:"country"`
+	State      string            `json:"state,omitempty"`
+	Lat        float64           `json:"lat"`
+	Lon        float64           `json:"lon"`
+	// geocoded is set when Name is that of the place rather than a
+	// location_id or, in mock mode, the city as asked for
+	geocoded bool
+}
+
+// id is the location_id of l.
+func (l *location) id() string {
+	return strconv.FormatFloat(l.Lat, 'f', 4, 64) + "," + strconv.FormatFloat(l.Lon, 'f', 4, 64)
+}
+
+// owmPlaceQuery is the query parameters OpenWeatherMap is asked about l
+// with.
+func owmPlaceQuery(l *location) string {
+	return fmt.Sprintf("lat=%s&lon=%s", strconv.FormatFloat(l.Lat, 'f', -1, 64), strconv.FormatFloat(l.Lon, 'f', -1, 64))
+}
+
+// placeName is the name weather for l is reported under: that of the
+// geocoded place, else the name OpenWeatherMap gives the coordinates.
+func placeName(l *location, owmName string) string {
+	if l.geocoded || owmName == "" {
+		return l.Name
+	}
+	return owmName
+}
+
+// Candidate is one of the places an ambiguous city name may refer to
+type Candidate struct {
+	LocationID string  `json:"location_id"`
+	Name       string  `json:"name"`
+	Country    string  `json:"country"`
+	State      string  `json:"state,omitempty"`
+	Lat        float64 `json:"lat"`
+	Lon        float64 `json:"lon"`
+}
+
+// ambiguousCityError is returned when several places have the name asked
+// for.
+type ambiguousCityError struct {
+	city       string
+	candidates []location
+}
+
+func (e *ambiguousCityError) Error() string {
+	return fmt.Sprintf("%d places are called %s", len(e.candidates), e.city)
+}
+
+// parseLocationID returns the place of a location_id.
+func parseLocationID(id string) (*location, error) {
+	latStr, lonStr, ok := strings.Cut(id, ",")
+	lat, latErr := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	lon, lonErr := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+	if !ok || latErr != nil || lonErr != nil || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return nil, fmt.Errorf("invalid location_id %q: expected latitude,longitude", id)
+	}
+	return &location{Name: id, Lat: lat, Lon: lon}, nil
+}
+
+// resolveLocation returns the place locationID names or, without one, the
+// only place called city. Without an API key city is taken as it is.
+func resolveLocation(ctx context.Context, city, locationID string) (*location, error) {
+	if locationID != "" {
+		return parseLocationID(locationID)
+	}
+	apiKey := getEnv("OPENWEATHER_API_KEY", "")
+	if apiKey == "" {
+		return &location{Name: city}, nil
+	}
+
+	places, err := geocodeCity(ctx, city, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	switch len(places) {
+	case 0:
+		return nil, errCityNotFound
+	case 1:
+		places[0].geocoded = true
+		return &places[0], nil
+	}
+	return nil, &ambiguousCityError{city: city, candidates: places}
+}
+
+// geocodeCity returns the distinct places called city, from the cache or
+// the geocoding API. Places the API lists more than once, under the same
+// name, country and state, are only kept once.
+func geocodeCity(ctx context.Context, city, apiKey string) ([]location, error) {
+	key := geocodeCacheKey(city)
+	if places, ok := cachedPlaces(ctx, key); ok {
+		return places, nil
+	}
+
+	apiURL := fmt.Sprintf("https://api.openweathermap.org/geo/1.0/direct?q=%s&limit=%d&appid=%s",
+		url.QueryEscape(city), maxCandidates, apiKey)
+
+	apiCtx, cancel := context.WithTimeout(ctx, openWeatherTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(apiCtx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		externalAPICallsTotal.WithLabelValues("openweathermap_geocoding", "error").Inc()
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		externalAPICallsTotal.WithLabelValues("openweathermap_geocoding", "error").Inc()
+		return nil, fmt.Errorf("geocoding request failed with status: %s", resp.Status)
+	}
+	var found []location
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		externalAPICallsTotal.WithLabelValues("openweathermap_geocoding", "error").Inc()
+		return nil, err
+	}
+	externalAPICallsTotal.WithLabelValues("openweathermap_geocoding", "success").Inc()
+
+	seen := map[string]bool{}
+	places := []location{}
+	for _, place := range found {
+		k := strings.ToLower(place.Name + "|" + place.Country + "|" + place.State)
+		if !seen[k] {
+			seen[k] = true
+			places = append(places, place)
+		}
+	}
+
+	if redisClient != nil {
+		setCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), redisTimeout)
+		defer cancel()
+		if data, err := json.Marshal(places); err == nil {
+			if err := redisClient.Set(setCtx, key, data, geocodeCacheTTL).Err(); err != nil {
+				log.Printf("Warning: Failed to cache places of %s: %v", city, err)
+			}
+		}
+	}
+	return places, nil
+}
+
+func cachedPlaces(ctx context.Context, key string) ([]location, bool) {
+	if redisClient == nil {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	data, err := redisClient.Get(ctx, key).Result()
+	if err != nil {
+		return nil, false
+	}
+	var places []location
+	if err := json.Unmarshal([]byte(data), &places); err != nil {
+		return nil, false
+	}
+	return places, true
+}
+
+func geocodeCacheKey(city string) string {
+	return fmt.Sprintf("geocode:%s", strings.ToLower(city))
+}
+
+// requestLanguage is the language candidates are named in: the lang
+// parameter, else the first language of Accept-Language.
+func requestLanguage(r *http.Request) string {
+	lang := r.URL.Query().Get("lang")
+	if lang == "" {
+		lang, _, _ = strings.Cut(r.Header.Get("Accept-Language"), ",")
+		lang, _, _ = strings.Cut(lang, ";")
+	}
+	lang, _, _ = strings.Cut(strings.TrimSpace(lang), "-")
+	return strings.ToLower(lang)
+}
+
+// candidatesOf lists places as candidates named in lang.
+func candidatesOf(places []location, lang string) []Candidate {
+	candidates := make([]Candidate, len(places))
+	for i, place := range places {
+		name := place.Name
+		if local := place.LocalNames[lang]; local != "" {
+			name = local
+		}
+		candidates[i] = Candidate{
+			LocationID: place.id(),
+			Name:       name,
+			Country:    place.Country,
+			State:      place.State,
+			Lat:        place.Lat,
+			Lon:        place.Lon,
+		}
+	}
+	return candidates
+}
+
+// writeAmbiguousCity answers 300 Multiple Choices with the candidates of
+// err named in lang.
+func writeAmbiguousCity(w http.ResponseWriter, err *ambiguousCityError, lang string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMultipleChoices)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      "ambiguous_city",
+		"message":    fmt.Sprintf("Several places are called %s; repeat the request with the location_id of one", err.city),
+		"city":       err.city,
+		"candidates": candidatesOf(err.candidates, lang),
+	})
+}
+
+// handleGeocode lists the places called q, best match first, for other
+// services such as the calendar's travel time estimates. Without an API
+// key nothing can be looked up and it answers 503.
+func handleGeocode(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		weatherRequestDuration.WithLabelValues("GET", "/geocode").Observe(time.Since(start).Seconds())
+	}()
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		weatherRequestsTotal.WithLabelValues("GET", "/geocode", "error").Inc()
+		http.Error(w, "q parameter is required", http.StatusBadRequest)
+		return
+	}
+	apiKey := getEnv("OPENWEATHER_API_KEY", "")
+	if apiKey == "" {
+		weatherRequestsTotal.WithLabelValues("GET", "/geocode", "error").Inc()
+		http.Error(w, "Geocoding needs OPENWEATHER_API_KEY", http.StatusServiceUnavailable)
+		return
+	}
+
+	places, err := geocodeCity(r.Context(), q, apiKey)
+	if err != nil {
+		weatherRequestsTotal.WithLabelValues("GET", "/geocode", "error").Inc()
+		http.Error(w, fmt.Sprintf("Failed to look up place: %v", err), http.StatusBadGateway)
+		return
+	}
+	weatherRequestsTotal.WithLabelValues("GET", "/geocode", "success").Inc()
+	writeJSONResponse(w, map[string]interface{}{"places": candidatesOf(places, requestLanguage(r))})
+}
+
+// writeLocationError answers a request whose city or location_id could not
+// be resolved, returning the status label for the metrics.
+func writeLocationError(w http.ResponseWriter, r *http.Request, err error) string {
+	var ambiguous *ambiguousCityError
+	switch {
+	case errors.As(err, &ambiguous):
+		writeAmbiguousCity(w, ambiguous, requestLanguage(r))
+		return "ambiguous"
+	case errors.Is(err, errCityNotFound):
+		http.Error(w, "City not found", http.StatusNotFound)
+		return "not_found"
+	case strings.HasPrefix(err.Error(), "invalid location_id"):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "error"
+	}
+	externalAPICallsTotal.WithLabelValues("openweathermap", "error").Inc()
+	http.Error(w, fmt.Sprintf("Failed to look up city: %v", err), http.StatusInternalServerError)
+	return "error"
+}
+
+// weatherQuery returns the place r asks about: location_id, else city,
+// else the home city, and the key it is cached under.
+func weatherQuery(r *http.Request, prefs Preferences) (city, locationID, cacheKey string) {
+	locationID = strings.TrimSpace(r.URL.Query().Get("location_id"))
+	city = r.URL.Query().Get("city")
+	if city == "" && locationID == "" {
+		city = prefs.HomeCity
+	}
+	if locationID != "" {
+		return city, locationID, locationID
+	}
+	return city, "", city
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,6 +13,9 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/clock"
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/health"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
@@ -23,11 +27,26 @@ type WeatherData struct {
 	City        string  `json:"city"`
 	Country     string  `json:"country"`
 	Temperature float64 `json:"temperature"`
-	Description string  `json:"description"`
-	Humidity    int     `json:"humidity"`
-	WindSpeed   float64 `json:"wind_speed"`
-	Timestamp   int64   `json:"timestamp"`
-	Source      string  `json:"source"` // "api" or "cache"
+	// Description is the provider's, and Condition and Icon the same
+	// whichever provider the weather is from; see conditions.go.
+	Description string    `json:"description"`
+	Condition   Condition `json:"condition"`
+	Icon        string    `json:"icon"`
+	Humidity    int       `json:"humidity"`
+	WindSpeed   float64   `json:"wind_speed"`
+	Timestamp   int64     `json:"timestamp"`
+	// Source is "api", "cache" or "mock", or "local" when the temperature
+	// and humidity are those of the user's own sensor; see observations.go.
+	Source string `json:"source"`
+	// Station names the sensor of a local reading.
+	Station string `json:"station,omitempty"`
+	Units   string `json:"units"` // "metric" or "imperial"
+	// TemperatureUnit is "°C" or "°F" and WindSpeedUnit "m/s" or "mph".
+	TemperatureUnit string `json:"temperature_unit"`
+	WindSpeedUnit   string `json:"wind_speed_unit"`
+	// LocationID identifies the place the weather is for; see geocode.go.
+	// It is unset in mock mode.
+	LocationID string `json:"location_id,omitempty"`
 }
 
 // OpenWeatherMap API response structure
@@ -41,16 +60,121 @@ type OpenWeatherResponse struct {
 		Humidity int     `json:"humidity"`
 	} `json:"main"`
 	Weather []struct {
+		ID          int    `json:"id"`
 		Description string `json:"description"`
+		Icon        string `json:"icon"`
 	} `json:"weather"`
 	Wind struct {
 		Speed float64 `json:"speed"`
 	} `json:"wind"`
 }
 
+// ForecastData is the forecast for a time window, e.g. that of a
+// calendar event, summarized over the forecast slots it overlaps.
+type ForecastData struct {
+	City           string    `json:"city"`
+	Country        string    `json:"country"`
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	TemperatureMin float64   `json:"temperature_min"`
+	TemperatureMax float64   `json:"temperature_max"`
+	// Description, Condition and Icon are those of the wettest slot in
+	// the window.
+	Description string    `json:"description"`
+	Condition   Condition `json:"condition"`
+	Icon        string    `json:"icon"`
+	// PrecipitationChance is the highest chance of rain or snow in the
+	// window, from 0 to 1.
+	PrecipitationChance float64 `json:"precipitation_chance"`
+	WindSpeed           float64 `json:"wind_speed"`
+	Source              string  `json:"source"` // "api", "cache" or "mock"
+	Units               string  `json:"units"`  // "metric" or "imperial"
+	TemperatureUnit     string  `json:"temperature_unit"`
+	WindSpeedUnit       string  `json:"wind_speed_unit"`
+	LocationID          string  `json:"location_id,omitempty"`
+}
+
+// Preferences are a user's weather settings. Requests without a city use
+// HomeCity, and temperatures and wind speeds are reported in Units.
+type Preferences struct {
+	HomeCity string `json:"home_city,omitempty"`
+	// Units is "metric" (°C, m/s) or "imperial" (°F, mph).
+	Units string `json:"units"`
+}
+
+// ForecastSlot is one step of the OpenWeatherMap 5 day forecast, starting
+// at Time and lasting forecastStep.
+type ForecastSlot struct {
+	Time                time.Time `json:"time"`
+	Temperature         float64   `json:"temperature"`
+	Description         string    `json:"description"`
+	Condition           Condition `json:"condition"`
+	Icon                string    `json:"icon"`
+	PrecipitationChance float64   `json:"precipitation_chance"`
+	WindSpeed           float64   `json:"wind_speed"`
+}
+
+// cachedForecast is the forecast of a city as cached in Redis.
+type cachedForecast struct {
+	City       string `json:"city"`
+	Country    string `json:"country"`
+	LocationID string `json:"location_id,omitempty"`
+	// UTCOffset is the offset of the place from UTC, in seconds, so that
+	// its days can be told apart; 0 in mock mode.
+	UTCOffset int            `json:"utc_offset,omitempty"`
+	Slots     []ForecastSlot `json:"slots"`
+	Source    string         `json:"source"`
+}
+
+// OpenWeatherMap 5 day / 3 hour forecast response structure
+type OpenWeatherForecastResponse struct {
+	List []struct {
+		Dt   int64 `json:"dt"`
+		Main struct {
+			Temp float64 `json:"temp"`
+		} `json:"main"`
+		Weather []struct {
+			ID          int    `json:"id"`
+			Description string `json:"description"`
+			Icon        string `json:"icon"`
+		} `json:"weather"`
+		Wind struct {
+			Speed float64 `json:"speed"`
+		} `json:"wind"`
+		Pop float64 `json:"pop"`
+	} `json:"list"`
+	City struct {
+		Name    string `json:"name"`
+		Country string `json:"country"`
+		// Timezone is the offset of the place from UTC, in seconds.
+		Timezone int `json:"timezone"`
+	} `json:"city"`
+}
+
+// forecastStep is the length of one forecast slot.
+const forecastStep = 3 * time.Hour
+
+// Timeouts of the calls a request makes; each also stops when the client
+// goes away
+const (
+	redisTimeout       = 2 * time.Second
+	openWeatherTimeout = 10 * time.Second
+)
+
+// How long weather and forecasts stay cached. Forecasts are updated every
+// 3 hours.
+const (
+	weatherCacheTTL  = 10 * time.Minute
+	forecastCacheTTL = 30 * time.Minute
+)
+
 // Redis client
 var redisClient *redis.Client
 
+// mockClock is the time of the mock weather, which tests can set on
+// /mock/clock when MOCK_NOW or MOCK_TIME_TRAVEL is set
+var mockClock = clock.FromEnv()
+
 // Prometheus metrics
 var (
 	weatherRequestsTotal = prometheus.NewCounterVec(
@@ -97,15 +221,45 @@ func init() {
 }
 
 func main() {
+	// Initialize database, which may start after this service
+	if err := initDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
 	// Initialize Redis
 	initRedis()
 	defer redisClient.Close()
 
+	// Without Redis the service still serves weather, uncached
+	checker := health.NewChecker("Weather Service",
+		health.Dependency{Name: "postgres", Check: db.PingContext},
+		health.Dependency{Name: "redis", Check: pingRedis, Optional: true})
+
 	router := mux.NewRouter()
+	router.Use(auth.ConfigFromEnv().Middleware(health.PublicPaths("/metrics")...))
 
 	// Weather endpoints
 	router.HandleFunc("/weather", handleGetWeather).Methods("GET")
-	router.HandleFunc("/health", handleHealth).Methods("GET")
+	router.HandleFunc("/weather/nowcast", handleGetNowcast).Methods("GET")
+	router.HandleFunc("/weather/summary", handleGetWeatherSummary).Methods("GET")
+	router.HandleFunc("/forecast", handleGetForecast).Methods("GET")
+	router.HandleFunc("/preferences", handleGetPreferences).Methods("GET")
+	router.HandleFunc("/preferences", handleUpdatePreferences).Methods("PUT")
+	router.HandleFunc("/geocode", handleGeocode).Methods("GET")
+	router.HandleFunc("/cities", handleListCities).Methods("GET")
+	router.HandleFunc("/analytics", handleAnalytics).Methods("GET")
+	router.HandleFunc("/favorites", handleListFavorites).Methods("GET")
+	router.HandleFunc("/favorites", handleAddFavorite).Methods("POST")
+	router.HandleFunc("/favorites/{city}", handleDeleteFavorite).Methods("DELETE")
+	router.HandleFunc("/observations", handleAddObservation).Methods("POST")
+	for _, path := range health.Paths {
+		router.Handle(path, checker).Methods("GET")
+	}
+
+	if mockClock.Enabled {
+		router.HandleFunc("/mock/clock", mockClock.Handle).Methods("GET", "PUT", "DELETE")
+	}
 
 	// Metrics endpoint
 	router.Handle("/metrics", promhttp.Handler())
@@ -124,12 +278,25 @@ func main() {
 		}
 	}()
 
+	// Give the database and Redis started alongside this service time to
+	// come up, and report ready once the preferences table exists
+	if err := checker.Start(context.Background(), createTables); err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+
+	// Keep the favorite cities warm in the cache
+	warmCtx, stopWarmer := context.WithCancel(context.Background())
+	moveSharedFavorites(warmCtx)
+	go runWarmer(warmCtx)
+	go runHistoryWriter(warmCtx)
+
 	// Wait for interrupt signal
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
 
 	log.Println("Shutting down gracefully...")
+	stopWarmer()
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -155,74 +322,231 @@ func initRedis() {
 		Password: redisPassword,
 		DB:       redisDB,
 	})
+}
 
-	// Test connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := redisClient.Ping(ctx).Result()
-	if err != nil {
-		log.Printf("Warning: Failed to connect to Redis: %v", err)
-		log.Println("Weather service will work without caching")
-	} else {
-		log.Println("Connected to Redis cache")
-	}
+func pingRedis(ctx context.Context) error {
+	return redisClient.Ping(ctx).Err()
 }
 
 func handleGetWeather(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
+	served := newServedQuery(w, "/weather")
+	w = served
 	defer func() {
 		weatherRequestDuration.WithLabelValues("GET", "/weather").Observe(time.Since(start).Seconds())
+		served.record(start)
 	}()
 
-	city := r.URL.Query().Get("city")
-	if city == "" {
+	prefs := getPreferences(r.Context(), auth.UserID(r))
+	city, locationID, cacheKey := weatherQuery(r, prefs)
+	served.city = cacheKey
+	if cacheKey == "" {
 		weatherRequestsTotal.WithLabelValues("GET", "/weather", "error").Inc()
-		http.Error(w, "City parameter is required", http.StatusBadRequest)
+		http.Error(w, "City or location_id parameter is required when no home city is set", http.StatusBadRequest)
 		return
 	}
 
-	// Check cache first
-	weatherData, err := getWeatherFromCache(city)
+	weatherData, err := loadWeather(r.Context(), served, city, locationID, cacheKey)
+	if err != nil {
+		status := writeLoadError(w, r, err, "weather")
+		weatherRequestsTotal.WithLabelValues("GET", "/weather", status).Inc()
+		return
+	}
+
+	weatherRequestsTotal.WithLabelValues("GET", "/weather", "success").Inc()
+	blendObservation(r.Context(), auth.UserID(r), city, locationID, weatherData)
+	writeJSONResponse(w, weatherInUnits(weatherData, prefs.Units))
+}
+
+// providerError is a failed call to the weather provider, as opposed to a
+// place that cannot be resolved.
+type providerError struct {
+	err error
+}
+
+func (e *providerError) Error() string { return e.err.Error() }
+
+func (e *providerError) Unwrap() error { return e.err }
+
+// writeLoadError answers a request whose weather, or forecast as what
+// names it, failed to load with err, and returns the status of the
+// request for weatherRequestsTotal.
+func writeLoadError(w http.ResponseWriter, r *http.Request, err error, what string) string {
+	var provErr *providerError
+	if errors.As(err, &provErr) {
+		http.Error(w, fmt.Sprintf("Failed to get %s data: %v", what, provErr.err), http.StatusInternalServerError)
+		return "error"
+	}
+	return writeLocationError(w, r, err)
+}
+
+// loadWeather returns the current weather of the place, from the cache or
+// else from the provider, caching it then. It fails with a *providerError
+// when the provider does, and with the error of resolveLocation when the
+// place cannot be resolved.
+func loadWeather(ctx context.Context, served *servedQuery, city, locationID, cacheKey string) (*WeatherData, error) {
+	weatherData, err := getWeatherFromCache(ctx, cacheKey)
 	if err == nil {
 		cacheHitsTotal.Inc()
-		weatherRequestsTotal.WithLabelValues("GET", "/weather", "success").Inc()
-		writeJSONResponse(w, weatherData)
-		return
+		served.source = weatherData.Source
+		return weatherData, nil
 	}
 
 	cacheMissesTotal.Inc()
 
+	loc, err := resolveLocation(ctx, city, locationID)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get from OpenWeatherMap API
-	weatherData, err = getWeatherFromAPI(city)
+	weatherData, err = getWeatherFromAPI(ctx, loc)
 	if err != nil {
-		weatherRequestsTotal.WithLabelValues("GET", "/weather", "error").Inc()
+		served.fetched("", err)
 		externalAPICallsTotal.WithLabelValues("openweathermap", "error").Inc()
-		http.Error(w, fmt.Sprintf("Failed to get weather data: %v", err), http.StatusInternalServerError)
-		return
+		return nil, &providerError{err}
 	}
 
-	// Cache the result
-	if err := cacheWeatherData(city, weatherData); err != nil {
+	served.fetched(weatherData.Source, nil)
+	externalAPICallsTotal.WithLabelValues("openweathermap", "success").Inc()
+	log.Printf("api weather data: %v", weatherData)
+
+	// Cache the result, even if the client has gone away meanwhile
+	if err := cacheWeatherData(context.WithoutCancel(ctx), cacheKey, weatherData); err != nil {
 		log.Printf("Warning: Failed to cache weather data: %v", err)
 	}
+	return weatherData, nil
+}
 
-	weatherRequestsTotal.WithLabelValues("GET", "/weather", "success").Inc()
+// handleGetForecast returns the forecast for city, or location_id, between
+// start and end (RFC3339). Without end the window is the hour from start,
+// and without either place it is the user's home city.
+func handleGetForecast(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	served := newServedQuery(w, "/forecast")
+	w = served
+	defer func() {
+		weatherRequestDuration.WithLabelValues("GET", "/forecast").Observe(time.Since(start).Seconds())
+		served.record(start)
+	}()
+
+	prefs := getPreferences(r.Context(), auth.UserID(r))
+	city, locationID, cacheKey := weatherQuery(r, prefs)
+	served.city = cacheKey
+	from, err := time.Parse(time.RFC3339, r.URL.Query().Get("start"))
+	if cacheKey == "" || err != nil {
+		weatherRequestsTotal.WithLabelValues("GET", "/forecast", "error").Inc()
+		http.Error(w, "City and start (RFC3339) parameters are required", http.StatusBadRequest)
+		return
+	}
+	to := from.Add(time.Hour)
+	if endStr := r.URL.Query().Get("end"); endStr != "" {
+		if to, err = time.Parse(time.RFC3339, endStr); err != nil || !to.After(from) {
+			weatherRequestsTotal.WithLabelValues("GET", "/forecast", "error").Inc()
+			http.Error(w, "End must be an RFC3339 time after start", http.StatusBadRequest)
+			return
+		}
+	}
+
+	forecast, err := loadForecast(r.Context(), served, city, locationID, cacheKey)
+	if err != nil {
+		status := writeLoadError(w, r, err, "forecast")
+		weatherRequestsTotal.WithLabelValues("GET", "/forecast", status).Inc()
+		return
+	}
+
+	data := summarizeForecast(forecast, from, to)
+	if data == nil {
+		weatherRequestsTotal.WithLabelValues("GET", "/forecast", "not_found").Inc()
+		http.Error(w, "No forecast available for that time window", http.StatusNotFound)
+		return
+	}
+	weatherRequestsTotal.WithLabelValues("GET", "/forecast", "success").Inc()
+	writeJSONResponse(w, forecastInUnits(data, prefs.Units))
+}
+
+// loadForecast returns the forecast of the place as loadWeather does its
+// current weather.
+func loadForecast(ctx context.Context, served *servedQuery, city, locationID, cacheKey string) (*cachedForecast, error) {
+	forecast, err := getForecastFromCache(ctx, cacheKey)
+	if err == nil {
+		cacheHitsTotal.Inc()
+		served.source = forecast.Source
+		return forecast, nil
+	}
+
+	cacheMissesTotal.Inc()
+	loc, err := resolveLocation(ctx, city, locationID)
+	if err != nil {
+		return nil, err
+	}
+	forecast, err = getForecastFromAPI(ctx, loc)
+	if err != nil {
+		served.fetched("", err)
+		externalAPICallsTotal.WithLabelValues("openweathermap", "error").Inc()
+		return nil, &providerError{err}
+	}
 	externalAPICallsTotal.WithLabelValues("openweathermap", "success").Inc()
-	log.Printf("api weather data: %v", weatherData)
-	writeJSONResponse(w, weatherData)
+	served.fetched(forecast.Source, nil)
+	if err := cacheForecast(context.WithoutCancel(ctx), cacheKey, forecast); err != nil {
+		log.Printf("Warning: Failed to cache forecast data: %v", err)
+	}
+	return forecast, nil
+}
+
+// summarizeForecast sums up the slots of forecast overlapping from-to, or
+// returns nil when the forecast does not reach that far.
+func summarizeForecast(forecast *cachedForecast, from, to time.Time) *ForecastData {
+	var data *ForecastData
+	for _, slot := range forecast.Slots {
+		if !slot.Time.Before(to) || !slot.Time.Add(forecastStep).After(from) {
+			continue
+		}
+		if data == nil {
+			data = &ForecastData{
+				City:           forecast.City,
+				Country:        forecast.Country,
+				LocationID:     forecast.LocationID,
+				Start:          from,
+				End:            to,
+				TemperatureMin: slot.Temperature,
+				TemperatureMax: slot.Temperature,
+				Description:    slot.Description,
+				Condition:      slot.Condition,
+				Icon:           slot.Icon,
+			}
+		}
+		if slot.Temperature < data.TemperatureMin {
+			data.TemperatureMin = slot.Temperature
+		}
+		if slot.Temperature > data.TemperatureMax {
+			data.TemperatureMax = slot.Temperature
+		}
+		if slot.PrecipitationChance > data.PrecipitationChance {
+			data.PrecipitationChance = slot.PrecipitationChance
+			data.Description = slot.Description
+			data.Condition = slot.Condition
+			data.Icon = slot.Icon
+		}
+		if slot.WindSpeed > data.WindSpeed {
+			data.WindSpeed = slot.WindSpeed
+		}
+	}
+	if data != nil {
+		data.Source = forecast.Source
+	}
+	return data
 }
 
-func getWeatherFromCache(city string) (*WeatherData, error) {
+func getWeatherFromCache(ctx context.Context, city string) (*WeatherData, error) {
 	if redisClient == nil {
 		return nil, fmt.Errorf("redis not available")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
 	defer cancel()
 
-	cacheKey := fmt.Sprintf("weather:%s", city)
-	data, err := redisClient.Get(ctx, cacheKey).Result()
+	data, err := redisClient.Get(ctx, weatherCacheKey(city)).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -232,41 +556,49 @@ func getWeatherFromCache(city string) (*WeatherData, error) {
 		return nil, err
 	}
 
+	normalizeWeather(&weatherData)
 	weatherData.Source = "cache"
 	return &weatherData, nil
 }
 
-func cacheWeatherData(city string, data *WeatherData) error {
+func cacheWeatherData(ctx context.Context, city string, data *WeatherData) error {
 	if redisClient == nil {
 		return nil // No error if Redis is not available
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
 	defer cancel()
 
-	cacheKey := fmt.Sprintf("weather:%s", city)
 	dataBytes, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
+	return redisClient.Set(ctx, weatherCacheKey(city), dataBytes, weatherCacheTTL).Err()
+}
 
-	// Cache for 10 minutes
-	ttl := 10 * time.Minute
-	return redisClient.Set(ctx, cacheKey, dataBytes, ttl).Err()
+func weatherCacheKey(city string) string {
+	return fmt.Sprintf("weather:%s", city)
 }
 
-func getWeatherFromAPI(city string) (*WeatherData, error) {
+func getWeatherFromAPI(ctx context.Context, loc *location) (*WeatherData, error) {
 	apiKey := getEnv("OPENWEATHER_API_KEY", "")
 	if apiKey == "" {
 		// Return mock data if no API key is configured
 		log.Println("Warning: OPENWEATHER_API_KEY not configured, returning mock data")
-		return getMockWeatherData(city), nil
+		return getMockWeatherData(loc.Name), nil
 	}
 
-	url := fmt.Sprintf("https://api.openweathermap.org/data/2.5/weather?q=%s&appid=%s&units=metric", city, apiKey)
+	apiURL := fmt.Sprintf("https://api.openweathermap.org/data/2.5/weather?%s&appid=%s&units=metric",
+		owmPlaceQuery(loc), apiKey)
+
+	ctx, cancel := context.WithTimeout(ctx, openWeatherTimeout)
+	defer cancel()
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -281,20 +613,25 @@ func getWeatherFromAPI(city string) (*WeatherData, error) {
 		return nil, err
 	}
 
-	description := "Clear"
+	description, condition, icon := "Clear", ConditionClear, conditionIcon(ConditionClear, false)
 	if len(owmResp.Weather) > 0 {
 		description = owmResp.Weather[0].Description
+		condition = owmCondition(owmResp.Weather[0].ID)
+		icon = conditionIcon(condition, owmNight(owmResp.Weather[0].Icon))
 	}
 
 	return &WeatherData{
-		City:        owmResp.Name,
+		City:        placeName(loc, owmResp.Name),
 		Country:     owmResp.Sys.Country,
 		Temperature: owmResp.Main.Temp,
 		Description: description,
+		Condition:   condition,
+		Icon:        icon,
 		Humidity:    owmResp.Main.Humidity,
 		WindSpeed:   owmResp.Wind.Speed,
 		Timestamp:   time.Now().Unix(),
 		Source:      "api",
+		LocationID:  loc.id(),
 	}, nil
 }
 
@@ -314,36 +651,190 @@ func getMockWeatherData(city string) *WeatherData {
 	if t, ok := temps[city]; ok {
 		temp = t
 	}
+	now := mockClock.Now()
 
-	return &WeatherData{
+	data := &WeatherData{
 		City:        city,
 		Country:     "XX",
 		Temperature: temp,
-		Description: descriptions[int(time.Now().Unix())%len(descriptions)],
+		Description: descriptions[int(now.Unix())%len(descriptions)],
 		Humidity:    65,
 		WindSpeed:   5.2,
-		Timestamp:   time.Now().Unix(),
+		Timestamp:   now.Unix(),
 		Source:      "mock",
 	}
+	normalizeWeather(data)
+	return data
 }
 
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	health := map[string]interface{}{
-		"status": "healthy",
-		"redis":  "disconnected",
+func getForecastFromCache(ctx context.Context, city string) (*cachedForecast, error) {
+	if redisClient == nil {
+		return nil, fmt.Errorf("redis not available")
 	}
 
-	// Check Redis connection
-	if redisClient != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		defer cancel()
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
 
-		if _, err := redisClient.Ping(ctx).Result(); err == nil {
-			health["redis"] = "connected"
+	data, err := redisClient.Get(ctx, forecastCacheKey(city)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var forecast cachedForecast
+	if err := json.Unmarshal([]byte(data), &forecast); err != nil {
+		return nil, err
+	}
+
+	normalizeForecast(&forecast)
+	forecast.Source = "cache"
+	return &forecast, nil
+}
+
+func cacheForecast(ctx context.Context, city string, forecast *cachedForecast) error {
+	if redisClient == nil {
+		return nil // No error if Redis is not available
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	dataBytes, err := json.Marshal(forecast)
+	if err != nil {
+		return err
+	}
+	return redisClient.Set(ctx, forecastCacheKey(city), dataBytes, forecastCacheTTL).Err()
+}
+
+func forecastCacheKey(city string) string {
+	return fmt.Sprintf("forecast:%s", city)
+}
+
+func getForecastFromAPI(ctx context.Context, loc *location) (*cachedForecast, error) {
+	apiKey := getEnv("OPENWEATHER_API_KEY", "")
+	if apiKey == "" {
+		// Return mock data if no API key is configured
+		log.Println("Warning: OPENWEATHER_API_KEY not configured, returning mock forecast")
+		return getMockForecast(loc.Name), nil
+	}
+
+	apiURL := fmt.Sprintf("https://api.openweathermap.org/data/2.5/forecast?%s&appid=%s&units=metric",
+		owmPlaceQuery(loc), apiKey)
+
+	ctx, cancel := context.WithTimeout(ctx, openWeatherTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed with status: %s", resp.Status)
+	}
+
+	var owmResp OpenWeatherForecastResponse
+	if err := json.NewDecoder(resp.Body).Decode(&owmResp); err != nil {
+		return nil, err
+	}
+
+	forecast := &cachedForecast{
+		City:       placeName(loc, owmResp.City.Name),
+		Country:    owmResp.City.Country,
+		LocationID: loc.id(),
+		UTCOffset:  owmResp.City.Timezone,
+		Source:     "api",
+	}
+	for _, item := range owmResp.List {
+		description, condition, icon := "Clear", ConditionClear, conditionIcon(ConditionClear, false)
+		if len(item.Weather) > 0 {
+			description = item.Weather[0].Description
+			condition = owmCondition(item.Weather[0].ID)
+			icon = conditionIcon(condition, owmNight(item.Weather[0].Icon))
 		}
+		forecast.Slots = append(forecast.Slots, ForecastSlot{
+			Time:                time.Unix(item.Dt, 0).UTC(),
+			Temperature:         item.Main.Temp,
+			Description:         description,
+			Condition:           condition,
+			Icon:                icon,
+			PrecipitationChance: item.Pop,
+			WindSpeed:           item.Wind.Speed,
+		})
 	}
+	return forecast, nil
+}
+
+// dataNow returns the time the weather data is of: the mock clock's in mock
+// mode, where the data is dated by it.
+func dataNow() time.Time {
+	if getEnv("OPENWEATHER_API_KEY", "") == "" {
+		return mockClock.Now()
+	}
+	return time.Now()
+}
+
+func getMockForecast(city string) *cachedForecast {
+	// Generate five days of mock slots, rotating through the descriptions
+	descriptions := []string{"Sunny", "Cloudy", "Rainy", "Partly cloudy", "Clear"}
+	chances := map[string]float64{"Rainy": 0.8, "Cloudy": 0.3, "Partly cloudy": 0.1}
+
+	current := getMockWeatherData(city)
+	forecast := &cachedForecast{City: city, Country: current.Country, Source: "mock"}
+	first := mockClock.Now().UTC().Truncate(forecastStep)
+	for i := 0; i < 40; i++ {
+		slotTime := first.Add(time.Duration(i) * forecastStep)
+		description := descriptions[int(slotTime.Unix()/int64(forecastStep/time.Second))%len(descriptions)]
+		forecast.Slots = append(forecast.Slots, ForecastSlot{
+			Time:                slotTime,
+			Temperature:         current.Temperature + float64(i%8-4),
+			Description:         description,
+			PrecipitationChance: chances[description],
+			WindSpeed:           current.WindSpeed,
+		})
+	}
+	normalizeForecast(forecast)
+	return forecast
+}
+
+// weatherInUnits converts data, which is always fetched and cached in
+// metric units, to units.
+func weatherInUnits(data *WeatherData, units string) *WeatherData {
+	data.Units = "metric"
+	data.TemperatureUnit, data.WindSpeedUnit = celsius, metersPerSecond
+	if units == "imperial" {
+		data.Units = units
+		data.TemperatureUnit, data.WindSpeedUnit = fahrenheit, milesPerHour
+		data.Temperature = celsiusToFahrenheit(data.Temperature)
+		data.WindSpeed = msToMph(data.WindSpeed)
+	}
+	return data
+}
+
+// forecastInUnits converts data from metric units to units.
+func forecastInUnits(data *ForecastData, units string) *ForecastData {
+	data.Units = "metric"
+	data.TemperatureUnit, data.WindSpeedUnit = celsius, metersPerSecond
+	if units == "imperial" {
+		data.Units = units
+		data.TemperatureUnit, data.WindSpeedUnit = fahrenheit, milesPerHour
+		data.TemperatureMin = celsiusToFahrenheit(data.TemperatureMin)
+		data.TemperatureMax = celsiusToFahrenheit(data.TemperatureMax)
+		data.WindSpeed = msToMph(data.WindSpeed)
+	}
+	return data
+}
+
+func celsiusToFahrenheit(c float64) float64 {
+	return c*9/5 + 32
+}
 
-	writeJSONResponse(w, health)
+func msToMph(ms float64) float64 {
+	return ms * 2.237
 }
 
 func writeJSONResponse(w http.ResponseWriter, data interface{}) {
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+)
+
+// GET /weather/nowcast answers "will it rain soon?" from the minute by
+// minute precipitation forecast of OpenWeatherMap's One Call 3.0 API,
+// which covers the next hour. One Call is billed per call beyond a daily
+// allowance, so nowcasts are cached briefly and never warmed; a cached
+// nowcast is served from the current minute on, the minutes gone by left
+// out. Minute forecasts only exist for some regions, elsewhere the
+// endpoint answers 404.
+
+// nowcastCacheTTL is how long nowcasts stay cached. OpenWeatherMap
+// updates them every few minutes.
+const nowcastCacheTTL = 2 * time.Minute
+
+// rainThreshold is the precipitation, in mm/h, from which a minute counts
+// as rainy; below it is mist rather than rain
+const rainThreshold = 0.1
+
+// NowcastMinute is the precipitation forecast for the minute from Time.
+type NowcastMinute struct {
+	Time time.Time `json:"time"`
+	// Precipitation is in mm/h, or in/h in imperial units.
+	Precipitation float64 `json:"precipitation"`
+}
+
+// Nowcast is the precipitation of the next hour, minute by minute, with
+// whether and when it will rain.
+type Nowcast struct {
+	City       string `json:"city"`
+	Country    string `json:"country,omitempty"`
+	LocationID string `json:"location_id,omitempty"`
+	// RainingNow is whether the current minute is rainy.
+	RainingNow bool `json:"raining_now"`
+	// WillRain is whether any minute of the window is rainy.
+	WillRain bool `json:"will_rain"`
+	// RainStartsIn is the minutes until it starts raining, when it is dry
+	// now, and RainStopsIn those until it stops, when it is raining now.
+	// Each is unset when it does not happen within the window.
+	RainStartsIn *int `json:"rain_starts_in,omitempty"`
+	RainStopsIn  *int `json:"rain_stops_in,omitempty"`
+	// PeakPrecipitation is the heaviest precipitation of the window.
+	PeakPrecipitation float64 `json:"peak_precipitation"`
+	// WindowMinutes is how many minutes ahead the nowcast reaches.
+	WindowMinutes int             `json:"window_minutes"`
+	Minutes       []NowcastMinute `json:"minutes"`
+	Source        string          `json:"source"` // "api", "cache" or "mock"
+	Units         string          `json:"units"`  // "metric" or "imperial"
+	// PrecipitationUnit is "mm/h" or "in/h".
+	PrecipitationUnit string `json:"precipitation_unit"`
+}
+
+// cachedNowcast is the nowcast of a place as cached in Redis.
+type cachedNowcast struct {
+	City       string          `json:"city"`
+	Country    string          `json:"country,omitempty"`
+	LocationID string          `json:"location_id,omitempty"`
+	Minutes    []NowcastMinute `json:"minutes"`
+	Source     string          `json:"source"`
+}
+
+// OpenWeatherMap One Call 3.0 response structure, with only the minutely
+// forecast asked for
+type OpenWeatherOneCallResponse struct {
+	Minutely []struct {
+		Dt            int64   `json:"dt"`
+		Precipitation float64 `json:"precipitation"`
+	} `json:"minutely"`
+}
+
+// handleGetNowcast returns the precipitation of the next hour for city, or
+// location_id, and without either for the user's home city.
+func handleGetNowcast(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	served := newServedQuery(w, "/weather/nowcast")
+	w = served
+	defer func() {
+		weatherRequestDuration.WithLabelValues("GET", "/weather/nowcast").Observe(time.Since(start).Seconds())
+		served.record(start)
+	}()
+
+	prefs := getPreferences(r.Context(), auth.UserID(r))
+	city, locationID, cacheKey := weatherQuery(r, prefs)
+	served.city = cacheKey
+	if cacheKey == "" {
+		weatherRequestsTotal.WithLabelValues("GET", "/weather/nowcast", "error").Inc()
+		http.Error(w, "City or location_id parameter is required when no home city is set", http.StatusBadRequest)
+		return
+	}
+
+	nowcast, err := getNowcastFromCache(r.Context(), cacheKey)
+	if err == nil {
+		cacheHitsTotal.Inc()
+		served.source = nowcast.Source
+	} else {
+		cacheMissesTotal.Inc()
+		loc, err := resolveLocation(r.Context(), city, locationID)
+		if err != nil {
+			status := writeLocationError(w, r, err)
+			weatherRequestsTotal.WithLabelValues("GET", "/weather/nowcast", status).Inc()
+			return
+		}
+		nowcast, err = getNowcastFromAPI(r.Context(), loc)
+		if err != nil {
+			served.fetched("", err)
+			weatherRequestsTotal.WithLabelValues("GET", "/weather/nowcast", "error").Inc()
+			externalAPICallsTotal.WithLabelValues("openweathermap_onecall", "error").Inc()
+			http.Error(w, fmt.Sprintf("Failed to get nowcast data: %v", err), http.StatusInternalServerError)
+			return
+		}
+		externalAPICallsTotal.WithLabelValues("openweathermap_onecall", "success").Inc()
+		served.fetched(nowcast.Source, nil)
+		if err := cacheNowcast(context.WithoutCancel(r.Context()), cacheKey, nowcast); err != nil {
+			log.Printf("Warning: Failed to cache nowcast data: %v", err)
+		}
+	}
+
+	data := summarizeNowcast(nowcast, dataNow())
+	if data == nil {
+		weatherRequestsTotal.WithLabelValues("GET", "/weather/nowcast", "not_found").Inc()
+		http.Error(w, "No minute-level precipitation forecast available for this place", http.StatusNotFound)
+		return
+	}
+	weatherRequestsTotal.WithLabelValues("GET", "/weather/nowcast", "success").Inc()
+	writeJSONResponse(w, nowcastInUnits(data, prefs.Units))
+}
+
+// summarizeNowcast returns the nowcast of the minutes from the one now is
+// in, or nil when none are left.
+func summarizeNowcast(nowcast *cachedNowcast, now time.Time) *Nowcast {
+	current := now.Truncate(time.Minute)
+	var minutes []NowcastMinute
+	for _, m := range nowcast.Minutes {
+		if !m.Time.Before(current) {
+			minutes = append(minutes, m)
+		}
+	}
+	if len(minutes) == 0 {
+		return nil
+	}
+
+	data := &Nowcast{
+		City:          nowcast.City,
+		Country:       nowcast.Country,
+		LocationID:    nowcast.LocationID,
+		RainingNow:    minutes[0].Precipitation >= rainThreshold,
+		WindowMinutes: len(minutes),
+		Minutes:       minutes,
+		Source:        nowcast.Source,
+	}
+	for i, m := range minutes {
+		rainy := m.Precipitation >= rainThreshold
+		if rainy {
+			data.WillRain = true
+		}
+		if m.Precipitation > data.PeakPrecipitation {
+			data.PeakPrecipitation = m.Precipitation
+		}
+		switch {
+		case rainy && !data.RainingNow && data.RainStartsIn == nil:
+			in := i
+			data.RainStartsIn = &in
+		case !rainy && data.RainingNow && data.RainStopsIn == nil:
+			in := i
+			data.RainStopsIn = &in
+		}
+	}
+	return data
+}
+
+func getNowcastFromCache(ctx context.Context, city string) (*cachedNowcast, error) {
+	if redisClient == nil {
+		return nil, fmt.Errorf("redis not available")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	data, err := redisClient.Get(ctx, nowcastCacheKey(city)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var nowcast cachedNowcast
+	if err := json.Unmarshal([]byte(data), &nowcast); err != nil {
+		return nil, err
+	}
+
+	nowcast.Source = "cache"
+	return &nowcast, nil
+}
+
+func cacheNowcast(ctx context.Context, city string, nowcast *cachedNowcast) error {
+	if redisClient == nil {
+		return nil // No error if Redis is not available
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	dataBytes, err := json.Marshal(nowcast)
+	if err != nil {
+		return err
+	}
+	return redisClient.Set(ctx, nowcastCacheKey(city), dataBytes, nowcastCacheTTL).Err()
+}
+
+func nowcastCacheKey(city string) string {
+	return fmt.Sprintf("nowcast:%s", city)
+}
+
+func getNowcastFromAPI(ctx context.Context, loc *location) (*cachedNowcast, error) {
+	apiKey := getEnv("OPENWEATHER_API_KEY", "")
+	if apiKey == "" {
+		// Return mock data if no API key is configured
+		log.Println("Warning: OPENWEATHER_API_KEY not configured, returning mock nowcast")
+		return getMockNowcast(loc.Name), nil
+	}
+
+	apiURL := fmt.Sprintf("https://api.openweathermap.org/data/3.0/onecall?%s&exclude=current,hourly,daily,alerts&appid=%s&units=metric",
+		owmPlaceQuery(loc), apiKey)
+
+	ctx, cancel := context.WithTimeout(ctx, openWeatherTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed with status: %s", resp.Status)
+	}
+
+	var owmResp OpenWeatherOneCallResponse
+	if err := json.NewDecoder(resp.Body).Decode(&owmResp); err != nil {
+		return nil, err
+	}
+
+	// One Call names no place, so a location_id is reported as it is
+	nowcast := &cachedNowcast{
+		City:       placeName(loc, ""),
+		Country:    loc.Country,
+		LocationID: loc.id(),
+		Source:     "api",
+	}
+	for _, item := range owmResp.Minutely {
+		nowcast.Minutes = append(nowcast.Minutes, NowcastMinute{
+			Time:          time.Unix(item.Dt, 0).UTC(),
+			Precipitation: item.Precipitation,
+		})
+	}
+	return nowcast, nil
+}
+
+func getMockNowcast(city string) *cachedNowcast {
+	// Rain all hour when the mock weather is rainy, a passing shower when
+	// it is cloudy and none otherwise
+	current := getMockWeatherData(city)
+	nowcast := &cachedNowcast{City: city, Country: current.Country, Source: "mock"}
+	first := mockClock.Now().UTC().Truncate(time.Minute)
+	for i := 0; i <= 60; i++ {
+		precipitation := 0.0
+		switch {
+		case current.Description == "Rainy":
+			precipitation = 1.5
+		case current.Description == "Cloudy" && i >= 20 && i < 35:
+			precipitation = 0.6
+		}
+		nowcast.Minutes = append(nowcast.Minutes, NowcastMinute{
+			Time:          first.Add(time.Duration(i) * time.Minute),
+			Precipitation: precipitation,
+		})
+	}
+	return nowcast
+}
+
+// nowcastInUnits converts data from metric units to units.
+func nowcastInUnits(data *Nowcast, units string) *Nowcast {
+	data.Units = "metric"
+	data.PrecipitationUnit = millimetersPerHour
+	if units == "imperial" {
+		data.Units = units
+		data.PrecipitationUnit = inchesPerHour
+		data.PeakPrecipitation = mmToInches(data.PeakPrecipitation)
+		for i := range data.Minutes {
+			data.Minutes[i].Precipitation = mmToInches(data.Minutes[i].Precipitation)
+		}
+	}
+	return data
+}
+
+func mmToInches(mm float64) float64 {
+	return mm / 25.4
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+	"github.com/go-redis/redis/v8"
+)
+
+// Observations: a user's own sensors, such as a personal weather station,
+// push their readings with POST /observations. A reading is kept for its
+// place and user until it is observationMaxAge old, and meanwhile the
+// user's /weather for that place reports its temperature and humidity
+// instead of the provider's, with the source "local"; the rest of the
+// weather, such as the condition and the wind, is still the provider's.
+// The place is matched as asked for: a reading for a city is not used for
+// the weather asked for by location_id, nor the other way round.
+
+// observationMaxAge is how long a reading is used after it was taken
+var observationMaxAge = parseDurationEnv("OBSERVATION_MAX_AGE", 30*time.Minute)
+
+// maxObservationSkew is how far in the future a reading may say it was
+// taken, for sensors whose clock is a little ahead
+const maxObservationSkew = 5 * time.Minute
+
+// ObservationRequest is the body of POST /observations. One of City and
+// LocationID names the place, and at least one of Temperature and
+// Humidity is required.
+type ObservationRequest struct {
+	City       string `json:"city"`
+	LocationID string `json:"location_id"`
+	// Temperature is in Units, metric (°C) by default.
+	Temperature *float64 `json:"temperature"`
+	// Humidity is relative, in percent.
+	Humidity *int   `json:"humidity"`
+	Units    string `json:"units"`
+	// ObservedAt is when the reading was taken, RFC3339; now by default.
+	ObservedAt string `json:"observed_at"`
+	// Station names the sensor, e.g. "garden".
+	Station string `json:"station"`
+}
+
+// Observation is a reading as stored, in metric units.
+type Observation struct {
+	Place       string    `json:"place"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	Humidity    *int      `json:"humidity,omitempty"`
+	ObservedAt  time.Time `json:"observed_at"`
+	Station     string    `json:"station,omitempty"`
+}
+
+// handleAddObservation stores a reading of one of the user's sensors,
+// replacing the previous one of its place unless that one is newer. It
+// answers 201 Created with the reading, or 409 Conflict when a newer one
+// is kept.
+func handleAddObservation(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		weatherRequestDuration.WithLabelValues("POST", "/observations").Observe(time.Since(start).Seconds())
+	}()
+	fail := func(status int, message string) {
+		weatherRequestsTotal.WithLabelValues("POST", "/observations", "error").Inc()
+		http.Error(w, message, status)
+	}
+
+	var req ObservationRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<10)).Decode(&req); err != nil {
+		fail(http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	obs, err := newObservation(req, time.Now())
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	if redisClient == nil {
+		fail(http.StatusServiceUnavailable, "Redis not available")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), redisTimeout)
+	defer cancel()
+
+	key := observationKey(auth.UserID(r), obs.Place)
+	if previous, err := getObservation(ctx, key); err == nil && previous.ObservedAt.After(obs.ObservedAt) {
+		fail(http.StatusConflict, "A newer reading of this place is stored")
+		return
+	}
+	ttl := time.Until(obs.ObservedAt.Add(observationMaxAge))
+	if ttl <= 0 {
+		fail(http.StatusBadRequest, fmt.Sprintf("observed_at is older than %s", observationMaxAge))
+		return
+	}
+	dataBytes, err := json.Marshal(obs)
+	if err == nil {
+		err = redisClient.Set(ctx, key, dataBytes, ttl).Err()
+	}
+	if err != nil {
+		fail(http.StatusServiceUnavailable, fmt.Sprintf("Failed to save observation: %v", err))
+		return
+	}
+
+	weatherRequestsTotal.WithLabelValues("POST", "/observations", "success").Inc()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(obs)
+}
+
+// newObservation validates req and returns its reading in metric units.
+func newObservation(req ObservationRequest, now time.Time) (*Observation, error) {
+	place := observationPlace(req.City, req.LocationID)
+	if place == "" {
+		return nil, fmt.Errorf("city or location_id is required")
+	}
+	if req.LocationID != "" {
+		if _, err := parseLocationID(req.LocationID); err != nil {
+			return nil, err
+		}
+	}
+	if req.Temperature == nil && req.Humidity == nil {
+		return nil, fmt.Errorf("temperature or humidity is required")
+	}
+	if req.Units != "" && req.Units != "metric" && req.Units != "imperial" {
+		return nil, fmt.Errorf("units must be metric or imperial")
+	}
+
+	obs := &Observation{Place: place, Humidity: req.Humidity, ObservedAt: now.UTC(), Station: strings.TrimSpace(req.Station)}
+	if req.Temperature != nil {
+		celsius := *req.Temperature
+		if req.Units == "imperial" {
+			celsius = fahrenheitToCelsius(celsius)
+		}
+		if celsius < -90 || celsius > 60 {
+			return nil, fmt.Errorf("temperature is out of range")
+		}
+		obs.Temperature = &celsius
+	}
+	if req.Humidity != nil && (*req.Humidity < 0 || *req.Humidity > 100) {
+		return nil, fmt.Errorf("humidity must be between 0 and 100")
+	}
+	if req.ObservedAt != "" {
+		observedAt, err := time.Parse(time.RFC3339, req.ObservedAt)
+		if err != nil {
+			return nil, fmt.Errorf("observed_at must be an RFC3339 time")
+		}
+		if observedAt.After(now.Add(maxObservationSkew)) {
+			return nil, fmt.Errorf("observed_at is in the future")
+		}
+		obs.ObservedAt = observedAt.UTC()
+	}
+	return obs, nil
+}
+
+// observationPlace is the place readings of city, or locationID, are kept
+// under: the location_id, else the city in lower case.
+func observationPlace(city, locationID string) string {
+	if locationID = strings.TrimSpace(locationID); locationID != "" {
+		return locationID
+	}
+	return strings.ToLower(strings.TrimSpace(city))
+}
+
+func observationKey(userID, place string) string {
+	return fmt.Sprintf("observation:%s:%s", userID, place)
+}
+
+// getObservation returns the reading stored under key.
+func getObservation(ctx context.Context, key string) (*Observation, error) {
+	data, err := redisClient.Get(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	var obs Observation
+	if err := json.Unmarshal([]byte(data), &obs); err != nil {
+		return nil, err
+	}
+	return &obs, nil
+}
+
+// blendObservation replaces the temperature and humidity of data, in
+// metric units, by those of the user's reading of its place, if they have
+// a recent one. Without one, or without Redis, data is left as it is.
+func blendObservation(ctx context.Context, userID, city, locationID string, data *WeatherData) {
+	if redisClient == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	obs, err := getObservation(ctx, observationKey(userID, observationPlace(city, locationID)))
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Warning: Failed to get observation: %v", err)
+		}
+		return
+	}
+	if time.Since(obs.ObservedAt) > observationMaxAge {
+		return
+	}
+	if obs.Temperature != nil {
+		data.Temperature = *obs.Temperature
+	}
+	if obs.Humidity != nil {
+		data.Humidity = *obs.Humidity
+	}
+	data.Source = "local"
+	data.Timestamp = obs.ObservedAt.Unix()
+	data.Station = obs.Station
+}
+
+// fahrenheitToCelsius converts f to °C, to the hundredth of a degree.
+func fahrenheitToCelsius(f float64) float64 {
+	return math.Round((f-32)*500/9) / 100
+}
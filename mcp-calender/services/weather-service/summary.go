@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+)
+
+// GET /weather/summary sums up the weather of a place in a small schema
+// that stays the same whatever the provider, for widgets and for passing
+// on to an LLM: a one-line text, the icon and temperature of now, and the
+// icon, range and chance of rain of the next summaryDays days. Numbers are
+// rounded as people read them. It is made from the current weather and
+// the forecast, cached as for /weather and /forecast; when the forecast
+// cannot be had, days is empty.
+
+// summaryDays is how many days of forecast a summary has
+const summaryDays = 3
+
+// wetChance is the chance of precipitation from which a day of a summary
+// is said to be wet
+const wetChance = 0.5
+
+// WeatherSummary is the weather of a place at a glance.
+type WeatherSummary struct {
+	City string `json:"city"`
+	// Text is a one-line summary, e.g. "Paris: 15°C, light rain. Rain
+	// likely tomorrow (80%)."
+	Text            string `json:"text"`
+	Icon            string `json:"icon"`
+	Temperature     int    `json:"temperature"`
+	TemperatureUnit string `json:"temperature_unit"`
+	// Days are the forecast from today, in the place's timezone.
+	Days   []DaySummary `json:"days"`
+	Source string       `json:"source"`
+}
+
+// DaySummary is the forecast of one day of a WeatherSummary.
+type DaySummary struct {
+	Date string `json:"date"` // YYYY-MM-DD
+	// Label is "Today", "Tomorrow" or the day of the week, e.g. "Sat".
+	Label string `json:"label"`
+	// Icon is the daytime icon of the wettest part of the day.
+	Icon           string `json:"icon"`
+	TemperatureMin int    `json:"temperature_min"`
+	TemperatureMax int    `json:"temperature_max"`
+	// PrecipitationChance is the highest of the day, in percent.
+	PrecipitationChance int `json:"precipitation_chance"`
+
+	condition Condition
+}
+
+// handleGetWeatherSummary returns the summary of the weather of city, or
+// location_id, and without either of the user's home city.
+func handleGetWeatherSummary(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	served := newServedQuery(w, "/weather/summary")
+	w = served
+	defer func() {
+		weatherRequestDuration.WithLabelValues("GET", "/weather/summary").Observe(time.Since(start).Seconds())
+		served.record(start)
+	}()
+
+	prefs := getPreferences(r.Context(), auth.UserID(r))
+	city, locationID, cacheKey := weatherQuery(r, prefs)
+	served.city = cacheKey
+	if cacheKey == "" {
+		weatherRequestsTotal.WithLabelValues("GET", "/weather/summary", "error").Inc()
+		http.Error(w, "City or location_id parameter is required when no home city is set", http.StatusBadRequest)
+		return
+	}
+
+	current, err := loadWeather(r.Context(), served, city, locationID, cacheKey)
+	if err != nil {
+		status := writeLoadError(w, r, err, "weather")
+		weatherRequestsTotal.WithLabelValues("GET", "/weather/summary", status).Inc()
+		return
+	}
+	blendObservation(r.Context(), auth.UserID(r), city, locationID, current)
+	summary := summarizeWeather(weatherInUnits(current, prefs.Units))
+
+	// The place is known by now, so the forecast can only fail at the
+	// provider; the weather of now is worth answering with anyway
+	forecast, err := loadForecast(r.Context(), served, city, locationID, cacheKey)
+	if err != nil {
+		log.Printf("Warning: Summary of %s without forecast: %v", cacheKey, err)
+	} else {
+		summary.Days = forecastDays(forecast, prefs.Units, dataNow())
+	}
+	summary.Text = summaryText(summary, current.Description)
+
+	weatherRequestsTotal.WithLabelValues("GET", "/weather/summary", "success").Inc()
+	writeJSONResponse(w, summary)
+}
+
+// summarizeWeather returns the summary of the current weather data, without
+// its days and text.
+func summarizeWeather(data *WeatherData) *WeatherSummary {
+	return &WeatherSummary{
+		City:            data.City,
+		Icon:            data.Icon,
+		Temperature:     int(math.Round(data.Temperature)),
+		TemperatureUnit: data.TemperatureUnit,
+		Days:            []DaySummary{},
+		Source:          data.Source,
+	}
+}
+
+// forecastDays sums up the days of forecast from the one now is in, up to
+// summaryDays of them, in units.
+func forecastDays(forecast *cachedForecast, units string, now time.Time) []DaySummary {
+	zone := time.FixedZone("", forecast.UTCOffset)
+	local := now.In(zone)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, zone)
+
+	days := []DaySummary{}
+	// The forecast reaches 5 days ahead, so late in the day today is
+	// already over and the days start tomorrow
+	for i := 0; i <= 5 && len(days) < summaryDays; i++ {
+		day := today.AddDate(0, 0, i)
+		from := day
+		if i == 0 {
+			from = now
+		}
+		data := summarizeForecast(forecast, from, day.AddDate(0, 0, 1))
+		if data == nil {
+			continue
+		}
+		data = forecastInUnits(data, units)
+		days = append(days, DaySummary{
+			Date:                day.Format("2006-01-02"),
+			Label:               dayLabel(i, day),
+			Icon:                conditionIcon(data.Condition, false),
+			TemperatureMin:      int(math.Round(data.TemperatureMin)),
+			TemperatureMax:      int(math.Round(data.TemperatureMax)),
+			PrecipitationChance: int(math.Round(data.PrecipitationChance * 100)),
+			condition:           data.Condition,
+		})
+	}
+	return days
+}
+
+// dayLabel names day, offset days from today.
+func dayLabel(offset int, day time.Time) string {
+	switch offset {
+	case 0:
+		return "Today"
+	case 1:
+		return "Tomorrow"
+	}
+	return day.Format("Mon")
+}
+
+// summaryText writes the one-line text of summary, description being the
+// provider's description of now: the weather of now, then the first wet
+// day or, when there is none, the range of the days.
+func summaryText(summary *WeatherSummary, description string) string {
+	text := fmt.Sprintf("%s: %d%s, %s.", summary.City, summary.Temperature, summary.TemperatureUnit, strings.ToLower(description))
+	if len(summary.Days) == 0 {
+		return text
+	}
+	for _, day := range summary.Days {
+		if float64(day.PrecipitationChance) >= wetChance*100 {
+			return fmt.Sprintf("%s %s likely %s (%d%%).", text, precipitationWord(day.condition), strings.ToLower(day.Label), day.PrecipitationChance)
+		}
+	}
+	low, high := summary.Days[0].TemperatureMin, summary.Days[0].TemperatureMax
+	for _, day := range summary.Days[1:] {
+		low, high = min(low, day.TemperatureMin), max(high, day.TemperatureMax)
+	}
+	return fmt.Sprintf("%s Dry for the next %d days, %d–%d%s.", text, len(summary.Days), low, high, summary.TemperatureUnit)
+}
+
+// precipitationWord names the precipitation of a wet day of condition.
+func precipitationWord(condition Condition) string {
+	switch condition {
+	case ConditionSnow:
+		return "Snow"
+	case ConditionSleet:
+		return "Sleet"
+	case ConditionThunderstorm:
+		return "Thunderstorms"
+	case ConditionDrizzle:
+		return "Drizzle"
+	}
+	return "Rain"
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/mcp-calender/internal/auth"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Favorite cities are kept warm in the cache: their weather and forecast
+// are fetched again shortly before the cached copies expire, so that
+// requests for them never wait on OpenWeatherMap. Each user has up to
+// maxFavorites favorites, and the cities of WEATHER_FAVORITE_CITIES are
+// warmed as well. A favorite is a city or, where several places have its
+// name, the location_id of one of them. With several replicas, one warms
+// the favorites each interval while the others skip it.
+
+// Redis keys of the favorites. Unlike cached weather they are kept until
+// changed: each user's set, and a sorted set counting the users of each
+// favorite, which the warmer reads.
+const (
+	userFavoritesPrefix = "favorites:user:"
+	warmedFavoritesKey  = "favorites:warmed"
+	// sharedFavoritesKey is the set of favorites shared by every user
+	// before favorites were per user; it moves to DefaultUser's.
+	sharedFavoritesKey = "favorites:cities"
+	// warmLockKey is held by the replica warming the favorites
+	warmLockKey = "weather:warm-lock"
+)
+
+// maxFavorites caps the favorites of a user, since each one costs
+// OpenWeatherMap calls every few minutes.
+var maxFavorites = parseIntEnv("MAX_FAVORITES", 20)
+
+// warmInterval is how often the warmer looks at the favorites. Entries
+// expiring within two intervals are refreshed, so they are replaced
+// before they expire.
+var warmInterval = parseDurationEnv("WEATHER_WARM_INTERVAL", time.Minute)
+
+// addFavoriteScript adds ARGV[1] to the user's favorites KEYS[1] unless
+// they number ARGV[2] already, counting the user in KEYS[2]. It returns 1
+// when added, 0 when already a favorite and -1 when full.
+var addFavoriteScript = redis.NewScript(`
+if redis.call('SISMEMBER', KEYS[1], ARGV[1]) == 1 then return 0 end
+if redis.call('SCARD', KEYS[1]) >= tonumber(ARGV[2]) then return -1 end
+redis.call('SADD', KEYS[1], ARGV[1])
+redis.call('ZINCRBY', KEYS[2], 1, ARGV[1])
+return 1`)
+
+// removeFavoriteScript removes ARGV[1] from the user's favorites KEYS[1]
+// and their count from KEYS[2], returning 1 when it was a favorite.
+var removeFavoriteScript = redis.NewScript(`
+if redis.call('SREM', KEYS[1], ARGV[1]) == 0 then return 0 end
+if tonumber(redis.call('ZINCRBY', KEYS[2], -1, ARGV[1])) <= 0 then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+end
+return 1`)
+
+var errTooManyFavorites = errors.New("too many favorites")
+
+var cacheWarmsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "weather_cache_warms_total",
+		Help: "Total number of cache entries refreshed for favorite cities",
+	},
+	[]string{"kind", "status"},
+)
+
+func init() {
+	prometheus.MustRegister(cacheWarmsTotal)
+}
+
+// FavoriteRequest is the body of POST /favorites; one of City and
+// LocationID is set.
+type FavoriteRequest struct {
+	City       string `json:"city,omitempty"`
+	LocationID string `json:"location_id,omitempty"`
+}
+
+// configuredFavorites are the cities of WEATHER_FAVORITE_CITIES, comma
+// separated, warmed whatever the users' favorites.
+var configuredFavorites = func() []string {
+	var cities []string
+	for _, city := range strings.Split(os.Getenv("WEATHER_FAVORITE_CITIES"), ",") {
+		if city = strings.TrimSpace(city); city != "" {
+			cities = append(cities, city)
+		}
+	}
+	return cities
+}()
+
+func userFavoritesKey(userID string) string {
+	return userFavoritesPrefix + userID
+}
+
+// moveSharedFavorites makes the favorites once shared by every user those
+// of DefaultUser, beyond maxFavorites if need be.
+func moveSharedFavorites(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	cities, err := redisClient.SMembers(ctx, sharedFavoritesKey).Result()
+	if err == nil && len(cities) > 0 {
+		for _, city := range cities {
+			if _, err = addFavoriteScript.Run(ctx, redisClient, []string{userFavoritesKey(auth.DefaultUser), warmedFavoritesKey}, city, maxFavorites+len(cities)).Result(); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = redisClient.Del(ctx, sharedFavoritesKey).Err()
+		}
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to move the shared favorite cities: %v", err)
+	}
+}
+
+// listFavorites returns the favorite cities of a user in alphabetical
+// order.
+func listFavorites(ctx context.Context, userID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	cities, err := redisClient.SMembers(ctx, userFavoritesKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(cities)
+	return cities, nil
+}
+
+// warmedFavorites returns the favorites of every user and the configured
+// ones.
+func warmedFavorites(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	cities, err := redisClient.ZRange(ctx, warmedFavoritesKey, 0, -1).Result()
+	if err != nil {
+		return configuredFavorites, err
+	}
+	seen := make(map[string]bool, len(cities))
+	for _, city := range cities {
+		seen[city] = true
+	}
+	for _, city := range configuredFavorites {
+		if !seen[city] {
+			cities = append(cities, city)
+		}
+	}
+	return cities, nil
+}
+
+// takeWarmTurn reports whether this replica warms the favorites this
+// interval: the first to ask holds warmLockKey until the interval ends.
+func takeWarmTurn(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	ok, err := redisClient.SetNX(ctx, warmLockKey, hostname, warmInterval).Result()
+	if err != nil {
+		log.Printf("Warning: Failed to take the warm lock: %v", err)
+		return false
+	}
+	return ok
+}
+
+// hostname tells which replica holds warmLockKey
+var hostname, _ = os.Hostname()
+
+// runWarmer warms the favorites every warmInterval until ctx is done.
+func runWarmer(ctx context.Context) {
+	ticker := time.NewTicker(warmInterval)
+	defer ticker.Stop()
+
+	for {
+		if takeWarmTurn(ctx) {
+			cities, err := warmedFavorites(ctx)
+			if err != nil {
+				log.Printf("Warning: Failed to list favorite cities: %v", err)
+			}
+			for _, city := range cities {
+				if ctx.Err() != nil {
+					return
+				}
+				warmCity(ctx, city)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// warmCity refreshes the cached weather and forecast of a favorite that
+// are missing or about to expire.
+func warmCity(ctx context.Context, favorite string) {
+	city, locationID := favorite, ""
+	if _, err := parseLocationID(favorite); err == nil {
+		city, locationID = "", favorite
+	}
+	warmEntry(ctx, "weather", weatherCacheKey(favorite), func() error {
+		loc, err := resolveLocation(ctx, city, locationID)
+		if err != nil {
+			return err
+		}
+		data, err := getWeatherFromAPI(ctx, loc)
+		if err != nil {
+			externalAPICallsTotal.WithLabelValues("openweathermap", "error").Inc()
+			return err
+		}
+		externalAPICallsTotal.WithLabelValues("openweathermap", "success").Inc()
+		return cacheWeatherData(ctx, favorite, data)
+	})
+	warmEntry(ctx, "forecast", forecastCacheKey(favorite), func() error {
+		loc, err := resolveLocation(ctx, city, locationID)
+		if err != nil {
+			return err
+		}
+		forecast, err := getForecastFromAPI(ctx, loc)
+		if err != nil {
+			externalAPICallsTotal.WithLabelValues("openweathermap", "error").Inc()
+			return err
+		}
+		externalAPICallsTotal.WithLabelValues("openweathermap", "success").Inc()
+		return cacheForecast(ctx, favorite, forecast)
+	})
+}
+
+// warmEntry calls refresh when the cache entry at key is missing or
+// expires within two warm intervals.
+func warmEntry(ctx context.Context, kind, key string, refresh func() error) {
+	ttlCtx, cancel := context.WithTimeout(ctx, redisTimeout)
+	ttl, err := redisClient.PTTL(ttlCtx, key).Result()
+	cancel()
+	if err != nil {
+		log.Printf("Warning: Failed to check the cached %s: %v", key, err)
+		return
+	}
+	// PTTL is -2 for a missing key and -1 for one that does not expire
+	if ttl == -1 || ttl > 2*warmInterval {
+		return
+	}
+
+	if err := refresh(); err != nil {
+		cacheWarmsTotal.WithLabelValues(kind, "error").Inc()
+		log.Printf("Warning: Failed to warm %s: %v", key, err)
+		return
+	}
+	cacheWarmsTotal.WithLabelValues(kind, "success").Inc()
+}
+
+func handleListFavorites(w http.ResponseWriter, r *http.Request) {
+	cities, err := listFavorites(r.Context(), auth.UserID(r))
+	if err != nil {
+		weatherRequestsTotal.WithLabelValues("GET", "/favorites", "error").Inc()
+		http.Error(w, fmt.Sprintf("Failed to list favorites: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	weatherRequestsTotal.WithLabelValues("GET", "/favorites", "success").Inc()
+	writeJSONResponse(w, map[string]interface{}{"cities": cities})
+}
+
+// handleAddFavorite adds a favorite city, or location_id, of the user and
+// warms its cache straight away. A city several places are called is
+// answered like GET /weather, with the candidates to choose a location_id
+// from.
+func handleAddFavorite(w http.ResponseWriter, r *http.Request) {
+	var req FavoriteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		weatherRequestsTotal.WithLabelValues("POST", "/favorites", "error").Inc()
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	city, locationID := strings.TrimSpace(req.City), strings.TrimSpace(req.LocationID)
+	if city == "" && locationID == "" {
+		weatherRequestsTotal.WithLabelValues("POST", "/favorites", "error").Inc()
+		http.Error(w, "City or location_id is required", http.StatusBadRequest)
+		return
+	}
+	if _, err := resolveLocation(r.Context(), city, locationID); err != nil {
+		status := writeLocationError(w, r, err)
+		weatherRequestsTotal.WithLabelValues("POST", "/favorites", status).Inc()
+		return
+	}
+	favorite := city
+	if locationID != "" {
+		favorite, city = locationID, ""
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), redisTimeout)
+	defer cancel()
+
+	added, err := addFavorite(ctx, auth.UserID(r), favorite)
+	if errors.Is(err, errTooManyFavorites) {
+		weatherRequestsTotal.WithLabelValues("POST", "/favorites", "error").Inc()
+		http.Error(w, fmt.Sprintf("At most %d favorites can be kept; remove one first", maxFavorites), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		weatherRequestsTotal.WithLabelValues("POST", "/favorites", "error").Inc()
+		http.Error(w, fmt.Sprintf("Failed to save favorite: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	if added {
+		go warmCity(context.WithoutCancel(r.Context()), favorite)
+	}
+
+	weatherRequestsTotal.WithLabelValues("POST", "/favorites", "success").Inc()
+	w.Header().Set("Content-Type", "application/json")
+	if added {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(FavoriteRequest{City: city, LocationID: locationID})
+}
+
+// addFavorite adds a favorite of a user, reporting whether it is new.
+func addFavorite(ctx context.Context, userID, favorite string) (bool, error) {
+	added, err := addFavoriteScript.Run(ctx, redisClient, []string{userFavoritesKey(userID), warmedFavoritesKey}, favorite, maxFavorites).Int()
+	if err != nil {
+		return false, err
+	}
+	if added < 0 {
+		return false, errTooManyFavorites
+	}
+	return added > 0, nil
+}
+
+func handleDeleteFavorite(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), redisTimeout)
+	defer cancel()
+
+	removed, err := removeFavoriteScript.Run(ctx, redisClient, []string{userFavoritesKey(auth.UserID(r)), warmedFavoritesKey}, mux.Vars(r)["city"]).Int()
+	if err != nil {
+		weatherRequestsTotal.WithLabelValues("DELETE", "/favorites/:city", "error").Inc()
+		http.Error(w, fmt.Sprintf("Failed to remove favorite: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	if removed == 0 {
+		weatherRequestsTotal.WithLabelValues("DELETE", "/favorites/:city", "not_found").Inc()
+		http.Error(w, "City is not a favorite", http.StatusNotFound)
+		return
+	}
+
+	// The cached weather expires as usual
+	weatherRequestsTotal.WithLabelValues("DELETE", "/favorites/:city", "success").Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Warning: invalid %s %q, using %s", key, value, defaultValue)
+	}
+	return defaultValue
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/cli"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/config"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/graph"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/indexer"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ingestion"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ollama"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// applyConfig pushes the loaded config into the packages that use it.
+func applyConfig(cfg *config.Config) error {
+	ollama.BaseURL = cfg.OllamaURL
+	ollama.Timeout = time.Duration(cfg.OllamaTimeoutSec) * time.Second
+	ollama.MaxRetries = cfg.OllamaMaxRetries
+	storage.MaxConns = int32(cfg.DBMaxConns)
+	processing.EmbedModel = cfg.EmbedModel
+	processing.EmbedModels = cfg.EmbedModels
+	processing.ChunkSize = cfg.ChunkSize
+	processing.ChunkOverlap = cfg.ChunkOverlap
+	processing.EntityExtractor = cfg.EntityExtractor
+	processing.EntityModel = cfg.LLMModel
+	indexer.DedupThreshold = cfg.DedupThreshold
+	indexer.AccessRules = cfg.AccessRuleTags()
+	indexer.CollectionRules = cfg.Collections
+	indexer.PDFPasswords = cfg.PDFPasswords
+	indexer.KnowledgeGraph = cfg.KnowledgeGraph
+	indexer.StoreText = cfg.StoreText
+	graph.LLMModel = cfg.LLMModel
+	graph.TopK = cfg.TopK
+	graph.MinScore = cfg.MinScore
+	graph.MaxContextChars = cfg.MaxContextChars
+	graph.FeedbackWeight = cfg.FeedbackWeight
+	graph.MetadataWeight = cfg.MetadataWeight
+	graph.CollectionWeights = cfg.CollectionWeights
+	graph.RecencyHalfLife = cfg.RecencyHalfLife()
+	graph.RecencyWeight = cfg.RecencyWeight
+	graph.StitchChunks = cfg.StitchChunks
+	graph.MinConfidence = cfg.MinConfidence
+	graph.GraphHops = cfg.GraphHops
+	graph.GraphMaxFacts = cfg.GraphMaxFacts
+	graph.RunLogPath = cfg.RunLogPath()
+	graph.DefaultGuardrails = graph.Guardrails{InjectionFilter: cfg.GuardInjection, RedactPII: cfg.GuardPII}
+	if err := graph.SetPIIPatterns(cfg.PIIPatterns); err != nil {
+		return err
+	}
+	ingestion.SetAllowedExtensions(cfg.AllowedExtensions)
+	ingestion.SetWalkOptions(ingestion.WalkOptions{
+		IgnorePatterns: cfg.IgnorePatterns,
+		MaxFileSize:    int64(cfg.MaxFileSizeMB) << 20,
+		FollowSymlinks: cfg.FollowSymlinks,
+	})
+	ingestion.SetArchiveOptions(ingestion.ArchiveOptions{
+		MaxDepth:     cfg.ArchiveMaxDepth,
+		MaxTotalSize: int64(cfg.ArchiveMaxSizeMB) << 20,
+		MaxFiles:     cfg.ArchiveMaxFiles,
+	})
+	ingestion.SetToolOptions(ingestion.ToolOptions{
+		Pdftoppm:   cfg.PdftoppmPath,
+		Pdftotext:  cfg.PdftotextPath,
+		Magick:     cfg.MagickPath,
+		Rasterizer: cfg.PDFRasterizer,
+	})
+	ingestion.SetVisionOptions(ingestion.VisionOptions{
+		Model:        cfg.VisionModel,
+		MinTextChars: cfg.VisionMinTextChars,
+	})
+	ingestion.SetOCROptions(ingestion.OCROptions{
+		Languages:     cfg.OCRLanguages,
+		DPI:           cfg.OCRDPI,
+		AutoRotate:    cfg.OCRAutoRotate,
+		Deskew:        cfg.OCRDeskew,
+		MinConfidence: cfg.OCRMinConfidence,
+		Workers:       cfg.OCRWorkers,
+		MaxPages:      cfg.OCRMaxPages,
+		PoolSize:      cfg.OCRPoolSize,
+	})
+	return nil
+}
+
+// warnMissingTools logs the external programs ingestion cannot find and
+// how it degrades without them.
+func warnMissingTools() {
+	for _, p := range ingestion.CheckTools() {
+		log.Printf("warning: %s", p)
+	}
+}
+
+// requiredModels lists every Ollama model the config refers to.
+func requiredModels(cfg *config.Config) []string {
+	models := []string{cfg.EmbedModel, cfg.LLMModel}
+	for _, m := range cfg.EmbedModels {
+		models = append(models, m)
+	}
+	if cfg.VisionModel != "" {
+		models = append(models, cfg.VisionModel)
+	}
+	return models
+}
+
+// configCommand shows the config or sets a key of the config file.
+func configCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "config",
+		Short: "show the config or set a key of the config file",
+		Commands: []*cli.Command{
+			{
+				Name:  "show",
+				Short: "print the config with environment overrides applied",
+				Run: func(c *cli.Context) error {
+					cfg, err := config.Load()
+					if err != nil {
+						fmt.Println(err)
+						os.Exit(1)
+					}
+					fmt.Printf("# %s (with environment overrides applied)\n", config.Path())
+					cfg.Redact()
+					b, _ := yaml.Marshal(cfg)
+					fmt.Print(string(b))
+					return nil
+				},
+			},
+			{
+				Name:  "set",
+				Short: "set a key of the config file",
+				Args:  "<key> <value>",
+				Run: func(c *cli.Context) error {
+					if len(c.Args) != 2 {
+						return c.Usage()
+					}
+					path := config.Path()
+					cfg, err := config.LoadFile(path)
+					if err != nil {
+						fmt.Println(err)
+						os.Exit(1)
+					}
+					if err := cfg.Set(c.Args[0], c.Args[1]); err != nil {
+						fmt.Println("config:", err)
+						os.Exit(1)
+					}
+					if err := cfg.Validate(); err != nil {
+						fmt.Println(err)
+						os.Exit(1)
+					}
+					if err := cfg.Save(path); err != nil {
+						fmt.Println(err)
+						os.Exit(1)
+					}
+					fmt.Printf("Set %s in %s\n", c.Args[0], path)
+					return nil
+				},
+			},
+		},
+	}
+}
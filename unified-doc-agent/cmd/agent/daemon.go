@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/cli"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/config"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/indexer"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ollama"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/remote"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/server"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+func daemonCommand() *cli.Command {
+	var port, uploadDir *string
+	var interval *int
+	return &cli.Command{
+		Name:  "daemon",
+		Short: "serve the HTTP API and keep the configured sources indexed",
+		Flags: func(fs *flag.FlagSet) {
+			port = fs.String("port", getEnv("PORT", "8090"), "port to listen on")
+			uploadDir = fs.String("upload-dir", filepath.Join(os.TempDir(), "uda-uploads"), "where uploaded files are stored before indexing")
+			interval = fs.Int("interval-min", 0, "minutes between reconciliations of the configured sources (default from config)")
+		},
+		Run: func(c *cli.Context) error {
+			cfg := setup()
+			if *interval > 0 {
+				cfg.ReindexIntervalMin = *interval
+			}
+			runDaemon(cfg, *port, *uploadDir)
+			return nil
+		},
+	}
+}
+
+// runDaemon serves the HTTP API and reconciles the configured sources on a
+// timer, so new and changed files are indexed and deleted ones removed
+// without anyone running `agent index`. Google Drive and S3 sources are
+// synced from their changes rather than crawled.
+func runDaemon(cfg *config.Config, port, uploadDir string) {
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if len(cfg.Sources) == 0 {
+		log.Println("warning: no sources configured; only the API will run (set them with `agent config set sources <dir|gdrive://folder|s3://bucket/prefix,...>`)")
+	}
+	if err := ollama.Ping(context.Background(), requiredModels(cfg)...); err != nil {
+		log.Printf("warning: %v", err)
+	}
+	warnMissingTools()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		reconcileLoop(cfg.Sources, time.Duration(cfg.ReindexIntervalMin)*time.Minute, stop)
+	}()
+
+	srv := server.New(storage.Default(), uploadDir, cfg)
+	runServer(port, srv, func() {
+		// Let a running reconciliation finish its current file so the
+		// database is closed under a checkpointed run.
+		close(stop)
+		wg.Wait()
+	})
+}
+
+// reconcileLoop reconciles every source straight away and then once per
+// interval until stop is closed.
+func reconcileLoop(sources []string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, src := range sources {
+			reconcile(src, stop)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func reconcile(src string, stop <-chan struct{}) {
+	select {
+	case <-stop:
+		return
+	default:
+	}
+	var res *indexer.Result
+	var err error
+	if remote.IsRemote(src) {
+		res, err = remote.Sync(context.Background(), src, stop)
+	} else {
+		// An interrupted earlier run is resumed rather than walked again.
+		res, err = indexer.IndexPath(context.Background(), src, nil, indexer.Options{Resume: true, Stop: stop, Prune: true})
+	}
+	server.RecordReindex(src, res, err)
+	if err != nil {
+		log.Printf("reconcile %s: %v", src, err)
+		return
+	}
+	log.Printf("reconcile %s: %d indexed, %d unchanged, %d removed, %d failed in %dms",
+		src, res.Indexed, res.Unchanged, res.Removed, res.Failed, res.DurationMS)
+	if n, err := storage.CountChunks(); err == nil {
+		server.SetChunkCount(n)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/cli"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/config"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// docsCommand lists the indexed files and shows the chunks of one, to
+// check what a query can find without asking one.
+func docsCommand() *cli.Command {
+	var listTags, listCollection, chunksTags *string
+	var listJSON, chunksJSON *bool
+	return &cli.Command{
+		Name:  "docs",
+		Short: "list the indexed files or show the chunks of one",
+		Commands: []*cli.Command{
+			{
+				Name:  "list",
+				Short: "list the indexed files with their chunk counts",
+				Flags: func(fs *flag.FlagSet) {
+					listTags = fs.String("access-tags", "", "comma-separated access tags the files must be visible to (* = all)")
+					listCollection = fs.String("collection", "", "only list the files of this collection")
+					listJSON = fs.Bool("json", false, "print the files as JSON")
+				},
+				Run: func(c *cli.Context) error {
+					setup()
+					docs, err := storage.ListDocuments(config.SplitTags(*listTags))
+					if err != nil {
+						log.Fatal("docs:", err)
+					}
+					if *listCollection != "" {
+						var kept []storage.IndexedFile
+						for _, d := range docs {
+							if d.Collection == *listCollection {
+								kept = append(kept, d)
+							}
+						}
+						docs = kept
+					}
+					if *listJSON {
+						if docs == nil {
+							docs = []storage.IndexedFile{}
+						}
+						printJSON(docs)
+						return nil
+					}
+					if len(docs) == 0 {
+						fmt.Println("No documents indexed yet.")
+						return nil
+					}
+					tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(tw, "FILE\tSOURCE\tLANGUAGE\tCOLLECTION\tCHUNKS\tINDEXED")
+					for _, d := range docs {
+						indexed := "-"
+						if d.IndexedAt != nil {
+							indexed = d.IndexedAt.Format("2006-01-02 15:04")
+						}
+						name := d.Filename
+						if d.DuplicateOf != "" {
+							name += " (duplicate of " + d.DuplicateOf + ")"
+						}
+						fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", name, d.Source, orDash(d.Language), orDash(d.Collection), d.Chunks, indexed)
+					}
+					tw.Flush()
+					return nil
+				},
+			},
+			{
+				Name:  "chunks",
+				Short: "show the chunks of an indexed file",
+				Args:  "<file>",
+				Flags: func(fs *flag.FlagSet) {
+					chunksTags = fs.String("access-tags", "", "comma-separated access tags the chunks must be visible to (* = all)")
+					chunksJSON = fs.Bool("json", false, "print the chunks as JSON")
+				},
+				Run: func(c *cli.Context) error {
+					if len(c.Args) != 1 {
+						return c.Usage()
+					}
+					setup()
+					chunks, err := storage.Default().FileChunks(c.Args[0], config.SplitTags(*chunksTags))
+					if err != nil {
+						log.Fatal("docs:", err)
+					}
+					if len(chunks) == 0 {
+						log.Fatalf("docs: %s is not indexed", c.Args[0])
+					}
+					if *chunksJSON {
+						out := make([]chunkJSON, len(chunks))
+						for i, d := range chunks {
+							out[i] = chunkJSON{ID: d.ID, Filename: d.Filename, Page: d.Page, Language: d.Language,
+								Collection: d.Collection, Entities: d.Entities, Keywords: d.Keywords, Content: d.Content}
+						}
+						printJSON(out)
+						return nil
+					}
+					for _, d := range chunks {
+						fmt.Printf("--- chunk %d of %s", d.ID, d.Filename)
+						if d.Page > 0 {
+							fmt.Printf(", page %d", d.Page)
+						}
+						fmt.Printf(" ---\n%s\n\n", d.Content)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// chunkJSON is a chunk as `agent docs chunks -json` prints it.
+type chunkJSON struct {
+	ID         int      `json:"id"`
+	Filename   string   `json:"filename"`
+	Page       int      `json:"page,omitempty"`
+	Language   string   `json:"language,omitempty"`
+	Collection string   `json:"collection,omitempty"`
+	Entities   []string `json:"entities,omitempty"`
+	Keywords   []string `json:"keywords,omitempty"`
+	Content    string   `json:"content"`
+}
+
+// indexStats is what `agent stats` reports.
+type indexStats struct {
+	Files       int                    `json:"files"`
+	Chunks      int                    `json:"chunks"`
+	Duplicates  int                    `json:"duplicates"`
+	Sources     map[string]int         `json:"sources"`
+	Collections map[string]int         `json:"collections"`
+	Languages   map[string]int         `json:"languages"`
+	Embeddings  []storage.EmbeddingUse `json:"embeddings"`
+	LastRun     *storage.IndexRun      `json:"last_run,omitempty"`
+}
+
+// statsCommand sums up the index: how many files and chunks it holds, by
+// source, collection and language, which models embedded them and how
+// the last index run went.
+func statsCommand() *cli.Command {
+	var asJSON *bool
+	return &cli.Command{
+		Name:  "stats",
+		Short: "sum up the index: files, chunks, embedding models and the last run",
+		Flags: func(fs *flag.FlagSet) {
+			asJSON = fs.Bool("json", false, "print the stats as JSON")
+		},
+		Run: func(c *cli.Context) error {
+			setup()
+			docs, err := storage.ListDocuments([]string{storage.AllTags})
+			if err != nil {
+				log.Fatal("stats:", err)
+			}
+			stats := indexStats{
+				Sources:     map[string]int{},
+				Collections: map[string]int{},
+				Languages:   map[string]int{},
+			}
+			for _, d := range docs {
+				stats.Files++
+				stats.Chunks += d.Chunks
+				if d.DuplicateOf != "" {
+					stats.Duplicates++
+				}
+				stats.Sources[orDash(d.Source)]++
+				stats.Collections[orDash(d.Collection)]++
+				stats.Languages[orDash(d.Language)]++
+			}
+			if stats.Embeddings, err = storage.EmbeddingModels([]string{storage.AllTags}, nil); err != nil {
+				log.Fatal("stats:", err)
+			}
+			runs, err := storage.IndexRuns(1)
+			if err != nil {
+				log.Fatal("stats:", err)
+			}
+			if len(runs) > 0 {
+				stats.LastRun = &runs[0]
+			}
+
+			if *asJSON {
+				printJSON(stats)
+				return nil
+			}
+			fmt.Printf("Files:       %d (%d duplicates)\n", stats.Files, stats.Duplicates)
+			fmt.Printf("Chunks:      %d\n", stats.Chunks)
+			printCounts("Sources", stats.Sources)
+			printCounts("Collections", stats.Collections)
+			printCounts("Languages", stats.Languages)
+			fmt.Println("Embeddings:")
+			for _, e := range stats.Embeddings {
+				fmt.Printf("  %-24s %d chunks\n", e.Model+"@"+orDash(e.Version), e.Chunks)
+			}
+			if r := stats.LastRun; r != nil {
+				fmt.Printf("Last run:    %d (%s) %s, started %s, took %s\n", r.ID, r.Kind, r.Status,
+					r.StartedAt.Format(time.DateTime), runDuration(*r))
+			}
+			return nil
+		},
+	}
+}
+
+// printCounts writes the files counted by name under title, most first.
+func printCounts(title string, counts map[string]int) {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	fmt.Printf("%s:\n", title)
+	for _, name := range names {
+		fmt.Printf("  %-12s %d files\n", name, counts[name])
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/cli"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/config"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// feedbackCommand records relevance feedback on a chunk returned for a
+// query. Chunk ids are listed next to each source in the query output.
+func feedbackCommand() *cli.Command {
+	var query, mark, tags *string
+	var chunk *int
+	return &cli.Command{
+		Name:  "feedback",
+		Short: "mark a chunk returned for a query helpful, unhelpful or pinned",
+		Flags: func(fs *flag.FlagSet) {
+			query = fs.String("q", "", "the query the chunk was returned for")
+			chunk = fs.Int("chunk", 0, "chunk id")
+			mark = fs.String("mark", storage.FeedbackHelpful, "helpful, unhelpful, pin or unpin")
+			tags = fs.String("access-tags", "", "comma-separated access tags the chunk must be visible to (* = all)")
+		},
+		Run: func(c *cli.Context) error {
+			setup()
+			runFeedback(*query, *chunk, *mark, *tags)
+			return nil
+		},
+	}
+}
+
+func runFeedback(query string, chunk int, mark, tags string) {
+
+	if query == "" || chunk <= 0 {
+		fmt.Println("Please provide -q \"your query\" and -chunk <id>")
+		os.Exit(1)
+	}
+	err := storage.AddFeedback(query, chunk, mark, "cli", config.SplitTags(tags))
+	if errors.Is(err, storage.ErrChunkNotFound) {
+		log.Fatalf("chunk %d not found (or, for unpin, not pinned to this query)", chunk)
+	}
+	if err != nil {
+		log.Fatal("feedback:", err)
+	}
+	fmt.Printf("Recorded %s for chunk %d.\n", mark, chunk)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/cli"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/indexer"
+)
+
+func gcCommand() *cli.Command {
+	var confirm, asJSON *bool
+	return &cli.Command{
+		Name:  "gc",
+		Short: "find and remove what the index keeps of deleted files and interrupted runs",
+		Flags: func(fs *flag.FlagSet) {
+			confirm = fs.Bool("confirm", false, "remove what was found and vacuum the tables (default: only report it)")
+			asJSON = fs.Bool("json", false, "print the report as JSON")
+		},
+		Run: func(c *cli.Context) error {
+			openDB(loadConfig())
+			runGC(*confirm, *asJSON)
+			return nil
+		},
+	}
+}
+
+// runGC reports the chunks and stored texts of local files that are gone
+// and of files no longer recorded, and the collections no rule leads to,
+// removing all but the collections with confirm. Local files are looked
+// for relative to the folders index runs were started from; run it while
+// nothing is being indexed.
+func runGC(confirm, asJSON bool) {
+	rep, err := indexer.CollectGarbage(context.Background(), confirm)
+	if err != nil && rep == nil {
+		log.Fatal("gc:", err)
+	}
+	if asJSON {
+		printJSON(rep)
+	} else {
+		indexer.PrintGC(os.Stdout, rep)
+	}
+	if err != nil {
+		log.Fatal("gc:", err)
+	}
+}
@@ -2,99 +2,429 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/cli"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/config"
 	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/graph"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/indexer"
 	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ingestion"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ollama"
 	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/server"
 	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
 )
 
 func main() {
+	root := &cli.Command{
+		Name:  "agent",
+		Short: "index documents and answer questions about them",
+		PersistentFlags: func(fs *flag.FlagSet) {
+			fs.Func("config", "config `file` to use instead of $UDA_CONFIG or <home>/config.yaml", func(path string) error {
+				return os.Setenv("UDA_CONFIG", path)
+			})
+			fs.Func("home", "agent `directory` to use instead of $UDA_HOME or ~/.uda", func(dir string) error {
+				return os.Setenv("UDA_HOME", dir)
+			})
+		},
+	}
+	root.Commands = []*cli.Command{
+		indexCommand(),
+		rechunkCommand(),
+		gcCommand(),
+		runsCommand(),
+		queryCommand(),
+		docsCommand(),
+		statsCommand(),
+		summarizeCommand(),
+		feedbackCommand(),
+		serveCommand(),
+		daemonCommand(),
+		mcpCommand(),
+		configCommand(),
+		migrateEmbeddingsCommand(),
+		cli.CompletionCommand(root),
+	}
+	if err := root.Execute(os.Args[1:]); err != nil {
+		if errors.Is(err, cli.ErrUsage) {
+			os.Exit(1)
+		}
+		log.Fatal(err)
+	}
+}
 
-	indexCmd := flag.NewFlagSet("index", flag.ExitOnError)
-	indexPath := indexCmd.String("path", "./data", "path to folder to index")
-
-	queryCmd := flag.NewFlagSet("query", flag.ExitOnError)
-	queryText := queryCmd.String("q", "", "query text")
-
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: agent <index|query> [flags]")
-		os.Exit(1)
+// loadConfig loads the config and the prompts and applies them.
+func loadConfig() *config.Config {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := applyConfig(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := graph.LoadPrompts(cfg.PromptsPath()); err != nil {
+		log.Fatal(err)
 	}
+	return cfg
+}
 
-	// Init Postgres DB
-	if err := storage.InitDB(); err != nil {
+// openDB connects to Postgres, creating the schema if need be.
+func openDB(cfg *config.Config) {
+	if err := storage.InitDB(cfg.DatabaseURL); err != nil {
 		log.Fatal("DB init:", err)
 	}
+}
 
-	switch os.Args[1] {
-	case "index":
-		indexCmd.Parse(os.Args[2:])
-		log.Println("Starting indexing:", *indexPath)
+// setup loads the config and opens the database for the commands that
+// search or change the index, which must embed with the model the stored
+// chunks were embedded with.
+func setup() *config.Config {
+	cfg := loadConfig()
+	openDB(cfg)
+	checkEmbeddingModel(cfg)
+	return cfg
+}
 
-		files, err := ingestion.LoadLocalFiles(*indexPath)
-		if err != nil {
-			log.Fatal("load files:", err)
-		}
+func indexCommand() *cli.Command {
+	var (
+		indexPath, indexOCRLang                                      *string
+		indexQuiet, indexJSON, indexResume, indexPrune, indexDryRun  *bool
+		indexFollow, indexOCRRotate, indexOCRDeskew, indexAskPass    *bool
+		indexMaxSize, indexOCRDPI, indexOCRWorkers, indexOCRMaxPages *int
+		indexOCRMinConf                                              *float64
+	)
+	return &cli.Command{
+		Name:  "index",
+		Short: "index the files under a folder",
+		Flags: func(indexCmd *flag.FlagSet) {
+			indexPath = indexCmd.String("path", "./data", "path to folder to index")
+			indexQuiet = indexCmd.Bool("quiet", false, "no progress bar, only the final summary")
+			indexJSON = indexCmd.Bool("json", false, "print the final summary as JSON and nothing else")
+			indexResume = indexCmd.Bool("resume", false, "continue the last interrupted run over the same path")
+			indexPrune = indexCmd.Bool("prune", false, "remove indexed files under the path that no longer exist")
+			indexDryRun = indexCmd.Bool("dry-run", false, "report what would be indexed without extracting or embedding anything")
+			indexMaxSize = indexCmd.Int("max-size-mb", -1, "skip files larger than this (0 = no limit; default from config)")
+			indexFollow = indexCmd.Bool("follow-symlinks", false, "follow symlinked files and directories (default from config)")
+			indexOCRLang = indexCmd.String("ocr-lang", "", "tesseract languages, e.g. eng+deu (default from config)")
+			indexOCRDPI = indexCmd.Int("ocr-dpi", 0, "DPI for rasterizing scanned PDFs (default from config)")
+			indexOCRRotate = indexCmd.Bool("ocr-auto-rotate", false, "detect page orientation before OCR (default from config)")
+			indexOCRDeskew = indexCmd.Bool("ocr-deskew", false, "deskew images with ImageMagick before OCR (default from config)")
+			indexOCRMinConf = indexCmd.Float64("ocr-min-confidence", 0, "flag pages whose mean OCR confidence is below this (0-100)")
+			indexOCRWorkers = indexCmd.Int("ocr-workers", 0, "pages OCR'd in parallel (0 = one per CPU; default from config)")
+			indexOCRMaxPages = indexCmd.Int("ocr-max-pages", 0, "OCR at most this many pages per PDF (0 = all; default from config)")
+			indexAskPass = indexCmd.Bool("ask-password", false, "prompt for the password of encrypted PDFs no pdf_passwords entry opens")
+		},
+		Run: func(c *cli.Context) error {
+			cfg := setup()
+			c.Flags.Visit(func(f *flag.Flag) {
+				switch f.Name {
+				case "max-size-mb":
+					cfg.MaxFileSizeMB = *indexMaxSize
+				case "follow-symlinks":
+					cfg.FollowSymlinks = *indexFollow
+				case "ocr-lang":
+					cfg.Set("ocr_languages", *indexOCRLang)
+				case "ocr-dpi":
+					cfg.OCRDPI = *indexOCRDPI
+				case "ocr-auto-rotate":
+					cfg.OCRAutoRotate = *indexOCRRotate
+				case "ocr-deskew":
+					cfg.OCRDeskew = *indexOCRDeskew
+				case "ocr-min-confidence":
+					cfg.OCRMinConfidence = *indexOCRMinConf
+				case "ocr-workers":
+					cfg.OCRWorkers = *indexOCRWorkers
+				case "ocr-max-pages":
+					cfg.OCRMaxPages = *indexOCRMaxPages
+				}
+			})
+			if err := cfg.Validate(); err != nil {
+				log.Fatal(err)
+			}
+			if err := applyConfig(cfg); err != nil {
+				log.Fatal(err)
+			}
 
-		for _, f := range files {
-			log.Println("Indexing:", f)
-			text, err := ingestion.ExtractText(f)
+			if *indexDryRun {
+				plan, err := indexer.BuildPlan(context.Background(), *indexPath)
+				if err != nil {
+					log.Fatal("dry run:", err)
+				}
+				if *indexJSON {
+					printJSON(plan)
+				} else {
+					indexer.PrintPlan(os.Stdout, plan)
+				}
+				return nil
+			}
+
+			warnMissingTools()
+			if *indexAskPass {
+				if !isTerminal() {
+					log.Fatal("index: -ask-password needs a terminal")
+				}
+				indexer.PasswordPrompt = promptPassword
+			}
+			var rep indexer.Reporter
+			if !*indexQuiet && !*indexJSON {
+				log.Println("Starting indexing:", *indexPath)
+				rep = indexer.NewProgressBar(os.Stderr)
+			}
+
+			if err := ollama.Ping(context.Background(), requiredModels(cfg)...); err != nil {
+				log.Fatal(err)
+			}
+			// The first Ctrl-C finishes the current file and checkpoints; a
+			// second one aborts immediately.
+			stop := make(chan struct{})
+			sigs := make(chan os.Signal, 2)
+			signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-sigs
+				log.Println("Interrupted: finishing the current file (press Ctrl-C again to abort)")
+				close(stop)
+				<-sigs
+				os.Exit(130)
+			}()
+
+			res, err := indexer.IndexPath(context.Background(), *indexPath, rep, indexer.Options{Resume: *indexResume, Stop: stop, Prune: *indexPrune})
+			signal.Stop(sigs)
+			ingestion.CloseOCR()
 			if err != nil {
-				log.Println("skip file:", f, "err:", err)
-				continue
+				log.Fatal("index:", err)
+			}
+			if *indexJSON {
+				printJSON(res)
+			} else {
+				indexer.PrintSummary(os.Stdout, res)
 			}
-			chunks := processing.ChunkText(text)
-			embs, err := processing.EmbedChunks(context.Background(), chunks)
+			if res.Interrupted {
+				fmt.Fprintf(os.Stderr, "Indexing was interrupted; run `agent index -path %s -resume` to continue.\n", *indexPath)
+				os.Exit(130)
+			}
+			if res.Failed > 0 {
+				os.Exit(2)
+			}
+			return nil
+		},
+	}
+}
+
+func queryCommand() *cli.Command {
+	var (
+		queryText, queryFormat, queryMode, queryStyle, queryLength, queryLanguage *string
+		queryTags, queryEntity, queryCollections, queryWeights                    *string
+		querySince, queryUntil                                                    *string
+		queryNoInjection, queryNoRedact, queryDebug, queryAllowMixed              *bool
+		queryTopK, queryMaxContext                                                *int
+		queryMinScore, queryMinConfidence, queryHalfLife                          *float64
+	)
+	return &cli.Command{
+		Name:  "query",
+		Short: "answer a question from the indexed documents",
+		Flags: func(queryCmd *flag.FlagSet) {
+			queryText = queryCmd.String("q", "", "query text")
+			queryFormat = queryCmd.String("format", "text", "output format: text or json")
+			queryMode = queryCmd.String("mode", "auto", "answer mode: auto, factoid, summary, list, compare or graph (multi-hop over the knowledge graph)")
+			queryStyle = queryCmd.String("style", "", "answer as bullet points (bullet) or prose (narrative) (default: as the prompt has it)")
+			queryLength = queryCmd.String("length", "", "answer length: short or long (default: as the prompt has it)")
+			queryLanguage = queryCmd.String("language", "", "language to answer in, e.g. es or Spanish (default: the LLM's choice)")
+			queryNoInjection = queryCmd.Bool("no-injection-filter", false, "keep retrieved chunks that contain prompt-injection phrases")
+			queryNoRedact = queryCmd.Bool("no-redact", false, "do not redact PII from retrieved chunks")
+			queryTopK = queryCmd.Int("top-k", 0, "chunks to retrieve (default from config)")
+			queryMinScore = queryCmd.Float64("min-score", 0, "drop chunks with a similarity score below this, 0-1 (default from config)")
+			queryMinConfidence = queryCmd.Float64("min-confidence", 0, "answer that the documents do not say when confidence is below this, 0-1 (default from config)")
+			queryMaxContext = queryCmd.Int("max-context-chars", 0, "cap on document text sent to the LLM (default from config)")
+			queryTags = queryCmd.String("access-tags", "", "comma-separated access tags to search within; untagged documents are always searched (* = all)")
+			queryEntity = queryCmd.String("entity", "", "only search chunks mentioning this entity, e.g. \"Acme Corp\"; separate several with commas")
+			queryCollections = queryCmd.String("collections", "", "comma-separated collections to search, each separately before merging (default: all)")
+			queryWeights = queryCmd.String("collection-weights", "", "per-collection score weights overriding the config, e.g. policies=1.5,archive=0.5")
+			querySince = queryCmd.String("since", "", "only search files last modified on or after this date (2006-01-02) or RFC 3339 time")
+			queryUntil = queryCmd.String("until", "", "only search files last modified before this RFC 3339 time or by the end of this date (2006-01-02)")
+			queryHalfLife = queryCmd.Float64("recency-half-life", 0, "prefer recently modified files, halving the recency-weighted share of a chunk's score every this many days (default from config)")
+			queryDebug = queryCmd.Bool("debug", false, "print a per-node trace to stderr and append it to the run log")
+			queryAllowMixed = queryCmd.Bool("allow-mixed-embeddings", false, "search the chunks of the query's embedding model even if others were embedded by other models or versions")
+		},
+		Run: func(c *cli.Context) error {
+			cfg := setup()
+			if *queryText == "" {
+				fmt.Println("Please provide -q \"your query\"")
+				os.Exit(1)
+			}
+			if *queryTopK < 0 || *queryMinScore < 0 || *queryMinScore > 1 || *queryMinConfidence < 0 || *queryMinConfidence > 1 || *queryMaxContext < 0 || *queryHalfLife < 0 {
+				fmt.Println("--top-k, --max-context-chars and --recency-half-life must not be negative and --min-score and --min-confidence must be between 0 and 1")
+				os.Exit(1)
+			}
+			since, until, err := graph.ParseDateRange(*querySince, *queryUntil)
 			if err != nil {
-				log.Println("embed error:", err)
-				continue
+				fmt.Println(err)
+				os.Exit(1)
 			}
-			for i := range chunks {
-				if err := storage.InsertEmbedding(f, "local", chunks[i], embs[i]); err != nil {
-					log.Println("db insert error:", err)
+
+			out, err := graph.NewOutput(*queryFormat, os.Stdout)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			mode, err := graph.ParseQueryMode(*queryMode)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			style, err := graph.ParseAnswerStyle(*queryStyle, *queryLength, *queryLanguage)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			weights, err := config.ParseWeights(*queryWeights)
+			if err != nil {
+				fmt.Println("--collection-weights:", err)
+				os.Exit(1)
+			}
+			for name, w := range weights {
+				if w <= 0 {
+					fmt.Printf("--collection-weights: weight of %s must be positive\n", name)
+					os.Exit(1)
 				}
 			}
-		}
-		fmt.Println("Indexing complete.")
+			if err := ollama.Ping(context.Background(), requiredModels(cfg)...); err != nil {
+				log.Fatal(err)
+			}
 
-	case "query":
-		queryCmd.Parse(os.Args[2:])
-		if *queryText == "" {
-			fmt.Println("Please provide -q \"your query\"")
-			os.Exit(1)
-		}
+			state := &graph.State{
+				Query: *queryText,
+				Docs:  nil, // RetrieverNode will fill this
+				Ans:   "",
+				DB:    graph.NewStore(storage.Default()),
+				Out:   out,
+				Guardrails: &graph.Guardrails{
+					InjectionFilter: cfg.GuardInjection && !*queryNoInjection,
+					RedactPII:       cfg.GuardPII && !*queryNoRedact,
+				},
+				TopK:              *queryTopK,
+				MinScore:          *queryMinScore,
+				MinConfidence:     *queryMinConfidence,
+				MaxContextChars:   *queryMaxContext,
+				Debug:             cfg.Debug || *queryDebug,
+				AccessTags:        config.SplitTags(*queryTags),
+				Entities:          splitEntities(*queryEntity),
+				Collections:       splitCollections(*queryCollections),
+				CollectionWeights: weights,
+				Since:             since,
+				Until:             until,
+				RecencyHalfLife:   time.Duration(*queryHalfLife * float64(24*time.Hour)),
+				QueryType:         mode,
+				Style:             style,
 
-		state := &graph.State{
-			Query: *queryText,
-			Docs:  nil, // RetrieverNode will fill this
-			Ans:   "",
-			DB: &graph.DBWrapper{
-				Search: storage.SearchImpl, // inject search implementation
-			},
-		}
+				AllowMixedEmbeddings: *queryAllowMixed,
+			}
+
+			err = graph.RunWorkflow(context.Background(), state)
+			if state.Debug && state.Trace != nil {
+				state.Trace.WriteTo(os.Stderr)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			return nil
+		},
+	}
+}
+
+func serveCommand() *cli.Command {
+	var servePort, serveUploads *string
+	return &cli.Command{
+		Name:  "serve",
+		Short: "serve the HTTP API",
+		Flags: func(serveCmd *flag.FlagSet) {
+			servePort = serveCmd.String("port", getEnv("PORT", "8090"), "port to listen on")
+			serveUploads = serveCmd.String("upload-dir", filepath.Join(os.TempDir(), "uda-uploads"), "where uploaded files are stored before indexing")
+		},
+		Run: func(c *cli.Context) error {
+			cfg := setup()
+			// Ollama may come up after the API; /health keeps reporting it.
+			if err := ollama.Ping(context.Background(), requiredModels(cfg)...); err != nil {
+				log.Printf("warning: %v", err)
+			}
+			warnMissingTools()
+			srv := server.New(storage.Default(), *serveUploads, cfg)
+			runServer(*servePort, srv, nil)
+			return nil
+		},
+	}
+}
 
-		if err := graph.RunWorkflow(context.Background(), state); err != nil {
-			log.Fatal(err)
+// runServer serves srv until SIGINT or SIGTERM. beforeShutdown, if not
+// nil, runs once the signal arrives and before the HTTP server stops.
+func runServer(port string, srv *server.Server, beforeShutdown func()) {
+	httpServer := &http.Server{
+		Addr:    ":" + port,
+		Handler: srv.Router(),
+	}
+
+	// Graceful shutdown
+	go func() {
+		log.Printf("Doc Agent API starting on port %s", port)
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed to start: %v", err)
 		}
+	}()
+
+	// Wait for interrupt signal
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+
+	log.Println("Shutting down gracefully...")
+	if beforeShutdown != nil {
+		beforeShutdown()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-		fmt.Println("Answer:", state.Ans)
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Fatalf("Server forced to shutdown: %v", err)
+	}
+	ingestion.CloseOCR()
+	storage.Default().Close()
+	log.Println("Server exited")
+}
 
-	default:
-		fmt.Println("expected 'index' or 'query' subcommands")
-		os.Exit(1)
+// splitEntities parses a comma-separated --entity value into normalized names.
+func splitEntities(value string) []string {
+	var out []string
+	for _, e := range strings.Split(value, ",") {
+		if e = processing.NormalizeEntity(e); e != "" {
+			out = append(out, e)
+		}
 	}
+	return out
 }
 
-// helper to convert storage.Document → string slice for graph.State
-func convertDocs(docs []storage.Document) []string {
-	out := make([]string, len(docs))
-	for i, d := range docs {
-		out[i] = fmt.Sprintf("File: %s\n%s", d.Filename, d.Content)
+// splitCollections parses a comma-separated --collections value.
+func splitCollections(value string) []string {
+	var out []string
+	for _, c := range strings.Split(value, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			out = append(out, c)
+		}
 	}
 	return out
 }
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
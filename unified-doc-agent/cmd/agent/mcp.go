@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/cli"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/config"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/graph"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ingestion"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/mcp"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ollama"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+func mcpCommand() *cli.Command {
+	var tags, roots *string
+	return &cli.Command{
+		Name:  "mcp",
+		Short: "serve the document tools to an MCP client over stdio",
+		Flags: func(fs *flag.FlagSet) {
+			tags = fs.String("access-tags", "", "comma-separated access tags the client may see; untagged documents are always visible (* = all)")
+			roots = fs.String("roots", "", "comma-separated directories the client may index and search under; the workspace roots the client lists only narrow them (default: no limit beyond the client's roots)")
+		},
+		Run: func(c *cli.Context) error {
+			runMCP(setup(), *tags, *roots)
+			return nil
+		},
+	}
+}
+
+// runMCP serves the index_path, search_documents and ask_documents tools
+// to an MCP client over stdin and stdout until the client disconnects,
+// reaching only the files under roots, when set, and the client's
+// workspace roots. Stdout carries the protocol only; warnings go to
+// stderr, which clients show in their logs.
+func runMCP(cfg *config.Config, tags, roots string) {
+
+	if err := ollama.Ping(context.Background(), requiredModels(cfg)...); err != nil {
+		log.Printf("warning: %v", err)
+	}
+	warnMissingTools()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var dirs []string
+	for _, r := range strings.Split(roots, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			dirs = append(dirs, r)
+		}
+	}
+	srv := mcp.New(mcp.NewTools(graph.NewStore(storage.Default()), config.SplitTags(tags), dirs))
+	err := srv.Serve(ctx, os.Stdin, os.Stdout)
+	ingestion.CloseOCR()
+	storage.Default().Close()
+	if err != nil && ctx.Err() == nil {
+		log.Fatal("mcp:", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/cli"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/config"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ollama"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// checkEmbeddingModel makes sure queries and new chunks are embedded with
+// the model the stored chunks were embedded with. Vectors from different
+// models are not comparable, so a mismatch is fatal rather than silently
+// returning nonsense neighbours.
+func checkEmbeddingModel(cfg *config.Config) {
+	model, dim, ok, err := storage.ActiveEmbedding()
+	if err != nil {
+		log.Fatal("reading embedding settings:", err)
+	}
+	if !ok {
+		if err := storage.SetActiveEmbedding(cfg.EmbedModel, processing.EmbeddingDim); err != nil {
+			log.Fatal("recording embedding settings:", err)
+		}
+		return
+	}
+	processing.EmbeddingDim = dim
+	if model != cfg.EmbedModel {
+		log.Fatalf("stored embeddings were made with %q but embed_model is %q; run `agent migrate-embeddings --model %s` to switch, or set embed_model back to %q",
+			model, cfg.EmbedModel, cfg.EmbedModel, model)
+	}
+}
+
+func migrateEmbeddingsCommand() *cli.Command {
+	var model *string
+	var batch *int
+	var force *bool
+	return &cli.Command{
+		Name:  "migrate-embeddings",
+		Short: "re-embed every stored chunk with another embedding model",
+		Flags: func(fs *flag.FlagSet) {
+			model = fs.String("model", "", "embedding model to switch to (required)")
+			batch = fs.Int("batch", 32, "chunks embedded per batch")
+			force = fs.Bool("force", false, "re-embed even if the stored embeddings use --model already")
+		},
+		Run: func(c *cli.Context) error {
+			if *model == "" {
+				return c.Usage()
+			}
+			// The stored model is not checked against the config here:
+			// migrating is how a mismatch is resolved.
+			cfg := loadConfig()
+			openDB(cfg)
+			runMigrateEmbeddings(cfg, *model, *batch, *force)
+			return nil
+		},
+	}
+}
+
+// runMigrateEmbeddings re-embeds every stored chunk with a new model into a
+// staging column, then swaps it in and records the new model atomically.
+// With force it re-embeds with the current model, e.g. after pulling a
+// newer version of it.
+func runMigrateEmbeddings(cfg *config.Config, model string, batch int, force bool) {
+	if len(cfg.EmbedModels) > 0 {
+		log.Fatal("migrate-embeddings re-embeds every chunk with one model; clear embed_models first")
+	}
+	current, _, _, err := storage.ActiveEmbedding()
+	if err != nil {
+		log.Fatal("reading embedding settings:", err)
+	}
+	if current == model && !force {
+		fmt.Printf("Stored embeddings already use %s.\n", model)
+		return
+	}
+
+	ctx := context.Background()
+	if err := ollama.Ping(ctx, model); err != nil {
+		log.Fatal(err)
+	}
+	dim, err := processing.ProbeDimension(ctx, model)
+	if err != nil {
+		log.Fatal("probing model:", err)
+	}
+	version, err := processing.EmbedVersion(ctx, model)
+	if err != nil {
+		log.Fatal("reading model version:", err)
+	}
+	total, err := storage.CountChunks()
+	if err != nil {
+		log.Fatal("counting chunks:", err)
+	}
+	log.Printf("Re-embedding %d chunks with %s@%s (%d dimensions)", total, model, version, dim)
+
+	if err := storage.PrepareNextEmbedding(dim); err != nil {
+		log.Fatal("preparing staging column:", err)
+	}
+	processing.EmbeddingDim = dim
+	if err := reembedAll(ctx, model, batch, total); err != nil {
+		storage.DropNextEmbedding()
+		log.Fatal("migration aborted, queries still use the old model: ", err)
+	}
+	if err := storage.SwapEmbeddings(model, version, dim); err != nil {
+		storage.DropNextEmbedding()
+		log.Fatal("migration aborted, queries still use the old model: ", err)
+	}
+
+	path := config.Path()
+	fileCfg, err := config.LoadFile(path)
+	if err == nil {
+		fileCfg.EmbedModel = model
+		err = fileCfg.Save(path)
+	}
+	if err != nil {
+		log.Printf("warning: embeddings migrated but %s was not updated: %v; set embed_model to %s", path, err, model)
+	} else if os.Getenv("UDA_EMBED_MODEL") != "" {
+		log.Printf("warning: UDA_EMBED_MODEL is set and overrides embed_model; update it to %s", model)
+	}
+	fmt.Printf("Migrated %d chunks to %s.\n", total, model)
+}
+
+func reembedAll(ctx context.Context, model string, batch, total int) error {
+	if batch < 1 {
+		return errors.New("batch must be positive")
+	}
+	done, lastID := 0, 0
+	for {
+		docs, err := storage.ChunksAfter(lastID, batch)
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+		texts := make([]string, len(docs))
+		for i, d := range docs {
+			texts[i] = d.Content
+		}
+		embs, err := processing.EmbedChunksWithModel(ctx, model, texts)
+		if err != nil {
+			return err
+		}
+		ids := make([]int, len(docs))
+		for i, d := range docs {
+			ids[i] = d.ID
+		}
+		if err := storage.SetNextEmbeddings(ids, embs); err != nil {
+			return err
+		}
+		lastID = docs[len(docs)-1].ID
+		done += len(docs)
+		log.Printf("  %d/%d chunks", done, total)
+	}
+}
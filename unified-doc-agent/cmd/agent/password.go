@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// stdinReader reads the passwords typed by the user.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// isTerminal reports whether stdin is a terminal someone can type into.
+func isTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// promptPassword asks on the terminal for the password of the encrypted
+// PDF name, without echoing it where stty is available. An empty answer
+// skips the file.
+func promptPassword(name string) string {
+	fmt.Fprintf(os.Stderr, "\n%s is encrypted; password (empty to skip): ", name)
+	if stty("-echo") == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, _ := stdinReader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
+
+// stty changes a setting of the terminal on stdin.
+func stty(setting string) error {
+	cmd := exec.Command("stty", setting)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
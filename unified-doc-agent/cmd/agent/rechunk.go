@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/cli"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/config"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/indexer"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ollama"
+)
+
+func rechunkCommand() *cli.Command {
+	var prefix *string
+	var quiet, asJSON *bool
+	return &cli.Command{
+		Name:  "rechunk",
+		Short: "re-chunk and re-embed indexed files from their stored text",
+		Flags: func(fs *flag.FlagSet) {
+			prefix = fs.String("path", "", "only re-chunk files whose indexed name starts with this (default: all)")
+			quiet = fs.Bool("quiet", false, "no progress bar, only the final summary")
+			asJSON = fs.Bool("json", false, "print the final summary as JSON and nothing else")
+		},
+		Run: func(c *cli.Context) error {
+			runRechunk(setup(), *prefix, *quiet, *asJSON)
+			return nil
+		},
+	}
+}
+
+// runRechunk re-chunks and re-embeds indexed files from their stored text
+// after chunk_size, chunk_overlap or the embedding settings changed, so
+// nothing has to be extracted or OCR'd again.
+func runRechunk(cfg *config.Config, prefix string, quiet, asJSON bool) {
+	if err := ollama.Ping(context.Background(), requiredModels(cfg)...); err != nil {
+		log.Fatal(err)
+	}
+	var rep indexer.Reporter
+	if !quiet && !asJSON {
+		rep = indexer.NewProgressBar(os.Stderr)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	res, err := indexer.Rechunk(ctx, prefix, rep)
+	if err != nil && res == nil {
+		log.Fatal("rechunk:", err)
+	}
+	if asJSON {
+		printJSON(res)
+	} else {
+		indexer.PrintSummary(os.Stdout, res)
+	}
+	if err != nil {
+		log.Fatal("rechunk:", err)
+	}
+	if res.Failed > 0 {
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/cli"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/indexer"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// runsCommand lists the recorded index runs or shows one in full, so
+// failures are not lost with the logs of the run.
+func runsCommand() *cli.Command {
+	var limit *int
+	var listJSON, showJSON *bool
+	return &cli.Command{
+		Name:  "runs",
+		Short: "list the recorded index runs or show one",
+		Commands: []*cli.Command{
+			{
+				Name:  "list",
+				Short: "list the most recent index runs",
+				Flags: func(fs *flag.FlagSet) {
+					limit = fs.Int("n", 20, "how many of the most recent runs to list")
+					listJSON = fs.Bool("json", false, "print the runs as JSON")
+				},
+				Run: func(c *cli.Context) error {
+					setup()
+					runs, err := storage.IndexRuns(*limit)
+					if err != nil {
+						log.Fatal("runs:", err)
+					}
+					if *listJSON {
+						printJSON(runs)
+						return nil
+					}
+					if len(runs) == 0 {
+						fmt.Println("No runs recorded yet.")
+						return nil
+					}
+					tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(tw, "ID\tKIND\tSTATUS\tSTARTED\tDURATION\tADDED\tUPDATED\tFAILED\tCHUNKS\tROOT")
+					for _, r := range runs {
+						fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\n", r.ID, r.Kind, r.Status,
+							r.StartedAt.Format("2006-01-02 15:04"), runDuration(r), r.Added, r.Updated, r.Failed, r.Chunks, r.Root)
+					}
+					tw.Flush()
+					return nil
+				},
+			},
+			{
+				Name:  "show",
+				Short: "show an index run and its report",
+				Args:  "<id>",
+				Flags: func(fs *flag.FlagSet) {
+					showJSON = fs.Bool("json", false, "print the run and its report as JSON")
+				},
+				Run: func(c *cli.Context) error {
+					if len(c.Args) != 1 {
+						return c.Usage()
+					}
+					id, err := strconv.Atoi(c.Args[0])
+					if err != nil {
+						log.Fatalf("runs: invalid run id %q", c.Args[0])
+					}
+					setup()
+					r, err := storage.GetIndexRun(id)
+					if err != nil {
+						log.Fatal("runs:", err)
+					}
+					if r == nil {
+						log.Fatalf("runs: no run %d", id)
+					}
+					if *showJSON {
+						printJSON(r)
+						return nil
+					}
+					printRun(r)
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// printRun writes a run's details and, when it finished, its report.
+func printRun(r *storage.IndexRun) {
+	fmt.Printf("Run %d (%s) over %s\n", r.ID, r.Kind, r.Root)
+	fmt.Printf("  status:      %s\n", r.Status)
+	if r.Error != "" {
+		fmt.Printf("  error:       %s\n", r.Error)
+	}
+	fmt.Printf("  source:      %s\n  embed model: %s\n", r.Source, r.EmbedModel)
+	fmt.Printf("  started:     %s\n", r.StartedAt.Format(time.DateTime))
+	if r.FinishedAt != nil {
+		fmt.Printf("  finished:    %s\n", r.FinishedAt.Format(time.DateTime))
+	}
+	if len(r.Report) == 0 {
+		fmt.Printf("  files done:  %d (last %s)\n", r.FilesDone, r.LastFile)
+		return
+	}
+	var res indexer.Result
+	if err := json.Unmarshal(r.Report, &res); err != nil {
+		log.Fatalf("runs: report of run %d: %v", r.ID, err)
+	}
+	fmt.Println()
+	indexer.PrintSummary(os.Stdout, &res)
+}
+
+// runDuration is how long a finished run took.
+func runDuration(r storage.IndexRun) string {
+	if r.FinishedAt == nil {
+		return "-"
+	}
+	return r.FinishedAt.Sub(r.StartedAt).Round(time.Second).String()
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,216 @@
+// Package cli is the agent's command framework: a tree of commands, each
+// with its own flags, where flags a command marks persistent apply to
+// every command below it too. Help is generated from the tree, as are the
+// shell completion scripts of `agent completion`.
+//
+// It stands in for spf13/cobra, which is not among the module's
+// dependencies: it is built on the standard flag package alone.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// ErrUsage is returned for a command line that names no runnable command
+// or has invalid flags; its help has been printed already.
+var ErrUsage = errors.New("invalid usage")
+
+// Command is a command of the tree: one that runs, or a group of
+// subcommands, or both.
+type Command struct {
+	Name string
+	// Short is the one line description listed in the parent's help.
+	Short string
+	// Long, if set, describes the command in its own help.
+	Long string
+	// Args names the arguments that follow the flags, e.g. "<id>".
+	Args string
+
+	// Flags defines the command's flags on fs, and PersistentFlags those
+	// of the command and every command below it.
+	Flags           func(fs *flag.FlagSet)
+	PersistentFlags func(fs *flag.FlagSet)
+
+	// Run runs the command with the arguments left after its flags. A
+	// command without Run only groups its subcommands.
+	Run func(c *Context) error
+
+	Commands []*Command
+}
+
+// Context is what a command runs with.
+type Context struct {
+	// Args are the arguments after the command's flags.
+	Args []string
+	// Flags are the command's parsed flags, persistent ones included.
+	Flags *flag.FlagSet
+	// Command is the command running, and Path the names leading to it
+	// from the root.
+	Command *Command
+	Path    []string
+}
+
+// IsSet reports whether the flag name was given on the command line.
+func (c *Context) IsSet(name string) bool {
+	set := false
+	c.Flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Usage prints the command's help to stderr and returns ErrUsage, for
+// commands given the wrong arguments.
+func (c *Context) Usage() error {
+	writeHelp(os.Stderr, c.Command, c.Path, c.Flags)
+	return ErrUsage
+}
+
+// Find returns the subcommand called name, or nil.
+func (c *Command) Find(name string) *Command {
+	for _, sub := range c.Commands {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// Execute runs the command args name, below c; args exclude the program
+// name. `help [command...]` and -h print help instead.
+func (c *Command) Execute(args []string) error {
+	if len(args) > 0 && args[0] == "help" {
+		return c.help(args[1:])
+	}
+
+	cmd, path := c, []string{c.Name}
+	var inherited []*flag.Flag
+	for {
+		fs, persistent := flagSet(cmd, path, inherited)
+		inherited = append(inherited, persistent...)
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				writeHelp(os.Stdout, cmd, path, fs)
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "%s: %v\n", strings.Join(path, " "), err)
+			writeHelp(os.Stderr, cmd, path, fs)
+			return ErrUsage
+		}
+		rest := fs.Args()
+
+		if len(cmd.Commands) > 0 && len(rest) > 0 {
+			if sub := cmd.Find(rest[0]); sub != nil {
+				cmd, path, args = sub, append(path, sub.Name), rest[1:]
+				continue
+			}
+			if cmd.Run == nil {
+				fmt.Fprintf(os.Stderr, "%s: unknown command %q\n", strings.Join(path, " "), rest[0])
+				writeHelp(os.Stderr, cmd, path, fs)
+				return ErrUsage
+			}
+		}
+		if cmd.Run == nil {
+			writeHelp(os.Stderr, cmd, path, fs)
+			return ErrUsage
+		}
+		return cmd.Run(&Context{Args: rest, Flags: fs, Command: cmd, Path: path})
+	}
+}
+
+// help prints the help of the command names lead to.
+func (c *Command) help(names []string) error {
+	cmd, path := c, []string{c.Name}
+	var inherited []*flag.Flag
+	for {
+		fs, persistent := flagSet(cmd, path, inherited)
+		inherited = append(inherited, persistent...)
+		if len(names) == 0 {
+			writeHelp(os.Stdout, cmd, path, fs)
+			return nil
+		}
+		sub := cmd.Find(names[0])
+		if sub == nil {
+			fmt.Fprintf(os.Stderr, "%s: unknown command %q\n", strings.Join(path, " "), names[0])
+			return ErrUsage
+		}
+		cmd, path, names = sub, append(path, sub.Name), names[1:]
+	}
+}
+
+// flagSet returns the flags of cmd: its own, its persistent ones, which it
+// also returns, and those inherited. Inherited flags share their values
+// with the ancestors' flag sets, so they keep what was parsed there.
+func flagSet(cmd *Command, path []string, inherited []*flag.Flag) (*flag.FlagSet, []*flag.Flag) {
+	fs := flag.NewFlagSet(strings.Join(path, " "), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	for _, f := range inherited {
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+	var persistent []*flag.Flag
+	if cmd.PersistentFlags != nil {
+		pfs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+		cmd.PersistentFlags(pfs)
+		pfs.VisitAll(func(f *flag.Flag) {
+			fs.Var(f.Value, f.Name, f.Usage)
+			persistent = append(persistent, f)
+		})
+	}
+	if cmd.Flags != nil {
+		cmd.Flags(fs)
+	}
+	return fs, persistent
+}
+
+// writeHelp writes the usage of cmd, its subcommands and its flags.
+func writeHelp(w io.Writer, cmd *Command, path []string, fs *flag.FlagSet) {
+	usage := "Usage: " + strings.Join(path, " ")
+	if len(cmd.Commands) > 0 {
+		usage += " <command>"
+	}
+	if hasFlags(fs) {
+		usage += " [flags]"
+	}
+	if cmd.Args != "" {
+		usage += " " + cmd.Args
+	}
+	fmt.Fprintln(w, usage)
+
+	if desc := cmd.Long; desc != "" || cmd.Short != "" {
+		if desc == "" {
+			desc = cmd.Short
+		}
+		fmt.Fprintf(w, "\n%s\n", desc)
+	}
+	if len(cmd.Commands) > 0 {
+		fmt.Fprintln(w, "\nCommands:")
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, sub := range cmd.Commands {
+			fmt.Fprintf(tw, "  %s\t%s\n", sub.Name, sub.Short)
+		}
+		tw.Flush()
+	}
+	if hasFlags(fs) {
+		fmt.Fprintln(w, "\nFlags:")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+		fs.SetOutput(io.Discard)
+	}
+	if len(cmd.Commands) > 0 {
+		fmt.Fprintf(w, "\nRun `%s help <command>` for the flags of a command.\n", path[0])
+	}
+}
+
+func hasFlags(fs *flag.FlagSet) bool {
+	has := false
+	fs.VisitAll(func(*flag.Flag) { has = true })
+	return has
+}
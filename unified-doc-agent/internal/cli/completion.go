@@ -0,0 +1,243 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// CompletionCommand returns the command printing the completion script of
+// root for a shell: bash, zsh or fish. The scripts are generated from the
+// command tree, so they complete the commands and flags of the build that
+// printed them.
+func CompletionCommand(root *Command) *Command {
+	return &Command{
+		Name:  "completion",
+		Short: "print the shell completion script for bash, zsh or fish",
+		Long: "Print the shell completion script for bash, zsh or fish. To load it:\n\n" +
+			"  bash: source <(" + root.Name + " completion bash)\n" +
+			"  zsh:  source <(" + root.Name + " completion zsh)\n" +
+			"  fish: " + root.Name + " completion fish | source",
+		Args: "<bash|zsh|fish>",
+		Run: func(c *Context) error {
+			if len(c.Args) != 1 {
+				return c.Usage()
+			}
+			switch c.Args[0] {
+			case "bash":
+				writeBash(os.Stdout, root)
+			case "zsh":
+				writeZsh(os.Stdout, root)
+			case "fish":
+				writeFish(os.Stdout, root)
+			default:
+				fmt.Fprintf(os.Stderr, "completion: unsupported shell %q\n", c.Args[0])
+				return c.Usage()
+			}
+			return nil
+		},
+	}
+}
+
+// node is a command of the tree with its path and flags, for generating
+// completions.
+type node struct {
+	cmd  *Command
+	path []string
+	// flags are all the flags of the command, own is those it defines
+	// itself and persistent those it passes down.
+	flags, own, persistent []*flag.Flag
+}
+
+// walk returns the commands of the tree below root, parents first.
+func walk(root *Command) []node {
+	var nodes []node
+	var visit func(cmd *Command, path []string, inherited []*flag.Flag)
+	visit = func(cmd *Command, path []string, inherited []*flag.Flag) {
+		fs, persistent := flagSet(cmd, path, inherited)
+		n := node{cmd: cmd, path: path, persistent: persistent}
+		isPersistent := map[string]bool{}
+		for _, f := range append(inherited, persistent...) {
+			isPersistent[f.Name] = true
+		}
+		fs.VisitAll(func(f *flag.Flag) {
+			n.flags = append(n.flags, f)
+			if !isPersistent[f.Name] {
+				n.own = append(n.own, f)
+			}
+		})
+		nodes = append(nodes, n)
+		inherited = append(inherited, persistent...)
+		for _, sub := range cmd.Commands {
+			visit(sub, append(append([]string{}, path...), sub.Name), inherited)
+		}
+	}
+	visit(root, []string{root.Name}, nil)
+	return nodes
+}
+
+// takesValue reports whether f is followed by a value, unlike -f=value and
+// boolean flags.
+func takesValue(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+func funcName(root *Command) string {
+	return "_" + strings.NewReplacer("-", "_", ".", "_").Replace(root.Name)
+}
+
+func writeBash(w io.Writer, root *Command) {
+	nodes := walk(root)
+	valueFlags := map[string]bool{}
+	for _, n := range nodes {
+		for _, f := range n.flags {
+			if takesValue(f) {
+				valueFlags["-"+f.Name] = true
+			}
+		}
+	}
+	var values []string
+	for name := range valueFlags {
+		values = append(values, name)
+	}
+	sort.Strings(values)
+
+	fn := funcName(root)
+	fmt.Fprintf(w, "# bash completion for %s, generated by `%s completion bash`\n\n", root.Name, root.Name)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintf(w, "\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	fmt.Fprintf(w, "\tlocal values=\" %s \"\n", strings.Join(values, " "))
+	fmt.Fprintf(w, "\tlocal path=%q w i\n\n", root.Name)
+
+	// Find the command being completed, skipping flags and their values
+	fmt.Fprintf(w, "\tfor ((i = 1; i < COMP_CWORD; i++)); do\n")
+	fmt.Fprintf(w, "\t\tw=\"${COMP_WORDS[i]}\"\n")
+	fmt.Fprintf(w, "\t\tcase \"$w\" in\n")
+	fmt.Fprintf(w, "\t\t-*)\n")
+	fmt.Fprintf(w, "\t\t\t[[ $w != *=* && $values == *\" ${w/#--/-} \"* ]] && ((i++))\n")
+	fmt.Fprintf(w, "\t\t\tcontinue ;;\n")
+	fmt.Fprintf(w, "\t\tesac\n")
+	fmt.Fprintf(w, "\t\tcase \"$path $w\" in\n")
+	var paths []string
+	for _, n := range nodes[1:] {
+		paths = append(paths, fmt.Sprintf("%q", strings.Join(n.path, " ")))
+	}
+	fmt.Fprintf(w, "\t\t%s) path=\"$path $w\" ;;\n", strings.Join(paths, "|"))
+	fmt.Fprintf(w, "\t\t*) break ;;\n")
+	fmt.Fprintf(w, "\t\tesac\n")
+	fmt.Fprintf(w, "\tdone\n\n")
+
+	// A flag's value completes as a file
+	fmt.Fprintf(w, "\tif [[ $prev == -* && $prev != *=* && $values == *\" ${prev/#--/-} \"* ]]; then\n")
+	fmt.Fprintf(w, "\t\treturn\n")
+	fmt.Fprintf(w, "\tfi\n\n")
+
+	fmt.Fprintf(w, "\tlocal commands flags\n")
+	fmt.Fprintf(w, "\tcase \"$path\" in\n")
+	for _, n := range nodes {
+		var commands, flags []string
+		for _, sub := range n.cmd.Commands {
+			commands = append(commands, sub.Name)
+		}
+		if n.cmd == root {
+			commands = append(commands, "help")
+		}
+		for _, f := range n.flags {
+			flags = append(flags, "-"+f.Name)
+		}
+		fmt.Fprintf(w, "\t%q)\n", strings.Join(n.path, " "))
+		fmt.Fprintf(w, "\t\tcommands=%q\n", strings.Join(commands, " "))
+		fmt.Fprintf(w, "\t\tflags=%q ;;\n", strings.Join(flags, " "))
+	}
+	fmt.Fprintf(w, "\tesac\n\n")
+
+	fmt.Fprintf(w, "\tif [[ $cur == -* ]]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "\telif [[ -n $commands ]]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W \"$commands\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "\tfi\n")
+	fmt.Fprintf(w, "}\n\n")
+	fmt.Fprintf(w, "complete -o default -F %s %s\n", fn, root.Name)
+}
+
+// writeZsh writes the bash script wrapped for zsh's bashcompinit, which
+// runs bash completion functions.
+func writeZsh(w io.Writer, root *Command) {
+	fmt.Fprintf(w, "#compdef %s\n", root.Name)
+	fmt.Fprintf(w, "# zsh completion for %s, generated by `%s completion zsh`\n\n", root.Name, root.Name)
+	fmt.Fprintf(w, "autoload -U +X compinit && compinit\n")
+	fmt.Fprintf(w, "autoload -U +X bashcompinit && bashcompinit\n\n")
+	writeBash(w, root)
+}
+
+func writeFish(w io.Writer, root *Command) {
+	fmt.Fprintf(w, "# fish completion for %s, generated by `%s completion fish`\n\n", root.Name, root.Name)
+	fmt.Fprintf(w, "complete -c %s -f\n", root.Name)
+	for _, n := range walk(root) {
+		// at is the condition for completing the command itself, under
+		// that for completing it or any command below it
+		var under string
+		for i, name := range n.path[1:] {
+			if i > 0 {
+				under += "; and "
+			}
+			under += "__fish_seen_subcommand_from " + name
+		}
+		at := "__fish_use_subcommand"
+		if under != "" {
+			at = under
+			if len(n.cmd.Commands) > 0 {
+				var children []string
+				for _, sub := range n.cmd.Commands {
+					children = append(children, sub.Name)
+				}
+				at += "; and not __fish_seen_subcommand_from " + strings.Join(children, " ")
+			}
+		}
+
+		for _, sub := range n.cmd.Commands {
+			fmt.Fprintf(w, "complete -c %s -n %s -a %s -d %s\n", root.Name, fishQuote(at), sub.Name, fishQuote(sub.Short))
+		}
+		if n.cmd == root {
+			fmt.Fprintf(w, "complete -c %s -n %s -a help -d %s\n", root.Name, fishQuote(at), fishQuote("show the help of a command"))
+		}
+		for _, f := range n.own {
+			writeFishFlag(w, root.Name, at, f)
+		}
+		for _, f := range n.persistent {
+			writeFishFlag(w, root.Name, under, f)
+		}
+		if n.cmd.Run != nil && len(n.cmd.Commands) == 0 && n.cmd.Args != "" {
+			fmt.Fprintf(w, "complete -c %s -n %s -F\n", root.Name, fishQuote(at))
+		}
+	}
+}
+
+func writeFishFlag(w io.Writer, name, condition string, f *flag.Flag) {
+	fmt.Fprintf(w, "complete -c %s", name)
+	if condition != "" {
+		fmt.Fprintf(w, " -n %s", fishQuote(condition))
+	}
+	fmt.Fprintf(w, " -o %s", f.Name)
+	if takesValue(f) {
+		fmt.Fprintf(w, " -r -F")
+	}
+	_, usage := flag.UnquoteUsage(f)
+	fmt.Fprintf(w, " -d %s\n", fishQuote(firstLine(usage)))
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// fishQuote quotes s for fish, which expands nothing in single quotes.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
@@ -1,13 +1,10 @@
 package graph
 
-import (
-	"context"
-	"fmt"
-)
+import "context"
 
-// AnswerNode prints the final answer to console. You can replace it to return JSON.
+// AnswerNode ends the workflow. The result is handed to the configured
+// Output (console text or JSON) by RunWorkflow once the graph has run, so
+// that its timings include every node, this one too.
 func AnswerNode(ctx context.Context, s *State) error {
-	fmt.Println("\n===== ANSWER =====\n")
-	fmt.Println(s.Ans)
 	return nil
 }
@@ -0,0 +1,44 @@
+package graph
+
+import (
+	"context"
+	"strings"
+)
+
+// vagueWords are queries that carry no searchable intent on their own.
+var vagueWords = map[string]bool{
+	"it": true, "this": true, "that": true, "these": true, "those": true,
+	"what": true, "why": true, "how": true, "help": true, "info": true,
+	"stuff": true, "things": true, "more": true, "details": true,
+}
+
+// isAmbiguous reports whether the query is too vague to retrieve against.
+func isAmbiguous(s *State) bool {
+	words := strings.Fields(strings.ToLower(s.Query))
+	if len(words) == 0 {
+		return true
+	}
+	for _, w := range words {
+		if !vagueWords[strings.Trim(w, "?!.,")] {
+			return false
+		}
+	}
+	return true
+}
+
+// ClarifyNode asks the user to narrow down an ambiguous query instead of
+// running retrieval on it.
+func ClarifyNode(ctx context.Context, s *State) error {
+	s.Update(func(s *State) {
+		s.Ans = "Your query is too vague to search for. Please mention a topic, document name, or keyword, e.g. \"summarize the Q3 invoice from Acme\"."
+	})
+	return nil
+}
+
+// NoResultsNode answers when retrieval found nothing, so the summarizer is not called.
+func NoResultsNode(ctx context.Context, s *State) error {
+	s.Update(func(s *State) {
+		s.Ans = "No documents found matching the query."
+	})
+	return nil
+}
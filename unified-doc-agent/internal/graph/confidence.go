@@ -0,0 +1,200 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// MinConfidence is the confidence below which a run answers that the
+// documents do not contain the answer instead of returning the summary,
+// overridden from the agent config at startup; zero never refuses.
+var MinConfidence = 0.35
+
+// Critic verdicts.
+const (
+	VerdictOK = "ok"
+	// VerdictShort answers are too short to be useful.
+	VerdictShort = "short"
+	// VerdictUnsupported answers say the documents do not answer the query.
+	VerdictUnsupported = "unsupported"
+)
+
+// Weights of the confidence signals; they add up to 1.
+const (
+	retrievalWeight = 0.5
+	coverageWeight  = 0.3
+	criticWeight    = 0.2
+)
+
+// refusalAnswer starts the answer of a run below MinConfidence.
+const refusalAnswer = "I couldn't find this in your documents."
+
+// nearestMatches is how many chunks a refusal lists.
+const nearestMatches = 3
+
+// Confidence is how far a run's answer can be trusted, with the signals it
+// was computed from.
+type Confidence struct {
+	// Score is the weighted sum of the signals, between 0 and 1.
+	Score float64 `json:"score"`
+	// Retrieval is the mean score of the best retrieved chunks.
+	Retrieval float64 `json:"retrieval"`
+	// Coverage is the share of the answer's words found in the retrieved
+	// chunks.
+	Coverage float64 `json:"coverage"`
+	// Verdict is the critic's verdict on the answer.
+	Verdict string `json:"verdict"`
+	// Refused is set when the answer was replaced by a refusal.
+	Refused bool `json:"refused,omitempty"`
+}
+
+// unsupportedPhrases are how models say the documents lack the answer.
+var unsupportedPhrases = []string{
+	"do not contain", "does not contain", "don't contain", "doesn't contain",
+	"no information", "not mentioned", "not provided", "no mention",
+	"cannot find", "can't find", "could not find", "couldn't find",
+	"unable to find", "not enough information",
+}
+
+// criticVerdict judges an answer without calling the LLM.
+func criticVerdict(ans string) string {
+	lower := strings.ToLower(ans)
+	for _, p := range unsupportedPhrases {
+		if strings.Contains(lower, p) {
+			return VerdictUnsupported
+		}
+	}
+	if len(strings.TrimSpace(ans)) < 50 {
+		return VerdictShort
+	}
+	return VerdictOK
+}
+
+// retrievalSignal is the mean score of up to nearestMatches of the best
+// chunks; pinned chunks count as a perfect match.
+func retrievalSignal(docs []Chunk) float64 {
+	n, sum := 0, 0.0
+	for _, d := range docs {
+		if n == nearestMatches {
+			break
+		}
+		sum += min(d.Score, 1)
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// answerCoverage is the share of the answer's words of four or more
+// letters that occur in the retrieved chunks.
+func answerCoverage(ans string, docs []Chunk) float64 {
+	var text strings.Builder
+	for _, d := range docs {
+		text.WriteString(strings.ToLower(d.Content))
+		text.WriteByte(' ')
+	}
+	corpus := text.String()
+	words := strings.FieldsFunc(strings.ToLower(ans), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	total, found := 0, 0
+	for _, w := range words {
+		if len([]rune(w)) < 4 {
+			continue
+		}
+		total++
+		if strings.Contains(corpus, w) {
+			found++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(found) / float64(total)
+}
+
+// scoreConfidence combines the signals for the state's answer and docs.
+func scoreConfidence(s *State) Confidence {
+	c := Confidence{
+		Retrieval: retrievalSignal(s.Docs),
+		Coverage:  answerCoverage(s.Ans, s.Docs),
+		Verdict:   s.Verdict,
+	}
+	if c.Verdict == "" {
+		c.Verdict = criticVerdict(s.Ans)
+	}
+	critic := map[string]float64{VerdictOK: 1, VerdictShort: 0.5}[c.Verdict]
+	c.Score = retrievalWeight*c.Retrieval + coverageWeight*c.Coverage + criticWeight*critic
+	return c
+}
+
+// ConfidenceNode scores the answer and, below the run's minimum
+// confidence, replaces it with a refusal listing the nearest matches so
+// that a summary of unrelated chunks is not passed off as an answer.
+// Otherwise it checks the passages the answer quotes against the cited
+// documents.
+func ConfidenceNode(ctx context.Context, s *State) error {
+	c := scoreConfidence(s)
+	threshold := s.ConfidenceThreshold()
+	var quotes []QuoteCheck
+	if c.Score >= threshold {
+		quotes = checkQuotes(s)
+	}
+	s.Update(func(s *State) {
+		if c.Score < threshold {
+			c.Refused = true
+			s.Ans = refusal(s.Docs)
+		}
+		s.Confidence = &c
+		s.Quotes = quotes
+	})
+	return nil
+}
+
+// ConfidenceThreshold is the confidence below which the run refuses to
+// answer: its MinConfidence, or else the package's. Zero never refuses.
+func (s *State) ConfidenceThreshold() float64 {
+	if s.MinConfidence > 0 {
+		return s.MinConfidence
+	}
+	return MinConfidence
+}
+
+// refusal is the answer given instead of a low-confidence summary.
+func refusal(docs []Chunk) string {
+	var b strings.Builder
+	b.WriteString(refusalAnswer)
+	if len(docs) == 0 {
+		return b.String()
+	}
+	b.WriteString(" The nearest matches were:\n")
+	for i, d := range docs {
+		if i == nearestMatches {
+			break
+		}
+		where := d.Filename
+		if d.Page > 0 {
+			where = fmt.Sprintf("%s (page %d)", d.Filename, d.Page)
+		}
+		fmt.Fprintf(&b, "  - %s: %s\n", where, snippet(d.Content, 120))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// snippet returns the start of text on one line, cut at a word boundary
+// after at most n bytes.
+func snippet(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= n {
+		return text
+	}
+	cut := strings.ToValidUTF8(text[:n], "")
+	if i := strings.LastIndexByte(cut, ' '); i > n/2 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
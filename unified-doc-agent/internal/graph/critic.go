@@ -2,10 +2,22 @@ package graph
 
 import "context"
 
-// Critic can improve or validate the summary. Currently a small heuristic.
+// Critic can improve or validate the summary. Currently a small heuristic:
+// it records a verdict for the confidence score, and short answers are
+// rewritten with the critic prompt template.
 func CriticNode(ctx context.Context, s *State) error {
+	s.Verdict = criticVerdict(s.Ans)
 	if len(s.Ans) < 50 {
-		s.Ans = s.Ans + "\n\n(Note: result short; consider rephrasing your query or indexing more documents.)"
+		ans, err := renderPrompt(PromptCritic, PromptData{
+			Query:     s.Query,
+			Docs:      s.Docs,
+			Citations: s.Result().Citations,
+			Answer:    s.Ans,
+		})
+		if err != nil {
+			return err
+		}
+		s.Ans = ans
 	}
 	return nil
 }
@@ -0,0 +1,94 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+)
+
+// Summary lengths for SummarizeDocument.
+const (
+	LengthShort    = "short"
+	LengthDetailed = "detailed"
+)
+
+// SectionChars is the most text summarized by one LLM call. Longer
+// documents are split into sections whose summaries are then merged.
+var SectionChars = 8000
+
+// SummarizeDocument summarizes text, the content of the file name, without
+// retrieval. Text that does not fit in one section is summarized section by
+// section and the summaries merged, repeating until they fit in one call.
+// Only the final call's tokens go to onToken; progress, if not nil, is told
+// about each intermediate call.
+func SummarizeDocument(ctx context.Context, name, text, length string, onToken func(string), progress func(done, total int)) (string, error) {
+	if length != LengthShort && length != LengthDetailed {
+		return "", fmt.Errorf("unknown summary length %q (expected %s or %s)", length, LengthShort, LengthDetailed)
+	}
+	sections := splitSections(processing.ChunkText(text), SectionChars)
+	if len(sections) == 0 {
+		return "", fmt.Errorf("%s: no text to summarize", name)
+	}
+	data := PromptData{Filename: name, Length: length}
+	if len(sections) == 1 {
+		data.Text = sections[0]
+		return runPrompt(ctx, PromptDocSection, data, onToken)
+	}
+
+	for {
+		summaries := make([]string, len(sections))
+		for i, sec := range sections {
+			data.Text = sec
+			sum, err := runPrompt(ctx, PromptDocSection, data, nil)
+			if err != nil {
+				return "", fmt.Errorf("section %d: %w", i+1, err)
+			}
+			summaries[i] = strings.TrimSpace(sum)
+			if progress != nil {
+				progress(i+1, len(sections))
+			}
+		}
+		merged := splitSections(summaries, SectionChars)
+		// Merge once everything fits, or when another round would not
+		// shrink the text any further.
+		if len(merged) == 1 || len(merged) >= len(sections) {
+			data.Text = ""
+			data.Summaries = summaries
+			return runPrompt(ctx, PromptDocMerge, data, onToken)
+		}
+		// Too many summaries for one merge: summarize them again.
+		sections = merged
+	}
+}
+
+func runPrompt(ctx context.Context, name string, data PromptData, onToken func(string)) (string, error) {
+	prompt, err := renderPrompt(name, data)
+	if err != nil {
+		return "", err
+	}
+	out, _, err := generate(ctx, LLMModel, prompt, onToken)
+	return out, err
+}
+
+// splitSections packs consecutive pieces into sections of at most max
+// characters. A piece longer than max becomes a section of its own.
+func splitSections(pieces []string, max int) []string {
+	var out []string
+	var cur strings.Builder
+	for _, p := range pieces {
+		if cur.Len() > 0 && cur.Len()+len(p)+2 > max {
+			out = append(out, cur.String())
+			cur.Reset()
+		}
+		if cur.Len() > 0 {
+			cur.WriteString("\n\n")
+		}
+		cur.WriteString(p)
+	}
+	if cur.Len() > 0 {
+		out = append(out, cur.String())
+	}
+	return out
+}
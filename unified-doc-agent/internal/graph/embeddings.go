@@ -0,0 +1,155 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+)
+
+// ErrMixedEmbeddings is matched by a *MixedEmbeddingsError.
+var ErrMixedEmbeddings = errors.New("chunks were embedded by different models")
+
+// EmbeddingUse is how many chunks a search may reach were embedded by a
+// model at a version; Version is "" for chunks from before versions were
+// recorded.
+type EmbeddingUse struct {
+	Model   string
+	Version string
+	Chunks  int
+}
+
+// MixedEmbeddingsError refuses a query some of whose chunks were embedded
+// by another version of the query's model, whose distances to the query
+// mean nothing, or by a model no longer configured, which the search
+// would leave out unnoticed.
+type MixedEmbeddingsError struct {
+	// Model and Version embed the query.
+	Model   string
+	Version string
+	// Others are the chunks embedded otherwise.
+	Others []EmbeddingUse
+}
+
+func (e *MixedEmbeddingsError) Error() string {
+	var others []string
+	for _, u := range e.Others {
+		others = append(others, fmt.Sprintf("%s (%d chunks)", modelVersion(u.Model, u.Version), u.Chunks))
+	}
+	return fmt.Sprintf("%s: the query is embedded by %s but chunks it would search were embedded by %s; "+
+		"re-embed them with `agent rechunk`, or pass allow_mixed_embeddings to search only the chunks of %s",
+		ErrMixedEmbeddings, modelVersion(e.Model, e.Version), strings.Join(others, ", "), e.Model)
+}
+
+func (e *MixedEmbeddingsError) Unwrap() error { return ErrMixedEmbeddings }
+
+func modelVersion(model, version string) string {
+	switch {
+	case model == "":
+		return "an unrecorded model"
+	case version == "":
+		return model
+	}
+	return model + "@" + version
+}
+
+// routedModels are the models that embed queries when a run has no model
+// of its own: the default model and those languages are routed to.
+func routedModels() map[string]bool {
+	routed := map[string]bool{processing.EmbedModel: true}
+	for _, m := range processing.EmbedModels {
+		if m != "" {
+			routed[m] = true
+		}
+	}
+	return routed
+}
+
+// queryModels returns the models to embed the query of s with, given the
+// uses of the chunks it may search: the run's own model or, without one,
+// every routed model that embedded some of them. The language of a query
+// is not enough to pick one, since a short query has none that can be
+// detected and a question may be asked in another language than its
+// answer is written in. With none of them indexed, it is the model routed
+// for the query's language.
+func queryModels(s *State, uses []EmbeddingUse) []string {
+	if s.EmbedModel != "" {
+		return []string{s.EmbedModel}
+	}
+	routed := routedModels()
+	var models []string
+	seen := map[string]bool{}
+	for _, u := range uses {
+		m := u.Model
+		if m == "" {
+			m = processing.EmbedModel
+		}
+		if routed[m] && !seen[m] {
+			seen[m] = true
+			models = append(models, m)
+		}
+	}
+	if len(models) == 0 {
+		return []string{processing.ModelFor(processing.DetectLanguage(s.Query))}
+	}
+	sort.Strings(models)
+	return models
+}
+
+// checkEmbeddings returns a *MixedEmbeddingsError if chunks of uses were
+// embedded by another version of one of the query's models, or by a model
+// that embeds no query any more: neither the run's own model nor, without
+// one, a model languages are routed to. Chunks with no model recorded
+// count as the default model's, and those with no version as the current
+// one's.
+func checkEmbeddings(ctx context.Context, s *State, models []string, uses []EmbeddingUse) error {
+	searched := map[string]bool{}
+	for _, m := range models {
+		searched[m] = true
+	}
+	routed := routedModels()
+	model := models[0]
+	versions := map[string]string{}
+	var others []EmbeddingUse
+	for _, u := range uses {
+		m := u.Model
+		if m == "" {
+			m = processing.EmbedModel
+		}
+		if !searched[m] {
+			if s.EmbedModel != "" || !routed[m] {
+				others = append(others, u)
+			}
+			continue
+		}
+		if u.Version == "" {
+			continue
+		}
+		version, ok := versions[m]
+		if !ok {
+			var err error
+			if version, err = processing.EmbedVersion(ctx, m); err != nil {
+				return err
+			}
+			versions[m] = version
+		}
+		if u.Version != version {
+			others = append(others, u)
+			model = m
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	version, ok := versions[model]
+	if !ok {
+		var err error
+		if version, err = processing.EmbedVersion(ctx, model); err != nil {
+			return err
+		}
+	}
+	return &MixedEmbeddingsError{Model: model, Version: version, Others: others}
+}
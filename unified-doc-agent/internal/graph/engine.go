@@ -0,0 +1,217 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Start is the pseudo-node every run begins from. Edges out of Start pick
+// the first real node(s) to execute.
+const Start = "__start__"
+
+// maxSteps bounds the number of supersteps so a cyclic graph cannot spin forever.
+const maxSteps = 50
+
+type NodeFunc func(context.Context, *State) error
+
+// ErrorPolicy decides what the engine does when a node fails.
+type ErrorPolicy int
+
+const (
+	// FailOnError aborts the run and returns the node's error.
+	FailOnError ErrorPolicy = iota
+	// ContinueOnError logs the error and follows the node's edges as if it succeeded.
+	ContinueOnError
+	// StopOnError ends the run quietly without following the node's edges.
+	StopOnError
+)
+
+type Node struct {
+	Name    string
+	Run     NodeFunc
+	Timeout time.Duration // zero means no per-node timeout
+	OnError ErrorPolicy
+}
+
+// Edge connects two nodes. A nil When always matches.
+type Edge struct {
+	To   string
+	When func(*State) bool
+}
+
+// Graph is a small DAG engine. Execution proceeds in supersteps: every node
+// in the current frontier runs in parallel, then the matching edges of all
+// of them form the next frontier (deduplicated, which is how fan-in works).
+// The run ends when the frontier is empty.
+type Graph struct {
+	nodes map[string]Node
+	edges map[string][]Edge
+}
+
+func NewGraph() *Graph {
+	return &Graph{
+		nodes: map[string]Node{},
+		edges: map[string][]Edge{},
+	}
+}
+
+func (g *Graph) AddNode(n Node) {
+	g.nodes[n.Name] = n
+}
+
+func (g *Graph) AddEdge(from, to string) {
+	g.AddConditionalEdge(from, to, nil)
+}
+
+func (g *Graph) AddConditionalEdge(from, to string, when func(*State) bool) {
+	g.edges[from] = append(g.edges[from], Edge{To: to, When: when})
+}
+
+// Validate checks that every edge points at a registered node.
+func (g *Graph) Validate() error {
+	if len(g.edges[Start]) == 0 {
+		return errors.New("graph has no edges from Start")
+	}
+	for from, edges := range g.edges {
+		if _, ok := g.nodes[from]; !ok && from != Start {
+			return fmt.Errorf("edge from unknown node %q", from)
+		}
+		for _, e := range edges {
+			if _, ok := g.nodes[e.To]; !ok {
+				return fmt.Errorf("edge %s -> %s: unknown node %q", from, e.To, e.To)
+			}
+		}
+	}
+	return nil
+}
+
+// Run executes the graph against s, recording a span per node and the
+// run's Trace in s.
+func (g *Graph) Run(ctx context.Context, s *State) (err error) {
+	if err := g.Validate(); err != nil {
+		return err
+	}
+
+	ctx, span := tracer.Start(ctx, "workflow", trace.WithAttributes(attribute.String("query", s.Query)))
+	start := time.Now()
+	s.traceUpdate(func(t *Trace) {}) // creates the trace
+	defer func() {
+		s.traceUpdate(func(t *Trace) {
+			t.TotalMS = time.Since(start).Milliseconds()
+			if err != nil {
+				t.Error = err.Error()
+			}
+		})
+		endSpan(span, err)
+	}()
+
+	frontier := g.next(Start, s)
+	for step := 0; len(frontier) > 0; step++ {
+		if step >= maxSteps {
+			return fmt.Errorf("workflow exceeded %d steps; check for cycles", maxSteps)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		advance, err := g.runStep(ctx, frontier, s)
+		if err != nil {
+			return err
+		}
+
+		var next []string
+		seen := map[string]bool{}
+		for _, name := range advance {
+			for _, to := range g.next(name, s) {
+				if !seen[to] {
+					seen[to] = true
+					next = append(next, to)
+				}
+			}
+		}
+		frontier = next
+	}
+	return nil
+}
+
+// runStep runs the frontier nodes concurrently and returns the names of the
+// nodes whose outgoing edges should be followed.
+func (g *Graph) runStep(ctx context.Context, frontier []string, s *State) ([]string, error) {
+	errs := make([]error, len(frontier))
+	var wg sync.WaitGroup
+	for i, name := range frontier {
+		wg.Add(1)
+		go func(i int, n Node) {
+			defer wg.Done()
+			errs[i] = runNode(ctx, n, s)
+		}(i, g.nodes[name])
+	}
+	wg.Wait()
+
+	var advance []string
+	for i, name := range frontier {
+		err := errs[i]
+		if err == nil {
+			advance = append(advance, name)
+			continue
+		}
+		switch g.nodes[name].OnError {
+		case ContinueOnError:
+			log.Printf("node %s failed, continuing: %v", name, err)
+			advance = append(advance, name)
+		case StopOnError:
+			log.Printf("node %s failed, stopping branch: %v", name, err)
+		default:
+			return nil, fmt.Errorf("node %s: %w", name, err)
+		}
+	}
+	return advance, nil
+}
+
+func runNode(ctx context.Context, n Node, s *State) error {
+	if n.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.Timeout)
+		defer cancel()
+	}
+	ctx, span := tracer.Start(ctx, "node "+n.Name, trace.WithAttributes(attribute.String("node", n.Name)))
+	start := time.Now()
+	err := n.Run(ctx, s)
+	d := time.Since(start)
+	s.recordTiming(n.Name, d)
+	s.traceUpdate(func(t *Trace) {
+		nt := NodeTrace{Name: n.Name, DurationMS: d.Milliseconds()}
+		if err != nil {
+			nt.Error = err.Error()
+		}
+		t.Nodes = append(t.Nodes, nt)
+	})
+	endSpan(span, err)
+	return err
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (g *Graph) next(from string, s *State) []string {
+	var out []string
+	for _, e := range g.edges[from] {
+		if e.When == nil || e.When(s) {
+			out = append(out, e.To)
+		}
+	}
+	return out
+}
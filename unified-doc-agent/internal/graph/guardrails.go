@@ -0,0 +1,163 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Guardrails selects which filters the guardrails node applies.
+type Guardrails struct {
+	// InjectionFilter drops retrieved chunks that contain prompt-injection
+	// phrases, so documents cannot steer the summarizer.
+	InjectionFilter bool
+	// RedactPII replaces matches of the PII patterns with a placeholder.
+	RedactPII bool
+}
+
+// DefaultGuardrails apply when a run does not set State.Guardrails.
+var DefaultGuardrails = Guardrails{InjectionFilter: true, RedactPII: true}
+
+// Filtered reports one thing the guardrails node removed from a chunk.
+type Filtered struct {
+	ChunkID  int    `json:"chunk_id"`
+	Filename string `json:"filename"`
+	// Kind is "injection" for dropped chunks or "pii:<pattern>" for redactions.
+	Kind  string `json:"kind"`
+	Count int    `json:"count"`
+}
+
+var injectionPatterns = compileAll(
+	`ignore (all |any )?(the )?(previous|prior|above|earlier) (instructions|prompts?|messages)`,
+	`disregard (all |any )?(the )?(previous|prior|above|earlier) (instructions|prompts?|messages)`,
+	`forget (all |everything |what )?(you('ve| have) been told|previous instructions)`,
+	`you are now (a|an|in) `,
+	`(reveal|print|show) (me )?(your|the) (system )?prompt`,
+	`new instructions:`,
+	`</?(system|assistant)>`,
+)
+
+func compileAll(exprs ...string) []*regexp.Regexp {
+	out := make([]*regexp.Regexp, len(exprs))
+	for i, e := range exprs {
+		out[i] = regexp.MustCompile(`(?i)` + e)
+	}
+	return out
+}
+
+// piiPatterns maps a pattern name to what it matches. "card" matches are
+// additionally checked with the Luhn algorithm to avoid redacting ordinary
+// long numbers.
+var piiPatterns = map[string]*regexp.Regexp{
+	"email": regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	"card":  regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+}
+
+// SetPIIPatterns adds or replaces named PII patterns. An empty expression
+// removes the pattern of that name.
+func SetPIIPatterns(patterns map[string]string) error {
+	for name, expr := range patterns {
+		if expr == "" {
+			delete(piiPatterns, name)
+			continue
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("pii pattern %s: %w", name, err)
+		}
+		piiPatterns[name] = re
+	}
+	return nil
+}
+
+// GuardrailsNode drops chunks with prompt-injection phrases and redacts PII
+// from the rest before they reach the summarizer prompt. What it removed is
+// recorded in State.Filtered.
+func GuardrailsNode(ctx context.Context, s *State) error {
+	g := DefaultGuardrails
+	if s.Guardrails != nil {
+		g = *s.Guardrails
+	}
+	if !g.InjectionFilter && !g.RedactPII {
+		return nil
+	}
+
+	names := make([]string, 0, len(piiPatterns))
+	for name := range piiPatterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var kept []Chunk
+	var report []Filtered
+	for _, d := range s.Docs {
+		if g.InjectionFilter {
+			if n := countMatches(injectionPatterns, d.Content); n > 0 {
+				report = append(report, Filtered{ChunkID: d.ID, Filename: d.Filename, Kind: "injection", Count: n})
+				continue
+			}
+		}
+		if g.RedactPII {
+			for _, name := range names {
+				var n int
+				d.Content, n = redact(d.Content, name, piiPatterns[name])
+				if n > 0 {
+					report = append(report, Filtered{ChunkID: d.ID, Filename: d.Filename, Kind: "pii:" + name, Count: n})
+				}
+			}
+		}
+		kept = append(kept, d)
+	}
+
+	s.Update(func(s *State) {
+		s.Docs = kept
+		s.Filtered = append(s.Filtered, report...)
+	})
+	return nil
+}
+
+func countMatches(res []*regexp.Regexp, text string) int {
+	n := 0
+	for _, re := range res {
+		n += len(re.FindAllStringIndex(text, -1))
+	}
+	return n
+}
+
+func redact(text, name string, re *regexp.Regexp) (string, int) {
+	n := 0
+	out := re.ReplaceAllStringFunc(text, func(m string) string {
+		if name == "card" && !luhn(m) {
+			return m
+		}
+		n++
+		return "[REDACTED:" + name + "]"
+	})
+	return out, n
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum.
+func luhn(s string) bool {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return len(digits) >= 13 && sum%10 == 0
+}
@@ -0,0 +1,109 @@
+package graph
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+)
+
+// Knowledge-graph traversal limits, overridden from the agent config.
+var (
+	// GraphHops is how many relations away from the seeds a graph query
+	// follows.
+	GraphHops = 2
+	// GraphMaxFacts caps the facts collected for one graph query.
+	GraphMaxFacts = 50
+)
+
+// Fact is a knowledge-graph triple and the chunk stating it.
+type Fact struct {
+	Subject  string `json:"subject"`
+	Relation string `json:"relation"`
+	Object   string `json:"object"`
+	ChunkID  int    `json:"chunk_id"`
+	Filename string `json:"filename"`
+}
+
+func (f Fact) String() string {
+	return f.Subject + " —" + f.Relation + "→ " + f.Object
+}
+
+// fileMention matches file names such as invoice.pdf in a query.
+var fileMention = regexp.MustCompile(`[\w.-]+\.\w{2,4}\b`)
+
+// GraphNode answers multi-hop questions by walking the knowledge graph.
+// It seeds the walk with the entities named in the query, the files it
+// mentions and the facts of the chunks retrieval found, then follows
+// relations for GraphHops steps. The facts found go into the prompt, and
+// the chunks stating them are added to the retrieved ones.
+func GraphNode(ctx context.Context, s *State) error {
+	filter := Filter{AccessTags: s.AccessTags, Entities: s.Entities, Roots: s.Roots}
+	seeds := processing.RuleMetadata(s.Query).Entities
+	files := fileMention.FindAllString(s.Query, -1)
+	ids := make([]int, len(s.Docs))
+	for i, d := range s.Docs {
+		ids[i] = d.ID
+	}
+
+	var facts []Fact
+	seen := map[Fact]bool{}
+	visited := map[string]bool{}
+	for hop := 0; hop <= GraphHops && len(facts) < GraphMaxFacts; hop++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		found, err := s.DB.Graph(seeds, ids, files, filter, GraphMaxFacts-len(facts))
+		if err != nil {
+			return err
+		}
+		for _, n := range seeds {
+			visited[n] = true
+		}
+		// Chunks and files only seed the first hop.
+		ids, files = nil, nil
+		var next []string
+		for _, f := range found {
+			if seen[f] {
+				continue
+			}
+			seen[f] = true
+			facts = append(facts, f)
+			for _, n := range []string{f.Subject, f.Object} {
+				if !visited[n] {
+					visited[n] = true
+					next = append(next, n)
+				}
+			}
+		}
+		if len(next) == 0 {
+			break
+		}
+		seeds = next
+	}
+
+	have := map[int]bool{}
+	for _, d := range s.Docs {
+		have[d.ID] = true
+	}
+	var extra []int
+	for _, f := range facts {
+		if !have[f.ChunkID] {
+			have[f.ChunkID] = true
+			extra = append(extra, f.ChunkID)
+		}
+	}
+	var added []Chunk
+	if len(extra) > 0 {
+		var err error
+		if added, err = s.DB.Chunks(extra, filter); err != nil {
+			return err
+		}
+	}
+	s.Update(func(s *State) {
+		s.Facts = facts
+		s.Docs = append(s.Docs, added...)
+	})
+	s.traceUpdate(func(t *Trace) { t.GraphFacts = len(facts) })
+	return nil
+}
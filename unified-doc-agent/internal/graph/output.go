@@ -0,0 +1,150 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Result is the structured outcome of a query run.
+type Result struct {
+	Query     string           `json:"query"`
+	QueryType QueryType        `json:"query_type,omitempty"`
+	Answer    string           `json:"answer"`
+	Citations []Citation       `json:"citations"`
+	ChunkIDs  []int            `json:"chunk_ids"`
+	TimingsMS map[string]int64 `json:"timings_ms"`
+	Filtered  []Filtered       `json:"filtered,omitempty"`
+	// Facts are the knowledge-graph facts a graph query used.
+	Facts []Fact `json:"facts,omitempty"`
+	// Confidence scores the answer; it is missing for clarifications.
+	Confidence *Confidence `json:"confidence,omitempty"`
+	// Quotes are the checks of the passages the answer quotes.
+	Quotes []QuoteCheck `json:"quotes,omitempty"`
+	// Trace is only set for debug runs.
+	Trace *Trace `json:"trace,omitempty"`
+}
+
+// Citation groups the retrieved chunks that came from one file.
+type Citation struct {
+	Filename string `json:"filename"`
+	Source   string `json:"source"`
+	ChunkIDs []int  `json:"chunk_ids"`
+	Pages    []int  `json:"pages,omitempty"`
+}
+
+// Result builds the structured result from the current state.
+func (s *State) Result() *Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := &Result{
+		Query:     s.Query,
+		QueryType: s.QueryType,
+		Answer:    s.Ans,
+		Citations: []Citation{},
+		ChunkIDs:  []int{},
+		TimingsMS: map[string]int64{},
+	}
+	byFile := map[string]int{}
+	for _, d := range s.Docs {
+		r.ChunkIDs = append(r.ChunkIDs, d.ID)
+		i, ok := byFile[d.Filename]
+		if !ok {
+			i = len(r.Citations)
+			byFile[d.Filename] = i
+			r.Citations = append(r.Citations, Citation{Filename: d.Filename, Source: d.Source})
+		}
+		r.Citations[i].ChunkIDs = append(r.Citations[i].ChunkIDs, d.ID)
+		if d.Page > 0 && !containsInt(r.Citations[i].Pages, d.Page) {
+			r.Citations[i].Pages = append(r.Citations[i].Pages, d.Page)
+		}
+	}
+	r.Filtered = s.Filtered
+	r.Facts = s.Facts
+	r.Confidence = s.Confidence
+	r.Quotes = s.Quotes
+	if s.Debug {
+		r.Trace = s.Trace
+	}
+	for name, d := range s.Timings {
+		r.TimingsMS[name] = d.Milliseconds()
+	}
+	return r
+}
+
+func containsInt(xs []int, x int) bool {
+	for _, v := range xs {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
+
+// Output renders a query result.
+type Output interface {
+	Write(r *Result) error
+}
+
+// NewOutput returns an Output for the given format ("text" or "json").
+func NewOutput(format string, w io.Writer) (Output, error) {
+	switch format {
+	case "", "text":
+		return &textOutput{w: w}, nil
+	case "json":
+		return &jsonOutput{w: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q (expected text or json)", format)
+	}
+}
+
+type textOutput struct {
+	w io.Writer
+}
+
+func (o *textOutput) Write(r *Result) error {
+	fmt.Fprint(o.w, "\n===== ANSWER =====\n\n")
+	fmt.Fprintln(o.w, r.Answer)
+	if c := r.Confidence; c != nil && !c.Refused {
+		fmt.Fprintf(o.w, "\nConfidence: %.2f (retrieval %.2f, coverage %.2f, critic %s)\n", c.Score, c.Retrieval, c.Coverage, c.Verdict)
+	}
+	for _, q := range r.Quotes {
+		if !q.Verified {
+			fmt.Fprintf(o.w, "Unverified quote: %q\n", q.Quote)
+		}
+	}
+	if len(r.Citations) > 0 {
+		fmt.Fprintln(o.w, "\nSources:")
+		for _, c := range r.Citations {
+			if len(c.Pages) > 0 {
+				fmt.Fprintf(o.w, "  - %s (pages %v, chunks %v)\n", c.Filename, c.Pages, c.ChunkIDs)
+				continue
+			}
+			fmt.Fprintf(o.w, "  - %s (chunks %v)\n", c.Filename, c.ChunkIDs)
+		}
+	}
+	if len(r.Facts) > 0 {
+		fmt.Fprintln(o.w, "\nFacts:")
+		for _, f := range r.Facts {
+			fmt.Fprintf(o.w, "  - %s (%s)\n", f, f.Filename)
+		}
+	}
+	if len(r.Filtered) > 0 {
+		fmt.Fprintln(o.w, "\nFiltered:")
+		for _, f := range r.Filtered {
+			fmt.Fprintf(o.w, "  - %s chunk %d: %s x%d\n", f.Filename, f.ChunkID, f.Kind, f.Count)
+		}
+	}
+	return nil
+}
+
+type jsonOutput struct {
+	w io.Writer
+}
+
+func (o *jsonOutput) Write(r *Result) error {
+	enc := json.NewEncoder(o.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
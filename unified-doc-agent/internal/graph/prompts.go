@@ -0,0 +1,164 @@
+package graph
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// PromptData is what prompt templates can refer to.
+type PromptData struct {
+	Query     string
+	Docs      []Chunk
+	Citations []Citation
+	// Facts are the knowledge-graph facts found for graph queries.
+	Facts []Fact
+	// Answer is the current answer; empty before the summarizer has run.
+	Answer string
+
+	// Filename, Length, Text and Summaries are used by the single-document
+	// summary prompts. Length is "short" or "detailed".
+	Filename  string
+	Length    string
+	Text      string
+	Summaries []string
+}
+
+// Prompt template names. A file <name>.tmpl in the prompts directory
+// replaces the built-in template of the same name.
+const (
+	PromptSummarizer = "summarizer"
+	PromptFactoid    = "factoid"
+	PromptList       = "list"
+	PromptCompare    = "compare"
+	PromptGraph      = "graph"
+	PromptCritic     = "critic"
+	PromptDocSection = "doc_section"
+	PromptDocMerge   = "doc_merge"
+)
+
+var defaultPrompts = map[string]string{
+	PromptSummarizer: `The user asked: {{printf "%q" .Query}}.
+
+Summarize the following documents in the context of this query:
+
+{{range $i, $d := .Docs}}Document {{inc $i}}:
+{{$d}}
+
+{{end}}`,
+	PromptFactoid: `Answer the question using only the documents below. Reply with the
+answer itself in one or two sentences and name the file it came from. If
+the documents do not contain the answer, say so.
+
+Question: {{.Query}}
+
+{{range $i, $d := .Docs}}Document {{inc $i}}:
+{{$d}}
+
+{{end}}`,
+	PromptList: `The user asked: {{printf "%q" .Query}}.
+
+Go through every document below and list each item that answers the
+request as a bullet point, with the file it came from. Include every
+occurrence; do not summarize or merge items from different documents.
+
+{{range $i, $d := .Docs}}Document {{inc $i}}:
+{{$d}}
+
+{{end}}`,
+	PromptCompare: `The user asked: {{printf "%q" .Query}}.
+
+Compare the things the user mentions using the documents below. Describe
+what they have in common, then how they differ, citing the file for each
+point. Say which side the documents do not cover, if any.
+
+{{range $i, $d := .Docs}}Document {{inc $i}}:
+{{$d}}
+
+{{end}}`,
+	PromptGraph: `Answer the question by connecting the facts and documents below. The
+facts were extracted from the documents; follow them from one to the next
+to link what the question asks about, and name the file behind each step.
+If the chain breaks, say where.
+
+Question: {{.Query}}
+
+Facts:
+{{range .Facts}}- {{.}} ({{.Filename}})
+{{else}}(none found)
+{{end}}
+{{range $i, $d := .Docs}}Document {{inc $i}}:
+{{$d}}
+
+{{end}}`,
+	// doc_section and doc_merge drive `agent summarize`: each section of a
+	// long file is summarized, then the section summaries are merged.
+	PromptDocSection: `Summarize the following part of {{.Filename}}.
+Keep names, numbers, dates and decisions; leave out boilerplate.
+{{if eq .Length "detailed"}}Use as many bullet points as the content needs.{{else}}Use at most five bullet points.{{end}}
+
+{{.Text}}`,
+	PromptDocMerge: `Below are summaries of consecutive parts of {{.Filename}}.
+Combine them into one {{if eq .Length "detailed"}}detailed summary with a short
+overview followed by a section per main topic{{else}}short summary of one or
+two paragraphs{{end}}. Do not repeat points and do not add anything that is
+not in the summaries.
+
+{{range $i, $s := .Summaries}}Part {{inc $i}}:
+{{$s}}
+
+{{end}}`,
+	// The critic rewrites answers that look too short to be useful.
+	PromptCritic: `{{.Answer}}
+
+(Note: result short; consider rephrasing your query or indexing more documents.)`,
+}
+
+var promptFuncs = template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}
+
+var prompts = mustParsePrompts()
+
+func mustParsePrompts() map[string]*template.Template {
+	out := make(map[string]*template.Template, len(defaultPrompts))
+	for name, text := range defaultPrompts {
+		out[name] = template.Must(template.New(name).Funcs(promptFuncs).Parse(text))
+	}
+	return out
+}
+
+// LoadPrompts replaces built-in templates with any <name>.tmpl files found
+// in dir. A missing directory is not an error; a template that does not
+// parse is.
+func LoadPrompts(dir string) error {
+	loaded := mustParsePrompts()
+	for name := range defaultPrompts {
+		path := filepath.Join(dir, name+".tmpl")
+		b, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("prompts: reading %s: %w", path, err)
+		}
+		t, err := template.New(name).Funcs(promptFuncs).Parse(string(b))
+		if err != nil {
+			return fmt.Errorf("prompts: parsing %s: %w", path, err)
+		}
+		loaded[name] = t
+	}
+	prompts = loaded
+	return nil
+}
+
+func renderPrompt(name string, data PromptData) (string, error) {
+	var buf bytes.Buffer
+	if err := prompts[name].Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("prompt %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,111 @@
+package graph
+
+import (
+	"strings"
+	"unicode"
+)
+
+// minQuoteWords is the fewest words a quoted span needs to be checked;
+// shorter ones are usually terms rather than quotations.
+const minQuoteWords = 3
+
+// QuoteCheck reports whether a passage the answer quotes occurs verbatim
+// in a cited document.
+type QuoteCheck struct {
+	Quote string `json:"quote"`
+	// Filename is the document the quote was found in.
+	Filename string `json:"filename,omitempty"`
+	Verified bool   `json:"verified"`
+}
+
+// quotePairs are the quotation marks an answer may use.
+var quotePairs = map[rune]rune{'"': '"', '“': '”', '«': '»', '„': '“'}
+
+// extractQuotes returns the quoted spans of ans of at least minQuoteWords
+// words, in order.
+func extractQuotes(ans string) []string {
+	var out []string
+	runes := []rune(ans)
+	for i := 0; i < len(runes); i++ {
+		closing, ok := quotePairs[runes[i]]
+		if !ok {
+			continue
+		}
+		j := i + 1
+		for j < len(runes) && runes[j] != closing && runes[j] != '\n' {
+			j++
+		}
+		if j == len(runes) || runes[j] != closing {
+			continue
+		}
+		q := strings.TrimSpace(string(runes[i+1 : j]))
+		if len(strings.Fields(q)) >= minQuoteWords {
+			out = append(out, q)
+		}
+		i = j
+	}
+	return out
+}
+
+// normalizeQuote folds case, white space, typographic quotes and trailing
+// punctuation so that a quote matches its source despite reflowed text.
+func normalizeQuote(s string) string {
+	s = strings.NewReplacer("’", "'", "‘", "'", "“", `"`, "”", `"`, "­", "").Replace(s)
+	s = strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	return strings.TrimRightFunc(s, unicode.IsPunct)
+}
+
+// checkQuotes checks every quote in the state's answer against the
+// retrieved chunks and then, for quotes spanning chunk boundaries, against
+// the full stored text of the cited files.
+func checkQuotes(s *State) []QuoteCheck {
+	quotes := extractQuotes(s.Ans)
+	if len(quotes) == 0 {
+		return nil
+	}
+	var files []string
+	texts := map[string]string{}
+	for _, d := range s.Docs {
+		if _, ok := texts[d.Filename]; !ok {
+			files = append(files, d.Filename)
+		}
+		texts[d.Filename] += normalizeQuote(d.Content) + "\n"
+	}
+	raw := map[string]string{}
+	rawText := func(file string) string {
+		if t, ok := raw[file]; ok {
+			return t
+		}
+		t := ""
+		if s.DB != nil {
+			// A file without stored text just leaves the quote unverified.
+			if text, err := s.DB.RawText(file); err == nil {
+				t = normalizeQuote(text)
+			}
+		}
+		raw[file] = t
+		return t
+	}
+
+	out := make([]QuoteCheck, len(quotes))
+	for i, q := range quotes {
+		out[i].Quote = q
+		nq := normalizeQuote(q)
+		if f := findQuote(files, nq, func(f string) string { return texts[f] }); f != "" {
+			out[i].Filename, out[i].Verified = f, true
+		} else if f := findQuote(files, nq, rawText); f != "" {
+			out[i].Filename, out[i].Verified = f, true
+		}
+	}
+	return out
+}
+
+// findQuote returns the first of files whose text contains q, or "".
+func findQuote(files []string, q string, text func(string) string) string {
+	for _, f := range files {
+		if strings.Contains(text(f), q) {
+			return f
+		}
+	}
+	return ""
+}
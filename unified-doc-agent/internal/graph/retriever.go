@@ -2,19 +2,317 @@ package graph
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
 )
 
-func RetrieverNode(ctx context.Context, s *State) error {
-	qemb, err := processing.QueryEmbedding(ctx, s.Query)
+// Retrieval defaults, overridden from the agent config at startup. A run
+// can override each of them through State.
+var (
+	// TopK is the number of chunks retrieved per query.
+	TopK = 5
+	// MinScore drops retrieved chunks scoring below it; zero keeps all.
+	MinScore = 0.0
+	// MaxContextChars caps the document text put into the summarizer
+	// prompt; zero means no cap.
+	MaxContextChars = 0
+	// FeedbackWeight is the score added per net helpful vote on a chunk,
+	// or taken away per unhelpful one; zero ignores feedback.
+	FeedbackWeight = 0.05
+	// MetadataWeight is the score added for each of a chunk's entities or
+	// keywords that the query mentions; zero disables the boost.
+	MetadataWeight = 0.02
+	// CollectionWeights multiplies the similarity of chunks from each named
+	// collection, e.g. {"policies": 1.5, "archive": 0.5}; collections not
+	// listed weigh 1.
+	CollectionWeights map[string]float64
+	// RecencyHalfLife is the age at which the recency-weighted share of a
+	// chunk's similarity is halved, counted from when its file was last
+	// modified; zero disables recency weighting.
+	RecencyHalfLife time.Duration
+	// RecencyWeight is the share of the similarity, 0-1, that decays with
+	// age; the rest is kept however old the file.
+	RecencyWeight = 0.5
+)
+
+// collectionWeight is the weight of collection c for s, preferring the
+// run's own weights over CollectionWeights.
+func collectionWeight(s *State, c string) float64 {
+	if w, ok := s.CollectionWeights[c]; ok {
+		return w
+	}
+	if w, ok := CollectionWeights[c]; ok {
+		return w
+	}
+	return 1
+}
+
+// recencyFactor is what the similarity of a chunk of a file last modified
+// at modified is multiplied by for its age at now: 1 for a new file, down
+// to 1 - RecencyWeight for a very old one. Chunks of unknown age, and
+// every chunk when recency weighting is off, keep their similarity.
+func recencyFactor(s *State, modified, now time.Time) float64 {
+	halfLife := RecencyHalfLife
+	if s.RecencyHalfLife > 0 {
+		halfLife = s.RecencyHalfLife
+	}
+	if halfLife <= 0 || modified.IsZero() {
+		return 1
+	}
+	age := now.Sub(modified)
+	if age < 0 {
+		age = 0
+	}
+	return 1 - RecencyWeight + RecencyWeight*math.Pow(0.5, float64(age)/float64(halfLife))
+}
+
+// ParseDateRange parses the since and until bounds of a query, each empty
+// or a date (2006-01-02, in UTC) or an RFC 3339 time. A date until
+// includes that whole day.
+func ParseDateRange(since, until string) (time.Time, time.Time, error) {
+	from, err := parseDate(since)
 	if err != nil {
-		return err
+		return time.Time{}, time.Time{}, fmt.Errorf("since: %w", err)
 	}
-	docs, err := s.DB.Search(qemb, 5)
+	to, err := parseDate(until)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("until: %w", err)
+	}
+	if len(until) == len(time.DateOnly) {
+		to = to.AddDate(0, 0, 1)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("since must be before until")
+	}
+	return from, to, nil
+}
+
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a date like 2006-01-02 nor an RFC 3339 time", value)
+	}
+	return t, nil
+}
+
+// searchCollections runs the search once per collection in filter, so a
+// large collection cannot crowd the others out of the top k, and merges
+// the results. Without collections it is a single search.
+func searchCollections(s *State, qemb []float32, model string, topK int, filter Filter) ([]Chunk, error) {
+	if len(filter.Collections) <= 1 {
+		return s.DB.Search(qemb, model, topK, filter)
+	}
+	var found []Chunk
+	seen := map[int]bool{}
+	for _, c := range filter.Collections {
+		one := filter
+		one.Collections = []string{c}
+		docs, err := s.DB.Search(qemb, model, topK, one)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range docs {
+			if !seen[d.ID] {
+				seen[d.ID] = true
+				found = append(found, d)
+			}
+		}
+	}
+	return found, nil
+}
+
+// maxFeedbackVotes caps how many net votes count, so feedback reorders
+// close results without overriding similarity altogether.
+const maxFeedbackVotes = 3
+
+// feedbackBoost is the score adjustment for a chunk's net votes.
+func feedbackBoost(votes int) float64 {
+	if votes > maxFeedbackVotes {
+		votes = maxFeedbackVotes
+	} else if votes < -maxFeedbackVotes {
+		votes = -maxFeedbackVotes
+	}
+	return FeedbackWeight * float64(votes)
+}
+
+// maxMetadataMatches caps how many entity and keyword matches count.
+const maxMetadataMatches = 3
+
+// metadataMatches counts the entities and keywords of c that appear as
+// whole words in the normalized query q.
+func metadataMatches(q string, c Chunk) int {
+	n := 0
+	padded := " " + q + " "
+	for _, terms := range [][]string{c.Entities, c.Keywords} {
+		for _, t := range terms {
+			if t != "" && strings.Contains(padded, " "+t+" ") {
+				n++
+			}
+		}
+	}
+	if n > maxMetadataMatches {
+		n = maxMetadataMatches
+	}
+	return n
+}
+
+// normalizeQueryTerms lower-cases q and reduces it to words separated by
+// single spaces, the form metadata is matched in.
+func normalizeQueryTerms(q string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '&' && r != '\''
+	}), " ")
+}
+
+// Score turns an L2 distance into a similarity in (0, 1]; higher is closer.
+func Score(distance float64) float64 {
+	return 1 / (1 + distance)
+}
+
+// modelSimilarity returns the similarity to the query of each chunk of
+// byModel, the chunks found with each embedding model: its Score. The
+// distances of different models are not comparable, so with several models
+// the similarities of each are rescaled min-max onto the range of them all,
+// the best chunk of every model scoring as the best overall.
+func modelSimilarity(byModel [][]Chunk) map[int]float64 {
+	similarity := map[int]float64{}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, docs := range byModel {
+		for _, d := range docs {
+			sc := Score(d.Distance)
+			similarity[d.ID] = sc
+			lo, hi = math.Min(lo, sc), math.Max(hi, sc)
+		}
+	}
+	if len(byModel) < 2 {
+		return similarity
+	}
+	for _, docs := range byModel {
+		mlo, mhi := math.Inf(1), math.Inf(-1)
+		for _, d := range docs {
+			mlo, mhi = math.Min(mlo, similarity[d.ID]), math.Max(mhi, similarity[d.ID])
+		}
+		for _, d := range docs {
+			if mhi == mlo {
+				similarity[d.ID] = hi
+				continue
+			}
+			similarity[d.ID] = lo + (similarity[d.ID]-mlo)/(mhi-mlo)*(hi-lo)
+		}
+	}
+	return similarity
+}
+
+// RetrieverNode embeds the query with the run's embedding model, or else
+// with each routed model that embedded chunks the run may search, and
+// searches the chunks embedded by that same model, in each of the run's
+// collections when there are several, among the files modified within the
+// run's date range. Chunks pinned to the query are put first; the rest are
+// merged, their similarities normalized per model, and reranked by
+// collection weight, the age of their file,
+// relevance feedback and how many of their entities and keywords the query
+// mentions. Unless the run allows it, a query whose chunks were embedded
+// by other models or versions is refused with a *MixedEmbeddingsError.
+func RetrieverNode(ctx context.Context, s *State) error {
+	topK := retrievalTopK(s)
+	minScore := MinScore
+	if s.MinScore > 0 {
+		minScore = s.MinScore
+	}
+	filter := Filter{AccessTags: s.AccessTags, Entities: s.Entities, Collections: s.Collections, Since: s.Since, Until: s.Until, Roots: s.Roots}
+	var uses []EmbeddingUse
+	if s.EmbedModel == "" || !s.AllowMixedEmbeddings {
+		var err error
+		if uses, err = s.DB.Embeddings(filter); err != nil {
+			return err
+		}
+	}
+	models := queryModels(s, uses)
+	if !s.AllowMixedEmbeddings {
+		if err := checkEmbeddings(ctx, s, models, uses); err != nil {
+			return err
+		}
+	}
+	var found []Chunk
+	var byModel [][]Chunk
+	for _, model := range models {
+		qemb, err := processing.QueryEmbeddingWithModel(ctx, model, s.Query)
+		if err != nil {
+			return err
+		}
+		docs, err := searchCollections(s, qemb, model, topK, filter)
+		if err != nil {
+			return err
+		}
+		// The chunks of each model are their own
+		found = append(found, docs...)
+		byModel = append(byModel, docs)
+	}
+	similarity := modelSimilarity(byModel)
+	pinned, err := s.DB.Pinned(s.Query, filter)
 	if err != nil {
 		return err
 	}
-	s.Docs = docs
+	votes := map[int]int{}
+	if FeedbackWeight != 0 && len(found) > 0 {
+		ids := make([]int, len(found))
+		for i, d := range found {
+			ids[i] = d.ID
+		}
+		if votes, err = s.DB.Votes(ids); err != nil {
+			return err
+		}
+	}
+
+	// Pinned chunks come first and are kept whatever their score.
+	docs := make([]Chunk, 0, len(pinned)+len(found))
+	isPinned := map[int]bool{}
+	for _, d := range pinned {
+		d.Pinned = true
+		d.Score = 1
+		isPinned[d.ID] = true
+		docs = append(docs, d)
+	}
+	terms := normalizeQueryTerms(s.Query)
+	matches := map[int]int{}
+	recency := map[int]float64{}
+	now := time.Now()
+	var ranked []Chunk
+	for _, d := range found {
+		matches[d.ID] = metadataMatches(terms, d)
+		recency[d.ID] = recencyFactor(s, d.Modified, now)
+		d.Score = similarity[d.ID]*collectionWeight(s, d.Collection)*recency[d.ID] + feedbackBoost(votes[d.ID]) + MetadataWeight*float64(matches[d.ID])
+		if !isPinned[d.ID] && d.Score >= minScore {
+			ranked = append(ranked, d)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	if len(ranked) > topK {
+		// Searching collections or models separately can find more
+		// than topK.
+		ranked = ranked[:topK]
+	}
+	docs = append(docs, ranked...)
+	s.Update(func(s *State) { s.Docs = docs })
+	s.traceUpdate(func(t *Trace) {
+		for _, d := range found {
+			t.Retrieved = append(t.Retrieved, RetrievedInfo{ChunkID: d.ID, Filename: d.Filename, Collection: d.Collection, Distance: d.Distance, Score: similarity[d.ID], Votes: votes[d.ID], Matches: matches[d.ID], Recency: recency[d.ID]})
+		}
+		for _, d := range pinned {
+			t.Retrieved = append(t.Retrieved, RetrievedInfo{ChunkID: d.ID, Filename: d.Filename, Pinned: true})
+		}
+	})
 	return nil
 }
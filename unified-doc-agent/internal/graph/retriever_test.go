@@ -0,0 +1,119 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ollama"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// fakeOllama serves the embeddings and model list of Ollama for the
+// duration of a test, recording the models queries were embedded with.
+func fakeOllama(t *testing.T) *[]string {
+	var mu sync.Mutex
+	var embedded []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/embeddings":
+			var req struct {
+				Model string `json:"model"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			embedded = append(embedded, req.Model)
+			mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]interface{}{"embedding": make([]float32, processing.EmbeddingDim)})
+		case "/api/tags":
+			json.NewEncoder(w).Encode(map[string]interface{}{"models": []map[string]string{
+				{"name": processing.EmbedModel + ":latest", "digest": "default-digest"},
+				{"name": "german-embed:latest", "digest": "german-digest"},
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	baseURL, routes := ollama.BaseURL, processing.EmbedModels
+	ollama.BaseURL = srv.URL
+	processing.EmbedModels = map[string]string{"de": "german-embed"}
+	t.Cleanup(func() { ollama.BaseURL, processing.EmbedModels = baseURL, routes })
+	return &embedded
+}
+
+func TestRetrieverShortQueryOtherLanguage(t *testing.T) {
+	embedded := fakeOllama(t)
+	// Too short for its language to be detected, so it would have been
+	// embedded by the default model, which embedded none of the chunks
+	query := "Urlaubsantrag Frist?"
+	if lang := processing.DetectLanguage(query); lang != processing.UnknownLanguage {
+		t.Fatalf("DetectLanguage(%q) = %s, want it undetected", query, lang)
+	}
+	db := &mockStorage{
+		uses: []storage.EmbeddingUse{{Model: "german-embed", Chunks: 1}},
+		byModel: map[string][]storage.Document{
+			"german-embed": {{ID: 3, Filename: "urlaub.txt", Content: "Urlaub ist zwei Wochen vorher zu beantragen.", Distance: 0.2}},
+		},
+	}
+	s := &State{Query: query, DB: NewStore(db)}
+	if err := RetrieverNode(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Docs) != 1 || s.Docs[0].ID != 3 {
+		t.Errorf("Docs = %+v, want the German chunk", s.Docs)
+	}
+	if !reflect.DeepEqual(*embedded, []string{"german-embed"}) {
+		t.Errorf("query embedded with %q, want the model of the indexed chunks", *embedded)
+	}
+}
+
+func TestRetrieverSearchesEveryIndexedModel(t *testing.T) {
+	embedded := fakeOllama(t)
+	db := &mockStorage{
+		uses: []storage.EmbeddingUse{{Model: "", Chunks: 1}, {Model: "german-embed", Chunks: 1}},
+		byModel: map[string][]storage.Document{
+			processing.EmbedModel: {{ID: 1, Filename: "leave.txt", Distance: 0.5}},
+			"german-embed":        {{ID: 2, Filename: "urlaub.txt", Distance: 0.1}},
+		},
+	}
+	s := &State{Query: "How much notice does a leave request need?", DB: NewStore(db)}
+	if err := RetrieverNode(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Docs) != 2 || s.Docs[0].ID+s.Docs[1].ID != 3 {
+		t.Errorf("Docs = %+v, want the chunks of both models", s.Docs)
+	}
+	got := append([]string(nil), *embedded...)
+	sort.Strings(got)
+	if want := []string{"german-embed", processing.EmbedModel}; !reflect.DeepEqual(got, want) {
+		t.Errorf("query embedded with %q, want %q", got, want)
+	}
+}
+
+func TestRetrieverNormalizesDistancesPerModel(t *testing.T) {
+	fakeOllama(t)
+	// german-embed puts everything close to the query, so its worst chunk
+	// is nearer than the default model's best
+	db := &mockStorage{
+		uses: []storage.EmbeddingUse{{Model: "", Chunks: 2}, {Model: "german-embed", Chunks: 2}},
+		byModel: map[string][]storage.Document{
+			processing.EmbedModel: {{ID: 1, Filename: "leave.txt", Distance: 0.5}, {ID: 4, Filename: "parking.txt", Distance: 0.9}},
+			"german-embed":        {{ID: 2, Filename: "urlaub.txt", Distance: 0.1}, {ID: 3, Filename: "parken.txt", Distance: 0.12}},
+		},
+	}
+	s := &State{Query: "How much notice does a leave request need?", DB: NewStore(db)}
+	if err := RetrieverNode(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Docs) != 4 || s.Docs[0].ID+s.Docs[1].ID != 3 {
+		t.Errorf("Docs = %+v, want the best chunk of each model first", s.Docs)
+	}
+}
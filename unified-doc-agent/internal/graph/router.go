@@ -0,0 +1,97 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// QueryType is the kind of answer a query asks for. Each type has its own
+// prompt template.
+type QueryType string
+
+const (
+	QueryFactoid QueryType = "factoid"
+	QuerySummary QueryType = "summary"
+	QueryList    QueryType = "list"
+	QueryCompare QueryType = "compare"
+	// QueryGraph answers multi-hop questions from the knowledge graph. It
+	// is never picked by ClassifyQuery; callers ask for it explicitly.
+	QueryGraph QueryType = "graph"
+)
+
+// queryRules are checked in order; the first matching type wins.
+var queryRules = []struct {
+	typ QueryType
+	re  *regexp.Regexp
+}{
+	{QueryCompare, regexp.MustCompile(`(?i)\b(compare|comparison|difference between|differences|differ|versus|vs\.?|contrast)\b`)},
+	{QueryList, regexp.MustCompile(`(?i)(^\s*(list|enumerate|name)\b|\b(every|all the|all of the|each of the)\b|\bwhat are (all )?the\b)`)},
+	{QuerySummary, regexp.MustCompile(`(?i)\b(summar(y|ize|ise)|overview|tl;?dr|explain|describe|what is .* about)\b`)},
+	{QueryFactoid, regexp.MustCompile(`(?i)^\s*(who|when|where|which|what|how (many|much|long|old)|is|are|does|do|did|can)\b`)},
+}
+
+// ClassifyQuery picks the QueryType for a query from its wording.
+// Queries that match nothing are summarized.
+func ClassifyQuery(query string) QueryType {
+	for _, r := range queryRules {
+		if r.re.MatchString(query) {
+			return r.typ
+		}
+	}
+	return QuerySummary
+}
+
+// ParseQueryMode returns the QueryType named by mode. "" and "auto" return
+// "", leaving the router to classify the query.
+func ParseQueryMode(mode string) (QueryType, error) {
+	switch t := QueryType(mode); t {
+	case "", "auto":
+		return "", nil
+	case QueryFactoid, QuerySummary, QueryList, QueryCompare, QueryGraph:
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown query mode %q (expected auto, factoid, summary, list, compare or graph)", mode)
+}
+
+// listTopKFactor widens retrieval for list and comparison queries, whose
+// answers are spread over more chunks than a single fact is.
+const listTopKFactor = 2
+
+// RouterNode classifies the query so later nodes can specialize.
+func RouterNode(ctx context.Context, s *State) error {
+	typ := ClassifyQuery(s.Query)
+	s.Update(func(s *State) {
+		if s.QueryType == "" {
+			s.QueryType = typ
+		}
+	})
+	return nil
+}
+
+// retrievalTopK is the number of chunks to retrieve for s.
+func retrievalTopK(s *State) int {
+	if s.TopK > 0 {
+		return s.TopK
+	}
+	switch s.QueryType {
+	case QueryList, QueryCompare:
+		return TopK * listTopKFactor
+	}
+	return TopK
+}
+
+// promptFor returns the prompt template name for the query type.
+func promptFor(t QueryType) string {
+	switch t {
+	case QueryFactoid:
+		return PromptFactoid
+	case QueryList:
+		return PromptList
+	case QueryCompare:
+		return PromptCompare
+	case QueryGraph:
+		return PromptGraph
+	}
+	return PromptSummarizer
+}
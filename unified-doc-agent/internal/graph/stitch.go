@@ -0,0 +1,121 @@
+package graph
+
+import (
+	"sort"
+	"strings"
+)
+
+// StitchChunks joins neighbouring chunks of the same file into one block
+// before prompting, overridden from the agent config at startup.
+var StitchChunks = true
+
+// maxStitchGap is the most text, in bytes, that may separate two chunks
+// for them to count as neighbours. The chunker only drops white space
+// between paragraphs.
+const maxStitchGap = 8
+
+// minTextOverlap is the shortest overlap found by comparing text when the
+// stored offsets no longer match it, e.g. after PII redaction.
+const minTextOverlap = 20
+
+// stitchChunks prepares retrieved chunks for the prompt. Chunks with the
+// same text as a better-ranked one are dropped, and chunks of the same file
+// and page that overlap or follow each other are joined into one block
+// with the overlapping text removed. A block takes the place and ID of its
+// best-ranked chunk. Chunks indexed without offsets are only deduplicated.
+// It returns the blocks and how many chunks were merged away.
+func stitchChunks(docs []Chunk) ([]Chunk, int) {
+	var blocks [][]Chunk
+	seen := map[string]bool{}
+	for _, d := range docs {
+		if seen[d.Content] {
+			continue
+		}
+		seen[d.Content] = true
+		into := -1
+		if StitchChunks && d.Start >= 0 {
+			for i, b := range blocks {
+				if b == nil || !neighbours(b, d) {
+					continue
+				}
+				if into < 0 {
+					into = i
+					blocks[i] = append(b, d)
+					continue
+				}
+				// d bridges two blocks.
+				blocks[into] = append(blocks[into], b...)
+				blocks[i] = nil
+			}
+		}
+		if into < 0 {
+			blocks = append(blocks, []Chunk{d})
+		}
+	}
+
+	var out []Chunk
+	for _, b := range blocks {
+		if b != nil {
+			out = append(out, joinBlock(b))
+		}
+	}
+	return out, len(docs) - len(out)
+}
+
+// neighbours reports whether d overlaps or directly follows or precedes a
+// chunk of block b.
+func neighbours(b []Chunk, d Chunk) bool {
+	for _, c := range b {
+		if c.Filename == d.Filename && c.Page == d.Page && c.Start >= 0 &&
+			d.Start <= c.End+maxStitchGap && c.Start <= d.End+maxStitchGap {
+			return true
+		}
+	}
+	return false
+}
+
+// joinBlock joins the chunks of a block in text order. The first chunk is
+// the best-ranked one and the block keeps its ID.
+func joinBlock(b []Chunk) Chunk {
+	out := b[0]
+	if len(b) == 1 {
+		return out
+	}
+	sorted := append([]Chunk(nil), b...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	content, end := sorted[0].Content, sorted[0].End
+	for _, c := range sorted[1:] {
+		if c.End <= end {
+			continue // contained in what is already joined
+		}
+		content = joinText(content, c.Content, end-c.Start)
+		end = c.End
+	}
+	out.Content = content
+	out.Start, out.End = sorted[0].Start, end
+	for _, c := range b {
+		if c.Score > out.Score {
+			out.Score = c.Score
+		}
+		out.Pinned = out.Pinned || c.Pinned
+	}
+	return out
+}
+
+// joinText appends next to prev, dropping the overlap bytes the offsets
+// say they share. If the text no longer matches the offsets the overlap is
+// looked for in the text itself.
+func joinText(prev, next string, overlap int) string {
+	if overlap <= 0 {
+		return prev + "\n\n" + next
+	}
+	if overlap < len(next) && strings.HasSuffix(prev, next[:overlap]) {
+		return prev + next[overlap:]
+	}
+	for n := min(len(prev), len(next)) - 1; n >= minTextOverlap; n-- {
+		if strings.HasSuffix(prev, next[:n]) {
+			return prev + next[n:]
+		}
+	}
+	return prev + "\n\n" + next
+}
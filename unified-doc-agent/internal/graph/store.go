@@ -0,0 +1,136 @@
+package graph
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// NewStore returns the workflow's view of db.
+func NewStore(db storage.Store) Store {
+	return storageStore{db}
+}
+
+// storageStore adapts a storage.Store to Store.
+type storageStore struct {
+	db storage.Store
+}
+
+func (s storageStore) Search(emb []float32, model string, k int, f Filter) ([]Chunk, error) {
+	models := []string{model}
+	if model == processing.EmbedModel {
+		// chunks stored before the model was recorded used the default
+		models = append(models, "")
+	}
+	docs, err := s.db.QuerySimilar(emb, k, models, f.AccessTags, f.Entities, f.Collections, rootPrefixes(f.Roots), f.Since, f.Until)
+	if err != nil {
+		return nil, err
+	}
+	return convertDocs(docs), nil
+}
+
+func (s storageStore) Embeddings(f Filter) ([]EmbeddingUse, error) {
+	uses, err := s.db.EmbeddingModels(f.AccessTags, f.Collections)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]EmbeddingUse, len(uses))
+	for i, u := range uses {
+		out[i] = EmbeddingUse{Model: u.Model, Version: u.Version, Chunks: u.Chunks}
+	}
+	return out, nil
+}
+
+func (s storageStore) Votes(ids []int) (map[int]int, error) {
+	return s.db.FeedbackVotes(ids)
+}
+
+func (s storageStore) Pinned(query string, f Filter) ([]Chunk, error) {
+	docs, err := s.db.PinnedChunks(query, f.AccessTags)
+	if err != nil {
+		return nil, err
+	}
+	return convertDocs(underRoots(docs, f.Roots)), nil
+}
+
+func (s storageStore) Graph(nodes []string, chunkIDs []int, files []string, f Filter, limit int) ([]Fact, error) {
+	edges, err := s.db.GraphEdges(nodes, chunkIDs, files, f.AccessTags, limit)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Fact, 0, len(edges))
+	for _, e := range edges {
+		if inRoots(e.Filename, f.Roots) {
+			out = append(out, Fact{Subject: e.Subject, Relation: e.Relation, Object: e.Object, ChunkID: e.ChunkID, Filename: e.Filename})
+		}
+	}
+	return out, nil
+}
+
+func (s storageStore) Chunks(ids []int, f Filter) ([]Chunk, error) {
+	docs, err := s.db.ChunksByID(ids, f.AccessTags)
+	if err != nil {
+		return nil, err
+	}
+	return convertDocs(underRoots(docs, f.Roots)), nil
+}
+
+func (s storageStore) RawText(filename string) (string, error) {
+	t, err := s.db.LoadText(filename)
+	if err != nil {
+		return "", err
+	}
+	return t.Text, nil
+}
+
+// rootPrefixes returns the prefixes of the names of the files under roots.
+func rootPrefixes(roots []string) []string {
+	prefixes := make([]string, len(roots))
+	for i, r := range roots {
+		prefixes[i] = strings.TrimSuffix(r, string(filepath.Separator)) + string(filepath.Separator)
+	}
+	return prefixes
+}
+
+// inRoots reports whether filename is under one of roots, or roots is
+// empty.
+func inRoots(filename string, roots []string) bool {
+	if len(roots) == 0 {
+		return true
+	}
+	for _, p := range rootPrefixes(roots) {
+		if strings.HasPrefix(filename, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// underRoots keeps the docs of the files under roots.
+func underRoots(docs []storage.Document, roots []string) []storage.Document {
+	if len(roots) == 0 {
+		return docs
+	}
+	kept := docs[:0]
+	for _, d := range docs {
+		if inRoots(d.Filename, roots) {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// convertDocs converts storage.Document → Chunk.
+func convertDocs(docs []storage.Document) []Chunk {
+	out := make([]Chunk, len(docs))
+	for i, d := range docs {
+		out[i] = Chunk{ID: d.ID, Filename: d.Filename, Source: d.Source, Content: d.Content, Page: d.Page, Distance: d.Distance,
+			Collection: d.Collection, Start: d.Start, End: d.End, Entities: d.Entities, Keywords: d.Keywords}
+		if d.Modified != nil {
+			out[i].Modified = *d.Modified
+		}
+	}
+	return out
+}
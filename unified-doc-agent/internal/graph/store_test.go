@@ -0,0 +1,186 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// mockStorage is a storage.Store recording the arguments of its calls.
+type mockStorage struct {
+	docs  []storage.Document
+	edges []storage.GraphEdge
+	texts map[string]string
+	votes map[int]int
+	// byModel, when set, are the chunks embedded by each model, searched
+	// instead of docs; uses are what EmbeddingModels reports.
+	byModel map[string][]storage.Document
+	uses    []storage.EmbeddingUse
+
+	models   []string
+	searches [][]string
+	allowed  []string
+}
+
+func (m *mockStorage) Ping(context.Context) error { return nil }
+func (m *mockStorage) Close()                     {}
+
+func (m *mockStorage) QuerySimilar(_ []float32, _ int, models, allowed, _, _, _ []string, _, _ time.Time) ([]storage.Document, error) {
+	m.models, m.allowed = models, allowed
+	m.searches = append(m.searches, models)
+	if m.byModel != nil {
+		return m.byModel[models[0]], nil
+	}
+	return m.docs, nil
+}
+
+func (m *mockStorage) EmbeddingModels(_, _ []string) ([]storage.EmbeddingUse, error) {
+	return m.uses, nil
+}
+
+func (m *mockStorage) ChunksByID(ids []int, allowed []string) ([]storage.Document, error) {
+	m.allowed = allowed
+	var out []storage.Document
+	for _, d := range m.docs {
+		for _, id := range ids {
+			if d.ID == id {
+				out = append(out, d)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (m *mockStorage) PinnedChunks(_ string, allowed []string) ([]storage.Document, error) {
+	m.allowed = allowed
+	return nil, nil
+}
+
+func (m *mockStorage) FeedbackVotes([]int) (map[int]int, error) { return m.votes, nil }
+
+func (m *mockStorage) AddFeedback(string, int, string, string, []string) error { return nil }
+
+func (m *mockStorage) GraphEdges(nodes []string, _ []int, _ []string, allowed []string, limit int) ([]storage.GraphEdge, error) {
+	m.allowed = allowed
+	var out []storage.GraphEdge
+	for _, e := range m.edges {
+		for _, n := range nodes {
+			if (e.Subject == n || e.Object == n) && len(out) < limit {
+				out = append(out, e)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+func (m *mockStorage) LoadText(filename string) (*storage.FileText, error) {
+	t, ok := m.texts[filename]
+	if !ok {
+		return nil, errors.New("no stored text for " + filename)
+	}
+	return &storage.FileText{Filename: filename, Text: t}, nil
+}
+
+func (m *mockStorage) ListDocuments([]string) ([]storage.IndexedFile, error) { return nil, nil }
+
+func (m *mockStorage) FileChunks(string, []string) ([]storage.Document, error) { return nil, nil }
+
+func (m *mockStorage) ForTenant(string) storage.Store     { return m }
+func (m *mockStorage) Tenants() ([]storage.Tenant, error) { return nil, nil }
+func (m *mockStorage) GetTenant(string) (*storage.Tenant, error) {
+	return nil, storage.ErrTenantNotFound
+}
+func (m *mockStorage) CreateTenant(storage.Tenant) (*storage.Tenant, error) { return nil, nil }
+func (m *mockStorage) UpdateTenant(storage.Tenant) (*storage.Tenant, error) { return nil, nil }
+func (m *mockStorage) DeleteTenant(string) error                            { return nil }
+
+func TestStoreSearch(t *testing.T) {
+	db := &mockStorage{docs: []storage.Document{{ID: 7, Filename: "a.txt", Content: "alpha", Page: 2, Distance: 0.5, Collection: "c"}}}
+	st := NewStore(db)
+	f := Filter{AccessTags: []string{"hr"}}
+
+	got, err := st.Search(nil, "other-model", 3, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Chunk{{ID: 7, Filename: "a.txt", Content: "alpha", Page: 2, Distance: 0.5, Collection: "c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Search = %+v, want %+v", got, want)
+	}
+	if !reflect.DeepEqual(db.models, []string{"other-model"}) {
+		t.Errorf("models = %q, want only the given model", db.models)
+	}
+	if !reflect.DeepEqual(db.allowed, f.AccessTags) {
+		t.Errorf("allowed = %q, want %q", db.allowed, f.AccessTags)
+	}
+
+	// Chunks stored before the model was recorded have no model.
+	if _, err := st.Search(nil, processing.EmbedModel, 3, f); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(db.models, []string{processing.EmbedModel, ""}) {
+		t.Errorf("models = %q, want the default model and \"\"", db.models)
+	}
+}
+
+func TestStoreRawText(t *testing.T) {
+	st := NewStore(&mockStorage{texts: map[string]string{"a.txt": "full text"}})
+	if got, err := st.RawText("a.txt"); err != nil || got != "full text" {
+		t.Errorf("RawText(a.txt) = %q, %v", got, err)
+	}
+	if _, err := st.RawText("b.txt"); err == nil {
+		t.Error("RawText(b.txt) succeeded without stored text")
+	}
+}
+
+func TestGraphNode(t *testing.T) {
+	db := &mockStorage{
+		docs: []storage.Document{{ID: 1, Filename: "a.txt"}, {ID: 2, Filename: "b.txt"}},
+		edges: []storage.GraphEdge{
+			{Triple: storage.Triple{Subject: "acme", Relation: "acquired", Object: "globex"}, ChunkID: 1, Filename: "a.txt"},
+			{Triple: storage.Triple{Subject: "globex", Relation: "owns", Object: "initech"}, ChunkID: 2, Filename: "b.txt"},
+		},
+	}
+	s := &State{Query: "Who owns what Acme acquired?", DB: NewStore(db), AccessTags: []string{"hr"}}
+	if err := GraphNode(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Facts) != 2 {
+		t.Fatalf("got %d facts, want both hops: %+v", len(s.Facts), s.Facts)
+	}
+	if len(s.Docs) != 2 || s.Docs[0].ID != 1 || s.Docs[1].ID != 2 {
+		t.Errorf("Docs = %+v, want the chunks stating the facts", s.Docs)
+	}
+	if !reflect.DeepEqual(db.allowed, s.AccessTags) {
+		t.Errorf("allowed = %q, want %q", db.allowed, s.AccessTags)
+	}
+}
+
+func TestCheckQuotesRawText(t *testing.T) {
+	db := &mockStorage{texts: map[string]string{
+		"a.txt": "The contract renews every year unless either party cancels.",
+	}}
+	s := &State{
+		Ans: `It says "renews every year unless either party cancels" and "was signed in Paris last May".`,
+		// The quote spans two chunks, so only the stored text has it whole.
+		Docs: []Chunk{
+			{Filename: "a.txt", Content: "The contract renews every year"},
+			{Filename: "a.txt", Content: "unless either party cancels."},
+		},
+		DB: NewStore(db),
+	}
+	got := checkQuotes(s)
+	want := []QuoteCheck{
+		{Quote: "renews every year unless either party cancels", Filename: "a.txt", Verified: true},
+		{Quote: "was signed in Paris last May"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checkQuotes = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,239 @@
+package graph
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// AnswerStyle shapes the answer of a query for whoever consumes it: a chat
+// UI may want a few bullet points, report generation long prose. Its
+// instructions are appended to the rendered summarizer prompt, so custom
+// templates get them too, and the answer is reshaped afterwards in case
+// the LLM did not follow them. The zero value leaves the answer as the
+// template has it.
+type AnswerStyle struct {
+	// Format is StyleBullet, StyleNarrative or "".
+	Format string
+	// Length is LengthShort, LengthLong or "".
+	Length string
+	// Language is the language to answer in, an ISO 639-1 code such as
+	// "es" or a name such as "Spanish"; "" leaves it to the LLM.
+	Language string
+}
+
+// Answer formats, and the long answer length; short answers share
+// LengthShort with document summaries.
+const (
+	StyleBullet    = "bullet"
+	StyleNarrative = "narrative"
+	LengthLong     = "long"
+)
+
+// Short answers are cut to this many bullet points or sentences.
+const (
+	shortMaxBullets   = 5
+	shortMaxSentences = 4
+)
+
+// languageNames names the languages most often asked for by code.
+var languageNames = map[string]string{
+	"ar": "Arabic", "de": "German", "en": "English", "es": "Spanish",
+	"fr": "French", "hi": "Hindi", "it": "Italian", "ja": "Japanese",
+	"ko": "Korean", "nl": "Dutch", "pl": "Polish", "pt": "Portuguese",
+	"ru": "Russian", "sv": "Swedish", "tr": "Turkish", "zh": "Chinese",
+}
+
+var (
+	languagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})?$|^[\p{L} ]{3,40}$`)
+	// bulletPattern matches the markers LLMs start list items with.
+	bulletPattern = regexp.MustCompile(`^\s*(?:[-*+•–]|\d{1,2}[.)])\s+`)
+)
+
+// ParseAnswerStyle checks the answer format, length and language of a
+// query; empty values leave the default.
+func ParseAnswerStyle(format, length, language string) (AnswerStyle, error) {
+	st := AnswerStyle{
+		Format:   strings.ToLower(strings.TrimSpace(format)),
+		Length:   strings.ToLower(strings.TrimSpace(length)),
+		Language: strings.TrimSpace(language),
+	}
+	switch st.Format {
+	case "", StyleBullet, StyleNarrative:
+	default:
+		return AnswerStyle{}, fmt.Errorf("unknown answer style %q (expected bullet or narrative)", format)
+	}
+	switch st.Length {
+	case "", LengthShort, LengthLong:
+	default:
+		return AnswerStyle{}, fmt.Errorf("unknown answer length %q (expected short or long)", length)
+	}
+	if st.Language != "" && !languagePattern.MatchString(st.Language) {
+		return AnswerStyle{}, fmt.Errorf("invalid answer language %q (expected a code such as es or a name such as Spanish)", language)
+	}
+	return st, nil
+}
+
+// languageName is the name of st.Language to put in a prompt.
+func (st AnswerStyle) languageName() string {
+	code, _, _ := strings.Cut(strings.ToLower(st.Language), "-")
+	if name, ok := languageNames[code]; ok {
+		return name
+	}
+	return st.Language
+}
+
+// instructions are the prompt lines asking for st, or "" for the zero
+// style.
+func (st AnswerStyle) instructions() string {
+	var lines []string
+	switch st.Format {
+	case StyleBullet:
+		lines = append(lines, `Write the answer as bullet points, one per line, each starting with "- ".`)
+	case StyleNarrative:
+		lines = append(lines, "Write the answer as prose in full paragraphs, without bullet points or headings.")
+	}
+	switch {
+	case st.Length == LengthShort && st.Format == StyleBullet:
+		lines = append(lines, fmt.Sprintf("Keep it short: at most %d bullet points.", shortMaxBullets))
+	case st.Length == LengthShort && st.Format == StyleNarrative:
+		lines = append(lines, fmt.Sprintf("Keep it short: at most %d sentences.", shortMaxSentences))
+	case st.Length == LengthShort:
+		lines = append(lines, fmt.Sprintf("Keep it short: at most %d sentences or bullet points.", shortMaxSentences))
+	case st.Length == LengthLong:
+		lines = append(lines, "Be thorough: cover every relevant point of the documents, keeping names, numbers and dates.")
+	}
+	if st.Language != "" {
+		lines = append(lines, fmt.Sprintf("Answer in %s, whatever the language of the question and the documents; keep file names as they are.", st.languageName()))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "\n\n" + strings.Join(lines, "\n") + "\n"
+}
+
+// apply reshapes ans to st where the LLM did not follow the instructions:
+// list markers become "- " or are dropped for prose, and short answers are
+// cut to shortMaxBullets points or shortMaxSentences sentences.
+func (st AnswerStyle) apply(ans string) string {
+	ans = strings.TrimSpace(ans)
+	if ans == "" {
+		return ans
+	}
+	switch st.Format {
+	case StyleBullet:
+		ans = toBullets(ans)
+	case StyleNarrative:
+		ans = toProse(ans)
+	}
+	if st.Length != LengthShort {
+		return ans
+	}
+	if st.Format == StyleBullet || (st.Format == "" && hasBullets(ans)) {
+		return limitBullets(ans, shortMaxBullets)
+	}
+	return limitSentences(ans, shortMaxSentences)
+}
+
+func hasBullets(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		if bulletPattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// toBullets normalizes list markers to "- ". An answer without any list
+// gets a bullet per sentence.
+func toBullets(text string) string {
+	if !hasBullets(text) {
+		var b strings.Builder
+		for _, s := range sentences(strings.Join(strings.Fields(text), " ")) {
+			b.WriteString("- " + s + "\n")
+		}
+		return strings.TrimRight(b.String(), "\n")
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if loc := bulletPattern.FindStringIndex(line); loc != nil {
+			lines[i] = "- " + line[loc[1]:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// toProse drops list markers, joining the items of a list into one
+// paragraph. Blank lines still separate paragraphs.
+func toProse(text string) string {
+	var paragraphs []string
+	for _, para := range strings.Split(text, "\n\n") {
+		var parts []string
+		for _, line := range strings.Split(para, "\n") {
+			line = strings.TrimSpace(bulletPattern.ReplaceAllString(line, ""))
+			if line == "" {
+				continue
+			}
+			// List items often lack a full stop
+			if !strings.ContainsAny(line[len(line)-1:], ".!?:") {
+				line += "."
+			}
+			parts = append(parts, line)
+		}
+		if len(parts) > 0 {
+			paragraphs = append(paragraphs, strings.Join(parts, " "))
+		}
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// limitBullets cuts text after its max-th bullet point, dropping the
+// points past it and anything following them.
+func limitBullets(text string, max int) string {
+	lines := strings.Split(text, "\n")
+	bullets := 0
+	for i, line := range lines {
+		if !bulletPattern.MatchString(line) {
+			continue
+		}
+		if bullets == max {
+			return strings.TrimSpace(strings.Join(lines[:i], "\n"))
+		}
+		bullets++
+	}
+	return text
+}
+
+// limitSentences keeps the first max sentences of text.
+func limitSentences(text string, max int) string {
+	count := 0
+	for i := 0; i < len(text); i++ {
+		if !strings.ContainsRune(".!?", rune(text[i])) {
+			continue
+		}
+		if i+1 < len(text) && text[i+1] != ' ' && text[i+1] != '\n' {
+			continue
+		}
+		if count++; count == max {
+			return text[:i+1]
+		}
+	}
+	return text
+}
+
+// sentences splits text after each full stop, question or exclamation
+// mark followed by a space.
+func sentences(text string) []string {
+	var out []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if strings.ContainsRune(".!?", rune(text[i])) && i+1 < len(text) && text[i+1] == ' ' {
+			out = append(out, strings.TrimSpace(text[start:i+1]))
+			start = i + 1
+		}
+	}
+	if rest := strings.TrimSpace(text[start:]); rest != "" {
+		out = append(out, rest)
+	}
+	return out
+}
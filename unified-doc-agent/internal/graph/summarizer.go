@@ -1,13 +1,13 @@
 package graph
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"strings"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ollama"
 )
 
 // request body for Ollama
@@ -21,60 +21,110 @@ type ollamaRequest struct {
 type ollamaResponse struct {
 	Response string `json:"response"`
 	Done     bool   `json:"done"`
+	// Token counts, sent with the final chunk.
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
 }
 
+// LLMModel is the generation model, overridden from the agent config at startup.
+var LLMModel = "llama3"
+
 func SummarizerNode(ctx context.Context, s *State) error {
 	if len(s.Docs) == 0 {
 		s.Ans = "No documents found matching the query."
 		return nil
 	}
 
-	// Build the summarization prompt
-	var docText strings.Builder
-	for i, d := range s.Docs {
-		docText.WriteString(fmt.Sprintf("Document %d:\n%s\n\n", i+1, d))
+	maxChars := MaxContextChars
+	if s.MaxContextChars > 0 {
+		maxChars = s.MaxContextChars
 	}
-
-	prompt := fmt.Sprintf(
-		"The user asked: %q.\n\nSummarize the following documents in the context of this query:\n\n%s",
-		s.Query,
-		docText.String(),
-	)
-	// Prepare request
-	reqBody, _ := json.Marshal(ollamaRequest{
-		Model:  "llama3", // change if you want another model like "mistral"
-		Prompt: prompt,
+	docs, stitched := stitchChunks(s.Docs)
+	prompt, err := renderPrompt(promptFor(s.QueryType), PromptData{
+		Query:     s.Query,
+		Docs:      limitContext(docs, maxChars),
+		Citations: s.Result().Citations,
+		Facts:     s.Facts,
+	})
+	if err != nil {
+		return err
+	}
+	prompt += s.Style.instructions()
+	s.traceUpdate(func(t *Trace) {
+		t.PromptChars = len(prompt)
+		t.StitchedChunks = stitched
 	})
 
-	req, err := http.NewRequestWithContext(ctx, "POST", "http://localhost:11434/api/generate", bytes.NewBuffer(reqBody))
+	model := LLMModel
+	if s.LLMModel != "" {
+		model = s.LLMModel
+	}
+	ans, stats, err := generate(ctx, model, prompt, s.OnToken)
 	if err != nil {
-		return fmt.Errorf("creating ollama request: %w", err)
+		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	s.traceUpdate(func(t *Trace) {
+		t.PromptTokens = stats.PromptEvalCount
+		t.CompletionTokens = stats.EvalCount
+	})
+	// Tokens were streamed as generated; the answer is reshaped whole
+	s.Ans = s.Style.apply(ans)
+	return nil
+}
 
-	// Call Ollama
-	resp, err := http.DefaultClient.Do(req)
+// generate runs prompt through model, passing each streamed token to
+// onToken if it is not nil. The returned response carries the token counts
+// of the final chunk.
+func generate(ctx context.Context, model, prompt string, onToken func(string)) (string, ollamaResponse, error) {
+	body, err := ollama.Stream(ctx, "/api/generate", ollamaRequest{
+		Model:  model,
+		Prompt: prompt,
+	})
 	if err != nil {
-		return fmt.Errorf("calling ollama: %w", err)
+		return "", ollamaResponse{}, fmt.Errorf("calling ollama: %w", err)
 	}
-	defer resp.Body.Close()
+	defer body.Close()
 
 	// Read streaming response
-	var summary strings.Builder
-	decoder := json.NewDecoder(resp.Body)
+	var out strings.Builder
+	decoder := json.NewDecoder(body)
 	for {
 		var chunk ollamaResponse
 		if err := decoder.Decode(&chunk); err == io.EOF {
 			break
 		} else if err != nil {
-			return fmt.Errorf("decoding ollama response: %w", err)
+			return "", ollamaResponse{}, fmt.Errorf("decoding ollama response: %w", err)
+		}
+		out.WriteString(chunk.Response)
+		if onToken != nil {
+			onToken(chunk.Response)
 		}
-		summary.WriteString(chunk.Response)
 		if chunk.Done {
-			break
+			return out.String(), chunk, nil
 		}
 	}
+	return out.String(), ollamaResponse{}, nil
+}
 
-	s.Ans = summary.String()
-	return nil
+// limitContext keeps the best-ranked chunks whose content fits in max
+// characters. The first chunk is always kept, truncated if needed, so the
+// summarizer never gets an empty context. max <= 0 means no limit.
+func limitContext(docs []Chunk, max int) []Chunk {
+	if max <= 0 {
+		return docs
+	}
+	var out []Chunk
+	used := 0
+	for _, d := range docs {
+		if used+len(d.Content) > max {
+			if len(out) == 0 {
+				d.Content = strings.ToValidUTF8(d.Content[:max], "")
+				out = append(out, d)
+			}
+			break
+		}
+		used += len(d.Content)
+		out = append(out, d)
+	}
+	return out
 }
@@ -0,0 +1,125 @@
+package graph
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/graph")
+
+// Trace is the diagnostic record of one workflow run.
+type Trace struct {
+	RunID     string          `json:"run_id"`
+	Query     string          `json:"query"`
+	StartedAt time.Time       `json:"started_at"`
+	TotalMS   int64           `json:"total_ms"`
+	Nodes     []NodeTrace     `json:"nodes"`
+	Retrieved []RetrievedInfo `json:"retrieved"`
+	// GraphFacts is how many knowledge-graph facts a graph query used.
+	GraphFacts int `json:"graph_facts,omitempty"`
+	// StitchedChunks is how many retrieved chunks were merged into a
+	// neighbour or dropped as duplicates before prompting.
+	StitchedChunks int `json:"stitched_chunks,omitempty"`
+	// PromptChars is the size of the summarizer prompt.
+	PromptChars int `json:"prompt_chars"`
+	// PromptTokens and CompletionTokens are the LLM's own counts.
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	Error            string `json:"error,omitempty"`
+}
+
+// NodeTrace is one node execution.
+type NodeTrace struct {
+	Name       string `json:"name"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// RetrievedInfo is one chunk returned by retrieval, before any filtering.
+// Score is the plain similarity, before feedback adjusts it.
+type RetrievedInfo struct {
+	ChunkID    int     `json:"chunk_id"`
+	Filename   string  `json:"filename"`
+	Collection string  `json:"collection,omitempty"`
+	Distance   float64 `json:"distance"`
+	Score      float64 `json:"score"`
+	Votes      int     `json:"votes,omitempty"`
+	// Matches counts the chunk's entities and keywords found in the query.
+	Matches int `json:"matches,omitempty"`
+	// Recency is the factor the age of the chunk's file weighed its
+	// similarity by; 1 when recency weighting is off.
+	Recency float64 `json:"recency,omitempty"`
+	Pinned  bool    `json:"pinned,omitempty"`
+}
+
+func newTrace(query string) *Trace {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &Trace{RunID: hex.EncodeToString(b), Query: query, StartedAt: time.Now()}
+}
+
+// traceUpdate runs fn on the run's trace under the state lock.
+func (s *State) traceUpdate(fn func(t *Trace)) {
+	s.Update(func(s *State) {
+		if s.Trace == nil {
+			s.Trace = newTrace(s.Query)
+		}
+		fn(s.Trace)
+	})
+}
+
+// WriteTo prints a human-readable trace.
+func (t *Trace) WriteTo(w io.Writer) (int64, error) {
+	var n int
+	p := func(format string, args ...interface{}) {
+		k, _ := fmt.Fprintf(w, format, args...)
+		n += k
+	}
+	p("run %s  %dms\n", t.RunID, t.TotalMS)
+	for _, nt := range t.Nodes {
+		p("  node %-12s %6dms", nt.Name, nt.DurationMS)
+		if nt.Error != "" {
+			p("  error: %s", nt.Error)
+		}
+		p("\n")
+	}
+	for _, r := range t.Retrieved {
+		switch {
+		case r.Pinned:
+			p("  chunk %-6d pinned  %s\n", r.ChunkID, r.Filename)
+		case r.Votes != 0:
+			p("  chunk %-6d distance %.4f score %.3f votes %+d  %s\n", r.ChunkID, r.Distance, r.Score, r.Votes, r.Filename)
+		default:
+			p("  chunk %-6d distance %.4f score %.3f  %s\n", r.ChunkID, r.Distance, r.Score, r.Filename)
+		}
+	}
+	if t.StitchedChunks > 0 {
+		p("  stitched %d chunks into their neighbours\n", t.StitchedChunks)
+	}
+	p("  prompt %d chars, %d tokens; completion %d tokens\n", t.PromptChars, t.PromptTokens, t.CompletionTokens)
+	if t.Error != "" {
+		p("  error: %s\n", t.Error)
+	}
+	return int64(n), nil
+}
+
+// AppendRunLog appends t as one JSON line to path, creating it if needed.
+func AppendRunLog(path string, t *Trace) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(t)
+}
@@ -1,31 +1,250 @@
 package graph
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
 
 type State struct {
 	Query string
-	Docs  []string
+	Docs  []Chunk
 	Ans   string
-	DB    *DBWrapper
+	DB    Store
+
+	// Out receives the final Result once the workflow has run. Nil means
+	// no output.
+	Out Output
+	// OnToken, if set, is called with each piece of the answer as the LLM streams it.
+	OnToken func(string)
+	// Timings records how long each node took to run.
+	Timings map[string]time.Duration
+	// QueryType is set by the router node unless the caller sets it.
+	QueryType QueryType
+	// AccessTags are the access tags the caller may see; see Filter.
+	AccessTags []string
+	// Entities restricts retrieval to chunks mentioning all of them.
+	Entities []string
+	// Collections restricts retrieval to these collections, searched
+	// separately and merged; none searches every collection.
+	Collections []string
+	// Roots restricts retrieval to the files under these directories; see
+	// Filter.
+	Roots []string
+	// CollectionWeights overrides CollectionWeights for this run.
+	CollectionWeights map[string]float64
+	// Since and Until, when set, restrict retrieval to the files last
+	// modified at or after Since and before Until.
+	Since, Until time.Time
+	// RecencyHalfLife overrides RecencyHalfLife for this run when positive.
+	RecencyHalfLife time.Duration
+	// Facts are the knowledge-graph facts found for a graph query.
+	Facts []Fact
+	// TopK, MinScore, MaxContextChars and MinConfidence override the
+	// package defaults of the same name for this run when positive.
+	TopK            int
+	MinScore        float64
+	MaxContextChars int
+	MinConfidence   float64
+	// Verdict is the critic's verdict on the answer.
+	Verdict string
+	// Confidence is set by the confidence node.
+	Confidence *Confidence
+	// Quotes are the checks of the passages the answer quotes.
+	Quotes []QuoteCheck
+	// EmbedModel embeds the query instead of the models languages are
+	// routed to, and LLMModel answers it instead of LLMModel, when set;
+	// they carry a tenant's own models.
+	EmbedModel string
+	LLMModel   string
+	// AllowMixedEmbeddings searches the chunks of the query's models even
+	// when others in reach were embedded otherwise; see checkEmbeddings.
+	AllowMixedEmbeddings bool
+	// Style shapes the answer: bullet points or prose, short or long, and
+	// its language.
+	Style AnswerStyle
+	// Guardrails overrides DefaultGuardrails for this run when set.
+	Guardrails *Guardrails
+	// Filtered reports what the guardrails node dropped or redacted.
+	Filtered []Filtered
+	// Debug includes the Trace in the Result and appends it to the run log.
+	Debug bool
+	// Trace collects per-node timings, retrieval details and LLM usage.
+	Trace *Trace
+
+	// mu guards State when nodes in the same step run in parallel.
+	mu sync.Mutex
 }
 
-// DBWrapper is a thin wrapper around VectorStore to avoid circular imports in this example.
-// In real code, just pass the vector store interface type.
-type DBWrapper struct {
-	Search func([]float32, int) ([]string, error)
+// Update runs fn while holding the state lock. Nodes that may run in
+// parallel with others should mutate State through it.
+func (s *State) Update(fn func(*State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s)
 }
 
-func RunWorkflow(ctx context.Context, s *State) error {
-	nodes := []func(context.Context, *State) error{
-		RetrieverNode,
-		SummarizerNode,
-		CriticNode,
-		AnswerNode,
+func (s *State) recordTiming(node string, d time.Duration) {
+	s.Update(func(s *State) {
+		if s.Timings == nil {
+			s.Timings = map[string]time.Duration{}
+		}
+		s.Timings[node] = d
+	})
+}
+
+// Chunk is a retrieved document chunk.
+type Chunk struct {
+	ID       int
+	Filename string
+	Source   string
+	Content  string
+	Page     int // 1-based page number, 0 when unknown
+	// Collection is the collection the chunk's file was indexed into.
+	Collection string
+	// Start and End are the chunk's offsets in its page's text, -1 when
+	// unknown; neighbouring chunks are stitched together by them.
+	Start, End int
+	// Distance is the L2 distance to the query embedding; lower is closer.
+	Distance float64
+	// Score is the similarity derived from Distance, weighted by
+	// collection and adjusted by relevance feedback; higher is closer.
+	Score float64
+	// Pinned chunks were pinned to the query and are always included.
+	Pinned bool
+	// Entities and Keywords were extracted from the chunk at indexing.
+	Entities []string
+	Keywords []string
+	// Modified is when the chunk's file was last modified, zero when
+	// unknown.
+	Modified time.Time
+}
+
+func (c Chunk) String() string {
+	if c.Page > 0 {
+		return fmt.Sprintf("File: %s (page %d)\n%s", c.Filename, c.Page, c.Content)
 	}
-	for _, n := range nodes {
-		if err := n(ctx, s); err != nil {
-			return err
+	return fmt.Sprintf("File: %s\n%s", c.Filename, c.Content)
+}
+
+// Store is the index the workflow queries. NewStore adapts a
+// storage.Store to it.
+type Store interface {
+	// Search returns the k chunks nearest to the embedding among those
+	// embedded by model that f lets through.
+	Search(emb []float32, model string, k int, f Filter) ([]Chunk, error)
+	// Embeddings returns the models and versions that embedded the
+	// chunks f lets through, ignoring its entities.
+	Embeddings(f Filter) ([]EmbeddingUse, error)
+	// Votes returns the net helpful votes of the chunks with feedback.
+	Votes(ids []int) (map[int]int, error)
+	// Pinned returns the chunks pinned to query.
+	Pinned(query string, f Filter) ([]Chunk, error)
+	// Graph returns up to limit facts touching one of nodes, stated in one
+	// of chunkIDs, or stated in a file named by files.
+	Graph(nodes []string, chunkIDs []int, files []string, f Filter, limit int) ([]Fact, error)
+	// Chunks returns the chunks with the given ids that f lets through.
+	Chunks(ids []int, f Filter) ([]Chunk, error)
+	// RawText returns the full text extracted from a file at indexing.
+	RawText(filename string) (string, error)
+}
+
+// Filter restricts which chunks a search may return.
+type Filter struct {
+	// AccessTags are the tags the caller may see. Untagged chunks are
+	// always visible; "*" lets everything through.
+	AccessTags []string
+	// Entities, normalized by processing.NormalizeEntity, must all have
+	// been extracted from a chunk for it to match.
+	Entities []string
+	// Collections, when set, restricts the search to these collections.
+	Collections []string
+	// Since and Until, when set, restrict the search to the chunks of
+	// files last modified at or after Since and before Until.
+	Since, Until time.Time
+	// Roots, when set, restricts the search to the chunks of files under
+	// one of these absolute directories, such as the workspace roots of an
+	// MCP client.
+	Roots []string
+}
+
+// Node names used by the default workflow.
+const (
+	NodeRouter     = "router"
+	NodeRetriever  = "retriever"
+	NodeGraph      = "graph"
+	NodeGuardrails = "guardrails"
+	NodeSummarizer = "summarizer"
+	NodeCritic     = "critic"
+	NodeConfidence = "confidence"
+	NodeAnswer     = "answer"
+	NodeClarify    = "clarify"
+	NodeNoResults  = "no_results"
+)
+
+// DefaultGraph wires the standard route → retrieve → guardrails →
+// summarize → critique → score confidence → answer pipeline. The router's
+// query type picks the summarizer prompt and how widely to retrieve; graph
+// queries also walk the knowledge graph after retrieval. Ambiguous queries
+// branch to a clarification node and the summarizer is skipped when no
+// chunks are left to summarize.
+func DefaultGraph() *Graph {
+	g := NewGraph()
+	g.AddNode(Node{Name: NodeRouter, Run: RouterNode})
+	g.AddNode(Node{Name: NodeClarify, Run: ClarifyNode})
+	g.AddNode(Node{Name: NodeRetriever, Run: RetrieverNode, Timeout: 30 * time.Second})
+	g.AddNode(Node{Name: NodeGraph, Run: GraphNode, Timeout: 30 * time.Second})
+	g.AddNode(Node{Name: NodeGuardrails, Run: GuardrailsNode})
+	g.AddNode(Node{Name: NodeNoResults, Run: NoResultsNode})
+	g.AddNode(Node{Name: NodeSummarizer, Run: SummarizerNode, Timeout: 3 * time.Minute})
+	g.AddNode(Node{Name: NodeCritic, Run: CriticNode, OnError: ContinueOnError})
+	g.AddNode(Node{Name: NodeConfidence, Run: ConfidenceNode})
+	g.AddNode(Node{Name: NodeAnswer, Run: AnswerNode})
+
+	g.AddConditionalEdge(Start, NodeClarify, isAmbiguous)
+	g.AddConditionalEdge(Start, NodeRouter, not(isAmbiguous))
+	g.AddEdge(NodeRouter, NodeRetriever)
+	g.AddConditionalEdge(NodeRetriever, NodeGraph, isGraphQuery)
+	g.AddConditionalEdge(NodeRetriever, NodeGuardrails, and(not(isGraphQuery), hasDocs))
+	g.AddConditionalEdge(NodeRetriever, NodeNoResults, and(not(isGraphQuery), not(hasDocs)))
+	g.AddConditionalEdge(NodeGraph, NodeGuardrails, hasDocs)
+	g.AddConditionalEdge(NodeGraph, NodeNoResults, not(hasDocs))
+	g.AddConditionalEdge(NodeGuardrails, NodeSummarizer, hasDocs)
+	g.AddConditionalEdge(NodeGuardrails, NodeNoResults, not(hasDocs))
+	g.AddEdge(NodeSummarizer, NodeCritic)
+	g.AddEdge(NodeCritic, NodeConfidence)
+	g.AddEdge(NodeNoResults, NodeConfidence)
+	g.AddEdge(NodeConfidence, NodeAnswer)
+	g.AddEdge(NodeClarify, NodeAnswer)
+	return g
+}
+
+// RunLogPath is where debug runs are appended as JSON lines. Empty disables it.
+var RunLogPath string
+
+func RunWorkflow(ctx context.Context, s *State) error {
+	err := DefaultGraph().Run(ctx, s)
+	if s.Debug && RunLogPath != "" && s.Trace != nil {
+		if lerr := AppendRunLog(RunLogPath, s.Trace); lerr != nil {
+			log.Printf("run log: %v", lerr)
 		}
 	}
-	return nil
+	if err != nil || s.Out == nil {
+		return err
+	}
+	return s.Out.Write(s.Result())
+}
+
+func hasDocs(s *State) bool { return len(s.Docs) > 0 }
+
+func isGraphQuery(s *State) bool { return s.QueryType == QueryGraph }
+
+func and(a, b func(*State) bool) func(*State) bool {
+	return func(s *State) bool { return a(s) && b(s) }
+}
+
+func not(cond func(*State) bool) func(*State) bool {
+	return func(s *State) bool { return !cond(s) }
 }
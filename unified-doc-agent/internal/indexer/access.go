@@ -0,0 +1,105 @@
+package indexer
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// AccessRules maps a folder, or a source name such as "local" or "upload",
+// to the access tags given to the files ingested from it. A file gets the
+// tags of every rule that matches it. Untagged files are visible to every
+// query.
+var AccessRules map[string][]string
+
+// CollectionRules maps a folder, or a source name, to the collection its
+// files are put in. When several rules match, the most specific folder
+// wins and source names lose to folders. Files matching none go into
+// DefaultCollection.
+var CollectionRules map[string]string
+
+// DefaultCollection holds the files no collection rule matches.
+const DefaultCollection = "default"
+
+// fileLabels are what a file inherits from the rules it matches.
+type fileLabels struct {
+	tags       []string
+	collection string
+}
+
+func labelsFor(path, source string) fileLabels {
+	return fileLabels{tags: accessTags(path, source), collection: collectionFor(path, source)}
+}
+
+// collectionFor returns the collection of a file at path ingested from source.
+func collectionFor(path, source string) string {
+	abs := absName(path)
+	best, bestLen := DefaultCollection, -1
+	for key, coll := range CollectionRules {
+		n := -1
+		if key == source {
+			n = 0
+		} else if inFolder(abs, key) {
+			n = len(key)
+		}
+		// Ties go to the smaller name so the result is stable.
+		if n > bestLen || (n == bestLen && n >= 0 && coll < best) {
+			best, bestLen = coll, n
+		}
+	}
+	return best
+}
+
+// accessTags returns the sorted, de-duplicated tags for a file at path
+// ingested from source.
+func accessTags(path, source string) []string {
+	if len(AccessRules) == 0 {
+		return nil
+	}
+	abs := absName(path)
+	seen := map[string]bool{}
+	for key, tags := range AccessRules {
+		if key != source && !inFolder(abs, key) {
+			continue
+		}
+		for _, t := range tags {
+			seen[t] = true
+		}
+	}
+	if len(seen) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(seen))
+	for t := range seen {
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// absName returns the absolute path of a local file; names of files from
+// remote sources, such as s3://bucket/key, are returned as they are.
+func absName(path string) string {
+	if strings.Contains(path, "://") {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+// inFolder reports whether path is dir or lies below it. Remote folders
+// are written as URLs, e.g. s3://bucket/reports.
+func inFolder(path, dir string) bool {
+	if strings.Contains(dir, "://") {
+		dir = strings.TrimSuffix(dir, "/")
+		return path == dir || strings.HasPrefix(path, dir+"/")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
@@ -0,0 +1,212 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ingestion"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// GCReport is what CollectGarbage found and, once confirmed, removed.
+type GCReport struct {
+	// Confirmed is set when the garbage was removed rather than reported.
+	Confirmed bool `json:"confirmed"`
+	// MissingFiles are the local files indexed whose file is gone.
+	MissingFiles []storage.FileUsage `json:"missing_files"`
+	// OrphanChunks and OrphanTexts are the chunks and stored texts of
+	// files with no index record, by file name.
+	OrphanChunks []storage.FileUsage `json:"orphan_chunks"`
+	OrphanTexts  []storage.FileUsage `json:"orphan_texts"`
+	// UnusedTexts are the stored texts no file of any tenant refers to.
+	UnusedTexts     int   `json:"unused_texts"`
+	UnusedTextBytes int64 `json:"unused_text_bytes"`
+	// StaleCollections are the collections no collection rule leads to any
+	// more. They are only reported: re-indexing moves their files.
+	StaleCollections []StaleCollection `json:"stale_collections"`
+	// ReclaimableBytes is what removing the garbage frees, stale
+	// collections aside.
+	ReclaimableBytes int64 `json:"reclaimable_bytes"`
+}
+
+// StaleCollection is a collection of indexed files that files are no
+// longer put in.
+type StaleCollection struct {
+	Name  string `json:"name"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// CollectGarbage looks for what the index of the tenant of ctx holds in
+// vain: local files that were deleted without `agent index -prune`, chunks
+// and stored texts left by interrupted runs, and collections the config
+// no longer has rules for. With confirm, it removes all but the
+// collections and vacuums the tables. It must not run alongside an index
+// run, whose archive entries are stored before the archive is recorded.
+func CollectGarbage(ctx context.Context, confirm bool) (*GCReport, error) {
+	db := TenantOf(ctx).Store
+	files, err := db.RecordedFiles(ingestion.ArchiveSep)
+	if err != nil {
+		return nil, fmt.Errorf("list files: %w", err)
+	}
+	roots, err := db.RunRoots("local")
+	if err != nil {
+		return nil, fmt.Errorf("list runs: %w", err)
+	}
+	rep := &GCReport{}
+	if rep.OrphanChunks, err = db.OrphanChunks(ingestion.ArchiveSep); err != nil {
+		return nil, fmt.Errorf("list orphan chunks: %w", err)
+	}
+	if rep.OrphanTexts, err = db.OrphanTexts(ingestion.ArchiveSep); err != nil {
+		return nil, fmt.Errorf("list orphan texts: %w", err)
+	}
+	if rep.UnusedTexts, rep.UnusedTextBytes, err = db.UnusedTexts(); err != nil {
+		return nil, fmt.Errorf("list unused texts: %w", err)
+	}
+
+	collections := map[string]*StaleCollection{}
+	for _, f := range files {
+		if f.Source == "local" && localFileGone(f.Filename, roots) {
+			rep.MissingFiles = append(rep.MissingFiles, f)
+			rep.ReclaimableBytes += f.Bytes
+			continue
+		}
+		if f.Collection == DefaultCollection || ruledCollection(f.Collection) {
+			continue
+		}
+		c := collections[f.Collection]
+		if c == nil {
+			c = &StaleCollection{Name: f.Collection}
+			collections[f.Collection] = c
+		}
+		c.Files++
+		c.Bytes += f.Bytes
+	}
+	for _, c := range collections {
+		rep.StaleCollections = append(rep.StaleCollections, *c)
+	}
+	sort.Slice(rep.StaleCollections, func(i, j int) bool { return rep.StaleCollections[i].Name < rep.StaleCollections[j].Name })
+	for _, f := range rep.OrphanChunks {
+		rep.ReclaimableBytes += f.Bytes
+	}
+	for _, f := range rep.OrphanTexts {
+		rep.ReclaimableBytes += f.Bytes
+	}
+	rep.ReclaimableBytes += rep.UnusedTextBytes
+
+	rep.Confirmed = confirm
+	if !confirm || rep.empty() {
+		return rep, nil
+	}
+	for _, f := range rep.MissingFiles {
+		if err := RemoveFile(ctx, f.Filename); err != nil {
+			return nil, fmt.Errorf("remove %s: %w", f.Filename, err)
+		}
+	}
+	if err := db.DeleteOrphans(ingestion.ArchiveSep); err != nil {
+		return nil, fmt.Errorf("remove orphans: %w", err)
+	}
+	if err := db.Vacuum(); err != nil {
+		return rep, fmt.Errorf("vacuum: %w", err)
+	}
+	return rep, nil
+}
+
+// empty reports whether there is nothing to remove.
+func (rep *GCReport) empty() bool {
+	return len(rep.MissingFiles)+len(rep.OrphanChunks)+len(rep.OrphanTexts)+rep.UnusedTexts == 0
+}
+
+// ruledCollection reports whether a collection rule puts files in name.
+func ruledCollection(name string) bool {
+	for _, coll := range CollectionRules {
+		if coll == name {
+			return true
+		}
+	}
+	return false
+}
+
+// localFileGone reports whether the local file indexed as name is known
+// to be gone. Names are paths as walked, so a relative one is relative to
+// the folder the index run was started from; it is looked for from here
+// and from each folder above a run's root it would lie under. A name no
+// run accounts for, or a file that cannot be checked, is not gone.
+func localFileGone(name string, roots []string) bool {
+	if strings.Contains(name, "://") {
+		return false
+	}
+	candidates := []string{name}
+	if !filepath.IsAbs(name) {
+		candidates = nil
+		for _, root := range roots {
+			for dir := root; ; dir = filepath.Dir(dir) {
+				if path := filepath.Join(dir, name); inFolder(path, root) {
+					candidates = append(candidates, path)
+				}
+				if filepath.Dir(dir) == dir {
+					break
+				}
+			}
+		}
+		if len(candidates) == 0 {
+			return false
+		}
+		candidates = append(candidates, name)
+	}
+	for _, path := range candidates {
+		if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			return false
+		}
+	}
+	return true
+}
+
+// PrintGC writes a GCReport for people.
+func PrintGC(w io.Writer, rep *GCReport) {
+	verb := "would be removed"
+	if rep.Confirmed {
+		verb = "removed"
+	}
+	for _, f := range rep.MissingFiles {
+		fmt.Fprintf(w, "  missing %s: %d chunks, %s\n", f.Filename, f.Chunks, formatBytes(f.Bytes))
+	}
+	for _, f := range rep.OrphanChunks {
+		fmt.Fprintf(w, "  orphan  %s: %d chunks, %s\n", f.Filename, f.Chunks, formatBytes(f.Bytes))
+	}
+	for _, f := range rep.OrphanTexts {
+		fmt.Fprintf(w, "  orphan  %s: stored text, %s\n", f.Filename, formatBytes(f.Bytes))
+	}
+	for _, c := range rep.StaleCollections {
+		fmt.Fprintf(w, "  stale   collection %s: %d files, %s; re-index them to move them\n", c.Name, c.Files, formatBytes(c.Bytes))
+	}
+	fmt.Fprintf(w, "Missing files:     %d\n", len(rep.MissingFiles))
+	fmt.Fprintf(w, "Orphan chunks:     %d files\n", len(rep.OrphanChunks))
+	fmt.Fprintf(w, "Orphan texts:      %d\n", len(rep.OrphanTexts)+rep.UnusedTexts)
+	fmt.Fprintf(w, "Stale collections: %d\n", len(rep.StaleCollections))
+	fmt.Fprintf(w, "Reclaimable:       %s %s\n", formatBytes(rep.ReclaimableBytes), verb)
+	if !rep.Confirmed && !rep.empty() {
+		fmt.Fprintln(w, "Run again with -confirm to remove them.")
+	}
+}
+
+// formatBytes formats n bytes in binary units, e.g. 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
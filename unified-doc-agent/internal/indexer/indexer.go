@@ -0,0 +1,637 @@
+package indexer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ingestion"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+var (
+	// ErrNoText is returned for files that yield no extractable text. They
+	// are counted as skipped rather than failed.
+	ErrNoText = errors.New("no extractable text")
+	// ErrUnchanged is returned when a file's content hash matches the one
+	// recorded at its last indexing.
+	ErrUnchanged = errors.New("unchanged since last index")
+	// ErrDuplicate is returned for files whose content nearly matches an
+	// already indexed file. They are recorded but their chunks are not
+	// stored, so copies do not crowd the top-K at query time.
+	ErrDuplicate = errors.New("near-duplicate of an indexed file")
+)
+
+// DedupThreshold is the estimated Jaccard similarity at or above which a
+// file counts as a duplicate. Zero disables duplicate detection.
+var DedupThreshold = 0.9
+
+// StoreText keeps the full, compressed extracted text of every file so
+// that `agent rechunk` and `agent summarize -indexed` need not extract it
+// again and quotes in answers can be checked against it.
+var StoreText = true
+
+// KnowledgeGraph enables extracting (subject, relation, object) triples
+// from every chunk into the knowledge graph. It costs an LLM call per chunk.
+var KnowledgeGraph = false
+
+// FileIssue records why a file was skipped or failed.
+type FileIssue struct {
+	File   string `json:"file"`
+	Reason string `json:"reason"`
+}
+
+// Result summarizes an indexing run.
+type Result struct {
+	Files          int         `json:"files"`
+	Indexed        int         `json:"indexed"`
+	Updated        int         `json:"updated"`
+	Unchanged      int         `json:"unchanged"`
+	Resumed        int         `json:"skipped_by_resume"`
+	Removed        int         `json:"removed"`
+	Duplicates     int         `json:"duplicates"`
+	Skipped        int         `json:"skipped"`
+	Encrypted      int         `json:"encrypted"`
+	Failed         int         `json:"failed"`
+	Chunks         int         `json:"chunks"`
+	DurationMS     int64       `json:"duration_ms"`
+	Interrupted    bool        `json:"interrupted"`
+	Skips          []FileIssue `json:"skips,omitempty"`
+	Failures       []FileIssue `json:"failures,omitempty"`
+	DuplicateFiles []FileIssue `json:"duplicate_files,omitempty"`
+	Warnings       []FileIssue `json:"warnings,omitempty"`
+}
+
+// FileResult is the outcome of indexing one file.
+type FileResult struct {
+	Chunks   int      `json:"chunks"`
+	Language string   `json:"language,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	// DuplicateOf is set when the file was recognised as a near-duplicate.
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+
+	minhash []uint64
+	text    *storage.FileText
+	// updated is set when the file replaced an earlier indexed version.
+	updated bool
+}
+
+// Add counts the outcome of indexing file into r.
+func (r *Result) Add(file string, fr *FileResult, err error) {
+	if fr != nil {
+		for _, w := range fr.Warnings {
+			r.Warnings = append(r.Warnings, FileIssue{File: file, Reason: w})
+		}
+	}
+	switch {
+	case errors.Is(err, ErrUnchanged):
+		r.Unchanged++
+	case errors.Is(err, ErrDuplicate):
+		r.Duplicates++
+		r.DuplicateFiles = append(r.DuplicateFiles, FileIssue{File: file, Reason: "duplicate of " + fr.DuplicateOf})
+	case errors.Is(err, ErrNoText):
+		r.Skipped++
+		r.Skips = append(r.Skips, FileIssue{File: file, Reason: err.Error()})
+	case errors.Is(err, ingestion.ErrEncrypted):
+		// Encrypted PDFs are skipped rather than failed: no retry reads them
+		// until a password is configured.
+		r.Skipped++
+		r.Encrypted++
+		r.Skips = append(r.Skips, FileIssue{File: file, Reason: err.Error() + "; set its password in pdf_passwords"})
+	case err != nil:
+		r.Failed++
+		r.Failures = append(r.Failures, FileIssue{File: file, Reason: err.Error()})
+	default:
+		r.Indexed++
+		r.Chunks += fr.Chunks
+		if fr.updated {
+			r.Updated++
+		}
+	}
+}
+
+// Options controls an IndexPath run.
+type Options struct {
+	// Resume skips the files an unfinished earlier run over the same root
+	// had already finished.
+	Resume bool
+	// Stop, when closed, ends the run after the current file. Cancelling
+	// ctx instead aborts the current file too.
+	Stop <-chan struct{}
+	// Prune removes indexed files under root that no longer exist, once
+	// the run has walked every file.
+	Prune bool
+}
+
+// IndexPath indexes every supported file under root. rep may be nil.
+// Progress is checkpointed after each file so an interrupted run can be
+// resumed with Options.Resume.
+func IndexPath(ctx context.Context, root string, rep Reporter, opts Options) (*Result, error) {
+	if rep == nil {
+		rep = nopReporter{}
+	}
+	files, err := ingestion.LoadLocalFiles(root)
+	if err != nil {
+		return nil, fmt.Errorf("load files: %w", err)
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	res := &Result{Files: len(files)}
+	runID, skip, err := beginRun(ctx, absRoot, files, opts.Resume)
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint: %w", err)
+	}
+	res.Resumed = skip
+
+	rep.Start(len(files) - skip)
+	err = indexFiles(ctx, root, files, skip, runID, rep, opts, res)
+	res.DurationMS = time.Since(start).Milliseconds()
+	FinishRun(ctx, runID, res, err)
+	rep.Finish(res)
+	return res, err
+}
+
+// indexFiles indexes files from skip on into res, checkpointing run
+// runID after each, and prunes root when asked to.
+func indexFiles(ctx context.Context, root string, files []string, skip, runID int, rep Reporter, opts Options, res *Result) error {
+	db := TenantOf(ctx).Store
+	for i := skip; i < len(files); i++ {
+		f := files[i]
+		if stopped(opts.Stop) {
+			res.Interrupted = true
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			res.Interrupted = true
+			return err
+		}
+		fr, err := IndexFile(ctx, f, "local")
+		n := 0
+		if fr != nil {
+			n = fr.Chunks
+		}
+		res.Add(f, fr, err)
+		rep.FileDone(f, n, err)
+		if err := db.CheckpointIndexRun(runID, f, i+1); err != nil {
+			log.Println("checkpoint:", err)
+		}
+	}
+	if opts.Prune {
+		n, err := prune(ctx, root, files)
+		res.Removed = n
+		if err != nil {
+			return fmt.Errorf("prune: %w", err)
+		}
+	}
+	return nil
+}
+
+// StartRun records the start of a run of kind over root, a folder, name
+// prefix or remote source, indexing files from source for the tenant of
+// ctx.
+func StartRun(ctx context.Context, kind, root, source string) (int, error) {
+	t := TenantOf(ctx)
+	model := t.EmbedModel
+	if model == "" {
+		model = processing.EmbedModel
+	}
+	return t.Store.StartIndexRun(storage.IndexRun{Kind: kind, Root: root, Source: source, EmbedModel: model})
+}
+
+// FinishRun records the outcome of run id; err is what ended it early, if
+// anything. Failing to record it is only logged.
+func FinishRun(ctx context.Context, id int, res *Result, err error) {
+	status := storage.RunCompleted
+	msg := ""
+	switch {
+	case res.Interrupted:
+		status = storage.RunInterrupted
+	case err != nil:
+		status, msg = storage.RunFailed, err.Error()
+	}
+	counts := storage.RunCounts{
+		Added:      res.Indexed - res.Updated,
+		Updated:    res.Updated,
+		Unchanged:  res.Unchanged,
+		Duplicates: res.Duplicates,
+		Skipped:    res.Skipped,
+		Failed:     res.Failed,
+		Removed:    res.Removed,
+		Chunks:     res.Chunks,
+	}
+	if err := TenantOf(ctx).Store.FinishIndexRun(id, status, counts, msg, res); err != nil {
+		log.Println("record run:", err)
+	}
+}
+
+// prune deletes the indexed files under root that are not among files,
+// along with the entries of removed archives. Files that are now ignored
+// or too large count as removed too.
+func prune(ctx context.Context, root string, files []string) (int, error) {
+	present := make(map[string]bool, len(files))
+	for _, f := range files {
+		present[f] = true
+	}
+	// Walked paths are joined onto the cleaned root.
+	indexed, err := TenantOf(ctx).Store.IndexedFilesWithPrefix(filepath.Clean(root) + string(filepath.Separator))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, name := range indexed {
+		if present[name] {
+			continue
+		}
+		if err := RemoveFile(ctx, name); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// RemoveFile removes an indexed file of the tenant of ctx and, for an
+// archive, its entries.
+func RemoveFile(ctx context.Context, name string) error {
+	db := TenantOf(ctx).Store
+	if err := db.DeleteFile(name); err != nil {
+		return err
+	}
+	return db.DeleteFilesWithPrefix(name + ingestion.ArchiveSep)
+}
+
+// beginRun starts a new run, or with resume picks up the last unfinished
+// run over root and returns how many leading files it already finished.
+// If that run's last file is no longer in the list, everything is walked
+// again; unchanged files are still skipped by their hash.
+func beginRun(ctx context.Context, root string, files []string, resume bool) (int, int, error) {
+	if resume {
+		db := TenantOf(ctx).Store
+		prev, err := db.LastUnfinishedRun(root)
+		if err != nil {
+			return 0, 0, err
+		}
+		if prev != nil {
+			skip := 0
+			for i, f := range files {
+				if f == prev.LastFile {
+					skip = i + 1
+					break
+				}
+			}
+			return prev.ID, skip, db.ResumeIndexRun(prev.ID)
+		}
+	}
+	id, err := StartRun(ctx, storage.RunIndex, root, "local")
+	return id, 0, err
+}
+
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// IndexFile extracts, chunks, embeds and stores a single file. Files whose
+// content has not changed since they were last indexed return ErrUnchanged;
+// changed files replace their old chunks. Archives are expanded and each
+// supported file inside is stored under its archive-relative name.
+func IndexFile(ctx context.Context, path, source string) (*FileResult, error) {
+	hash, size, err := hashFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return indexFile(ctx, path, path, hash, size, info.ModTime(), source)
+}
+
+// IndexRemote indexes a file downloaded to path from a remote source under
+// name, e.g. s3://bucket/key. version identifies the remote content and is
+// recorded in place of the file hash; it must differ between sources, so
+// it should start with the source's scheme. modified is when the file was
+// last modified at the source, zero if it does not tell. An unchanged
+// version returns ErrUnchanged.
+func IndexRemote(ctx context.Context, path, name, version, source string, modified time.Time) (*FileResult, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return indexFile(ctx, path, name, version, info.Size(), modified, source)
+}
+
+// indexFile indexes the file at path under name; hash identifies its
+// content and modified is when it last changed.
+func indexFile(ctx context.Context, path, name, hash string, size int64, modified time.Time, source string) (*FileResult, error) {
+	db := TenantOf(ctx).Store
+	prev, indexed, err := db.FileHash(name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup hash: %w", err)
+	}
+	labels := labelsFor(name, source)
+	if indexed && prev == hash {
+		// The access and collection rules may have changed since.
+		if err := db.SetLabels(name, name+ingestion.ArchiveSep, labels.tags, labels.collection); err != nil {
+			return nil, fmt.Errorf("retag: %w", err)
+		}
+		if err := db.SetModified(name, name+ingestion.ArchiveSep, modified); err != nil {
+			return nil, fmt.Errorf("record modification time: %w", err)
+		}
+		return nil, ErrUnchanged
+	}
+
+	if ingestion.IsArchive(name) {
+		fr, err := indexArchive(ctx, path, name, source, labels, modified)
+		if err != nil {
+			return fr, err
+		}
+		fr.updated = indexed
+		rec := storage.FileRecord{Filename: name, Source: source, Hash: hash, Size: size, Chunks: fr.Chunks, AccessTags: labels.tags, Collection: labels.collection, Modified: modified}
+		if err := db.RecordFile(rec); err != nil {
+			return fr, fmt.Errorf("record file: %w", err)
+		}
+		return fr, nil
+	}
+
+	fr, chunks, err := prepareFile(ctx, path, name, hash, source, labels, modified, true)
+	if err != nil && !errors.Is(err, ErrDuplicate) {
+		return fr, err
+	}
+	fr.updated = indexed
+	rec := storage.FileRecord{
+		Filename:    name,
+		Source:      source,
+		Hash:        hash,
+		Language:    fr.Language,
+		Size:        size,
+		Chunks:      fr.Chunks,
+		MinHash:     fr.minhash,
+		DuplicateOf: fr.DuplicateOf,
+		AccessTags:  labels.tags,
+		Collection:  labels.collection,
+		Modified:    modified,
+	}
+	if serr := db.StoreFile(name, chunks, fr.text, &rec); serr != nil {
+		return fr, fmt.Errorf("store: %w", serr)
+	}
+	return fr, err
+}
+
+// indexArchive replaces everything previously stored from the archive at
+// path, indexed as name, with the files it contains now. Each entry
+// replaces its old chunks as it is stored, and only then are the entries
+// no longer stored removed, so searches keep finding the archive while it
+// is reindexed. A file inside that fails becomes a warning rather than
+// failing the whole archive.
+// Entries inherit the archive's tags, collection and modification time.
+func indexArchive(ctx context.Context, path, name, source string, labels fileLabels, modified time.Time) (*FileResult, error) {
+	exp, err := ingestion.ExpandArchive(path, name)
+	if err != nil {
+		return nil, err
+	}
+	defer exp.Close()
+
+	db := TenantOf(ctx).Store
+	fr := &FileResult{Warnings: exp.Warnings}
+	var stored []string
+	for _, e := range exp.Entries {
+		if err := ctx.Err(); err != nil {
+			return fr, err
+		}
+		hash, _, err := hashFile(e.Path)
+		if err != nil {
+			fr.Warnings = append(fr.Warnings, e.Name+": "+err.Error())
+			continue
+		}
+		efr, chunks, err := prepareFile(ctx, e.Path, e.Name, hash, source, labels, modified, false)
+		if err == nil {
+			err = db.StoreFile(e.Name, chunks, efr.text, nil)
+		}
+		if efr != nil {
+			for _, w := range efr.Warnings {
+				fr.Warnings = append(fr.Warnings, e.Name+": "+w)
+			}
+		}
+		if err != nil {
+			fr.Warnings = append(fr.Warnings, e.Name+": "+err.Error())
+			continue
+		}
+		stored = append(stored, e.Name)
+		fr.Chunks += efr.Chunks
+	}
+	if err := db.DeleteFilesWithPrefixExcept(name+ingestion.ArchiveSep, stored); err != nil {
+		return fr, fmt.Errorf("remove old chunks: %w", err)
+	}
+	if fr.Chunks == 0 {
+		return fr, ErrNoText
+	}
+	return fr, nil
+}
+
+// prepareFile extracts, chunks and embeds the file at path, whose content
+// hashes to hash, returning the chunk records to store under name with the
+// given labels and modification time. With dedup
+// set, a near-duplicate of another indexed file returns ErrDuplicate and no
+// chunks.
+func prepareFile(ctx context.Context, path, name, hash, source string, labels fileLabels, modified time.Time, dedup bool) (*FileResult, []storage.ChunkRecord, error) {
+	ext, err := extract(path, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fr := &FileResult{Warnings: ext.Warnings}
+	if strings.TrimSpace(ext.Text) == "" {
+		return fr, nil, ErrNoText
+	}
+	fr.Language = processing.DetectLanguage(ext.Text)
+	if dedup && DedupThreshold > 0 {
+		fr.minhash = processing.MinHash(ext.Text)
+		dup, err := findDuplicate(ctx, name, fr.minhash, labels.tags)
+		if err != nil {
+			return fr, nil, fmt.Errorf("duplicate check: %w", err)
+		}
+		if dup != "" {
+			fr.DuplicateOf = dup
+			return fr, nil, ErrDuplicate
+		}
+	}
+	if StoreText {
+		fr.text = fileText(ext, hash, source)
+	}
+	chunks, err := buildChunks(ctx, ext, name, source, labels, modified, fr)
+	if err != nil {
+		return fr, nil, err
+	}
+	fr.Chunks = len(chunks)
+	return fr, chunks, nil
+}
+
+// buildChunks chunks and embeds extracted text, returning the records to
+// store under name; modified may be zero when unknown. fr supplies the
+// language and collects warnings.
+func buildChunks(ctx context.Context, ext *ingestion.Extraction, name, source string, labels fileLabels, modified time.Time, fr *FileResult) ([]storage.ChunkRecord, error) {
+	model := TenantOf(ctx).embedModel(fr.Language)
+	spans, pages := chunkExtraction(ext)
+	texts := make([]string, len(spans))
+	for i, sp := range spans {
+		texts[i] = sp.Text
+	}
+	embs, err := processing.EmbedChunksWithModel(ctx, model, texts)
+	if err != nil {
+		return nil, fmt.Errorf("embed: %w", err)
+	}
+	version, err := processing.EmbedVersion(ctx, model)
+	if err != nil {
+		return nil, fmt.Errorf("embed: %w", err)
+	}
+	metas := extractMetadata(ctx, texts, fr)
+	var triples [][]storage.Triple
+	if KnowledgeGraph {
+		triples = extractTriples(ctx, texts, fr)
+	}
+	chunks := make([]storage.ChunkRecord, len(texts))
+	for i := range texts {
+		chunks[i] = storage.ChunkRecord{
+			Filename:     name,
+			Source:       source,
+			Content:      texts[i],
+			Page:         pages[i],
+			Start:        spans[i].Start,
+			End:          spans[i].End,
+			Language:     fr.Language,
+			EmbedModel:   model,
+			EmbedVersion: version,
+			AccessTags:   labels.tags,
+			Collection:   labels.collection,
+			Entities:     metas[i].Entities,
+			Keywords:     metas[i].Keywords,
+			Embedding:    embs[i],
+			Modified:     modified,
+		}
+		if triples != nil {
+			chunks[i].Triples = triples[i]
+		}
+	}
+	return chunks, nil
+}
+
+// fileText is the text of ext as stored for re-chunking, summarizing and
+// quote checks.
+func fileText(ext *ingestion.Extraction, hash, source string) *storage.FileText {
+	t := &storage.FileText{Source: source, Hash: hash, Text: ext.Text}
+	for _, p := range ext.Pages {
+		t.Pages = append(t.Pages, storage.PageText{Number: p.Number, Text: p.Text})
+	}
+	return t
+}
+
+// extractMetadata extracts the entities and keyphrases of each chunk. If
+// the configured extractor fails, the rest of the file falls back to the
+// built-in rules with a warning rather than failing the file.
+func extractMetadata(ctx context.Context, texts []string, fr *FileResult) []processing.ChunkMetadata {
+	out := make([]processing.ChunkMetadata, len(texts))
+	fallback := false
+	for i, t := range texts {
+		if fallback {
+			out[i] = processing.RuleMetadata(t)
+			continue
+		}
+		md, err := processing.ExtractMetadata(ctx, t)
+		if err != nil {
+			fr.Warnings = append(fr.Warnings, err.Error()+"; using rule-based extraction")
+			fallback = true
+			md = processing.RuleMetadata(t)
+		}
+		out[i] = md
+	}
+	return out
+}
+
+// findDuplicate returns the indexed file most similar to sig, if it
+// reaches DedupThreshold. name itself is never its own duplicate, and only
+// files with the same access tags are considered.
+func findDuplicate(ctx context.Context, name string, sig []uint64, tags []string) (string, error) {
+	sigs, err := TenantOf(ctx).Store.FileSignatures(tags)
+	if err != nil {
+		return "", err
+	}
+	best, bestSim := "", DedupThreshold
+	for _, s := range sigs {
+		if s.Filename == name {
+			continue
+		}
+		if sim := processing.Similarity(sig, s.MinHash); sim >= bestSim {
+			best, bestSim = s.Filename, sim
+		}
+	}
+	return best, nil
+}
+
+// extractTriples extracts the knowledge-graph triples of each chunk. A
+// failure leaves the rest of the file without triples and adds a warning;
+// the chunks are still stored.
+func extractTriples(ctx context.Context, texts []string, fr *FileResult) [][]storage.Triple {
+	out := make([][]storage.Triple, len(texts))
+	for i, t := range texts {
+		ts, err := processing.ExtractTriples(ctx, t)
+		if err != nil {
+			fr.Warnings = append(fr.Warnings, err.Error()+"; rest of the file left out of the knowledge graph")
+			break
+		}
+		for _, tr := range ts {
+			out[i] = append(out[i], storage.Triple{Subject: tr.Subject, Relation: tr.Relation, Object: tr.Object})
+		}
+	}
+	return out
+}
+
+// chunkExtraction chunks paged documents page by page so every chunk can be
+// traced back to its page. The second slice holds each chunk's page number
+// (0 for documents without pages); span offsets are into the page's text.
+func chunkExtraction(ext *ingestion.Extraction) ([]processing.Span, []int) {
+	if len(ext.Pages) == 0 {
+		chunks := processing.ChunkSpans(ext.Text)
+		return chunks, make([]int, len(chunks))
+	}
+	var chunks []processing.Span
+	var pages []int
+	for _, p := range ext.Pages {
+		for _, c := range processing.ChunkSpans(p.Text) {
+			chunks = append(chunks, c)
+			pages = append(pages, p.Number)
+		}
+	}
+	return chunks, pages
+}
+
+// hashFile returns the hex SHA-256 and size of the file at path.
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,47 @@
+package indexer
+
+import (
+	"errors"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ingestion"
+)
+
+// PDFPasswords maps an encrypted PDF, or a folder of them, to the password
+// opening it. When several entries match, the most specific one wins.
+var PDFPasswords map[string]string
+
+// PasswordPrompt, when set, is asked for the password of an encrypted PDF
+// that no entry of PDFPasswords opens, and again while the password it
+// gives does not open it. Returning "" gives up and skips the file. It is
+// set by the interactive `agent index` only.
+var PasswordPrompt func(name string) string
+
+// pdfPassword returns the password of PDFPasswords for the file name.
+func pdfPassword(name string) string {
+	if len(PDFPasswords) == 0 {
+		return ""
+	}
+	abs := absName(name)
+	best, bestLen := "", -1
+	for key, password := range PDFPasswords {
+		if inFolder(abs, key) && len(key) > bestLen {
+			best, bestLen = password, len(key)
+		}
+	}
+	return best
+}
+
+// extract extracts the file at path, stored under name, decrypting an
+// encrypted PDF with its configured password or those PasswordPrompt
+// gives.
+func extract(path, name string) (*ingestion.Extraction, error) {
+	ext, err := ingestion.ExtractWithPassword(path, pdfPassword(name))
+	for errors.Is(err, ingestion.ErrEncrypted) && PasswordPrompt != nil {
+		password := PasswordPrompt(name)
+		if password == "" {
+			break
+		}
+		ext, err = ingestion.ExtractWithPassword(path, password)
+	}
+	return ext, err
+}
@@ -0,0 +1,176 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ingestion"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+)
+
+// Plan actions.
+const (
+	ActionIndex     = "index"
+	ActionReindex   = "reindex"
+	ActionUnchanged = "unchanged"
+	ActionSkip      = "skip"
+)
+
+// PlanEntry is what a real run would do with one file.
+type PlanEntry struct {
+	File      string `json:"file"`
+	Action    string `json:"action"`
+	Reason    string `json:"reason,omitempty"`
+	SizeBytes int64  `json:"size_bytes"`
+	// EstChunks is exact for plain text and PDFs with a text layer; files
+	// that need OCR are flagged and not estimated.
+	EstChunks int  `json:"est_chunks"`
+	NeedsOCR  bool `json:"needs_ocr,omitempty"`
+	// Encrypted is set for encrypted PDFs no configured password opens;
+	// a real run skips them.
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+// Plan is the output of a dry run.
+type Plan struct {
+	Entries       []PlanEntry `json:"entries"`
+	Index         int         `json:"index"`
+	Reindex       int         `json:"reindex"`
+	Unchanged     int         `json:"unchanged"`
+	Skip          int         `json:"skip"`
+	NeedsOCR      int         `json:"needs_ocr"`
+	Encrypted     int         `json:"encrypted"`
+	EstChunks     int         `json:"est_chunks"`
+	EstEmbeddings int         `json:"est_embeddings"`
+}
+
+// BuildPlan walks root and reports what IndexPath would do, without
+// running OCR, embedding, or writing to the database.
+func BuildPlan(ctx context.Context, root string) (*Plan, error) {
+	candidates, err := ingestion.ScanLocalFiles(root)
+	if err != nil {
+		return nil, fmt.Errorf("load files: %w", err)
+	}
+
+	plan := &Plan{Entries: []PlanEntry{}}
+	for _, c := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		e := PlanEntry{File: c.Path, SizeBytes: c.Size}
+		if c.SkipReason != "" {
+			e.Action, e.Reason = ActionSkip, c.SkipReason
+			plan.add(e)
+			continue
+		}
+
+		hash, _, err := hashFile(c.Path)
+		if err != nil {
+			e.Action, e.Reason = ActionSkip, err.Error()
+			plan.add(e)
+			continue
+		}
+		prev, indexed, err := TenantOf(ctx).Store.FileHash(c.Path)
+		if err != nil {
+			return nil, fmt.Errorf("lookup hash: %w", err)
+		}
+		switch {
+		case indexed && prev == hash:
+			e.Action = ActionUnchanged
+			plan.add(e)
+			continue
+		case indexed:
+			e.Action, e.Reason = ActionReindex, "content changed"
+		default:
+			e.Action = ActionIndex
+		}
+		e.EstChunks, e.NeedsOCR, e.Encrypted = estimateChunks(c.Path)
+		plan.add(e)
+	}
+	return plan, nil
+}
+
+func (p *Plan) add(e PlanEntry) {
+	p.Entries = append(p.Entries, e)
+	switch e.Action {
+	case ActionIndex:
+		p.Index++
+	case ActionReindex:
+		p.Reindex++
+	case ActionUnchanged:
+		p.Unchanged++
+	case ActionSkip:
+		p.Skip++
+	}
+	if e.NeedsOCR {
+		p.NeedsOCR++
+	}
+	if e.Encrypted {
+		p.Encrypted++
+	}
+	p.EstChunks += e.EstChunks
+	p.EstEmbeddings += e.EstChunks // one embedding per chunk
+}
+
+// estimateChunks chunks the cheaply extractable text of path. Images and
+// scanned PDFs would need OCR, so they are reported instead of estimated,
+// as are encrypted PDFs no configured password opens.
+func estimateChunks(path string) (chunks int, needsOCR, encrypted bool) {
+	if ingestion.IsArchive(path) {
+		// Contents are unknown until the archive is expanded.
+		return 0, false, false
+	}
+	var text string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".txt", ".md":
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return 0, false, false
+		}
+		text = string(b)
+	case ".pdf":
+		t, err := ingestion.ExtractTextFromPDF(path, pdfPassword(path))
+		if errors.Is(err, ingestion.ErrEncrypted) {
+			return 0, false, true
+		}
+		if err != nil || strings.TrimSpace(t) == "" {
+			return 0, true, false
+		}
+		text = t
+	default:
+		return 0, true, false
+	}
+	return len(processing.ChunkText(text)), false, false
+}
+
+// PrintPlan writes a human-readable plan to w.
+func PrintPlan(w io.Writer, p *Plan) {
+	for _, e := range p.Entries {
+		line := fmt.Sprintf("%-9s %s", e.Action, e.File)
+		if e.Reason != "" {
+			line += " (" + e.Reason + ")"
+		}
+		if e.Encrypted {
+			line += " [encrypted, no password]"
+		} else if e.NeedsOCR {
+			line += " [needs OCR]"
+		} else if e.EstChunks > 0 {
+			line += fmt.Sprintf(" ~%d chunks", e.EstChunks)
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintf(w, "\nWould index %d, re-index %d, leave %d unchanged, skip %d.\n", p.Index, p.Reindex, p.Unchanged, p.Skip)
+	fmt.Fprintf(w, "Estimated %d chunks / %d embeddings", p.EstChunks, p.EstEmbeddings)
+	if p.NeedsOCR > 0 {
+		fmt.Fprintf(w, ", plus %d file(s) needing OCR", p.NeedsOCR)
+	}
+	if p.Encrypted > 0 {
+		fmt.Fprintf(w, "; %d encrypted PDF(s) would be skipped (set pdf_passwords)", p.Encrypted)
+	}
+	fmt.Fprintln(w, ".")
+}
@@ -0,0 +1,102 @@
+package indexer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Reporter receives progress events from IndexPath.
+type Reporter interface {
+	Start(total int)
+	FileDone(path string, chunks int, err error)
+	Finish(res *Result)
+}
+
+type nopReporter struct{}
+
+func (nopReporter) Start(int)                   {}
+func (nopReporter) FileDone(string, int, error) {}
+func (nopReporter) Finish(*Result)              {}
+
+const barWidth = 30
+
+// ProgressBar redraws a single status line on w (usually stderr):
+//
+//	[#########.....................] 12/40 files | 340 chunks | ETA 3m12s
+type ProgressBar struct {
+	w      io.Writer
+	total  int
+	done   int
+	chunks int
+	start  time.Time
+}
+
+func NewProgressBar(w io.Writer) *ProgressBar {
+	return &ProgressBar{w: w}
+}
+
+func (p *ProgressBar) Start(total int) {
+	p.total = total
+	p.start = time.Now()
+	p.draw()
+}
+
+func (p *ProgressBar) FileDone(path string, chunks int, err error) {
+	p.done++
+	p.chunks += chunks
+	p.draw()
+}
+
+func (p *ProgressBar) Finish(res *Result) {
+	fmt.Fprintln(p.w)
+}
+
+func (p *ProgressBar) draw() {
+	filled := 0
+	if p.total > 0 {
+		filled = barWidth * p.done / p.total
+	}
+	bar := strings.Repeat("#", filled) + strings.Repeat(".", barWidth-filled)
+	fmt.Fprintf(p.w, "\r[%s] %d/%d files | %d chunks | ETA %s   ", bar, p.done, p.total, p.chunks, p.eta())
+}
+
+func (p *ProgressBar) eta() string {
+	if p.done == 0 {
+		return "--"
+	}
+	if p.done == p.total {
+		return "0s"
+	}
+	perFile := time.Since(p.start) / time.Duration(p.done)
+	return (perFile * time.Duration(p.total-p.done)).Round(time.Second).String()
+}
+
+// PrintSummary writes a human-readable summary of res to w.
+func PrintSummary(w io.Writer, res *Result) {
+	fmt.Fprintf(w, "Indexing complete in %s.\n", (time.Duration(res.DurationMS) * time.Millisecond).Round(time.Millisecond))
+	fmt.Fprintf(w, "  files:      %d\n  indexed:    %d (%d updated)\n  unchanged:  %d\n  duplicates: %d\n  skipped:    %d\n  failed:     %d\n  chunks:     %d\n",
+		res.Files, res.Indexed, res.Updated, res.Unchanged, res.Duplicates, res.Skipped, res.Failed, res.Chunks)
+	if res.Encrypted > 0 {
+		fmt.Fprintf(w, "  %d skipped as encrypted PDFs no password opens\n", res.Encrypted)
+	}
+	for _, s := range res.Skips {
+		fmt.Fprintf(w, "  skipped %s: %s\n", s.File, s.Reason)
+	}
+	if res.Removed > 0 {
+		fmt.Fprintf(w, "  removed %d files that no longer exist\n", res.Removed)
+	}
+	if res.Resumed > 0 {
+		fmt.Fprintf(w, "  resumed after %d files finished by an earlier run\n", res.Resumed)
+	}
+	for _, d := range res.DuplicateFiles {
+		fmt.Fprintf(w, "  dup     %s: %s\n", d.File, d.Reason)
+	}
+	for _, f := range res.Failures {
+		fmt.Fprintf(w, "  failed  %s: %s\n", f.File, f.Reason)
+	}
+	for _, f := range res.Warnings {
+		fmt.Fprintf(w, "  warning %s: %s\n", f.File, f.Reason)
+	}
+}
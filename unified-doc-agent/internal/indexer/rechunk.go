@@ -0,0 +1,96 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ingestion"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// Rechunk re-chunks and re-embeds every file whose extracted text was
+// stored and whose name starts with prefix, using the current chunker,
+// embedding and metadata settings. Nothing is extracted or OCR'd again.
+// Files indexed before texts were stored are reported as skipped; they
+// need `agent index` once. rep may be nil.
+func Rechunk(ctx context.Context, prefix string, rep Reporter) (*Result, error) {
+	if rep == nil {
+		rep = nopReporter{}
+	}
+	db := TenantOf(ctx).Store
+	names, err := db.StoredTexts(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("list stored texts: %w", err)
+	}
+	missing, err := db.FilesWithoutText(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("list files: %w", err)
+	}
+
+	start := time.Now()
+	res := &Result{Files: len(names) + len(missing)}
+	for _, name := range missing {
+		res.Skipped++
+		res.Skips = append(res.Skips, FileIssue{File: name, Reason: "no stored text; re-index it with `agent index` first"})
+	}
+	runID, err := StartRun(ctx, storage.RunRechunk, prefix, "")
+	if err != nil {
+		return nil, fmt.Errorf("record run: %w", err)
+	}
+	rep.Start(len(names))
+	err = rechunkFiles(ctx, names, rep, res)
+	res.DurationMS = time.Since(start).Milliseconds()
+	FinishRun(ctx, runID, res, err)
+	rep.Finish(res)
+	return res, err
+}
+
+// rechunkFiles re-chunks the named files into res.
+func rechunkFiles(ctx context.Context, names []string, rep Reporter, res *Result) error {
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			res.Interrupted = true
+			return err
+		}
+		fr, err := rechunkFile(ctx, name)
+		n := 0
+		if fr != nil {
+			n = fr.Chunks
+		}
+		res.Add(name, fr, err)
+		rep.FileDone(name, n, err)
+	}
+	if err := TenantOf(ctx).Store.RecountChunks(ingestion.ArchiveSep); err != nil {
+		return fmt.Errorf("recount chunks: %w", err)
+	}
+	return nil
+}
+
+// rechunkFile replaces the chunks of one file with ones made from its
+// stored text. Archive entries take their labels from the archive.
+func rechunkFile(ctx context.Context, name string) (*FileResult, error) {
+	db := TenantOf(ctx).Store
+	t, err := db.LoadText(name)
+	if err != nil {
+		return nil, err
+	}
+	ext := &ingestion.Extraction{Text: t.Text}
+	for _, p := range t.Pages {
+		ext.Pages = append(ext.Pages, ingestion.Page{Number: p.Number, Text: p.Text})
+	}
+	fr := &FileResult{Language: processing.DetectLanguage(t.Text), updated: true}
+	path, _, _ := strings.Cut(name, ingestion.ArchiveSep)
+	// ReplaceChunks keeps the modification time of the chunks replaced.
+	chunks, err := buildChunks(ctx, ext, name, t.Source, labelsFor(path, t.Source), time.Time{}, fr)
+	if err != nil {
+		return fr, err
+	}
+	if err := db.ReplaceChunks(name, chunks); err != nil {
+		return fr, fmt.Errorf("store: %w", err)
+	}
+	fr.Chunks = len(chunks)
+	return fr, nil
+}
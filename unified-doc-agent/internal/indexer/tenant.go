@@ -0,0 +1,49 @@
+package indexer
+
+import (
+	"context"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// Tenant is whom a run indexes for.
+type Tenant struct {
+	// ID names the tenant.
+	ID string
+	// Store is scoped to the tenant; see storage.PgStore.TenantStore.
+	// WithTenant sets it from ID when it is nil.
+	Store *storage.PgStore
+	// EmbedModel embeds every chunk instead of the model routed for its
+	// language when set.
+	EmbedModel string
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a context whose runs index into t rather than the
+// default store. Without a Store, t indexes into the default store's
+// connections scoped to its ID.
+func WithTenant(ctx context.Context, t Tenant) context.Context {
+	if t.Store == nil {
+		t.Store = storage.Default().TenantStore(t.ID)
+	}
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// TenantOf returns the tenant ctx indexes for: the one set by WithTenant,
+// or else the default store.
+func TenantOf(ctx context.Context) Tenant {
+	if t, ok := ctx.Value(tenantKey{}).(Tenant); ok {
+		return t
+	}
+	return Tenant{Store: storage.Default()}
+}
+
+// embedModel returns the model that embeds text in language.
+func (t Tenant) embedModel(language string) string {
+	if t.EmbedModel != "" {
+		return t.EmbedModel
+	}
+	return processing.ModelFor(language)
+}
@@ -0,0 +1,254 @@
+package ingestion
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveSep separates an archive's path from the path of a file inside
+// it, e.g. "reports.zip!/2023/q1.pdf".
+const ArchiveSep = "!/"
+
+// ArchiveOptions bounds how much an archive may expand to.
+type ArchiveOptions struct {
+	// MaxDepth is how many levels of archives-inside-archives are opened;
+	// the outermost archive is level 1.
+	MaxDepth int
+	// MaxTotalSize caps the uncompressed bytes extracted from one archive,
+	// nested archives included.
+	MaxTotalSize int64
+	// MaxFiles caps the number of entries extracted from one archive.
+	MaxFiles int
+}
+
+var archiveOptions = ArchiveOptions{
+	MaxDepth:     3,
+	MaxTotalSize: 1 << 30,
+	MaxFiles:     10000,
+}
+
+// SetArchiveOptions replaces the limits used by ExpandArchive.
+func SetArchiveOptions(o ArchiveOptions) {
+	archiveOptions = o
+}
+
+var archiveExts = []string{".tar.gz", ".tgz", ".tar", ".zip"}
+
+// fileExt is filepath.Ext, except that compound archive extensions such
+// as ".tar.gz" are returned whole.
+func fileExt(path string) string {
+	lower := strings.ToLower(path)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(lower, ext) {
+			return ext
+		}
+	}
+	return strings.ToLower(filepath.Ext(path))
+}
+
+// IsArchive reports whether path is a zip or tar archive by its name.
+func IsArchive(path string) bool {
+	ext := fileExt(path)
+	for _, a := range archiveExts {
+		if ext == a {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchiveEntry is an extracted file. Path is where it lives on disk, Name
+// is its archive-relative name to store and cite.
+type ArchiveEntry struct {
+	Path string
+	Name string
+}
+
+// Expansion is the result of ExpandArchive. Close removes the extracted files.
+type Expansion struct {
+	Dir      string
+	Entries  []ArchiveEntry
+	Warnings []string
+}
+
+// Close deletes the temporary extraction directory.
+func (e *Expansion) Close() error {
+	return os.RemoveAll(e.Dir)
+}
+
+// ExpandArchive extracts path into a temp dir, opening nested archives up
+// to the configured depth, and returns the supported files inside. name is
+// what the archive itself is called in entry names. Entries that would
+// escape the extraction dir (zip-slip) and archives that exceed the size
+// or file-count limits are rejected with an error.
+func ExpandArchive(path, name string) (*Expansion, error) {
+	dir, err := os.MkdirTemp("", "uda_archive_*")
+	if err != nil {
+		return nil, err
+	}
+	x := &expander{exp: &Expansion{Dir: dir}}
+	if err := x.expand(path, name, 1); err != nil {
+		x.exp.Close()
+		return nil, err
+	}
+	return x.exp, nil
+}
+
+type expander struct {
+	exp   *Expansion
+	files int
+	bytes int64
+}
+
+func (x *expander) expand(path, name string, depth int) error {
+	dest, err := os.MkdirTemp(x.exp.Dir, "a")
+	if err != nil {
+		return err
+	}
+	var files []string
+	switch fileExt(path) {
+	case ".zip":
+		files, err = x.extractZip(path, dest)
+	case ".tar":
+		files, err = x.extractTarFile(path, dest, false)
+	default:
+		files, err = x.extractTarFile(path, dest, true)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	for _, rel := range files {
+		p := filepath.Join(dest, filepath.FromSlash(rel))
+		entryName := name + ArchiveSep + rel
+		switch {
+		case IsArchive(p):
+			if depth >= archiveOptions.MaxDepth {
+				x.exp.Warnings = append(x.exp.Warnings, fmt.Sprintf("%s: nested deeper than %d archives, not opened", entryName, archiveOptions.MaxDepth))
+				continue
+			}
+			if err := x.expand(p, entryName, depth+1); err != nil {
+				return err
+			}
+		case isAllowed(p):
+			x.exp.Entries = append(x.exp.Entries, ArchiveEntry{Path: p, Name: entryName})
+		}
+	}
+	return nil
+}
+
+func (x *expander) extractZip(path, dest string) ([]string, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var files []string
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		rel, err := x.write(dest, f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, rel)
+	}
+	return files, nil
+}
+
+func (x *expander) extractTarFile(path, dest string, gzipped bool) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	var files []string
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Only regular files are extracted; links could point outside dest.
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		rel, err := x.write(dest, hdr.Name, tr)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, rel)
+	}
+}
+
+// write copies one entry under dest, enforcing the path and size limits.
+// It returns the cleaned slash-separated path relative to dest.
+func (x *expander) write(dest, entry string, r io.Reader) (string, error) {
+	name := strings.ReplaceAll(entry, `\`, "/")
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("entry %q escapes the archive", entry)
+		}
+	}
+	rel := path.Clean(strings.TrimLeft(name, "/"))
+	target := filepath.Join(dest, filepath.FromSlash(rel))
+	if within, err := filepath.Rel(dest, target); err != nil || within == "." || strings.HasPrefix(within, "..") {
+		return "", fmt.Errorf("entry %q escapes the archive", entry)
+	}
+
+	x.files++
+	if archiveOptions.MaxFiles > 0 && x.files > archiveOptions.MaxFiles {
+		return "", fmt.Errorf("more than %d files", archiveOptions.MaxFiles)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", err
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	if archiveOptions.MaxTotalSize > 0 {
+		r = io.LimitReader(r, archiveOptions.MaxTotalSize-x.bytes+1)
+	}
+	n, err := io.Copy(out, r)
+	x.bytes += n
+	if err != nil {
+		return "", err
+	}
+	if archiveOptions.MaxTotalSize > 0 && x.bytes > archiveOptions.MaxTotalSize {
+		return "", fmt.Errorf("expands to more than %d bytes", archiveOptions.MaxTotalSize)
+	}
+	return rel, nil
+}
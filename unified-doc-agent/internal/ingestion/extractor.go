@@ -7,27 +7,71 @@ import (
 	"strings"
 )
 
-// ExtractText detects file type and returns text via direct extraction or OCR.
-func ExtractText(path string) (string, error) {
+// Extraction is the text pulled from a file plus anything worth flagging
+// about its quality.
+type Extraction struct {
+	Text     string
+	Warnings []string
+	// Pages holds per-page text for paged documents whose text layer was
+	// extracted. It is empty for plain text, images and OCR'd scans.
+	Pages []Page
+}
+
+// Extract detects file type and returns text via direct extraction or OCR.
+func Extract(path string) (*Extraction, error) {
+	return ExtractWithPassword(path, "")
+}
+
+// ExtractWithPassword is Extract decrypting encrypted PDFs with password.
+// Encrypted PDFs it does not open fail with ErrEncrypted rather than
+// being OCR'd, which could not read them either.
+func ExtractWithPassword(path, password string) (*Extraction, error) {
 	ext := strings.ToLower(filepath.Ext(path))
 	switch ext {
 	case ".txt", ".md":
 		b, err := os.ReadFile(path)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		return string(b), nil
+		return &Extraction{Text: string(b)}, nil
 	case ".pdf":
 		// try text layer
-		text, err := ExtractTextFromPDF(path)
-		if err == nil && strings.TrimSpace(text) != "" {
-			return text, nil
+		pages, err := ExtractPDFPages(path, password)
+		if errors.Is(err, ErrEncrypted) {
+			return nil, err
+		}
+		if err == nil && len(pages) > 0 {
+			return &Extraction{Text: joinPages(pages), Pages: pages}, nil
 		}
 		//fallback to OCR
-		return ExtractTextWithOCR(path)
+		return extractOCR(path, password)
 	case ".png", ".jpg", ".jpeg":
-		return ExtractTextWithOCR(path)
+		return extractImage(path)
 	default:
-		return "", errors.New("unsupported file type")
+		return nil, errors.New("unsupported file type")
+	}
+}
+
+// ExtractText is Extract without the warnings.
+func ExtractText(path string) (string, error) {
+	e, err := Extract(path)
+	if err != nil {
+		return "", err
+	}
+	return e.Text, nil
+}
+
+func extractOCR(path, password string) (*Extraction, error) {
+	var text string
+	var warnings []string
+	var err error
+	if strings.ToLower(filepath.Ext(path)) == ".pdf" {
+		text, warnings, err = ocrPDF(path, password)
+	} else {
+		text, warnings, err = ExtractTextWithOCR(path)
+	}
+	if err != nil {
+		return nil, err
 	}
+	return &Extraction{Text: text, Warnings: warnings}, nil
 }
@@ -0,0 +1,131 @@
+package ingestion
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFileName is read from every walked directory. It uses gitignore
+// syntax: globs with * ? ** and [..], a leading ! to re-include, a trailing
+// / for directories only, and a leading or inner / to anchor the pattern to
+// the directory containing the ignore file.
+const IgnoreFileName = ".udaignore"
+
+type ignoreRule struct {
+	pattern string
+	base    string // slash-separated dir the rule is relative to, "" for root
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules is an ordered rule list; the last matching rule wins.
+type ignoreRules []ignoreRule
+
+// parseIgnoreLines compiles gitignore-style lines relative to base.
+func parseIgnoreLines(lines []string, base string) ignoreRules {
+	var rules ignoreRules
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := ignoreRule{pattern: line, base: base}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		expr := globToRegexp(line)
+		if anchored {
+			expr = "^" + expr + "$"
+		} else {
+			expr = "(^|/)" + expr + "$"
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			continue
+		}
+		r.re = re
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// readIgnoreFile loads dir/.udaignore; base is dir relative to the walk root.
+func readIgnoreFile(dir, base string) ignoreRules {
+	f, err := os.Open(filepath.Join(dir, IgnoreFileName))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return parseIgnoreLines(lines, base)
+}
+
+// match reports whether rel (slash-separated, relative to the walk root)
+// is ignored, and the pattern that decided it.
+func (rules ignoreRules) match(rel string, isDir bool) (bool, string) {
+	ignored, by := false, ""
+	for _, r := range rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if r.base != "" {
+			if !strings.HasPrefix(rel, r.base+"/") {
+				continue
+			}
+			sub = strings.TrimPrefix(rel, r.base+"/")
+		}
+		if r.re.MatchString(sub) {
+			ignored, by = !r.negate, r.pattern
+		}
+	}
+	return ignored, by
+}
+
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			if j := strings.IndexByte(glob[i:], ']'); j > 0 {
+				class := glob[i+1 : i+j]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				b.WriteString("[" + class + "]")
+				i += j
+			} else {
+				b.WriteString(`\[`)
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
@@ -1,30 +1,166 @@
 package ingestion
 
 import (
-	"io/fs"
+	"fmt"
+	"os"
 	"path/filepath"
-	"strings"
 )
 
-var allowedExt = []string{".pdf", ".txt", ".md", ".png", ".jpg", ".jpeg"}
+var allowedExt = []string{".pdf", ".txt", ".md", ".png", ".jpg", ".jpeg", ".zip", ".tar", ".tar.gz", ".tgz"}
 
-func LoadLocalFiles(root string) ([]string, error) {
-	var out []string
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+// SetAllowedExtensions replaces the list of extensions LoadLocalFiles picks up.
+func SetAllowedExtensions(exts []string) {
+	allowedExt = exts
+}
+
+// WalkOptions controls which files ScanLocalFiles considers.
+type WalkOptions struct {
+	// IgnorePatterns are gitignore-style patterns applied before any
+	// .udaignore file found in the tree.
+	IgnorePatterns []string
+	// MaxFileSize skips files larger than this many bytes; zero means no limit.
+	MaxFileSize int64
+	// FollowSymlinks descends into symlinked directories and indexes
+	// symlinked files. When false, symlinks are reported as skipped.
+	FollowSymlinks bool
+}
+
+var walkOptions = WalkOptions{
+	IgnorePatterns: []string{".git/", "node_modules/"},
+}
+
+// SetWalkOptions replaces the options used by ScanLocalFiles and LoadLocalFiles.
+func SetWalkOptions(o WalkOptions) {
+	walkOptions = o
+}
+
+// Candidate is a file found while walking a folder. SkipReason is empty
+// for files that should be indexed.
+type Candidate struct {
+	Path       string
+	Size       int64
+	SkipReason string
+}
+
+// ScanLocalFiles walks root and returns every regular file, marking the
+// ones that will not be indexed with the reason why. Ignored directories
+// are reported once and not descended into.
+func ScanLocalFiles(root string) ([]Candidate, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []Candidate{classify(root, info)}, nil
+	}
+
+	w := &walker{
+		root:    root,
+		visited: map[string]bool{},
+	}
+	rules := parseIgnoreLines(walkOptions.IgnorePatterns, "")
+	if err := w.walk(root, "", rules); err != nil {
+		return nil, err
+	}
+	return w.out, nil
+}
+
+type walker struct {
+	root    string
+	out     []Candidate
+	visited map[string]bool // real paths of directories, to stop symlink cycles
+}
+
+func (w *walker) walk(dir, rel string, rules ignoreRules) error {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		if w.visited[real] {
+			return nil
+		}
+		w.visited[real] = true
+	}
+
+	rules = append(rules[:len(rules):len(rules)], readIgnoreFile(dir, rel)...)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == IgnoreFileName {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		childRel := e.Name()
+		if rel != "" {
+			childRel = rel + "/" + e.Name()
+		}
+
+		isLink := e.Type()&os.ModeSymlink != 0
+		info, err := os.Stat(path) // follows symlinks
 		if err != nil {
-			return err
+			w.out = append(w.out, Candidate{Path: path, SkipReason: fmt.Sprintf("unreadable: %v", err)})
+			continue
 		}
-		if d.IsDir() {
-			return nil
+
+		if ignored, by := rules.match(childRel, info.IsDir()); ignored {
+			w.out = append(w.out, Candidate{Path: path, SkipReason: fmt.Sprintf("ignored by pattern %q", by)})
+			continue
 		}
-		ext := strings.ToLower(filepath.Ext(path))
-		for _, a := range allowedExt {
-			if ext == a {
-				out = append(out, path)
-				break
+		if isLink && !walkOptions.FollowSymlinks {
+			w.out = append(w.out, Candidate{Path: path, SkipReason: "symlink (follow_symlinks is off)"})
+			continue
+		}
+
+		if info.IsDir() {
+			if err := w.walk(path, childRel, rules); err != nil {
+				return err
 			}
+			continue
+		}
+		if info.Mode().IsRegular() {
+			w.out = append(w.out, classify(path, info))
+		}
+	}
+	return nil
+}
+
+func classify(path string, info os.FileInfo) Candidate {
+	return Candidate{Path: path, Size: info.Size(), SkipReason: SkipReason(path, info.Size())}
+}
+
+// SkipReason returns why a file with this name and size would not be
+// indexed, or "" if it would be. Remote sources use it before downloading.
+func SkipReason(name string, size int64) string {
+	switch {
+	case !isAllowed(name):
+		return "unsupported file type"
+	case walkOptions.MaxFileSize > 0 && size > walkOptions.MaxFileSize:
+		return fmt.Sprintf("too large (%d bytes > %d byte limit)", size, walkOptions.MaxFileSize)
+	}
+	return ""
+}
+
+// LoadLocalFiles returns the files under root that should be indexed.
+func LoadLocalFiles(root string) ([]string, error) {
+	candidates, err := ScanLocalFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, c := range candidates {
+		if c.SkipReason == "" {
+			out = append(out, c.Path)
+		}
+	}
+	return out, nil
+}
+
+func isAllowed(path string) bool {
+	ext := fileExt(path)
+	for _, a := range allowedExt {
+		if ext == a {
+			return true
 		}
-		return nil
-	})
-	return out, err
+	}
+	return false
 }
@@ -1,56 +1,235 @@
 package ingestion
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/otiai10/gosseract/v2"
 )
 
+// OCROptions tunes tesseract and the PDF rasterizer.
+type OCROptions struct {
+	// Languages are tesseract language codes, e.g. "eng", "deu". The
+	// matching traineddata packs must be installed.
+	Languages []string
+	// DPI is the resolution pdftoppm renders scanned PDF pages at.
+	DPI int
+	// AutoRotate lets tesseract detect page orientation (OSD) before recognition.
+	AutoRotate bool
+	// Deskew straightens images with ImageMagick before OCR, if it is installed.
+	Deskew bool
+	// MinConfidence flags pages whose mean word confidence (0-100) is below
+	// it. Zero disables the check.
+	MinConfidence float64
+	// Workers is how many PDF pages are OCR'd concurrently; zero means one per CPU.
+	Workers int
+	// MaxPages caps how many pages of a scanned PDF are OCR'd; zero means all.
+	MaxPages int
+	// PoolSize is how many tesseract clients are kept alive and reused
+	// across pages and files; zero means the same as Workers.
+	PoolSize int
+}
+
+var ocrOptions = OCROptions{
+	Languages: []string{"eng"},
+	DPI:       300,
+}
+
+// SetOCROptions replaces the options used for OCR. Pooled clients were
+// configured with the old options, so the pool is drained and recreated.
+func SetOCROptions(o OCROptions) {
+	CloseOCR()
+	ocrOptions = o
+}
+
 // ExtractTextWithOCR runs OCR on images or scanned PDFs.
 // For PDFs we convert pages to PNGs using pdftoppm (poppler).
-func ExtractTextWithOCR(path string) (string, error) {
+// Pages with low recognition confidence are returned as warnings.
+func ExtractTextWithOCR(path string) (string, []string, error) {
 	ext := strings.ToLower(filepath.Ext(path))
 	if ext == ".pdf" {
-		// require pdftoppm installed
-		tmpPrefix := filepath.Join(os.TempDir(), "uda_pdfimg")
-		// pdftoppm -png input.pdf outprefix
-		cmd := exec.Command("pdftoppm", "-png", path, tmpPrefix)
-		if err := cmd.Run(); err != nil {
-			return "", fmt.Errorf("pdftoppm convert failed: %w", err)
+		return ocrPDF(path, "")
+	}
+	// image file
+	text, conf, err := runTesseract(path)
+	if err != nil {
+		return "", nil, err
+	}
+	var warnings []string
+	if lowConfidence(conf) {
+		warnings = append(warnings, fmt.Sprintf("low OCR confidence %.0f%%", conf))
+	}
+	return text, warnings, nil
+}
+
+// ocrPDF rasterizes the PDF into a private temp dir, OCRs the pages with a
+// bounded worker pool, and removes the images afterwards. Encrypted PDFs
+// are decrypted with password.
+func ocrPDF(path, password string) (string, []string, error) {
+	dir, err := os.MkdirTemp("", "uda_pdfimg_*")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	rasterize := rasterizePoppler
+	if useGoRasterizer() {
+		rasterize = rasterizeGo
+	}
+	pages, err := rasterize(path, dir, password)
+	if err != nil {
+		return "", nil, err
+	}
+
+	texts := make([]string, len(pages))
+	confs := make([]float64, len(pages))
+	errs := make([]error, len(pages))
+
+	workers := ocrOptions.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(pages); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				texts[i], confs[i], errs[i] = runTesseract(pages[i])
+			}
+		}()
+	}
+	for i := range pages {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var combined strings.Builder
+	var warnings []string
+	for i := range pages {
+		if errs[i] != nil {
+			warnings = append(warnings, fmt.Sprintf("page %d: OCR failed: %v", i+1, errs[i]))
+			continue
 		}
-		pattern := tmpPrefix + "-*.png"
-		matches, err := filepath.Glob(pattern)
-		if err != nil {
-			return "", err
+		if lowConfidence(confs[i]) {
+			warnings = append(warnings, fmt.Sprintf("page %d: low OCR confidence %.0f%%", i+1, confs[i]))
 		}
-		var combined strings.Builder
-		for _, m := range matches {
-			t, err := runTesseract(m)
-			if err != nil {
-				continue
-			}
-			combined.WriteString(t)
-			combined.WriteString("\n")
+		combined.WriteString(texts[i])
+		combined.WriteString("\n")
+	}
+	if ocrOptions.MaxPages > 0 {
+		if total := pdfPageCount(path, password); total > ocrOptions.MaxPages {
+			warnings = append(warnings, fmt.Sprintf("only the first %d of %d pages were OCR'd (ocr_max_pages)", ocrOptions.MaxPages, total))
 		}
-		return strings.TrimSpace(combined.String()), nil
 	}
-	// image file
-	return runTesseract(path)
+	return strings.TrimSpace(combined.String()), warnings, nil
 }
 
-func runTesseract(imgPath string) (string, error) {
-	client := gosseract.NewClient()
-	defer client.Close()
+// rasterizePoppler renders the pages of the PDF to PNGs in dir with
+// pdftoppm and returns them in page order.
+func rasterizePoppler(path, dir, password string) ([]string, error) {
+	bin, err := toolPath(ToolPdftoppm)
+	if err != nil {
+		return nil, err
+	}
+	// pdftoppm -png -r <dpi> [-l <last page>] input.pdf outprefix
+	args := append(popplerPasswordArgs(password), "-png", "-r", strconv.Itoa(ocrOptions.DPI))
+	if ocrOptions.MaxPages > 0 {
+		args = append(args, "-l", strconv.Itoa(ocrOptions.MaxPages))
+	}
+	args = append(args, path, filepath.Join(dir, "page"))
+	if _, err := exec.Command(bin, args...).Output(); err != nil {
+		if err = popplerError(err); errors.Is(err, ErrEncrypted) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("pdftoppm convert failed: %w", err)
+	}
+	// pdftoppm zero-pads page numbers to a common width, so lexical order is page order.
+	pages, err := filepath.Glob(filepath.Join(dir, "page-*.png"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(pages)
+	return pages, nil
+}
+
+func lowConfidence(conf float64) bool {
+	return ocrOptions.MinConfidence > 0 && conf >= 0 && conf < ocrOptions.MinConfidence
+}
+
+// runTesseract returns the recognized text and, when a confidence threshold
+// is configured, the mean word confidence (otherwise -1).
+func runTesseract(imgPath string) (string, float64, error) {
+	if ocrOptions.Deskew {
+		if straight, err := deskew(imgPath); err == nil {
+			defer os.Remove(straight)
+			imgPath = straight
+		}
+	}
+
+	p := ocrPool()
+	client, err := p.get()
+	if err != nil {
+		return "", -1, err
+	}
+	text, conf, err := recognize(client, imgPath)
+	p.put(client, err != nil)
+	return text, conf, err
+}
+
+func recognize(client *gosseract.Client, imgPath string) (string, float64, error) {
 	if err := client.SetImage(imgPath); err != nil {
-		return "", err
+		return "", -1, err
 	}
 	text, err := client.Text()
 	if err != nil {
+		return "", -1, err
+	}
+
+	conf := -1.0
+	if ocrOptions.MinConfidence > 0 {
+		if boxes, err := client.GetBoundingBoxes(gosseract.RIL_WORD); err == nil && len(boxes) > 0 {
+			var sum float64
+			for _, b := range boxes {
+				sum += b.Confidence
+			}
+			conf = sum / float64(len(boxes))
+		}
+	}
+	return strings.TrimSpace(text), conf, nil
+}
+
+var warnNoMagick sync.Once
+
+// deskew writes a straightened copy of imgPath using ImageMagick and
+// returns its path. The caller removes it.
+func deskew(imgPath string) (string, error) {
+	bin, err := toolPath(ToolMagick)
+	if err != nil {
+		warnNoMagick.Do(func() {
+			log.Printf("ocr: deskew requested but %v; skipping", err)
+		})
 		return "", err
 	}
-	return strings.TrimSpace(text), nil
+	out, err := os.CreateTemp("", "uda_deskew_*.png")
+	if err != nil {
+		return "", err
+	}
+	out.Close()
+	if err := exec.Command(bin, imgPath, "-deskew", "40%", out.Name()).Run(); err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("deskew: %w", err)
+	}
+	return out.Name(), nil
 }
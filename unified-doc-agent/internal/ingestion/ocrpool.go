@@ -0,0 +1,107 @@
+package ingestion
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/otiai10/gosseract/v2"
+)
+
+// clientPool hands out at most size tesseract clients. Each slot in the
+// channel holds either an idle client or nil (not created yet), so creating
+// tesseract's engine only happens once per slot instead of once per image.
+type clientPool struct {
+	slots chan *gosseract.Client
+	size  int
+}
+
+func newClientPool(size int) *clientPool {
+	p := &clientPool{slots: make(chan *gosseract.Client, size), size: size}
+	for i := 0; i < size; i++ {
+		p.slots <- nil
+	}
+	return p
+}
+
+// get blocks until a slot is free and returns its client, creating and
+// configuring it on first use.
+func (p *clientPool) get() (*gosseract.Client, error) {
+	c := <-p.slots
+	if c != nil {
+		return c, nil
+	}
+	c = gosseract.NewClient()
+	if err := configureClient(c); err != nil {
+		c.Close()
+		p.slots <- nil
+		return nil, err
+	}
+	return c, nil
+}
+
+// put returns a client to the pool. Clients that hit an error are closed
+// and replaced lazily, in case tesseract is left in a bad state.
+func (p *clientPool) put(c *gosseract.Client, broken bool) {
+	if broken {
+		c.Close()
+		c = nil
+	}
+	p.slots <- c
+}
+
+// close waits for every client to be returned and closes them.
+func (p *clientPool) close() {
+	for i := 0; i < p.size; i++ {
+		if c := <-p.slots; c != nil {
+			c.Close()
+		}
+	}
+}
+
+func configureClient(c *gosseract.Client) error {
+	if len(ocrOptions.Languages) > 0 {
+		if err := c.SetLanguage(ocrOptions.Languages...); err != nil {
+			return err
+		}
+	}
+	if ocrOptions.AutoRotate {
+		if err := c.SetPageSegMode(gosseract.PSM_AUTO_OSD); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	poolMu sync.Mutex
+	pool   *clientPool
+)
+
+// ocrPool returns the shared pool, creating it with the configured size.
+func ocrPool() *clientPool {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	if pool == nil {
+		size := ocrOptions.PoolSize
+		if size < 1 {
+			size = ocrOptions.Workers
+		}
+		if size < 1 {
+			size = runtime.NumCPU()
+		}
+		pool = newClientPool(size)
+	}
+	return pool
+}
+
+// CloseOCR shuts down the tesseract client pool, waiting for in-flight
+// pages to finish. Call it once before the process exits.
+func CloseOCR() {
+	poolMu.Lock()
+	p := pool
+	pool = nil
+	poolMu.Unlock()
+	if p != nil {
+		p.close()
+	}
+}
@@ -1,39 +1,202 @@
 package ingestion
 
 import (
-	"bytes"
-	"io"
+	"errors"
+	"fmt"
+	"os"
 	"os/exec"
 	"strings"
+	"unicode"
 
 	pdf "github.com/ledongthuc/pdf"
 )
 
+// Page is the text of one page of a paged document. Number is 1-based.
+type Page struct {
+	Number int
+	Text   string
+}
+
+// ErrEncrypted is returned for encrypted PDFs that cannot be opened
+// without a password, or with the one given.
+var ErrEncrypted = errors.New("encrypted PDF")
+
+// pdfPageCount returns the number of pages in the PDF, or 0 if it cannot be read.
+func pdfPageCount(path, password string) int {
+	f, r, err := openPDF(path, password)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	return r.NumPage()
+}
+
+// openPDF opens the PDF with the pure-Go parser, decrypting it with
+// password if it is encrypted and the empty password does not open it.
+func openPDF(path, password string) (*os.File, *pdf.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	tried := false
+	r, err := pdf.NewReaderEncrypted(f, fi.Size(), func() string {
+		if tried {
+			return ""
+		}
+		tried = true
+		return password
+	})
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, r, nil
+}
+
+// ExtractPDFPages extracts the text layer page by page. For each page the
+// richer of the Go parser's text and `pdftotext -layout` is kept; layout
+// text is preferred when comparable because it keeps column alignment,
+// which lets simple tables be flattened into row-wise text. Pages without
+// text are omitted. Encrypted PDFs are decrypted with password; those it
+// does not open fail with ErrEncrypted.
+func ExtractPDFPages(path, password string) ([]Page, error) {
+	plain, plainErr := pdfPagesPlain(path, password)
+	layout, layoutErr := pdfPagesLayout(path, password)
+	if plainErr != nil && layoutErr != nil {
+		if errors.Is(plainErr, ErrEncrypted) || errors.Is(layoutErr, ErrEncrypted) {
+			return nil, encryptedError(plainErr, layoutErr)
+		}
+		return nil, plainErr
+	}
+
+	n := len(plain)
+	if len(layout) > n {
+		n = len(layout)
+	}
+	var pages []Page
+	for i := 0; i < n; i++ {
+		var p, l string
+		if i < len(plain) {
+			p = plain[i]
+		}
+		if i < len(layout) {
+			l = layout[i]
+		}
+		text := p
+		if l != "" && nonSpace(l)*10 >= nonSpace(p)*9 {
+			text = flattenTables(l)
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			pages = append(pages, Page{Number: i + 1, Text: text})
+		}
+	}
+	return pages, nil
+}
+
 // ExtractTextFromPDF tries to extract text; returns empty string if none found.
-func ExtractTextFromPDF(path string) (string, error) {
-	f, r, err := pdf.Open(path)
+func ExtractTextFromPDF(path, password string) (string, error) {
+	pages, err := ExtractPDFPages(path, password)
 	if err != nil {
-		// fallback to pdftotext later
 		return "", err
 	}
+	return joinPages(pages), nil
+}
+
+func joinPages(pages []Page) string {
+	texts := make([]string, len(pages))
+	for i, p := range pages {
+		texts[i] = p.Text
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// encryptedError explains why an encrypted PDF could not be read, from
+// the errors of the Go parser and of pdftotext.
+func encryptedError(plainErr, layoutErr error) error {
+	if errors.Is(plainErr, pdf.ErrInvalidPassword) || errors.Is(layoutErr, ErrEncrypted) {
+		return fmt.Errorf("%w: wrong or missing password", ErrEncrypted)
+	}
+	// The Go parser cannot decrypt this kind of encryption, and pdftotext
+	// failed for another reason, most likely because it is not installed.
+	return fmt.Errorf("%w: %v (install poppler to read it)", ErrEncrypted, strings.TrimPrefix(plainErr.Error(), "unsupported PDF: "))
+}
+
+// pdfPagesPlain reads each page with the pure-Go parser.
+func pdfPagesPlain(path, password string) ([]string, error) {
+	f, r, err := openPDF(path, password)
+	if err != nil {
+		if err == pdf.ErrInvalidPassword || strings.HasPrefix(err.Error(), "unsupported PDF: encryption") {
+			return nil, fmt.Errorf("%w: %w", ErrEncrypted, err)
+		}
+		return nil, err
+	}
 	defer f.Close()
 
-	var buf bytes.Buffer
-	b, err := r.GetPlainText()
+	out := make([]string, r.NumPage())
+	for i := range out {
+		p := r.Page(i + 1)
+		if p.V.IsNull() {
+			continue
+		}
+		text, err := p.GetPlainText(nil)
+		if err != nil {
+			continue
+		}
+		out[i] = text
+	}
+	return out, nil
+}
+
+// pdfPagesLayout runs pdftotext -layout, which separates pages with form feeds.
+func pdfPagesLayout(path, password string) ([]string, error) {
+	bin, err := toolPath(ToolPdftotext)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	_, err = io.Copy(&buf, b)
+	args := append(popplerPasswordArgs(password), "-layout", path, "-")
+	out, err := exec.Command(bin, args...).Output()
 	if err != nil {
-		return "", err
+		return nil, popplerError(err)
 	}
-	text := strings.TrimSpace(buf.String())
-	if text == "" {
-		// try pdftotext CLI if available
-		out, err := exec.Command("pdftotext", "-layout", path, "-").Output()
-		if err == nil {
-			return string(out), nil
+	pages := strings.Split(string(out), "\f")
+	// pdftotext ends the last page with a form feed too.
+	if len(pages) > 0 && strings.TrimSpace(pages[len(pages)-1]) == "" {
+		pages = pages[:len(pages)-1]
+	}
+	return pages, nil
+}
+
+func nonSpace(s string) int {
+	n := 0
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			n++
 		}
 	}
-	return text, nil
+	return n
+}
+
+// popplerPasswordArgs returns the arguments giving password to a poppler
+// program, as both the owner and the user password since either opens the
+// file.
+func popplerPasswordArgs(password string) []string {
+	if password == "" {
+		return nil
+	}
+	return []string{"-opw", password, "-upw", password}
+}
+
+// popplerError returns ErrEncrypted for a poppler program that failed
+// because the PDF is encrypted and the password is wrong or missing.
+func popplerError(err error) error {
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && strings.Contains(string(exit.Stderr), "Incorrect password") {
+		return fmt.Errorf("%w: wrong or missing password", ErrEncrypted)
+	}
+	return err
 }
@@ -0,0 +1,183 @@
+package ingestion
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// errNoPageImages is returned for PDFs without images the Go rasterizer
+// can decode.
+var errNoPageImages = errors.New("no decodable page images in PDF (install poppler for vector or JBIG2 pages)")
+
+var (
+	streamStart = regexp.MustCompile(`stream\r?\n`)
+	widthKey    = intKey("Width")
+	heightKey   = intKey("Height")
+	bpcKey      = intKey("BitsPerComponent")
+	imageType   = regexp.MustCompile(`/Subtype\s*/Image\b`)
+	filterKey   = regexp.MustCompile(`/Filter\s*\[?\s*/(\w+)\s*\]?`)
+	colorKey    = regexp.MustCompile(`/ColorSpace\s*/(\w+)`)
+	encryptKey  = regexp.MustCompile(`/Encrypt\s+\d+\s+\d+\s+R`)
+)
+
+// intKey matches an integer dictionary entry, which may be an indirect
+// reference ("12 0 R").
+func intKey(key string) *regexp.Regexp {
+	return regexp.MustCompile(`/` + key + `\s+(\d+)(\s+\d+\s+R)?`)
+}
+
+// minPageImage is the smallest width and height, in pixels, of an image
+// taken for a page; smaller ones are logos and icons.
+const minPageImage = 300
+
+// rasterizeGo writes the page-sized images embedded in the PDF at path to
+// dir as page-NNNN.jpg or .png, in file order, which for scanners' output
+// is page order. At most ocr_max_pages are written when it is set. Only
+// JPEG images and 8-bit gray or RGB images, raw or Flate compressed, are
+// understood; others are skipped. Encrypted PDFs are not: their streams
+// are only decrypted by poppler.
+func rasterizeGo(path, dir, password string) ([]string, error) {
+	maxPages := ocrOptions.MaxPages
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if encryptKey.Match(data) {
+		return nil, errors.New("the built-in rasterizer cannot read encrypted PDFs (install poppler)")
+	}
+	var pages []string
+	pos := 0
+	for maxPages <= 0 || len(pages) < maxPages {
+		loc := streamStart.FindIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		loc[0], loc[1] = loc[0]+pos, loc[1]+pos
+		if bytes.HasSuffix(data[:loc[0]], []byte("end")) {
+			pos = loc[1]
+			continue
+		}
+		dict := data[pos:loc[0]]
+		end := bytes.Index(data[loc[1]:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		body := bytes.TrimRight(data[loc[1]:loc[1]+end], "\r\n")
+		pos = loc[1] + end + len("endstream")
+		// Only the dictionary of this object, not earlier ones.
+		if i := bytes.LastIndex(dict, []byte(" obj")); i >= 0 {
+			dict = dict[i:]
+		}
+		if !imageType.Match(dict) {
+			continue
+		}
+		w, h := dictValue(dict, widthKey), dictValue(dict, heightKey)
+		if w < minPageImage || h < minPageImage {
+			continue
+		}
+		name := filepath.Join(dir, fmt.Sprintf("page-%04d", len(pages)+1))
+		switch filter := submatch(dict, filterKey); filter {
+		case "DCTDecode":
+			name += ".jpg"
+			err = os.WriteFile(name, body, 0o600)
+		case "FlateDecode", "":
+			name += ".png"
+			err = writeRawImage(name, body, filter == "FlateDecode", w, h, dictValue(dict, bpcKey), submatch(dict, colorKey))
+		default:
+			continue
+		}
+		if errors.Is(err, errUnsupportedImage) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, name)
+	}
+	if len(pages) == 0 {
+		return nil, errNoPageImages
+	}
+	return pages, nil
+}
+
+var errUnsupportedImage = errors.New("unsupported image format")
+
+// maxImagePixels caps the size of a raw page image, whose samples and RGBA
+// copy are held in memory: 64 megapixels is an A3 page scanned at 600 DPI,
+// taking 256 MiB as RGBA.
+const maxImagePixels = 64 << 20
+
+// writeRawImage encodes raw 8-bit gray or RGB samples as a PNG. Images of
+// more than maxImagePixels are refused, whatever their stream holds, as
+// the size comes from the PDF.
+func writeRawImage(name string, body []byte, flate bool, w, h, bpc int, colorSpace string) error {
+	channels := map[string]int{"DeviceGray": 1, "DeviceRGB": 3}[colorSpace]
+	if bpc != 8 || channels == 0 {
+		return errUnsupportedImage
+	}
+	if int64(w)*int64(h) > maxImagePixels {
+		return fmt.Errorf("page image of %dx%d pixels is larger than the %d megapixels the built-in rasterizer takes (install poppler)", w, h, maxImagePixels>>20)
+	}
+	var r io.Reader = bytes.NewReader(body)
+	if flate {
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return errUnsupportedImage
+		}
+		defer zr.Close()
+		r = zr
+	}
+	samples := make([]byte, w*h*channels)
+	if _, err := io.ReadFull(r, samples); err != nil {
+		// Predictors and truncated streams are not handled.
+		return errUnsupportedImage
+	}
+
+	var img image.Image
+	if channels == 1 {
+		img = &image.Gray{Pix: samples, Stride: w, Rect: image.Rect(0, 0, w, h)}
+	} else {
+		rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+		for i := 0; i < w*h; i++ {
+			copy(rgba.Pix[i*4:], samples[i*3:i*3+3])
+			rgba.Pix[i*4+3] = 0xff
+		}
+		img = rgba
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// dictValue returns the integer value of a key matched by re, or 0. An
+// indirect reference counts as unknown.
+func dictValue(dict []byte, re *regexp.Regexp) int {
+	m := re.FindSubmatch(dict)
+	if m == nil || len(m[2]) > 0 {
+		return 0
+	}
+	n, _ := strconv.Atoi(string(m[1]))
+	return n
+}
+
+func submatch(dict []byte, re *regexp.Regexp) string {
+	if m := re.FindSubmatch(dict); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
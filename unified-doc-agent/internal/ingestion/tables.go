@@ -0,0 +1,55 @@
+package ingestion
+
+import (
+	"regexp"
+	"strings"
+)
+
+// columnGap separates cells in layout-preserved text.
+var columnGap = regexp.MustCompile(`\s{2,}`)
+
+// minTableRows is the header plus at least two data rows.
+const minTableRows = 3
+
+// flattenTables finds runs of lines that split into the same number (>= 2)
+// of columns on wide gaps and rewrites each data row as
+// "Header: value; Header: value", so that the values stay next to their
+// column names once the text is chunked and embedded.
+func flattenTables(text string) string {
+	lines := strings.Split(text, "\n")
+	var out []string
+	for i := 0; i < len(lines); {
+		cols := splitColumns(lines[i])
+		j := i + 1
+		if len(cols) >= 2 {
+			for j < len(lines) && len(splitColumns(lines[j])) == len(cols) {
+				j++
+			}
+		}
+		if len(cols) < 2 || j-i < minTableRows {
+			out = append(out, lines[i])
+			i++
+			continue
+		}
+
+		header := cols
+		for _, line := range lines[i+1 : j] {
+			cells := splitColumns(line)
+			parts := make([]string, len(cells))
+			for k, c := range cells {
+				parts[k] = header[k] + ": " + c
+			}
+			out = append(out, strings.Join(parts, "; "))
+		}
+		i = j
+	}
+	return strings.Join(out, "\n")
+}
+
+func splitColumns(line string) []string {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+	return columnGap.Split(line, -1)
+}
@@ -0,0 +1,127 @@
+package ingestion
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// External programs used by ingestion.
+const (
+	ToolPdftoppm  = "pdftoppm"
+	ToolPdftotext = "pdftotext"
+	ToolMagick    = "magick"
+)
+
+// PDF rasterizers for OCR of scanned PDFs.
+const (
+	// RasterizerAuto uses poppler when pdftoppm is found and the pure-Go
+	// fallback otherwise.
+	RasterizerAuto = "auto"
+	// RasterizerPoppler always uses pdftoppm.
+	RasterizerPoppler = "poppler"
+	// RasterizerGo extracts the page images embedded in the PDF without
+	// any external program. It handles typical scans (one JPEG or 8-bit
+	// Flate image per page) but not vector pages.
+	RasterizerGo = "go"
+)
+
+// ToolOptions locates the external programs. An empty path means looking
+// the program up on PATH.
+type ToolOptions struct {
+	Pdftoppm   string
+	Pdftotext  string
+	Magick     string
+	Rasterizer string
+}
+
+var toolOptions = ToolOptions{Rasterizer: RasterizerAuto}
+
+// SetToolOptions replaces the program paths and rasterizer choice.
+func SetToolOptions(o ToolOptions) {
+	if o.Rasterizer == "" {
+		o.Rasterizer = RasterizerAuto
+	}
+	toolOptions = o
+}
+
+// toolPath returns the program to run for tool: the configured path if
+// there is one, otherwise the first match on PATH. ImageMagick 6 installs
+// convert instead of magick. The error says how to install the tool.
+func toolPath(tool string) (string, error) {
+	configured := map[string]string{
+		ToolPdftoppm:  toolOptions.Pdftoppm,
+		ToolPdftotext: toolOptions.Pdftotext,
+		ToolMagick:    toolOptions.Magick,
+	}[tool]
+	if configured != "" {
+		if _, err := os.Stat(configured); err != nil {
+			return "", fmt.Errorf("%s: configured path %s: %w", tool, configured, err)
+		}
+		return configured, nil
+	}
+	if p, err := exec.LookPath(tool); err == nil {
+		return p, nil
+	}
+	if tool == ToolMagick {
+		if p, err := exec.LookPath("convert"); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found on PATH; %s, or set %s_path in the config", tool, installHint(tool), tool)
+}
+
+// installHint tells the user how to get tool on this OS.
+func installHint(tool string) string {
+	pkg := "poppler"
+	if tool == ToolMagick {
+		pkg = "imagemagick"
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return "install it with `brew install " + pkg + "`"
+	case "windows":
+		return "install it with `choco install " + pkg + "` (or `scoop install " + pkg + "`)"
+	default:
+		if pkg == "poppler" {
+			pkg = "poppler-utils"
+		}
+		return "install the " + pkg + " package (e.g. `apt install " + pkg + "`)"
+	}
+}
+
+// useGoRasterizer reports whether scanned PDFs are rasterized in Go.
+func useGoRasterizer() bool {
+	switch toolOptions.Rasterizer {
+	case RasterizerGo:
+		return true
+	case RasterizerPoppler:
+		return false
+	}
+	_, err := toolPath(ToolPdftoppm)
+	return err != nil
+}
+
+// CheckTools reports the external programs that are missing and what
+// ingestion does without them. It returns nothing when all are found.
+func CheckTools() []string {
+	var problems []string
+	if _, err := toolPath(ToolPdftoppm); err != nil {
+		switch toolOptions.Rasterizer {
+		case RasterizerPoppler:
+			problems = append(problems, err.Error()+"; scanned PDFs cannot be OCR'd (set pdf_rasterizer: go to use the built-in fallback)")
+		case RasterizerAuto:
+			problems = append(problems, err.Error()+"; scanned PDFs are OCR'd from their embedded images instead")
+		}
+	}
+	if _, err := toolPath(ToolPdftotext); err != nil {
+		problems = append(problems, err.Error()+"; PDF text is extracted without layout, so tables are not flattened")
+	}
+	if ocrOptions.Deskew {
+		if _, err := toolPath(ToolMagick); err != nil {
+			problems = append(problems, err.Error()+"; images are OCR'd without deskewing")
+		}
+	}
+	return problems
+}
@@ -0,0 +1,99 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ollama"
+)
+
+// VisionOptions controls captioning of images with a vision model.
+type VisionOptions struct {
+	// Model is the Ollama vision model, e.g. llava. Empty disables captioning.
+	Model string
+	// MinTextChars is how much OCR text an image needs to go without a
+	// caption. Screenshots of documents are captioned only when OCR finds
+	// almost nothing; diagrams and photos always are.
+	MinTextChars int
+}
+
+var visionOptions = VisionOptions{MinTextChars: 100}
+
+// SetVisionOptions replaces the options used for image captioning.
+func SetVisionOptions(o VisionOptions) {
+	visionOptions = o
+}
+
+type visionRequest struct {
+	Model  string   `json:"model"`
+	Prompt string   `json:"prompt"`
+	Images []string `json:"images"`
+	Stream bool     `json:"stream"`
+}
+
+type visionResponse struct {
+	Response string `json:"response"`
+}
+
+const captionPrompt = `Describe this image for a search index. Say what kind of image it is
+(diagram, chart, screenshot, photo, ...), what it shows, and any labels,
+names or numbers you can read. Use plain sentences, no more than 150 words.`
+
+// needsCaption reports whether an image with the given OCR text should be
+// described by the vision model.
+func needsCaption(ocrText string) bool {
+	return visionOptions.Model != "" && len(strings.TrimSpace(ocrText)) < visionOptions.MinTextChars
+}
+
+// captionImage asks the vision model to describe the image at path.
+func captionImage(ctx context.Context, path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var resp visionResponse
+	err = ollama.PostJSON(ctx, "/api/generate", visionRequest{
+		Model:  visionOptions.Model,
+		Prompt: captionPrompt,
+		Images: []string{base64.StdEncoding.EncodeToString(b)},
+	}, &resp)
+	if err != nil {
+		return "", fmt.Errorf("caption: %w", err)
+	}
+	return strings.TrimSpace(resp.Response), nil
+}
+
+// extractImage OCRs an image and, if it has little text, adds a caption
+// from the vision model. Either one failing is a warning as long as the
+// other produced text.
+func extractImage(path string) (*Extraction, error) {
+	text, warnings, ocrErr := ExtractTextWithOCR(path)
+	if !needsCaption(text) {
+		if ocrErr != nil {
+			return nil, ocrErr
+		}
+		return &Extraction{Text: text, Warnings: warnings}, nil
+	}
+	if ocrErr != nil {
+		warnings = append(warnings, "OCR failed: "+ocrErr.Error())
+	}
+	caption, err := captionImage(context.Background(), path)
+	if err != nil {
+		if ocrErr != nil {
+			return nil, ocrErr
+		}
+		warnings = append(warnings, err.Error())
+		return &Extraction{Text: text, Warnings: warnings}, nil
+	}
+	if caption == "" {
+		return &Extraction{Text: text, Warnings: warnings}, nil
+	}
+	full := "Image description: " + caption
+	if strings.TrimSpace(text) != "" {
+		full += "\n\nText in image:\n" + text
+	}
+	return &Extraction{Text: full, Warnings: warnings}, nil
+}
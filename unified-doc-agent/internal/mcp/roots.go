@@ -0,0 +1,176 @@
+package mcp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Roots are the directories the client may reach. A client with the roots
+// capability lists its workspace directories: the server asks it with
+// roots/list once it is initialized and again whenever it sends
+// notifications/roots/list_changed. index_path then only indexes paths
+// under them, and search_documents and ask_documents only use the files
+// under them. The roots given to NewTools, such as those of the -roots
+// flag, bound the client's: a client root outside them is left out, and
+// one containing one of them is narrowed to it. Without roots of either
+// kind any path may be indexed and every file searched.
+
+// Root is a root as the client lists it.
+type Root struct {
+	URI  string `json:"uri"`
+	Name string `json:"name,omitempty"`
+}
+
+var (
+	errRootsPending = errors.New("the client has not listed its workspace roots yet; try again")
+	errNoRoots      = errors.New("no workspace roots allow this: add the folder to the client's workspace")
+)
+
+// requestRoots asks the client for its roots. Its answer comes as a
+// response to a request of the server, handled by handleResponse.
+func (s *Server) requestRoots() {
+	s.lastID++
+	s.rootsID = fmt.Sprintf(`"roots-%d"`, s.lastID)
+	s.send(request{JSONRPC: "2.0", ID: json.RawMessage(s.rootsID), Method: "roots/list"})
+}
+
+// handleResponse takes the client's answer to a request of the server.
+// Only the answer to the latest roots/list counts; an earlier one is
+// outdated.
+func (s *Server) handleResponse(msg request) {
+	if string(msg.ID) != s.rootsID {
+		log.Printf("mcp: ignoring response to request %s", msg.ID)
+		return
+	}
+	if msg.Error != nil {
+		log.Printf("mcp: roots/list: %s", msg.Error.Message)
+		return
+	}
+	var result struct {
+		Roots []Root `json:"roots"`
+	}
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		log.Printf("mcp: roots/list: %v", err)
+		return
+	}
+	var dirs []string
+	for _, r := range result.Roots {
+		dir, err := rootPath(r.URI)
+		if err != nil {
+			log.Printf("mcp: ignoring root %s: %v", r.URI, err)
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+	s.tools.setClientRoots(dirs)
+}
+
+// rootPath returns the directory of a file:// root URI.
+func rootPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", errors.New("not a file:// URI")
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", errors.New("not a local file")
+	}
+	path := filepath.FromSlash(u.Path)
+	if !filepath.IsAbs(path) {
+		return "", errors.New("not an absolute path")
+	}
+	return resolvePath(path), nil
+}
+
+// resolvePath returns the clean path with its symbolic links resolved, as
+// far as they exist.
+func resolvePath(path string) string {
+	path = filepath.Clean(path)
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// within reports whether path is root or under it. Both are clean and
+// absolute.
+func within(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// withinAny reports whether path is under one of roots.
+func withinAny(path string, roots []string) bool {
+	for _, r := range roots {
+		if within(path, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// intersectRoots returns the directories under both one of a and one of b.
+func intersectRoots(a, b []string) []string {
+	var out []string
+	for _, x := range a {
+		for _, y := range b {
+			switch {
+			case within(x, y):
+				out = append(out, x)
+			case within(y, x):
+				out = append(out, y)
+			}
+		}
+	}
+	return out
+}
+
+// expectClientRoots notes that the client lists its roots; until it has,
+// nothing is reachable.
+func (t *Tools) expectClientRoots() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clientRootsExpected = true
+}
+
+// expectsClientRoots reports whether the client lists its roots.
+func (t *Tools) expectsClientRoots() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.clientRootsExpected
+}
+
+// setClientRoots replaces the client's roots.
+func (t *Tools) setClientRoots(dirs []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clientRoots, t.clientRootsListed = dirs, true
+}
+
+// scope returns the directories a tool call may reach, or nil when it may
+// reach everything.
+func (t *Tools) scope() ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	roots := t.roots
+	switch {
+	case !t.clientRootsExpected:
+	case !t.clientRootsListed:
+		return nil, errRootsPending
+	case roots == nil:
+		roots = t.clientRoots
+	default:
+		roots = intersectRoots(t.clientRoots, roots)
+	}
+	if (t.roots != nil || t.clientRootsExpected) && len(roots) == 0 {
+		return nil, errNoRoots
+	}
+	return roots, nil
+}
@@ -0,0 +1,245 @@
+// Package mcp serves the doc agent to MCP clients such as Claude Desktop
+// or an IDE over stdio: one JSON-RPC 2.0 message per line in each
+// direction.
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+// ProtocolVersion is the MCP revision the server implements. Clients
+// asking for another one get this one and may disconnect.
+const ProtocolVersion = "2024-11-05"
+
+// Version is reported to clients; release builds set it with -ldflags.
+var Version = "dev"
+
+// maxMessage caps the size of one incoming message.
+const maxMessage = 10 << 20
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// request is a message of either side: a request, a notification or,
+// without a method, the response to a request of the other side.
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Server answers MCP requests by running Tools.
+type Server struct {
+	tools *Tools
+
+	// mu serializes writes to the client.
+	mu  sync.Mutex
+	enc *json.Encoder
+
+	// lastID numbers the requests sent to the client, and rootsID is the
+	// id of the latest roots/list; see roots.go.
+	lastID  int
+	rootsID string
+
+	// calls cancels the tool calls in flight, by request id.
+	callsMu sync.Mutex
+	calls   map[string]context.CancelFunc
+}
+
+// New returns a server exposing tools.
+func New(tools *Tools) *Server {
+	return &Server{tools: tools}
+}
+
+// Serve reads requests from r and writes responses to w until r ends or
+// ctx is cancelled. Tool calls run concurrently, each until it finishes
+// or the client cancels it with notifications/cancelled; other requests
+// are handled in order as they arrive. Serve returns once the calls in
+// flight have answered. Nothing else may write to w, so logs must go to
+// stderr.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	s.enc = json.NewEncoder(w)
+	s.calls = make(map[string]context.CancelFunc)
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), maxMessage)
+	for sc.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var req request
+		if err := json.Unmarshal(line, &req); err != nil {
+			s.write(response{ID: json.RawMessage("null"), Error: &rpcError{codeParseError, "parse error: " + err.Error()}})
+			continue
+		}
+		if req.Method == "" && len(req.ID) > 0 {
+			s.handleResponse(req)
+			continue
+		}
+		// Notifications (no id) get no response.
+		notification := len(req.ID) == 0
+		if !notification && req.Method == "tools/call" {
+			inFlight.Add(1)
+			go func() {
+				defer inFlight.Done()
+				s.call(ctx, req)
+			}()
+			continue
+		}
+		result, rerr := s.handle(ctx, req)
+		if notification {
+			if rerr != nil {
+				log.Printf("mcp: notification %s: %s", req.Method, rerr.Message)
+			}
+			continue
+		}
+		s.write(response{ID: req.ID, Result: result, Error: rerr})
+	}
+	return sc.Err()
+}
+
+// call answers the tool call req unless the client cancels it first, in
+// which case it expects no answer.
+func (s *Server) call(ctx context.Context, req request) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	id := string(req.ID)
+	s.callsMu.Lock()
+	s.calls[id] = cancel
+	s.callsMu.Unlock()
+
+	result, rerr := s.handle(ctx, req)
+
+	s.callsMu.Lock()
+	delete(s.calls, id)
+	s.callsMu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	s.write(response{ID: req.ID, Result: result, Error: rerr})
+}
+
+// cancel cancels the tool call with the request id of a
+// notifications/cancelled. Calls already answered are left alone.
+func (s *Server) cancel(params json.RawMessage) {
+	var p struct {
+		RequestID json.RawMessage `json:"requestId"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil || len(p.RequestID) == 0 {
+		return
+	}
+	s.callsMu.Lock()
+	defer s.callsMu.Unlock()
+	if cancel, ok := s.calls[string(p.RequestID)]; ok {
+		cancel()
+	}
+}
+
+func (s *Server) write(resp response) {
+	resp.JSONRPC = "2.0"
+	// A success carries a result even when it is nil.
+	if resp.Error == nil && resp.Result == nil {
+		resp.Result = json.RawMessage("null")
+	}
+	s.send(resp)
+}
+
+// send writes one message to the client.
+func (s *Server) send(msg interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(msg); err != nil {
+		log.Printf("mcp: write message: %v", err)
+	}
+}
+
+func (s *Server) handle(ctx context.Context, req request) (interface{}, *rpcError) {
+	if req.JSONRPC != "2.0" {
+		return nil, &rpcError{codeInvalidRequest, `jsonrpc must be "2.0"`}
+	}
+	switch req.Method {
+	case "initialize":
+		var p struct {
+			Capabilities struct {
+				Roots *struct{} `json:"roots"`
+			} `json:"capabilities"`
+		}
+		if json.Unmarshal(req.Params, &p) == nil && p.Capabilities.Roots != nil {
+			s.tools.expectClientRoots()
+		}
+		return map[string]interface{}{
+			"protocolVersion": ProtocolVersion,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]string{"name": "unified-doc-agent", "version": Version},
+		}, nil
+	case "notifications/initialized", "notifications/roots/list_changed":
+		if s.tools.expectsClientRoots() {
+			s.requestRoots()
+		}
+		return nil, nil
+	case "notifications/cancelled":
+		s.cancel(req.Params)
+		return nil, nil
+	case "ping":
+		return map[string]interface{}{}, nil
+	case "tools/list":
+		return map[string]interface{}{"tools": toolList}, nil
+	case "tools/call":
+		var p struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &p); err != nil || p.Name == "" {
+			return nil, &rpcError{codeInvalidParams, "tools/call needs a tool name"}
+		}
+		text, err := s.tools.Call(ctx, p.Name, p.Arguments)
+		if err == errUnknownTool {
+			return nil, &rpcError{codeInvalidParams, fmt.Sprintf("unknown tool %q", p.Name)}
+		}
+		// Tool failures are results the model can read, not protocol errors.
+		if err != nil {
+			return toolResult(err.Error(), true), nil
+		}
+		return toolResult(text, false), nil
+	default:
+		return nil, &rpcError{codeMethodNotFound, "method not found: " + req.Method}
+	}
+}
+
+func toolResult(text string, isError bool) map[string]interface{} {
+	return map[string]interface{}{
+		"content": []map[string]string{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
@@ -0,0 +1,285 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/graph"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/indexer"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+)
+
+// Tool names.
+const (
+	ToolIndexPath       = "index_path"
+	ToolSearchDocuments = "search_documents"
+	ToolAskDocuments    = "ask_documents"
+)
+
+var errUnknownTool = errors.New("unknown tool")
+
+// Tool describes a tool to MCP clients.
+type Tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+func stringProp(description string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "description": description}
+}
+
+func listProp(description string) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": map[string]string{"type": "string"}, "description": description}
+}
+
+var toolList = []Tool{
+	{
+		Name:        ToolIndexPath,
+		Description: "Index a local file or folder (PDFs, text, Markdown, images, archives) so it can be searched and asked about. Unchanged files are skipped.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"path":   stringProp("Absolute path of the file or folder"),
+				"resume": map[string]interface{}{"type": "boolean", "description": "Continue an interrupted earlier run over the same path"},
+			},
+			"required": []string{"path"},
+		},
+	},
+	{
+		Name:        ToolSearchDocuments,
+		Description: "Find the passages of the indexed documents most relevant to a query, with their file, page and chunk id.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query":                  stringProp("What to look for"),
+				"top_k":                  map[string]interface{}{"type": "integer", "description": "How many passages to return (default from config)"},
+				"entities":               listProp("Only return passages mentioning all of these names, e.g. Acme Corp"),
+				"collections":            listProp("Only search these collections"),
+				"since":                  stringProp("Only search documents last modified on or after this date (2006-01-02) or RFC 3339 time"),
+				"until":                  stringProp("Only search documents last modified before this RFC 3339 time or by the end of this date (2006-01-02)"),
+				"recency_half_life_days": map[string]interface{}{"type": "number", "description": "Prefer recently modified documents, halving the recency-weighted share of a passage's score every this many days"},
+				"allow_mixed_embeddings": map[string]interface{}{"type": "boolean", "description": "Search even if some passages were embedded by another model or model version, skipping them"},
+			},
+			"required": []string{"query"},
+		},
+	},
+	{
+		Name:        ToolAskDocuments,
+		Description: "Answer a question from the indexed documents, citing the files used. Says so when the documents do not contain the answer.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query":                  stringProp("The question"),
+				"mode":                   stringProp("auto, factoid, summary, list, compare or graph (multi-hop); default auto"),
+				"style":                  stringProp("bullet for bullet points or narrative for prose; default as the mode has it"),
+				"length":                 stringProp("short or long; default as the mode has it"),
+				"language":               stringProp("Language to answer in, e.g. es or Spanish"),
+				"entities":               listProp("Only use passages mentioning all of these names"),
+				"collections":            listProp("Only search these collections"),
+				"since":                  stringProp("Only use documents last modified on or after this date (2006-01-02) or RFC 3339 time"),
+				"until":                  stringProp("Only use documents last modified before this RFC 3339 time or by the end of this date (2006-01-02)"),
+				"recency_half_life_days": map[string]interface{}{"type": "number", "description": "Prefer recently modified documents, e.g. for the latest decision on something, halving the recency-weighted share of a passage's score every this many days"},
+				"allow_mixed_embeddings": map[string]interface{}{"type": "boolean", "description": "Answer even if some passages were embedded by another model or model version, skipping them"},
+			},
+			"required": []string{"query"},
+		},
+	},
+}
+
+// Tools runs the doc agent's tools against the index.
+type Tools struct {
+	db graph.Store
+	// accessTags are the tags the MCP client may see.
+	accessTags []string
+	// roots bound the directories the client may reach, when set; see
+	// roots.go.
+	roots []string
+
+	// mu guards the client's roots.
+	mu                  sync.Mutex
+	clientRoots         []string
+	clientRootsExpected bool
+	clientRootsListed   bool
+}
+
+// NewTools returns tools searching db as a caller with accessTags, and
+// reaching only the files under roots unless roots is empty.
+func NewTools(db graph.Store, accessTags, roots []string) *Tools {
+	t := &Tools{db: db, accessTags: accessTags}
+	for _, r := range roots {
+		if abs, err := filepath.Abs(r); err == nil {
+			t.roots = append(t.roots, resolvePath(abs))
+		}
+	}
+	return t
+}
+
+type toolArgs struct {
+	Path        string   `json:"path"`
+	Resume      bool     `json:"resume"`
+	Query       string   `json:"query"`
+	TopK        int      `json:"top_k"`
+	Mode        string   `json:"mode"`
+	Style       string   `json:"style"`
+	Length      string   `json:"length"`
+	Language    string   `json:"language"`
+	Entities    []string `json:"entities"`
+	Collections []string `json:"collections"`
+	Since       string   `json:"since"`
+	Until       string   `json:"until"`
+
+	RecencyHalfLifeDays  float64 `json:"recency_half_life_days"`
+	AllowMixedEmbeddings bool    `json:"allow_mixed_embeddings"`
+}
+
+// Call runs the named tool and returns its text output.
+func (t *Tools) Call(ctx context.Context, name string, raw json.RawMessage) (string, error) {
+	var args toolArgs
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+	}
+	switch name {
+	case ToolIndexPath:
+		return t.indexPath(ctx, args)
+	case ToolSearchDocuments:
+		return t.search(ctx, args)
+	case ToolAskDocuments:
+		return t.ask(ctx, args)
+	}
+	return "", errUnknownTool
+}
+
+func (t *Tools) indexPath(ctx context.Context, args toolArgs) (string, error) {
+	if args.Path == "" {
+		return "", errors.New("path is required")
+	}
+	roots, err := t.scope()
+	if err != nil {
+		return "", err
+	}
+	path := args.Path
+	if roots != nil {
+		// Symbolic links are resolved so that none leads out of the roots
+		if !filepath.IsAbs(path) {
+			return "", errors.New("path must be absolute")
+		}
+		if path = resolvePath(path); !withinAny(path, roots) {
+			return "", fmt.Errorf("%s is outside the workspace roots %s", args.Path, strings.Join(roots, ", "))
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("path not accessible: %w", err)
+	}
+	res, err := indexer.IndexPath(ctx, path, nil, indexer.Options{Resume: args.Resume})
+	if err != nil {
+		return "", fmt.Errorf("indexing failed: %w", err)
+	}
+	var b bytes.Buffer
+	indexer.PrintSummary(&b, res)
+	return b.String(), nil
+}
+
+// state builds the workflow state for a query tool call.
+func (t *Tools) state(args toolArgs) (*graph.State, error) {
+	if strings.TrimSpace(args.Query) == "" {
+		return nil, errors.New("query is required")
+	}
+	if args.TopK < 0 || args.RecencyHalfLifeDays < 0 {
+		return nil, errors.New("top_k and recency_half_life_days must not be negative")
+	}
+	since, until, err := graph.ParseDateRange(args.Since, args.Until)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := t.scope()
+	if err != nil {
+		return nil, err
+	}
+	mode, err := graph.ParseQueryMode(args.Mode)
+	if err != nil {
+		return nil, err
+	}
+	style, err := graph.ParseAnswerStyle(args.Style, args.Length, args.Language)
+	if err != nil {
+		return nil, err
+	}
+	s := &graph.State{
+		Query:       args.Query,
+		DB:          t.db,
+		TopK:        args.TopK,
+		AccessTags:  t.accessTags,
+		Collections: args.Collections,
+		Roots:       roots,
+		Since:       since,
+		Until:       until,
+		QueryType:   mode,
+		Style:       style,
+
+		RecencyHalfLife: time.Duration(args.RecencyHalfLifeDays * float64(24*time.Hour)),
+
+		AllowMixedEmbeddings: args.AllowMixedEmbeddings,
+	}
+	for _, e := range args.Entities {
+		if e = processing.NormalizeEntity(e); e != "" {
+			s.Entities = append(s.Entities, e)
+		}
+	}
+	return s, nil
+}
+
+// search retrieves and filters chunks the way a query does, without
+// summarizing them.
+func (t *Tools) search(ctx context.Context, args toolArgs) (string, error) {
+	s, err := t.state(args)
+	if err != nil {
+		return "", err
+	}
+	if err := graph.RetrieverNode(ctx, s); err != nil {
+		return "", err
+	}
+	if err := graph.GuardrailsNode(ctx, s); err != nil {
+		return "", err
+	}
+	if len(s.Docs) == 0 {
+		return "No matching passages found.", nil
+	}
+	var b strings.Builder
+	for _, d := range s.Docs {
+		fmt.Fprintf(&b, "[chunk %d] %s", d.ID, d.Filename)
+		if d.Page > 0 {
+			fmt.Fprintf(&b, " (page %d)", d.Page)
+		}
+		if !d.Modified.IsZero() {
+			fmt.Fprintf(&b, " modified %s", d.Modified.Format(time.DateOnly))
+		}
+		fmt.Fprintf(&b, " score %.3f\n%s\n\n", d.Score, d.Content)
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// ask runs the full query workflow and returns the answer with sources.
+func (t *Tools) ask(ctx context.Context, args toolArgs) (string, error) {
+	s, err := t.state(args)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if s.Out, err = graph.NewOutput("text", &b); err != nil {
+		return "", err
+	}
+	if err := graph.RunWorkflow(ctx, s); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
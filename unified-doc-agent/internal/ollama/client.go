@@ -0,0 +1,242 @@
+// Package ollama is the HTTP client shared by the embedding and generation
+// code. It reuses connections, bounds every call with a timeout and retries
+// transient failures.
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Settings, overridden from the agent config at startup.
+var (
+	BaseURL = "http://localhost:11434"
+	// Timeout bounds a whole non-streaming request. A streaming request,
+	// whose generation can run long, is only bounded by it while waiting
+	// for the response headers and for each piece of the response.
+	Timeout = 60 * time.Second
+	// MaxRetries is how many times a failed request is retried.
+	MaxRetries = 3
+	// RetryBackoff is the delay before the first retry; it doubles each time.
+	RetryBackoff = 500 * time.Millisecond
+)
+
+var transport = &http.Transport{
+	Proxy:               http.ProxyFromEnvironment,
+	DialContext:         (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+	MaxIdleConns:        32,
+	MaxIdleConnsPerHost: 16,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+var httpClient = &http.Client{Transport: transport}
+
+// streamClient sends streaming requests, giving up on responses whose
+// headers take longer than Timeout. It is made on first use, once Timeout
+// is configured.
+var streamClient = sync.OnceValue(func() *http.Client {
+	t := transport.Clone()
+	t.ResponseHeaderTimeout = Timeout
+	return &http.Client{Transport: t}
+})
+
+// PostJSON sends body to path and decodes the JSON response into out,
+// retrying connection errors, 429s and 5xx responses with backoff.
+func PostJSON(ctx context.Context, path string, body, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	resp, err := post(ctx, httpClient, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("ollama: decoding response: %w", err)
+	}
+	return nil
+}
+
+// Stream is PostJSON for streaming endpoints: the caller reads and closes
+// the response body. Only establishing the response is retried. Reading
+// fails once Timeout passes without a piece of the response, so a stalled
+// generation does not hold the caller until its context ends.
+func Stream(ctx context.Context, path string, body interface{}) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	resp, err := post(ctx, streamClient(), path, body)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r := &idleReader{body: resp.Body, cancel: cancel, idle: Timeout}
+	r.timer = time.AfterFunc(r.idle, r.expire)
+	return r, nil
+}
+
+// idleReader is the body of a streaming response, cancelling the request
+// when no read returns anything for idle.
+type idleReader struct {
+	body    io.ReadCloser
+	cancel  context.CancelFunc
+	idle    time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func (r *idleReader) expire() {
+	r.expired.Store(true)
+	r.cancel()
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if r.expired.Load() {
+		return n, fmt.Errorf("ollama: no response for %s", r.idle)
+	}
+	if n > 0 {
+		r.timer.Reset(r.idle)
+	}
+	return n, err
+}
+
+func (r *idleReader) Close() error {
+	r.timer.Stop()
+	r.cancel()
+	return r.body.Close()
+}
+
+func post(ctx context.Context, client *http.Client, path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := RetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := doPost(ctx, client, path, data)
+		if err == nil {
+			return resp, nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) || attempt >= MaxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// permanentError is a response that retrying will not fix.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func doPost(ctx context.Context, client *http.Client, path string, data []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", BaseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, unreachable(err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	err = fmt.Errorf("ollama error (%s): %s", resp.Status, strings.TrimSpace(string(msg)))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return nil, err
+	}
+	return nil, &permanentError{err}
+}
+
+func unreachable(err error) error {
+	return fmt.Errorf("cannot reach Ollama at %s (is Ollama running? start it with `ollama serve`): %w", BaseURL, err)
+}
+
+type tagsResponse struct {
+	Models []struct {
+		Name   string `json:"name"`
+		Digest string `json:"digest"`
+	} `json:"models"`
+}
+
+// installedModels returns the digest of every pulled model by name, with
+// "llama3" standing for "llama3:latest".
+func installedModels(ctx context.Context) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", BaseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, unreachable(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ollama at %s answered %s", BaseURL, resp.Status)
+	}
+
+	var tags tagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("ollama: decoding model list: %w", err)
+	}
+	have := map[string]string{}
+	for _, m := range tags.Models {
+		have[m.Name] = m.Digest
+		have[strings.TrimSuffix(m.Name, ":latest")] = m.Digest
+	}
+	return have, nil
+}
+
+// Ping checks that Ollama answers and that every model in models has been
+// pulled, returning an error that says what to do about it if not.
+func Ping(ctx context.Context, models ...string) error {
+	have, err := installedModels(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range models {
+		if _, ok := have[m]; !ok {
+			return fmt.Errorf("ollama model %q is not installed (run `ollama pull %s`)", m, m)
+		}
+	}
+	return nil
+}
+
+// ModelDigest returns the digest of the pulled model, which changes when
+// it is pulled again and its weights are updated.
+func ModelDigest(ctx context.Context, model string) (string, error) {
+	have, err := installedModels(ctx)
+	if err != nil {
+		return "", err
+	}
+	digest, ok := have[model]
+	if !ok {
+		return "", fmt.Errorf("ollama model %q is not installed (run `ollama pull %s`)", model, model)
+	}
+	return digest, nil
+}
@@ -3,38 +3,80 @@ package processing
 import (
 	"regexp"
 	"strings"
+	"unicode"
 )
 
+// Chunk sizing in characters, overridden from the agent config at startup.
+var (
+	ChunkSize    = 1000
+	ChunkOverlap = 200
+)
+
+var paragraphBreak = regexp.MustCompile(`\n{2,}`)
+
+// Span is a chunk and the byte offsets of its text in what was chunked:
+// Text == text[Start:End].
+type Span struct {
+	Text       string
+	Start, End int
+}
+
 // ChunkText splits into paragraph chunks and limits size.
 func ChunkText(text string) []string {
-	re := regexp.MustCompile(`\n{2,}`)
-	paras := re.Split(text, -1)
-	var out []string
-	for _, p := range paras {
-		p = strings.TrimSpace(p)
-		if p == "" {
+	spans := ChunkSpans(text)
+	out := make([]string, len(spans))
+	for i, s := range spans {
+		out[i] = s.Text
+	}
+	return out
+}
+
+// ChunkSpans chunks text like ChunkText, keeping where each chunk came
+// from so neighbouring chunks can be stitched back together at query time.
+func ChunkSpans(text string) []Span {
+	var out []Span
+	start := 0
+	for _, brk := range append(paragraphBreak.FindAllStringIndex(text, -1), []int{len(text), len(text)}) {
+		p, ok := trimSpan(text, start, brk[0])
+		start = brk[1]
+		if !ok {
 			continue
 		}
-		// further split very long paragraphs into ~1000-char chunks with overlap
-		out = append(out, splitLong(p, 1000, 200)...)
+		// further split very long paragraphs into ChunkSize chunks with overlap
+		out = append(out, splitLong(text, p, ChunkSize, ChunkOverlap)...)
 	}
 	return out
 }
 
-func splitLong(s string, max, overlap int) []string {
-	if len(s) <= max {
-		return []string{s}
+func splitLong(text string, p Span, max, overlap int) []Span {
+	if len(p.Text) <= max {
+		return []Span{p}
 	}
-	var res []string
-	for i := 0; i < len(s); i += (max - overlap) {
+	var res []Span
+	for i := p.Start; i < p.End; i += (max - overlap) {
 		end := i + max
-		if end > len(s) {
-			end = len(s)
+		if end > p.End {
+			end = p.End
+		}
+		if s, ok := trimSpan(text, i, end); ok {
+			res = append(res, s)
 		}
-		res = append(res, strings.TrimSpace(s[i:end]))
-		if end == len(s) {
+		if end == p.End {
 			break
 		}
 	}
 	return res
 }
+
+// trimSpan returns text[start:end] without surrounding white space, or
+// false if nothing is left.
+func trimSpan(text string, start, end int) (Span, bool) {
+	s := text[start:end]
+	trimmed := strings.TrimLeftFunc(s, unicode.IsSpace)
+	start += len(s) - len(trimmed)
+	trimmed = strings.TrimRightFunc(trimmed, unicode.IsSpace)
+	if trimmed == "" {
+		return Span{}, false
+	}
+	return Span{Text: trimmed, Start: start, End: start + len(trimmed)}, true
+}
@@ -1,20 +1,21 @@
 package processing
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ollama"
 )
 
-// EmbeddingDim is the fixed dimension of the embedding vector.
-const EmbeddingDim = 768
+// EmbeddingDim is the dimension every embedding must have. It matches the
+// documents table and changes only through an embedding migration.
+var EmbeddingDim = 768
 
-// Ollama API endpoint for local embeddings
-const ollamaURL = "http://localhost:11434/api/embeddings"
+// EmbedModel is the default embedding model, overridden from the agent config at startup.
+var EmbedModel = "nomic-embed-text"
 
 // request struct for Ollama API
 type ollamaRequest struct {
@@ -27,15 +28,21 @@ type ollamaResponse struct {
 	Embedding []float32 `json:"embedding"`
 }
 
-// EmbedChunks produces embeddings for each chunk by calling Ollama.
+// EmbedChunks produces embeddings for each chunk by calling Ollama with the
+// default embedding model.
 func EmbedChunks(ctx context.Context, chunks []string) ([][]float32, error) {
+	return EmbedChunksWithModel(ctx, EmbedModel, chunks)
+}
+
+// EmbedChunksWithModel is EmbedChunks with an explicit embedding model.
+func EmbedChunksWithModel(ctx context.Context, model string, chunks []string) ([][]float32, error) {
 	if len(chunks) == 0 {
 		return nil, errors.New("no chunks")
 	}
 
 	out := make([][]float32, len(chunks))
 	for i, chunk := range chunks {
-		emb, err := getOllamaEmbedding(chunk)
+		emb, err := getOllamaEmbedding(ctx, model, chunk)
 		if err != nil {
 			return nil, fmt.Errorf("failed embedding chunk %d: %w", i, err)
 		}
@@ -47,39 +54,90 @@ func EmbedChunks(ctx context.Context, chunks []string) ([][]float32, error) {
 
 // QueryEmbedding produces an embedding for a query string.
 func QueryEmbedding(ctx context.Context, query string) ([]float32, error) {
+	return QueryEmbeddingWithModel(ctx, EmbedModel, query)
+}
+
+// QueryEmbeddingWithModel is QueryEmbedding with an explicit embedding model.
+func QueryEmbeddingWithModel(ctx context.Context, model, query string) ([]float32, error) {
 	if query == "" {
 		return nil, errors.New("empty query")
 	}
-	return getOllamaEmbedding(query)
+	return getOllamaEmbedding(ctx, model, query)
 }
 
-// getOllamaEmbedding calls Ollama local API and returns the embedding vector.
-func getOllamaEmbedding(text string) ([]float32, error) {
-	reqBody := ollamaRequest{
-		Model:  "nomic-embed-text",
-		Prompt: text,
+// ProbeDimension embeds a short sample with model and returns the length
+// of the vector it produces.
+func ProbeDimension(ctx context.Context, model string) (int, error) {
+	emb, err := rawEmbedding(ctx, model, "dimension probe")
+	if err != nil {
+		return 0, err
+	}
+	if len(emb) == 0 {
+		return 0, fmt.Errorf("model %s returned an empty embedding", model)
 	}
-	data, _ := json.Marshal(reqBody)
+	return len(emb), nil
+}
 
-	resp, err := http.Post(ollamaURL, "application/json", bytes.NewReader(data))
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+// versionTTL is how long EmbedVersion trusts the digest it last read, so
+// a model pulled again is noticed while the agent runs.
+const versionTTL = time.Minute
+
+var (
+	versionsMu sync.Mutex
+	versions   = map[string]modelVersion{}
+)
+
+type modelVersion struct {
+	version string
+	read    time.Time
+}
+
+// EmbedVersion returns the version of model: the first 12 characters of
+// its Ollama digest, as `ollama list` shows it. Chunks record it, since a
+// model pulled again under the same name may embed differently.
+func EmbedVersion(ctx context.Context, model string) (string, error) {
+	versionsMu.Lock()
+	v, ok := versions[model]
+	versionsMu.Unlock()
+	if ok && time.Since(v.read) < versionTTL {
+		return v.version, nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("ollama error: %s", string(bodyBytes))
+	digest, err := ollama.ModelDigest(ctx, model)
+	if err != nil {
+		return "", err
+	}
+	if len(digest) > 12 {
+		digest = digest[:12]
 	}
+	versionsMu.Lock()
+	versions[model] = modelVersion{version: digest, read: time.Now()}
+	versionsMu.Unlock()
+	return digest, nil
+}
 
+func rawEmbedding(ctx context.Context, model, text string) ([]float32, error) {
+	reqBody := ollamaRequest{
+		Model:  model,
+		Prompt: text,
+	}
 	var oResp ollamaResponse
-	if err := json.NewDecoder(resp.Body).Decode(&oResp); err != nil {
-		return nil, fmt.Errorf("failed decode response: %w", err)
+	if err := ollama.PostJSON(ctx, "/api/embeddings", reqBody, &oResp); err != nil {
+		return nil, err
 	}
+	return oResp.Embedding, nil
+}
 
-	if len(oResp.Embedding) != EmbeddingDim {
-		return nil, fmt.Errorf("expected embedding dim %d, got %d", EmbeddingDim, len(oResp.Embedding))
+// getOllamaEmbedding calls Ollama local API and returns the embedding vector.
+func getOllamaEmbedding(ctx context.Context, model, text string) ([]float32, error) {
+	emb, err := rawEmbedding(ctx, model, text)
+	if err != nil {
+		return nil, err
 	}
 
-	return oResp.Embedding, nil
+	if len(emb) != EmbeddingDim {
+		return nil, fmt.Errorf("expected embedding dim %d, got %d", EmbeddingDim, len(emb))
+	}
+
+	return emb, nil
 }
@@ -0,0 +1,243 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ollama"
+)
+
+// Entity extractors.
+const (
+	ExtractorRules = "rules"
+	ExtractorLLM   = "llm"
+	ExtractorOff   = "off"
+)
+
+// EntityExtractor selects how chunk metadata is extracted, and EntityModel
+// the generation model used by ExtractorLLM. Both are overridden from the
+// agent config at startup.
+var (
+	EntityExtractor = ExtractorRules
+	EntityModel     = "llama3"
+)
+
+// maxKeywords is the number of keyphrases kept per chunk.
+const maxKeywords = 8
+
+// ChunkMetadata is what is extracted from a chunk to filter and rank on.
+// Values are lower case so they can be matched exactly.
+type ChunkMetadata struct {
+	Entities []string `json:"entities,omitempty"`
+	Keywords []string `json:"keywords,omitempty"`
+}
+
+// ExtractMetadata returns the named entities and keyphrases of a chunk.
+func ExtractMetadata(ctx context.Context, text string) (ChunkMetadata, error) {
+	switch EntityExtractor {
+	case ExtractorOff:
+		return ChunkMetadata{}, nil
+	case ExtractorLLM:
+		return llmMetadata(ctx, text)
+	default:
+		return RuleMetadata(text), nil
+	}
+}
+
+// RuleMetadata extracts metadata with the built-in rules, which need no
+// model: capitalized names and acronyms, and the most frequent terms.
+func RuleMetadata(text string) ChunkMetadata {
+	return ChunkMetadata{Entities: extractEntities(text), Keywords: extractKeywords(text, maxKeywords)}
+}
+
+// NormalizeEntity lower-cases name and collapses its whitespace, the form
+// entities are stored and filtered in.
+func NormalizeEntity(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+var (
+	// A run of capitalized words, optionally joined by "of", "&" or "and",
+	// e.g. "Acme Corp" or "Bank of England".
+	capitalizedRun = regexp.MustCompile(`\b\p{Lu}[\p{L}'’-]*(?:[ \t]+(?:(?:of|and|&|de|van|von)[ \t]+)?\p{Lu}[\p{L}'’-]*)*`)
+	acronym        = regexp.MustCompile(`\b\p{Lu}{2,6}\b`)
+	sentenceEnd    = regexp.MustCompile(`(?:[.!?:]|\n)\s*$`)
+	// clauseBreak separates the stretches of text keyphrases may span.
+	clauseBreak = regexp.MustCompile(`[.,;:!?()\[\]"\n]+`)
+)
+
+// extractEntities picks capitalized word runs and acronyms. A single
+// capitalized word at the start of a sentence is ignored, since it is
+// usually just an ordinary word.
+func extractEntities(text string) []string {
+	seen := map[string]bool{}
+	var out []string
+	add := func(e string) {
+		e = NormalizeEntity(strings.Trim(e, "-'’"))
+		if len(e) < 2 || seen[e] || isStopword(e) {
+			return
+		}
+		seen[e] = true
+		out = append(out, e)
+	}
+	for _, loc := range capitalizedRun.FindAllStringIndex(text, -1) {
+		m := text[loc[0]:loc[1]]
+		startOfSentence := loc[0] == 0 || sentenceEnd.MatchString(text[:loc[0]])
+		if startOfSentence && !strings.ContainsAny(m, " \t\n") {
+			continue
+		}
+		if startOfSentence {
+			// Drop a leading ordinary word: "The Acme Corp" -> "Acme Corp".
+			if first, rest, ok := strings.Cut(m, " "); ok && isStopword(strings.ToLower(first)) {
+				m = rest
+			}
+		}
+		add(m)
+	}
+	for _, m := range acronym.FindAllString(text, -1) {
+		add(m)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// extractKeywords ranks the words and two-word phrases of text that are
+// not stopwords by frequency and returns the top n. Phrases never span
+// punctuation, and anything seen only once is left out.
+func extractKeywords(text string, n int) []string {
+	counts := map[string]int{}
+	for _, clause := range clauseBreak.Split(strings.ToLower(text), -1) {
+		words := strings.FieldsFunc(clause, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-'
+		})
+		prev := ""
+		for _, w := range words {
+			w = strings.Trim(w, "-")
+			if len([]rune(w)) < 3 || isStopword(w) || isNumber(w) {
+				prev = ""
+				continue
+			}
+			counts[w]++
+			if prev != "" {
+				// Phrases count double so they beat their own words on a tie.
+				counts[prev+" "+w] += 2
+			}
+			prev = w
+		}
+	}
+	type kv struct {
+		k string
+		v int
+	}
+	var ranked []kv
+	for k, v := range counts {
+		// A phrase adds 2 per occurrence and a word 1; either must occur twice.
+		min := 2
+		if strings.Contains(k, " ") {
+			min = 4
+		}
+		if v >= min {
+			ranked = append(ranked, kv{k, v})
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].v != ranked[j].v {
+			return ranked[i].v > ranked[j].v
+		}
+		return ranked[i].k < ranked[j].k
+	})
+	var out []string
+	for _, r := range ranked {
+		if len(out) == n {
+			break
+		}
+		out = append(out, r.k)
+	}
+	return out
+}
+
+// extraStopwords complement the language-detection stopwords with common
+// English words that make poor keywords.
+var extraStopwords = strings.Fields(`a an at but can could did do does had has have he her his i if into its
+	may me might more most must my no our out over she should so such than their them then there these they
+	those through under up us very was we were what when where which while who whom why will would you your
+	also about after all any been before being between both each few how just only other own same some too`)
+
+func isStopword(w string) bool {
+	for _, set := range stopwordSets {
+		if set[w] {
+			return true
+		}
+	}
+	for _, s := range extraStopwords {
+		if s == w {
+			return true
+		}
+	}
+	return false
+}
+
+func isNumber(w string) bool {
+	for _, r := range w {
+		if !unicode.IsDigit(r) && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+type generateRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+	Stream bool   `json:"stream"`
+	Format string `json:"format"`
+}
+
+type generateResponse struct {
+	Response string `json:"response"`
+}
+
+const metadataPrompt = `Extract the named entities (people, organizations, places, products, projects) and up to %d keyphrases from the text below.
+Reply with JSON only, in the form {"entities": ["..."], "keywords": ["..."]}.
+
+%s`
+
+// llmMetadata asks EntityModel for the metadata in JSON mode.
+func llmMetadata(ctx context.Context, text string) (ChunkMetadata, error) {
+	var resp generateResponse
+	err := ollama.PostJSON(ctx, "/api/generate", generateRequest{
+		Model:  EntityModel,
+		Prompt: fmt.Sprintf(metadataPrompt, maxKeywords, text),
+		Format: "json",
+	}, &resp)
+	if err != nil {
+		return ChunkMetadata{}, fmt.Errorf("entity extraction: %w", err)
+	}
+	var md ChunkMetadata
+	if err := json.Unmarshal([]byte(resp.Response), &md); err != nil {
+		return ChunkMetadata{}, fmt.Errorf("entity extraction: model returned invalid JSON: %w", err)
+	}
+	md.Entities = normalizeAll(md.Entities)
+	md.Keywords = normalizeAll(md.Keywords)
+	if len(md.Keywords) > maxKeywords {
+		md.Keywords = md.Keywords[:maxKeywords]
+	}
+	return md, nil
+}
+
+func normalizeAll(xs []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, x := range xs {
+		if x = NormalizeEntity(x); x != "" && !seen[x] {
+			seen[x] = true
+			out = append(out, x)
+		}
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+package processing
+
+import (
+	"strings"
+	"unicode"
+)
+
+// UnknownLanguage is returned when too little text matches any profile.
+const UnknownLanguage = "und"
+
+// EmbedModels routes languages (ISO 639-1 codes) to embedding models.
+// Languages without an entry use EmbedModel. Every model must produce
+// EmbeddingDim-sized vectors to fit the documents table.
+var EmbedModels = map[string]string{}
+
+// stopwords are the most frequent function words of each supported
+// language. They are enough to tell languages apart on a few hundred words
+// without shipping n-gram tables.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "in", "is", "that", "for", "it", "with", "as", "was", "on", "are", "this", "be", "by", "not", "or", "from"},
+	"de": {"der", "die", "und", "in", "den", "von", "zu", "das", "mit", "sich", "des", "auf", "für", "ist", "im", "dem", "nicht", "ein", "eine", "auch"},
+	"fr": {"le", "la", "les", "de", "des", "et", "en", "un", "une", "du", "est", "que", "pour", "dans", "qui", "pas", "sur", "au", "avec", "ce"},
+	"es": {"el", "la", "de", "que", "y", "en", "los", "se", "del", "las", "un", "por", "con", "no", "una", "su", "para", "es", "al", "lo"},
+	"it": {"il", "di", "che", "e", "la", "per", "un", "in", "non", "una", "sono", "del", "della", "le", "si", "con", "da", "gli", "lo", "anche"},
+	"pt": {"de", "que", "o", "a", "e", "do", "da", "em", "um", "para", "com", "não", "uma", "os", "no", "se", "na", "por", "mais", "as"},
+	"nl": {"de", "van", "het", "een", "en", "in", "is", "dat", "op", "te", "zijn", "voor", "met", "niet", "aan", "er", "ook", "als", "bij", "door"},
+}
+
+var stopwordSets = func() map[string]map[string]bool {
+	sets := make(map[string]map[string]bool, len(stopwords))
+	for lang, words := range stopwords {
+		set := make(map[string]bool, len(words))
+		for _, w := range words {
+			set[w] = true
+		}
+		sets[lang] = set
+	}
+	return sets
+}()
+
+// DetectLanguage guesses the ISO 639-1 code of text from stopword
+// frequencies, looking at no more than the first 2000 words. It returns
+// UnknownLanguage when fewer than 3 stopwords match.
+func DetectLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if len(words) > 2000 {
+		words = words[:2000]
+	}
+
+	best, bestScore := UnknownLanguage, 2
+	for lang, set := range stopwordSets {
+		score := 0
+		for _, w := range words {
+			if set[w] {
+				score++
+			}
+		}
+		// Ties go to the lexically smaller code so the result is stable.
+		if score > bestScore || (score == bestScore && best != UnknownLanguage && lang < best) {
+			best, bestScore = lang, score
+		}
+	}
+	return best
+}
+
+// ModelFor returns the embedding model to use for a language.
+func ModelFor(lang string) string {
+	if m, ok := EmbedModels[lang]; ok && m != "" {
+		return m
+	}
+	return EmbedModel
+}
@@ -0,0 +1,77 @@
+package processing
+
+import (
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	// MinHashSize is the number of hash functions in a signature.
+	MinHashSize = 128
+	// shingleWords is how many consecutive words form one shingle.
+	shingleWords = 5
+)
+
+// MinHash returns a signature of text's 5-word shingles. The fraction of
+// equal positions in two signatures estimates the Jaccard similarity of
+// the texts, so near-identical documents can be found without comparing
+// them in full. Empty text yields nil.
+func MinHash(text string) []uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return nil
+	}
+
+	sig := make([]uint64, MinHashSize)
+	for i := range sig {
+		sig[i] = math.MaxUint64
+	}
+	n := len(words) - shingleWords + 1
+	if n < 1 {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		end := i + shingleWords
+		if end > len(words) {
+			end = len(words)
+		}
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:end], " ")))
+		base := h.Sum64()
+		for j := range sig {
+			// Derive the j-th hash function by mixing in a per-function seed.
+			if v := mix(base ^ uint64(j+1)*0x9e3779b97f4a7c15); v < sig[j] {
+				sig[j] = v
+			}
+		}
+	}
+	return sig
+}
+
+// Similarity estimates the Jaccard similarity of two MinHash signatures.
+func Similarity(a, b []uint64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	same := 0
+	for i := range a {
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(a))
+}
+
+// mix is the splitmix64 finalizer.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,67 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ollama"
+)
+
+// maxTriples caps the relations kept per chunk.
+const maxTriples = 20
+
+// Triple is one (subject, relation, object) fact stated in a chunk.
+// Subject and Object are normalized like entities so facts from different
+// chunks join on them.
+type Triple struct {
+	Subject  string `json:"subject"`
+	Relation string `json:"relation"`
+	Object   string `json:"object"`
+}
+
+const triplesPrompt = `Extract up to %d facts from the text below as (subject, relation, object) triples.
+Subjects and objects are named things: people, organizations, places, products, projects, documents.
+Relations are short verb phrases such as "works for", "supplies" or "is part of".
+Reply with JSON only, in the form {"triples": [{"subject": "...", "relation": "...", "object": "..."}]}.
+
+%s`
+
+// ExtractTriples asks EntityModel for the facts stated in text, in JSON mode.
+func ExtractTriples(ctx context.Context, text string) ([]Triple, error) {
+	var resp generateResponse
+	err := ollama.PostJSON(ctx, "/api/generate", generateRequest{
+		Model:  EntityModel,
+		Prompt: fmt.Sprintf(triplesPrompt, maxTriples, text),
+		Format: "json",
+	}, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("triple extraction: %w", err)
+	}
+	var out struct {
+		Triples []Triple `json:"triples"`
+	}
+	if err := json.Unmarshal([]byte(resp.Response), &out); err != nil {
+		return nil, fmt.Errorf("triple extraction: model returned invalid JSON: %w", err)
+	}
+
+	seen := map[Triple]bool{}
+	var triples []Triple
+	for _, t := range out.Triples {
+		t = Triple{
+			Subject:  NormalizeEntity(t.Subject),
+			Relation: strings.Join(strings.Fields(strings.ToLower(t.Relation)), " "),
+			Object:   NormalizeEntity(t.Object),
+		}
+		if t.Subject == "" || t.Relation == "" || t.Object == "" || t.Subject == t.Object || seen[t] {
+			continue
+		}
+		seen[t] = true
+		triples = append(triples, t)
+		if len(triples) == maxTriples {
+			break
+		}
+	}
+	return triples, nil
+}
@@ -0,0 +1,282 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// Google Workspace MIME types.
+const (
+	mimeFolder    = "application/vnd.google-apps.folder"
+	mimeGoogleDoc = "application/vnd.google-apps.document"
+	mimeGoogleApp = "application/vnd.google-apps."
+)
+
+const driveFileFields = "id,name,mimeType,md5Checksum,version,size,trashed,parents,modifiedTime"
+
+// Retries of a Drive call that failed with a 429, a 5xx or no response: up
+// to driveRetries more attempts, waiting driveBackoff before the first and
+// twice as long before each next one.
+const (
+	driveRetries = 3
+	driveBackoff = time.Second
+)
+
+// Drive syncs the files of a Google Drive through its changes feed.
+// Google Docs are indexed as plain text; other Workspace files are skipped.
+type Drive struct {
+	// folder restricts the sync to the files directly in it; "" syncs all
+	// of My Drive.
+	folder string
+	svc    *drive.Service
+}
+
+// NewDrive returns the Drive source for folder. It authenticates with
+// GOOGLE_ACCESS_TOKEN or, for long-running daemons, refreshes a token from
+// GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET and GOOGLE_REFRESH_TOKEN.
+func NewDrive(folder string) (*Drive, error) {
+	access := os.Getenv("GOOGLE_ACCESS_TOKEN")
+	conf := &oauth2.Config{
+		ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
+		ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+		Endpoint:     google.Endpoint,
+		Scopes:       []string{drive.DriveReadonlyScope},
+	}
+	refresh := os.Getenv("GOOGLE_REFRESH_TOKEN")
+
+	// Token refreshes are bounded like the calls they are made for
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: httpTimeout})
+	var src oauth2.TokenSource
+	switch {
+	case conf.ClientID != "" && conf.ClientSecret != "" && refresh != "":
+		src = conf.TokenSource(ctx, &oauth2.Token{RefreshToken: refresh})
+	case access != "":
+		src = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: access})
+	default:
+		return nil, errors.New("google drive: set GOOGLE_ACCESS_TOKEN, or GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET and GOOGLE_REFRESH_TOKEN")
+	}
+	client := &http.Client{Timeout: httpTimeout, Transport: &oauth2.Transport{Source: src}}
+	svc, err := drive.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("google drive: %w", err)
+	}
+	return &Drive{folder: folder, svc: svc}, nil
+}
+
+// Root implements Source.
+func (d *Drive) Root() string {
+	if d.folder == "" {
+		return SchemeDrive + "all/"
+	}
+	return SchemeDrive + d.folder + "/"
+}
+
+// Changes implements Source. Without a token every file is listed and the
+// feed's current position becomes the token.
+func (d *Drive) Changes(ctx context.Context, token string) (*Delta, error) {
+	if token == "" {
+		return d.listAll(ctx)
+	}
+	delta := &Delta{}
+	for {
+		var page *drive.ChangeList
+		err := retryDrive(ctx, func() (err error) {
+			page, err = d.svc.Changes.List(token).
+				PageSize(1000).
+				IncludeRemoved(true).
+				Spaces("drive").
+				Fields("nextPageToken,newStartPageToken,changes(fileId,removed,file(" + driveFileFields + "))").
+				Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("google drive: changes: %w", err)
+		}
+		for _, c := range page.Changes {
+			if c.Removed || c.File == nil {
+				delta.Changes = append(delta.Changes, Change{Name: d.Root() + c.FileId, Prefix: d.Root() + c.FileId + "/", Removed: true})
+				continue
+			}
+			if ch, ok := d.change(c.File); ok {
+				delta.Changes = append(delta.Changes, ch)
+			}
+		}
+		if page.NewStartPageToken != "" {
+			delta.Token = page.NewStartPageToken
+			return delta, nil
+		}
+		if page.NextPageToken == "" {
+			return nil, errors.New("google drive: changes feed ended without a new start token")
+		}
+		token = page.NextPageToken
+	}
+}
+
+// listAll lists every file of the source, taking the start token first so
+// that changes made while listing are seen by the next sync.
+func (d *Drive) listAll(ctx context.Context) (*Delta, error) {
+	var start *drive.StartPageToken
+	err := retryDrive(ctx, func() (err error) {
+		start, err = d.svc.Changes.GetStartPageToken().Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("google drive: start token: %w", err)
+	}
+	delta := &Delta{Token: start.StartPageToken, Full: true}
+	filter := "trashed = false and mimeType != '" + mimeFolder + "'"
+	if d.folder != "" {
+		filter += " and '" + strings.ReplaceAll(d.folder, "'", `\'`) + "' in parents"
+	}
+	pageToken := ""
+	for {
+		var page *drive.FileList
+		err := retryDrive(ctx, func() (err error) {
+			page, err = d.svc.Files.List().
+				Q(filter).
+				PageSize(1000).
+				Spaces("drive").
+				Fields("nextPageToken,files(" + driveFileFields + ")").
+				PageToken(pageToken).
+				Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("google drive: files: %w", err)
+		}
+		for _, f := range page.Files {
+			if ch, ok := d.change(f); ok {
+				delta.Changes = append(delta.Changes, ch)
+			}
+		}
+		if page.NextPageToken == "" {
+			return delta, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// change converts a file from the feed. Folders are left out; files that
+// were trashed or moved out of the folder count as removed.
+func (d *Drive) change(f *drive.File) (Change, bool) {
+	if f.MimeType == mimeFolder {
+		return Change{}, false
+	}
+	prefix := d.Root() + f.Id + "/"
+	c := Change{Name: prefix + f.Name, Prefix: prefix, Size: f.Size, id: f.Id}
+	// ModifiedTime is when anyone last modified the file.
+	if modified, err := time.Parse(time.RFC3339, f.ModifiedTime); err == nil {
+		c.Modified = modified
+	}
+	if f.Trashed || (d.folder != "" && !contains(f.Parents, d.folder)) {
+		c.Removed = true
+		return c, true
+	}
+	switch {
+	case f.MimeType == mimeGoogleDoc:
+		c.Ext, c.export = ".txt", true
+	case strings.HasPrefix(f.MimeType, mimeGoogleApp):
+		// Sheets, slides and the like have no text export the indexer reads.
+	default:
+		c.Ext = fileExt(f.Name)
+	}
+	// Only binary files have a checksum; Docs fall back to the file's
+	// version, which is unique only together with its id.
+	if f.Md5Checksum != "" {
+		c.Version = "gdrive:md5:" + f.Md5Checksum
+	} else {
+		c.Version = fmt.Sprintf("gdrive:%s@%d", f.Id, f.Version)
+	}
+	return c, true
+}
+
+// Download implements Source. Only getting the response is retried, not
+// reading it.
+func (d *Drive) Download(ctx context.Context, c Change, w io.Writer) error {
+	var resp *http.Response
+	err := retryDrive(ctx, func() (err error) {
+		if c.export {
+			resp, err = d.svc.Files.Export(c.id, "text/plain").Context(ctx).Download()
+		} else {
+			resp, err = d.svc.Files.Get(c.id).Context(ctx).Download()
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("google drive: download %s: %w", c.Name, err)
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// retryDrive calls call until it succeeds, fails in a way retrying will
+// not fix, or has been retried driveRetries times.
+func retryDrive(ctx context.Context, call func() error) error {
+	backoff := driveBackoff
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if err == nil || attempt >= driveRetries || ctx.Err() != nil || !retryableDrive(err) {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// retryableDrive reports whether a failed Drive call may succeed when
+// made again: it got no response, or a 429 or 5xx one. Drive answers rate
+// limits with some 403s too.
+func retryableDrive(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		var retrieveErr *oauth2.RetrieveError
+		return !errors.As(err, &retrieveErr)
+	}
+	if apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500 {
+		return true
+	}
+	for _, e := range apiErr.Errors {
+		if e.Reason == "rateLimitExceeded" || e.Reason == "userRateLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(xs []string, x string) bool {
+	for _, v := range xs {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
+
+// fileExt returns the lower-case extension of name, keeping .tar.gz whole.
+func fileExt(name string) string {
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, ".tar.gz") {
+		return ".tar.gz"
+	}
+	if i := strings.LastIndexByte(lower, '.'); i >= 0 && !strings.Contains(lower[i:], "/") {
+		return lower[i:]
+	}
+	return ""
+}
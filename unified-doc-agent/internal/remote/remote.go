@@ -0,0 +1,246 @@
+// Package remote keeps documents stored in Google Drive or S3 indexed. Each
+// sync asks the provider what changed since the last one and indexes,
+// re-indexes or removes just those files.
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/indexer"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ingestion"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// Source schemes.
+const (
+	SchemeDrive = "gdrive://"
+	SchemeS3    = "s3://"
+)
+
+// Change is a file that was added, changed or removed at the source.
+type Change struct {
+	// Name is what the file is indexed as, e.g. s3://bucket/key.
+	Name string
+	// Prefix, when set, starts every name the file was ever indexed
+	// under, so that the old name of a renamed file is removed with it.
+	Prefix string
+	// Ext is the extension the content is extracted as; "" for content
+	// that cannot be indexed.
+	Ext string
+	// Version identifies the content; it starts with the scheme.
+	Version string
+	Size    int64
+	// Modified is when the file was last modified, zero if unknown.
+	Modified time.Time
+	Removed  bool
+
+	// id is the provider's id of the file when it differs from the name.
+	id string
+	// export asks the provider for a text export instead of the content.
+	export bool
+}
+
+// Delta is what changed at a source since a token.
+type Delta struct {
+	Changes []Change
+	// Token is where the next sync starts.
+	Token string
+	// Full is set when Changes lists every file rather than the changes,
+	// so indexed files missing from it were deleted.
+	Full bool
+}
+
+// Source is a remote document store.
+type Source interface {
+	// Root starts the name of every file indexed from the source.
+	Root() string
+	// Changes returns the changes since token; "" lists every file.
+	Changes(ctx context.Context, token string) (*Delta, error)
+	// Download writes the content of a changed file to w.
+	Download(ctx context.Context, c Change, w io.Writer) error
+}
+
+// IsRemote reports whether a configured source is remote rather than a
+// local folder.
+func IsRemote(spec string) bool {
+	return strings.HasPrefix(spec, SchemeDrive) || strings.HasPrefix(spec, SchemeS3)
+}
+
+// Open returns the source configured as spec: gdrive:// for all of My
+// Drive, gdrive://<folder id> for the files directly in a folder, or
+// s3://<bucket>[/<prefix>]. Credentials come from the environment; see
+// NewDrive and NewS3.
+func Open(spec string) (Source, error) {
+	switch {
+	case strings.HasPrefix(spec, SchemeDrive):
+		return NewDrive(strings.Trim(strings.TrimPrefix(spec, SchemeDrive), "/"))
+	case strings.HasPrefix(spec, SchemeS3):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(spec, SchemeS3), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("remote source %q: missing bucket", spec)
+		}
+		return NewS3(bucket, prefix)
+	}
+	return nil, fmt.Errorf("remote source %q: expected gdrive:// or s3://", spec)
+}
+
+// httpTimeout bounds one request to a provider, downloads included.
+const httpTimeout = 10 * time.Minute
+
+// Sync brings the index up to date with the source configured as spec.
+// Only files whose version changed are downloaded. The change token is
+// saved once every change was applied, so a sync that is stopped or has
+// failures is retried from the same point. Closing stop ends it after the
+// current file.
+func Sync(ctx context.Context, spec string, stop <-chan struct{}) (*indexer.Result, error) {
+	src, err := Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	db := indexer.TenantOf(ctx).Store
+	token, err := db.SyncToken(spec)
+	if err != nil {
+		return nil, fmt.Errorf("load sync token: %w", err)
+	}
+	runID, err := indexer.StartRun(ctx, storage.RunSync, spec, spec)
+	if err != nil {
+		return nil, fmt.Errorf("record run: %w", err)
+	}
+	start := time.Now()
+	res := &indexer.Result{}
+	err = syncChanges(ctx, src, spec, token, stop, res)
+	res.DurationMS = time.Since(start).Milliseconds()
+	indexer.FinishRun(ctx, runID, res, err)
+	return res, err
+}
+
+// syncChanges applies the changes since token to the index, counting them
+// into res, and saves the next token when every change was applied.
+func syncChanges(ctx context.Context, src Source, spec, token string, stop <-chan struct{}, res *indexer.Result) error {
+	delta, err := src.Changes(ctx, token)
+	if err != nil {
+		return fmt.Errorf("list changes: %w", err)
+	}
+	res.Files = len(delta.Changes)
+	db := indexer.TenantOf(ctx).Store
+	present := map[string]bool{}
+	for _, c := range delta.Changes {
+		select {
+		case <-stop:
+			res.Interrupted = true
+			return nil
+		default:
+		}
+		if err := ctx.Err(); err != nil {
+			res.Interrupted = true
+			return err
+		}
+		if c.Removed {
+			n, err := remove(ctx, c.Prefix, c.Name, "")
+			res.Removed += n
+			if err != nil {
+				return fmt.Errorf("remove %s: %w", c.Name, err)
+			}
+			continue
+		}
+		if reason := ingestion.SkipReason("file"+c.Ext, c.Size); reason != "" {
+			res.Skipped++
+			res.Skips = append(res.Skips, indexer.FileIssue{File: c.Name, Reason: reason})
+			continue
+		}
+		present[c.Name] = true
+		fr, err := syncFile(ctx, src, spec, c)
+		res.Add(c.Name, fr, err)
+		if c.Prefix != "" && (err == nil || errors.Is(err, indexer.ErrUnchanged)) {
+			// Drop the names the file had before it was renamed.
+			n, err := remove(ctx, c.Prefix, "", c.Name)
+			res.Removed += n
+			if err != nil {
+				return fmt.Errorf("remove old names of %s: %w", c.Name, err)
+			}
+		}
+	}
+	if delta.Full {
+		indexed, err := db.IndexedFilesWithPrefix(src.Root())
+		if err != nil {
+			return err
+		}
+		for _, name := range indexed {
+			if present[name] {
+				continue
+			}
+			if err := indexer.RemoveFile(ctx, name); err != nil {
+				return fmt.Errorf("remove %s: %w", name, err)
+			}
+			res.Removed++
+		}
+	}
+	if res.Failed > 0 {
+		return nil
+	}
+	if err := db.SetSyncToken(spec, delta.Token); err != nil {
+		return fmt.Errorf("save sync token: %w", err)
+	}
+	return nil
+}
+
+// syncFile downloads and indexes a changed file unless its version is the
+// one already indexed.
+func syncFile(ctx context.Context, src Source, spec string, c Change) (*indexer.FileResult, error) {
+	prev, ok, err := indexer.TenantOf(ctx).Store.FileHash(c.Name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup version: %w", err)
+	}
+	if ok && prev == c.Version {
+		return nil, indexer.ErrUnchanged
+	}
+	f, err := os.CreateTemp("", "uda-remote-*"+c.Ext)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	err = src.Download(ctx, c, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	return indexer.IndexRemote(ctx, f.Name(), c.Name, c.Version, spec, c.Modified)
+}
+
+// remove removes the indexed files named name or, with a prefix, every
+// file under it except keep. It returns how many it removed.
+func remove(ctx context.Context, prefix, name, keep string) (int, error) {
+	db := indexer.TenantOf(ctx).Store
+	names := []string{name}
+	if prefix != "" {
+		var err error
+		if names, err = db.IndexedFilesWithPrefix(prefix); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for _, name := range names {
+		if name == keep {
+			continue
+		}
+		if _, ok, err := db.FileHash(name); err != nil || !ok {
+			if err != nil {
+				return n, err
+			}
+			continue
+		}
+		if err := indexer.RemoveFile(ctx, name); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
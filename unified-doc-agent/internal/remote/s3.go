@@ -0,0 +1,237 @@
+package remote
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptySHA256 is the hash of an empty request body.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3 syncs the objects under a prefix of an S3 bucket. S3 has no change
+// feed, so every sync lists the prefix and compares ETags with the indexed
+// versions; only new and changed objects are downloaded.
+type S3 struct {
+	bucket, prefix string
+	region         string
+	// endpoint, for S3-compatible stores, is addressed path-style.
+	endpoint string
+
+	accessKey, secretKey, sessionToken string
+	client                             *http.Client
+}
+
+// NewS3 returns the S3 source for a bucket and key prefix. It uses the
+// standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and
+// AWS_REGION variables, and AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL for
+// S3-compatible stores such as MinIO.
+func NewS3(bucket, prefix string) (*S3, error) {
+	s := &S3{
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		endpoint:     strings.TrimSuffix(firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"), "/"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: httpTimeout},
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("s3: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	return s, nil
+}
+
+func firstEnv(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Root implements Source.
+func (s *S3) Root() string {
+	return SchemeS3 + s.bucket + "/" + s.prefix
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		ETag         string    `xml:"ETag"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Changes implements Source. It always lists every object; the token is
+// unused.
+func (s *S3) Changes(ctx context.Context, _ string) (*Delta, error) {
+	delta := &Delta{Full: true}
+	q := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+	for {
+		resp, err := s.get(ctx, "", q)
+		if err != nil {
+			return nil, err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: list %s: %w", s.bucket, err)
+		}
+		for _, o := range page.Contents {
+			if strings.HasSuffix(o.Key, "/") {
+				continue // folder placeholder
+			}
+			delta.Changes = append(delta.Changes, Change{
+				Name:     SchemeS3 + s.bucket + "/" + o.Key,
+				Ext:      fileExt(o.Key),
+				Version:  "s3:" + strings.Trim(o.ETag, `"`),
+				Size:     o.Size,
+				Modified: o.LastModified,
+				id:       o.Key,
+			})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return delta, nil
+		}
+		q.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// Download implements Source.
+func (s *S3) Download(ctx context.Context, c Change, w io.Writer) error {
+	resp, err := s.get(ctx, c.id, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// get performs a signed GET of key, or of the bucket when key is "", and
+// fails on any status but 200.
+func (s *S3) get(ctx context.Context, key string, q url.Values) (*http.Response, error) {
+	host, path := s.bucket+".s3."+s.region+".amazonaws.com", "/"+uriEncode(key, false)
+	scheme := "https"
+	if s.endpoint != "" {
+		u, err := url.Parse(s.endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("s3: endpoint: %w", err)
+		}
+		scheme, host = u.Scheme, u.Host
+		path = "/" + uriEncode(s.bucket, true) + path
+	}
+	query := canonicalQuery(q)
+	u := scheme + "://" + host + path
+	if query != "" {
+		u += "?" + query
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, host, path, query, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3: GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 to a GET request without a body.
+func (s *S3) sign(req *http.Request, host, path, query string, now time.Time) {
+	amzDate, day := now.Format("20060102T150405Z"), now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	headers := map[string]string{"host": host, "x-amz-content-sha256": emptySHA256, "x-amz-date": amzDate}
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+		headers["x-amz-security-token"] = s.sessionToken
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{http.MethodGet, path, query, canonHeaders.String(), signed, emptySHA256}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes q sorted by key, as SigV4 requires.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes every byte but the unreserved characters and,
+// unless encodeSlash is set, '/'.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/config"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// caller is who a request comes from, as told by its bearer token.
+type caller struct {
+	// name identifies the caller, e.g. as a voter: the name of its API
+	// key, admin or anonymous.
+	name string
+	// admin is set for the admin token, whose bearer is trusted to pass
+	// on the access tags of the users it authenticated.
+	admin bool
+	// tags are the access tags the caller may see.
+	tags []string
+	// tenant is the tenant the caller's requests are scoped to.
+	tenant string
+}
+
+// bearer returns the bearer token of r, or "" without one.
+func bearer(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// isAdmin reports whether r bears the admin token.
+func (s *Server) isAdmin(r *http.Request) bool {
+	token := bearer(r)
+	return s.adminToken != "" && token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+// caller returns who r comes from. A request bearing an API key sees the
+// tags of the key in its tenant; one bearing the admin token sees those of
+// its AccessTagsHeader in the tenant of its TenantHeader. Without API keys
+// configured, requests bearing neither see only the untagged documents of
+// the default tenant. Otherwise, the error has been written to w.
+func (s *Server) caller(w http.ResponseWriter, r *http.Request) (*caller, bool) {
+	header, tenant := r.Header.Get(AccessTagsHeader), r.Header.Get(TenantHeader)
+	if s.isAdmin(r) {
+		tags := config.SplitTags(header)
+		if slices.Contains(tags, storage.AllTags) {
+			http.Error(w, AccessTagsHeader+" must not contain "+storage.AllTags, http.StatusBadRequest)
+			return nil, false
+		}
+		if tenant == "" {
+			tenant = storage.DefaultTenant
+		}
+		return &caller{name: "admin", admin: true, tags: tags, tenant: tenant}, true
+	}
+	if header != "" {
+		http.Error(w, AccessTagsHeader+" is only accepted with the admin token", http.StatusForbidden)
+		return nil, false
+	}
+
+	who, ok := s.keyCaller(w, r)
+	if !ok {
+		return nil, false
+	}
+	if tenant != "" && tenant != who.tenant {
+		http.Error(w, TenantHeader+" is only accepted with the admin token or naming the key's own tenant", http.StatusForbidden)
+		return nil, false
+	}
+	return who, true
+}
+
+// keyCaller returns the caller of r from the API key it bears.
+func (s *Server) keyCaller(w http.ResponseWriter, r *http.Request) (*caller, bool) {
+	token := bearer(r)
+	if token == "" && len(s.apiKeys) == 0 {
+		return &caller{name: "anonymous", tenant: storage.DefaultTenant}, true
+	}
+	// Compare with every key so that the time taken does not tell which
+	// one came close.
+	var found *config.APIKey
+	for i, k := range s.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(k.Key)) == 1 {
+			found = &s.apiKeys[i]
+		}
+	}
+	if found == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "API key required", http.StatusUnauthorized)
+		return nil, false
+	}
+	tenant := found.Tenant
+	if tenant == "" {
+		tenant = storage.DefaultTenant
+	}
+	return &caller{name: "key:" + found.Name, tags: found.Tags(), tenant: tenant}, true
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/indexer"
+)
+
+// Prometheus metrics
+var (
+	requestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "doc_agent_requests_total",
+			Help: "Total number of doc agent API requests",
+		},
+		[]string{"method", "endpoint", "status"},
+	)
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "doc_agent_request_duration_seconds",
+			Help: "Duration of doc agent API requests",
+		},
+		[]string{"method", "endpoint"},
+	)
+	reindexRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "doc_agent_reindex_runs_total",
+			Help: "Total number of scheduled source reconciliations",
+		},
+		[]string{"source", "status"},
+	)
+	reindexFiles = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "doc_agent_reindex_files_total",
+			Help: "Files seen by scheduled reconciliations, by outcome",
+		},
+		[]string{"source", "outcome"},
+	)
+	lastReindex = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "doc_agent_last_reindex_timestamp_seconds",
+			Help: "Unix time of the last completed reconciliation of a source",
+		},
+		[]string{"source"},
+	)
+	chunksInDB = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "doc_agent_chunks_in_database_total",
+			Help: "Total number of chunks in the database",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal)
+	prometheus.MustRegister(requestDuration)
+	prometheus.MustRegister(reindexRunsTotal)
+	prometheus.MustRegister(reindexFiles)
+	prometheus.MustRegister(lastReindex)
+	prometheus.MustRegister(chunksInDB)
+}
+
+// RecordReindex updates the reconciliation metrics for one run over source.
+func RecordReindex(source string, res *indexer.Result, err error) {
+	status := "completed"
+	switch {
+	case err != nil:
+		status = "failed"
+	case res.Interrupted:
+		status = "interrupted"
+	}
+	reindexRunsTotal.WithLabelValues(source, status).Inc()
+	if res == nil {
+		return
+	}
+	for outcome, n := range map[string]int{
+		"indexed":   res.Indexed,
+		"unchanged": res.Unchanged,
+		"duplicate": res.Duplicates,
+		"skipped":   res.Skipped,
+		"failed":    res.Failed,
+		"removed":   res.Removed,
+	} {
+		reindexFiles.WithLabelValues(source, outcome).Add(float64(n))
+	}
+	if status == "completed" {
+		lastReindex.WithLabelValues(source).SetToCurrentTime()
+	}
+}
+
+// SetChunkCount records the number of stored chunks.
+func SetChunkCount(n int) {
+	chunksInDB.Set(float64(n))
+}
+
+// statusRecorder captures the status code written by a handler. It keeps
+// the Flusher of the wrapped writer so streaming still works.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrument records request counts and durations by route template.
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		endpoint := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				endpoint = tmpl
+			}
+		}
+		requestsTotal.WithLabelValues(r.Method, endpoint, strconv.Itoa(rec.status)).Inc()
+		requestDuration.WithLabelValues(r.Method, endpoint).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,606 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/config"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/graph"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/indexer"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ollama"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// maxUploadSize caps multipart uploads on POST /index.
+const maxUploadSize = 100 << 20
+
+// AccessTagsHeader carries the caller's access tags, comma separated. It is
+// only accepted from an authenticating proxy in front of the agent bearing
+// the admin token; other callers get the tags of their API key.
+const AccessTagsHeader = "X-Access-Tags"
+
+// TenantHeader names the tenant a request is for. Like AccessTagsHeader it
+// is only accepted from a proxy bearing the admin token; other callers are
+// scoped to the tenant of their API key, storage.DefaultTenant without one.
+const TenantHeader = "X-Tenant-ID"
+
+// QueryRequest is the body of POST /query.
+type QueryRequest struct {
+	Query  string `json:"query"`
+	Stream bool   `json:"stream"`
+	// InjectionFilter and RedactPII turn on guardrails the config leaves
+	// off; turning off configured ones is refused.
+	InjectionFilter *bool `json:"injection_filter,omitempty"`
+	RedactPII       *bool `json:"redact_pii,omitempty"`
+	// Debug adds the run trace to the result and the run log. It requires
+	// the admin token.
+	Debug bool `json:"debug"`
+	// TopK, MinScore and MaxContextChars override the configured retrieval settings.
+	TopK            int     `json:"top_k,omitempty"`
+	MinScore        float64 `json:"min_score,omitempty"`
+	MaxContextChars int     `json:"max_context_chars,omitempty"`
+	// MinConfidence overrides the configured refusal threshold.
+	MinConfidence float64 `json:"min_confidence,omitempty"`
+	// Entities restricts retrieval to chunks mentioning all of them.
+	Entities []string `json:"entities,omitempty"`
+	// Collections restricts retrieval to these collections, searched
+	// separately and merged; CollectionWeights overrides the configured
+	// per-collection weights.
+	Collections       []string           `json:"collections,omitempty"`
+	CollectionWeights map[string]float64 `json:"collection_weights,omitempty"`
+	// Since and Until restrict retrieval to the files last modified in
+	// between, as for `agent query`; each is a date (2006-01-02) or an
+	// RFC 3339 time.
+	Since string `json:"since,omitempty"`
+	Until string `json:"until,omitempty"`
+	// RecencyHalfLifeDays overrides the configured recency weighting.
+	RecencyHalfLifeDays float64 `json:"recency_half_life_days,omitempty"`
+	// Mode picks the answer mode, as for `agent query -mode`; empty is auto.
+	Mode string `json:"mode,omitempty"`
+	// Style (bullet or narrative), Length (short or long) and Language
+	// shape the answer, as for `agent query`.
+	Style    string `json:"style,omitempty"`
+	Length   string `json:"length,omitempty"`
+	Language string `json:"language,omitempty"`
+	// AllowMixedEmbeddings searches the chunks of the query's embedding
+	// model even when others were embedded by other models or versions,
+	// which is refused otherwise.
+	AllowMixedEmbeddings bool `json:"allow_mixed_embeddings,omitempty"`
+}
+
+// IndexRequest is the JSON body of POST /index.
+type IndexRequest struct {
+	Path string `json:"path"`
+	// Resume continues an unfinished earlier run over the same path.
+	Resume bool `json:"resume"`
+}
+
+// FeedbackRequest is the body of POST /feedback.
+type FeedbackRequest struct {
+	Query   string `json:"query"`
+	ChunkID int    `json:"chunk_id"`
+	// Mark is helpful, unhelpful, pin or unpin.
+	Mark string `json:"mark"`
+}
+
+// Server exposes the doc agent over HTTP.
+type Server struct {
+	db        storage.Store
+	uploadDir string
+	// adminToken guards the /tenants routes, which are disabled when it
+	// is empty.
+	adminToken string
+	apiKeys    []config.APIKey
+	// roots are the folders POST /index may index paths in: the local
+	// sources and the upload dir.
+	roots []string
+}
+
+// New returns a server answering from db, each request scoped to the
+// tenant of its caller, and storing uploads in uploadDir. The admin_token of
+// cfg, if set, enables tenant provisioning for callers presenting it as a
+// bearer token; its api_keys authenticate the other callers.
+func New(db storage.Store, uploadDir string, cfg *config.Config) *Server {
+	s := &Server{db: db, uploadDir: uploadDir, adminToken: cfg.AdminToken, apiKeys: cfg.APIKeys}
+	for _, src := range append([]string{uploadDir}, cfg.Sources...) {
+		if strings.Contains(src, "://") {
+			continue
+		}
+		if root, err := filepath.Abs(src); err == nil {
+			if resolved, err := filepath.EvalSymlinks(root); err == nil {
+				root = resolved
+			}
+			s.roots = append(s.roots, root)
+		}
+	}
+	return s
+}
+
+// inRoots reports whether path, with symlinks resolved, is in one of the
+// roots of s.
+func (s *Server) inRoots(path string) bool {
+	for _, root := range s.roots {
+		if rel, err := filepath.Rel(root, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestTenant is the tenant a request is scoped to.
+type requestTenant struct {
+	*storage.Tenant
+	// db is the server's store scoped to the tenant.
+	db storage.Store
+}
+
+// tenant returns the tenant of who. If it is malformed or unknown, the
+// error has been written to w.
+func (s *Server) tenant(w http.ResponseWriter, who *caller) (*requestTenant, bool) {
+	id := who.tenant
+	if !storage.ValidTenantID(id) {
+		http.Error(w, "Invalid "+TenantHeader, http.StatusBadRequest)
+		return nil, false
+	}
+	t, err := s.db.GetTenant(id)
+	if errors.Is(err, storage.ErrTenantNotFound) {
+		http.Error(w, "Unknown tenant", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to load tenant", http.StatusInternalServerError)
+		return nil, false
+	}
+	return &requestTenant{Tenant: t, db: s.db.ForTenant(id)}, true
+}
+
+// indexContext returns ctx indexing into t with its embedding model.
+func (t *requestTenant) indexContext(ctx context.Context) context.Context {
+	return indexer.WithTenant(ctx, indexer.Tenant{ID: t.ID, EmbedModel: t.EmbedModel})
+}
+
+// Router returns the HTTP routes served by the agent.
+func (s *Server) Router() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/query", s.handleQuery).Methods("POST")
+	router.HandleFunc("/index", s.handleIndex).Methods("POST")
+	router.HandleFunc("/feedback", s.handleFeedback).Methods("POST")
+	router.HandleFunc("/docs", s.handleDocs).Methods("GET")
+	router.HandleFunc("/docs/chunks", s.handleDocChunks).Methods("GET")
+	router.HandleFunc("/tenants", s.requireAdmin(s.handleListTenants)).Methods("GET")
+	router.HandleFunc("/tenants", s.requireAdmin(s.handleCreateTenant)).Methods("POST")
+	router.HandleFunc("/tenants/{id}", s.requireAdmin(s.handleGetTenant)).Methods("GET")
+	router.HandleFunc("/tenants/{id}", s.requireAdmin(s.handleUpdateTenant)).Methods("PUT")
+	router.HandleFunc("/tenants/{id}", s.requireAdmin(s.handleDeleteTenant)).Methods("DELETE")
+	router.HandleFunc("/health", s.handleHealth).Methods("GET")
+	router.Handle("/metrics", promhttp.Handler())
+	router.Use(instrument)
+	return router
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var req QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		http.Error(w, "Query is required", http.StatusBadRequest)
+		return
+	}
+
+	who, ok := s.caller(w, r)
+	if !ok {
+		return
+	}
+	if req.Debug && !who.admin {
+		http.Error(w, "Debug requires the admin token", http.StatusForbidden)
+		return
+	}
+	tenant, ok := s.tenant(w, who)
+	if !ok {
+		return
+	}
+	mode, err := graph.ParseQueryMode(req.Mode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	style, err := graph.ParseAnswerStyle(req.Style, req.Length, req.Language)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for name, weight := range req.CollectionWeights {
+		if weight <= 0 {
+			http.Error(w, fmt.Sprintf("collection_weights[%s] must be positive", name), http.StatusBadRequest)
+			return
+		}
+	}
+	since, until, err := graph.ParseDateRange(req.Since, req.Until)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.RecencyHalfLifeDays < 0 {
+		http.Error(w, "recency_half_life_days must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	guard := graph.DefaultGuardrails
+	if (req.InjectionFilter != nil && !*req.InjectionFilter && guard.InjectionFilter) ||
+		(req.RedactPII != nil && !*req.RedactPII && guard.RedactPII) {
+		http.Error(w, "injection_filter and redact_pii may only turn guardrails on", http.StatusForbidden)
+		return
+	}
+	if req.InjectionFilter != nil && *req.InjectionFilter {
+		guard.InjectionFilter = true
+	}
+	if req.RedactPII != nil && *req.RedactPII {
+		guard.RedactPII = true
+	}
+	state := &graph.State{
+		Query:             req.Query,
+		DB:                graph.NewStore(tenant.db),
+		EmbedModel:        tenant.EmbedModel,
+		LLMModel:          tenant.LLMModel,
+		Guardrails:        &guard,
+		Debug:             req.Debug,
+		TopK:              req.TopK,
+		MinScore:          req.MinScore,
+		MinConfidence:     req.MinConfidence,
+		MaxContextChars:   req.MaxContextChars,
+		AccessTags:        who.tags,
+		QueryType:         mode,
+		Collections:       req.Collections,
+		CollectionWeights: req.CollectionWeights,
+		Since:             since,
+		Until:             until,
+		RecencyHalfLife:   time.Duration(req.RecencyHalfLifeDays * float64(24*time.Hour)),
+		Style:             style,
+
+		AllowMixedEmbeddings: req.AllowMixedEmbeddings,
+	}
+	for _, e := range req.Entities {
+		if e = processing.NormalizeEntity(e); e != "" {
+			state.Entities = append(state.Entities, e)
+		}
+	}
+
+	stream := req.Stream || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if !stream {
+		if err := graph.RunWorkflow(r.Context(), state); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, graph.ErrMixedEmbeddings) {
+				status = http.StatusConflict
+			}
+			http.Error(w, fmt.Sprintf("Query failed: %v", err), status)
+			return
+		}
+		writeJSONResponse(w, state.Result())
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// Under a confidence threshold, the answer is held back until it is
+	// known not to be refused, so that a summary of unrelated chunks never
+	// reaches the client
+	var held []string
+	hold := state.ConfidenceThreshold() > 0
+	state.OnToken = func(tok string) {
+		if hold {
+			held = append(held, tok)
+			return
+		}
+		writeSSE(w, "token", tok)
+		flusher.Flush()
+	}
+	if err := graph.RunWorkflow(r.Context(), state); err != nil {
+		writeSSE(w, "error", err.Error())
+		flusher.Flush()
+		return
+	}
+	if c := state.Confidence; len(held) > 0 && (c == nil || !c.Refused) {
+		writeSSE(w, "token", strings.Join(held, ""))
+	}
+	writeSSE(w, "result", state.Result())
+	flusher.Flush()
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	who, ok := s.caller(w, r)
+	if !ok {
+		return
+	}
+	tenant, ok := s.tenant(w, who)
+	if !ok {
+		return
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		s.handleUpload(w, r, tenant)
+		return
+	}
+
+	var req IndexRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Path == "" {
+		http.Error(w, "Path is required", http.StatusBadRequest)
+		return
+	}
+	if !who.admin {
+		http.Error(w, "Indexing a path requires the admin token; upload files instead", http.StatusForbidden)
+		return
+	}
+	path, err := filepath.Abs(req.Path)
+	if err == nil {
+		path, err = filepath.EvalSymlinks(path)
+	}
+	if err != nil {
+		http.Error(w, "Path not accessible", http.StatusBadRequest)
+		return
+	}
+	if !s.inRoots(path) {
+		http.Error(w, "Path must be in a configured source folder or the upload dir", http.StatusForbidden)
+		return
+	}
+
+	res, err := indexer.IndexPath(tenant.indexContext(r.Context()), req.Path, nil, indexer.Options{Resume: req.Resume})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Indexing failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, res)
+}
+
+// handleUpload stores an uploaded file and indexes it for tenant. Uploads
+// of the default tenant go in uploadDir, those of the others in a folder
+// of uploadDir named after them. An upload named as an earlier one is
+// refused unless the form sets replace to true, so that two files that
+// happen to share a name do not overwrite each other.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request, tenant *requestTenant) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "A multipart \"file\" field is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	dir := s.uploadDir
+	if tenant.ID != storage.DefaultTenant {
+		dir = filepath.Join(dir, tenant.ID)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		http.Error(w, "Failed to prepare upload dir", http.StatusInternalServerError)
+		return
+	}
+	dst := filepath.Join(dir, filepath.Base(header.Filename))
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if r.FormValue("replace") == "true" {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	out, err := os.OpenFile(dst, flags, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		http.Error(w, fmt.Sprintf("An upload named %s exists; set replace to true to replace it", filepath.Base(dst)), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to store upload", http.StatusInternalServerError)
+		return
+	}
+	if _, err := io.Copy(out, file); err != nil {
+		out.Close()
+		http.Error(w, "Failed to store upload", http.StatusInternalServerError)
+		return
+	}
+	out.Close()
+
+	fr, err := indexer.IndexFile(tenant.indexContext(r.Context()), dst, "upload")
+	if errors.Is(err, indexer.ErrUnchanged) {
+		writeJSONResponse(w, indexer.Result{Files: 1, Unchanged: 1})
+		return
+	}
+	if errors.Is(err, indexer.ErrDuplicate) {
+		writeJSONResponse(w, indexer.Result{Files: 1, Duplicates: 1, DuplicateFiles: []indexer.FileIssue{
+			{File: dst, Reason: "duplicate of " + fr.DuplicateOf},
+		}})
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Indexing failed: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	res := indexer.Result{Files: 1, Indexed: 1, Chunks: fr.Chunks}
+	for _, warning := range fr.Warnings {
+		res.Warnings = append(res.Warnings, indexer.FileIssue{File: dst, Reason: warning})
+	}
+	writeJSONResponse(w, res)
+}
+
+func (s *Server) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	var req FeedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" || req.ChunkID <= 0 {
+		http.Error(w, "Query and chunk_id are required", http.StatusBadRequest)
+		return
+	}
+	switch req.Mark {
+	case storage.FeedbackHelpful, storage.FeedbackUnhelpful, storage.FeedbackPin, storage.FeedbackUnpin:
+	default:
+		http.Error(w, "Mark must be helpful, unhelpful, pin or unpin", http.StatusBadRequest)
+		return
+	}
+	who, ok := s.caller(w, r)
+	if !ok {
+		return
+	}
+	// Pins apply to everyone asking the query
+	if (req.Mark == storage.FeedbackPin || req.Mark == storage.FeedbackUnpin) && !who.admin {
+		http.Error(w, "Pinning requires the admin token", http.StatusForbidden)
+		return
+	}
+	tenant, ok := s.tenant(w, who)
+	if !ok {
+		return
+	}
+
+	err := tenant.db.AddFeedback(req.Query, req.ChunkID, req.Mark, who.name, who.tags)
+	if errors.Is(err, storage.ErrChunkNotFound) {
+		http.Error(w, "Chunk not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to record feedback", http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, map[string]string{"status": "recorded"})
+}
+
+// handleDocs lists the indexed documents the caller may see. With since, an
+// RFC 3339 time or a date, only those indexed from then on are listed.
+func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			if since, err = time.Parse("2006-01-02", v); err != nil {
+				http.Error(w, "since must be an RFC 3339 time or a YYYY-MM-DD date", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	who, ok := s.caller(w, r)
+	if !ok {
+		return
+	}
+	tenant, ok := s.tenant(w, who)
+	if !ok {
+		return
+	}
+	docs, err := tenant.db.ListDocuments(who.tags)
+	if err != nil {
+		http.Error(w, "Failed to list documents", http.StatusInternalServerError)
+		return
+	}
+	if !since.IsZero() {
+		recent := docs[:0]
+		for _, d := range docs {
+			if d.IndexedAt != nil && !d.IndexedAt.Before(since) {
+				recent = append(recent, d)
+			}
+		}
+		docs = recent
+	}
+	if docs == nil {
+		docs = []storage.IndexedFile{}
+	}
+	writeJSONResponse(w, map[string]interface{}{"documents": docs})
+}
+
+// DocChunk is a chunk of a document as listed by GET /docs/chunks.
+type DocChunk struct {
+	ID      int    `json:"id"`
+	Page    int    `json:"page,omitempty"`
+	Content string `json:"content"`
+}
+
+// handleDocChunks returns the text of an indexed document, chunk by chunk
+// in reading order, for callers working through a whole document rather
+// than querying it. filename is the indexed path or its last elements,
+// such as the base name, as long as only one document matches.
+func (s *Server) handleDocChunks(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(r.URL.Query().Get("filename"), "/")
+	if name == "" {
+		http.Error(w, "filename is required", http.StatusBadRequest)
+		return
+	}
+	who, ok := s.caller(w, r)
+	if !ok {
+		return
+	}
+	tenant, ok := s.tenant(w, who)
+	if !ok {
+		return
+	}
+
+	docs, err := tenant.db.FileChunks(name, who.tags)
+	if err != nil {
+		http.Error(w, "Failed to load document", http.StatusInternalServerError)
+		return
+	}
+	if len(docs) == 0 {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return
+	}
+	if first, last := docs[0].Filename, docs[len(docs)-1].Filename; first != last {
+		http.Error(w, fmt.Sprintf("filename matches several documents, such as %s and %s", first, last), http.StatusConflict)
+		return
+	}
+
+	chunks := make([]DocChunk, len(docs))
+	for i, d := range docs {
+		chunks[i] = DocChunk{ID: d.ID, Page: d.Page, Content: d.Content}
+	}
+	writeJSONResponse(w, map[string]interface{}{
+		"filename":   docs[0].Filename,
+		"source":     docs[0].Source,
+		"collection": docs[0].Collection,
+		"chunks":     chunks,
+	})
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if err := s.db.Ping(ctx); err != nil {
+		http.Error(w, "Database connection failed", http.StatusServiceUnavailable)
+		return
+	}
+	if err := ollama.Ping(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSONResponse(w, map[string]string{"status": "healthy"})
+}
+
+func writeSSE(w io.Writer, event string, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("sse marshal: %v", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+}
+
+func writeJSONResponse(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/ollama"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/processing"
+	"github.com/Divas-Gupta30/mcp/unified-doc-agent/internal/storage"
+)
+
+// TenantRequest is the body of POST /tenants and PUT /tenants/{id}. ID is
+// only read on creation.
+type TenantRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// EmbedModel and LLMModel override the configured models for the
+	// tenant; empty uses the configured ones.
+	EmbedModel string `json:"embed_model"`
+	LLMModel   string `json:"llm_model"`
+}
+
+// requireAdmin lets through requests bearing the admin token.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			http.Error(w, "Tenant administration is disabled: set admin_token", http.StatusForbidden)
+			return
+		}
+		if !s.isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Admin token required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleListTenants(w http.ResponseWriter, r *http.Request) {
+	tenants, err := s.db.Tenants()
+	if err != nil {
+		http.Error(w, "Failed to list tenants", http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, map[string]interface{}{"tenants": tenants})
+}
+
+func (s *Server) handleCreateTenant(w http.ResponseWriter, r *http.Request) {
+	var req TenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !storage.ValidTenantID(req.ID) {
+		http.Error(w, "id must be 1 to 63 lower-case letters, digits, '-' or '_', starting with a letter or digit", http.StatusBadRequest)
+		return
+	}
+	if err := checkModels(r.Context(), req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		req.Name = req.ID
+	}
+
+	t, err := s.db.CreateTenant(storage.Tenant{ID: req.ID, Name: req.Name, EmbedModel: req.EmbedModel, LLMModel: req.LLMModel})
+	if errors.Is(err, storage.ErrTenantExists) {
+		http.Error(w, "Tenant already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create tenant", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+func (s *Server) handleGetTenant(w http.ResponseWriter, r *http.Request) {
+	t, err := s.db.GetTenant(mux.Vars(r)["id"])
+	if errors.Is(err, storage.ErrTenantNotFound) {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load tenant", http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, t)
+}
+
+func (s *Server) handleUpdateTenant(w http.ResponseWriter, r *http.Request) {
+	var req TenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := checkModels(r.Context(), req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if req.Name == "" {
+		req.Name = id
+	}
+
+	t, err := s.db.UpdateTenant(storage.Tenant{ID: id, Name: req.Name, EmbedModel: req.EmbedModel, LLMModel: req.LLMModel})
+	if errors.Is(err, storage.ErrTenantNotFound) {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update tenant", http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, t)
+}
+
+// handleDeleteTenant deletes a tenant with everything indexed for it. Its
+// uploaded files are left in the upload dir.
+func (s *Server) handleDeleteTenant(w http.ResponseWriter, r *http.Request) {
+	err := s.db.DeleteTenant(mux.Vars(r)["id"])
+	switch {
+	case errors.Is(err, storage.ErrTenantNotFound):
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+	case errors.Is(err, storage.ErrDefaultTenant):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, "Failed to delete tenant", http.StatusInternalServerError)
+	default:
+		writeJSONResponse(w, map[string]string{"status": "deleted"})
+	}
+}
+
+// checkModels checks that the models of req are installed and that its
+// embedding model fits the vectors already stored.
+func checkModels(ctx context.Context, req TenantRequest) error {
+	if req.EmbedModel != "" {
+		dim, err := processing.ProbeDimension(ctx, req.EmbedModel)
+		if err != nil {
+			return fmt.Errorf("embed_model: %w", err)
+		}
+		if dim != processing.EmbeddingDim {
+			return fmt.Errorf("embed_model %s produces %d-dimensional vectors, the index stores %d", req.EmbedModel, dim, processing.EmbeddingDim)
+		}
+	}
+	if req.LLMModel != "" {
+		if err := ollama.Ping(ctx, req.LLMModel); err != nil {
+			return fmt.Errorf("llm_model: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package storage
+
+import "time"
+
+// The functions below run on the Default store, for callers that use one
+// database for the life of the process.
+
+// InsertEmbedding calls InsertEmbedding on the Default store.
+func InsertEmbedding(c ChunkRecord) error {
+	return Default().InsertEmbedding(c)
+}
+
+// InsertChunks calls InsertChunks on the Default store.
+func InsertChunks(chunks []ChunkRecord) error {
+	return Default().InsertChunks(chunks)
+}
+
+// QuerySimilar calls QuerySimilar on the Default store.
+func QuerySimilar(queryEmb []float32, topK int, models, allowed, entities, collections, prefixes []string, since, until time.Time) ([]Document, error) {
+	return Default().QuerySimilar(queryEmb, topK, models, allowed, entities, collections, prefixes, since, until)
+}
+
+// EmbeddingModels calls EmbeddingModels on the Default store.
+func EmbeddingModels(allowed, collections []string) ([]EmbeddingUse, error) {
+	return Default().EmbeddingModels(allowed, collections)
+}
+
+// ListDocuments calls ListDocuments on the Default store.
+func ListDocuments(allowed []string) ([]IndexedFile, error) {
+	return Default().ListDocuments(allowed)
+}
+
+// FileHash calls FileHash on the Default store.
+func FileHash(filename string) (hash string, ok bool, err error) {
+	return Default().FileHash(filename)
+}
+
+// RecordFile calls RecordFile on the Default store.
+func RecordFile(f FileRecord) error {
+	return Default().RecordFile(f)
+}
+
+// SetLabels calls SetLabels on the Default store.
+func SetLabels(filename, entryPrefix string, tags []string, collection string) error {
+	return Default().SetLabels(filename, entryPrefix, tags, collection)
+}
+
+// SetModified calls SetModified on the Default store.
+func SetModified(filename, entryPrefix string, modified time.Time) error {
+	return Default().SetModified(filename, entryPrefix, modified)
+}
+
+// FileSignatures calls FileSignatures on the Default store.
+func FileSignatures(tags []string) ([]FileSignature, error) {
+	return Default().FileSignatures(tags)
+}
+
+// DeleteChunks calls DeleteChunks on the Default store.
+func DeleteChunks(filename string) error {
+	return Default().DeleteChunks(filename)
+}
+
+// IndexedFilesWithPrefix calls IndexedFilesWithPrefix on the Default store.
+func IndexedFilesWithPrefix(prefix string) ([]string, error) {
+	return Default().IndexedFilesWithPrefix(prefix)
+}
+
+// DeleteFile calls DeleteFile on the Default store.
+func DeleteFile(filename string) error {
+	return Default().DeleteFile(filename)
+}
+
+// DeleteFilesWithPrefix calls DeleteFilesWithPrefix on the Default store.
+func DeleteFilesWithPrefix(prefix string) error {
+	return Default().DeleteFilesWithPrefix(prefix)
+}
+
+// AddFeedback calls AddFeedback on the Default store.
+func AddFeedback(query string, chunkID int, mark, voter string, allowed []string) error {
+	return Default().AddFeedback(query, chunkID, mark, voter, allowed)
+}
+
+// FeedbackVotes calls FeedbackVotes on the Default store.
+func FeedbackVotes(ids []int) (map[int]int, error) {
+	return Default().FeedbackVotes(ids)
+}
+
+// PinnedChunks calls PinnedChunks on the Default store.
+func PinnedChunks(query string, allowed []string) ([]Document, error) {
+	return Default().PinnedChunks(query, allowed)
+}
+
+// GraphEdges calls GraphEdges on the Default store.
+func GraphEdges(nodes []string, chunkIDs []int, files []string, allowed []string, limit int) ([]GraphEdge, error) {
+	return Default().GraphEdges(nodes, chunkIDs, files, allowed, limit)
+}
+
+// ChunksByID calls ChunksByID on the Default store.
+func ChunksByID(ids []int, allowed []string) ([]Document, error) {
+	return Default().ChunksByID(ids, allowed)
+}
+
+// EnsureSchema calls EnsureSchema on the Default store.
+func EnsureSchema() error {
+	return Default().EnsureSchema()
+}
+
+// StoredTexts calls StoredTexts on the Default store.
+func StoredTexts(prefix string) ([]string, error) {
+	return Default().StoredTexts(prefix)
+}
+
+// FilesWithoutText calls FilesWithoutText on the Default store.
+func FilesWithoutText(prefix string) ([]string, error) {
+	return Default().FilesWithoutText(prefix)
+}
+
+// LoadText calls LoadText on the Default store.
+func LoadText(filename string) (*FileText, error) {
+	return Default().LoadText(filename)
+}
+
+// ReplaceChunks calls ReplaceChunks on the Default store.
+func ReplaceChunks(filename string, chunks []ChunkRecord) error {
+	return Default().ReplaceChunks(filename, chunks)
+}
+
+// RecountChunks calls RecountChunks on the Default store.
+func RecountChunks(entrySep string) error {
+	return Default().RecountChunks(entrySep)
+}
+
+// StartIndexRun calls StartIndexRun on the Default store.
+func StartIndexRun(r IndexRun) (int, error) {
+	return Default().StartIndexRun(r)
+}
+
+// ResumeIndexRun calls ResumeIndexRun on the Default store.
+func ResumeIndexRun(id int) error {
+	return Default().ResumeIndexRun(id)
+}
+
+// CheckpointIndexRun calls CheckpointIndexRun on the Default store.
+func CheckpointIndexRun(id int, file string, done int) error {
+	return Default().CheckpointIndexRun(id, file, done)
+}
+
+// FinishIndexRun calls FinishIndexRun on the Default store.
+func FinishIndexRun(id int, status string, counts RunCounts, runErr string, report interface{}) error {
+	return Default().FinishIndexRun(id, status, counts, runErr, report)
+}
+
+// LastUnfinishedRun calls LastUnfinishedRun on the Default store.
+func LastUnfinishedRun(root string) (*IndexRun, error) {
+	return Default().LastUnfinishedRun(root)
+}
+
+// IndexRuns calls IndexRuns on the Default store.
+func IndexRuns(limit int) ([]IndexRun, error) {
+	return Default().IndexRuns(limit)
+}
+
+// GetIndexRun calls GetIndexRun on the Default store.
+func GetIndexRun(id int) (*IndexRun, error) {
+	return Default().GetIndexRun(id)
+}
+
+// SyncToken calls SyncToken on the Default store.
+func SyncToken(source string) (string, error) {
+	return Default().SyncToken(source)
+}
+
+// SetSyncToken calls SetSyncToken on the Default store.
+func SetSyncToken(source, token string) error {
+	return Default().SetSyncToken(source, token)
+}
+
+// StoreFile calls StoreFile on the Default store.
+func StoreFile(filename string, chunks []ChunkRecord, text *FileText, rec *FileRecord) error {
+	return Default().StoreFile(filename, chunks, text, rec)
+}
+
+// ActiveEmbedding calls ActiveEmbedding on the Default store.
+func ActiveEmbedding() (model string, dim int, ok bool, err error) {
+	return Default().ActiveEmbedding()
+}
+
+// SetActiveEmbedding calls SetActiveEmbedding on the Default store.
+func SetActiveEmbedding(model string, dim int) error {
+	return Default().SetActiveEmbedding(model, dim)
+}
+
+// CountChunks calls CountChunks on the Default store.
+func CountChunks() (int, error) {
+	return Default().CountChunks()
+}
+
+// PrepareNextEmbedding calls PrepareNextEmbedding on the Default store.
+func PrepareNextEmbedding(dim int) error {
+	return Default().PrepareNextEmbedding(dim)
+}
+
+// ChunksAfter calls ChunksAfter on the Default store.
+func ChunksAfter(afterID, limit int) ([]Document, error) {
+	return Default().ChunksAfter(afterID, limit)
+}
+
+// SetNextEmbedding calls SetNextEmbedding on the Default store.
+func SetNextEmbedding(id int, embedding []float32) error {
+	return Default().SetNextEmbedding(id, embedding)
+}
+
+// SetNextEmbeddings calls SetNextEmbeddings on the Default store.
+func SetNextEmbeddings(ids []int, embeddings [][]float32) error {
+	return Default().SetNextEmbeddings(ids, embeddings)
+}
+
+// SwapEmbeddings calls SwapEmbeddings on the Default store.
+func SwapEmbeddings(model, version string, dim int) error {
+	return Default().SwapEmbeddings(model, version, dim)
+}
+
+// DropNextEmbedding calls DropNextEmbedding on the Default store.
+func DropNextEmbedding() error {
+	return Default().DropNextEmbedding()
+}
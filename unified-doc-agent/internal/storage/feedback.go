@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Feedback marks.
+const (
+	FeedbackHelpful   = "helpful"
+	FeedbackUnhelpful = "unhelpful"
+	FeedbackPin       = "pin"
+	FeedbackUnpin     = "unpin"
+)
+
+// ErrChunkNotFound is returned for feedback on a chunk that does not exist
+// or that the caller's access tags do not cover.
+var ErrChunkNotFound = errors.New("chunk not found")
+
+// maxQueryVotes caps the net votes a chunk gets from the feedback on one
+// query, so that voting on a query again and again does not outweigh the
+// feedback on the others.
+const maxQueryVotes = 1
+
+// normalizeQuery makes pins match regardless of case and spacing.
+func normalizeQuery(q string) string {
+	return strings.Join(strings.Fields(strings.ToLower(q)), " ")
+}
+
+// AddFeedback records mark for chunkID as a result for query. A voter has
+// one vote per chunk and query, which a later helpful or unhelpful mark
+// replaces. Feedback is dropped with the chunk, so re-indexing a changed
+// file starts it afresh.
+func (s *PgStore) AddFeedback(query string, chunkID int, mark, voter string, allowed []string) error {
+	args := []any{chunkID, normalizeQuery(query), tagsOrEmpty(allowed), s.tenant}
+	var sql string
+	switch mark {
+	case FeedbackHelpful, FeedbackUnhelpful:
+		sql = `INSERT INTO chunk_feedback (chunk_id, query, helpful, voter)
+			SELECT id, $2, ` + fmt.Sprint(mark == FeedbackHelpful) + `, $5 FROM documents WHERE id = $1 AND tenant_id = $4 AND ` + accessFilter(3) + `
+			ON CONFLICT (chunk_id, query, voter) DO UPDATE SET helpful = EXCLUDED.helpful, created_at = CURRENT_TIMESTAMP`
+		args = append(args, voter)
+	case FeedbackPin:
+		// Re-pinning keeps the original pin so the order of pins is stable.
+		sql = `INSERT INTO pinned_chunks (query, chunk_id)
+			SELECT $2, id FROM documents WHERE id = $1 AND tenant_id = $4 AND ` + accessFilter(3) + `
+			ON CONFLICT (query, chunk_id) DO UPDATE SET created_at = pinned_chunks.created_at`
+	case FeedbackUnpin:
+		sql = `DELETE FROM pinned_chunks WHERE chunk_id = $1 AND query = $2
+			AND chunk_id IN (SELECT id FROM documents WHERE tenant_id = $4 AND ` + accessFilter(3) + `)`
+	default:
+		return fmt.Errorf("unknown feedback %q (expected helpful, unhelpful, pin or unpin)", mark)
+	}
+	tag, err := s.pool.Exec(context.Background(), sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrChunkNotFound
+	}
+	return nil
+}
+
+// FeedbackVotes returns the net helpful minus unhelpful votes of each of
+// ids that has any, across all queries, each query counting for at most
+// maxQueryVotes either way.
+func (s *PgStore) FeedbackVotes(ids []int) (map[int]int, error) {
+	rows, err := s.pool.Query(context.Background(), `
+		SELECT chunk_id, SUM(votes)::int FROM (
+			SELECT f.chunk_id, GREATEST(-$3::int, LEAST($3::int, SUM(CASE WHEN f.helpful THEN 1 ELSE -1 END))) AS votes
+			FROM chunk_feedback f JOIN documents d ON d.id = f.chunk_id
+			WHERE f.chunk_id = ANY($1) AND d.tenant_id = $2 GROUP BY f.chunk_id, f.query
+		) q GROUP BY chunk_id`, ids, s.tenant, maxQueryVotes)
+	if err != nil {
+		return nil, fmt.Errorf("load feedback failed: %w", err)
+	}
+	defer rows.Close()
+
+	votes := map[int]int{}
+	for rows.Next() {
+		var id, n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		votes[id] = n
+	}
+	return votes, rows.Err()
+}
+
+// PinnedChunks returns the chunks pinned for query that the allowed access
+// tags may see, oldest pin first.
+func (s *PgStore) PinnedChunks(query string, allowed []string) ([]Document, error) {
+	rows, err := s.pool.Query(context.Background(), `
+		SELECT d.id, d.filename, d.source, d.content, d.page, d.language, d.collection, d.start_offset, d.end_offset
+		FROM pinned_chunks p JOIN documents d ON d.id = p.chunk_id
+		WHERE p.query = $1 AND d.tenant_id = $3 AND `+accessFilter(2)+`
+		ORDER BY p.created_at, d.id`, normalizeQuery(query), tagsOrEmpty(allowed), s.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("load pins failed: %w", err)
+	}
+	defer rows.Close()
+
+	var results []Document
+	for rows.Next() {
+		var doc Document
+		if err := rows.Scan(&doc.ID, &doc.Filename, &doc.Source, &doc.Content, &doc.Page, &doc.Language, &doc.Collection, &doc.Start, &doc.End); err != nil {
+			return nil, err
+		}
+		results = append(results, doc)
+	}
+	return results, rows.Err()
+}
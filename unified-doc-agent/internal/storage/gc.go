@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// The queries of `agent gc`. A chunk or stored text belongs to the
+// recorded file of its name or, for an archive entry, of the part of its
+// name before the entry separator; those of no recorded file are orphans,
+// left by interrupted runs or files removed by earlier versions. Sizes are
+// those of the rows, without indexes and free space.
+
+// FileUsage is the space a file's chunks and stored text take.
+type FileUsage struct {
+	Filename   string `json:"filename"`
+	Source     string `json:"source"`
+	Collection string `json:"collection,omitempty"`
+	Chunks     int    `json:"chunks"`
+	// Bytes counts its chunks, and its stored text unless other files
+	// share it.
+	Bytes int64 `json:"bytes"`
+}
+
+// ownedTexts are the stored texts by the file they belong to, with their
+// size when no other file shares them
+const ownedTexts = `SELECT split_part(f.filename, $2, 1) AS owner, f.tenant_id, f.filename, f.source,
+		CASE WHEN EXISTS (SELECT 1 FROM raw_files o WHERE o.content_hash = f.content_hash
+			AND (o.tenant_id <> f.tenant_id OR o.filename <> f.filename)) THEN 0
+		ELSE octet_length(r.data) END AS bytes
+	FROM raw_files f JOIN documents_raw r ON r.content_hash = f.content_hash
+	WHERE f.tenant_id = $1`
+
+// unrecorded is true of the rows of table alias t belonging to no
+// recorded file
+const unrecorded = `NOT EXISTS (SELECT 1 FROM indexed_files i
+	WHERE i.tenant_id = t.tenant_id AND i.filename = split_part(t.filename, $2, 1))`
+
+// RecordedFiles returns every recorded file with the space it takes,
+// entrySep separating the name of an archive from those of its entries.
+func (s *PgStore) RecordedFiles(entrySep string) ([]FileUsage, error) {
+	return s.fileUsage(`
+		WITH chunks AS (
+			SELECT split_part(d.filename, $2, 1) AS owner, COUNT(*) AS chunks, SUM(pg_column_size(d.*)) AS bytes
+			FROM documents d WHERE d.tenant_id = $1 GROUP BY 1
+		), texts AS (
+			SELECT owner, SUM(bytes) AS bytes FROM (`+ownedTexts+`) t GROUP BY owner
+		)
+		SELECT i.filename, i.source, i.collection, COALESCE(c.chunks, 0), COALESCE(c.bytes, 0) + COALESCE(t.bytes, 0)
+		FROM indexed_files i
+		LEFT JOIN chunks c ON c.owner = i.filename
+		LEFT JOIN texts t ON t.owner = i.filename
+		WHERE i.tenant_id = $1 ORDER BY i.filename`, s.tenant, entrySep)
+}
+
+// OrphanChunks returns the chunks of no recorded file, by name.
+func (s *PgStore) OrphanChunks(entrySep string) ([]FileUsage, error) {
+	return s.fileUsage(`
+		SELECT t.filename, MAX(t.source), MAX(t.collection), COUNT(*), SUM(pg_column_size(t.*))
+		FROM documents t WHERE t.tenant_id = $1 AND `+unrecorded+`
+		GROUP BY t.filename ORDER BY t.filename`, s.tenant, entrySep)
+}
+
+// OrphanTexts returns the stored texts of no recorded file, by name.
+func (s *PgStore) OrphanTexts(entrySep string) ([]FileUsage, error) {
+	return s.fileUsage(`
+		SELECT t.filename, t.source, '', 0, t.bytes FROM (`+ownedTexts+`) t
+		WHERE `+unrecorded+` ORDER BY t.filename`, s.tenant, entrySep)
+}
+
+// UnusedTexts returns how many stored texts no file of any tenant refers
+// to, and their size.
+func (s *PgStore) UnusedTexts() (n int, bytes int64, err error) {
+	err = s.pool.QueryRow(context.Background(), `SELECT COUNT(*), COALESCE(SUM(octet_length(data)), 0) FROM documents_raw r
+		WHERE NOT EXISTS (SELECT 1 FROM raw_files f WHERE f.content_hash = r.content_hash)`).Scan(&n, &bytes)
+	return n, bytes, err
+}
+
+func (s *PgStore) fileUsage(query string, args ...interface{}) ([]FileUsage, error) {
+	rows, err := s.pool.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []FileUsage
+	for rows.Next() {
+		var f FileUsage
+		if err := rows.Scan(&f.Filename, &f.Source, &f.Collection, &f.Chunks, &f.Bytes); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// DeleteOrphans removes the chunks and stored texts of no recorded file,
+// and the stored texts no file refers to, in one transaction.
+func (s *PgStore) DeleteOrphans(entrySep string) error {
+	defer s.uses.invalidate()
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM documents t WHERE t.tenant_id = $1 AND "+unrecorded, s.tenant, entrySep); err != nil {
+		return err
+	}
+	if _, err := deleteRawFiles(ctx, tx, "t.tenant_id = $1 AND "+unrecorded, s.tenant, entrySep); err != nil {
+		return err
+	}
+	// Unlike other deletions, collect every text no file refers to,
+	// whatever left it behind.
+	if _, err := tx.Exec(ctx, `DELETE FROM documents_raw r
+		WHERE NOT EXISTS (SELECT 1 FROM raw_files f WHERE f.content_hash = r.content_hash)`); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// RunRoots returns the roots of the index runs of files from source.
+func (s *PgStore) RunRoots(source string) ([]string, error) {
+	return s.filenames("SELECT DISTINCT root FROM index_runs WHERE tenant_id = $1 AND source = $2 ORDER BY root", s.tenant, source)
+}
+
+// Vacuum vacuums and analyzes the tables removed files leave dead rows
+// in, so that Postgres reuses their space.
+func (s *PgStore) Vacuum() error {
+	// VACUUM cannot run in the implicit transaction of a prepared statement
+	_, err := s.pool.Exec(context.Background(),
+		"VACUUM (ANALYZE) documents, indexed_files, raw_files, documents_raw, kg_triples, chunk_feedback, pinned_chunks",
+		pgx.QueryExecModeSimpleProtocol)
+	return err
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// Triple is a (subject, relation, object) fact of the knowledge graph.
+type Triple struct {
+	Subject  string `json:"subject"`
+	Relation string `json:"relation"`
+	Object   string `json:"object"`
+}
+
+// triplesOrEmpty keeps chunks without triples from being stored as NULL.
+func triplesOrEmpty(t []Triple) []Triple {
+	if t == nil {
+		return []Triple{}
+	}
+	return t
+}
+
+// GraphEdge is a stored triple together with the chunk it came from.
+type GraphEdge struct {
+	Triple
+	ChunkID  int
+	Filename string
+}
+
+// GraphEdges returns up to limit triples that the allowed access tags may
+// see and that either touch one of nodes, come from one of chunkIDs, or
+// come from a file whose name is, or ends in /, one of files.
+func (s *PgStore) GraphEdges(nodes []string, chunkIDs []int, files []string, allowed []string, limit int) ([]GraphEdge, error) {
+	if nodes == nil {
+		nodes = []string{}
+	}
+	if chunkIDs == nil {
+		chunkIDs = []int{}
+	}
+	if files == nil {
+		files = []string{}
+	}
+	rows, err := s.pool.Query(context.Background(), `
+		SELECT t.subject, t.relation, t.object, t.chunk_id, d.filename
+		FROM kg_triples t JOIN documents d ON d.id = t.chunk_id
+		WHERE (t.subject = ANY($1) OR t.object = ANY($1) OR t.chunk_id = ANY($2)
+			OR d.filename = ANY($3) OR EXISTS (SELECT 1 FROM unnest($3::text[]) f WHERE d.filename LIKE '%/' || f))
+		AND d.tenant_id = $6 AND `+accessFilter(4)+`
+		ORDER BY t.id LIMIT $5`,
+		nodes, chunkIDs, files, tagsOrEmpty(allowed), limit, s.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("load graph failed: %w", err)
+	}
+	defer rows.Close()
+
+	var edges []GraphEdge
+	for rows.Next() {
+		var e GraphEdge
+		if err := rows.Scan(&e.Subject, &e.Relation, &e.Object, &e.ChunkID, &e.Filename); err != nil {
+			return nil, err
+		}
+		edges = append(edges, e)
+	}
+	return edges, rows.Err()
+}
+
+// ChunksByID returns the chunks among ids that the allowed access tags may
+// see, in id order.
+func (s *PgStore) ChunksByID(ids []int, allowed []string) ([]Document, error) {
+	rows, err := s.pool.Query(context.Background(), `
+		SELECT id, filename, source, content, page, language, collection, start_offset, end_offset, metadata
+		FROM documents WHERE id = ANY($1) AND tenant_id = $3 AND `+accessFilter(2)+` ORDER BY id`,
+		ids, tagsOrEmpty(allowed), s.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("load chunks failed: %w", err)
+	}
+	defer rows.Close()
+
+	var results []Document
+	for rows.Next() {
+		var doc Document
+		var md chunkMetadata
+		if err := rows.Scan(&doc.ID, &doc.Filename, &doc.Source, &doc.Content, &doc.Page, &doc.Language, &doc.Collection, &doc.Start, &doc.End, &md); err != nil {
+			return nil, err
+		}
+		doc.Entities, doc.Keywords = md.Entities, md.Keywords
+		results = append(results, doc)
+	}
+	return results, rows.Err()
+}
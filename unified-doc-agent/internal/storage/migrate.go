@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pgvector/pgvector-go"
+)
+
+// execer is satisfied by both the pool and a transaction.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// ActiveEmbedding returns the model and dimension the stored embeddings
+// were produced with. ok is false for a database that has not recorded one.
+// The embedding column, and so the dimension, is shared by every tenant;
+// the methods below migrate the chunks of all of them at once.
+func (s *PgStore) ActiveEmbedding() (model string, dim int, ok bool, err error) {
+	ctx := context.Background()
+	err = s.pool.QueryRow(ctx, "SELECT value FROM agent_settings WHERE key = 'embed_model'").Scan(&model)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", 0, false, nil
+	}
+	if err != nil {
+		return "", 0, false, err
+	}
+	var dimStr string
+	if err := s.pool.QueryRow(ctx, "SELECT value FROM agent_settings WHERE key = 'embed_dim'").Scan(&dimStr); err != nil {
+		return "", 0, false, err
+	}
+	dim, err = strconv.Atoi(dimStr)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("bad embed_dim setting %q", dimStr)
+	}
+	return model, dim, true, nil
+}
+
+// SetActiveEmbedding records the model and dimension of the stored embeddings.
+func (s *PgStore) SetActiveEmbedding(model string, dim int) error {
+	return setActiveEmbedding(context.Background(), s.pool, model, dim)
+}
+
+func setActiveEmbedding(ctx context.Context, db execer, model string, dim int) error {
+	for key, value := range map[string]string{"embed_model": model, "embed_dim": strconv.Itoa(dim)} {
+		_, err := db.Exec(ctx, `
+			INSERT INTO agent_settings (key, value) VALUES ($1, $2)
+			ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value`, key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CountChunks returns the number of stored chunks of every tenant.
+func (s *PgStore) CountChunks() (int, error) {
+	var n int
+	err := s.pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM documents").Scan(&n)
+	return n, err
+}
+
+// PrepareNextEmbedding (re)creates the staging column that a migration
+// fills with embeddings of the given dimension.
+func (s *PgStore) PrepareNextEmbedding(dim int) error {
+	ctx := context.Background()
+	if _, err := s.pool.Exec(ctx, "ALTER TABLE documents DROP COLUMN IF EXISTS embedding_next"); err != nil {
+		return err
+	}
+	_, err := s.pool.Exec(ctx, fmt.Sprintf("ALTER TABLE documents ADD COLUMN embedding_next vector(%d)", dim))
+	return err
+}
+
+// ChunksAfter returns up to limit chunks with an id greater than afterID,
+// in id order, for batch processing.
+func (s *PgStore) ChunksAfter(afterID, limit int) ([]Document, error) {
+	rows, err := s.pool.Query(context.Background(),
+		"SELECT id, filename, source, content, page, language FROM documents WHERE id > $1 ORDER BY id LIMIT $2",
+		afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []Document
+	for rows.Next() {
+		var doc Document
+		if err := rows.Scan(&doc.ID, &doc.Filename, &doc.Source, &doc.Content, &doc.Page, &doc.Language); err != nil {
+			return nil, err
+		}
+		results = append(results, doc)
+	}
+	return results, rows.Err()
+}
+
+// SetNextEmbedding stores a chunk's embedding in the staging column.
+func (s *PgStore) SetNextEmbedding(id int, embedding []float32) error {
+	_, err := s.pool.Exec(context.Background(),
+		"UPDATE documents SET embedding_next = $2 WHERE id = $1", id, pgvector.NewVector(embedding))
+	return err
+}
+
+// SetNextEmbeddings stores embeddings[i] for the chunk ids[i], sending all
+// updates in one round trip.
+func (s *PgStore) SetNextEmbeddings(ids []int, embeddings [][]float32) error {
+	if len(ids) != len(embeddings) {
+		return fmt.Errorf("%d ids but %d embeddings", len(ids), len(embeddings))
+	}
+	b := &pgx.Batch{}
+	for i, id := range ids {
+		b.Queue("UPDATE documents SET embedding_next = $2 WHERE id = $1", id, pgvector.NewVector(embeddings[i]))
+	}
+	return s.pool.SendBatch(context.Background(), b).Close()
+}
+
+// SwapEmbeddings makes the staging column the live embedding column and
+// records the new model and its version, in one transaction so queries
+// never see a mix. The indexes of the embedding column, such as an ivfflat
+// or hnsw index, are recreated on the new one in the same transaction.
+// It fails if any chunk is missing a staged embedding. Tenants' own
+// embedding models are cleared, as every chunk is now embedded by model.
+func (s *PgStore) SwapEmbeddings(model, version string, dim int) error {
+	defer s.uses.invalidate()
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Block inserts from concurrent indexing while the columns change.
+	if _, err := tx.Exec(ctx, "LOCK TABLE documents IN ACCESS EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+	var missing int
+	if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM documents WHERE embedding_next IS NULL").Scan(&missing); err != nil {
+		return err
+	}
+	if missing > 0 {
+		return fmt.Errorf("%d chunks have no new embedding (were files indexed during the migration?)", missing)
+	}
+	indexes, err := embeddingIndexes(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("listing the embedding indexes: %w", err)
+	}
+	// Dropping the column drops its indexes; their definitions name the
+	// column, so they apply to the renamed one as they are.
+	stmts := append([]string{
+		"ALTER TABLE documents DROP COLUMN embedding",
+		"ALTER TABLE documents RENAME COLUMN embedding_next TO embedding",
+	}, indexes...)
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, "UPDATE documents SET embed_model = $1, embed_version = $2", model, version); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "UPDATE tenants SET embed_model = ''"); err != nil {
+		return err
+	}
+	if err := setActiveEmbedding(ctx, tx, model, dim); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// embeddingIndexes returns the definitions of the indexes of the embedding
+// column of documents.
+func embeddingIndexes(ctx context.Context, tx pgx.Tx) ([]string, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT DISTINCT pg_get_indexdef(i.indexrelid)
+		FROM pg_index i JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indrelid = 'documents'::regclass AND a.attname = 'embedding'`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// DropNextEmbedding removes the staging column after a failed migration.
+func (s *PgStore) DropNextEmbedding() error {
+	_, err := s.pool.Exec(context.Background(), "ALTER TABLE documents DROP COLUMN IF EXISTS embedding_next")
+	return err
+}
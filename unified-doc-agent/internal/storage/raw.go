@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PageText is the extracted text of one page.
+type PageText struct {
+	Number int    `json:"number"`
+	Text   string `json:"text"`
+}
+
+// FileText is the full text extracted from a file at indexing, kept so the
+// file can be re-chunked, summarized whole and quoted from without
+// extracting (and OCRing) it again.
+type FileText struct {
+	Filename string `json:"-"`
+	Source   string `json:"-"`
+	// Hash is the SHA-256 of the file the text was extracted from. Files
+	// with the same content share one stored copy.
+	Hash string `json:"-"`
+	Text string `json:"text"`
+	// Pages holds per-page text for paged documents, as chunked.
+	Pages []PageText `json:"pages,omitempty"`
+}
+
+// storeText replaces the stored text of tenant's filename with t; nil
+// removes it. The text is stored gzipped in documents_raw under t.Hash, or
+// the hash of the text when t has none.
+func storeText(ctx context.Context, db execer, tenant, filename string, t *FileText) error {
+	old, err := deleteRawFiles(ctx, db, "tenant_id = $1 AND filename = $2", tenant, filename)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return pruneRaw(ctx, db, old)
+	}
+	hash := t.Hash
+	if hash == "" {
+		sum := sha256.Sum256([]byte(t.Text))
+		hash = hex.EncodeToString(sum[:])
+	}
+	data, err := compressText(t)
+	if err != nil {
+		return fmt.Errorf("compress text: %w", err)
+	}
+	if _, err := db.Exec(ctx, `INSERT INTO documents_raw (content_hash, data, text_bytes) VALUES ($1, $2, $3)
+		ON CONFLICT (content_hash) DO UPDATE SET data = EXCLUDED.data, text_bytes = EXCLUDED.text_bytes`,
+		hash, data, len(t.Text)); err != nil {
+		return err
+	}
+	if _, err := db.Exec(ctx, "INSERT INTO raw_files (filename, source, content_hash, tenant_id) VALUES ($1, $2, $3, $4)",
+		filename, t.Source, hash, tenant); err != nil {
+		return err
+	}
+	return pruneRaw(ctx, db, old)
+}
+
+// deleteRawFiles deletes the rows t of raw_files matching cond, whose
+// parameters are args, and returns the hashes of the texts they referred
+// to.
+func deleteRawFiles(ctx context.Context, db execer, cond string, args ...any) ([]string, error) {
+	rows, err := db.Query(ctx, "DELETE FROM raw_files t WHERE "+cond+" RETURNING content_hash", args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// pruneRaw removes the stored texts of hashes that no file refers to any
+// more.
+func pruneRaw(ctx context.Context, db execer, hashes []string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	_, err := db.Exec(ctx, `DELETE FROM documents_raw r WHERE r.content_hash = ANY($1)
+		AND NOT EXISTS (SELECT 1 FROM raw_files f WHERE f.content_hash = r.content_hash)`, hashes)
+	return err
+}
+
+func compressText(t *FileText) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(t); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressText(data []byte, t *FileText) error {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	return json.NewDecoder(zr).Decode(t)
+}
+
+// StoredTexts returns the names of the files with stored text whose name
+// starts with prefix, in name order.
+func (s *PgStore) StoredTexts(prefix string) ([]string, error) {
+	return s.filenames("SELECT filename FROM raw_files WHERE tenant_id = $1 AND starts_with(filename, $2) ORDER BY filename", s.tenant, prefix)
+}
+
+// FilesWithoutText returns the files with chunks but no stored text whose
+// name starts with prefix, e.g. those indexed before texts were kept.
+func (s *PgStore) FilesWithoutText(prefix string) ([]string, error) {
+	return s.filenames(`SELECT DISTINCT filename FROM documents d
+		WHERE d.tenant_id = $1 AND starts_with(filename, $2)
+		AND NOT EXISTS (SELECT 1 FROM raw_files f WHERE f.tenant_id = d.tenant_id AND f.filename = d.filename)
+		ORDER BY filename`, s.tenant, prefix)
+}
+
+func (s *PgStore) filenames(query string, args ...interface{}) ([]string, error) {
+	rows, err := s.pool.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// LoadText returns the stored text of filename.
+func (s *PgStore) LoadText(filename string) (*FileText, error) {
+	t := &FileText{Filename: filename}
+	var data []byte
+	err := s.pool.QueryRow(context.Background(), `SELECT f.source, f.content_hash, r.data
+		FROM raw_files f JOIN documents_raw r ON r.content_hash = f.content_hash
+		WHERE f.tenant_id = $1 AND f.filename = $2`, s.tenant, filename).Scan(&t.Source, &t.Hash, &data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("no stored text for %s", filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := decompressText(data, t); err != nil {
+		return nil, fmt.Errorf("stored text of %s: %w", filename, err)
+	}
+	return t, nil
+}
+
+// ReplaceChunks replaces the chunks stored under filename with chunks in
+// one transaction, leaving its index record and text as they are. Chunks
+// without a modification time keep that of the chunks they replace.
+func (s *PgStore) ReplaceChunks(filename string, chunks []ChunkRecord) error {
+	defer s.uses.invalidate()
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var modified *time.Time
+	err = tx.QueryRow(ctx, "SELECT MAX(modified_at) FROM documents WHERE tenant_id = $1 AND filename = $2", s.tenant, filename).Scan(&modified)
+	if err != nil {
+		return err
+	}
+	for i := range chunks {
+		if chunks[i].Modified.IsZero() && modified != nil {
+			chunks[i].Modified = *modified
+		}
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM documents WHERE tenant_id = $1 AND filename = $2", s.tenant, filename); err != nil {
+		return err
+	}
+	if err := copyChunks(ctx, tx, s.tenant, chunks); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// RecountChunks sets the chunk count of every recorded file that is not a
+// duplicate to the chunks stored under its name or, for archives, under
+// names starting with its name and entrySep.
+func (s *PgStore) RecountChunks(entrySep string) error {
+	_, err := s.pool.Exec(context.Background(), `
+		UPDATE indexed_files f SET chunks = (
+			SELECT COUNT(*) FROM documents d
+			WHERE d.tenant_id = f.tenant_id AND (d.filename = f.filename OR starts_with(d.filename, f.filename || $1))
+		) WHERE f.tenant_id = $2 AND f.duplicate_of = ''`, entrySep, s.tenant)
+	return err
+}
+
+// migrateFileTexts moves texts stored by earlier versions in the
+// uncompressed file_texts table to documents_raw and drops the table.
+func (s *PgStore) migrateFileTexts() error {
+	ctx := context.Background()
+	var exists bool
+	if err := s.pool.QueryRow(ctx, "SELECT to_regclass('file_texts') IS NOT NULL").Scan(&exists); err != nil || !exists {
+		return err
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, "SELECT filename, source, content, pages FROM file_texts")
+	if err != nil {
+		return err
+	}
+	var texts []FileText
+	for rows.Next() {
+		var t FileText
+		if err := rows.Scan(&t.Filename, &t.Source, &t.Text, &t.Pages); err != nil {
+			rows.Close()
+			return err
+		}
+		texts = append(texts, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range texts {
+		t := &texts[i]
+		// The file hash was not kept with the text, so key it by the text.
+		if err := storeText(ctx, tx, DefaultTenant, t.Filename, t); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, "DROP TABLE file_texts"); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Index run statuses.
+const (
+	RunRunning     = "running"
+	RunCompleted   = "completed"
+	RunInterrupted = "interrupted"
+	// RunFailed runs stopped on an error other than a single file failing.
+	RunFailed = "failed"
+)
+
+// Index run kinds.
+const (
+	RunIndex   = "index"
+	RunRechunk = "rechunk"
+	RunSync    = "sync"
+)
+
+// IndexRun is the record of one run that indexed files: an `agent index`
+// or daemon reconciliation over a root folder, a re-chunk or a remote sync.
+type IndexRun struct {
+	ID   int    `json:"id"`
+	Kind string `json:"kind"`
+	// Root is the folder, name prefix or remote source the run covered.
+	Root       string `json:"root"`
+	Source     string `json:"source"`
+	EmbedModel string `json:"embed_model"`
+	Status     string `json:"status"`
+	LastFile   string `json:"last_file,omitempty"`
+	FilesDone  int    `json:"files_done"`
+	RunCounts
+	// Error is why a failed run stopped.
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Report is the run's full result as JSON, with every failure.
+	Report json.RawMessage `json:"report,omitempty"`
+}
+
+// RunCounts are the per-outcome file counts of a run.
+type RunCounts struct {
+	Added      int `json:"added"`
+	Updated    int `json:"updated"`
+	Unchanged  int `json:"unchanged"`
+	Duplicates int `json:"duplicates"`
+	Skipped    int `json:"skipped"`
+	Failed     int `json:"failed"`
+	Removed    int `json:"removed"`
+	Chunks     int `json:"chunks"`
+}
+
+// StartIndexRun records a new run from r's kind, root, source and
+// embedding model.
+func (s *PgStore) StartIndexRun(r IndexRun) (int, error) {
+	var id int
+	err := s.pool.QueryRow(context.Background(),
+		"INSERT INTO index_runs (kind, root, source, embed_model, status, tenant_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		r.Kind, r.Root, r.Source, r.EmbedModel, RunRunning, s.tenant).Scan(&id)
+	return id, err
+}
+
+// ResumeIndexRun marks an interrupted run as running again.
+func (s *PgStore) ResumeIndexRun(id int) error {
+	_, err := s.pool.Exec(context.Background(),
+		"UPDATE index_runs SET status = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $3", id, RunRunning, s.tenant)
+	return err
+}
+
+// CheckpointIndexRun records that file, the done-th file of the run, is finished.
+func (s *PgStore) CheckpointIndexRun(id int, file string, done int) error {
+	_, err := s.pool.Exec(context.Background(),
+		"UPDATE index_runs SET last_file = $2, files_done = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $4",
+		id, file, done, s.tenant)
+	return err
+}
+
+// FinishIndexRun records the final status, counts and report of a run;
+// runErr is the error that stopped a failed run. report is stored as JSON.
+func (s *PgStore) FinishIndexRun(id int, status string, counts RunCounts, runErr string, report interface{}) error {
+	_, err := s.pool.Exec(context.Background(), `
+		UPDATE index_runs SET status = $2, files_added = $3, files_updated = $4, files_unchanged = $5,
+			files_duplicate = $6, files_skipped = $7, files_failed = $8, files_removed = $9, chunks = $10,
+			error = $11, report = $12, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND tenant_id = $13`,
+		id, status, counts.Added, counts.Updated, counts.Unchanged, counts.Duplicates, counts.Skipped,
+		counts.Failed, counts.Removed, counts.Chunks, runErr, report, s.tenant)
+	return err
+}
+
+// LastUnfinishedRun returns the most recent index run over root that did
+// not complete, whether it was interrupted cleanly or the process died.
+func (s *PgStore) LastUnfinishedRun(root string) (*IndexRun, error) {
+	r := &IndexRun{Root: root}
+	err := s.pool.QueryRow(context.Background(), `
+		SELECT id, last_file, files_done FROM index_runs
+		WHERE tenant_id = $5 AND root = $1 AND kind = $2 AND status NOT IN ($3, $4)
+		ORDER BY id DESC LIMIT 1`, root, RunIndex, RunCompleted, RunFailed, s.tenant).Scan(&r.ID, &r.LastFile, &r.FilesDone)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+const runColumns = `id, kind, root, source, embed_model, status, last_file, files_done, files_added, files_updated,
+	files_unchanged, files_duplicate, files_skipped, files_failed, files_removed, chunks, error, started_at, finished_at`
+
+func scanRun(row pgx.Row, extra ...interface{}) (*IndexRun, error) {
+	r := &IndexRun{}
+	dest := []interface{}{&r.ID, &r.Kind, &r.Root, &r.Source, &r.EmbedModel, &r.Status, &r.LastFile, &r.FilesDone,
+		&r.Added, &r.Updated, &r.Unchanged, &r.Duplicates, &r.Skipped, &r.Failed, &r.Removed, &r.Chunks,
+		&r.Error, &r.StartedAt, &r.FinishedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// IndexRuns returns the most recent runs, newest first, without reports.
+func (s *PgStore) IndexRuns(limit int) ([]IndexRun, error) {
+	rows, err := s.pool.Query(context.Background(),
+		"SELECT "+runColumns+" FROM index_runs WHERE tenant_id = $2 ORDER BY id DESC LIMIT $1", limit, s.tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []IndexRun
+	for rows.Next() {
+		r, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *r)
+	}
+	return runs, rows.Err()
+}
+
+// GetIndexRun returns run id with its report, or nil if there is none.
+func (s *PgStore) GetIndexRun(id int) (*IndexRun, error) {
+	var report []byte
+	r, err := scanRun(s.pool.QueryRow(context.Background(),
+		"SELECT "+runColumns+", report FROM index_runs WHERE id = $1 AND tenant_id = $2", id, s.tenant), &report)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.Report = report
+	return r, nil
+}
+
+// SyncToken returns the change token saved by the last complete sync of a
+// remote source, or "" if it was never synced.
+func (s *PgStore) SyncToken(source string) (string, error) {
+	var token string
+	err := s.pool.QueryRow(context.Background(),
+		"SELECT token FROM sync_tokens WHERE tenant_id = $1 AND source = $2", s.tenant, source).Scan(&token)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return token, err
+}
+
+// SetSyncToken saves the change token the next sync of source starts from.
+func (s *PgStore) SetSyncToken(source, token string) error {
+	_, err := s.pool.Exec(context.Background(), `
+		INSERT INTO sync_tokens (source, token, tenant_id) VALUES ($1, $2, $3)
+		ON CONFLICT (tenant_id, source) DO UPDATE SET token = EXCLUDED.token, updated_at = CURRENT_TIMESTAMP`, source, token, s.tenant)
+	return err
+}
+
+// StoreFile replaces the chunks and extracted text stored under filename
+// with chunks and text (nil stores none) and, if rec is not nil, records
+// the file, all in one transaction. An interrupted run therefore never
+// leaves a file half stored.
+func (s *PgStore) StoreFile(filename string, chunks []ChunkRecord, text *FileText, rec *FileRecord) error {
+	defer s.uses.invalidate()
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM documents WHERE tenant_id = $1 AND filename = $2", s.tenant, filename); err != nil {
+		return err
+	}
+	if err := copyChunks(ctx, tx, s.tenant, chunks); err != nil {
+		return err
+	}
+	if err := storeText(ctx, tx, s.tenant, filename, text); err != nil {
+		return err
+	}
+	if rec != nil {
+		if err := recordFile(ctx, tx, s.tenant, *rec); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// schema is applied in order on startup. Every statement must be idempotent.
+var schema = []string{
+	`CREATE EXTENSION IF NOT EXISTS vector`,
+	`CREATE TABLE IF NOT EXISTS documents (
+		id SERIAL PRIMARY KEY,
+		filename TEXT NOT NULL,
+		source TEXT NOT NULL,
+		content TEXT NOT NULL,
+		embedding vector(768)
+	)`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS page INT NOT NULL DEFAULT 0`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS embed_model TEXT NOT NULL DEFAULT ''`,
+	// Version of embed_model, '' for chunks embedded before it was recorded.
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS embed_version TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS access_tags TEXT[] NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS collection TEXT NOT NULL DEFAULT 'default'`,
+	// Offsets of the chunk in its page's (or file's) text; -1 for chunks
+	// indexed before they were recorded.
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS start_offset INT NOT NULL DEFAULT -1`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS end_offset INT NOT NULL DEFAULT -1`,
+	// When the chunk's file was last modified, in UTC; NULL for chunks
+	// indexed before it was recorded.
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS modified_at TIMESTAMP`,
+	`CREATE INDEX IF NOT EXISTS documents_filename_idx ON documents (filename)`,
+	`CREATE INDEX IF NOT EXISTS documents_collection_idx ON documents (collection)`,
+	`CREATE INDEX IF NOT EXISTS documents_metadata_idx ON documents USING GIN (metadata jsonb_path_ops)`,
+	`CREATE TABLE IF NOT EXISTS indexed_files (
+		filename TEXT PRIMARY KEY,
+		source TEXT NOT NULL,
+		content_hash TEXT NOT NULL,
+		size_bytes BIGINT NOT NULL,
+		chunks INT NOT NULL,
+		indexed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`ALTER TABLE indexed_files ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE indexed_files ADD COLUMN IF NOT EXISTS minhash BIGINT[]`,
+	`ALTER TABLE indexed_files ADD COLUMN IF NOT EXISTS duplicate_of TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE indexed_files ADD COLUMN IF NOT EXISTS access_tags TEXT[] NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE indexed_files ADD COLUMN IF NOT EXISTS collection TEXT NOT NULL DEFAULT 'default'`,
+	`ALTER TABLE indexed_files ADD COLUMN IF NOT EXISTS modified_at TIMESTAMP`,
+	`CREATE TABLE IF NOT EXISTS index_runs (
+		id SERIAL PRIMARY KEY,
+		root TEXT NOT NULL,
+		status TEXT NOT NULL,
+		last_file TEXT NOT NULL DEFAULT '',
+		files_done INT NOT NULL DEFAULT 0,
+		kind TEXT NOT NULL DEFAULT 'index',
+		source TEXT NOT NULL DEFAULT '',
+		embed_model TEXT NOT NULL DEFAULT '',
+		files_added INT NOT NULL DEFAULT 0,
+		files_updated INT NOT NULL DEFAULT 0,
+		files_unchanged INT NOT NULL DEFAULT 0,
+		files_duplicate INT NOT NULL DEFAULT 0,
+		files_skipped INT NOT NULL DEFAULT 0,
+		files_failed INT NOT NULL DEFAULT 0,
+		files_removed INT NOT NULL DEFAULT 0,
+		chunks INT NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		report JSONB,
+		started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		finished_at TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS chunk_feedback (
+		id SERIAL PRIMARY KEY,
+		chunk_id INT NOT NULL REFERENCES documents (id) ON DELETE CASCADE,
+		query TEXT NOT NULL,
+		helpful BOOLEAN NOT NULL,
+		voter TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (chunk_id, query, voter)
+	)`,
+	`CREATE INDEX IF NOT EXISTS chunk_feedback_chunk_idx ON chunk_feedback (chunk_id)`,
+	`CREATE TABLE IF NOT EXISTS pinned_chunks (
+		query TEXT NOT NULL,
+		chunk_id INT NOT NULL REFERENCES documents (id) ON DELETE CASCADE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (query, chunk_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS kg_triples (
+		id SERIAL PRIMARY KEY,
+		chunk_id INT NOT NULL REFERENCES documents (id) ON DELETE CASCADE,
+		subject TEXT NOT NULL,
+		relation TEXT NOT NULL,
+		object TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS kg_triples_subject_idx ON kg_triples (subject)`,
+	`CREATE INDEX IF NOT EXISTS kg_triples_object_idx ON kg_triples (object)`,
+	`CREATE INDEX IF NOT EXISTS kg_triples_chunk_idx ON kg_triples (chunk_id)`,
+	// Extracted text is stored once per file content, gzipped, and shared
+	// by every file name with that content.
+	`CREATE TABLE IF NOT EXISTS documents_raw (
+		content_hash TEXT PRIMARY KEY,
+		data BYTEA NOT NULL,
+		text_bytes INT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS raw_files (
+		filename TEXT PRIMARY KEY,
+		source TEXT NOT NULL,
+		content_hash TEXT NOT NULL REFERENCES documents_raw (content_hash)
+	)`,
+	`CREATE INDEX IF NOT EXISTS raw_files_hash_idx ON raw_files (content_hash)`,
+	`CREATE TABLE IF NOT EXISTS sync_tokens (
+		source TEXT PRIMARY KEY,
+		token TEXT NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS agent_settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS tenants (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		embed_model TEXT NOT NULL DEFAULT '',
+		llm_model TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`INSERT INTO tenants (id, name) VALUES ('` + DefaultTenant + `', 'Default') ON CONFLICT (id) DO NOTHING`,
+	// Files, chunks, runs and sync tokens belong to a tenant; rows from
+	// before tenants belong to the default one. Feedback, pins and triples
+	// belong to the tenant of their chunk, and stored texts are shared by
+	// content hash, reached only through a tenant's raw_files.
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '` + DefaultTenant + `'`,
+	`ALTER TABLE indexed_files ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '` + DefaultTenant + `'`,
+	`ALTER TABLE raw_files ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '` + DefaultTenant + `'`,
+	`ALTER TABLE index_runs ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '` + DefaultTenant + `'`,
+	`ALTER TABLE sync_tokens ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '` + DefaultTenant + `'`,
+	`CREATE INDEX IF NOT EXISTS documents_tenant_filename_idx ON documents (tenant_id, filename)`,
+	`CREATE INDEX IF NOT EXISTS index_runs_tenant_idx ON index_runs (tenant_id, id)`,
+	tenantPrimaryKey("indexed_files", "filename"),
+	tenantPrimaryKey("raw_files", "filename"),
+	tenantPrimaryKey("sync_tokens", "source"),
+}
+
+// tenantPrimaryKey replaces the primary key of table, column, with
+// (tenant_id, column) once, so that tenants may use the same names.
+func tenantPrimaryKey(table, column string) string {
+	return fmt.Sprintf(`DO $$ BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = '%[1]s_tenant_pkey') THEN
+			ALTER TABLE %[1]s DROP CONSTRAINT IF EXISTS %[1]s_pkey;
+			ALTER TABLE %[1]s ADD CONSTRAINT %[1]s_tenant_pkey PRIMARY KEY (tenant_id, %[2]s);
+		END IF;
+	END $$`, table, column)
+}
+
+// EnsureSchema creates the tables the agent needs if they do not exist yet.
+func (s *PgStore) EnsureSchema() error {
+	for _, stmt := range schema {
+		if _, err := s.pool.Exec(context.Background(), stmt); err != nil {
+			return fmt.Errorf("schema: %w", err)
+		}
+	}
+	if err := s.migrateFileTexts(); err != nil {
+		return fmt.Errorf("schema: moving file_texts to documents_raw: %w", err)
+	}
+	return nil
+}
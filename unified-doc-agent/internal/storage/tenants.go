@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultTenant owns what is indexed without naming a tenant, including
+// everything indexed before tenants existed. It cannot be deleted.
+const DefaultTenant = "default"
+
+// Tenant errors.
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+	ErrTenantExists   = errors.New("tenant already exists")
+	// ErrDefaultTenant is returned when deleting DefaultTenant.
+	ErrDefaultTenant = errors.New("the default tenant cannot be deleted")
+)
+
+// tenantIDPattern keeps tenant ids usable in headers, paths and file names.
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// ValidTenantID reports whether id may name a tenant: 1 to 63 lower-case
+// letters, digits, '-' and '_', starting with a letter or digit.
+func ValidTenantID(id string) bool {
+	return tenantIDPattern.MatchString(id)
+}
+
+// Tenant is a user or team whose documents are isolated from the others'.
+type Tenant struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// EmbedModel embeds the tenant's chunks and queries instead of the
+	// configured models; it must produce vectors of the active embedding
+	// dimension. Empty uses the configured models.
+	EmbedModel string `json:"embed_model,omitempty"`
+	// LLMModel answers the tenant's queries instead of the configured
+	// llm_model. Empty uses the configured model.
+	LLMModel  string    `json:"llm_model,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const tenantColumns = "id, name, embed_model, llm_model, created_at"
+
+func scanTenant(row pgx.Row) (*Tenant, error) {
+	t := &Tenant{}
+	if err := row.Scan(&t.ID, &t.Name, &t.EmbedModel, &t.LLMModel, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Tenants returns every tenant, in id order. Tenants are managed across
+// the whole database whichever tenant s belongs to.
+func (s *PgStore) Tenants() ([]Tenant, error) {
+	rows, err := s.pool.Query(context.Background(), "SELECT "+tenantColumns+" FROM tenants ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []Tenant
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, *t)
+	}
+	return tenants, rows.Err()
+}
+
+// GetTenant returns tenant id, or ErrTenantNotFound.
+func (s *PgStore) GetTenant(id string) (*Tenant, error) {
+	t, err := scanTenant(s.pool.QueryRow(context.Background(),
+		"SELECT "+tenantColumns+" FROM tenants WHERE id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTenantNotFound
+	}
+	return t, err
+}
+
+// CreateTenant provisions t, returning ErrTenantExists if its id is taken.
+func (s *PgStore) CreateTenant(t Tenant) (*Tenant, error) {
+	created, err := scanTenant(s.pool.QueryRow(context.Background(), `
+		INSERT INTO tenants (id, name, embed_model, llm_model) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO NOTHING
+		RETURNING `+tenantColumns, t.ID, t.Name, t.EmbedModel, t.LLMModel))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTenantExists
+	}
+	return created, err
+}
+
+// UpdateTenant sets the name and models of tenant t.ID. Chunks already
+// embedded by a previous embedding model are only found again once the
+// tenant's files are re-indexed.
+func (s *PgStore) UpdateTenant(t Tenant) (*Tenant, error) {
+	updated, err := scanTenant(s.pool.QueryRow(context.Background(), `
+		UPDATE tenants SET name = $2, embed_model = $3, llm_model = $4 WHERE id = $1
+		RETURNING `+tenantColumns, t.ID, t.Name, t.EmbedModel, t.LLMModel))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTenantNotFound
+	}
+	return updated, err
+}
+
+// DeleteTenant removes tenant id with all of its files, chunks, feedback,
+// runs and sync tokens, in one transaction.
+func (s *PgStore) DeleteTenant(id string) error {
+	defer s.uses.invalidate()
+	if id == DefaultTenant {
+		return ErrDefaultTenant
+	}
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, "DELETE FROM tenants WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTenantNotFound
+	}
+	// Feedback, pins and triples go with the chunks.
+	for _, table := range []string{"documents", "indexed_files", "index_runs", "sync_tokens"} {
+		if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE tenant_id = $1", id); err != nil {
+			return err
+		}
+	}
+	hashes, err := deleteRawFiles(ctx, tx, "tenant_id = $1", id)
+	if err != nil {
+		return err
+	}
+	if err := pruneRaw(ctx, tx, hashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
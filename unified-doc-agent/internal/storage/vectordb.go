@@ -3,30 +3,188 @@ package storage
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/pgvector/pgvector-go"
 )
 
 type Document struct {
-	ID       int
+	ID         int
+	Filename   string
+	Source     string
+	Content    string
+	Page       int // 1-based page number, 0 when unknown
+	Language   string
+	Collection string
+	// Start and End are the chunk's offsets in its page's text, -1 when
+	// unknown.
+	Start, End int
+	// Entities and Keywords are the chunk's extracted metadata.
+	Entities []string
+	Keywords []string
+	// Modified is when the chunk's file was last modified, nil when
+	// unrecorded.
+	Modified *time.Time
+	// Distance is the L2 distance to the query embedding (search results only).
+	Distance float64
+}
+
+// ChunkRecord is one chunk to store along with its metadata.
+type ChunkRecord struct {
 	Filename string
 	Source   string
 	Content  string
+	// Page is the 1-based page the chunk came from, or 0 if the file has no pages.
+	Page int
+	// Start and End are the byte offsets of Content in the page's text
+	// (the file's, without pages), for stitching neighbours together.
+	Start, End int
+	// Language is the ISO 639-1 code detected for the file.
+	Language string
+	// EmbedModel is the model that produced Embedding. Only chunks embedded
+	// by the same model as a query are comparable with it.
+	EmbedModel string
+	// EmbedVersion is the version of EmbedModel, as by
+	// processing.EmbedVersion; a model pulled again may embed differently.
+	EmbedVersion string
+	// AccessTags restrict which queries may see the chunk; none means all.
+	AccessTags []string
+	// Collection groups chunks for searching and weighting; empty means
+	// the default collection.
+	Collection string
+	// Entities and Keywords are stored in the metadata column, normalized
+	// as by processing.NormalizeEntity.
+	Entities []string
+	Keywords []string
+	// Triples are the facts extracted for the knowledge graph, if enabled.
+	Triples   []Triple
+	Embedding []float32
+	// Modified is when the file was last modified; zero when unknown.
+	Modified time.Time
+}
+
+// chunkMetadata is the JSON stored in the documents metadata column.
+type chunkMetadata struct {
+	Entities []string `json:"entities,omitempty"`
+	Keywords []string `json:"keywords,omitempty"`
+}
+
+func (c ChunkRecord) metadata() chunkMetadata {
+	return chunkMetadata{Entities: c.Entities, Keywords: c.Keywords}
+}
+
+// entityFilter is the metadata value that chunks mentioning every one of
+// entities contain. With no entities it matches every chunk.
+func entityFilter(entities []string) chunkMetadata {
+	return chunkMetadata{Entities: entities}
+}
+
+// AllTags in a query's allowed tags disables access filtering.
+const AllTags = "*"
+
+// accessFilter is the SQL condition letting through rows that are untagged
+// or share a tag with the array parameter $n.
+func accessFilter(n int) string {
+	return fmt.Sprintf("(cardinality(access_tags) = 0 OR access_tags && $%d OR '*' = ANY($%d))", n, n)
 }
 
 // InsertEmbedding adds a chunk into Postgres with embedding
-func InsertEmbedding(filename, source, content string, embedding []float32) error {
-	_, err := DB.Exec(context.Background(),
-		"INSERT INTO documents (filename, source, content, embedding) VALUES ($1, $2, $3, $4)",
-		filename, source, content, pgvector.NewVector(embedding))
+func (s *PgStore) InsertEmbedding(c ChunkRecord) error {
+	defer s.uses.invalidate()
+	_, err := s.pool.Exec(context.Background(),
+		"INSERT INTO documents (filename, source, content, page, language, embed_model, embed_version, access_tags, metadata, collection, start_offset, end_offset, embedding, tenant_id, modified_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)",
+		c.Filename, c.Source, c.Content, c.Page, c.Language, c.EmbedModel, c.EmbedVersion, tagsOrEmpty(c.AccessTags), c.metadata(), collectionOrDefault(c.Collection), c.Start, c.End, pgvector.NewVector(c.Embedding), s.tenant, utcOrNil(c.Modified))
+	return err
+}
+
+// InsertChunks adds chunks in one transaction using COPY, so either all of
+// them are stored or none are.
+func (s *PgStore) InsertChunks(chunks []ChunkRecord) error {
+	defer s.uses.invalidate()
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := copyChunks(ctx, tx, s.tenant, chunks); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// copyChunks bulk-loads chunks of tenant within tx. pgx has no binary codec for the
+// vector type, so rows are copied into a temporary table with the
+// embedding in text form and cast on the way into documents. Chunk ids are
+// drawn in the staging table so the triples can be stored against them.
+func copyChunks(ctx context.Context, tx pgx.Tx, tenant string, chunks []ChunkRecord) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `
+		CREATE TEMP TABLE IF NOT EXISTS documents_staging (
+			filename TEXT, source TEXT, content TEXT, page INT,
+			language TEXT, embed_model TEXT, access_tags TEXT[], metadata JSONB, embedding TEXT,
+			triples JSONB, id INT, collection TEXT, start_offset INT, end_offset INT, embed_version TEXT,
+			modified_at TIMESTAMP
+		)`)
+	if err != nil {
+		return err
+	}
+	cols := []string{"filename", "source", "content", "page", "language", "embed_model", "access_tags", "metadata", "embedding", "triples", "collection", "start_offset", "end_offset", "embed_version", "modified_at"}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"documents_staging"}, cols,
+		pgx.CopyFromSlice(len(chunks), func(i int) ([]any, error) {
+			c := chunks[i]
+			return []any{c.Filename, c.Source, c.Content, c.Page, c.Language, c.EmbedModel,
+				tagsOrEmpty(c.AccessTags), c.metadata(), pgvector.NewVector(c.Embedding).String(), triplesOrEmpty(c.Triples), collectionOrDefault(c.Collection), c.Start, c.End, c.EmbedVersion, utcOrNil(c.Modified)}, nil
+		}))
+	if err != nil {
+		return fmt.Errorf("copy chunks: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `UPDATE documents_staging SET id = nextval(pg_get_serial_sequence('documents', 'id'))`); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO documents (id, filename, source, content, page, language, embed_model, embed_version, access_tags, metadata, collection, start_offset, end_offset, embedding, tenant_id, modified_at)
+		SELECT id, filename, source, content, page, language, embed_model, embed_version, access_tags, metadata, collection, start_offset, end_offset, embedding::vector, $1, modified_at
+		FROM documents_staging`, tenant)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO kg_triples (chunk_id, subject, relation, object)
+		SELECT s.id, t.subject, t.relation, t.object
+		FROM documents_staging s, jsonb_to_recordset(s.triples) AS t (subject TEXT, relation TEXT, object TEXT)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "TRUNCATE documents_staging")
 	return err
 }
 
-// QuerySimilar returns top-k most similar documents
-func QuerySimilar(queryEmb []float32, topK int) ([]Document, error) {
-	rows, err := DB.Query(context.Background(),
-		"SELECT id, filename, source, content FROM documents ORDER BY embedding <-> $1 LIMIT $2",
-		pgvector.NewVector(queryEmb), topK)
+// QuerySimilar returns top-k most similar documents among the chunks
+// embedded by one of models ("" matches chunks stored before the model was
+// recorded) that the allowed access tags may see, that mention every one
+// of entities and, unless collections is empty, that are in one of
+// collections. Unless prefixes is empty, only the chunks of files whose
+// name starts with one of prefixes are searched. A non-zero since or until
+// keeps only the chunks of files modified at or after since and before
+// until; chunks without a modification time are then left out.
+func (s *PgStore) QuerySimilar(queryEmb []float32, topK int, models, allowed, entities, collections, prefixes []string, since, until time.Time) ([]Document, error) {
+	if collections == nil {
+		collections = []string{}
+	}
+	if prefixes == nil {
+		prefixes = []string{}
+	}
+	rows, err := s.pool.Query(context.Background(),
+		"SELECT id, filename, source, content, page, language, collection, start_offset, end_offset, metadata, modified_at, embedding <-> $1 AS distance FROM documents WHERE embed_model = ANY($3) AND "+accessFilter(4)+
+			" AND metadata @> $5 AND (cardinality($6::text[]) = 0 OR collection = ANY($6)) AND tenant_id = $7"+
+			" AND ($8::timestamp IS NULL OR modified_at >= $8) AND ($9::timestamp IS NULL OR modified_at < $9)"+
+			" AND (cardinality($10::text[]) = 0 OR EXISTS (SELECT 1 FROM unnest($10::text[]) AS p WHERE starts_with(filename, p))) ORDER BY distance LIMIT $2",
+		pgvector.NewVector(queryEmb), topK, models, tagsOrEmpty(allowed), entityFilter(entities), collections, s.tenant, utcOrNil(since), utcOrNil(until), prefixes)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -35,24 +193,485 @@ func QuerySimilar(queryEmb []float32, topK int) ([]Document, error) {
 	var results []Document
 	for rows.Next() {
 		var doc Document
-		if err := rows.Scan(&doc.ID, &doc.Filename, &doc.Source, &doc.Content); err != nil {
+		var md chunkMetadata
+		if err := rows.Scan(&doc.ID, &doc.Filename, &doc.Source, &doc.Content, &doc.Page, &doc.Language, &doc.Collection, &doc.Start, &doc.End, &md, &doc.Modified, &doc.Distance); err != nil {
 			return nil, err
 		}
+		doc.Entities, doc.Keywords = md.Entities, md.Keywords
 		results = append(results, doc)
 	}
 	return results, nil
 }
 
-// SearchImpl adapts QuerySimilar for graph.DBWrapper
-func SearchImpl(queryEmb []float32, topK int) ([]string, error) {
-	docs, err := QuerySimilar(queryEmb, topK)
+// EmbeddingUse is how many chunks were embedded by a model at a version.
+type EmbeddingUse struct {
+	Model   string `json:"model"`
+	Version string `json:"version"`
+	Chunks  int    `json:"chunks"`
+}
+
+// EmbeddingModels returns the models and versions that embedded the chunks
+// the allowed access tags may see in one of collections, or in any when
+// collections is empty, with how many chunks each embedded. Every query
+// asks, so the answers are cached; see useCache.
+func (s *PgStore) EmbeddingModels(allowed, collections []string) ([]EmbeddingUse, error) {
+	if collections == nil {
+		collections = []string{}
+	}
+	key := s.tenant + "\x00" + strings.Join(allowed, ",") + "\x00" + strings.Join(collections, ",")
+	if uses, ok := s.uses.get(key); ok {
+		return uses, nil
+	}
+	gen := s.uses.generation()
+	rows, err := s.pool.Query(context.Background(),
+		"SELECT embed_model, embed_version, COUNT(*) FROM documents WHERE "+accessFilter(1)+
+			" AND (cardinality($2::text[]) = 0 OR collection = ANY($2)) AND tenant_id = $3 GROUP BY embed_model, embed_version ORDER BY embed_model, embed_version",
+		tagsOrEmpty(allowed), collections, s.tenant)
 	if err != nil {
+		return nil, fmt.Errorf("list embedding models failed: %w", err)
+	}
+	defer rows.Close()
+
+	var uses []EmbeddingUse
+	for rows.Next() {
+		var u EmbeddingUse
+		if err := rows.Scan(&u.Model, &u.Version, &u.Chunks); err != nil {
+			return nil, err
+		}
+		uses = append(uses, u)
+	}
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	s.uses.put(key, gen, uses)
+	return uses, nil
+}
+
+// useCacheTTL bounds how long EmbeddingModels answers from its cache.
+// Changes made through the store drop the cache at once; this is how late
+// those of other processes, such as `agent index` next to `agent serve`,
+// may show.
+const useCacheTTL = time.Minute
+
+// maxCachedUses bounds the cached answers, one per tenant, access tags and
+// collections asked about.
+const maxCachedUses = 1000
+
+// useCache holds the answers of EmbeddingModels, which otherwise groups
+// every chunk a query may search. Methods changing chunks call invalidate.
+type useCache struct {
+	mu      sync.Mutex
+	gen     uint64
+	entries map[string]cachedUses
+}
 
-	results := make([]string, len(docs))
-	for i, d := range docs {
-		results[i] = fmt.Sprintf("File: %s\n%s", d.Filename, d.Content)
+type cachedUses struct {
+	uses []EmbeddingUse
+	at   time.Time
+}
+
+func newUseCache() *useCache {
+	return &useCache{entries: map[string]cachedUses{}}
+}
+
+func (c *useCache) get(key string) ([]EmbeddingUse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Since(e.at) > useCacheTTL {
+		return nil, false
 	}
-	return results, nil
+	return e.uses, true
+}
+
+// generation returns the count of invalidations, to pass to put.
+func (c *useCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// put caches uses, read after generation returned gen, unless the cache
+// was invalidated since.
+func (c *useCache) put(key string, gen uint64, uses []EmbeddingUse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if len(c.entries) >= maxCachedUses {
+		clear(c.entries)
+	}
+	c.entries[key] = cachedUses{uses: uses, at: time.Now()}
+}
+
+// invalidate drops every cached answer. Writers call it once their change
+// is committed.
+func (c *useCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	clear(c.entries)
+}
+
+// IndexedFile describes one indexed file and how many chunks it has.
+type IndexedFile struct {
+	Filename    string `json:"filename"`
+	Source      string `json:"source"`
+	Language    string `json:"language"`
+	Collection  string `json:"collection"`
+	Chunks      int    `json:"chunks"`
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	// IndexedAt is when the file was last indexed, nil when unrecorded.
+	IndexedAt *time.Time `json:"indexed_at,omitempty"`
+	// Modified is when the file was last modified, nil when unrecorded.
+	Modified *time.Time `json:"modified_at,omitempty"`
+}
+
+// ListDocuments returns every indexed file the allowed access tags may see,
+// with its chunk count.
+func (s *PgStore) ListDocuments(allowed []string) ([]IndexedFile, error) {
+	rows, err := s.pool.Query(context.Background(),
+		"SELECT filename, source, MAX(language), MAX(collection), COUNT(*), (SELECT f.indexed_at FROM indexed_files f WHERE f.tenant_id = $2 AND f.filename = documents.filename), MAX(modified_at) FROM documents WHERE "+accessFilter(1)+" AND tenant_id = $2 GROUP BY filename, source ORDER BY filename",
+		tagsOrEmpty(allowed), s.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list documents failed: %w", err)
+	}
+	defer rows.Close()
+
+	var results []IndexedFile
+	for rows.Next() {
+		var f IndexedFile
+		if err := rows.Scan(&f.Filename, &f.Source, &f.Language, &f.Collection, &f.Chunks, &f.IndexedAt, &f.Modified); err != nil {
+			return nil, err
+		}
+		results = append(results, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Duplicates have no chunks, so they only appear in indexed_files.
+	dups, err := s.pool.Query(context.Background(),
+		"SELECT filename, source, language, collection, duplicate_of, indexed_at FROM indexed_files WHERE duplicate_of <> '' AND "+accessFilter(1)+" AND tenant_id = $2 ORDER BY filename",
+		tagsOrEmpty(allowed), s.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("list duplicates failed: %w", err)
+	}
+	defer dups.Close()
+	for dups.Next() {
+		var f IndexedFile
+		if err := dups.Scan(&f.Filename, &f.Source, &f.Language, &f.Collection, &f.DuplicateOf, &f.IndexedAt); err != nil {
+			return nil, err
+		}
+		results = append(results, f)
+	}
+	return results, dups.Err()
+}
+
+// FileChunks returns the chunks of the indexed file named name, or of the
+// files whose path ends in /name, that the allowed access tags may see, in
+// reading order.
+func (s *PgStore) FileChunks(name string, allowed []string) ([]Document, error) {
+	rows, err := s.pool.Query(context.Background(), `
+		SELECT id, filename, source, content, page, language, collection, start_offset, end_offset, metadata
+		FROM documents WHERE (filename = $1 OR right(filename, length($1) + 1) = '/' || $1)
+		AND tenant_id = $3 AND `+accessFilter(2)+` ORDER BY filename, page, start_offset, id`,
+		name, tagsOrEmpty(allowed), s.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("load file chunks failed: %w", err)
+	}
+	defer rows.Close()
+
+	var results []Document
+	for rows.Next() {
+		var doc Document
+		var md chunkMetadata
+		if err := rows.Scan(&doc.ID, &doc.Filename, &doc.Source, &doc.Content, &doc.Page, &doc.Language, &doc.Collection, &doc.Start, &doc.End, &md); err != nil {
+			return nil, err
+		}
+		doc.Entities, doc.Keywords = md.Entities, md.Keywords
+		results = append(results, doc)
+	}
+	return results, rows.Err()
+}
+
+// FileHash returns the content hash recorded for filename when it was last
+// indexed. ok is false if the file has never been indexed. A file whose
+// chunks were stored by a version that did not record files has an empty
+// hash, so that indexing it again replaces those chunks rather than adds
+// to them.
+func (s *PgStore) FileHash(filename string) (hash string, ok bool, err error) {
+	var recorded *string
+	err = s.pool.QueryRow(context.Background(), `SELECT COALESCE(
+			(SELECT content_hash FROM indexed_files WHERE tenant_id = $1 AND filename = $2),
+			(SELECT '' FROM documents WHERE tenant_id = $1 AND filename = $2 LIMIT 1))`,
+		s.tenant, filename).Scan(&recorded)
+	if err != nil {
+		return "", false, err
+	}
+	if recorded == nil {
+		return "", false, nil
+	}
+	return *recorded, true, nil
+}
+
+// FileRecord is the bookkeeping kept for each indexed file.
+type FileRecord struct {
+	Filename string
+	Source   string
+	Hash     string
+	Language string
+	Size     int64
+	Chunks   int
+	// MinHash is the content signature used to spot near-duplicates.
+	MinHash []uint64
+	// DuplicateOf names the file this one duplicates; its chunks are not stored.
+	DuplicateOf string
+	AccessTags  []string
+	Collection  string
+	// Modified is when the file was last modified; zero when unknown.
+	Modified time.Time
+}
+
+// RecordFile stores the record of a freshly indexed file.
+func (s *PgStore) RecordFile(f FileRecord) error {
+	return recordFile(context.Background(), s.pool, s.tenant, f)
+}
+
+func recordFile(ctx context.Context, db execer, tenant string, f FileRecord) error {
+	_, err := db.Exec(ctx, `
+		INSERT INTO indexed_files (filename, source, content_hash, language, size_bytes, chunks, minhash, duplicate_of, access_tags, collection, tenant_id, modified_at, indexed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, CURRENT_TIMESTAMP)
+		ON CONFLICT (tenant_id, filename) DO UPDATE SET
+			source = EXCLUDED.source,
+			content_hash = EXCLUDED.content_hash,
+			language = EXCLUDED.language,
+			size_bytes = EXCLUDED.size_bytes,
+			chunks = EXCLUDED.chunks,
+			minhash = EXCLUDED.minhash,
+			duplicate_of = EXCLUDED.duplicate_of,
+			access_tags = EXCLUDED.access_tags,
+			collection = EXCLUDED.collection,
+			modified_at = EXCLUDED.modified_at,
+			indexed_at = CURRENT_TIMESTAMP`,
+		f.Filename, f.Source, f.Hash, f.Language, f.Size, f.Chunks, toInt64s(f.MinHash), f.DuplicateOf, tagsOrEmpty(f.AccessTags), collectionOrDefault(f.Collection), tenant, utcOrNil(f.Modified))
+	return err
+}
+
+// SetLabels sets the access tags and collection of an indexed file and its
+// chunks without re-indexing it, along with every file whose name starts
+// with entryPrefix, e.g. the entries of an archive. An empty entryPrefix
+// relabels filename only.
+func (s *PgStore) SetLabels(filename, entryPrefix string, tags []string, collection string) error {
+	defer s.uses.invalidate()
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, table := range []string{"documents", "indexed_files"} {
+		_, err := tx.Exec(ctx, "UPDATE "+table+` SET access_tags = $3, collection = $4
+			WHERE tenant_id = $5 AND (filename = $1 OR ($2 <> '' AND starts_with(filename, $2))) AND (access_tags <> $3 OR collection <> $4)`,
+			filename, entryPrefix, tagsOrEmpty(tags), collectionOrDefault(collection), s.tenant)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// collectionOrDefault maps an empty collection to the default one.
+func collectionOrDefault(c string) string {
+	if c == "" {
+		return "default"
+	}
+	return c
+}
+
+// SetModified records when an indexed file was last modified, for it and
+// its chunks and every file whose name starts with entryPrefix, where no
+// time was recorded yet. Files indexed before modification times were
+// recorded get theirs the next time they are found unchanged.
+func (s *PgStore) SetModified(filename, entryPrefix string, modified time.Time) error {
+	if modified.IsZero() {
+		return nil
+	}
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, table := range []string{"documents", "indexed_files"} {
+		_, err := tx.Exec(ctx, "UPDATE "+table+` SET modified_at = $3
+			WHERE tenant_id = $4 AND (filename = $1 OR ($2 <> '' AND starts_with(filename, $2))) AND modified_at IS NULL`,
+			filename, entryPrefix, modified.UTC(), s.tenant)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// utcOrNil stores a zero time as NULL and any other in UTC, as the
+// timestamp columns hold it.
+func utcOrNil(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+// tagsOrEmpty keeps nil tag lists from being stored as NULL.
+func tagsOrEmpty(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// FileSignature is an indexed file's MinHash signature.
+type FileSignature struct {
+	Filename string
+	MinHash  []uint64
+}
+
+// FileSignatures returns the signatures of every indexed file with exactly
+// the given access tags that is not itself a duplicate. Files with other
+// tags are not candidates, so a duplicate is never hidden from someone who
+// may see it but not its original.
+func (s *PgStore) FileSignatures(tags []string) ([]FileSignature, error) {
+	rows, err := s.pool.Query(context.Background(),
+		"SELECT filename, minhash FROM indexed_files WHERE minhash IS NOT NULL AND duplicate_of = '' AND access_tags = $1 AND tenant_id = $2",
+		tagsOrEmpty(tags), s.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("load signatures failed: %w", err)
+	}
+	defer rows.Close()
+
+	var results []FileSignature
+	for rows.Next() {
+		var name string
+		var sig []int64
+		if err := rows.Scan(&name, &sig); err != nil {
+			return nil, err
+		}
+		results = append(results, FileSignature{Filename: name, MinHash: toUint64s(sig)})
+	}
+	return results, rows.Err()
+}
+
+// Postgres has no unsigned integers, so signatures are stored bit-for-bit
+// as BIGINT.
+func toInt64s(v []uint64) []int64 {
+	if v == nil {
+		return nil
+	}
+	out := make([]int64, len(v))
+	for i, x := range v {
+		out[i] = int64(x)
+	}
+	return out
+}
+
+func toUint64s(v []int64) []uint64 {
+	out := make([]uint64, len(v))
+	for i, x := range v {
+		out[i] = uint64(x)
+	}
+	return out
+}
+
+// DeleteChunks removes every stored chunk of filename.
+func (s *PgStore) DeleteChunks(filename string) error {
+	defer s.uses.invalidate()
+	_, err := s.pool.Exec(context.Background(), "DELETE FROM documents WHERE tenant_id = $1 AND filename = $2", s.tenant, filename)
+	return err
+}
+
+// IndexedFilesWithPrefix returns the names of the recorded files whose name
+// starts with prefix.
+func (s *PgStore) IndexedFilesWithPrefix(prefix string) ([]string, error) {
+	rows, err := s.pool.Query(context.Background(),
+		"SELECT filename FROM indexed_files WHERE tenant_id = $1 AND starts_with(filename, $2) ORDER BY filename", s.tenant, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// DeleteFile removes the chunks, text and index record of filename.
+func (s *PgStore) DeleteFile(filename string) error {
+	defer s.uses.invalidate()
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, table := range []string{"documents", "indexed_files"} {
+		if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE tenant_id = $1 AND filename = $2", s.tenant, filename); err != nil {
+			return err
+		}
+	}
+	hashes, err := deleteRawFiles(ctx, tx, "tenant_id = $1 AND filename = $2", s.tenant, filename)
+	if err != nil {
+		return err
+	}
+	if err := pruneRaw(ctx, tx, hashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// DeleteFilesWithPrefix removes the chunks, texts and index records of
+// every file whose name starts with prefix, e.g. all entries of an archive.
+func (s *PgStore) DeleteFilesWithPrefix(prefix string) error {
+	return s.DeleteFilesWithPrefixExcept(prefix, nil)
+}
+
+// DeleteFilesWithPrefixExcept is DeleteFilesWithPrefix sparing the files
+// named in keep, e.g. the entries an archive still contains.
+func (s *PgStore) DeleteFilesWithPrefixExcept(prefix string, keep []string) error {
+	defer s.uses.invalidate()
+	if keep == nil {
+		// A NULL array would match no file.
+		keep = []string{}
+	}
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	const cond = "tenant_id = $1 AND starts_with(filename, $2) AND filename <> ALL($3)"
+	for _, table := range []string{"documents", "indexed_files"} {
+		if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE "+cond, s.tenant, prefix, keep); err != nil {
+			return err
+		}
+	}
+	hashes, err := deleteRawFiles(ctx, tx, cond, s.tenant, prefix, keep)
+	if err != nil {
+		return err
+	}
+	if err := pruneRaw(ctx, tx, hashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }